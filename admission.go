@@ -0,0 +1,126 @@
+package dumbdb
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueTimeout = errors.New("query waited in the queue for too long")
+	ErrQueueFull    = errors.New("too many queries are waiting in the queue")
+)
+
+// Admission control: up to maxRunning queries run at once, others wait for
+// their turn in FIFO order, so that the buffer pool isn't thrashed under load
+type QueryQueue struct {
+	maxRunning int
+	maxQueued  int           // 0 means no limit
+	timeout    time.Duration // 0 means no limit
+
+	m       sync.Mutex
+	running int
+	waiting list.List // of *queuedQuery, in the order of arrival
+}
+
+type queuedQuery struct {
+	admitted chan struct{} // closed once the query gets a slot
+}
+
+// Queue running up to maxRunning queries at once, with up to maxQueued of them
+// waiting up to timeout for a slot (0 means no limit for both)
+func NewQueryQueue(maxRunning int, maxQueued int, timeout time.Duration) *QueryQueue {
+	return &QueryQueue{
+		maxRunning: maxRunning,
+		maxQueued:  maxQueued,
+		timeout:    timeout,
+	}
+}
+
+// Wait until the query can run, release() has to be called once it's done.
+// Fails if the queue is full, the query waits longer than the timeout or ctx is done
+func (q *QueryQueue) Admit(ctx context.Context) (release func(), err error) {
+	q.m.Lock()
+	if q.running < q.maxRunning && q.waiting.Len() == 0 {
+		q.running++
+		q.m.Unlock()
+		DefaultMetrics.RunningQueries.Add(1)
+		return q.release, nil
+	}
+
+	if q.maxQueued != 0 && q.waiting.Len() >= q.maxQueued {
+		q.m.Unlock()
+		DefaultMetrics.QueueRejections.Add(1)
+		return nil, fmt.Errorf("%w: %v are running and %v are waiting", ErrQueueFull, q.running, q.waiting.Len())
+	}
+
+	query := &queuedQuery{admitted: make(chan struct{})}
+	elem := q.waiting.PushBack(query)
+	q.m.Unlock()
+
+	DefaultMetrics.QueuedQueries.Add(1)
+	defer DefaultMetrics.QueuedQueries.Add(-1)
+
+	start := time.Now()
+	var timeout <-chan time.Time
+	if q.timeout != 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-query.admitted:
+	case <-timeout:
+		err = fmt.Errorf("%w: no slot in %v", ErrQueueTimeout, q.timeout)
+	case <-ctx.Done():
+		err = CancellationError(ctx)
+	}
+
+	if err != nil {
+		q.m.Lock()
+		select {
+		case <-query.admitted:
+			// the slot was given to the query concurrently, so it has to be passed on
+			q.m.Unlock()
+			q.release()
+		default:
+			q.waiting.Remove(elem)
+			q.m.Unlock()
+		}
+
+		if errors.Is(err, ErrQueueTimeout) {
+			DefaultMetrics.QueueTimeouts.Add(1)
+		}
+		return nil, err
+	}
+
+	DefaultMetrics.ObserveQueueWait(time.Since(start))
+	return q.release, nil
+}
+
+// Pass the slot to the first waiting query, if any
+func (q *QueryQueue) release() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	front := q.waiting.Front()
+	if front == nil {
+		q.running--
+		DefaultMetrics.RunningQueries.Add(-1)
+		return
+	}
+
+	q.waiting.Remove(front)
+	close(front.Value.(*queuedQuery).admitted)
+}
+
+// Number of running and waiting queries
+func (q *QueryQueue) Len() (running int, waiting int) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.running, q.waiting.Len()
+}
@@ -0,0 +1,91 @@
+package dumbdb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueryQueue(t *testing.T) {
+	queue := NewQueryQueue(2, 3, time.Second)
+	ctx := context.Background()
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := queue.Admit(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+
+	// waiting queries are admitted in the order of arrival
+	order := make(chan int, 3)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			release, err := queue.Admit(ctx)
+			if err != nil {
+				t.Error(err)
+				order <- -1
+				return
+			}
+			order <- i
+			release()
+		}(i)
+
+		for {
+			if _, waiting := queue.Len(); waiting == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	_, err := queue.Admit(ctx)
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+
+	releases[0]()
+	for i := 0; i < 3; i++ {
+		if got := <-order; got != i {
+			t.Fatalf("Expected query %v to be admitted, got %v", i, got)
+		}
+	}
+	wg.Wait()
+
+	if running, waiting := queue.Len(); running != 1 || waiting != 0 {
+		t.Fatalf("Expected 1 running query, got %v running and %v waiting", running, waiting)
+	}
+
+	// the only slot is taken
+	short := NewQueryQueue(1, 0, 10*time.Millisecond)
+	release, err := short.Admit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = short.Admit(ctx)
+	if !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("Expected ErrQueueTimeout, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = short.Admit(cancelled)
+	if !errors.Is(err, ErrQueryCancelled) {
+		t.Fatalf("Expected ErrQueryCancelled, got %v", err)
+	}
+
+	release()
+	if running, waiting := short.Len(); running != 0 || waiting != 0 {
+		t.Fatalf("Expected empty queue, got %v running and %v waiting", running, waiting)
+	}
+	releases[1]()
+}
@@ -0,0 +1,494 @@
+package dumbdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrTableBeingAltered = errors.New("table is being altered")
+
+// Rows inserted into a table while it's being altered, they are copied into
+// the new table after the rows which existed when the alter started.
+//
+// The rows are kept only in memory, the journal records only the swap of the
+// tables. Nothing is lost if the alter is interrupted before the swap, as the
+// rows are synced to the old table by the inserts, and the new table is
+// removed once the catalog is opened. The swap is journaled only once all the
+// captured rows are copied and the new table is synced
+type rowCapture struct {
+	m    sync.Mutex
+	rows []Row
+}
+
+func (capture *rowCapture) add(rows []Row) {
+	capture.m.Lock()
+	defer capture.m.Unlock()
+	capture.rows = append(capture.rows, rows...)
+}
+
+// Returns the rows captured so far, and starts capturing anew
+func (capture *rowCapture) take() []Row {
+	capture.m.Lock()
+	defer capture.m.Unlock()
+	rows := capture.rows
+	capture.rows = nil
+	return rows
+}
+
+// Path of the new table built by alter table or vacuum, its files are renamed
+// over the files of the table once all the rows are copied
+func shadowPath(tablePath string) string {
+	return tablePath + ".alter"
+}
+
+// Alter table in progress, see Catalog.doAlter()
+type tableAlter struct {
+	name   string
+	table  *Table
+	shadow *Table
+
+	// rows of the new table from the row of the old one
+	convert func(Row) Row
+
+	// pages of the old table and their numbers of rows when the alter started,
+	// rows inserted later are captured
+	pages []PageID
+	rows  []int
+}
+
+// Schema and options of the table once the column is added or dropped
+func alteredSchema(table *Table, alter *Alter) (Schema, TableOptions, func(Row) Row, error) {
+	opts := table.options
+	var schema Schema
+	var columns []int // column of the old row for each column of the new one, -1 for a new column
+
+	if add := alter.AddColumn; add != nil {
+		field := NewSchema([]FieldDescription{*add}).Fields[0]
+		if field.AutoIncrement {
+			return schema, opts, nil, errors.New("auto_increment column can't be added to existing table")
+		}
+		if idx, _ := table.schema.GetField(field.Name); idx != -1 {
+			return schema, opts, nil, fmt.Errorf("column %v already exists", field.Name)
+		}
+
+		for i, old := range table.schema.Fields {
+			schema.addField(old)
+			columns = append(columns, i)
+		}
+		schema.addField(field)
+		columns = append(columns, -1)
+	} else {
+		name := alter.DropColumn
+		idx, _ := table.schema.GetField(name)
+		if idx == -1 {
+			return schema, opts, nil, fmt.Errorf("no column named %v", name)
+		}
+		if len(table.schema.Fields) == 1 {
+			return schema, opts, nil, errors.New("the only column of the table can't be dropped")
+		}
+		if idx == table.ttlColumn {
+			return schema, opts, nil, fmt.Errorf("column %v is the ttl column of the table", name)
+		}
+		for _, index := range table.indexes {
+			for _, column := range index.columns {
+				if column == name {
+					return schema, opts, nil, fmt.Errorf("column %v is used by index %v, drop the index first", name, index.name)
+				}
+			}
+		}
+
+		for i, old := range table.schema.Fields {
+			if i != idx {
+				schema.addField(old)
+				columns = append(columns, i)
+			}
+		}
+		opts.BloomFilter = withoutColumn(opts.BloomFilter, name)
+		opts.Dictionary = withoutColumn(opts.Dictionary, name)
+	}
+
+	err := schema.Validate()
+	if err != nil {
+		return schema, opts, nil, err
+	}
+
+	convert := func(row Row) Row {
+		converted := make(Row, len(columns))
+		for i, idx := range columns {
+			if idx == -1 {
+				converted[i] = Value{TypeID: schema.Fields[i].TypeID}
+			} else {
+				converted[i] = row[idx]
+			}
+		}
+		return converted
+	}
+	return schema, opts, convert, nil
+}
+
+func withoutColumn(columns []string, name string) []string {
+	var result []string
+	for _, column := range columns {
+		if column != name {
+			result = append(result, column)
+		}
+	}
+	return result
+}
+
+// Change columns of the table without blocking it. The new table is created
+// next to the old one, and the rows are copied while the catalog is unlocked,
+// rows inserted meanwhile are captured by the old table and copied after them.
+// Finally the catalog is locked for the rest of the captured rows, and the
+// files of the new table replace the old ones
+func (catalog *Catalog) doAlter(ctx context.Context, q *Alter) (*Result, error) {
+	alter, err := catalog.beginAlter(q)
+	if err != nil {
+		return nil, err
+	}
+
+	err = alter.backfill(ctx)
+	if err == nil {
+		err = alter.catchUp(ctx)
+	}
+
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	// the catalog is locked, so nothing is inserted until the tables are swapped
+	captured := alter.table.capture.take()
+	alter.table.capture = nil
+
+	if err == nil {
+		err = alter.copyRows(captured)
+	}
+	if err != nil {
+		alter.discard()
+		return nil, err
+	}
+	return nil, catalog.swapTables(alter)
+}
+
+// Create the new table and start capturing inserts into the old one
+func (catalog *Catalog) beginAlter(q *Alter) (*tableAlter, error) {
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	table, ok := catalog.tables[q.Table]
+	if !ok {
+		return nil, catalog.tableError(q.Table, ErrNoSuchTable)
+	}
+	if table.capture != nil {
+		return nil, ErrTableBeingAltered
+	}
+
+	schema, opts, convert, err := alteredSchema(table, q)
+	if err != nil {
+		return nil, err
+	}
+
+	alter, err := catalog.newShadow(q.Table, table, schema, opts, convert)
+	if err != nil {
+		return nil, err
+	}
+
+	table.capture = &rowCapture{}
+	return alter, nil
+}
+
+// Create the new table of the alter with the indexes of the table, and
+// remember the pages of the table to be copied. catalog.m should be locked
+func (catalog *Catalog) newShadow(name string, table *Table, schema Schema, opts TableOptions, convert func(Row) Row) (*tableAlter, error) {
+	// leftovers of an alter interrupted by a crash are removed when the catalog is opened
+	path := shadowPath(filepath.Join(catalog.dataDir, name))
+	shadow, err := NewTable(path, schema, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	alter := &tableAlter{name: name, table: table, shadow: shadow, convert: convert}
+	for _, index := range table.indexes {
+		_, err = shadow.CreateIndex(indexPath(path, index.name), index.name, index.columns)
+		if err != nil {
+			alter.discard()
+			return nil, fmt.Errorf("index %v: %w", index.name, err)
+		}
+	}
+
+	for id := table.firstPage(); id != InvalidPageID; id = table.nextPage(id) {
+		// the first column of a row group has its number of rows
+		lockedPage, release, err := table.lockRows(id, false, make([]bool, len(table.schema.Fields)))
+		if err != nil {
+			alter.discard()
+			return nil, err
+		}
+
+		alter.pages = append(alter.pages, id)
+		alter.rows = append(alter.rows, lockedPage.NumRows())
+		release()
+	}
+	return alter, nil
+}
+
+func (alter *tableAlter) copyRows(rows []Row) error {
+	for len(rows) != 0 {
+		n := len(rows)
+		if n > CopyBatchSize {
+			n = CopyBatchSize
+		}
+
+		batch := make([]Row, 0, n)
+		for _, row := range rows[:n] {
+			batch = append(batch, alter.convert(row))
+		}
+
+		err := alter.shadow.Insert(batch)
+		if err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+// Copy the rows which existed when the alter started
+func (alter *tableAlter) backfill(ctx context.Context) error {
+	for i, id := range alter.pages {
+		err := CancellationError(ctx)
+		if err != nil {
+			return err
+		}
+
+		// rows are appended to the pages, so the ones inserted later are skipped
+		var rows []Row
+		err = alter.table.ScanPage(id, nil, func(row Row) error {
+			if len(rows) < alter.rows[i] {
+				rows = append(rows, row)
+			}
+			return nil
+		})
+		if err == nil {
+			err = alter.copyRows(rows)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy the rows captured so far, until few enough are left to be copied
+// while the catalog is locked
+func (alter *tableAlter) catchUp(ctx context.Context) error {
+	for {
+		err := CancellationError(ctx)
+		if err != nil {
+			return err
+		}
+
+		rows := alter.table.capture.take()
+		err = alter.copyRows(rows)
+		if err != nil || len(rows) < CopyBatchSize {
+			return err
+		}
+	}
+}
+
+// Close the new table and remove its files
+func (alter *tableAlter) discard() {
+	alter.shadow.Close()
+	if alter.shadow.options.Engine == EngineMemory {
+		return
+	}
+
+	os.Remove(alter.shadow.storage.Name())
+	if alter.shadow.dict != nil {
+		os.Remove(alter.shadow.dict.path)
+	}
+	for _, index := range alter.shadow.indexes {
+		os.Remove(index.path)
+	}
+}
+
+// Replace the old table with the new one, catalog.m should be locked
+func (catalog *Catalog) swapTables(alter *tableAlter) error {
+	old, shadow := alter.table, alter.shadow
+	shadow.autoIncrement = old.lastAutoIncrement()
+
+	table := shadow
+	if old.options.Engine != EngineMemory {
+		// files of the new table are closed before they are renamed
+		meta := tableMetadata{
+			Schema:        shadow.schema,
+			TableOptions:  shadow.options,
+			AutoIncrement: shadow.autoIncrement,
+			Indexes:       shadow.indexMetadata(),
+		}
+		rowCount := shadow.RowCount()
+
+		err := shadow.Close()
+		if err != nil {
+			alter.discard()
+			return err
+		}
+
+		err = catalog.beginDDL(ddlOp{Op: ddlAlterTable, Table: alter.name, Meta: &meta})
+		if err != nil {
+			alter.discard()
+			return err
+		}
+
+		// from now on recovery finishes the alter if it's interrupted,
+		// scans of the old table still read its renamed-over files
+		err = catalog.renameShadowFiles(alter.name, &meta)
+		if err != nil {
+			return err
+		}
+
+		table, err = catalog.openTable(alter.name, meta)
+		if err != nil {
+			return err
+		}
+		table.rowCount = rowCount
+	}
+
+	catalog.tables[alter.name] = table
+	err := catalog.saveMetadata()
+	if err != nil {
+		return err
+	}
+
+	// selects release the catalog lock before their rows are read, so the
+	// old table is closed once they finish
+	go func() {
+		old.scansWG.Wait()
+		old.Close()
+	}()
+
+	// statistics describe the old columns
+	_, hasStats := catalog.stats[alter.name]
+	if hasStats {
+		delete(catalog.stats, alter.name)
+		err = catalog.saveStatistics()
+		if err != nil {
+			return err
+		}
+	}
+
+	if old.options.Engine == EngineMemory {
+		return nil
+	}
+	return catalog.commitDDL()
+}
+
+// Open the table and its indexes, which were closed cleanly
+func (catalog *Catalog) openTable(name string, meta tableMetadata) (*Table, error) {
+	meta.TableOptions.Storage = catalog.storage
+	if meta.Encryption != EncryptionNone {
+		meta.TableOptions.EncryptionKey = catalog.encryptionKey
+	}
+	table, err := OpenTable(filepath.Join(catalog.dataDir, name), meta.Schema, meta.TableOptions)
+	if err != nil {
+		return nil, err
+	}
+	table.autoIncrement = meta.AutoIncrement
+
+	for _, index := range meta.Indexes {
+		_, err = table.OpenIndex(catalog.indexPath(name, index.Name), index, false)
+		if err != nil {
+			table.Close()
+			return nil, fmt.Errorf("index %v: %w", index.Name, err)
+		}
+	}
+	return table, nil
+}
+
+// Files of the new table of an alter and the files of the table they replace,
+// the table file goes last, so that it's renamed only once the others are
+func (catalog *Catalog) shadowFiles(name string, meta *tableMetadata) [][2]string {
+	path := filepath.Join(catalog.dataDir, name)
+	shadow := shadowPath(path)
+
+	var files [][2]string
+	if len(meta.Dictionary) != 0 {
+		files = append(files, [2]string{shadow + DictionaryFileExtension, path + DictionaryFileExtension})
+	}
+	for _, index := range meta.Indexes {
+		files = append(files, [2]string{indexPath(shadow, index.Name), indexPath(path, index.Name)})
+	}
+	return append(files, [2]string{shadow + ".bin", path + ".bin"})
+}
+
+// Rename files of the new table of an alter over the files of the table,
+// files which were renamed already are skipped
+func (catalog *Catalog) renameShadowFiles(name string, meta *tableMetadata) error {
+	for _, file := range catalog.shadowFiles(name, meta) {
+		err := os.Rename(file[0], file[1])
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// a dropped dictionary column may have been the last one
+	if len(meta.Dictionary) == 0 {
+		err := os.Remove(filepath.Join(catalog.dataDir, name) + DictionaryFileExtension)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return syncDir(catalog.dataDir)
+}
+
+// Finish alter of the table interrupted after its journal was written,
+// metadata is fixed in place. Returns true if it was changed
+func (catalog *Catalog) recoverAlter(op ddlOp, metadata map[string]tableMetadata) (bool, error) {
+	if op.Meta == nil {
+		return false, nil
+	}
+
+	if !catalog.readOnly {
+		err := catalog.renameShadowFiles(op.Table, op.Meta)
+		if err != nil {
+			return false, err
+		}
+
+		metadata[op.Table] = *op.Meta
+		return true, nil
+	}
+
+	// files can't be renamed, so the table is opened as it was unless they
+	// were renamed already
+	files := catalog.shadowFiles(op.Table, op.Meta)
+	renamed := 0
+	for _, file := range files {
+		if _, err := os.Stat(file[0]); os.IsNotExist(err) {
+			renamed++
+		}
+	}
+
+	switch renamed {
+	case 0:
+		return false, nil
+	case len(files):
+		metadata[op.Table] = *op.Meta
+		return true, nil
+	default:
+		return false, fmt.Errorf("alter of table %v was interrupted, the database has to be opened for writing to finish it", op.Table)
+	}
+}
+
+// Remove files of the new table of an alter interrupted before its journal
+// was written
+func (catalog *Catalog) removeShadowFiles(name string, meta *tableMetadata) error {
+	for _, file := range catalog.shadowFiles(name, meta) {
+		err := os.Remove(file[0])
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
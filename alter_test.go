@@ -0,0 +1,264 @@
+package dumbdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAlterTable(t *testing.T) {
+	for _, engine := range []string{"disk", EngineColumnar, EngineMemory} {
+		t.Run(engine, func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := NewDatabase(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { db.Close() }()
+
+			mustExec(t, db, nil, fmt.Sprintf("create table users (id int, name varchar(20), city varchar(10)) engine = %v dictionary = (city)", engine))
+
+			values := ""
+			for i := 0; i < 3000; i++ {
+				if i != 0 {
+					values += ", "
+				}
+				values += fmt.Sprintf("(%v, \"user%v\", \"city%v\")", i, i, i%7)
+			}
+			for _, q := range []string{"insert into users values " + values, "create index users_id on users (id)"} {
+				mustExec(t, db, nil, q)
+			}
+
+			// rows are inserted while the table is altered, until the column is added
+			inserted := 3000
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for ; ; inserted++ {
+					_, err := execQuery(t, db, nil, fmt.Sprintf("insert into users (id, name, city) values (%v, \"user%v\", \"city%v\")", inserted, inserted, inserted%7))
+					if err != nil {
+						return
+					}
+				}
+			}()
+
+			_, err = execQuery(t, db, nil, "alter table users add column age int")
+			<-done
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := queryRows(t, db, nil, "select id, name, city, age from users")
+			if err != nil || len(rows) != inserted {
+				t.Fatalf("Expected %v rows, got %v (%v)", inserted, len(rows), err)
+			}
+			for _, row := range rows {
+				if row[1].StrVal() != fmt.Sprintf("user%v", row[0].Int) || row[2].StrVal() != fmt.Sprintf("city%v", row[0].Int%7) || row[3].Int != 0 {
+					t.Fatalf("Unexpected row %v", row)
+				}
+			}
+
+			for _, q := range []string{fmt.Sprintf("insert into users values (%v, \"user\", \"city0\", 42)", inserted), "alter table users drop column city"} {
+				mustExec(t, db, nil, q)
+			}
+
+			for _, q := range []string{"alter table users drop column id", "alter table users add column age int", "alter table users drop column missing"} {
+				_, err = execQuery(t, db, nil, q)
+				if err == nil {
+					t.Fatalf("Expected %v to fail", q)
+				}
+			}
+
+			if engine == EngineMemory {
+				return
+			}
+
+			// the altered table is persisted
+			err = db.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db, err = NewDatabase(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err = queryRows(t, db, nil, fmt.Sprintf("select * from users where id = %v", inserted))
+			if err != nil || len(rows) != 1 || len(rows[0]) != 3 || rows[0][1].StrVal() != "user" || rows[0][2].Int != 42 {
+				t.Fatalf("Expected row %v, got %v (%v)", inserted, rows, err)
+			}
+
+			table, err := db.Table("users")
+			if err != nil || table.RowCount() != int64(inserted+1) || table.dict != nil {
+				t.Fatalf("Expected %v rows without dictionary, got %v", inserted+1, err)
+			}
+
+			files, err := filepath.Glob(filepath.Join(dir, "users*"))
+			if err != nil || len(files) != 2 {
+				t.Fatalf("Expected the table and index files, got %v (%v)", files, err)
+			}
+		})
+	}
+}
+
+func TestAlterTableRecovery(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{"create table users (id int, name varchar(20))", "insert into users values (1, \"foo\")", "alter table users add column age int"} {
+		mustExec(t, db, nil, q)
+	}
+
+	catalog, err := db.catalog(DefaultDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the alter was interrupted once its journal was written, so the table
+	// file is still at the path of the new table
+	path := filepath.Join(dir, "users")
+	err = os.Rename(path+".bin", shadowPath(path)+".bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := catalog.tables["users"]
+	meta := tableMetadata{Schema: table.schema, TableOptions: table.options}
+	err = catalog.beginDDL(ddlOp{Op: ddlAlterTable, Table: "users", Meta: &meta})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err = db.Table("users")
+	if err != nil || len(table.schema.Fields) != 3 {
+		t.Fatalf("Expected the altered table, got %v", err)
+	}
+
+	n := 0
+	err = table.Scan(func(row Row) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 row, got %v (%v)", n, err)
+	}
+
+	// leftover of an alter interrupted before its journal was written
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(shadowPath(path)+".bin", nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, file := range []string{shadowPath(path) + ".bin", filepath.Join(dir, JournalFilename)} {
+		_, err = os.Stat(file)
+		if !os.IsNotExist(err) {
+			t.Fatalf("Expected %v to be removed, got %v", file, err)
+		}
+	}
+
+	// the table can't be modified while it's altered
+	catalog, err = db.catalog(DefaultDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog.tables["users"].capture = &rowCapture{}
+	defer func() { catalog.tables["users"].capture = nil }()
+
+	for _, q := range []string{"vacuum users", "truncate table users", "drop table users", "alter table users drop column age"} {
+		_, err = execQuery(t, db, nil, q)
+		if !errors.Is(err, ErrTableBeingAltered) {
+			t.Fatalf("Expected ErrTableBeingAltered for %v, got %v", q, err)
+		}
+	}
+}
+
+func TestAlterTableInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mustExec(t, db, nil, "create table users (id int, name varchar(20))")
+	mustExec(t, db, nil, "insert into users values (1, \"foo\"), (2, \"bar\")")
+
+	catalog, err := db.catalog(DefaultDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alter, err := catalog.beginAlter(&Alter{Table: "users", AddColumn: &FieldDescription{Name: "age", Type: &Type{Integer: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = alter.backfill(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the row is captured, but the alter is interrupted before it's copied
+	mustExec(t, db, nil, "insert into users values (3, \"baz\")")
+	err = alter.shadow.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alter.table.capture = nil
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the old table is kept with all its rows
+	table, err := db.Table("users")
+	if err != nil || len(table.schema.Fields) != 2 {
+		t.Fatalf("Expected the table before the alter, got %v", err)
+	}
+
+	var ids []int64
+	err = table.Scan(func(row Row) error {
+		ids = append(ids, row[0].Int)
+		return nil
+	})
+	if err != nil || len(ids) != 3 || ids[2] != 3 {
+		t.Fatalf("Expected 3 rows, got %v (%v)", ids, err)
+	}
+
+	path := shadowPath(filepath.Join(dir, "users"))
+	_, err = os.Stat(path + ".bin")
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected the new table to be removed, got %v", err)
+	}
+}
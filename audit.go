@@ -0,0 +1,169 @@
+package dumbdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry of the audit log, written for each statement modifying the database
+type AuditEntry struct {
+	Time         time.Time `json:"time"`
+	User         string    `json:"user,omitempty"` // empty for sessions without a user
+	Client       string    `json:"client,omitempty"`
+	Database     string    `json:"database"`
+	Statement    string    `json:"statement"`
+	RowsAffected int64     `json:"rows_affected"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Append-only log of the statements modifying the database (DDL and DML), an
+// entry in JSON per line. Once the file exceeds maxSize, it's renamed to
+// path.1, the older ones to path.2 and so on, up to maxFiles of them are kept
+type AuditLog struct {
+	path     string
+	maxSize  int64 // 0 means no rotation
+	maxFiles int
+
+	m      sync.Mutex
+	file   *os.File // nil if rotation failed, it's reopened by the next write
+	size   int64
+	closed bool
+}
+
+// Open the audit log at path for appending
+func NewAuditLog(path string, maxSize int64, maxFiles int) (*AuditLog, error) {
+	log := &AuditLog{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	err := log.open()
+	if err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+func (log *AuditLog) open() error {
+	file, err := os.OpenFile(log.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	log.file = file
+	log.size = info.Size()
+	return nil
+}
+
+func rotatedPath(path string, n int) string {
+	return fmt.Sprintf("%v.%v", path, n)
+}
+
+// Rename the current file to path.1, shifting the older ones
+// log.m should be locked
+func (log *AuditLog) rotate() error {
+	err := log.file.Close()
+	log.file = nil
+	if err != nil {
+		return err
+	}
+
+	if log.maxFiles == 0 {
+		err = os.Remove(log.path)
+	} else {
+		err = os.Remove(rotatedPath(log.path, log.maxFiles))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		for n := log.maxFiles - 1; n > 0; n-- {
+			err = os.Rename(rotatedPath(log.path, n), rotatedPath(log.path, n+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		err = os.Rename(log.path, rotatedPath(log.path, 1))
+	}
+
+	if err != nil {
+		return err
+	}
+	return log.open()
+}
+
+func (log *AuditLog) Write(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	log.m.Lock()
+	defer log.m.Unlock()
+
+	if log.closed {
+		return os.ErrClosed
+	}
+
+	if log.file == nil {
+		err = log.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	if log.maxSize != 0 && log.size != 0 && log.size+int64(len(data)) > log.maxSize {
+		err = log.rotate()
+		if err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+
+	n, err := log.file.Write(data)
+	log.size += int64(n)
+	return err
+}
+
+func (log *AuditLog) Close() error {
+	log.m.Lock()
+	defer log.m.Unlock()
+
+	log.closed = true
+	if log.file == nil {
+		return nil
+	}
+
+	err := log.file.Close()
+	log.file = nil
+	return err
+}
+
+// Write the statement modifying the database to the audit log,
+// database is the current one before the statement was executed
+func (db *Database) auditQuery(sess *Session, database string, query *Query, result *Result, err error) {
+	entry := &AuditEntry{
+		Time:      time.Now(),
+		User:      sess.user(),
+		Database:  database,
+		Statement: query.Text,
+	}
+	if sess != nil {
+		entry.Client = sess.Client
+	}
+	if result != nil {
+		entry.RowsAffected = result.RowsAffected
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	// the statement is executed already, so failure to audit it doesn't fail it
+	if db.audit.Write(entry) != nil {
+		DefaultMetrics.AuditErrors.Add(1)
+	}
+}
@@ -0,0 +1,122 @@
+package dumbdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readAuditLog(t *testing.T, path string) []AuditEntry {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := NewAuditLog(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+
+	db, err := OpenDatabase(Options{DataDir: t.TempDir(), AuditLog: audit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sess := &Session{User: "alice", Client: "127.0.0.1:1234"}
+	queries := []string{
+		"create table users (id int, name varchar(20))",
+		"select * from users",
+		"insert into users values (1, \"foo\"), (2, \"bar\")",
+		"insert into missing values (1)",
+		"show tables",
+		"truncate table users",
+	}
+	for _, q := range queries {
+		execQuery(t, db, &Session{}, q)
+		if q == queries[0] {
+			// the rest is executed by the user
+			continue
+		}
+		execQuery(t, db, sess, q)
+	}
+
+	expected := []struct {
+		user      string
+		statement string
+		rows      int64
+		failed    bool
+	}{
+		{"", queries[0], 0, false},
+		{"", queries[2], 2, false},
+		{"alice", queries[2], 0, true},
+		{"", queries[3], 0, true},
+		{"alice", queries[3], 0, true},
+		{"", queries[5], 2, false},
+		{"alice", queries[5], 0, true},
+	}
+
+	entries := readAuditLog(t, path)
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %v entries, got %v", len(expected), entries)
+	}
+
+	for i, entry := range entries {
+		e := expected[i]
+		if entry.User != e.user || entry.Statement != e.statement || entry.RowsAffected != e.rows || (entry.Error != "") != e.failed || entry.Database != DefaultDatabase {
+			t.Fatalf("Expected %+v, got %+v", e, entry)
+		}
+	}
+
+	if entries[2].Client != sess.Client {
+		t.Fatalf("Expected client %v, got %v", sess.Client, entries[2].Client)
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := NewAuditLog(path, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+
+	for i := 0; i < 20; i++ {
+		err = audit.Write(&AuditEntry{Database: DefaultDatabase, Statement: "insert into t values (1)", RowsAffected: int64(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each file fits one entry, the last three are kept
+	for n, file := range []string{path, rotatedPath(path, 1), rotatedPath(path, 2)} {
+		entries := readAuditLog(t, file)
+		if len(entries) != 1 || entries[0].RowsAffected != int64(19-n) {
+			t.Fatalf("Unexpected entries of %v: %v", file, entries)
+		}
+	}
+
+	_, err = os.Stat(rotatedPath(path, 3))
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected only 2 rotated files to be kept, got %v", err)
+	}
+}
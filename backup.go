@@ -0,0 +1,307 @@
+package dumbdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrDataDirNotEmpty = errors.New("data directory is not empty")
+
+func writeTarFile(tw *tar.Writer, name string, size int64, modTime time.Time, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0600,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+// Write catalog files into the archive, names are prefixed with dir
+// catalog.m should be locked, so no tables are modified
+func (catalog *Catalog) backup(tw *tar.Writer, dir string, modTime time.Time) error {
+	if dir != "" {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     dir + "/",
+			Mode:     0700,
+			ModTime:  modTime,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// pages are copied as is, so the backup has the format of the catalog
+	version := formatVersionData()
+	err := writeTarFile(tw, path.Join(dir, VersionFilename), int64(len(version)), modTime, bytes.NewReader(version))
+	if err != nil {
+		return err
+	}
+
+	metadata, err := catalog.metadata(true)
+	if err != nil {
+		return err
+	}
+
+	err = writeTarFile(tw, path.Join(dir, MetadataFilename), int64(len(metadata)), modTime, bytes.NewReader(metadata))
+	if err != nil {
+		return err
+	}
+
+	if len(catalog.stats) != 0 {
+		stats, err := json.Marshal(catalog.stats)
+		if err != nil {
+			return err
+		}
+
+		err = writeTarFile(tw, path.Join(dir, StatisticsFilename), int64(len(stats)), modTime, bytes.NewReader(stats))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(catalog.views) != 0 {
+		views, err := catalog.viewDefinitions()
+		if err != nil {
+			return err
+		}
+
+		err = writeTarFile(tw, path.Join(dir, ViewsFilename), int64(len(views)), modTime, bytes.NewReader(views))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(catalog.grants) != 0 {
+		grants, err := catalog.grantDefinitions()
+		if err != nil {
+			return err
+		}
+
+		err = writeTarFile(tw, path.Join(dir, GrantsFilename), int64(len(grants)), modTime, bytes.NewReader(grants))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(catalog.triggers) != 0 {
+		triggers, err := catalog.triggerDefinitions()
+		if err != nil {
+			return err
+		}
+
+		err = writeTarFile(tw, path.Join(dir, TriggersFilename), int64(len(triggers)), modTime, bytes.NewReader(triggers))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(catalog.procedures) != 0 {
+		procedures, err := catalog.procedureDefinitions()
+		if err != nil {
+			return err
+		}
+
+		err = writeTarFile(tw, path.Join(dir, ProceduresFilename), int64(len(procedures)), modTime, bytes.NewReader(procedures))
+		if err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(catalog.tables))
+	for name := range catalog.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		table := catalog.tables[name]
+		if table.options.Engine == EngineMemory {
+			// contents of memory tables are lost on restart anyway
+			continue
+		}
+
+		// index files are left out, indexes are rebuilt once the restored catalog
+		// is opened and their files are missing
+
+		snapshot, err := table.pager.Snapshot()
+		if err != nil {
+			return err
+		}
+
+		err = writeTarFile(tw, path.Join(dir, name+".bin"), snapshot.Size(), modTime, snapshot)
+		if err != nil {
+			return fmt.Errorf("failed to backup table %v: %w", name, err)
+		}
+
+		if table.dict != nil {
+			dict := table.dict.snapshot()
+			err = writeTarFile(tw, path.Join(dir, name+DictionaryFileExtension), int64(len(dict)), modTime, bytes.NewReader(dict))
+			if err != nil {
+				return fmt.Errorf("failed to backup dictionary of %v: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Write consistent snapshot of all the databases to w as a tar archive
+// Writes are blocked while backup is running, reads are not
+func (db *Database) Backup(w io.Writer) error {
+	db.m.RLock()
+	defer db.m.RUnlock()
+
+	names := make([]string, 0, len(db.catalogs))
+	for name := range db.catalogs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// all the catalogs are locked at once, so snapshot is consistent across databases
+	for _, name := range names {
+		catalog := db.catalogs[name]
+		catalog.m.Lock()
+		defer catalog.m.Unlock()
+	}
+
+	modTime := time.Now()
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		dir := name
+		if name == DefaultDatabase {
+			dir = ""
+		}
+
+		err := db.catalogs[name].backup(tw, dir, modTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func (db *Database) doBackup(backup *Backup) (*Result, error) {
+	file, err := os.OpenFile(backup.Filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	err = db.Backup(file)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if err == nil {
+		err = file.Close()
+	}
+
+	if err != nil {
+		os.Remove(backup.Filename)
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Resolve archive entry name inside dataDir, rejecting names escaping it
+func restorePath(dataDir string, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid file name in backup: %q", name)
+	}
+
+	return filepath.Join(dataDir, clean), nil
+}
+
+// Extract backup made by Database.Backup() into dataDir, which has to be empty or not exist
+func Restore(r io.Reader, dataDir string) error {
+	err := os.MkdirAll(dataDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.Open(dataDir)
+	if err != nil {
+		return err
+	}
+
+	_, err = dir.Readdirnames(1)
+	dir.Close()
+	if err == nil {
+		return ErrDataDirNotEmpty
+	}
+
+	if !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		filename, err := restorePath(dataDir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(filename, 0700)
+		case tar.TypeReg:
+			err = restoreFile(filename, tr)
+		default:
+			err = fmt.Errorf("unexpected entry in backup: %q", header.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func restoreFile(filename string, r io.Reader) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, r)
+	if err != nil {
+		return err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
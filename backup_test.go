@@ -0,0 +1,97 @@
+package dumbdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sess := &Session{}
+	queries := []string{
+		"create table users (id int, name varchar(16))",
+		"insert into users values (1, \"foo\"), (2, \"bar\")",
+		"create table visits (id int, country varchar(40)) dictionary = (country)",
+		"insert into visits values (1, \"nl\"), (2, \"de\"), (3, \"nl\")",
+		"grant select on users to alice",
+		"create database other",
+		"use other",
+		"create table items (id int) compression = flate",
+		"insert into items values (42)",
+	}
+	for _, q := range queries {
+		mustExec(t, db, sess, q)
+	}
+
+	var backup bytes.Buffer
+	err = db.Backup(&backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	err = Restore(&backup, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	count := func(database string, table string) int {
+		catalog, err := restored.catalog(database)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := 0
+		err = catalog.tables[table].Scan(func(row Row) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if n := count(DefaultDatabase, "users"); n != 2 {
+		t.Fatalf("Expected 2 rows in users, got %v", n)
+	}
+
+	if n := count("other", "items"); n != 1 {
+		t.Fatalf("Expected 1 row in items, got %v", n)
+	}
+
+	// values of the dictionary columns are restored with the table
+	if n := count(DefaultDatabase, "visits"); n != 3 {
+		t.Fatalf("Expected 3 rows in visits, got %v", n)
+	}
+
+	catalog, err := restored.catalog(DefaultDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := catalog.tables["visits"].FetchRow(NewRowID(0, 2))
+	if err != nil || row[1].StrVal() != "nl" {
+		t.Fatalf("Unexpected row %v (%v)", row, err)
+	}
+
+	if privs := catalog.privileges("alice", "users"); privs != PrivSelect {
+		t.Fatalf("Expected select privilege to be restored, got %v", privs)
+	}
+
+	err = Restore(&bytes.Buffer{}, dir)
+	if err != ErrDataDirNotEmpty {
+		t.Fatalf("Expected restore into non-empty dir to fail, got %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package dumbdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+const (
+	// Bits of a bloom filter per row of the page, and number of hashes per value,
+	// which give about 1% of false positives for a full page
+	BloomBitsPerRow = 10
+	BloomHashes     = 7
+)
+
+// Bloom filter of the values of a column on a page
+type bloomFilter []uint64
+
+// Pair of hashes of a value, bits of the filter are h1 + i*h2
+type bloomHash struct {
+	h1, h2 uint32
+}
+
+func (f bloomFilter) add(h bloomHash) {
+	bits := uint32(len(f) * 64)
+	for i := uint32(0); i < BloomHashes; i++ {
+		bit := (h.h1 + i*h.h2) % bits
+		f[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// Returns false if the value is definitely not in the filter
+func (f bloomFilter) mayContain(h bloomHash) bool {
+	bits := uint32(len(f) * 64)
+	for i := uint32(0); i < BloomHashes; i++ {
+		bit := (h.h1 + i*h.h2) % bits
+		if f[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash of the encoded value of the field, see Field.Write()
+func hashValue(field *Field, data []byte) bloomHash {
+	data = data[:field.Len]
+	if field.TypeID == TypeFloat && math.Float64frombits(binary.LittleEndian.Uint64(data)) == 0 {
+		// negative zero is equal to zero
+		data = make([]byte, 8)
+	}
+
+	hash := fnv.New64a()
+	hash.Write(data)
+	sum := hash.Sum64()
+	// odd step is never zero, so that the hashes set different bits
+	return bloomHash{h1: uint32(sum), h2: uint32(sum>>32) | 1}
+}
+
+// Bloom filters of the values of some columns on each page of the table, so that
+// scans looking for rows equal to constants skip pages which definitely don't
+// have them.
+//
+// Filters are kept in memory: filter of a page is built when the page is scanned
+// for the first time, or when rows are inserted into the new page, and updated by
+// inserts afterwards. Rows removed from the page stay in the filter, which
+// only makes it less selective. Methods can be called on nil BloomFilterMap,
+// which is used for tables without bloom filters
+type BloomFilterMap struct {
+	columns []int   // indexes of the columns in the schema
+	fields  []Field // the columns
+	offsets []int   // offsets of the columns in the encoded row
+	words   int     // size of each filter
+
+	m sync.Mutex
+	// indexed by PageID, then by column. nil for pages which weren't scanned yet
+	filters [][]bloomFilter
+}
+
+// Bloom filters of the columns, rows is the max number of rows on a page
+func NewBloomFilterMap(schema *Schema, columns []string, rows int) (*BloomFilterMap, error) {
+	if len(columns) == 0 {
+		return nil, nil
+	}
+
+	bm := &BloomFilterMap{
+		words: (rows*BloomBitsPerRow + 63) / 64,
+	}
+	for _, name := range columns {
+		idx, field := schema.GetField(name)
+		if idx == -1 {
+			return nil, fmt.Errorf("no column named %v for the bloom filter", name)
+		}
+
+		bm.columns = append(bm.columns, idx)
+		bm.fields = append(bm.fields, field)
+		bm.offsets = append(bm.offsets, schema.Offset(idx))
+	}
+	return bm, nil
+}
+
+func (bm *BloomFilterMap) newFilters() []bloomFilter {
+	filters := make([]bloomFilter, len(bm.columns))
+	for i := range filters {
+		filters[i] = make(bloomFilter, bm.words)
+	}
+	return filters
+}
+
+func (bm *BloomFilterMap) addRow(filters []bloomFilter, data []byte) {
+	for i := range filters {
+		filters[i].add(hashValue(&bm.fields[i], data[bm.offsets[i]:]))
+	}
+}
+
+// Build filters of the page unless they are built already, the page should be at least read-locked
+func (bm *BloomFilterMap) scanned(id PageID, page rowSet, schema *Schema) {
+	if bm == nil {
+		return
+	}
+
+	bm.m.Lock()
+	defer bm.m.Unlock()
+
+	if int(id) < len(bm.filters) && bm.filters[id] != nil {
+		return
+	}
+
+	filters := bm.newFilters()
+	for i := 0; i < page.NumRows(); i++ {
+		bm.addRow(filters, page.RowData(i, schema))
+	}
+
+	for len(bm.filters) <= int(id) {
+		bm.filters = append(bm.filters, nil)
+	}
+	bm.filters[id] = filters
+}
+
+// Add rows inserted into the page starting at index first, filters of pages which weren't
+// scanned are built only if the page was empty. The page should be locked
+func (bm *BloomFilterMap) inserted(id PageID, page rowSet, first int, schema *Schema) {
+	if bm == nil {
+		return
+	}
+
+	if first == 0 {
+		bm.scanned(id, page, schema)
+		return
+	}
+
+	bm.m.Lock()
+	defer bm.m.Unlock()
+
+	if int(id) >= len(bm.filters) || bm.filters[id] == nil {
+		return
+	}
+
+	for i := first; i < page.NumRows(); i++ {
+		bm.addRow(bm.filters[id], page.RowData(i, schema))
+	}
+}
+
+// Forget pages with ids >= n, e.g. after the table is truncated
+func (bm *BloomFilterMap) Truncate(n int) {
+	if bm == nil {
+		return
+	}
+
+	bm.m.Lock()
+	defer bm.m.Unlock()
+
+	if n < len(bm.filters) {
+		bm.filters = bm.filters[:n]
+	}
+}
+
+// Values looked up by a predicate in the filters of a column
+type bloomProbe struct {
+	predicate ColumnPredicate
+	column    int // index in bm.columns
+	hashes    []bloomHash
+}
+
+// Probes of the predicates which can be checked against the filters, i.e.
+// equalities and lists of the columns with filters
+func (bm *BloomFilterMap) probes(predicates []ColumnPredicate) []bloomProbe {
+	if bm == nil {
+		return nil
+	}
+
+	var probes []bloomProbe
+	for _, p := range predicates {
+		column := -1
+		for i := range bm.fields {
+			if bm.fields[i].Name == p.Field.Name {
+				column = i
+			}
+		}
+
+		if column == -1 || p.Op != OpEq && p.Op != OpIn {
+			continue
+		}
+
+		values := p.Values
+		if p.Op == OpEq {
+			values = []Value{p.Value}
+		}
+
+		field := &bm.fields[column]
+		probe := bloomProbe{predicate: p, column: column}
+		for _, val := range values {
+			val, ok := keyValue(field, val)
+			if !ok {
+				probe.hashes = nil
+				break
+			}
+
+			data := make([]byte, field.Len)
+			field.Write(data, val)
+			probe.hashes = append(probe.hashes, hashValue(field, data))
+		}
+
+		if len(probe.hashes) != 0 {
+			probes = append(probes, probe)
+		}
+	}
+	return probes
+}
+
+// Returns true if the page definitely has no rows matching all the probes
+func (bm *BloomFilterMap) Excludes(id PageID, probes []bloomProbe) bool {
+	if bm == nil || len(probes) == 0 {
+		return false
+	}
+
+	bm.m.Lock()
+	defer bm.m.Unlock()
+
+	if int(id) >= len(bm.filters) || bm.filters[id] == nil {
+		return false
+	}
+
+	filters := bm.filters[id]
+	for _, probe := range probes {
+		found := false
+		for _, h := range probe.hashes {
+			found = found || filters[probe.column].mayContain(h)
+		}
+
+		if !found {
+			return true
+		}
+	}
+	return false
+}
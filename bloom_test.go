@@ -0,0 +1,201 @@
+package dumbdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBloomFilterMap(t *testing.T) {
+	schema := NewSchema([]FieldDescription{
+		{Name: "id", Type: &Type{Integer: true}},
+		{Name: "name", Type: &Type{Varchar: 20}},
+		{Name: "score", Type: &Type{Float: true}},
+	})
+
+	_, err := NewBloomFilterMap(&schema, []string{"id", "nope"}, 100)
+	if err == nil {
+		t.Fatal("Expected bloom filter of unknown column to fail")
+	}
+
+	table, err := NewTable("", schema, TableOptions{Engine: EngineMemory, BloomFilter: []string{"id", "name", "score"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	rows := make([]Row, 0, 5000)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("name %v", i)), FloatValue(float64(i) / 2)})
+	}
+	rows[0][2] = FloatValue(math.Copysign(0, -1))
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pages []PageID
+	for id := table.pager.FirstPage(); id != InvalidPageID; id = table.pager.NextPage(id) {
+		pages = append(pages, id)
+	}
+
+	perPage := table.rowsPerPage()
+	tests := []struct {
+		predicates []ColumnPredicate
+		page       int // the only page with matching rows, -1 if there are none
+	}{
+		{[]ColumnPredicate{{Field: schema.Fields[0], Op: OpEq, Value: IntValue(1234)}}, 1234 / perPage},
+		{[]ColumnPredicate{{Field: schema.Fields[1], Op: OpEq, Value: VarcharValue("name 4321")}}, 4321 / perPage},
+		{[]ColumnPredicate{{Field: schema.Fields[2], Op: OpEq, Value: FloatValue(0)}}, 0},
+		{[]ColumnPredicate{{Field: schema.Fields[2], Op: OpEq, Value: IntValue(1000)}}, 2000 / perPage},
+		{[]ColumnPredicate{{Field: schema.Fields[0], Op: OpIn, Values: []Value{IntValue(-1), IntValue(3000)}}}, 3000 / perPage},
+		{[]ColumnPredicate{{Field: schema.Fields[0], Op: OpEq, Value: IntValue(-1)}}, -1},
+		{[]ColumnPredicate{
+			{Field: schema.Fields[0], Op: OpEq, Value: IntValue(10)},
+			{Field: schema.Fields[1], Op: OpEq, Value: VarcharValue("name 4321")},
+		}, -1},
+	}
+
+	for _, test := range tests {
+		probes := table.bloom.probes(test.predicates)
+		if len(probes) != len(test.predicates) {
+			t.Fatalf("%v: expected %v probes, got %v", test.predicates, len(test.predicates), len(probes))
+		}
+
+		// pages were filled by inserts, so their filters are built already
+		excluded := 0
+		for i, id := range pages {
+			if !table.bloom.Excludes(id, probes) {
+				continue
+			}
+
+			if i == test.page {
+				t.Fatalf("%v: page %v with matching rows is excluded", test.predicates, id)
+			}
+			excluded++
+		}
+
+		// a few false positives
+		if excluded < len(pages)-3 {
+			t.Fatalf("%v: only %v of %v pages are excluded", test.predicates, excluded, len(pages))
+		}
+	}
+
+	// predicates which can't be checked against the filters
+	for _, p := range []ColumnPredicate{
+		{Field: schema.Fields[0], Op: OpLess, Value: IntValue(10)},
+		{Field: schema.Fields[0], Op: OpEq, Value: FloatValue(0.5)},
+		{Field: schema.Fields[0], Op: OpIn, Values: []Value{IntValue(1), FloatValue(0.5)}},
+	} {
+		if probes := table.bloom.probes([]ColumnPredicate{p}); len(probes) != 0 {
+			t.Fatalf("%v: expected no probes, got %v", p, len(probes))
+		}
+	}
+
+	// filters of truncated pages are dropped
+	err = table.Truncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	probes := table.bloom.probes(tests[0].predicates)
+	if table.bloom.Excludes(pages[0], probes) {
+		t.Fatal("Expected no filter of the truncated page")
+	}
+}
+
+func TestBloomFilterScan(t *testing.T) {
+	dir := t.TempDir()
+	catalog, err := OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		catalog.Close()
+	}()
+
+	query := func(q string) []string {
+		return columnStrings(mustQueryCatalog(t, catalog, q), 0)
+	}
+
+	query("create table t (id int, kind varchar(10)) bloom_filter = (kind)")
+
+	values := make([]string, 0, 3000)
+	for i := 0; i < cap(values); i++ {
+		values = append(values, fmt.Sprintf("(%v, \"k%v\")", i, i/7))
+	}
+	query("insert into t values " + strings.Join(values, ", "))
+
+	check := func() {
+		ids := query("select id from t where kind = \"k100\" or kind = \"k200\"")
+		if len(ids) != 14 {
+			t.Fatalf("Expected 14 rows, got %v", ids)
+		}
+
+		ids = query("select id from t where kind in (\"k1\", \"nope\") and id > 8")
+		if !reflect.DeepEqual(ids, []string{"9", "10", "11", "12", "13"}) {
+			t.Fatalf("Unexpected rows %v", ids)
+		}
+
+		plan := query("explain select id from t where kind = \"k1\"")
+		if len(plan) < 3 || strings.TrimSpace(plan[2]) != "bloom filter: kind = \"k1\"" {
+			t.Fatalf("Expected bloom filter in the plan, got %v", plan)
+		}
+
+		table, err := catalog.Table("t")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		predicates := []ColumnPredicate{
+			{Offset: table.schema.Offset(1), Field: table.schema.Fields[1], Op: OpEq, Value: VarcharValue("k1")},
+		}
+
+		// skipped pages are reported as scanned
+		progress := &ScanProgress{}
+		rows := FullScan(context.Background(), table, predicates, nil, func(Row) (bool, error) { return true, nil }, func(r Row) Row { return r }, progress)
+		all, err := rows.All()
+		if err != nil || len(all) != 7 {
+			t.Fatalf("Expected 7 rows, got %v (%v)", len(all), err)
+		}
+
+		p := progress.Get()
+		if p.PagesScanned != p.PagesTotal || p.PagesTotal < 2 {
+			t.Fatalf("Unexpected progress %+v", p)
+		}
+
+		probes := table.bloom.probes(predicates)
+		excluded := 0
+		for id := table.pager.FirstPage(); id != InvalidPageID; id = table.pager.NextPage(id) {
+			if table.bloom.Excludes(id, probes) {
+				excluded++
+			}
+		}
+
+		if excluded != int(p.PagesTotal)-1 {
+			t.Fatalf("Expected %v pages to be excluded, got %v", p.PagesTotal-1, excluded)
+		}
+	}
+
+	check()
+
+	// filters are built by the first scan after reopening
+	catalog.Close()
+	catalog, err = OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query("select id from t where id < 0")
+	check()
+
+	// filters are updated by inserts into partially filled pages
+	query("insert into t values (5000, \"new\")")
+	if ids := query("select id from t where kind = \"new\""); !reflect.DeepEqual(ids, []string{"5000"}) {
+		t.Fatalf("Unexpected rows %v", ids)
+	}
+}
@@ -1,21 +1,20 @@
 package dumbdb
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
 )
 
-// TODO: move to a different file
-type RowID uint32
-
-func (id RowID) PageID() PageID {
-	val := uint32(id)
-	return PageID(val >> 8)
-}
-
-func (id RowID) RowIndex() uint8 {
-	val := uint32(id)
-	return uint8(val & 0xff)
-}
+var (
+	ErrKeyTooLarge    = errors.New("key is too large")
+	ErrInvalidKeySize = errors.New("key size doesn't match key size of the tree")
+	ErrNotBTree       = errors.New("page is not a B+ tree header")
+	ErrDuplicateKey   = errors.New("duplicate key")
+)
 
 // B+ tree is
 // 1) m-way search tree (for each node there is up to m children nodes)
@@ -25,38 +24,75 @@ func (id RowID) RowIndex() uint8 {
 //
 // for database m is usually set to (PageSize-HeaderSize)/(KeySize+PageIDSize)
 //
+// Keys are byte strings compared with bytes.Compare(), see EncodeKey() for
+// encoding of column values into keys. Keys of the tree either all have the same
+// size, in which case they are stored inline, or have variable size.
+//
+// Unique trees reject keys which are already in the tree with ErrDuplicateKey.
+// Other trees store each duplicate as a separate entry, entries with equal keys
+// are adjacent (possibly spanning a few leaves) in no particular order, so all
+// of them are visited by a Cursor returned from Search(key).
+//
 // see cmudb.io/btree for visualization
+//
+// Concurrent access is synchronized with latch crabbing: latch of a child node is
+// taken before releasing latch of its parent, so nodes are always latched top to
+// bottom (and left to right for leaves), which avoids deadlocks.
 type BTree struct {
-	rootID PageID
-	root   BTreeNode
-	pager  *Pager
+	// id of the header page, which stores id of the root, see ReadBTree()
+	headerID PageID
+
+	// protects rootID and rootPage, which change when the root is split
+	rootM    sync.RWMutex
+	rootID   PageID
+	rootPage *Page // pinned while the tree is open
+
+	pager   *Pager
+	keySize int
+	unique  bool
 }
 
-type BTreeKey uint32
+type BTreeKey []byte
 type BTreeValue RowID
 
 const (
-	// isLeaf (1) + pad (1) +  slotsTaken (2) + prev (4) + next (4)
-	NodeHeaderSize  = 2 + 2 + 4 + 4
-	KeySize         = 4 // sizeof(uint32)
-	ValueSize       = 4 // sizeof(RowID)
-	PageIDSize      = 4 // sizeof(PageID)
-	BranchEntrySize = KeySize + PageIDSize
-	LeafEntrySize   = KeySize + ValueSize
-
-	// test values
-	// BranchNodeCap = 3
-	// LeafNodeCap   = 4
-	BranchNodeCap = (int(PageSize) - NodeHeaderSize) / BranchEntrySize
-	LeafNodeCap   = (int(PageSize) - NodeHeaderSize) / LeafEntrySize
+	// Tree header page is magic (4) + rootID (4) + unique (1)
+	btreeMagic uint32 = 0x45525442 // "BTRE"
+
+	// isLeaf (1) + keySize (1) + slotsTaken (2) + prev (4) + next (4) + heapStart (2) + keyBytes (2)
+	NodeHeaderSize = 1 + 1 + 2 + 4 + 4 + 2 + 2
+	ValueSize      = 4 // sizeof(RowID)
+	PageIDSize     = 4 // sizeof(PageID)
+
+	// Key size of trees with variable-size keys
+	VariableKeySize = 0
+
+	// Fixed-size keys are stored in slots next to the value, so slot is KeySize + ValueSize.
+	// Variable-size keys are stored in the heap growing from the end of the page,
+	// slot holds key offset (2) + key length (2) + value
+	VarSlotSize = 2 + 2 + ValueSize
+
+	// Max size of a fixed-size key, it's stored in a single byte of the header
+	MaxFixedKeySize = 255
+
+	// Max size of a variable-size key, so that a node holds at least a few keys
+	MaxKeySize = 512
 )
 
+// Node of the tree, entries of both leaf and branch nodes are (key, 4 byte value).
+// Value of the leaf entry is BTreeValue, value of the branch entry is id of the
+// child page, which contains keys <= key
 type BTreeNode struct {
 	isLeaf     bool   // leaf when true, branch otherwise
+	keySize    int    // size of the keys or VariableKeySize
 	slotsTaken uint16 // number of slots taken
 	prev       PageID // id of the previous leaf page, not set for branch nodes
 	next       PageID // id of the next leaf page, id of the rightmost branch for branch nodes
 
+	// only used for variable-size keys
+	heapStart uint16 // offset of the first byte of the heap
+	keyBytes  uint16 // total size of the keys in the heap (excluding holes)
+
 	page *Page
 }
 
@@ -64,9 +100,12 @@ func readNode(page *Page) BTreeNode {
 	data := page.Data()
 	node := BTreeNode{
 		isLeaf:     data[0] != 0,
+		keySize:    int(data[1]),
 		slotsTaken: binary.LittleEndian.Uint16(data[2:]),
 		prev:       InvalidPageID,
 		next:       InvalidPageID,
+		heapStart:  binary.LittleEndian.Uint16(data[12:]),
+		keyBytes:   binary.LittleEndian.Uint16(data[14:]),
 
 		page: page,
 	}
@@ -79,6 +118,57 @@ func readNode(page *Page) BTreeNode {
 	return node
 }
 
+// Returns ErrCorruptedPage if the node read from a page can't be a node of
+// the tree with the given key size, so that its entries are safe to access
+func (node *BTreeNode) check(keySize int) error {
+	err := node.page.checkType(PageTypeBTreeNode)
+	if err != nil {
+		return err
+	}
+
+	if node.keySize != keySize {
+		return fmt.Errorf("%w: key size %v, expected %v", ErrCorruptedPage, node.keySize, keySize)
+	}
+
+	slotsEnd := node.slotOffset(node.len())
+	if slotsEnd > int(PageDataSize) {
+		return fmt.Errorf("%w: %v slots don't fit into the page", ErrCorruptedPage, node.len())
+	}
+
+	if !node.isLeaf && node.next >= InvalidPageID {
+		return fmt.Errorf("%w: branch without the rightmost child", ErrCorruptedPage)
+	}
+
+	keyBytes := 0
+	data := node.page.Data()
+	for idx := 0; idx < node.len(); idx++ {
+		if !node.isLeaf && PageID(node.value(idx)) >= InvalidPageID {
+			return fmt.Errorf("%w: invalid child of entry %v", ErrCorruptedPage, idx)
+		}
+
+		if !node.isVariable() {
+			continue
+		}
+
+		offset := node.slotOffset(idx)
+		keyOffset := int(binary.LittleEndian.Uint16(data[offset:]))
+		keyLen := int(binary.LittleEndian.Uint16(data[offset+2:]))
+		if keyOffset < int(node.heapStart) || keyOffset+keyLen > int(PageDataSize) {
+			return fmt.Errorf("%w: key of entry %v is outside of the heap", ErrCorruptedPage, idx)
+		}
+		keyBytes += keyLen
+	}
+
+	if node.isVariable() && (int(node.heapStart) < slotsEnd || int(node.heapStart) > int(PageDataSize)) {
+		return fmt.Errorf("%w: heap overlaps the slots", ErrCorruptedPage)
+	}
+
+	if keyBytes != int(node.keyBytes) {
+		return fmt.Errorf("%w: keys take %v bytes, expected %v", ErrCorruptedPage, keyBytes, node.keyBytes)
+	}
+	return nil
+}
+
 func (node *BTreeNode) writeHeader() {
 	data := node.page.Data()
 	if node.isLeaf {
@@ -87,9 +177,13 @@ func (node *BTreeNode) writeHeader() {
 		data[0] = 0
 
 	}
+	data[1] = byte(node.keySize)
 	binary.LittleEndian.PutUint16(data[2:], node.slotsTaken)
 	binary.LittleEndian.PutUint32(data[4:], uint32(node.prev))
 	binary.LittleEndian.PutUint32(data[8:], uint32(node.next))
+	binary.LittleEndian.PutUint16(data[12:], node.heapStart)
+	binary.LittleEndian.PutUint16(data[14:], node.keyBytes)
+	node.page.SetType(PageTypeBTreeNode)
 	node.page.MarkDirty()
 }
 
@@ -97,211 +191,423 @@ func (node *BTreeNode) len() int {
 	return int(node.slotsTaken)
 }
 
+func (node *BTreeNode) isVariable() bool {
+	return node.keySize == VariableKeySize
+}
+
+func (node *BTreeNode) slotSize() int {
+	if node.isVariable() {
+		return VarSlotSize
+	}
+	return node.keySize + ValueSize
+}
+
+func (node *BTreeNode) slotOffset(idx int) int {
+	return NodeHeaderSize + node.slotSize()*idx
+}
+
+// Returns the key stored in the page, it's only valid until the node is modified
+// requires idx < node.len()
+func (node *BTreeNode) key(idx int) BTreeKey {
+	data := node.page.Data()
+	offset := node.slotOffset(idx)
+	if !node.isVariable() {
+		return data[offset : offset+node.keySize]
+	}
+
+	keyOffset := int(binary.LittleEndian.Uint16(data[offset:]))
+	keyLen := int(binary.LittleEndian.Uint16(data[offset+2:]))
+	return data[keyOffset : keyOffset+keyLen]
+}
+
+// requires idx < node.len()
+func (node *BTreeNode) value(idx int) uint32 {
+	offset := node.slotOffset(idx) + node.slotSize() - ValueSize
+	return binary.LittleEndian.Uint32(node.page.Data()[offset:])
+}
+
+// Number of bytes available for new entries, including holes in the heap
+func (node *BTreeNode) freeSpace() int {
+	return int(PageDataSize) - node.slotOffset(node.len()) - int(node.keyBytes)
+}
+
+// Returns true if an entry with the key of size keyLen fits into the node
+func (node *BTreeNode) hasRoom(keyLen int) bool {
+	size := node.slotSize()
+	if node.isVariable() {
+		size += keyLen
+	}
+	return node.freeSpace() >= size
+}
+
+// Returns true if the node may not have space for a new branch entry after a split of its child,
+// which replaces one entry with two
+func (node *BTreeNode) isFull() bool {
+	if !node.isVariable() {
+		return !node.hasRoom(node.keySize)
+	}
+	// keys of both new entries can be larger than the replaced one
+	return node.freeSpace() < 2*(VarSlotSize+MaxKeySize)
+}
+
+// Move keys to the end of the page removing holes left by removed entries
+func (node *BTreeNode) compact() {
+	var heap [PageDataSize]byte
+	data := node.page.Data()
+	end := int(PageDataSize)
+	for idx := 0; idx < node.len(); idx++ {
+		key := node.key(idx)
+		end -= len(key)
+		copy(heap[end:], key)
+		binary.LittleEndian.PutUint16(data[node.slotOffset(idx):], uint16(end))
+	}
+
+	copy(data[end:], heap[end:])
+	node.heapStart = uint16(end)
+}
+
+// requires node.hasRoom(len(key)) && idx <= node.len()
+func (node *BTreeNode) insertAt(idx int, key BTreeKey, value uint32) {
+	data := node.page.Data()
+	slotSize := node.slotSize()
+	offset := node.slotOffset(idx)
+	slotsEnd := node.slotOffset(node.len())
+
+	if node.isVariable() && int(node.heapStart)-slotsEnd < slotSize+len(key) {
+		node.compact()
+	}
+
+	copy(data[offset+slotSize:], data[offset:slotsEnd])
+	if node.isVariable() {
+		node.heapStart -= uint16(len(key))
+		node.keyBytes += uint16(len(key))
+		copy(data[node.heapStart:], key)
+		binary.LittleEndian.PutUint16(data[offset:], node.heapStart)
+		binary.LittleEndian.PutUint16(data[offset+2:], uint16(len(key)))
+	} else {
+		copy(data[offset:offset+node.keySize], key)
+	}
+	binary.LittleEndian.PutUint32(data[offset+slotSize-ValueSize:], value)
+	node.slotsTaken++
+}
+
+// Remove the entry, key of the removed entry leaves a hole in the heap
+func (node *BTreeNode) removeAt(idx int) {
+	if node.isVariable() {
+		node.keyBytes -= uint16(len(node.key(idx)))
+	}
+
+	data := node.page.Data()
+	dstOffset := node.slotOffset(idx)
+	srcOffset := node.slotOffset(idx + 1)
+	copy(data[dstOffset:], data[srcOffset:node.slotOffset(node.len())])
+	node.slotsTaken--
+}
+
 func (node *BTreeNode) truncate(n int) {
 	if n >= int(node.slotsTaken) {
 		return
 	}
 
+	if node.isVariable() {
+		for idx := n; idx < node.len(); idx++ {
+			node.keyBytes -= uint16(len(node.key(idx)))
+		}
+	}
 	node.slotsTaken = uint16(n)
 }
 
-func (node *BTreeNode) branchCap() int {
-	return BranchNodeCap
+// Index of the first entry with key >= |key|, node.len() if there is none
+func (node *BTreeNode) lowerBound(key BTreeKey) int {
+	return sort.Search(node.len(), func(idx int) bool {
+		return bytes.Compare(node.key(idx), key) >= 0
+	})
 }
 
-func (node *BTreeNode) leafCap() int {
-	return LeafNodeCap
+// Index of the first entry with key > |key|, node.len() if there is none
+func (node *BTreeNode) upperBound(key BTreeKey) int {
+	return sort.Search(node.len(), func(idx int) bool {
+		return bytes.Compare(node.key(idx), key) > 0
+	})
 }
 
 // requires !IsLeaf() && idx < Len()
 func (node *BTreeNode) getBranch(idx int) (key BTreeKey, id PageID) {
-	offset := NodeHeaderSize + BranchEntrySize*idx
-	data := node.page.Data()
-	key = BTreeKey(binary.LittleEndian.Uint32(data[offset:]))
-	id = PageID(binary.LittleEndian.Uint32(data[offset+KeySize:]))
+	key = append(BTreeKey(nil), node.key(idx)...)
+	id = PageID(node.value(idx))
 	return
 }
 
 // requires !IsLeaf()
 func (node *BTreeNode) searchBranch(key BTreeKey) (int, PageID) {
-	len := node.len()
-	for idx := 0; idx < len; idx++ {
-		k, id := node.getBranch(idx)
-		if key <= k {
-			return idx, id
-		}
+	idx := node.lowerBound(key)
+	if idx == node.len() {
+		return idx, node.next
 	}
-	return len, node.next
+	return idx, PageID(node.value(idx))
 }
 
-// requires !node.isLeaf() && node.len() < node.cap() && idx <= node.len()
-func (node *BTreeNode) insertBranchAt(idx int, key BTreeKey, id PageID) int {
-	len := node.len()
-	data := node.page.Data()
-	offset := NodeHeaderSize + BranchEntrySize*idx
-	restSize := (len - idx) * BranchEntrySize
-	copy(data[offset+BranchEntrySize:], data[offset:offset+restSize])
-	binary.LittleEndian.PutUint32(data[offset:], uint32(key))
-	binary.LittleEndian.PutUint32(data[offset+KeySize:], uint32(id))
-	node.slotsTaken++
-	return idx
-}
-
-// requires !node.isLeaf()
+// requires !node.isLeaf() && node.hasRoom(len(key))
 func (node *BTreeNode) insertBranch(key BTreeKey, id PageID) int {
-	idx := 0
-	len := node.len()
-	for {
-		if idx >= len {
-			break
-		}
-		k, _ := node.getBranch(idx)
-		if k > key {
-			break
-		}
-		idx++
-	}
-
-	return node.insertBranchAt(idx, key, id)
+	idx := node.upperBound(key)
+	node.insertAt(idx, key, uint32(id))
+	return idx
 }
 
 func (node *BTreeNode) removeBranchAt(idx int) {
-	data := node.page.Data()
-	dstOffset := NodeHeaderSize + BranchEntrySize*idx
-	srcOffset := dstOffset + BranchEntrySize
-	restSize := (node.len() - idx) * BranchEntrySize
-	copy(data[dstOffset:], data[srcOffset:srcOffset+restSize])
-	node.slotsTaken--
+	node.removeAt(idx)
 }
 
 // requies node.isLeaf
 func (node *BTreeNode) searchLeaf(key BTreeKey) (int, BTreeValue) {
-	len := node.len()
-	for idx := 0; idx < len; idx++ {
-		k, v := node.getLeaf(idx)
-		if key <= k {
-			return idx, v
-		}
+	idx := node.lowerBound(key)
+	if idx == node.len() {
+		return idx, BTreeValue(0)
 	}
-	return len, BTreeValue(0)
+	return idx, BTreeValue(node.value(idx))
 }
 
 // requires node.isLeaf && idx < node.Len()
 func (node *BTreeNode) getLeaf(idx int) (key BTreeKey, value BTreeValue) {
-	offset := NodeHeaderSize + LeafEntrySize*idx
-	data := node.page.Data()
-	key = BTreeKey(binary.LittleEndian.Uint32(data[offset:]))
-	value = BTreeValue(binary.LittleEndian.Uint32(data[offset+KeySize:]))
+	key = append(BTreeKey(nil), node.key(idx)...)
+	value = BTreeValue(node.value(idx))
 	return
 }
 
-// requires node.isLeaf && node.len() < node.cap()
+// requires node.isLeaf && node.hasRoom(len(key))
 // returns insert position (i.e. node.GetLeaf(insertLeaf(key, value)) == (key, value))
 func (node *BTreeNode) insertLeaf(key BTreeKey, value BTreeValue) int {
-	idx := 0
-	len := node.len()
-	for {
-		if idx >= len {
-			break
-		}
-		k, _ := node.getLeaf(idx)
-		if k > key {
-			break
-		}
-		idx++
-	}
-
-	data := node.page.Data()
-	offset := NodeHeaderSize + LeafEntrySize*idx
-	restSize := (len - idx) * LeafEntrySize
-	copy(data[offset+LeafEntrySize:], data[offset:offset+restSize])
-	binary.LittleEndian.PutUint32(data[offset:], uint32(key))
-	binary.LittleEndian.PutUint32(data[offset+KeySize:], uint32(value))
-	node.slotsTaken++
+	idx := node.upperBound(key)
+	node.insertAt(idx, key, uint32(value))
 	return idx
 }
 
-// requires node.isLeaf && other.isLeaf && node.len() + (to - from) < node.cap()
-func (node *BTreeNode) copyLeafFrom(other *BTreeNode, from int, to int) {
-	fromOffset := NodeHeaderSize + from*LeafEntrySize
-	toOffset := NodeHeaderSize + to*LeafEntrySize
-	copy(node.page.Data()[NodeHeaderSize:], other.page.Data()[fromOffset:toOffset])
-	node.slotsTaken = uint16(to - from)
+// Replace entries of the node with entries [from, to) of the other node
+func (node *BTreeNode) copyFrom(other *BTreeNode, from int, to int) {
+	node.slotsTaken = 0
+	node.heapStart = uint16(PageDataSize)
+	node.keyBytes = 0
+
+	if !node.isVariable() {
+		copy(node.page.Data()[NodeHeaderSize:], other.page.Data()[other.slotOffset(from):other.slotOffset(to)])
+		node.slotsTaken = uint16(to - from)
+		return
+	}
+
+	for idx := from; idx < to; idx++ {
+		node.insertAt(node.len(), other.key(idx), other.value(idx))
+	}
 }
 
-func (node *BTreeNode) copyBranchFrom(other *BTreeNode, from int, to int) {
-	fromOffset := NodeHeaderSize + from*BranchEntrySize
-	toOffset := NodeHeaderSize + to*BranchEntrySize
-	copy(node.page.Data()[NodeHeaderSize:], other.page.Data()[fromOffset:toOffset])
-	node.slotsTaken = uint16(to - from)
+// Index of the first entry moved to the right node when the node is split,
+// so that both nodes take about the same space
+func (node *BTreeNode) splitPoint() int {
+	n := node.len()
+	if !node.isVariable() {
+		return n / 2
+	}
+
+	half := (node.slotOffset(n) - NodeHeaderSize + int(node.keyBytes)) / 2
+	size := 0
+	for idx := 0; idx < n-1; idx++ {
+		size += VarSlotSize + len(node.key(idx))
+		if size >= half {
+			return idx + 1
+		}
+	}
+	return n - 1
 }
 
-func ReadBTree(rootID PageID, pager *Pager) (*BTree, error) {
+// Open the tree stored in the pager, headerID is the id returned by BTree.HeaderID()
+func ReadBTree(headerID PageID, pager *Pager) (*BTree, error) {
+	header, err := pager.FetchPage(headerID)
+	if err != nil {
+		return nil, err
+	}
+
+	header.RLock()
+	magic := binary.LittleEndian.Uint32(header.Data())
+	rootID := PageID(binary.LittleEndian.Uint32(header.Data()[4:]))
+	unique := header.Data()[8] != 0
+	kind := header.Type()
+	header.RUnlock()
+	header.Unpin()
+
+	if magic != btreeMagic || kind != PageTypeBTreeHeader {
+		return nil, ErrNotBTree
+	}
+
 	root, err := pager.FetchPage(rootID)
 	if err != nil {
 		return nil, err
 	}
 
+	root.RLock()
 	rootNode := readNode(root)
+	err = rootNode.check(rootNode.keySize)
+	root.RUnlock()
+	if err != nil {
+		root.Unpin()
+		return nil, fmt.Errorf("root %v: %w", rootID, err)
+	}
+
 	return &BTree{
-		rootID: rootID,
-		root:   rootNode,
-		pager:  pager,
+		headerID: headerID,
+		rootID:   rootID,
+		rootPage: root,
+		pager:    pager,
+		keySize:  rootNode.keySize,
+		unique:   unique,
 	}, nil
 }
 
-func NewBTree(pager *Pager) (*BTree, error) {
+// Allocate the header page of a tree with the given root
+func newBTreeHeader(pager *Pager, rootID PageID, unique bool) (PageID, error) {
+	headerID, err := pager.AllocatePage()
+	if err != nil {
+		return InvalidPageID, err
+	}
+
+	header, err := pager.FetchPage(headerID)
+	if err != nil {
+		return InvalidPageID, err
+	}
+	defer header.Unpin()
+
+	header.Lock()
+	binary.LittleEndian.PutUint32(header.Data(), btreeMagic)
+	binary.LittleEndian.PutUint32(header.Data()[4:], uint32(rootID))
+	if unique {
+		header.Data()[8] = 1
+	}
+	header.SetType(PageTypeBTreeHeader)
+	header.MarkDirty()
+	header.Unlock()
+	return headerID, nil
+}
+
+// Update the root id in the header page
+// requires rootM to be locked
+func (tree *BTree) saveRootID() error {
+	header, err := tree.pager.FetchPage(tree.headerID)
+	if err != nil {
+		return err
+	}
+	defer header.Unpin()
+
+	header.Lock()
+	binary.LittleEndian.PutUint32(header.Data()[4:], uint32(tree.rootID))
+	header.MarkDirty()
+	header.Unlock()
+	return nil
+}
+
+// Create a new tree with keys of keySize bytes, or keys of any size if keySize is VariableKeySize,
+// if unique is true duplicate keys are rejected
+func NewBTree(pager *Pager, keySize int, unique bool) (*BTree, error) {
+	if keySize < 0 || keySize > MaxFixedKeySize {
+		return nil, ErrKeyTooLarge
+	}
+
 	rootID, err := pager.AllocatePage()
 	if err != nil {
 		return nil, err
 	}
 
+	headerID, err := newBTreeHeader(pager, rootID, unique)
+	if err != nil {
+		return nil, err
+	}
+
 	rootPage, err := pager.FetchPage(rootID)
 	if err != nil {
 		return nil, err
 	}
 
 	tree := &BTree{
-		rootID: rootID,
-		root: BTreeNode{
-			isLeaf:     false,
-			slotsTaken: 0,
-			prev:       InvalidPageID,
-			next:       InvalidPageID,
+		headerID: headerID,
+		rootID:   rootID,
+		rootPage: rootPage,
+		pager:    pager,
+		keySize:  keySize,
+		unique:   unique,
+	}
 
-			page: rootPage,
-		},
-		pager: pager,
+	root := BTreeNode{
+		isLeaf:     false,
+		keySize:    keySize,
+		slotsTaken: 0,
+		prev:       InvalidPageID,
+		next:       InvalidPageID,
+		heapStart:  uint16(PageDataSize),
+
+		page: rootPage,
 	}
 
 	// insert 2 leaf nodes initially
 	leftID, left, err := tree.allocateNode(true)
 	if err != nil {
+		rootPage.Unpin()
 		return nil, err
 	}
 	defer left.page.Unpin()
 
 	rightID, right, err := tree.allocateNode(true)
 	if err != nil {
+		rootPage.Unpin()
 		return nil, err
 	}
 	defer right.page.Unpin()
 
-	tree.root.insertBranch(BTreeKey(0), leftID)
-	tree.root.next = rightID
+	// smallest possible key
+	root.insertBranch(make(BTreeKey, keySize), leftID)
+	root.next = rightID
 	left.next = rightID
 	right.prev = leftID
 
 	left.writeHeader()
 	right.writeHeader()
-	tree.root.writeHeader()
+	root.writeHeader()
 	return tree, nil
 }
 
-func (tree *BTree) Close() {
-	tree.root.page.Unpin()
+// Id of the header page, which is used to open the tree with ReadBTree()
+func (tree *BTree) HeaderID() PageID {
+	return tree.headerID
+}
+
+// Returns true if the tree rejects duplicate keys
+func (tree *BTree) Unique() bool {
+	return tree.unique
+}
+
+// Release the root page and flush all the pages of the tree to the disk
+func (tree *BTree) Close() error {
+	tree.rootPage.Unpin()
+	return tree.pager.SyncAll()
+}
+
+// Pin the root page and take the shared latch, returns the root node
+func (tree *BTree) rlatchRoot() BTreeNode {
+	tree.rootM.RLock()
+	defer tree.rootM.RUnlock()
+
+	tree.rootPage.Pin()
+	tree.rootPage.RLock()
+	return readNode(tree.rootPage)
+}
+
+func runlatch(node *BTreeNode) {
+	node.page.RUnlock()
+	node.page.Unpin()
+}
+
+func unlatch(node *BTreeNode) {
+	node.page.Unlock()
+	node.page.Unpin()
 }
 
+// Returns a new pinned node
 func (tree *BTree) allocateNode(isLeaf bool) (PageID, BTreeNode, error) {
 	id, err := tree.pager.AllocatePage()
 	if err != nil {
@@ -315,9 +621,11 @@ func (tree *BTree) allocateNode(isLeaf bool) (PageID, BTreeNode, error) {
 
 	node := BTreeNode{
 		isLeaf:     isLeaf,
+		keySize:    tree.keySize,
 		slotsTaken: 0,
 		prev:       InvalidPageID,
 		next:       InvalidPageID,
+		heapStart:  uint16(PageDataSize),
 
 		page: page,
 	}
@@ -325,8 +633,20 @@ func (tree *BTree) allocateNode(isLeaf bool) (PageID, BTreeNode, error) {
 	return id, node, nil
 }
 
-// Move high keys from node to a new node
-// requires node.len() == node.Cap()
+// Check that the key can be stored in the tree
+func (tree *BTree) checkKey(key BTreeKey) error {
+	if tree.keySize != VariableKeySize && len(key) != tree.keySize {
+		return ErrInvalidKeySize
+	}
+
+	if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
+// Move high keys from node to a new node, which is returned pinned
+// requires node.len() >= 2
 func (tree *BTree) splitNode(node *BTreeNode) (mid BTreeKey, newID PageID, newNode BTreeNode, err error) {
 	newID, newNode, err = tree.allocateNode(node.isLeaf)
 	if err != nil {
@@ -334,47 +654,28 @@ func (tree *BTree) splitNode(node *BTreeNode) (mid BTreeKey, newID PageID, newNo
 	}
 
 	len := node.len()
+	split := node.splitPoint()
 	if node.isLeaf {
-		mid, _ = node.getLeaf(len/2 - 1)
-		newNode.copyLeafFrom(node, len/2, len)
-		node.truncate(len / 2)
+		mid, _ = node.getLeaf(split - 1)
+		newNode.copyFrom(node, split, len)
+		node.truncate(split)
 	} else {
 		var id PageID
-		mid, id = node.getBranch(len / 2)
-		newNode.copyBranchFrom(node, len/2+1, len)
+		mid, id = node.getBranch(split)
+		newNode.copyFrom(node, split+1, len)
 
 		// move rightmost pointer to the right node
 		newNode.next = node.next
 
 		// set new rightmost pointer for left node
 		node.next = id
-		node.truncate(len / 2)
+		node.truncate(split)
 	}
 
 	return
 }
 
-func getMaxKey(node *BTreeNode, pager *Pager) (BTreeKey, error) {
-	for {
-		if node.isLeaf {
-			k, _ := node.getLeaf(node.len() - 1)
-			node.page.Unpin()
-			return k, nil
-		}
-
-		page, err := pager.FetchPage(node.next)
-		if err != nil {
-			node.page.Unpin()
-			return BTreeKey(0), err
-		}
-
-		nextNode := readNode(page)
-		node.page.Unpin()
-		node = &nextNode
-	}
-}
-
-// split branch node
+// split branch node, the new right node is returned pinned unless there is an error
 func (tree *BTree) splitBranch(path []*BTreeNode, key BTreeKey) (mid BTreeKey, right BTreeNode, err error) {
 	depth := len(path)
 	if depth == 1 {
@@ -400,24 +701,22 @@ func (tree *BTree) splitBranch(path []*BTreeNode, key BTreeKey) (mid BTreeKey, r
 		left.writeHeader()
 		right.writeHeader()
 
-		prevRoot := tree.root.page
-		// we are inside an Insert(), so root should be locked
+		// we are inside an insertPessimistic() with the root latched, so rootM is locked.
+		// Nobody else can reach the new root until it's released
 		parent.page.Pin()
-		parent.page.Lock()
-
-		// update pointers
-		tree.root = parent
+		tree.rootPage.Unpin()
+		tree.rootPage = parent.page
 		tree.rootID = parentID
-
-		// release previous root
-		prevRoot.Unlock()
-		prevRoot.Unpin()
+		err = tree.saveRootID()
+		if err != nil {
+			right.page.Unpin()
+		}
 		return
 	}
 
 	left := path[depth-1]
 	parent := path[depth-2]
-	if parent.len() == parent.branchCap() {
+	if parent.isFull() {
 		var parentMid BTreeKey
 		var parentRhs BTreeNode
 		parentMid, parentRhs, err = tree.splitBranch(path[:depth-1], key)
@@ -426,7 +725,7 @@ func (tree *BTree) splitBranch(path []*BTreeNode, key BTreeKey) (mid BTreeKey, r
 		}
 		defer parentRhs.page.Unpin()
 
-		if key > parentMid {
+		if bytes.Compare(key, parentMid) > 0 {
 			parent = &parentRhs
 		}
 	}
@@ -440,22 +739,19 @@ func (tree *BTree) splitBranch(path []*BTreeNode, key BTreeKey) (mid BTreeKey, r
 	idx, leftID := parent.searchBranch(key)
 	isRightmost := idx == parent.len()
 
-	// detach |left| from |parent|
+	// detach |left| from |parent|, its key is >= all the keys in the right node
+	var maxKey BTreeKey
 	if !isRightmost {
+		maxKey, _ = parent.getBranch(idx)
 		parent.removeBranchAt(idx)
 	}
 
-	parent.insertBranch(mid, leftID)
+	// insert at the position of |left|, as siblings can have equal keys
+	parent.insertAt(idx, mid, uint32(leftID))
 	if isRightmost {
 		parent.next = rightID
 	} else {
-		// we'll have to find max key in the right subtree
-		var maxKey BTreeKey
-		maxKey, err = getMaxKey(&right, tree.pager)
-		if err != nil {
-			return
-		}
-		parent.insertBranch(maxKey, rightID)
+		parent.insertAt(idx+1, maxKey, uint32(rightID))
 	}
 
 	parent.writeHeader()
@@ -471,7 +767,7 @@ func (tree *BTree) insertLeafOverflow(node *BTreeNode, parent *BTreeNode, key BT
 	}
 	defer newLeaf.page.Unpin()
 
-	if key < mid {
+	if bytes.Compare(key, mid) <= 0 {
 		node.insertLeaf(key, value)
 	} else {
 		newLeaf.insertLeaf(key, value)
@@ -495,21 +791,27 @@ func (tree *BTree) insertLeafOverflow(node *BTreeNode, parent *BTreeNode, key BT
 			return err
 		}
 
+		nextPage.Lock()
 		nextNode := readNode(nextPage)
+		err = nextNode.check(tree.keySize)
+		if err != nil {
+			unlatch(&nextNode)
+			return fmt.Errorf("%v: %w", newLeaf.next, err)
+		}
 		nextNode.prev = newLeafID
 		nextNode.writeHeader()
-		nextNode.page.Unpin()
+		unlatch(&nextNode)
 	}
 
-	// attach |node| back with key=mid
-	parent.insertBranch(mid, nodeID)
+	// attach |node| back with key=mid, at the same position as siblings can have equal keys
+	parent.insertAt(idx, mid, uint32(nodeID))
 
 	// attach new node
 	if isRightmost {
 		parent.next = newLeafID
 	} else {
 		maxLeafKey, _ := newLeaf.getLeaf(newLeaf.len() - 1)
-		parent.insertBranch(maxLeafKey, newLeafID)
+		parent.insertAt(idx+1, maxLeafKey, uint32(newLeafID))
 	}
 
 	parent.writeHeader()
@@ -524,14 +826,14 @@ func (tree *BTree) insertSlow(path []*BTreeNode, key BTreeKey, value BTreeValue)
 	parent := path[depth-2]
 
 	// before splitting the leaf make sure we have space for a new branch
-	if parent.len() == parent.branchCap() {
+	if parent.isFull() {
 		mid, rhs, err := tree.splitBranch(path[:depth-1], key)
 		if err != nil {
 			return err
 		}
 		defer rhs.page.Unpin()
 
-		if key > mid {
+		if bytes.Compare(key, mid) > 0 {
 			parent = &rhs
 		}
 	}
@@ -540,46 +842,135 @@ func (tree *BTree) insertSlow(path []*BTreeNode, key BTreeKey, value BTreeValue)
 	return tree.insertLeafOverflow(node, parent, key, value)
 }
 
-// TODO: optimize locking, only take the locks top to bottom to avoid deadlocks
+// Insert the entry, returns ErrDuplicateKey if the tree is unique and already has the key
 //
-//       first do optimistic walk through tree with read-only locks on branch nodes
-//       take the write lock on leaf node, if there is enough space - insert and we are done
-//       if not -> re-do the walk from root with write locks
-//
-//       on the path down the tree we can release locks above if the node below has enough
-//       space for merge op - on 2nd pass with write locks. With read locks we _assume_ split
-//       will not happen, so we can just release lock above as soon as we get the lock to the node below
+// First the tree is descended with shared latches, and only the leaf is latched exclusively,
+// which is enough if the leaf has room for the entry. Otherwise the tree is descended again
+// with exclusive latches, which are released as soon as a node below can't be split.
 func (tree *BTree) Insert(key BTreeKey, value BTreeValue) error {
-	// NOTE: root can change because of splits
-	tree.root.page.Lock()
-	defer func(tree *BTree) {
-		tree.root.page.Unlock()
-	}(tree)
+	err := tree.checkKey(key)
+	if err != nil {
+		return err
+	}
 
-	depth := 0
-	var path [12]*BTreeNode
+	done, err := tree.insertOptimistic(key, value)
+	if err != nil || done {
+		return err
+	}
 
-	path[depth] = &tree.root
-	node := path[depth]
-	depth++
+	return tree.insertPessimistic(key, value)
+}
 
-	defer func() {
-		for i := 1; i < depth; i++ {
-			path[i].page.Unpin()
+// Returns true if the key can't be inserted into the leaf of a unique tree,
+// leaf has to be the one the key is routed to
+func (tree *BTree) isDuplicate(leaf *BTreeNode, key BTreeKey) bool {
+	if !tree.unique {
+		return false
+	}
+
+	idx := leaf.lowerBound(key)
+	return idx < leaf.len() && bytes.Equal(leaf.key(idx), key)
+}
+
+// Returns false if the leaf has to be split
+func (tree *BTree) insertOptimistic(key BTreeKey, value BTreeValue) (bool, error) {
+	node := tree.rlatchRoot()
+	for {
+		_, id := node.searchBranch(key)
+		if id == InvalidPageID {
+			runlatch(&node)
+			panic("no valid path")
+		}
+
+		page, err := tree.pager.FetchPage(id)
+		if err != nil {
+			runlatch(&node)
+			return false, err
+		}
+
+		page.RLock()
+		child := readNode(page)
+		if child.isLeaf {
+			// leaf can't be split while its parent is latched, so it's safe to re-latch it
+			page.RUnlock()
+			page.Lock()
+			child = readNode(page)
+		}
+		runlatch(&node)
+
+		err = child.check(tree.keySize)
+		if err != nil {
+			if child.isLeaf {
+				unlatch(&child)
+			} else {
+				runlatch(&child)
+			}
+			return false, fmt.Errorf("%v: %w", id, err)
+		}
+
+		if !child.isLeaf {
+			node = child
+			continue
+		}
+
+		defer unlatch(&child)
+		if tree.isDuplicate(&child, key) {
+			return true, ErrDuplicateKey
+		}
+
+		if !child.hasRoom(len(key)) {
+			return false, nil
+		}
+
+		child.insertLeaf(key, value)
+		child.writeHeader()
+		return true, nil
+	}
+}
+
+func (tree *BTree) insertPessimistic(key BTreeKey, value BTreeValue) error {
+	// rootM is locked as long as the root is latched, because the root can be split
+	tree.rootM.Lock()
+	rootLatched := true
+
+	tree.rootPage.Pin()
+	tree.rootPage.Lock()
+	root := readNode(tree.rootPage)
+	path := []*BTreeNode{&root}
+
+	// release all the nodes above the last one
+	releaseAncestors := func() {
+		for _, node := range path[:len(path)-1] {
+			unlatch(node)
+		}
+		path = path[len(path)-1:]
+
+		if rootLatched {
+			tree.rootM.Unlock()
+			rootLatched = false
 		}
+	}
+
+	defer func() {
+		releaseAncestors()
+		unlatch(path[0])
 	}()
 
 	for {
+		node := path[len(path)-1]
 		if node.isLeaf {
-			// fast path
-			if node.len() < node.leafCap() {
+			if tree.isDuplicate(node, key) {
+				return ErrDuplicateKey
+			}
+
+			if node.hasRoom(len(key)) {
 				node.insertLeaf(key, value)
 				node.writeHeader()
 				return nil
 			}
 
-			// slow path, we have to split
-			return tree.insertSlow(path[:depth], key, value)
+			// we have to split, all nodes in path except the first one are full
+			return tree.insertSlow(path, key, value)
 		}
 
 		_, id := node.searchBranch(key)
@@ -592,17 +983,23 @@ func (tree *BTree) Insert(key BTreeKey, value BTreeValue) error {
 			return err
 		}
 
+		page.Lock()
 		nextNode := readNode(page)
-		path[depth] = &nextNode
-		node = path[depth]
-		depth++
+		err = nextNode.check(tree.keySize)
+		if err != nil {
+			unlatch(&nextNode)
+			return fmt.Errorf("%v: %w", id, err)
+		}
+
+		path = append(path, &nextNode)
+		if (nextNode.isLeaf && nextNode.hasRoom(len(key))) || (!nextNode.isLeaf && !nextNode.isFull()) {
+			releaseAncestors()
+		}
 	}
 }
 
-// leaf nodes iterator
+// leaf nodes iterator, the current leaf is latched until the cursor is closed
 type Cursor struct {
-	root *Page
-
 	pager *Pager
 	idx   int
 	node  BTreeNode
@@ -615,7 +1012,13 @@ func (cursor *Cursor) Forward() bool {
 	}
 
 	cursor.idx++
-	if cursor.idx >= cursor.node.len() {
+	return cursor.skipLeafEnd()
+}
+
+// Move to the next leaf with entries if the cursor is past the end of the current one,
+// returns false if there are no more entries
+func (cursor *Cursor) skipLeafEnd() bool {
+	for cursor.idx >= cursor.node.len() {
 		if cursor.node.next == InvalidPageID {
 			return false
 		}
@@ -626,64 +1029,81 @@ func (cursor *Cursor) Forward() bool {
 			return false
 		}
 
-		cursor.node.page.Unpin()
+		id, keySize := cursor.node.next, cursor.node.keySize
+		page.RLock()
+		runlatch(&cursor.node)
 		cursor.node = readNode(page)
 		cursor.idx = 0
-		return true
+
+		err = cursor.node.check(keySize)
+		if err != nil {
+			cursor.err = fmt.Errorf("%v: %w", id, err)
+			return false
+		}
 	}
 	return true
 }
 
+// Returns copy of the key and the value under cursor
 func (cursor *Cursor) Get() (BTreeKey, BTreeValue) {
 	return cursor.node.getLeaf(cursor.idx)
 }
 
+// Returns true if the cursor is at an entry, i.e. Get() can be called
+func (cursor *Cursor) Valid() bool {
+	return cursor.err == nil && cursor.node.page != nil && cursor.idx < cursor.node.len()
+}
+
 func (cursor *Cursor) Err() error {
 	return cursor.err
 }
 
 func (cursor *Cursor) Close() {
-	if cursor.root != nil {
-		cursor.root.RUnlock()
-		cursor.node.page.Unpin()
+	if cursor.node.page != nil {
+		runlatch(&cursor.node)
+		cursor.node.page = nil
 	}
 }
 
 func (tree *BTree) Search(key BTreeKey) Cursor {
-	tree.root.page.RLock()
-
-	node := tree.root
-	for {
-		if node.isLeaf {
-			idx, _ := node.searchLeaf(key)
-			return Cursor{
-				root: tree.root.page,
-
-				pager: tree.pager,
-				idx:   idx,
-				node:  node,
-				err:   nil,
-			}
-		}
-
+	node := tree.rlatchRoot()
+	for !node.isLeaf {
 		_, next := node.searchBranch(key)
 		if next == InvalidPageID {
 			// TODO: in which cases could this happen?
+			runlatch(&node)
 			panic("no valid branch")
 		}
 
 		page, err := tree.pager.FetchPage(next)
 		if err != nil {
-			tree.root.page.RUnlock()
+			runlatch(&node)
 			return Cursor{
 				err: err,
 			}
 		}
 
-		nextNode := readNode(page)
-		if !nextNode.isLeaf {
-			defer nextNode.page.Unpin()
+		page.RLock()
+		runlatch(&node)
+		node = readNode(page)
+
+		err = node.check(tree.keySize)
+		if err != nil {
+			runlatch(&node)
+			return Cursor{
+				err: fmt.Errorf("%v: %w", next, err),
+			}
 		}
-		node = nextNode
 	}
+
+	// keys of branches are upper bounds, so the leaf may have no keys >= |key|
+	idx, _ := node.searchLeaf(key)
+	cursor := Cursor{
+		pager: tree.pager,
+		idx:   idx,
+		node:  node,
+		err:   nil,
+	}
+	cursor.skipLeafEnd()
+	return cursor
 }
@@ -1,72 +1,15 @@
 package dumbdb
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"io"
+	"math"
 	"strings"
+	"sync"
 	"testing"
 )
 
-var (
-	ErrPartialRead  = errors.New("partial read")
-	ErrPartialWrite = errors.New("partial write")
-)
-
-type MemoryStorage struct {
-	pages [][PageSize]byte
-	off   int64
-}
-
-func NewMemoryStorage() *MemoryStorage {
-	return &MemoryStorage{
-		pages: make([][PageSize]byte, 0),
-		off:   0,
-	}
-}
-
-func (s *MemoryStorage) TotalLen() int64 {
-	return int64(len(s.pages)) * int64(PageSize)
-}
-
-func (s *MemoryStorage) Truncate(size int64) error {
-	for s.TotalLen() < size {
-		s.pages = append(s.pages, [PageSize]byte{})
-	}
-	return nil
-}
-
-func (s *MemoryStorage) ReadAt(buf []byte, off int64) (n int, err error) {
-	idx := off / int64(PageSize)
-	n = copy(buf, s.pages[idx][:])
-	if n != len(buf) {
-		err = ErrPartialRead
-	}
-	return
-}
-
-func (s *MemoryStorage) WriteAt(buf []byte, off int64) (n int, err error) {
-	idx := off / int64(PageSize)
-	n = copy(s.pages[idx][:], buf)
-	if n != len(buf) {
-		err = ErrPartialWrite
-	}
-	return
-}
-
-func (s *MemoryStorage) Seek(diff int64, whence int) (newOff int64, err error) {
-	switch whence {
-	case io.SeekCurrent:
-		s.off = s.off + diff
-	case io.SeekStart:
-		s.off = diff
-	case io.SeekEnd:
-		s.off = s.TotalLen() + diff
-	}
-	newOff = s.off
-	return
-}
-
 func debugLeaf(node *BTreeNode) {
 	if node.len() > 10 {
 		for idx := 0; idx < 5; idx++ {
@@ -134,7 +77,7 @@ func debugTree(t *testing.T, root *BTreeNode, pager *Pager, depth int) {
 }
 
 func checkValid(t *testing.T, tree *BTree, searchFrom int, nEntries int, tillEnd bool) {
-	c := tree.Search(BTreeKey(searchFrom))
+	c := tree.Search(IntKey(int32(searchFrom)))
 	defer c.Close()
 	for i := searchFrom; i < nEntries; i++ {
 		if c.Err() != nil {
@@ -142,16 +85,16 @@ func checkValid(t *testing.T, tree *BTree, searchFrom int, nEntries int, tillEnd
 		}
 
 		key, val := c.Get()
-		if key != BTreeKey(i) || val != BTreeValue(i*2) {
+		if !bytes.Equal(key, IntKey(int32(i))) || val != BTreeValue(i*2) {
 			t.Fatalf("Unexpected key at %v: %v %v\n", i, key, val)
 		}
 
-		innerCursor := tree.Search(BTreeKey(i))
+		innerCursor := tree.Search(IntKey(int32(i)))
 		if innerCursor.Err() != nil {
 			t.Fatal(c.Err())
 		}
 		k, v := innerCursor.Get()
-		if k != key || v != val {
+		if !bytes.Equal(k, key) || v != val {
 			t.Fatalf("Search results don't match %v: (%v %v) vs (%v %v)\n", i, key, val, k, v)
 		}
 		innerCursor.Close()
@@ -168,6 +111,18 @@ func checkValid(t *testing.T, tree *BTree, searchFrom int, nEntries int, tillEnd
 	}
 }
 
+// Fails if the tree doesn't pass Validate() or doesn't have nEntries entries
+func validateTree(t *testing.T, tree *BTree, nEntries int) {
+	n, err := tree.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != nEntries {
+		t.Fatalf("Expected %v entries, validated %v", nEntries, n)
+	}
+}
+
 func TestSearch(t *testing.T) {
 	storage := NewMemoryStorage()
 	pager, err := NewPager(20, storage)
@@ -175,7 +130,7 @@ func TestSearch(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	tree, err := NewBTree(pager)
+	tree, err := NewBTree(pager, IntKeySize, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -193,21 +148,21 @@ func TestSearch(t *testing.T) {
 	}
 
 	for _, pair := range values {
-		err := tree.Insert(BTreeKey(pair.Key), BTreeValue(pair.Val))
+		err := tree.Insert(IntKey(int32(pair.Key)), BTreeValue(pair.Val))
 		if err != nil {
 			t.Fatal(err)
 		}
 	}
 
 	checkSearch := func(key uint32, expectedKey uint32, expectedVal uint32) {
-		cursor := tree.Search(BTreeKey(key))
+		cursor := tree.Search(IntKey(int32(key)))
 		if cursor.Err() != nil {
 			t.Fatal(cursor.Err())
 		}
 		defer cursor.Close()
 
 		gotKey, gotVal := cursor.Get()
-		if gotKey != BTreeKey(expectedKey) {
+		if !bytes.Equal(gotKey, IntKey(int32(expectedKey))) {
 			t.Fatalf("Unexpected key %v, expected %v", gotKey, expectedKey)
 		}
 
@@ -229,7 +184,7 @@ func TestInsert(t *testing.T) {
 	}
 	defer pager.SyncAll()
 
-	tree, err := NewBTree(pager)
+	tree, err := NewBTree(pager, IntKeySize, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -241,13 +196,14 @@ func TestInsert(t *testing.T) {
 	// insert high keys
 	for key := nEntries - 1; key >= nEntries/2; key-- {
 		val := key * 2
-		err = tree.Insert(BTreeKey(key), BTreeValue(val))
+		err = tree.Insert(IntKey(int32(key)), BTreeValue(val))
 		if err != nil {
 			t.Fatal(err)
 		}
 
 		if PrintTree {
-			debugTree(t, &tree.root, pager, 0)
+			root := readNode(tree.rootPage)
+			debugTree(t, &root, pager, 0)
 			fmt.Println("---------------------------------")
 		}
 		checkValid(t, tree, key, nEntries, true)
@@ -256,16 +212,560 @@ func TestInsert(t *testing.T) {
 	// insert low keys
 	for key := 0; key < nEntries/2; key++ {
 		val := key * 2
-		err = tree.Insert(BTreeKey(key), BTreeValue(val))
+		err = tree.Insert(IntKey(int32(key)), BTreeValue(val))
 		if err != nil {
 			t.Fatal(err)
 		}
 
 		if PrintTree {
-			debugTree(t, &tree.root, pager, 0)
+			root := readNode(tree.rootPage)
+			debugTree(t, &root, pager, 0)
 			fmt.Println("---------------------------------")
 		}
 		checkValid(t, tree, 0, key+1, false)
 		checkValid(t, tree, nEntries/2, nEntries, true)
 	}
+	validateTree(t, tree, nEntries)
+}
+
+func TestVariableKeys(t *testing.T) {
+	storage := NewMemoryStorage()
+	pager, err := NewPager(64, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := NewBTree(pager, VariableKeySize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	const nEntries = 2000
+	field := Field{TypeID: TypeVarchar, Len: 255}
+	keys := make([]BTreeKey, 0, nEntries)
+	for i := 0; i < nEntries; i++ {
+		// keys of different lengths, so that nodes are split unevenly
+		s := fmt.Sprintf("%05d%v", (i*7919)%nEntries, strings.Repeat("x", (i*31)%200))
+		keys = append(keys, AppendKey(nil, &field, &Value{TypeID: TypeVarchar, Str: s}))
+	}
+
+	for i, key := range keys {
+		err = tree.Insert(key, BTreeValue(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = tree.Insert(make(BTreeKey, MaxKeySize+1), BTreeValue(0))
+	if !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("Expected ErrKeyTooLarge, got %v", err)
+	}
+
+	for i, key := range keys {
+		c := tree.Search(key)
+		if c.Err() != nil {
+			t.Fatal(c.Err())
+		}
+
+		k, v := c.Get()
+		c.Close()
+		if !bytes.Equal(k, key) || v != BTreeValue(i) {
+			t.Fatalf("Search for key #%d returned (%q, %v)", i, k, v)
+		}
+	}
+
+	// leaves have all the keys in order
+	c := tree.Search(BTreeKey{})
+	defer c.Close()
+
+	var prev BTreeKey
+	n := 0
+	for {
+		if c.node.len() != 0 {
+			key, _ := c.Get()
+			if prev != nil && bytes.Compare(prev, key) > 0 {
+				t.Fatalf("Keys are out of order: %q > %q", prev, key)
+			}
+			prev = key
+			n++
+		}
+
+		if !c.Forward() {
+			break
+		}
+	}
+
+	if c.Err() != nil {
+		t.Fatal(c.Err())
+	}
+
+	if n != nEntries {
+		t.Fatalf("Expected %v keys, got %v", nEntries, n)
+	}
+	validateTree(t, tree, nEntries)
+}
+
+func TestEncodeKey(t *testing.T) {
+	fields := []Field{
+		{TypeID: TypeInt, Len: 4},
+		{TypeID: TypeVarchar, Len: 10},
+		{TypeID: TypeBigint, Len: 8},
+	}
+
+	if KeySize(fields) != VariableKeySize || KeySize(fields[:1]) != IntKeySize || KeySize(fields[2:]) != 8 {
+		t.Fatal("Unexpected key size")
+	}
+
+	// in ascending order
+	rows := []Row{
+		{IntValue(-5), VarcharValue("b"), BigintValue(0)},
+		{IntValue(-1), VarcharValue(""), BigintValue(0)},
+		{IntValue(0), VarcharValue("a"), BigintValue(7)},
+		{IntValue(0), VarcharValue("a\x00b"), BigintValue(-7)},
+		{IntValue(0), VarcharValue("ab"), BigintValue(-9000000000)},
+		{IntValue(0), VarcharValue("ab"), BigintValue(9000000000)},
+		{IntValue(3), VarcharValue("a"), BigintValue(0)},
+	}
+
+	for i := 1; i < len(rows); i++ {
+		prev := EncodeKey(fields, rows[i-1])
+		key := EncodeKey(fields, rows[i])
+		if bytes.Compare(prev, key) >= 0 {
+			t.Errorf("Expected key of %v to be less than key of %v", rows[i-1], rows[i])
+		}
+	}
+
+	field := FloatField("")
+	floats := []float64{math.Inf(-1), -1e10, -2.5, -0.5, 0, 1e-10, 0.5, 3, math.Inf(1)}
+	for i := 1; i < len(floats); i++ {
+		prev := AppendKey(nil, &field, &Value{TypeID: TypeFloat, Float: floats[i-1]})
+		key := AppendKey(nil, &field, &Value{TypeID: TypeFloat, Float: floats[i]})
+		if bytes.Compare(prev, key) >= 0 {
+			t.Errorf("Expected key of %v to be less than key of %v", floats[i-1], floats[i])
+		}
+	}
+}
+
+func TestBuildBTree(t *testing.T) {
+	storage := NewMemoryStorage()
+	pager, err := NewPager(20, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const nEntries = 5000
+	tree, err := BuildBTree(pager, IntKeySize, false, func(emit func(BTreeKey, BTreeValue) error) error {
+		// leave odd keys for inserts
+		for i := 0; i < nEntries; i += 2 {
+			err := emit(IntKey(int32(i)), BTreeValue(i*2))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	for i := 1; i < nEntries; i += 2 {
+		err = tree.Insert(IntKey(int32(i)), BTreeValue(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkValid(t, tree, 0, nEntries, true)
+	validateTree(t, tree, nEntries)
+
+	_, err = BuildBTree(pager, IntKeySize, false, func(emit func(BTreeKey, BTreeValue) error) error {
+		err := emit(IntKey(2), BTreeValue(0))
+		if err != nil {
+			return err
+		}
+		return emit(IntKey(1), BTreeValue(0))
+	})
+	if !errors.Is(err, ErrUnsortedKeys) {
+		t.Fatalf("Expected ErrUnsortedKeys, got %v", err)
+	}
+
+	empty, err := BuildBTree(pager, VariableKeySize, false, func(emit func(BTreeKey, BTreeValue) error) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+
+	err = empty.Insert(BTreeKey("key"), BTreeValue(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentInsert(t *testing.T) {
+	storage := NewMemoryStorage()
+	pager, err := NewPager(64, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := NewBTree(pager, IntKeySize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	const nWorkers = 8
+	const nEntries = 20000
+
+	var wg sync.WaitGroup
+	errs := make(chan error, nWorkers)
+	for w := 0; w < nWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for key := w; key < nEntries; key += nWorkers {
+				err := tree.Insert(IntKey(int32(key)), BTreeValue(key*2))
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				// readers run concurrently with the inserts
+				c := tree.Search(IntKey(int32(key)))
+				k, _ := c.Get()
+				c.Close()
+				if !bytes.Equal(k, IntKey(int32(key))) {
+					errs <- fmt.Errorf("Inserted key %v is not found", key)
+					return
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	checkValid(t, tree, 0, nEntries, true)
+	validateTree(t, tree, nEntries)
+}
+
+// Fails if some pages of the pager are pinned
+func checkNoPins(t *testing.T, pager *Pager) {
+	pager.cache.ForEach(func(id PageID, page *Page) bool {
+		if page.IsPinned() {
+			t.Errorf("Page %v is still pinned (pin count %v)", id, page.pinCount)
+		}
+		return true
+	})
+}
+
+func TestBTreePins(t *testing.T) {
+	storage := NewMemoryStorage()
+	pager, err := NewPager(16, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := NewBTree(pager, VariableKeySize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const nEntries = 3000
+	key := func(i int) BTreeKey {
+		// long keys, so that there are a few levels of branches
+		return BTreeKey(fmt.Sprintf("%06d%v", (i*7919)%nEntries, strings.Repeat("x", 100)))
+	}
+
+	for i := 0; i < nEntries; i++ {
+		err = tree.Insert(key(i), BTreeValue(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < nEntries; i += 100 {
+		c := tree.Search(key(i))
+		for n := 0; n < 50 && c.Forward(); n++ {
+		}
+		c.Close()
+	}
+
+	c := tree.Search(BTreeKey{})
+	for c.Forward() {
+	}
+	c.Close()
+	if c.Err() != nil {
+		t.Fatal(c.Err())
+	}
+
+	tree.Close()
+	checkNoPins(t, pager)
+
+	tree, err = BuildBTree(pager, IntKeySize, false, func(emit func(BTreeKey, BTreeValue) error) error {
+		for i := 0; i < nEntries; i++ {
+			err := emit(IntKey(int32(i)), BTreeValue(i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree.Close()
+	checkNoPins(t, pager)
+}
+
+func TestReopenBTree(t *testing.T) {
+	storage := NewMemoryStorage()
+	pager, err := NewPager(20, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := NewBTree(pager, IntKeySize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// enough entries to split the root
+	const nEntries = 300000
+	firstRootID := tree.rootID
+	for i := 0; i < nEntries; i++ {
+		err = tree.Insert(IntKey(int32(i)), BTreeValue(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if tree.rootID == firstRootID {
+		t.Fatal("Expected the root to be split")
+	}
+
+	headerID := tree.HeaderID()
+	err = tree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager, err = NewPager(20, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = ReadBTree(headerID, pager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	checkValid(t, tree, 0, nEntries, true)
+	validateTree(t, tree, nEntries)
+
+	_, err = ReadBTree(tree.rootID, pager)
+	if !errors.Is(err, ErrNotBTree) {
+		t.Fatalf("Expected ErrNotBTree, got %v", err)
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	storage := NewMemoryStorage()
+	pager, err := NewPager(20, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unique, err := NewBTree(pager, IntKeySize, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2000; i++ {
+		err = unique.Insert(IntKey(int32(i)), BTreeValue(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, key := range []int32{0, 1000, 1999} {
+		err = unique.Insert(IntKey(key), BTreeValue(0))
+		if !errors.Is(err, ErrDuplicateKey) {
+			t.Fatalf("Expected ErrDuplicateKey for %v, got %v", key, err)
+		}
+	}
+
+	headerID := unique.HeaderID()
+	err = unique.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unique, err = ReadBTree(headerID, pager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unique.Close()
+
+	if !unique.Unique() {
+		t.Fatal("Expected reopened tree to be unique")
+	}
+	validateTree(t, unique, 2000)
+
+	_, err = BuildBTree(pager, IntKeySize, true, func(emit func(BTreeKey, BTreeValue) error) error {
+		for _, key := range []int32{1, 2, 2} {
+			err := emit(IntKey(key), BTreeValue(0))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("Expected ErrDuplicateKey, got %v", err)
+	}
+
+	tree, err := NewBTree(pager, IntKeySize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	// duplicates of a few keys fill a lot of leaves
+	const nKeys = 5
+	const nDuplicates = 1000
+	for i := 0; i < nKeys*nDuplicates; i++ {
+		err = tree.Insert(IntKey(int32(i%nKeys)), BTreeValue(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for key := 0; key < nKeys; key++ {
+		c := tree.Search(IntKey(int32(key)))
+		n := 0
+		for c.Err() == nil {
+			k, v := c.Get()
+			if !bytes.Equal(k, IntKey(int32(key))) {
+				break
+			}
+
+			if int(v)%nKeys != key {
+				t.Fatalf("Unexpected value of duplicate #%v of %v: %v", n, key, v)
+			}
+
+			n++
+			if !c.Forward() {
+				break
+			}
+		}
+		c.Close()
+
+		if n != nDuplicates {
+			t.Fatalf("Expected %v entries with key %v, got %v", nDuplicates, key, n)
+		}
+	}
+	validateTree(t, tree, nKeys*nDuplicates)
+}
+
+func TestValidateBTree(t *testing.T) {
+	storage := NewMemoryStorage()
+	pager, err := NewPager(20, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := NewBTree(pager, IntKeySize, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	validateTree(t, tree, 0)
+
+	const nEntries = 5000
+	for i := 0; i < nEntries; i++ {
+		err = tree.Insert(IntKey(int32(i*7919%nEntries)), BTreeValue(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	validateTree(t, tree, nEntries)
+
+	// id of a leaf in the middle of the tree
+	c := tree.Search(IntKey(nEntries / 2))
+	leafID := c.node.page.id
+	c.Close()
+
+	page, err := pager.FetchPage(leafID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Unpin()
+
+	corruptions := []struct {
+		corrupt  func(node *BTreeNode, data []byte)
+		expected error
+	}{
+		{
+			// keys out of order
+			corrupt: func(node *BTreeNode, data []byte) {
+				key := node.key(node.len() - 1)
+				copy(key, node.key(0))
+			},
+			expected: ErrInvalidBTree,
+		},
+		{
+			// key above the separator of the parent
+			corrupt: func(node *BTreeNode, data []byte) {
+				copy(node.key(node.len()-1), IntKey(nEntries))
+			},
+			expected: ErrInvalidBTree,
+		},
+		{
+			corrupt: func(node *BTreeNode, data []byte) {
+				node.prev = leafID
+				node.writeHeader()
+			},
+			expected: ErrInvalidBTree,
+		},
+		{
+			corrupt: func(node *BTreeNode, data []byte) {
+				node.slotsTaken = 1
+				node.writeHeader()
+			},
+			expected: ErrInvalidBTree,
+		},
+		{
+			corrupt: func(node *BTreeNode, data []byte) {
+				data[1] = IntKeySize + 1
+			},
+			expected: ErrCorruptedPage,
+		},
+	}
+
+	for i, corruption := range corruptions {
+		page.Lock()
+		saved := append([]byte(nil), page.Data()...)
+		node := readNode(page)
+		corruption.corrupt(&node, page.Data())
+		page.Unlock()
+
+		_, err = tree.Validate()
+		if !errors.Is(err, corruption.expected) {
+			t.Fatalf("Corruption #%v: expected %v, got %v", i, corruption.expected, err)
+		}
+
+		page.Lock()
+		copy(page.Data(), saved)
+		page.Unlock()
+		validateTree(t, tree, nEntries)
+	}
 }
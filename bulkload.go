@@ -0,0 +1,163 @@
+package dumbdb
+
+import (
+	"bytes"
+	"errors"
+)
+
+var ErrUnsortedKeys = errors.New("keys of the bulk load are not sorted")
+
+// Free space left in the nodes built by BuildBTree(), so that inserts
+// following the bulk load don't split every node they touch
+const BulkLoadFreeSpace = int(PageDataSize) / 10
+
+// Child node of a level being built, key is the max key of the subtree
+type bulkEntry struct {
+	key BTreeKey
+	id  PageID
+}
+
+// Returns true if an entry with the key of size keyLen fits into the node,
+// leaving BulkLoadFreeSpace bytes free
+func (node *BTreeNode) hasRoomBulk(keyLen int) bool {
+	size := node.slotSize()
+	if node.isVariable() {
+		size += keyLen
+	}
+	return node.freeSpace()-size >= BulkLoadFreeSpace
+}
+
+// Build a new tree from the entries emitted by |produce| in the ascending key order.
+// Leaves are filled left to right, then branch levels are built bottom-up,
+// so unlike repeated Insert() there are no splits and pages are written sequentially
+func BuildBTree(pager *Pager, keySize int, unique bool, produce func(emit func(BTreeKey, BTreeValue) error) error) (*BTree, error) {
+	if keySize < 0 || keySize > MaxFixedKeySize {
+		return nil, ErrKeyTooLarge
+	}
+
+	tree := &BTree{
+		pager:   pager,
+		keySize: keySize,
+		unique:  unique,
+	}
+
+	leaves, err := tree.buildLeaves(produce)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(leaves) == 0 {
+		return NewBTree(pager, keySize, unique)
+	}
+
+	// root is always a branch node, even if there is a single leaf
+	level := leaves
+	for isLeaf := true; isLeaf || len(level) > 1; isLeaf = false {
+		level, err = tree.buildBranches(level)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	headerID, err := newBTreeHeader(pager, level[0].id, unique)
+	if err != nil {
+		return nil, err
+	}
+	return ReadBTree(headerID, pager)
+}
+
+// Write sorted entries into the linked list of leaves, returns the leaves
+func (tree *BTree) buildLeaves(produce func(emit func(BTreeKey, BTreeValue) error) error) ([]bulkEntry, error) {
+	var leaves []bulkEntry
+	var leaf BTreeNode
+	var prevKey BTreeKey
+
+	err := produce(func(key BTreeKey, value BTreeValue) error {
+		err := tree.checkKey(key)
+		if err != nil {
+			return err
+		}
+
+		if prevKey != nil {
+			cmp := bytes.Compare(prevKey, key)
+			if cmp > 0 {
+				return ErrUnsortedKeys
+			}
+			if cmp == 0 && tree.unique {
+				return ErrDuplicateKey
+			}
+		}
+		prevKey = append(prevKey[:0], key...)
+
+		if leaf.page == nil || !leaf.hasRoomBulk(len(key)) {
+			id, next, err := tree.allocateNode(true)
+			if err != nil {
+				return err
+			}
+
+			if leaf.page != nil {
+				maxKey, _ := leaf.getLeaf(leaf.len() - 1)
+				leaves[len(leaves)-1].key = maxKey
+
+				leaf.next = id
+				next.prev = leaves[len(leaves)-1].id
+				leaf.writeHeader()
+				leaf.page.Unpin()
+			}
+
+			leaves = append(leaves, bulkEntry{id: id})
+			leaf = next
+		}
+
+		leaf.insertAt(leaf.len(), key, uint32(value))
+		return nil
+	})
+
+	if leaf.page != nil {
+		if leaf.len() != 0 {
+			leaves[len(leaves)-1].key, _ = leaf.getLeaf(leaf.len() - 1)
+		}
+		leaf.writeHeader()
+		leaf.page.Unpin()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return leaves, nil
+}
+
+// Build a level of branch nodes pointing to the children, returns the nodes
+func (tree *BTree) buildBranches(children []bulkEntry) ([]bulkEntry, error) {
+	var branches []bulkEntry
+	var node BTreeNode
+
+	// last child is added to the node only when the next one is known, as the
+	// rightmost child of a branch node is referenced by node.next
+	for idx, child := range children {
+		if node.page == nil {
+			id, next, err := tree.allocateNode(false)
+			if err != nil {
+				return nil, err
+			}
+
+			branches = append(branches, bulkEntry{id: id})
+			node = next
+		}
+
+		isLast := idx == len(children)-1
+		if isLast || !node.hasRoomBulk(len(child.key)) {
+			node.next = child.id
+			node.writeHeader()
+			node.page.Unpin()
+			node = BTreeNode{}
+
+			branches[len(branches)-1].key = child.key
+			continue
+		}
+
+		node.insertAt(node.len(), child.key, uint32(child.id))
+	}
+
+	return branches, nil
+}
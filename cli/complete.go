@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"dumbdb/client"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var keywords = []string{
+	"add", "after", "all", "alter", "analyze", "and", "as", "asc", "auto_increment", "backup", "before", "begin", "between", "bigint", "bloom_filter", "bool", "by", "call", "close", "column", "columnar", "compression", "copy",
+	"create", "csv", "cursor", "database", "ddl", "declare", "delete", "desc", "describe", "dictionary", "distinct", "drop", "each", "encryption", "end", "engine", "error", "explain", "false", "fetch", "flate", "float", "for", "format", "from",
+	"grant", "grants", "header", "in", "index", "indexes", "insert", "int", "into", "json", "kill", "like", "limit", "memory", "not", "on", "or", "order", "procedure", "procedures", "processlist", "raise", "revoke", "row", "select", "serial",
+	"set", "show", "status", "stdin", "table", "tables", "to", "trigger", "triggers", "true", "truncate", "ttl", "ttl_column", "update", "use", "vacuum", "values", "varchar", "variables", "view",
+	"views", "when", "where", "with",
+
+	// functions
+	"concat", "length", "lower", "substr", "trim", "upper",
+}
+
+// Completes SQL keywords, and names of tables, views and columns of the current database
+type completer struct {
+	conn  *client.Conn
+	names []string // tables, views and columns
+}
+
+// Reload table, view and column names from the server
+// Errors are ignored, completion just falls back to keywords
+func (c *completer) refresh() {
+	c.names = c.names[:0]
+	tables := append(c.queryColumn("show tables", 0), c.queryColumn("show views", 0)...)
+	seen := make(map[string]bool)
+	for _, table := range tables {
+		seen[table] = true
+		c.names = append(c.names, table)
+		for _, column := range c.queryColumn("describe "+table, 0) {
+			if !seen[column] {
+				seen[column] = true
+				c.names = append(c.names, column)
+			}
+		}
+	}
+	sort.Strings(c.names)
+}
+
+// Return values of the string column of the query result
+func (c *completer) queryColumn(query string, idx int) []string {
+	rows, err := c.conn.Query(context.Background(), query)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		row := rows.Row()
+		if idx < len(row) {
+			values = append(values, row[idx].StrVal())
+		}
+	}
+	return values
+}
+
+func isWordChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Returns suffixes of the candidates for the word before pos, see readline.AutoCompleter
+func (c *completer) Do(line []rune, pos int) ([][]rune, int) {
+	start := pos
+	for start > 0 && isWordChar(line[start-1]) {
+		start--
+	}
+
+	prefix := string(line[start:pos])
+	if prefix == "" {
+		return nil, 0
+	}
+
+	upper := strings.ToUpper(prefix) == prefix && strings.ToLower(prefix) != prefix
+	var candidates [][]rune
+	for _, keyword := range keywords {
+		if len(keyword) > len(prefix) && strings.EqualFold(keyword[:len(prefix)], prefix) {
+			suffix := keyword[len(prefix):]
+			if upper {
+				suffix = strings.ToUpper(suffix)
+			}
+			candidates = append(candidates, []rune(suffix))
+		}
+	}
+
+	for _, name := range c.names {
+		if len(name) > len(prefix) && strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, []rune(name[len(prefix):]))
+		}
+	}
+
+	return candidates, len([]rune(prefix))
+}
+
+// Returns true if the statement can change the set of tables or columns
+func changesNames(statement string) bool {
+	fields := strings.Fields(strings.ToLower(statement))
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch fields[0] {
+	case "create", "drop", "use":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"dumbdb"
+	"dumbdb/client"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/olekukonko/tablewriter"
+)
+
+func formatTable(rows []dumbdb.Row, schema dumbdb.Schema, w io.Writer) {
+	writer := tablewriter.NewWriter(w)
+	writer.SetHeader(schema.ColumnNames())
+
+	text := make([]string, 0, 3)
+	for _, row := range rows {
+		for _, field := range row {
+			text = append(text, field.String())
+		}
+
+		writer.Append(text)
+		text = text[:0]
+	}
+	writer.Render()
+}
+
+// Handle client-side commands, which start with a backslash
+func runMetaCommand(line string, out *output) error {
+	args := strings.Fields(line)
+	switch args[0] {
+	case "\\output":
+		return out.set(args[1:])
+	case "\\format":
+		return out.setFormat(args[1:])
+	default:
+		return fmt.Errorf("unknown command %v", args[0])
+	}
+}
+
+// Execute query and print its result, errors are printed as well
+// Progress of long queries is drawn unless progress is nil
+func runQuery(conn *client.Conn, query string, out *output, progress *progressLine) error {
+	ctx := context.Background()
+	if progress != nil {
+		ctx = client.WithProgress(ctx, progress.update)
+	}
+
+	rows, err := conn.Query(ctx, query)
+	if err != nil {
+		progress.clear()
+		printQueryError(query, err)
+		return err
+	}
+
+	result, queryErr := rows.All()
+	progress.clear()
+	if queryErr != nil {
+		printQueryError(query, queryErr)
+	}
+
+	if rows.Schema() != nil {
+		err = out.write(result, rows.Schema())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to write result:", err)
+			return err
+		}
+	} else if id := rows.LastInsertID(); id != 0 {
+		fmt.Println("Last insert id:", id)
+	}
+
+	return queryErr
+}
+
+func printQueryError(query string, err error) {
+	var serverErr *client.ServerError
+	if errors.As(err, &serverErr) {
+		fmt.Fprintln(os.Stderr, "Failed to process query:", err)
+		if serverErr.Syntax != nil {
+			printSyntaxError(query, serverErr.Syntax)
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "Connection error:", err)
+	}
+}
+
+// Print the line of the query with the error and a caret under the offending token
+func printSyntaxError(query string, syntaxErr *dumbdb.SyntaxError) {
+	offset := syntaxErr.Offset
+	if offset < 0 || offset > len(query) {
+		return
+	}
+
+	start := strings.LastIndexByte(query[:offset], '\n') + 1
+	end := strings.IndexByte(query[offset:], '\n')
+	if end == -1 {
+		end = len(query)
+	} else {
+		end += offset
+	}
+
+	// keep tabs, so that the caret is aligned with the token
+	indent := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, query[start:offset])
+
+	fmt.Fprintln(os.Stderr, query[start:end])
+	fmt.Fprintln(os.Stderr, indent+"^")
+}
+
+// Execute all the statements of the script, stops at the first error
+func runScript(conn *client.Conn, script string, out *output) error {
+
+	for _, statement := range dumbdb.SplitStatements(script) {
+		err := runQuery(conn, statement, out, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns true if the input ends with a semicolon, which is not inside a string literal
+func statementComplete(input string) bool {
+	inString := false
+	complete := false
+	for i := 0; i < len(input); i++ {
+		switch c := input[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+			complete = false
+		case !inString && c == ';':
+			complete = true
+		case c != ' ' && c != '\t' && c != '\n':
+			complete = false
+		}
+	}
+	return complete
+}
+
+const (
+	prompt             = "> "
+	continuationPrompt = "…> "
+)
+
+func runCLI(history string, conn *client.Conn, out *output) {
+	complete := &completer{conn: conn}
+	complete.refresh()
+
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:                 prompt,
+		HistoryFile:            history,
+		DisableAutoSaveHistory: true,
+		AutoComplete:           complete,
+	})
+	if err != nil {
+		fmt.Println("Failed to initialize readline", err)
+		return
+	}
+	defer rl.Close()
+
+	progress := &progressLine{}
+
+	// lines of the statement which is not terminated yet
+	var input []string
+	reset := func() {
+		input = input[:0]
+		rl.SetPrompt(prompt)
+	}
+
+	for {
+		line, err := rl.Readline()
+		if errors.Is(err, readline.ErrInterrupt) && len(input) != 0 {
+			// drop unfinished statement
+			reset()
+			continue
+		}
+
+		if err != nil {
+			break
+		}
+
+		line = strings.TrimSpace(line)
+		if len(input) == 0 {
+			if len(line) == 0 {
+				continue
+			}
+
+			if strings.HasPrefix(line, "\\") {
+				rl.SaveHistory(line)
+				err = runMetaCommand(line, out)
+				if err != nil {
+					fmt.Println(err)
+				}
+				continue
+			}
+		}
+
+		input = append(input, line)
+		statement := strings.Join(input, "\n")
+		if !statementComplete(statement) {
+			rl.SetPrompt(continuationPrompt)
+			continue
+		}
+
+		rl.SaveHistory(strings.Join(input, " "))
+		reset()
+		for _, query := range dumbdb.SplitStatements(statement) {
+			runQuery(conn, query, out, progress)
+			if changesNames(query) {
+				complete.refresh()
+			}
+		}
+	}
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:1337", "address of the server")
+	command := flag.String("c", "", "execute statements separated by semicolons and exit")
+	file := flag.String("f", "", "execute statements from the file (- for stdin) and exit")
+	format := flag.String("format", "table", "output format: table, csv, json or vertical")
+	user := flag.String("user", "", "user to connect as, if the server enforces access control (the password is read from $"+dumbdb.PasswordEnv+")")
+	flag.Parse()
+
+	err := checkFormat(*format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	out := &output{format: *format}
+	defer out.close()
+
+	if *command != "" && *file != "" {
+		fmt.Fprintln(os.Stderr, "-c and -f can't be used together")
+		os.Exit(2)
+	}
+
+	var script string
+	switch {
+	case *command != "":
+		script = *command
+	case *file == "-":
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to read stdin:", err)
+			os.Exit(2)
+		}
+		script = string(data)
+	case *file != "":
+		data, err := ioutil.ReadFile(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to read script:", err)
+			os.Exit(2)
+		}
+		script = string(data)
+	}
+
+	conn, err := client.Connect(context.Background(), *addr, client.Options{
+		DialTimeout: 5 * time.Second,
+		User:        *user,
+		Password:    os.Getenv(dumbdb.PasswordEnv),
+
+		// the shell survives server restarts
+		ReconnectAttempts: 5,
+		RetryReadOnly:     true,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to connect to server:", err)
+		os.Exit(2)
+	}
+	defer conn.Close()
+
+	if *command != "" || *file != "" {
+		err = runScript(conn, script, out)
+		out.close()
+		conn.Close()
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	currentDir, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	history := filepath.Join(currentDir, "history.txt")
+	runCLI(history, conn, out)
+}
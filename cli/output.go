@@ -0,0 +1,138 @@
+package main
+
+import (
+	"dumbdb"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Destination and format of query results
+type output struct {
+	format string   // "table", "csv" or "json"
+	file   *os.File // nil means stdout
+}
+
+func (o *output) writer() io.Writer {
+	if o.file == nil {
+		return os.Stdout
+	}
+	return o.file
+}
+
+func (o *output) close() error {
+	if o.file == nil {
+		return nil
+	}
+
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
+
+func checkFormat(format string) error {
+	switch format {
+	case "table", "csv", "json", "vertical":
+		return nil
+	default:
+		return errors.New("unknown format, expected table, csv, json or vertical")
+	}
+}
+
+// \output table|csv|json|vertical [file]
+func (o *output) set(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: \\output table|csv|json|vertical [file]")
+	}
+
+	format := args[0]
+	err := checkFormat(format)
+	if err != nil {
+		return err
+	}
+
+	var file *os.File
+	if len(args) == 2 {
+		file, err = os.Create(args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = o.close()
+	o.format = format
+	o.file = file
+	return err
+}
+
+// \format table|csv|json|vertical, keeps the destination
+func (o *output) setFormat(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: \\format table|csv|json|vertical")
+	}
+
+	err := checkFormat(args[0])
+	if err != nil {
+		return err
+	}
+
+	o.format = args[0]
+	return nil
+}
+
+func (o *output) write(rows []dumbdb.Row, schema *dumbdb.Schema) error {
+	switch o.format {
+	case "", "table":
+		formatTable(rows, *schema, o.writer())
+		return nil
+	case "vertical":
+		return formatVertical(rows, *schema, o.writer())
+	}
+
+	writer, err := dumbdb.NewRowWriter(o.format, o.writer(), schema)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		err = writer.WriteRow(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+// Print each row as a list of "column | value" lines, handy for wide rows
+func formatVertical(rows []dumbdb.Row, schema dumbdb.Schema, w io.Writer) error {
+	names := schema.ColumnNames()
+	width := 0
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	for i, row := range rows {
+		_, err := fmt.Fprintf(w, "-[ RECORD %d ]%s\n", i+1, strings.Repeat("-", width))
+		if err != nil {
+			return err
+		}
+
+		for j, value := range row {
+			_, err = fmt.Fprintf(w, "%-*s | %s\n", width, names[j], value.String())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(rows) == 0 {
+		_, err := fmt.Fprintln(w, "(0 rows)")
+		return err
+	}
+	return nil
+}
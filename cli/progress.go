@@ -0,0 +1,37 @@
+package main
+
+import (
+	"dumbdb"
+	"fmt"
+	"os"
+)
+
+// Progress of the running query, drawn over a single line of stderr
+// Methods can be called on nil progressLine, which draws nothing
+type progressLine struct {
+	shown bool
+}
+
+func (p *progressLine) update(progress dumbdb.Progress) {
+	if p == nil {
+		return
+	}
+
+	percent := int64(100)
+	if progress.PagesTotal != 0 && progress.PagesScanned < progress.PagesTotal {
+		percent = progress.PagesScanned * 100 / progress.PagesTotal
+	}
+
+	fmt.Fprintf(os.Stderr, "\rScanned %v of %v pages (%v%%)\033[K", progress.PagesScanned, progress.PagesTotal, percent)
+	p.shown = true
+}
+
+// Erase the line, so that the result is printed in its place
+func (p *progressLine) clear() {
+	if p == nil || !p.shown {
+		return
+	}
+
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	p.shown = false
+}
@@ -0,0 +1,691 @@
+// Package client implements a client for the dumbdb server
+package client
+
+import (
+	"context"
+	"dumbdb"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	ErrConnClosed = errors.New("connection is closed")
+	ErrBusy       = errors.New("previous query result is not closed")
+)
+
+// Errors reported by the server match these with errors.Is() by their codes
+var (
+	ErrSyntax              = errors.New("syntax error")
+	ErrNoSuchTable         = errors.New("no such table")
+	ErrTypeMismatch        = errors.New("type mismatch")
+	ErrConstraintViolation = errors.New("constraint violation")
+	ErrTimeout             = errors.New("query timed out")
+	ErrCancelled           = errors.New("query cancelled")
+	ErrAccessDenied        = errors.New("access denied")
+	ErrInternal            = errors.New("internal server error")
+)
+
+var codeErrors = map[dumbdb.ErrorCode]error{
+	dumbdb.ErrorCodeSyntax:              ErrSyntax,
+	dumbdb.ErrorCodeNoSuchTable:         ErrNoSuchTable,
+	dumbdb.ErrorCodeTypeMismatch:        ErrTypeMismatch,
+	dumbdb.ErrorCodeConstraintViolation: ErrConstraintViolation,
+	dumbdb.ErrorCodeTimeout:             ErrTimeout,
+	dumbdb.ErrorCodeCancelled:           ErrCancelled,
+	dumbdb.ErrorCodeAccessDenied:        ErrAccessDenied,
+	dumbdb.ErrorCodeInternal:            ErrInternal,
+}
+
+// Error reported by the server, connection stays usable after it
+type ServerError struct {
+	Message string
+
+	// empty if the server predates error codes
+	Code   dumbdb.ErrorCode
+	Detail string
+
+	// set if the query failed to parse
+	Syntax *dumbdb.SyntaxError
+}
+
+func (e *ServerError) Error() string {
+	return e.Message
+}
+
+// Error matches the sentinel error of its code, e.g. ErrNoSuchTable
+func (e *ServerError) Is(target error) bool {
+	code := e.Code
+	if code == "" && e.Syntax != nil {
+		code = dumbdb.ErrorCodeSyntax
+	}
+	return target != nil && codeErrors[code] == target
+}
+
+// Response reporting the error, as it was received
+func (e *ServerError) response() *dumbdb.Response {
+	return &dumbdb.Response{Error: e.Message, Code: e.Code, Detail: e.Detail, SyntaxError: e.Syntax}
+}
+
+// Error reported in the response, nil if there is none
+func responseError(response *dumbdb.Response) error {
+	if response == nil || response.Error == "" {
+		return nil
+	}
+	return &ServerError{Message: response.Error, Code: response.Code, Detail: response.Detail, Syntax: response.SyntaxError}
+}
+
+type Options struct {
+	// max time to establish a connection, 0 means no limit
+	DialTimeout time.Duration
+
+	// max duration of a query including reading its result, 0 means no limit
+	QueryTimeout time.Duration
+
+	// don't offer compression of large messages to the server
+	DisableCompression bool
+
+	// don't offer binary encoding of rows to the server, they are sent in JSON
+	DisableBinaryRows bool
+
+	// user to connect as and its password, queries are limited to its
+	// privileges if the server enforces access control
+	User     string
+	Password string
+
+	// number of attempts to re-establish the broken connection by the next query,
+	// 0 means a single one. The backoff between them starts at ReconnectBackoff
+	// (DefaultReconnectBackoff if it's 0) and doubles after every attempt
+	ReconnectAttempts int
+	ReconnectBackoff  time.Duration
+
+	// execute queries which only read the database (select, show, ...) once more
+	// if the connection breaks before their result is received. Other queries fail
+	// with ErrConnectionLost, as they might have been executed
+	RetryReadOnly bool
+}
+
+// Connection to the server, not safe for concurrent use
+// If connection breaks, next query establishes a new one with the same
+// database and session variables, see Options.ReconnectAttempts
+type Conn struct {
+	addr string
+	opts Options
+
+	conn   net.Conn // nil if connection is broken
+	busy   bool     // result of the last query is not closed yet
+	closed bool
+
+	// negotiated on every (re)connect
+	handshake dumbdb.Handshake
+
+	// replayed on every reconnect
+	session session
+}
+
+// Connect to the server at addr
+func Connect(ctx context.Context, addr string, opts Options) (*Conn, error) {
+	c := &Conn{
+		addr: addr,
+		opts: opts,
+	}
+
+	err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Conn) dial(ctx context.Context) error {
+	// requests of Conn are never interleaved
+	conn, handshake, err := dial(ctx, c.addr, c.opts, dumbdb.SupportedCapabilities&^dumbdb.CapPipelining)
+	if err != nil {
+		return err
+	}
+
+	for _, sql := range c.session.statements() {
+		err = restoreSession(conn, sql)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to restore session: %w", err)
+		}
+	}
+
+	c.handshake = *handshake
+	c.conn = conn
+	return nil
+}
+
+// Execute statement changing the session on the new connection
+func restoreSession(conn net.Conn, sql string) error {
+	err := dumbdb.SendMessage(conn, []byte(sql))
+	if err != nil {
+		return err
+	}
+
+	response, err := dumbdb.ReceiveResponse(conn)
+	if err != nil {
+		return err
+	}
+	return responseError(response)
+}
+
+// Connect to the server at addr and negotiate the capabilities,
+// returned connection has the deadline of ctx set
+func dial(ctx context.Context, addr string, opts Options, capabilities uint32) (net.Conn, *dumbdb.Handshake, error) {
+	if opts.DialTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.DialTimeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if opts.DisableCompression {
+		capabilities &^= dumbdb.CapCompression
+	}
+	if opts.DisableBinaryRows {
+		capabilities &^= dumbdb.CapBinaryRows
+	}
+
+	handshake, err := negotiate(conn, capabilities, opts.User, opts.Password)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if handshake.Capabilities&dumbdb.CapCompression != 0 {
+		conn = &dumbdb.CompressingConn{Conn: conn, Threshold: dumbdb.DefaultCompressionThreshold}
+	}
+	return conn, handshake, nil
+}
+
+// Offer the protocol version and capabilities to the server
+func negotiate(conn net.Conn, capabilities uint32, user string, password string) (*dumbdb.Handshake, error) {
+	err := dumbdb.SendHandshake(conn, &dumbdb.Handshake{
+		Version:      dumbdb.ProtocolVersion,
+		Capabilities: capabilities,
+		User:         user,
+		Password:     password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := dumbdb.ReceiveResponse(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case response != nil && response.Handshake != nil:
+		return response.Handshake, nil
+	case response != nil && strings.HasPrefix(response.Error, "syntax error"):
+		// server predating the handshake took it for a query
+		return &dumbdb.Handshake{Version: 0, Capabilities: dumbdb.CapChunkedResults}, nil
+	case response != nil && response.Error != "":
+		return nil, responseError(response)
+	default:
+		return nil, errors.New("unexpected reply to the handshake")
+	}
+}
+
+// Protocol version and capabilities agreed on with the server
+func (c *Conn) Handshake() dumbdb.Handshake {
+	return c.handshake
+}
+
+// Drop the connection, so that the next query reconnects
+func (c *Conn) broken() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *Conn) Close() error {
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// State of a single request on the connection
+type request struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// stops goroutine interrupting IO on cancellation
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// Prepare connection for a new request, reconnecting if needed
+func (c *Conn) begin(ctx context.Context) (*request, error) {
+	if c.closed {
+		return nil, ErrConnClosed
+	}
+
+	if c.busy {
+		return nil, ErrBusy
+	}
+
+	if c.conn == nil {
+		err := c.reconnect(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req := &request{
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if c.opts.QueryTimeout != 0 {
+		req.ctx, req.cancel = context.WithTimeout(ctx, c.opts.QueryTimeout)
+	} else {
+		req.ctx, req.cancel = context.WithCancel(ctx)
+	}
+
+	deadline, _ := req.ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+
+	conn := c.conn
+	go func() {
+		defer close(req.stopped)
+		select {
+		case <-req.ctx.Done():
+			// interrupt blocking IO
+			conn.SetDeadline(time.Now())
+		case <-req.stop:
+		}
+	}()
+
+	c.busy = true
+	return req, nil
+}
+
+// Finish the request, err is the IO error (if any) which happened during it
+func (c *Conn) end(req *request, err error) error {
+	close(req.stop)
+	<-req.stopped
+
+	ctxErr := req.ctx.Err()
+	req.cancel()
+	c.busy = false
+
+	if err == nil {
+		return nil
+	}
+
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return err
+	}
+
+	// state of the connection is unknown after IO error
+	c.broken()
+	if ctxErr != nil {
+		return ctxErr
+	}
+	return &ConnectionLostError{Err: err}
+}
+
+// Receive the next response to the request, progress frames are reported
+func (c *Conn) receive(req *request) (*dumbdb.Response, error) {
+	for {
+		response, err := dumbdb.ReceiveResponse(c.conn)
+		if err != nil {
+			return nil, err
+		}
+
+		if response != nil && response.Progress != nil {
+			reportProgress(req.ctx, response.Progress)
+			continue
+		}
+
+		return response, responseError(response)
+	}
+}
+
+// Execute the query, returned rows have to be closed before issuing the next query
+// For statements without result rows are empty and have no schema
+// Queries which only read the database are retried once if the connection breaks
+// before the result is received and Options.RetryReadOnly is set
+func (c *Conn) Query(ctx context.Context, sql string) (*Rows, error) {
+	rows, err := c.query(ctx, sql)
+	if err != nil && c.opts.RetryReadOnly && errors.Is(err, ErrConnectionLost) && readOnly(sql) {
+		rows, err = c.query(ctx, sql)
+	}
+
+	if err == nil {
+		c.session.remember(sql)
+	}
+	return rows, err
+}
+
+func (c *Conn) query(ctx context.Context, sql string) (*Rows, error) {
+	req, err := c.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dumbdb.SendMessage(c.conn, []byte(sql))
+	if err != nil {
+		return nil, c.end(req, err)
+	}
+
+	receive := func() (*dumbdb.Response, error) {
+		return c.receive(req)
+	}
+
+	response, err := receive()
+	if err != nil {
+		return nil, c.end(req, err)
+	}
+
+	if response != nil && response.CopyIn != nil {
+		// the server waits for the rows, which are streamed only by CopyIn()
+		return nil, c.end(req, c.abortCopy(req, "copy from stdin requires a client streaming the rows"))
+	}
+
+	end := func(err error) error {
+		return c.end(req, err)
+	}
+	return newRows(response, receive, end), nil
+}
+
+// Check that the connection is alive, reconnecting if it's broken
+func (c *Conn) Ping(ctx context.Context) error {
+	req, err := c.begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = dumbdb.SendPing(c.conn)
+	if err != nil {
+		return c.end(req, err)
+	}
+
+	// any reply will do, servers predating ping reply with an error
+	_, err = dumbdb.ReceiveResponse(c.conn)
+	return c.end(req, err)
+}
+
+// Execute the statement, discarding its result
+func (c *Conn) Exec(ctx context.Context, sql string) error {
+	rows, err := c.Query(ctx, sql)
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// Execute statements separated by semicolons in a single round trip, returns
+// a response for each executed statement. Once a statement fails the rest are
+// skipped, unless continue_on_error session variable is set.
+// Unlike results of Query(), all the rows are received at once
+func (c *Conn) Batch(ctx context.Context, sql string) ([]dumbdb.Response, error) {
+	statements := dumbdb.SplitStatements(sql)
+
+	// server handles a single statement as a regular query
+	if len(statements) <= 1 {
+		response, err := c.queryAll(ctx, sql)
+		if err != nil {
+			return nil, err
+		}
+		return []dumbdb.Response{*response}, nil
+	}
+
+	req, err := c.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.handshake.Capabilities&dumbdb.CapBatches == 0 {
+		c.end(req, nil)
+		return c.batchByOne(ctx, statements)
+	}
+
+	err = dumbdb.SendMessage(c.conn, []byte(sql))
+	if err != nil {
+		return nil, c.end(req, err)
+	}
+
+	responses, err := dumbdb.ReceiveResponses(c.conn)
+	if err != nil {
+		return nil, c.end(req, err)
+	}
+
+	for i, response := range responses {
+		if response.Error == "" && i < len(statements) {
+			c.session.remember(statements[i])
+		}
+	}
+	return responses, c.end(req, nil)
+}
+
+// Execute statements one by one for servers which don't support batches,
+// stops at the first failed statement
+func (c *Conn) batchByOne(ctx context.Context, statements []string) ([]dumbdb.Response, error) {
+	responses := make([]dumbdb.Response, 0, len(statements))
+	for _, statement := range statements {
+		response, err := c.queryAll(ctx, statement)
+		if err != nil {
+			return nil, err
+		}
+
+		responses = append(responses, *response)
+		if response.Error != "" {
+			break
+		}
+	}
+	return responses, nil
+}
+
+// Execute the query and collect its result into a single response
+func (c *Conn) queryAll(ctx context.Context, sql string) (*dumbdb.Response, error) {
+	rows, err := c.Query(ctx, sql)
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr.response(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := rows.All()
+	if errors.As(err, &serverErr) {
+		return serverErr.response(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response := &dumbdb.Response{LastInsertID: rows.LastInsertID()}
+	if rows.Schema() != nil {
+		if all == nil {
+			all = []dumbdb.Row{}
+		}
+		response.Result = dumbdb.NewResponseChunk(*rows.Schema(), all)
+	}
+	return response, nil
+}
+
+// Result of the query, received from the server in chunks
+type Rows struct {
+	// receives the next chunk, nil once all the chunks are received
+	receive func() (*dumbdb.Response, error)
+	// finishes the request, err is the error which ended it
+	end func(err error) error
+	// called once the request is finished, if set
+	release func()
+
+	schema *dumbdb.Schema
+	chunk  []dumbdb.Row
+	pos    int
+	row    dumbdb.Row
+	err    error
+
+	lastInsertID int64
+}
+
+// Rows starting with the first response to the query
+func newRows(response *dumbdb.Response, receive func() (*dumbdb.Response, error), end func(err error) error) *Rows {
+	rows := &Rows{
+		receive: receive,
+		end:     end,
+	}
+
+	if response == nil || response.Result == nil {
+		if response != nil {
+			rows.lastInsertID = response.LastInsertID
+		}
+		rows.finish(nil)
+		return rows
+	}
+
+	// servers predating column metadata send only the schema
+	schema := response.Result.Schema
+	if len(response.Result.Columns) != 0 {
+		var err error
+		schema, err = dumbdb.SchemaFromColumns(response.Result.Columns)
+		if err != nil {
+			rows.finish(err)
+			return rows
+		}
+	}
+
+	rows.schema = &schema
+	rows.chunk = response.Result.Rows
+	if !response.More {
+		rows.finish(nil)
+	}
+	return rows
+}
+
+func (rows *Rows) finish(err error) {
+	if rows.receive == nil {
+		return
+	}
+
+	rows.err = rows.end(err)
+	rows.receive = nil
+	if rows.release != nil {
+		rows.release()
+		rows.release = nil
+	}
+}
+
+// Columns of the result, nil for statements without result
+func (rows *Rows) Schema() *dumbdb.Schema {
+	return rows.schema
+}
+
+// Description of the columns of the result, nil for statements without result
+func (rows *Rows) Columns() []dumbdb.ColumnMetadata {
+	if rows.schema == nil {
+		return nil
+	}
+	return dumbdb.ColumnsMetadata(rows.schema)
+}
+
+// Value generated for auto-increment column by insert, 0 if there is none
+func (rows *Rows) LastInsertID() int64 {
+	return rows.lastInsertID
+}
+
+// Advance to the next row, receiving the next chunk if needed
+func (rows *Rows) Next() bool {
+	for rows.pos == len(rows.chunk) {
+		if rows.receive == nil {
+			rows.row = nil
+			return false
+		}
+
+		response, err := rows.receive()
+		if err != nil {
+			rows.finish(err)
+			continue
+		}
+
+		if response == nil || response.Result == nil {
+			rows.finish(nil)
+			continue
+		}
+
+		rows.chunk = response.Result.Rows
+		rows.pos = 0
+		if !response.More {
+			rows.finish(nil)
+		}
+	}
+
+	rows.row = rows.chunk[rows.pos]
+	rows.pos++
+	return true
+}
+
+// Current row, valid after Next() returned true
+func (rows *Rows) Row() dumbdb.Row {
+	return rows.row
+}
+
+// Copy columns of the current row into dest, see dumbdb.ScanRow()
+func (rows *Rows) Scan(dest ...interface{}) error {
+	if rows.row == nil {
+		return errors.New("scan called without calling next")
+	}
+	return dumbdb.ScanRow(rows.row, dest...)
+}
+
+// Copy current row into the struct, see dumbdb.Unmarshal()
+func (rows *Rows) ScanStruct(dest interface{}) error {
+	if rows.row == nil {
+		return errors.New("scan called without calling next")
+	}
+	return dumbdb.Unmarshal(rows.row, rows.schema, dest)
+}
+
+// Error which stopped the iteration, valid after Next() returned false
+func (rows *Rows) Err() error {
+	return rows.err
+}
+
+// Read all the remaining rows
+func (rows *Rows) All() ([]dumbdb.Row, error) {
+	var all []dumbdb.Row
+	for rows.Next() {
+		all = append(all, rows.row)
+	}
+	return all, rows.err
+}
+
+// Skip the rest of the result, so that connection can be used for the next query
+func (rows *Rows) Close() error {
+	for rows.receive != nil {
+		rows.chunk = nil
+		rows.pos = 0
+		rows.Next()
+	}
+	return rows.err
+}
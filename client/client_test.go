@@ -0,0 +1,1148 @@
+package client
+
+import (
+	"context"
+	"dumbdb"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serve each connection with handle until it returns error
+func fakeServer(t *testing.T, handle func(conn net.Conn, query string) error) string {
+	return fakeServerWithHandshake(t, true, handle)
+}
+
+// Same as fakeServer(), but server predating the handshake if handshake is false
+func fakeServerWithHandshake(t *testing.T, handshake bool, handle func(conn net.Conn, query string) error) string {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				for {
+					query, err := dumbdb.RecvMessage(conn)
+					if err != nil {
+						return
+					}
+
+					if offer, _ := dumbdb.ParseHandshake(query); offer != nil {
+						response := &dumbdb.Response{Error: "syntax error: unexpected token"}
+						if handshake {
+							response = &dumbdb.Response{Handshake: &dumbdb.Handshake{
+								Version:      dumbdb.ProtocolVersion,
+								Capabilities: offer.Capabilities,
+							}}
+						}
+
+						err = dumbdb.SendResponse(conn, response)
+						if err != nil {
+							return
+						}
+						continue
+					}
+
+					err = handle(conn, string(query))
+					if err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestQuery(t *testing.T) {
+	var schema dumbdb.Schema
+	schema.Fields = []dumbdb.Field{dumbdb.IntField("id")}
+
+	addr := fakeServer(t, func(conn net.Conn, query string) error {
+		switch query {
+		case "select":
+			for i := 0; i < 3; i++ {
+				err := dumbdb.SendResponse(conn, &dumbdb.Response{
+					Result: dumbdb.NewResponseChunk(schema, []dumbdb.Row{{dumbdb.IntValue(int32(i))}}),
+					More:   i != 2,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		case "fail":
+			return dumbdb.SendResponse(conn, dumbdb.ErrorResponse(dumbdb.ErrNoSuchTable))
+		default:
+			return errors.New("drop connection")
+		}
+	})
+
+	conn, err := Connect(context.Background(), addr, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query(context.Background(), "select")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := rows.Columns()
+	if len(columns) != 1 || columns[0] != (dumbdb.ColumnMetadata{Name: "id", Type: "int"}) {
+		t.Fatalf("Unexpected columns %v", columns)
+	}
+
+	sum := 0
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum += id
+	}
+
+	if rows.Err() != nil || sum != 3 {
+		t.Fatalf("Unexpected result: sum %v, err %v", sum, rows.Err())
+	}
+
+	var serverErr *ServerError
+	err = conn.Exec(context.Background(), "fail")
+	if !errors.As(err, &serverErr) {
+		t.Fatalf("Expected server error, got %v", err)
+	}
+	if !errors.Is(err, ErrNoSuchTable) || errors.Is(err, ErrSyntax) || serverErr.Code != dumbdb.ErrorCodeNoSuchTable {
+		t.Fatalf("Expected error matching ErrNoSuchTable, got %v (%v)", err, serverErr.Code)
+	}
+
+	err = conn.Exec(context.Background(), "disconnect")
+	if err == nil || errors.As(err, &serverErr) {
+		t.Fatalf("Expected connection error, got %v", err)
+	}
+
+	// reconnects after connection was dropped
+	rows, err = conn.Query(context.Background(), "select")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := rows.All()
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Unexpected result after reconnect: %v, %v", all, err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	var schema dumbdb.Schema
+	schema.Fields = []dumbdb.Field{dumbdb.IntField("id")}
+
+	addr := fakeServer(t, func(conn net.Conn, query string) error {
+		switch query {
+		case "select":
+			return dumbdb.SendResponse(conn, &dumbdb.Response{
+				Result: &dumbdb.ResponseChunk{
+					Schema: schema,
+					Rows:   []dumbdb.Row{{dumbdb.IntValue(1)}},
+				},
+			})
+		case "insert; select; fail":
+			return dumbdb.SendResponses(conn, []dumbdb.Response{
+				{LastInsertID: 7},
+				{Result: &dumbdb.ResponseChunk{Schema: schema, Rows: []dumbdb.Row{{dumbdb.IntValue(1)}}}},
+				{Error: "no such table"},
+			})
+		default:
+			return errors.New("drop connection")
+		}
+	})
+
+	conn, err := Connect(context.Background(), addr, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	responses, err := conn.Batch(context.Background(), "insert; select; fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(responses) != 3 || responses[0].LastInsertID != 7 ||
+		responses[1].Result == nil || len(responses[1].Result.Rows) != 1 || responses[2].Error == "" {
+		t.Fatalf("Unexpected responses %+v", responses)
+	}
+
+	// single statement is sent as a regular query
+	responses, err = conn.Batch(context.Background(), "select")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(responses) != 1 || responses[0].Result == nil || len(responses[0].Result.Rows) != 1 {
+		t.Fatalf("Unexpected responses %+v", responses)
+	}
+
+	// connection is usable after the batch
+	err = conn.Exec(context.Background(), "select")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacyServer(t *testing.T) {
+	var schema dumbdb.Schema
+	schema.Fields = []dumbdb.Field{dumbdb.IntField("id")}
+
+	addr := fakeServerWithHandshake(t, false, func(conn net.Conn, query string) error {
+		switch query {
+		case "insert":
+			return dumbdb.SendResponse(conn, &dumbdb.Response{LastInsertID: 7})
+		case "select":
+			return dumbdb.SendResponse(conn, &dumbdb.Response{
+				Result: &dumbdb.ResponseChunk{
+					Schema: schema,
+					Rows:   []dumbdb.Row{{dumbdb.IntValue(1)}},
+				},
+			})
+		default:
+			return errors.New("drop connection")
+		}
+	})
+
+	conn, err := Connect(context.Background(), addr, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	handshake := conn.Handshake()
+	if handshake.Version != 0 || handshake.Capabilities&dumbdb.CapBatches != 0 {
+		t.Fatalf("Expected legacy protocol, got %+v", handshake)
+	}
+
+	// batch is split on the client side
+	responses, err := conn.Batch(context.Background(), "insert; select")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(responses) != 2 || responses[0].LastInsertID != 7 || responses[1].Result == nil {
+		t.Fatalf("Unexpected responses %+v", responses)
+	}
+
+	_, err = ConnectPipeline(context.Background(), addr, Options{})
+	if !errors.Is(err, ErrPipeliningNotSupported) {
+		t.Fatalf("Expected ErrPipeliningNotSupported, got %v", err)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	// user and password are sent in the handshake
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				message, err := dumbdb.RecvMessage(conn)
+				if err != nil {
+					return
+				}
+
+				offer, err := dumbdb.ParseHandshake(message)
+				if err != nil || offer == nil {
+					return
+				}
+
+				response := &dumbdb.Response{Handshake: &dumbdb.Handshake{Version: dumbdb.ProtocolVersion}}
+				if offer.User != "alice" || offer.Password != "secret" {
+					response = dumbdb.ErrorResponse(fmt.Errorf("%w: wrong user or password", dumbdb.ErrAccessDenied))
+				}
+				dumbdb.SendResponse(conn, response)
+			}()
+		}
+	}()
+
+	addr := listener.Addr().String()
+	conn, err := Connect(context.Background(), addr, Options{User: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	_, err = Connect(context.Background(), addr, Options{User: "alice", Password: "guess"})
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("Expected ErrAccessDenied, got %v", err)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	var schema dumbdb.Schema
+	schema.Fields = []dumbdb.Field{dumbdb.IntField("id")}
+
+	chunk := func(value int32, more bool) *dumbdb.Response {
+		return &dumbdb.Response{
+			Result: &dumbdb.ResponseChunk{
+				Schema: schema,
+				Rows:   []dumbdb.Row{{dumbdb.IntValue(value)}},
+			},
+			More: more,
+		}
+	}
+
+	// result of "slow" is sent only after "fast" is received and
+	// interleaved with the result of "fast"
+	var sendM sync.Mutex
+	var slowID uint32
+	slowSent := make(chan struct{})
+	addr := fakeServer(t, func(conn net.Conn, message string) error {
+		id, query, err := dumbdb.UntagMessage([]byte(message))
+		if err != nil {
+			return err
+		}
+
+		send := func(id uint32, response *dumbdb.Response) error {
+			return dumbdb.SendResponse(dumbdb.NewRequestConn(conn, id, &sendM), response)
+		}
+
+		switch string(query) {
+		case "slow":
+			slowID = id
+			close(slowSent)
+			return nil
+		case "fast":
+			for _, response := range []struct {
+				id       uint32
+				response *dumbdb.Response
+			}{
+				{slowID, chunk(1, true)},
+				{id, chunk(10, true)},
+				{slowID, chunk(2, false)},
+				{id, chunk(20, false)},
+			} {
+				err = send(response.id, response.response)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		case "fail":
+			return send(id, &dumbdb.Response{Error: "no such table"})
+		default:
+			return errors.New("drop connection")
+		}
+	})
+
+	p, err := ConnectPipeline(context.Background(), addr, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	sum := func(rows *Rows) (int, error) {
+		sum := 0
+		for rows.Next() {
+			var value int
+			err := rows.Scan(&value)
+			if err != nil {
+				return 0, err
+			}
+			sum += value
+		}
+		return sum, rows.Err()
+	}
+
+	type result struct {
+		sum int
+		err error
+	}
+	slow := make(chan result)
+	go func() {
+		rows, err := p.Query(context.Background(), "slow")
+		if err != nil {
+			slow <- result{err: err}
+			return
+		}
+		s, err := sum(rows)
+		slow <- result{s, err}
+	}()
+
+	<-slowSent
+
+	rows, err := p.Query(context.Background(), "fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fast, err := sum(rows)
+	if err != nil || fast != 30 {
+		t.Fatalf("Expected 30, got %v (%v)", fast, err)
+	}
+
+	r := <-slow
+	if r.err != nil || r.sum != 3 {
+		t.Fatalf("Expected 3, got %v (%v)", r.sum, r.err)
+	}
+
+	var serverErr *ServerError
+	_, err = p.Query(context.Background(), "fail")
+	if !errors.As(err, &serverErr) {
+		t.Fatalf("Expected server error, got %v", err)
+	}
+
+	// pipeline is unusable once the connection breaks
+	err = p.Exec(context.Background(), "drop")
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	err = p.Exec(context.Background(), "fail")
+	if err == nil || errors.As(err, &serverErr) {
+		t.Fatalf("Expected connection error, got %v", err)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var schema dumbdb.Schema
+	schema.Fields = []dumbdb.Field{dumbdb.IntField("id")}
+
+	// progress frames precede the first chunk and go between the chunks
+	responses := []*dumbdb.Response{
+		{Progress: &dumbdb.Progress{PagesScanned: 1, PagesTotal: 4}},
+		{Result: &dumbdb.ResponseChunk{Schema: schema, Rows: []dumbdb.Row{{dumbdb.IntValue(1)}}}, More: true},
+		{Progress: &dumbdb.Progress{PagesScanned: 3, PagesTotal: 4}},
+		{Result: &dumbdb.ResponseChunk{Schema: schema, Rows: []dumbdb.Row{{dumbdb.IntValue(2)}}}, More: true},
+		{Result: &dumbdb.ResponseChunk{Schema: schema, Rows: []dumbdb.Row{}}},
+	}
+
+	var sendM sync.Mutex
+	addr := fakeServer(t, func(conn net.Conn, query string) error {
+		if query != "select" {
+			// pipelined request
+			id, _, err := dumbdb.UntagMessage([]byte(query))
+			if err != nil {
+				return err
+			}
+			conn = dumbdb.NewRequestConn(conn, id, &sendM)
+		}
+
+		for _, response := range responses {
+			err := dumbdb.SendResponse(conn, response)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	check := func(query func(ctx context.Context) (*Rows, error)) {
+		var reported []int64
+		ctx := WithProgress(context.Background(), func(progress dumbdb.Progress) {
+			reported = append(reported, progress.PagesScanned)
+		})
+
+		rows, err := query(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		all, err := rows.All()
+		if err != nil || len(all) != 2 {
+			t.Fatalf("Expected 2 rows, got %v (%v)", all, err)
+		}
+
+		if len(reported) != 2 || reported[0] != 1 || reported[1] != 3 {
+			t.Fatalf("Expected progress of 1 and 3 pages, got %v", reported)
+		}
+	}
+
+	conn, err := Connect(context.Background(), addr, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	check(func(ctx context.Context) (*Rows, error) {
+		return conn.Query(ctx, "select")
+	})
+
+	p, err := ConnectPipeline(context.Background(), addr, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	check(func(ctx context.Context) (*Rows, error) {
+		return p.Query(ctx, "select")
+	})
+}
+
+func TestBinaryRows(t *testing.T) {
+	var schema dumbdb.Schema
+	schema.Fields = []dumbdb.Field{dumbdb.IntField("id"), dumbdb.VarcharField("name", 10), dumbdb.FloatField("score")}
+
+	addr := fakeServer(t, func(conn net.Conn, query string) error {
+		chunk := dumbdb.NewResponseChunk(schema, []dumbdb.Row{
+			{dumbdb.IntValue(1), {TypeID: dumbdb.TypeVarchar, Str: "foo\x00\x00"}, dumbdb.FloatValue(1)},
+		})
+		if query == "binary" {
+			err := chunk.EncodeRows()
+			if err != nil {
+				return err
+			}
+		}
+		return dumbdb.SendResponse(conn, &dumbdb.Response{Result: chunk})
+	})
+
+	for _, disable := range []bool{false, true} {
+		conn, err := Connect(context.Background(), addr, Options{DisableBinaryRows: disable})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		if offered := conn.Handshake().Capabilities&dumbdb.CapBinaryRows != 0; offered == disable {
+			t.Fatalf("Expected binary rows to be offered: %v", !disable)
+		}
+
+		query := "json"
+		if !disable {
+			query = "binary"
+		}
+
+		rows, err := conn.Query(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		all, err := rows.All()
+		if err != nil || len(all) != 1 {
+			t.Fatalf("Expected a row, got %v (%v)", all, err)
+		}
+
+		// JSON doesn't preserve the type of the float, nor strips the padding
+		row := all[0]
+		if !disable && (row[0] != dumbdb.IntValue(1) || row[1].Str != "foo" || row[2] != dumbdb.FloatValue(1)) {
+			t.Fatalf("Unexpected row %v", row)
+		}
+	}
+}
+
+func TestCopyIn(t *testing.T) {
+	var schema dumbdb.Schema
+	schema.Fields = []dumbdb.Field{dumbdb.IntField("id"), dumbdb.VarcharField("name", 10)}
+
+	// inserted rows of all the copies, acknowledged as the server does it
+	var m sync.Mutex
+	inserted := 0
+	addr := fakeServer(t, func(conn net.Conn, query string) error {
+		if query == "select" {
+			return dumbdb.SendResponse(conn, &dumbdb.Response{})
+		}
+
+		err := dumbdb.SendResponse(conn, &dumbdb.Response{
+			CopyIn: &dumbdb.CopyInStart{Columns: dumbdb.ColumnsMetadata(&schema), AckRows: 1500},
+		})
+		if err != nil {
+			return err
+		}
+
+		var copied, acked int64
+		failed := false
+		for {
+			message, err := dumbdb.RecvMessage(conn)
+			if err != nil {
+				return err
+			}
+
+			rows, err := dumbdb.ParseCopyMessage(message)
+			done := errors.Is(err, io.EOF) || errors.Is(err, dumbdb.ErrCopyFailed)
+			switch {
+			case failed && done:
+				return nil
+			case failed:
+				continue
+			case errors.Is(err, io.EOF):
+				return dumbdb.SendResponse(conn, &dumbdb.Response{RowsCopied: copied})
+			case err == nil && rows[len(rows)-1][1].Str == "bad":
+				err = dumbdb.ErrConstraintViolation
+			}
+
+			if err != nil {
+				failed = true
+				err = dumbdb.SendResponse(conn, dumbdb.ErrorResponse(err))
+				if err != nil || done {
+					return err
+				}
+				continue
+			}
+
+			m.Lock()
+			inserted += len(rows)
+			m.Unlock()
+
+			copied += int64(len(rows))
+			if copied-acked >= 1500 {
+				acked = copied
+				err = dumbdb.SendResponse(conn, &dumbdb.Response{RowsCopied: acked, More: true})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	conn, err := Connect(context.Background(), addr, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	copyRows := func(n int, bad int) (*CopyWriter, error) {
+		w, err := conn.CopyIn(context.Background(), "users")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < n; i++ {
+			name := fmt.Sprintf("user%v", i)
+			if i == bad {
+				name = "bad"
+			}
+
+			err = w.Write(dumbdb.Row{dumbdb.IntValue(int32(i)), {TypeID: dumbdb.TypeVarchar, Str: name}})
+			if err != nil {
+				return w, err
+			}
+		}
+		return w, nil
+	}
+
+	w, err := copyRows(4500, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.Columns()) != 2 || w.Acknowledged() != 4000 {
+		t.Fatalf("Expected 4000 rows acknowledged, got %v", w.Acknowledged())
+	}
+
+	n, err := w.Close()
+	m.Lock()
+	total := inserted
+	m.Unlock()
+	if err != nil || n != 4500 || total != 4500 {
+		t.Fatalf("Expected 4500 rows, got %v, %v inserted (%v)", n, total, err)
+	}
+
+	// the rejected batch is reported once the server should acknowledge it
+	w, err = copyRows(10000, 2999)
+	if !errors.Is(err, ErrConstraintViolation) || w.Acknowledged() != 2000 {
+		t.Fatalf("Expected ErrConstraintViolation after 2000 rows, got %v (%v)", w.Acknowledged(), err)
+	}
+
+	_, err = w.Close()
+	if !errors.Is(err, ErrConstraintViolation) {
+		t.Fatalf("Expected closed copy to return its error, got %v", err)
+	}
+
+	w, err = copyRows(10, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Abort("changed my mind")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection is usable after all of them
+	err = conn.Exec(context.Background(), "select")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := fakeServerWithHandshake(t, false, func(conn net.Conn, query string) error {
+		return errors.New("unexpected query")
+	})
+
+	conn, err = Connect(context.Background(), legacy, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.CopyIn(context.Background(), "users")
+	if !errors.Is(err, ErrCopyInNotSupported) {
+		t.Fatalf("Expected ErrCopyInNotSupported, got %v", err)
+	}
+}
+
+func TestInsertBatch(t *testing.T) {
+	var schema dumbdb.Schema
+	schema.Fields = []dumbdb.Field{dumbdb.IntField("id"), dumbdb.VarcharField("name", 10)}
+
+	// rows named "bad" fail, a batch with a row named "drop" fails as a whole
+	addr := fakeServer(t, func(conn net.Conn, query string) error {
+		if query == "select" {
+			return dumbdb.SendResponse(conn, &dumbdb.Response{})
+		}
+
+		err := dumbdb.SendResponse(conn, &dumbdb.Response{
+			CopyIn: &dumbdb.CopyInStart{Columns: dumbdb.ColumnsMetadata(&schema), AckRows: dumbdb.DefaultCopyAckRows},
+		})
+		if err != nil {
+			return err
+		}
+
+		var copied int64
+		failed := false
+		for {
+			message, err := dumbdb.RecvMessage(conn)
+			if err != nil {
+				return err
+			}
+
+			rows, err := dumbdb.ParseCopyMessage(message)
+			switch {
+			case errors.Is(err, io.EOF) && failed:
+				return nil
+			case failed:
+				continue
+			case errors.Is(err, io.EOF):
+				return dumbdb.SendResponse(conn, &dumbdb.Response{RowsCopied: copied})
+			case err != nil:
+				return err
+			case !dumbdb.IsCopyBatch(message):
+				return errors.New("expected batch of rows")
+			}
+
+			statuses := make([]dumbdb.RowStatus, len(rows))
+			for i, row := range rows {
+				switch row[1].Str {
+				case "drop":
+					failed = true
+				case "bad":
+					statuses[i].Err = dumbdb.ErrConstraintViolation
+				case "unindexed":
+					statuses[i] = dumbdb.RowStatus{Inserted: true, Err: dumbdb.ErrIndexDropped}
+				default:
+					statuses[i].Inserted = true
+				}
+			}
+			if failed {
+				err = dumbdb.SendResponse(conn, dumbdb.ErrorResponse(dumbdb.ErrNoSuchTable))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			copied += int64(dumbdb.CountInserted(statuses))
+			err = dumbdb.SendResponse(conn, &dumbdb.Response{RowsCopied: copied, More: true, RowErrors: dumbdb.RowErrors(statuses)})
+			if err != nil {
+				return err
+			}
+		}
+	})
+
+	conn, err := Connect(context.Background(), addr, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	insert := func(n int, names map[int]string) ([]dumbdb.RowStatus, error) {
+		rows := make([]dumbdb.Row, 0, n)
+		for i := 0; i < n; i++ {
+			name, ok := names[i]
+			if !ok {
+				name = fmt.Sprintf("user%v", i)
+			}
+			rows = append(rows, dumbdb.Row{dumbdb.IntValue(int32(i)), {TypeID: dumbdb.TypeVarchar, Str: name}})
+		}
+		return conn.InsertBatch(context.Background(), "users", rows)
+	}
+
+	// rows which fail are reported in all the batches
+	statuses, err := insert(2500, map[int]string{10: "bad", 1500: "bad", 2000: "unindexed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, status := range statuses {
+		if (i == 10 || i == 1500) != errors.Is(status.Err, ErrConstraintViolation) {
+			t.Fatalf("Unexpected status of row %v: %v", i, status.Err)
+		}
+	}
+	if !statuses[2000].Inserted || statuses[2000].Err == nil {
+		t.Fatalf("Expected row inserted with an error, got %+v", statuses[2000])
+	}
+	if dumbdb.CountInserted(statuses) != 2498 {
+		t.Fatalf("Expected 2498 rows inserted, got %v", dumbdb.CountInserted(statuses))
+	}
+
+	// rows of the failed batch and the ones after it fail with its error
+	statuses, err = insert(2500, map[int]string{1200: "drop"})
+	if !errors.Is(err, ErrNoSuchTable) {
+		t.Fatalf("Expected ErrNoSuchTable, got %v", err)
+	}
+	if len(statuses) != 2500 || !statuses[999].Inserted || !errors.Is(statuses[1000].Err, ErrNoSuchTable) || !errors.Is(statuses[2499].Err, ErrNoSuchTable) {
+		t.Fatalf("Expected the first 1000 rows inserted, got %v", dumbdb.CountInserted(statuses))
+	}
+
+	// the connection is usable after both of them
+	err = conn.Exec(context.Background(), "select")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := fakeServerWithHandshake(t, false, func(conn net.Conn, query string) error {
+		return errors.New("unexpected query")
+	})
+
+	conn, err = Connect(context.Background(), legacy, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.InsertBatch(context.Background(), "users", nil)
+	if !errors.Is(err, ErrInsertBatchNotSupported) {
+		t.Fatalf("Expected ErrInsertBatchNotSupported, got %v", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	var schema dumbdb.Schema
+	schema.Fields = []dumbdb.Field{dumbdb.VarcharField("database", 10)}
+
+	var m sync.Mutex
+	databases := make(map[net.Conn]string)
+	// number of the next queries dropping the connection
+	drops := 0
+	addr := fakeServer(t, func(conn net.Conn, query string) error {
+		m.Lock()
+		defer m.Unlock()
+
+		if drops > 0 {
+			drops--
+			return errors.New("drop connection")
+		}
+
+		switch query {
+		case "use db1":
+			databases[conn] = "db1"
+			return dumbdb.SendResponse(conn, &dumbdb.Response{})
+		case "select * from t":
+			return dumbdb.SendResponse(conn, &dumbdb.Response{
+				Result: dumbdb.NewResponseChunk(schema, []dumbdb.Row{{{TypeID: dumbdb.TypeVarchar, Str: databases[conn]}}}),
+			})
+		case "insert into t values (\"foo\")":
+			return dumbdb.SendResponse(conn, &dumbdb.Response{})
+		default:
+			return errors.New("unexpected query")
+		}
+	})
+
+	conn, err := Connect(context.Background(), addr, Options{RetryReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	err = conn.Exec(context.Background(), "use db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Lock()
+	drops = 1
+	m.Unlock()
+
+	// select is retried on the new connection using the same database
+	rows, err := conn.Query(context.Background(), "select * from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := rows.All()
+	if err != nil || len(all) != 1 || all[0][0].StrVal() != "db1" {
+		t.Fatalf("Expected the select to be retried in db1, got %v (%v)", all, err)
+	}
+
+	m.Lock()
+	drops = 1
+	m.Unlock()
+
+	var lost *ConnectionLostError
+	err = conn.Exec(context.Background(), "insert into t values (\"foo\")")
+	if !errors.Is(err, ErrConnectionLost) || !errors.As(err, &lost) {
+		t.Fatalf("Expected ErrConnectionLost, got %v", err)
+	}
+
+	err = conn.Exec(context.Background(), "insert into t values (\"foo\")")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server is gone, reconnect gives up after all the attempts
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+
+	backoff := 20 * time.Millisecond
+	conn = &Conn{addr: listener.Addr().String(), opts: Options{ReconnectAttempts: 3, ReconnectBackoff: backoff}}
+	start := time.Now()
+	err = conn.Exec(context.Background(), "select * from t")
+	if err == nil || errors.Is(err, ErrConnectionLost) || time.Since(start) < 3*backoff {
+		t.Fatalf("Expected dial error after 3 attempts, got %v in %v", err, time.Since(start))
+	}
+}
+
+func TestPool(t *testing.T) {
+	var m sync.Mutex
+	var conns []net.Conn
+	active, maxActive, pings := 0, 0, 0
+	addr := fakeServer(t, func(conn net.Conn, query string) error {
+		m.Lock()
+		if dumbdb.IsPing([]byte(query)) {
+			pings++
+			m.Unlock()
+			return dumbdb.SendResponse(conn, &dumbdb.Response{})
+		}
+
+		conns = append(conns, conn)
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		m.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		m.Lock()
+		active--
+		m.Unlock()
+		return dumbdb.SendResponse(conn, &dumbdb.Response{})
+	})
+
+	pool := NewPool(addr, PoolOptions{MaxOpen: 3})
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- pool.Exec(context.Background(), "insert")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := pool.Stats()
+	if maxActive != 3 || stats.Open != 2 || stats.Idle != 2 {
+		t.Fatalf("Expected 3 queries at once and 2 idle connections, got %v and %+v", maxActive, stats)
+	}
+
+	// waits for a connection, while all of them are in use
+	held := make([]*Conn, 0, 3)
+	for i := 0; i < 3; i++ {
+		conn, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, conn)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := pool.Get(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || pool.Stats().Waiting != 0 {
+		t.Fatalf("Expected Get to time out, got %v", err)
+	}
+
+	got := make(chan *Conn)
+	go func() {
+		conn, _ := pool.Get(context.Background())
+		got <- conn
+	}()
+
+	for pool.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	pool.Put(held[0])
+	if conn := <-got; conn != held[0] {
+		t.Fatal("Expected the returned connection to be handed to the waiter")
+	}
+	for _, conn := range held {
+		pool.Put(conn)
+	}
+
+	// idle connections are pinged before they're used
+	pool.opts.HealthCheckInterval = time.Nanosecond
+	err = pool.Exec(context.Background(), "insert")
+	m.Lock()
+	if err != nil || pings != 1 {
+		t.Fatalf("Expected the idle connection to be pinged, got %v pings (%v)", pings, err)
+	}
+
+	// broken ones are replaced once the health check fails
+	for _, conn := range conns {
+		conn.Close()
+	}
+	m.Unlock()
+
+	err = pool.Exec(context.Background(), "insert")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool.Close()
+	_, err = pool.Get(context.Background())
+	if !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("Expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestPages(t *testing.T) {
+	catalog, err := dumbdb.OpenCatalog(t.TempDir(), dumbdb.StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+
+	execute := func(sql string) (*dumbdb.Result, error) {
+		q, err := dumbdb.ParseQuery(sql)
+		if err != nil {
+			return nil, err
+		}
+		return catalog.Execute(context.Background(), q)
+	}
+
+	for _, sql := range []string{
+		"create table users (id int, name varchar(10))",
+		"create index users_id on users (id)",
+		"insert into users values (3, \"c\"), (1, \"a\"), (5, \"e\"), (2, \"b\"), (4, \"d\"), (6, \"f\"), (7, \"g\")",
+	} {
+		_, err := execute(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var m sync.Mutex
+	var queries []string
+	addr := fakeServer(t, func(conn net.Conn, query string) error {
+		m.Lock()
+		queries = append(queries, query)
+		m.Unlock()
+
+		result, err := execute(query)
+		if err != nil {
+			return dumbdb.SendResponse(conn, dumbdb.ErrorResponse(err))
+		}
+
+		rows, err := result.Rows.All()
+		if err != nil {
+			return dumbdb.SendResponse(conn, dumbdb.ErrorResponse(err))
+		}
+		return dumbdb.SendResponse(conn, &dumbdb.Response{Result: dumbdb.NewResponseChunk(result.Schema, rows)})
+	})
+
+	conn, err := Connect(context.Background(), addr, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	read := func(q PageQuery) ([]string, error) {
+		var pages []string
+		it := conn.Pages(q)
+		for it.Next(context.Background()) {
+			var names []string
+			for _, row := range it.Rows() {
+				names = append(names, row[1].String())
+			}
+			pages = append(pages, fmt.Sprint(names))
+		}
+		return pages, it.Err()
+	}
+
+	pages, err := read(PageQuery{Select: "select id, name from users", Where: "name != \"d\"", Key: "id", Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(pages) != "[[a b] [c e] [f g]]" {
+		t.Fatalf("Unexpected pages %v", pages)
+	}
+
+	expected := []string{
+		"select id, name from users where (name != \"d\") order by id limit 2",
+		"select id, name from users where (name != \"d\") and id > 2 order by id limit 2",
+		"select id, name from users where (name != \"d\") and id > 5 order by id limit 2",
+		"select id, name from users where (name != \"d\") and id > 7 order by id limit 2",
+	}
+	m.Lock()
+	if fmt.Sprintf("%q", queries) != fmt.Sprintf("%q", expected) {
+		t.Fatalf("Unexpected queries %q", queries)
+	}
+	queries = nil
+	m.Unlock()
+
+	// pages are ordered by strings as well, the short page is the last one
+	pages, err = read(PageQuery{Select: "select id, name from users u", Key: "u.name", Size: 3})
+	if err != nil || fmt.Sprint(pages) != "[[a b c] [d e f] [g]]" {
+		t.Fatalf("Unexpected pages %v, %v", pages, err)
+	}
+
+	m.Lock()
+	if len(queries) != 3 || queries[2] != "select id, name from users u where u.name > \"f\" order by u.name limit 3" {
+		t.Fatalf("Unexpected queries %q", queries)
+	}
+	m.Unlock()
+
+	_, err = read(PageQuery{Select: "select name from users", Key: "id"})
+	if err == nil {
+		t.Fatal("Expected error of the key which isn't selected")
+	}
+
+	_, err = read(PageQuery{Select: "select id from nothing", Key: "id"})
+	if !errors.Is(err, ErrNoSuchTable) {
+		t.Fatalf("Expected ErrNoSuchTable, got %v", err)
+	}
+}
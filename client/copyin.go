@@ -0,0 +1,288 @@
+package client
+
+import (
+	"context"
+	"dumbdb"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrCopyInNotSupported = errors.New("server doesn't support copy from stdin")
+	ErrCopyClosed         = errors.New("copy is closed")
+
+	ErrInsertBatchNotSupported = errors.New("server doesn't support insert batches")
+)
+
+// Rows streamed into a table, see Conn.CopyIn(). The connection can't be used
+// for other queries until the copy is closed or aborted
+type CopyWriter struct {
+	c   *Conn
+	req *request
+
+	columns []dumbdb.ColumnMetadata
+	ackRows int64
+
+	batch []dumbdb.Row
+	// rows sent so far and acknowledged by the server
+	sent  int64
+	acked int64
+
+	// set once the request is over
+	closed bool
+	err    error
+}
+
+// Start copy of the rows into the table, they are sent in batches of
+// dumbdb.CopyBatchSize rows and inserted by the server as they arrive, which is
+// much faster than separate inserts. The table is a part of the statement,
+// so it's quoted as in SQL if needed
+func (c *Conn) CopyIn(ctx context.Context, table string) (*CopyWriter, error) {
+	return c.startCopy(ctx, table, dumbdb.CapCopyIn, ErrCopyInNotSupported)
+}
+
+// Start copy of the rows into the table, unsupported is returned unless the
+// server supports all the capabilities
+func (c *Conn) startCopy(ctx context.Context, table string, capabilities uint32, unsupported error) (*CopyWriter, error) {
+	req, err := c.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// capabilities are known only once the connection is (re)established
+	if c.handshake.Capabilities&capabilities != capabilities {
+		c.end(req, nil)
+		return nil, unsupported
+	}
+
+	err = dumbdb.SendMessage(c.conn, []byte("copy "+table+" from stdin"))
+	if err != nil {
+		return nil, c.end(req, err)
+	}
+
+	response, err := c.receive(req)
+	if err == nil && (response == nil || response.CopyIn == nil) {
+		err = errors.New("unexpected reply to copy from stdin")
+	}
+	if err != nil {
+		return nil, c.end(req, err)
+	}
+
+	return &CopyWriter{
+		c:       c,
+		req:     req,
+		columns: response.CopyIn.Columns,
+		ackRows: int64(response.CopyIn.AckRows),
+		batch:   make([]dumbdb.Row, 0, dumbdb.CopyBatchSize),
+	}, nil
+}
+
+// Columns of the table, rows have values for all of them in this order
+func (w *CopyWriter) Columns() []dumbdb.ColumnMetadata {
+	return w.columns
+}
+
+// Number of rows acknowledged by the server so far, i.e. the ones which are inserted
+func (w *CopyWriter) Acknowledged() int64 {
+	return w.acked
+}
+
+// Queue the rows to be sent, a full batch is sent right away. Once the server
+// rejects a batch, the copy is over, the error is returned when the server is
+// expected to acknowledge the rows, at the latest by Close()
+func (w *CopyWriter) Write(rows ...dumbdb.Row) error {
+	if w.closed {
+		return w.closedErr()
+	}
+
+	for _, row := range rows {
+		w.batch = append(w.batch, row)
+		if len(w.batch) == dumbdb.CopyBatchSize {
+			err := w.flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *CopyWriter) closedErr() error {
+	if w.err != nil {
+		return w.err
+	}
+	return ErrCopyClosed
+}
+
+// Send the queued rows, waits for the acknowledgement if the server sends one after them
+func (w *CopyWriter) flush() error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+
+	err := dumbdb.SendCopyData(w.c.conn, w.batch)
+	if err != nil {
+		return w.finish(err)
+	}
+
+	w.sent += int64(len(w.batch))
+	w.batch = w.batch[:0]
+
+	// the server acknowledges rows the same way, see dumbdb.CopyInStart
+	if w.sent-w.acked < w.ackRows {
+		return nil
+	}
+
+	response, err := w.c.receive(w.req)
+	if err == nil && (response == nil || !response.More) {
+		err = errors.New("unexpected reply to copied rows")
+	}
+	if err != nil {
+		return w.fail(err)
+	}
+
+	w.acked = response.RowsCopied
+	return nil
+}
+
+// Finish the request once the reply to a batch failed
+func (w *CopyWriter) fail(err error) error {
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		// the server skips the rest of the stream until it's done
+		sendErr := dumbdb.SendCopyDone(w.c.conn)
+		if sendErr != nil {
+			err = sendErr
+		}
+	}
+	return w.finish(err)
+}
+
+// Finish the request, err is the error which ended it
+func (w *CopyWriter) finish(err error) error {
+	w.closed = true
+	w.err = w.c.end(w.req, err)
+	return w.err
+}
+
+// Send the rest of the rows and finish the copy, returns the number of inserted rows
+func (w *CopyWriter) Close() (int64, error) {
+	if w.closed {
+		return w.acked, w.closedErr()
+	}
+
+	err := w.flush()
+	if err != nil {
+		return w.acked, err
+	}
+
+	err = dumbdb.SendCopyDone(w.c.conn)
+	if err != nil {
+		return w.acked, w.finish(err)
+	}
+
+	response, err := w.c.receive(w.req)
+	if err == nil && (response == nil || response.More) {
+		err = errors.New("unexpected reply to the end of copy")
+	}
+	if err != nil {
+		return w.acked, w.finish(err)
+	}
+
+	w.acked = response.RowsCopied
+	return w.acked, w.finish(nil)
+}
+
+// Insert the rows into the table by copy from stdin, but unlike CopyIn() rows
+// which fail don't stop the others, see dumbdb.Table.InsertBatch(). Returns
+// status of each row, errors of the rows are *ServerError. If the copy fails as
+// a whole, the error is returned as well and the rows of the batches which
+// weren't inserted by then fail with it
+func (c *Conn) InsertBatch(ctx context.Context, table string, rows []dumbdb.Row) ([]dumbdb.RowStatus, error) {
+	w, err := c.startCopy(ctx, table, dumbdb.CapCopyIn|dumbdb.CapInsertBatch, ErrInsertBatchNotSupported)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]dumbdb.RowStatus, len(rows))
+	for start := 0; start < len(rows); start += dumbdb.CopyBatchSize {
+		end := start + dumbdb.CopyBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		err = w.sendBatch(rows[start:end], statuses[start:end])
+		if err != nil {
+			for i := start; i < len(rows); i++ {
+				statuses[i] = dumbdb.RowStatus{Err: err}
+			}
+			return statuses, err
+		}
+	}
+
+	_, err = w.Close()
+	return statuses, err
+}
+
+// Send the rows with dumbdb.SendCopyBatch() and set their statuses from the reply
+func (w *CopyWriter) sendBatch(rows []dumbdb.Row, statuses []dumbdb.RowStatus) error {
+	err := dumbdb.SendCopyBatch(w.c.conn, rows)
+	if err != nil {
+		return w.finish(err)
+	}
+
+	response, err := w.c.receive(w.req)
+	if err == nil && (response == nil || !response.More) {
+		err = errors.New("unexpected reply to batch of rows")
+	}
+	if err != nil {
+		return w.fail(err)
+	}
+
+	for i := range statuses {
+		statuses[i].Inserted = true
+	}
+	for _, rowErr := range response.RowErrors {
+		if rowErr.Row < 0 || rowErr.Row >= len(rows) {
+			return w.finish(fmt.Errorf("reply to batch of %v rows refers to row %v", len(rows), rowErr.Row))
+		}
+		statuses[rowErr.Row] = dumbdb.RowStatus{
+			Inserted: rowErr.Inserted,
+			Err:      &ServerError{Message: rowErr.Error, Code: rowErr.Code},
+		}
+	}
+
+	w.sent += int64(len(rows))
+	w.acked = response.RowsCopied
+	return nil
+}
+
+// Give up the copy, queued rows are discarded, but the rows sent so far stay
+// inserted, at least the acknowledged ones
+func (w *CopyWriter) Abort(reason string) error {
+	if w.closed {
+		return nil
+	}
+
+	err := w.c.abortCopy(w.req, reason)
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		err = nil
+	}
+	return w.finish(err)
+}
+
+// Abort stream of copy from stdin, the server replies with an error, either
+// to the abort or to a batch it rejected before
+func (c *Conn) abortCopy(req *request, reason string) error {
+	err := dumbdb.SendCopyFail(c.conn, reason)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.receive(req)
+	if err == nil {
+		err = errors.New("unexpected reply to aborted copy")
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"dumbdb"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const DefaultPageSize = 1000
+
+// Select read page by page with keyset pagination: each page is selected by a
+// separate query for the rows with keys after the last key of the previous
+// page. Unlike offset, which skips the rows of all the previous pages, each
+// page costs the same, if the key is the first column of an index
+type PageQuery struct {
+	// select without the where clause, e.g. "select id, name from users"
+	Select string
+	// condition of the where clause, empty if all the rows are selected
+	Where string
+	// column the pages are ordered by, it has to be selected without an alias and
+	// its values have to be unique, otherwise rows with the same key are skipped
+	Key string
+	// rows per page, DefaultPageSize if 0
+	Size int
+}
+
+// Iterator over the pages, see Conn.Pages()
+type Pages struct {
+	query func(ctx context.Context, sql string) (*Rows, error)
+	q     PageQuery
+
+	schema *dumbdb.Schema
+	rows   []dumbdb.Row
+	// key of the last row read so far, nil before the first page
+	last *dumbdb.Value
+	done bool
+	err  error
+}
+
+// Read the select page by page, the connection is free between the pages
+func (c *Conn) Pages(q PageQuery) *Pages {
+	return newPages(c.Query, q)
+}
+
+// Read the select page by page, each page is read by a connection of the pool
+func (p *Pool) Pages(q PageQuery) *Pages {
+	return newPages(p.Query, q)
+}
+
+func newPages(query func(ctx context.Context, sql string) (*Rows, error), q PageQuery) *Pages {
+	if q.Size == 0 {
+		q.Size = DefaultPageSize
+	}
+	return &Pages{query: query, q: q}
+}
+
+// Format the value as a constant of the query
+func literal(val *dumbdb.Value) string {
+	switch val.TypeID {
+	case dumbdb.TypeVarchar:
+		return strconv.Quote(val.StrVal())
+	case dumbdb.TypeFloat:
+		// floats are recognized by the decimal point, exponents aren't supported
+		s := strconv.FormatFloat(val.Float, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	default:
+		return val.String()
+	}
+}
+
+// Query selecting the next page
+func (pages *Pages) nextQuery() string {
+	var conditions []string
+	if pages.q.Where != "" {
+		conditions = append(conditions, "("+pages.q.Where+")")
+	}
+	if pages.last != nil {
+		conditions = append(conditions, pages.q.Key+" > "+literal(pages.last))
+	}
+
+	var sql strings.Builder
+	sql.WriteString(pages.q.Select)
+	if len(conditions) != 0 {
+		sql.WriteString(" where " + strings.Join(conditions, " and "))
+	}
+	fmt.Fprintf(&sql, " order by %v limit %v", pages.q.Key, pages.q.Size)
+	return sql.String()
+}
+
+// Index of the key in the columns of the result, it's the name of the column
+// without the table it can be qualified with
+func (pages *Pages) keyIndex() (int, error) {
+	name := pages.q.Key
+	if i := strings.LastIndexByte(name, '.'); i != -1 {
+		name = name[i+1:]
+	}
+
+	// unquoted identifiers are case-insensitive
+	if strings.HasPrefix(name, "`") {
+		name = strings.Trim(name, "`")
+	} else {
+		name = strings.ToLower(name)
+	}
+
+	idx, _ := pages.schema.GetField(name)
+	if idx == -1 {
+		return -1, fmt.Errorf("key column %v is not selected", pages.q.Key)
+	}
+	return idx, nil
+}
+
+// Select the next page, returns false once there are no more rows or the query fails
+func (pages *Pages) Next(ctx context.Context) bool {
+	pages.rows = nil
+	if pages.done {
+		return false
+	}
+
+	if pages.q.Size < 0 {
+		return pages.fail(errors.New("page size should be positive"))
+	}
+
+	rows, err := pages.query(ctx, pages.nextQuery())
+	if err != nil {
+		return pages.fail(err)
+	}
+
+	all, err := rows.All()
+	if err != nil {
+		return pages.fail(err)
+	}
+
+	pages.schema = rows.Schema()
+	if pages.schema == nil {
+		return pages.fail(errors.New("query of the page doesn't return rows"))
+	}
+
+	// a short page is the last one
+	pages.done = len(all) < pages.q.Size
+	if len(all) == 0 {
+		return false
+	}
+
+	idx, err := pages.keyIndex()
+	if err != nil {
+		return pages.fail(err)
+	}
+
+	pages.rows = all
+	pages.last = &all[len(all)-1][idx]
+	return true
+}
+
+func (pages *Pages) fail(err error) bool {
+	pages.err = err
+	pages.done = true
+	return false
+}
+
+// Rows of the current page, valid after Next() returned true
+func (pages *Pages) Rows() []dumbdb.Row {
+	return pages.rows
+}
+
+// Columns of the pages, nil before the first page
+func (pages *Pages) Schema() *dumbdb.Schema {
+	return pages.schema
+}
+
+// Error which stopped the iteration, valid after Next() returned false
+func (pages *Pages) Err() error {
+	return pages.err
+}
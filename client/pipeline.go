@@ -0,0 +1,270 @@
+package client
+
+import (
+	"context"
+	"dumbdb"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrPipeliningNotSupported = errors.New("server doesn't support pipelining")
+
+// Connection to the server running several queries at once, safe for concurrent use.
+// Queries are tagged with request ids and chunks of their results are interleaved.
+// Unlike Conn, it doesn't reconnect once the connection breaks
+type Pipeline struct {
+	conn      net.Conn
+	handshake dumbdb.Handshake
+	opts      Options
+
+	// serializes messages of different requests
+	sendM sync.Mutex
+
+	// protects the fields below
+	m       sync.Mutex
+	nextID  uint32
+	pending map[uint32]*pipelineRequest
+	err     error // connection is unusable once set
+	closed  bool
+
+	// closed once the reader goroutine exits
+	done chan struct{}
+}
+
+// Request waiting for (the rest of) its responses
+type pipelineRequest struct {
+	// received, but not consumed yet, unbounded so that a slow
+	// consumer doesn't block responses to other requests
+	responses []*dumbdb.Response
+	// signalled when responses are appended or connection breaks
+	ready chan struct{}
+	// remaining responses are discarded
+	abandoned bool
+}
+
+// Connect to the server at addr, fails with ErrPipeliningNotSupported
+// if the server can't run queries of a single connection concurrently
+func ConnectPipeline(ctx context.Context, addr string, opts Options) (*Pipeline, error) {
+	conn, handshake, err := dial(ctx, addr, opts, dumbdb.SupportedCapabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	if handshake.Capabilities&dumbdb.CapPipelining == 0 {
+		conn.Close()
+		return nil, ErrPipeliningNotSupported
+	}
+
+	// requests have their own deadlines
+	conn.SetDeadline(time.Time{})
+
+	p := &Pipeline{
+		conn:      conn,
+		handshake: *handshake,
+		opts:      opts,
+		pending:   make(map[uint32]*pipelineRequest),
+		done:      make(chan struct{}),
+	}
+	go p.read()
+	return p, nil
+}
+
+// Protocol version and capabilities agreed on with the server
+func (p *Pipeline) Handshake() dumbdb.Handshake {
+	return p.handshake
+}
+
+// Route responses to the requests they belong to
+func (p *Pipeline) read() {
+	defer close(p.done)
+
+	for {
+		message, err := dumbdb.RecvMessage(p.conn)
+		if err != nil {
+			p.fail(err)
+			return
+		}
+
+		id, payload, err := dumbdb.UntagMessage(message)
+		if err != nil {
+			p.fail(err)
+			return
+		}
+
+		response, err := dumbdb.DecodeResponse(payload)
+		if err != nil {
+			p.fail(err)
+			return
+		}
+
+		p.m.Lock()
+		req, ok := p.pending[id]
+		if ok {
+			if !req.abandoned {
+				req.responses = append(req.responses, response)
+				notify(req.ready)
+			}
+
+			// progress frames are followed by the rest of the response
+			final := response == nil || response.Progress == nil && (response.Result == nil || !response.More)
+			if final {
+				delete(p.pending, id)
+			}
+		}
+		p.m.Unlock()
+	}
+}
+
+func notify(ready chan struct{}) {
+	select {
+	case ready <- struct{}{}:
+	default:
+	}
+}
+
+// Mark connection as broken, waking up all the pending requests
+func (p *Pipeline) fail(err error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.err == nil {
+		p.err = err
+		if p.closed {
+			p.err = ErrConnClosed
+		}
+	}
+
+	for _, req := range p.pending {
+		notify(req.ready)
+	}
+}
+
+func (p *Pipeline) register() (uint32, *pipelineRequest, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.err != nil {
+		return 0, nil, p.err
+	}
+
+	id := p.nextID
+	p.nextID++
+
+	req := &pipelineRequest{
+		ready: make(chan struct{}, 1),
+	}
+	p.pending[id] = req
+	return id, req, nil
+}
+
+// Discard the rest of the responses to the request
+func (p *Pipeline) abandon(req *pipelineRequest) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	req.abandoned = true
+	req.responses = nil
+}
+
+// Wait for the next response to the request, progress frames are reported
+func (p *Pipeline) receive(ctx context.Context, req *pipelineRequest) (*dumbdb.Response, error) {
+	for {
+		p.m.Lock()
+		if len(req.responses) > 0 {
+			response := req.responses[0]
+			req.responses = req.responses[1:]
+			p.m.Unlock()
+
+			if response != nil && response.Progress != nil {
+				reportProgress(ctx, response.Progress)
+				continue
+			}
+			return response, responseError(response)
+		}
+		err := p.err
+		p.m.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-req.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Execute the query, it runs concurrently with the other queries of the pipeline.
+// Statements changing the session (use, set) wait for the queries sent before
+// them, so the ones sent after them see the change. Result rows should be closed,
+// otherwise chunks of the result are kept in memory until the pipeline is closed
+func (p *Pipeline) Query(ctx context.Context, sql string) (*Rows, error) {
+	if len(dumbdb.SplitStatements(sql)) > 1 {
+		return nil, errors.New("pipeline can't execute batches of statements, use Conn.Batch()")
+	}
+
+	id, req, err := p.register()
+	if err != nil {
+		return nil, err
+	}
+
+	var cancel context.CancelFunc
+	if p.opts.QueryTimeout != 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.opts.QueryTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	end := func(err error) error {
+		cancel()
+		if err != nil {
+			p.abandon(req)
+		}
+		return err
+	}
+
+	err = dumbdb.SendMessage(dumbdb.NewRequestConn(p.conn, id, &p.sendM), []byte(sql))
+	if err != nil {
+		// state of the connection is unknown after a failed write
+		p.conn.Close()
+		return nil, end(err)
+	}
+
+	response, err := p.receive(ctx, req)
+	if err != nil {
+		return nil, end(err)
+	}
+
+	receive := func() (*dumbdb.Response, error) {
+		return p.receive(ctx, req)
+	}
+	return newRows(response, receive, end), nil
+}
+
+// Execute the statement, discarding its result
+func (p *Pipeline) Exec(ctx context.Context, sql string) error {
+	rows, err := p.Query(ctx, sql)
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// Close the connection, pending queries fail with ErrConnClosed
+func (p *Pipeline) Close() error {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.m.Unlock()
+
+	err := p.conn.Close()
+	<-p.done
+	return err
+}
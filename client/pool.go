@@ -0,0 +1,295 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrPoolClosed = errors.New("pool is closed")
+
+const (
+	DefaultMaxIdle             = 2
+	DefaultHealthCheckInterval = 30 * time.Second
+)
+
+type PoolOptions struct {
+	// options of the connections
+	Options
+
+	// max number of open connections, in use or idle, Get() waits for one to be
+	// returned once they are all in use. 0 means no limit
+	MaxOpen int
+	// max number of idle connections kept open, DefaultMaxIdle if 0, negative means none
+	MaxIdle int
+
+	// idle connections unused for this long are pinged before they're handed out,
+	// DefaultHealthCheckInterval if 0, negative means never
+	HealthCheckInterval time.Duration
+}
+
+// Pool of connections to the server, safe for concurrent use. Queries issued
+// concurrently run on separate connections, up to MaxOpen of them at once
+type Pool struct {
+	addr string
+	opts PoolOptions
+
+	// protects the fields below
+	m sync.Mutex
+	// most recently used last
+	idle  []idleConn
+	inUse map[*Conn]struct{}
+	// number of connections in use, idle and being established
+	open int
+	// Get() calls waiting for a connection, they receive either a connection
+	// or nil, if they can open a new one
+	waiters []chan *Conn
+	closed  bool
+}
+
+type idleConn struct {
+	conn *Conn
+	// when the connection was returned to the pool
+	since time.Time
+}
+
+// Pool of connections to the server at addr, they are established on demand
+func NewPool(addr string, opts PoolOptions) *Pool {
+	return &Pool{
+		addr:  addr,
+		opts:  opts,
+		inUse: make(map[*Conn]struct{}),
+	}
+}
+
+func (p *Pool) maxIdle() int {
+	if p.opts.MaxIdle == 0 {
+		return DefaultMaxIdle
+	}
+	return p.opts.MaxIdle
+}
+
+// Whether connection idle since the time has to be pinged before it's used
+func (p *Pool) needsCheck(since time.Time) bool {
+	interval := p.opts.HealthCheckInterval
+	if interval == 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	return interval > 0 && time.Since(since) >= interval
+}
+
+// Let the first waiter open a new connection
+// p.m should be locked
+func (p *Pool) wakeWaiter() {
+	if len(p.waiters) == 0 {
+		return
+	}
+
+	p.waiters[0] <- nil
+	p.waiters = p.waiters[1:]
+}
+
+// Forget connection which is closed or wasn't established
+// p.m should be locked
+func (p *Pool) discard() {
+	p.open--
+	p.wakeWaiter()
+}
+
+// Take an idle connection or open a new one, waits while MaxOpen connections
+// are in use. The connection has to be returned by Put()
+func (p *Pool) Get(ctx context.Context) (*Conn, error) {
+	for {
+		p.m.Lock()
+		if p.closed {
+			p.m.Unlock()
+			return nil, ErrPoolClosed
+		}
+
+		if n := len(p.idle); n != 0 {
+			idle := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			p.inUse[idle.conn] = struct{}{}
+			p.m.Unlock()
+
+			if !p.needsCheck(idle.since) || idle.conn.Ping(ctx) == nil {
+				return idle.conn, nil
+			}
+
+			// the connection is replaced, unless ctx is done
+			p.forget(idle.conn)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+
+		if p.opts.MaxOpen <= 0 || p.open < p.opts.MaxOpen {
+			p.open++
+			p.m.Unlock()
+			return p.connect(ctx)
+		}
+
+		wait := make(chan *Conn, 1)
+		p.waiters = append(p.waiters, wait)
+		p.m.Unlock()
+
+		select {
+		case conn := <-wait:
+			if conn != nil {
+				return conn, nil
+			}
+		case <-ctx.Done():
+			p.stopWaiting(wait)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Open a new connection, it's already counted as open
+func (p *Pool) connect(ctx context.Context) (*Conn, error) {
+	conn, err := Connect(ctx, p.addr, p.opts.Options)
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if err != nil {
+		p.discard()
+		return nil, err
+	}
+
+	p.inUse[conn] = struct{}{}
+	return conn, nil
+}
+
+// Give up waiting for a connection, the one handed to the waiter meanwhile is returned
+func (p *Pool) stopWaiting(wait chan *Conn) {
+	p.m.Lock()
+	for i, w := range p.waiters {
+		if w == wait {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			break
+		}
+	}
+	p.m.Unlock()
+
+	select {
+	case conn := <-wait:
+		if conn != nil {
+			p.Put(conn)
+			return
+		}
+
+		// pass the chance to open a connection on
+		p.m.Lock()
+		p.wakeWaiter()
+		p.m.Unlock()
+	default:
+	}
+}
+
+// Close connection taken from the pool instead of returning it
+func (p *Pool) forget(conn *Conn) {
+	p.m.Lock()
+	delete(p.inUse, conn)
+	p.discard()
+	p.m.Unlock()
+
+	conn.Close()
+}
+
+// Return the connection taken by Get() to the pool. It's closed instead if its
+// rows aren't closed, it's broken or there are MaxIdle idle connections already
+func (p *Pool) Put(conn *Conn) {
+	p.m.Lock()
+	if _, ok := p.inUse[conn]; !ok {
+		// returned already
+		p.m.Unlock()
+		return
+	}
+
+	reusable := !p.closed && !conn.busy && !conn.closed && conn.conn != nil
+	if reusable && len(p.waiters) != 0 {
+		p.waiters[0] <- conn
+		p.waiters = p.waiters[1:]
+		p.m.Unlock()
+		return
+	}
+
+	delete(p.inUse, conn)
+	if reusable && len(p.idle) < p.maxIdle() {
+		p.idle = append(p.idle, idleConn{conn, time.Now()})
+		p.m.Unlock()
+		return
+	}
+
+	p.discard()
+	p.m.Unlock()
+	conn.Close()
+}
+
+// Execute the query on a connection of the pool, it's returned once the rows
+// are closed (or read till the end)
+func (p *Pool) Query(ctx context.Context, sql string) (*Rows, error) {
+	conn, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(ctx, sql)
+	if err != nil || rows.receive == nil {
+		p.Put(conn)
+		return rows, err
+	}
+
+	rows.release = func() { p.Put(conn) }
+	return rows, nil
+}
+
+// Execute the statement on a connection of the pool, discarding its result
+func (p *Pool) Exec(ctx context.Context, sql string) error {
+	rows, err := p.Query(ctx, sql)
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+type PoolStats struct {
+	// connections in use and idle
+	Open int
+	Idle int
+	// Get() calls waiting for a connection
+	Waiting int
+}
+
+func (p *Pool) Stats() PoolStats {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return PoolStats{Open: p.open, Idle: len(p.idle), Waiting: len(p.waiters)}
+}
+
+// Close the idle connections, the ones in use are closed once they're returned
+func (p *Pool) Close() error {
+	p.m.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.open -= len(idle)
+	p.closed = true
+
+	// waiters find out that the pool is closed
+	for len(p.waiters) != 0 {
+		p.wakeWaiter()
+	}
+	p.m.Unlock()
+
+	var err error
+	for _, idle := range idle {
+		closeErr := idle.conn.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,24 @@
+package client
+
+import (
+	"context"
+	"dumbdb"
+)
+
+type progressKey struct{}
+
+// Returns context which makes queries executed with it report progress of their
+// table scans to fn. It's called while Query() or Rows.Next() wait for the rows,
+// about once a second (see -progress-interval of the server), and never for
+// queries finishing sooner or servers which don't report progress
+func WithProgress(ctx context.Context, fn func(dumbdb.Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// Report progress frame to the function set by WithProgress(), if any
+func reportProgress(ctx context.Context, progress *dumbdb.Progress) {
+	fn, ok := ctx.Value(progressKey{}).(func(dumbdb.Progress))
+	if ok && fn != nil {
+		fn(*progress)
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"dumbdb"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Connection broke during the query, so it's unknown whether the server
+// executed it, see ConnectionLostError
+var ErrConnectionLost = errors.New("connection lost")
+
+// Error of the query interrupted by the broken connection, it matches ErrConnectionLost
+// with errors.Is(). The next query re-establishes the connection
+type ConnectionLostError struct {
+	// IO error which broke the connection
+	Err error
+}
+
+func (e *ConnectionLostError) Error() string {
+	return "connection lost: " + e.Err.Error()
+}
+
+func (e *ConnectionLostError) Is(target error) bool {
+	return target == ErrConnectionLost
+}
+
+func (e *ConnectionLostError) Unwrap() error {
+	return e.Err
+}
+
+const (
+	DefaultReconnectBackoff = 100 * time.Millisecond
+
+	// backoff between reconnect attempts doesn't grow beyond this
+	maxReconnectBackoff = 5 * time.Second
+)
+
+// Re-establish the broken connection, up to opts.ReconnectAttempts times
+// with exponential backoff in between
+func (c *Conn) reconnect(ctx context.Context) error {
+	backoff := c.opts.ReconnectBackoff
+	if backoff <= 0 {
+		backoff = DefaultReconnectBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := c.dial(ctx)
+		if err == nil || attempt >= c.opts.ReconnectAttempts {
+			return err
+		}
+
+		// the error of the last attempt is returned, unless ctx is done before it
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// Whether the query can be executed again, if it isn't known whether it was
+// executed, i.e. whether it only reads the database
+func readOnly(sql string) bool {
+	q, err := dumbdb.ParseQuery(sql)
+	return err == nil && dumbdb.IsReadOnlyQuery(q)
+}
+
+// Statements changing the session on the server, replayed once the
+// connection is re-established, so that the new session is the same
+type session struct {
+	// use of the current database, empty if the default one is used
+	use string
+	// set of the session variables by their names
+	variables map[string]string
+}
+
+// Remember the statement if it changes the session, sql is a single statement executed successfully
+func (s *session) remember(sql string) {
+	// most queries don't need parsing
+	prefix := strings.ToLower(strings.TrimSpace(sql))
+	if !strings.HasPrefix(prefix, "use") && !strings.HasPrefix(prefix, "set") {
+		return
+	}
+
+	q, err := dumbdb.ParseQuery(sql)
+	switch {
+	case err != nil:
+	case q.Use != nil:
+		s.use = sql
+	case q.Set != nil:
+		if s.variables == nil {
+			s.variables = make(map[string]string)
+		}
+		s.variables[q.Set.Name] = sql
+	}
+}
+
+// Statements to replay, the database goes first
+func (s *session) statements() []string {
+	var statements []string
+	if s.use != "" {
+		statements = append(statements, s.use)
+	}
+	for _, sql := range s.variables {
+		statements = append(statements, sql)
+	}
+	return statements
+}
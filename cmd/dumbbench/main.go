@@ -0,0 +1,357 @@
+// Load generator for dumbdb: runs a workload against the embedded database or
+// a remote server and reports throughput and latency percentiles. The workload
+// uses table "bench" of the default database, which is re-created on every run
+package main
+
+import (
+	"context"
+	"dumbdb"
+	"dumbdb/client"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const tableName = "bench"
+
+type config struct {
+	workload    string
+	duration    time.Duration
+	concurrency int
+	batch       int     // rows per insert
+	rows        int     // rows loaded before the run
+	readRatio   float64 // share of reads in the mixed workload
+	payload     int     // length of the varchar column
+}
+
+// Executes statements of a single worker
+type executor interface {
+	exec(ctx context.Context, sql string) error
+	close() error
+}
+
+// Executes statements with the database opened in the same process
+type embeddedExecutor struct {
+	db *dumbdb.Database
+}
+
+func (e *embeddedExecutor) exec(ctx context.Context, sql string) error {
+	query, err := dumbdb.ParseQuery(sql)
+	if err != nil {
+		return err
+	}
+
+	result, err := e.db.Execute(ctx, nil, query)
+	if err != nil || result == nil || result.Rows == nil {
+		return err
+	}
+
+	// rows are produced lazily, so reading them is a part of the query
+	_, err = result.Rows.All()
+	return err
+}
+
+// database is shared by the workers and closed by main
+func (e *embeddedExecutor) close() error {
+	return nil
+}
+
+// Executes statements over its own connection to the server
+type remoteExecutor struct {
+	conn *client.Conn
+}
+
+func (e *remoteExecutor) exec(ctx context.Context, sql string) error {
+	rows, err := e.conn.Query(ctx, sql)
+	if err != nil {
+		return err
+	}
+
+	_, err = rows.All()
+	return err
+}
+
+func (e *remoteExecutor) close() error {
+	return e.conn.Close()
+}
+
+// Generates statements of the workload, safe for concurrent use
+type generator struct {
+	cfg    *config
+	nextID int64 // id of the next inserted row
+	value  string
+}
+
+// Inserted rows get ids starting at firstID
+func newGenerator(cfg *config, firstID int64) *generator {
+	return &generator{
+		cfg:    cfg,
+		nextID: firstID,
+		value:  strings.Repeat("x", cfg.payload),
+	}
+}
+
+func (g *generator) insert(n int) string {
+	first := atomic.AddInt64(&g.nextID, int64(n)) - int64(n)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "insert into %v values ", tableName)
+	for i := 0; i < n; i++ {
+		if i != 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "(%v, %q)", first+int64(i), g.value)
+	}
+	return sb.String()
+}
+
+// Lookup of a random row among the loaded ones
+func (g *generator) read(rnd *rand.Rand) string {
+	id := 0
+	if g.cfg.rows != 0 {
+		id = rnd.Intn(g.cfg.rows)
+	}
+	return fmt.Sprintf("select * from %v where id = %v", tableName, id)
+}
+
+// Next statement of the workload, returns its kind as well
+func (g *generator) next(rnd *rand.Rand) (string, string) {
+	switch g.cfg.workload {
+	case "insert":
+		return "insert", g.insert(g.cfg.batch)
+	case "read":
+		return "read", g.read(rnd)
+	default:
+		if rnd.Float64() < g.cfg.readRatio {
+			return "read", g.read(rnd)
+		}
+		return "insert", g.insert(g.cfg.batch)
+	}
+}
+
+// (Re-)create the table and fill it with cfg.rows rows
+func prepare(ctx context.Context, e executor, cfg *config) error {
+	// left by the previous run, if any
+	e.exec(ctx, "drop table "+tableName)
+
+	err := e.exec(ctx, fmt.Sprintf("create table %v (id bigint, payload varchar(%v))", tableName, cfg.payload))
+	if err != nil {
+		return err
+	}
+
+	g := newGenerator(cfg, 0)
+
+	const loadBatch = 500
+	for loaded := 0; loaded < cfg.rows; loaded += loadBatch {
+		n := loadBatch
+		if cfg.rows-loaded < n {
+			n = cfg.rows - loaded
+		}
+
+		err = e.exec(ctx, g.insert(n))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Latencies of the statements of one kind, and the number of failed ones
+type series struct {
+	latencies []time.Duration
+	errors    int
+	lastErr   error
+}
+
+// Run statements until ctx is done, latencies are recorded by statement kind
+func runWorker(ctx context.Context, e executor, g *generator, seed int64) map[string]*series {
+	rnd := rand.New(rand.NewSource(seed))
+	results := make(map[string]*series)
+
+	for ctx.Err() == nil {
+		kind, sql := g.next(rnd)
+		s, ok := results[kind]
+		if !ok {
+			s = &series{}
+			results[kind] = s
+		}
+
+		start := time.Now()
+		err := e.exec(context.Background(), sql)
+		if err != nil {
+			s.errors++
+			s.lastErr = err
+			continue
+		}
+		s.latencies = append(s.latencies, time.Since(start))
+	}
+
+	return results
+}
+
+func run(cfg *config, newExecutor func() (executor, error)) (map[string]*series, time.Duration, error) {
+	setup, err := newExecutor()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = prepare(context.Background(), setup, cfg)
+	setup.close()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load rows: %w", err)
+	}
+
+	executors := make([]executor, 0, cfg.concurrency)
+	defer func() {
+		for _, e := range executors {
+			e.close()
+		}
+	}()
+
+	for i := 0; i < cfg.concurrency; i++ {
+		e, err := newExecutor()
+		if err != nil {
+			return nil, 0, err
+		}
+		executors = append(executors, e)
+	}
+
+	g := newGenerator(cfg, int64(cfg.rows))
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	total := make(map[string]*series)
+
+	start := time.Now()
+	for i, e := range executors {
+		wg.Add(1)
+		go func(e executor, seed int64) {
+			defer wg.Done()
+			results := runWorker(ctx, e, g, seed)
+
+			m.Lock()
+			defer m.Unlock()
+			for kind, s := range results {
+				t, ok := total[kind]
+				if !ok {
+					t = &series{}
+					total[kind] = t
+				}
+				t.latencies = append(t.latencies, s.latencies...)
+				t.errors += s.errors
+				if s.lastErr != nil {
+					t.lastErr = s.lastErr
+				}
+			}
+		}(e, int64(i))
+	}
+	wg.Wait()
+
+	return total, time.Since(start), nil
+}
+
+func main() {
+	addr := flag.String("addr", "", "address of the server to benchmark (embedded database is used if empty)")
+	dataDir := flag.String("data", "", "data directory of the embedded database (temporary directory if empty)")
+	workload := flag.String("workload", "mixed", "workload: insert, read (point lookups) or mixed")
+	duration := flag.Duration("duration", 10*time.Second, "how long to run the workload")
+	concurrency := flag.Int("concurrency", 4, "number of concurrent workers (connections for the server)")
+	batch := flag.Int("batch", 1, "rows per insert statement")
+	rows := flag.Int("rows", 10000, "rows loaded into the table before the run")
+	readRatio := flag.Float64("read-ratio", 0.9, "share of reads in the mixed workload")
+	payload := flag.Int("payload", 64, "length of the varchar column of the rows")
+	flag.Parse()
+
+	cfg := &config{
+		workload:    *workload,
+		duration:    *duration,
+		concurrency: *concurrency,
+		batch:       *batch,
+		rows:        *rows,
+		readRatio:   *readRatio,
+		payload:     *payload,
+	}
+
+	err := cfg.validate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	err = benchmark(cfg, *addr, *dataDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Run the workload against the server at addr, or the embedded database
+// in dataDir if addr is empty, and print the report
+func benchmark(cfg *config, addr string, dataDir string) error {
+	var newExecutor func() (executor, error)
+	if addr != "" {
+		newExecutor = func() (executor, error) {
+			conn, err := client.Connect(context.Background(), addr, client.Options{DialTimeout: 5 * time.Second})
+			if err != nil {
+				return nil, err
+			}
+			return &remoteExecutor{conn: conn}, nil
+		}
+	} else {
+		if dataDir == "" {
+			dir, err := ioutil.TempDir("", "dumbbench")
+			if err != nil {
+				return fmt.Errorf("failed to create data directory: %w", err)
+			}
+			defer os.RemoveAll(dir)
+			dataDir = dir
+		}
+
+		db, err := dumbdb.OpenDatabase(dumbdb.Options{DataDir: dataDir})
+		if err != nil {
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+		defer db.Close()
+
+		newExecutor = func() (executor, error) {
+			return &embeddedExecutor{db: db}, nil
+		}
+	}
+
+	results, elapsed, err := run(cfg, newExecutor)
+	if err != nil {
+		return err
+	}
+
+	report(os.Stdout, cfg, results, elapsed)
+	return nil
+}
+
+func (cfg *config) validate() error {
+	switch cfg.workload {
+	case "insert", "read", "mixed":
+	default:
+		return fmt.Errorf("unknown workload %v", cfg.workload)
+	}
+
+	if cfg.concurrency < 1 || cfg.batch < 1 || cfg.payload < 1 {
+		return fmt.Errorf("-concurrency, -batch and -payload should be positive")
+	}
+
+	if cfg.rows < 0 || cfg.readRatio < 0 || cfg.readRatio > 1 {
+		return fmt.Errorf("-rows should be non-negative and -read-ratio between 0 and 1")
+	}
+
+	if cfg.workload != "insert" && cfg.rows == 0 {
+		return fmt.Errorf("%v workload needs rows to read, see -rows", cfg.workload)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Latency below which the given share of the sorted latencies are
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	idx := int(p * float64(len(sorted)-1))
+	return sorted[idx]
+}
+
+func report(w io.Writer, cfg *config, results map[string]*series, elapsed time.Duration) {
+	fmt.Fprintf(w, "workload %v: %v workers, batch %v, %v rows loaded, ran for %v\n",
+		cfg.workload, cfg.concurrency, cfg.batch, cfg.rows, elapsed.Round(time.Millisecond))
+
+	kinds := make([]string, 0, len(results))
+	for kind := range results {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	fmt.Fprintf(w, "%-8v %10v %10v %8v %10v %10v %10v %10v\n", "kind", "ops", "ops/s", "errors", "p50", "p90", "p99", "max")
+	for _, kind := range kinds {
+		s := results[kind]
+		sort.Slice(s.latencies, func(i, j int) bool {
+			return s.latencies[i] < s.latencies[j]
+		})
+
+		ops := len(s.latencies)
+		fmt.Fprintf(w, "%-8v %10v %10.1f %8v %10v %10v %10v %10v\n",
+			kind, ops, float64(ops)/elapsed.Seconds(), s.errors,
+			percentile(s.latencies, 0.5).Round(time.Microsecond),
+			percentile(s.latencies, 0.9).Round(time.Microsecond),
+			percentile(s.latencies, 0.99).Round(time.Microsecond),
+			percentile(s.latencies, 1).Round(time.Microsecond))
+
+		if kind == "insert" && cfg.batch > 1 {
+			fmt.Fprintf(w, "%-8v %10v %10.1f\n", "  rows", ops*cfg.batch, float64(ops*cfg.batch)/elapsed.Seconds())
+		}
+	}
+
+	for _, kind := range kinds {
+		if err := results[kind].lastErr; err != nil {
+			fmt.Fprintf(w, "last %v error: %v\n", kind, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Offline inspection of the files of a table for debugging: dumps the allocation
+// index, page headers, rows decoded with the schema of the table and the B+ trees
+// of its indexes, and salvages rows of damaged tables. The server shouldn't be
+// running on the data directory
+package main
+
+import (
+	"dumbdb"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const usage = `Usage: dumbdb-inspect [flags] <command>
+
+Commands:
+  alloc    allocation index of the table file, or of the index file with -index
+  pages    type and LSN of each page of the table file, or of the index file with -index
+  rows     rows of each page of the table
+  tree     nodes of the B+ tree of the index given with -index
+  salvage  copy rows of the readable pages into the new table given with -into,
+           or replace the table with them if -into is empty
+
+Flags:
+`
+
+func main() {
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to get cwd:", err)
+		os.Exit(1)
+	}
+
+	dataDir := flag.String("data", cwd, "data directory")
+	database := flag.String("database", "", "database of the table (the default one if empty)")
+	table := flag.String("table", "", "table to inspect")
+	index := flag.String("index", "", "index of the table to inspect")
+	into := flag.String("into", "", "new table for the salvaged rows")
+	keyFile := flag.String("encryption-keyfile", "", "file with the hex-encoded 32-byte key of encrypted tables (read from $"+dumbdb.EncryptionKeyEnv+" if empty)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *table == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	key, err := dumbdb.LoadEncryptionKey(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load encryption key:", err)
+		os.Exit(1)
+	}
+
+	dir := *dataDir
+	if *database != "" && *database != dumbdb.DefaultDatabase {
+		dir = filepath.Join(dir, *database)
+	}
+
+	if flag.Arg(0) == "salvage" {
+		report, err := dumbdb.Salvage(dir, *table, *into, key)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to salvage table:", err)
+			os.Exit(1)
+		}
+		fmt.Print(report)
+		return
+	}
+
+	inspector, err := dumbdb.NewInspector(dir, *table, key, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	switch flag.Arg(0) {
+	case "alloc":
+		err = inspector.DumpAllocationIndex(*index)
+	case "pages":
+		err = inspector.DumpPageHeaders(*index)
+	case "rows":
+		err = inspector.DumpRows()
+	case "tree":
+		err = inspector.DumpTree(*index)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
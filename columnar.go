@@ -0,0 +1,408 @@
+package dumbdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Encodings of the column segments, the smallest one is picked on each write
+const (
+	// values one after another
+	SegmentPlain = 0
+	// runs of equal values: number of runs (2), then length (2) and value of each run
+	SegmentRLE = 1
+	// distinct values: their number (2) and the values, then index (1) of each value
+	SegmentDict = 2
+)
+
+// number of rows (2) + encoding (1)
+const segmentHeaderSize = 3
+
+// Rows in a row group of a columnar table, segments of all the columns have
+// to fit into a page with the plain encoding
+func groupRows(schema *Schema) int {
+	width := 1
+	for _, field := range schema.Fields {
+		if int(field.Len) > width {
+			width = int(field.Len)
+		}
+	}
+
+	n := (int(PageDataSize) - segmentHeaderSize) / width
+	if n > MaxRowsPerPage {
+		return MaxRowsPerPage
+	}
+	return n
+}
+
+// Row group of a columnar table: segment of i-th column of the schema is stored on
+// the page id+i, so that scans read only the columns they need.
+//
+// Number of rows of the group is the one of the first column. Segments of the
+// other columns are synced before it on inserts and after it on removals, so that
+// they have at least as many rows when an update of the group is interrupted
+type ColumnGroup struct {
+	id       PageID
+	schema   *Schema
+	capacity int
+	write    bool
+
+	pages    []*Page  // nil for the columns which aren't read
+	segments [][]byte // decoded (plain) values of the columns which are read
+	err      error    // decoding error reported by Check()
+
+	nRows       int
+	initialRows int
+	saved       [][]byte // data of the pages before Commit(), for Rollback()
+	row         []byte   // buffer of RowData()
+}
+
+// Pin and lock pages of the group, columns are the ones read (nil for all of them,
+// the first one is always read). release() unlocks and unpins them
+func openColumnGroup(pager *Pager, id PageID, schema *Schema, columns []bool, write bool) (*ColumnGroup, func(), error) {
+	group := &ColumnGroup{
+		id:       id,
+		schema:   schema,
+		capacity: groupRows(schema),
+		write:    write,
+		pages:    make([]*Page, len(schema.Fields)),
+		segments: make([][]byte, len(schema.Fields)),
+		row:      make([]byte, schema.RowSize()),
+	}
+
+	// pages are locked in the order of ids
+	for i := range schema.Fields {
+		if i != 0 && columns != nil && !columns[i] {
+			continue
+		}
+
+		page, err := pager.FetchPage(id + PageID(i))
+		if err != nil {
+			group.release()
+			return nil, nil, err
+		}
+
+		if write {
+			page.Lock()
+		} else {
+			page.RLock()
+		}
+		group.pages[i] = page
+	}
+
+	for i, page := range group.pages {
+		if page == nil {
+			continue
+		}
+
+		err := page.checkType(PageTypeColumnSegment)
+		if err != nil {
+			group.err = fmt.Errorf("%v: %w", id+PageID(i), err)
+			break
+		}
+
+		n, values, err := decodeSegment(page.Data(), &schema.Fields[i], group.capacity)
+		if err != nil {
+			group.err = fmt.Errorf("%v: %w", id+PageID(i), err)
+			break
+		}
+
+		if i == 0 {
+			group.nRows = n
+		} else if n < group.nRows {
+			group.err = fmt.Errorf("%w: segment of %v has %v rows, expected at least %v",
+				ErrCorruptedPage, schema.Fields[i].Name, n, group.nRows)
+			break
+		}
+		group.segments[i] = values
+	}
+
+	// rows of the other columns beyond the first one weren't committed
+	for i := range group.segments {
+		if group.segments[i] != nil {
+			group.segments[i] = group.segments[i][:group.nRows*int(schema.Fields[i].Len)]
+		}
+	}
+	group.initialRows = group.nRows
+	return group, group.release, nil
+}
+
+func (g *ColumnGroup) release() {
+	for _, page := range g.pages {
+		if page == nil {
+			continue
+		}
+
+		if g.write {
+			page.Unlock()
+		} else {
+			page.RUnlock()
+		}
+		page.Unpin()
+	}
+}
+
+func (g *ColumnGroup) NumRows() int {
+	return g.nRows
+}
+
+// Returns ErrCorruptedPage if a segment can't be decoded
+func (g *ColumnGroup) Check(schema *Schema) error {
+	return g.err
+}
+
+// Returns encoded row at idx, or nil if idx is out of bounds. Columns which
+// aren't read are zeroed. Data is valid until the next call
+func (g *ColumnGroup) RowData(idx int, schema *Schema) []byte {
+	if idx >= g.nRows {
+		return nil
+	}
+
+	offset := 0
+	for i, field := range schema.Fields {
+		size := int(field.Len)
+		if g.segments[i] != nil {
+			copy(g.row[offset:offset+size], g.segments[i][idx*size:])
+		}
+		offset += size
+	}
+	return g.row
+}
+
+func (g *ColumnGroup) ReadRow(idx int, schema *Schema) Row {
+	data := g.RowData(idx, schema)
+	if data == nil {
+		return nil
+	}
+
+	row := make(Row, 0, len(schema.Fields))
+	err := schema.ReadRow(data, &row)
+	if err != nil {
+		return nil
+	}
+	return row
+}
+
+// Returns true on success, fails if the group is full. All the columns have to be read
+// NOTE: inserts are not applied until Commit() is called
+func (g *ColumnGroup) TryInsert(row Row, schema *Schema) bool {
+	if g.nRows >= g.capacity {
+		return false
+	}
+
+	err := schema.WriteRow(g.row, row)
+	if err != nil {
+		return false
+	}
+
+	offset := 0
+	for i, field := range schema.Fields {
+		size := int(field.Len)
+		g.segments[i] = append(g.segments[i], g.row[offset:offset+size]...)
+		offset += size
+	}
+
+	g.nRows++
+	return true
+}
+
+// Remove the last n rows. All the columns have to be read
+// NOTE: removals are not applied until Commit() is called
+func (g *ColumnGroup) RemoveLast(n int) {
+	if n > g.nRows {
+		n = g.nRows
+	}
+
+	g.nRows -= n
+	for i, field := range g.schema.Fields {
+		g.segments[i] = g.segments[i][:g.nRows*int(field.Len)]
+	}
+}
+
+// Encode the segments into the pages
+func (g *ColumnGroup) Commit() {
+	if g.nRows == g.initialRows {
+		return
+	}
+
+	g.saved = make([][]byte, len(g.pages))
+	for i, page := range g.pages {
+		g.saved[i] = append([]byte(nil), page.Data()...)
+		encodeSegment(page.Data(), &g.schema.Fields[i], g.segments[i], g.nRows)
+		page.SetType(PageTypeColumnSegment)
+		page.MarkDirty()
+	}
+}
+
+// Restore the pages changed by Commit()
+func (g *ColumnGroup) Rollback() {
+	if g.saved == nil {
+		return
+	}
+
+	for i, page := range g.pages {
+		copy(page.Data(), g.saved[i])
+	}
+
+	g.nRows = g.initialRows
+	for i, field := range g.schema.Fields {
+		g.segments[i] = g.segments[i][:g.nRows*int(field.Len)]
+	}
+	g.saved = nil
+}
+
+// Write the changed pages: when rows are added, the first column goes last,
+// so that the rows become visible once all their values are written
+func (g *ColumnGroup) Sync(pager *Pager) error {
+	order := make([]int, 0, len(g.pages))
+	for i := 1; i < len(g.pages); i++ {
+		order = append(order, i)
+	}
+
+	if g.nRows > g.initialRows {
+		order = append(order, 0)
+	} else {
+		order = append([]int{0}, order...)
+	}
+
+	for _, i := range order {
+		if g.pages[i] == nil {
+			continue
+		}
+
+		err := pager.SyncPage(g.id+PageID(i), g.pages[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode the segment of the field stored on the page, returns number of rows and
+// their values. Segments have no more than capacity rows
+func decodeSegment(data []byte, field *Field, capacity int) (int, []byte, error) {
+	n := int(binary.LittleEndian.Uint16(data))
+	if n > capacity {
+		return 0, nil, fmt.Errorf("%w: segment has %v rows, %v is max", ErrCorruptedPage, n, capacity)
+	}
+
+	size := int(field.Len)
+	values := make([]byte, 0, n*size)
+	body := data[segmentHeaderSize:]
+
+	switch data[2] {
+	case SegmentPlain:
+		if n*size > len(body) {
+			return 0, nil, fmt.Errorf("%w: %v values don't fit into the segment", ErrCorruptedPage, n)
+		}
+		values = append(values, body[:n*size]...)
+
+	case SegmentRLE:
+		runs := int(binary.LittleEndian.Uint16(body))
+		if 2+runs*(2+size) > len(body) {
+			return 0, nil, fmt.Errorf("%w: %v runs don't fit into the segment", ErrCorruptedPage, runs)
+		}
+
+		for i := 0; i < runs; i++ {
+			run := body[2+i*(2+size):]
+			count := int(binary.LittleEndian.Uint16(run))
+			if len(values)/size+count > n {
+				return 0, nil, fmt.Errorf("%w: runs have more than %v values", ErrCorruptedPage, n)
+			}
+
+			for j := 0; j < count; j++ {
+				values = append(values, run[2:2+size]...)
+			}
+		}
+
+		if len(values) != n*size {
+			return 0, nil, fmt.Errorf("%w: runs have %v values, expected %v", ErrCorruptedPage, len(values)/size, n)
+		}
+
+	case SegmentDict:
+		distinct := int(binary.LittleEndian.Uint16(body))
+		if 2+distinct*size+n > len(body) {
+			return 0, nil, fmt.Errorf("%w: dictionary of %v values doesn't fit into the segment", ErrCorruptedPage, distinct)
+		}
+
+		dict := body[2 : 2+distinct*size]
+		for _, idx := range body[2+distinct*size : 2+distinct*size+n] {
+			if int(idx) >= distinct {
+				return 0, nil, fmt.Errorf("%w: value %v is not in the dictionary of %v values", ErrCorruptedPage, idx, distinct)
+			}
+			values = append(values, dict[int(idx)*size:(int(idx)+1)*size]...)
+		}
+
+	default:
+		return 0, nil, fmt.Errorf("%w: unknown segment encoding %v", ErrCorruptedPage, data[2])
+	}
+
+	return n, values, nil
+}
+
+// Encode n values of the field into the page with the smallest encoding, the
+// plain one has to fit
+func encodeSegment(data []byte, field *Field, values []byte, n int) {
+	size := int(field.Len)
+	value := func(i int) []byte {
+		return values[i*size : (i+1)*size]
+	}
+
+	runs := 0
+	for i := 0; i < n; i++ {
+		if i == 0 || !bytes.Equal(value(i-1), value(i)) {
+			runs++
+		}
+	}
+
+	// dictionary indexes are single bytes
+	dict := make(map[string]int)
+	for i := 0; i < n && len(dict) <= 256; i++ {
+		if _, ok := dict[string(value(i))]; !ok {
+			dict[string(value(i))] = len(dict)
+		}
+	}
+
+	plainSize := n * size
+	rleSize := 2 + runs*(2+size)
+	dictSize := 2 + len(dict)*size + n
+
+	binary.LittleEndian.PutUint16(data, uint16(n))
+	body := data[segmentHeaderSize:]
+
+	switch {
+	case rleSize < plainSize && rleSize <= dictSize:
+		data[2] = SegmentRLE
+		binary.LittleEndian.PutUint16(body, uint16(runs))
+
+		run := body[2:]
+		for i := 0; i < n; {
+			j := i + 1
+			for j < n && bytes.Equal(value(i), value(j)) {
+				j++
+			}
+
+			binary.LittleEndian.PutUint16(run, uint16(j-i))
+			copy(run[2:], value(i))
+			run = run[2+size:]
+			i = j
+		}
+
+	case len(dict) <= 256 && dictSize < plainSize:
+		data[2] = SegmentDict
+		binary.LittleEndian.PutUint16(body, uint16(len(dict)))
+		for v, idx := range dict {
+			copy(body[2+idx*size:], v)
+		}
+
+		indexes := body[2+len(dict)*size:]
+		for i := 0; i < n; i++ {
+			indexes[i] = byte(dict[string(value(i))])
+		}
+
+	default:
+		data[2] = SegmentPlain
+		copy(body, values[:plainSize])
+	}
+}
@@ -0,0 +1,257 @@
+package dumbdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestColumnSegments(t *testing.T) {
+	field := Field{Name: "kind", TypeID: TypeVarchar, Len: 10}
+	capacity := 100
+
+	tests := []struct {
+		name     string
+		value    func(i int) string
+		encoding byte
+	}{
+		{"distinct", func(i int) string { return fmt.Sprintf("v%v", i) }, SegmentPlain},
+		{"runs", func(i int) string { return fmt.Sprintf("v%v", i/30) }, SegmentRLE},
+		{"few values", func(i int) string { return fmt.Sprintf("v%v", i%3) }, SegmentDict},
+		{"empty", nil, SegmentPlain},
+	}
+
+	for _, test := range tests {
+		n := capacity
+		if test.value == nil {
+			n = 0
+		}
+
+		values := make([]byte, n*int(field.Len))
+		for i := 0; i < n; i++ {
+			field.Write(values[i*int(field.Len):], VarcharValue(test.value(i)))
+		}
+
+		page := make([]byte, PageDataSize)
+		encodeSegment(page, &field, values, n)
+		if page[2] != test.encoding {
+			t.Fatalf("%v: expected encoding %v, got %v", test.name, test.encoding, page[2])
+		}
+
+		decoded, got, err := decodeSegment(page, &field, capacity)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		if decoded != n || !bytes.Equal(got, values) {
+			t.Fatalf("%v: decoded %v values differ", test.name, decoded)
+		}
+	}
+
+	// corrupted segments
+	for _, corrupt := range [][]byte{
+		{101, 0, SegmentPlain},
+		{1, 0, 7},
+		{2, 0, SegmentRLE, 1, 0, 1, 0},
+		{2, 0, SegmentDict, 1, 0, 'a', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+	} {
+		page := make([]byte, PageDataSize)
+		copy(page, corrupt)
+		_, _, err := decodeSegment(page, &field, capacity)
+		if !errors.Is(err, ErrCorruptedPage) {
+			t.Fatalf("%v: expected ErrCorruptedPage, got %v", corrupt, err)
+		}
+	}
+}
+
+func TestColumnarTable(t *testing.T) {
+	dir := t.TempDir()
+	catalog, err := OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		catalog.Close()
+	}()
+
+	query := func(q string) []string {
+		return rowStrings(mustQueryCatalog(t, catalog, q), formatConst, ", ")
+	}
+
+	insert := func(from int, to int) {
+		values := make([]string, 0, to-from)
+		for i := from; i < to; i++ {
+			values = append(values, fmt.Sprintf("(%v, \"k%v\", %v.5)", i, i%3, i/100))
+		}
+		query("insert into t values " + strings.Join(values, ", "))
+	}
+
+	query("create table t (id int, kind varchar(20), score float) engine = columnar")
+	insert(0, 1000)
+
+	table, err := catalog.Table("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(n int) {
+		if rows := query("select * from t"); len(rows) != n {
+			t.Fatalf("Expected %v rows, got %v", n, len(rows))
+		}
+
+		rows := query("select id, kind from t where score = 7.5 and id > 790")
+		want := []string{}
+		for i := 791; i < 800; i++ {
+			want = append(want, fmt.Sprintf("%v, \"k%v\"", i, i%3))
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Fatalf("Unexpected rows %v", rows)
+		}
+
+		if rows := query("select kind from t where id = 5"); !reflect.DeepEqual(rows, []string{"\"k2\""}) {
+			t.Fatalf("Unexpected rows %v", rows)
+		}
+
+		// table file holds a page per column of each row group
+		groups := (n + table.rowsPerPage() - 1) / table.rowsPerPage()
+		if count := table.pageCount(); count != int64(groups) {
+			t.Fatalf("Expected %v row groups, got %v", groups, count)
+		}
+	}
+	check(1000)
+
+	plan := query("explain select kind from t where id < 10")
+	if plan[len(plan)-2] != "\"  columns: [id kind]\"" {
+		t.Fatalf("Expected pruned columns in the plan, got %v", plan)
+	}
+
+	// pages of other columns aren't read
+	fetches := func(q string) uint64 {
+		before := DefaultMetrics.CacheHits.Value() + DefaultMetrics.CacheMisses.Value()
+		query(q)
+		return DefaultMetrics.CacheHits.Value() + DefaultMetrics.CacheMisses.Value() - before
+	}
+
+	all, pruned := fetches("select * from t"), fetches("select id from t")
+	if 3*pruned != all {
+		t.Fatalf("Expected scan of a column to fetch third of %v pages, got %v", all, pruned)
+	}
+
+	// rows are referenced by the first pages of their groups
+	query("create index t_kind on t (kind)")
+	if rows := query("select id from t where kind = \"k1\" and id < 10"); len(rows) != 3 {
+		t.Fatalf("Unexpected rows %v", rows)
+	}
+
+	_, err = table.FetchRow(NewRowID(1, 0))
+	if !errors.Is(err, ErrNoSuchRow) {
+		t.Fatalf("Expected ErrNoSuchRow, got %v", err)
+	}
+
+	// a group left incomplete by a crash is completed by the next insert
+	_, err = table.pager.AllocatePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := table.pageCount(); count != int64(1000/table.rowsPerPage()+1) {
+		t.Fatalf("Expected incomplete group to be skipped, got %v groups", count)
+	}
+
+	insert(1000, 2000)
+	check(2000)
+
+	for _, reopen := range []string{"clean", "crash"} {
+		if reopen == "crash" {
+			for _, table := range catalog.tables {
+				table.Close()
+			}
+		} else {
+			catalog.Close()
+		}
+
+		catalog, err = OpenCatalog(dir, StorageFile, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		table, err = catalog.Table("t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(2000)
+	}
+
+	_, err = table.allocatePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pages of the empty group are released
+	query("vacuum t")
+	table, err = catalog.Table("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(2000)
+
+	query("truncate table t")
+	insert(0, 1000)
+	check(1000)
+}
+
+func TestColumnGroupInterruptedInsert(t *testing.T) {
+	schema := NewSchema([]FieldDescription{
+		{Name: "id", Type: &Type{Integer: true}},
+		{Name: "name", Type: &Type{Varchar: 20}},
+	})
+
+	table, err := NewTable(filepath.Join(t.TempDir(), "t"), schema, TableOptions{Engine: EngineColumnar})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	err = table.Insert([]Row{{IntValue(1), VarcharValue("a")}, {IntValue(2), VarcharValue("b")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// segment of the second column was written, but the first one wasn't
+	page, err := table.pager.FetchPage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page.Lock()
+	values := make([]byte, 3*20)
+	for i, name := range []string{"a", "b", "c"} {
+		schema.Fields[1].Write(values[i*20:], VarcharValue(name))
+	}
+	encodeSegment(page.Data(), &schema.Fields[1], values, 3)
+	page.Unlock()
+	page.Unpin()
+
+	var names []string
+	err = table.Scan(func(row Row) error {
+		names = append(names, row[1].StrVal())
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatalf("Expected the uncommitted row to be ignored, got %v (%v)", names, err)
+	}
+
+	err = table.Insert([]Row{{IntValue(3), VarcharValue("d")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := table.FetchRow(NewRowID(0, 2))
+	if err != nil || row[1].StrVal() != "d" {
+		t.Fatalf("Expected the new row to replace the uncommitted one, got %v (%v)", row, err)
+	}
+}
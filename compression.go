@@ -0,0 +1,402 @@
+package dumbdb
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// Compression of table pages, see TableOptions
+const (
+	CompressionNone  = ""
+	CompressionFlate = "flate"
+)
+
+var ErrUnknownCompression = errors.New("unknown compression, expected flate")
+
+const (
+	// page index (4) + flags (1) + data length (2) + crc32 of the data (4)
+	frameHeaderSize = 4 + 1 + 2 + 4
+
+	// data of the frame is compressed page
+	frameCompressed = 1 << 0
+	// frame has no data, page index of the frame is the new number of pages
+	frameTruncate = 1 << 1
+
+	// log is compacted once it has more garbage than this and than live frames
+	minCompactionGarbage = 64 * int64(PageSize)
+)
+
+// Frame of the page in the log
+type frameRef struct {
+	offset int64 // offset of the frame header
+	size   int64 // including header
+}
+
+// Storage with transparently compressed pages.
+//
+// Pages are kept in an append-only log of frames, each frame holds the whole page
+// compressed with DEFLATE, or as is if it doesn't get smaller. The last frame of a page
+// wins, pages without frames are zeroed. Page table is rebuilt from the log when the
+// storage is opened, a torn frame at the end of the log is discarded.
+// The log is rewritten once most of it is garbage.
+type CompressedStorage struct {
+	m     sync.Mutex
+	path  string
+	flags int
+	file  *os.File
+
+	size    int64      // logical size
+	pages   []frameRef // indexed by page index, zero size if page has no frame
+	end     int64      // end of the log
+	garbage int64      // total size of overwritten frames
+	off     int64      // for Seek()
+
+	compressed bytes.Buffer
+	compressor *flate.Writer
+}
+
+// Open or create the storage in file at path, flags are the same as for os.OpenFile()
+func OpenCompressedStorage(path string, flags int) (*CompressedStorage, error) {
+	file, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &CompressedStorage{
+		path:  path,
+		flags: flags &^ (os.O_CREATE | os.O_EXCL | os.O_TRUNC),
+		file:  file,
+	}
+
+	storage.compressor, err = flate.NewWriter(&storage.compressed, flate.BestSpeed)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	err = storage.replay()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// Read frame headers of the log and build the page table
+func (storage *CompressedStorage) replay() error {
+	var header [frameHeaderSize]byte
+	data := make([]byte, PageSize)
+	for {
+		_, err := storage.file.ReadAt(header[:], storage.end)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		idx := binary.LittleEndian.Uint32(header[0:])
+		flags := header[4]
+		dataLen := int(binary.LittleEndian.Uint16(header[5:]))
+		checksum := binary.LittleEndian.Uint32(header[7:])
+		if dataLen > int(PageSize) {
+			break
+		}
+
+		_, err = storage.file.ReadAt(data[:dataLen], storage.end+frameHeaderSize)
+		if errors.Is(err, io.EOF) || crc32.ChecksumIEEE(data[:dataLen]) != checksum {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		frame := frameRef{offset: storage.end, size: int64(frameHeaderSize + dataLen)}
+		storage.end += frame.size
+		if flags&frameTruncate != 0 {
+			storage.truncatePages(idx)
+			storage.garbage += frame.size
+			continue
+		}
+
+		storage.setFrame(idx, frame)
+	}
+
+	// discard the torn frame, if any, it may be still being written if the file is opened read-only
+	if isReadOnly(storage.flags) {
+		return nil
+	}
+	return storage.file.Truncate(storage.end)
+}
+
+func (storage *CompressedStorage) setFrame(idx uint32, frame frameRef) {
+	for uint32(len(storage.pages)) <= idx {
+		storage.pages = append(storage.pages, frameRef{})
+	}
+
+	storage.garbage += storage.pages[idx].size
+	storage.pages[idx] = frame
+	if end := (int64(idx) + 1) * int64(PageSize); end > storage.size {
+		storage.size = end
+	}
+}
+
+func (storage *CompressedStorage) truncatePages(n uint32) {
+	for idx := n; idx < uint32(len(storage.pages)); idx++ {
+		storage.garbage += storage.pages[idx].size
+	}
+
+	if n < uint32(len(storage.pages)) {
+		storage.pages = storage.pages[:n]
+	}
+	storage.size = int64(n) * int64(PageSize)
+}
+
+// Read content of the page into buf
+func (storage *CompressedStorage) readPage(idx int, buf []byte) error {
+	if idx >= len(storage.pages) || storage.pages[idx].size == 0 {
+		for i := range buf {
+			buf[i] = 0
+		}
+		return nil
+	}
+
+	frame := storage.pages[idx]
+	data := make([]byte, frame.size)
+	_, err := storage.file.ReadAt(data, frame.offset)
+	if err != nil {
+		return err
+	}
+
+	flags := data[4]
+	data = data[frameHeaderSize:]
+	if flags&frameCompressed == 0 {
+		copy(buf, data)
+		return nil
+	}
+
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	_, err = io.ReadFull(r, buf)
+	return err
+}
+
+// Append frame to the end of the log
+func (storage *CompressedStorage) appendFrame(idx uint32, flags byte, data []byte) error {
+	frame := make([]byte, frameHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(frame[0:], idx)
+	frame[4] = flags
+	binary.LittleEndian.PutUint16(frame[5:], uint16(len(data)))
+	binary.LittleEndian.PutUint32(frame[7:], crc32.ChecksumIEEE(data))
+	copy(frame[frameHeaderSize:], data)
+
+	_, err := storage.file.WriteAt(frame, storage.end)
+	if err != nil {
+		return err
+	}
+
+	ref := frameRef{offset: storage.end, size: int64(len(frame))}
+	storage.end += ref.size
+	if flags&frameTruncate != 0 {
+		storage.truncatePages(idx)
+		storage.garbage += ref.size
+	} else {
+		storage.setFrame(idx, ref)
+	}
+	return nil
+}
+
+func (storage *CompressedStorage) writePage(idx int, page []byte) error {
+	storage.compressed.Reset()
+	storage.compressor.Reset(&storage.compressed)
+	_, err := storage.compressor.Write(page)
+	if err != nil {
+		return err
+	}
+
+	err = storage.compressor.Close()
+	if err != nil {
+		return err
+	}
+
+	if storage.compressed.Len() < len(page) {
+		return storage.appendFrame(uint32(idx), frameCompressed, storage.compressed.Bytes())
+	}
+	return storage.appendFrame(uint32(idx), 0, page)
+}
+
+func (storage *CompressedStorage) ReadAt(buf []byte, off int64) (int, error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	page := make([]byte, PageSize)
+	n := 0
+	for n < len(buf) {
+		pos := off + int64(n)
+		if pos >= storage.size {
+			return n, io.EOF
+		}
+
+		idx := int(pos / int64(PageSize))
+		err := storage.readPage(idx, page)
+		if err != nil {
+			return n, err
+		}
+
+		n += copy(buf[n:], page[pos%int64(PageSize):])
+	}
+	return n, nil
+}
+
+func (storage *CompressedStorage) WriteAt(buf []byte, off int64) (int, error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	page := make([]byte, PageSize)
+	n := 0
+	for n < len(buf) {
+		pos := off + int64(n)
+		idx := int(pos / int64(PageSize))
+		pageOff := int(pos % int64(PageSize))
+
+		// partially written pages are merged with the current content
+		if pageOff != 0 || len(buf)-n < int(PageSize) {
+			err := storage.readPage(idx, page)
+			if err != nil {
+				return n, err
+			}
+		}
+
+		written := copy(page[pageOff:], buf[n:])
+		err := storage.writePage(idx, page)
+		if err != nil {
+			return n, err
+		}
+		n += written
+	}
+
+	return n, storage.maybeCompact()
+}
+
+func (storage *CompressedStorage) Seek(diff int64, whence int) (int64, error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		storage.off += diff
+	case io.SeekStart:
+		storage.off = diff
+	case io.SeekEnd:
+		storage.off = storage.size + diff
+	}
+	return storage.off, nil
+}
+
+// Change size of the storage, size has to be multiple of the page size
+func (storage *CompressedStorage) Truncate(size int64) error {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	if size%int64(PageSize) != 0 {
+		return ErrInvalidStorageSize
+	}
+
+	err := storage.appendFrame(uint32(size/int64(PageSize)), frameTruncate, nil)
+	if err != nil {
+		return err
+	}
+	return storage.maybeCompact()
+}
+
+// Rewrite the log with live frames only, if it has too much garbage
+func (storage *CompressedStorage) maybeCompact() error {
+	if storage.garbage < minCompactionGarbage || storage.garbage < storage.end-storage.garbage {
+		return nil
+	}
+
+	tmpPath := storage.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	compacted := &CompressedStorage{
+		path:  storage.path,
+		flags: storage.flags,
+		file:  tmp,
+
+		compressor: storage.compressor,
+	}
+
+	for idx, frame := range storage.pages {
+		if frame.size == 0 {
+			continue
+		}
+
+		data := make([]byte, frame.size)
+		_, err = storage.file.ReadAt(data, frame.offset)
+		if err == nil {
+			_, err = tmp.WriteAt(data, compacted.end)
+		}
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+
+		compacted.setFrame(uint32(idx), frameRef{offset: compacted.end, size: frame.size})
+		compacted.end += frame.size
+	}
+
+	// size can be larger than the last page with a frame
+	err = compacted.appendFrame(uint32(storage.size/int64(PageSize)), frameTruncate, nil)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, storage.path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(storage.path, storage.flags, 0600)
+	if err != nil {
+		return err
+	}
+
+	storage.file.Close()
+	storage.file = file
+	storage.pages = compacted.pages
+	storage.end = compacted.end
+	storage.garbage = compacted.garbage
+	return nil
+}
+
+// Reader of the log, which is copied as is by backups
+func (storage *CompressedStorage) Raw() (*io.SectionReader, error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+	return io.NewSectionReader(storage.file, 0, storage.end), nil
+}
+
+func (storage *CompressedStorage) Name() string {
+	return storage.path
+}
+
+func (storage *CompressedStorage) Close() error {
+	return storage.file.Close()
+}
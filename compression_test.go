@@ -0,0 +1,146 @@
+package dumbdb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompressedTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	opts := TableOptions{Compression: CompressionFlate}
+	table, err := NewTable(path, testTableSchema(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const nRows = 1000
+	rows := make([]Row, 0, nRows)
+	for i := 0; i < nRows; i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("user %v", i))})
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nPages := countPages(table)
+	err = table.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path + ".bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// varchar columns are mostly padding
+	if info.Size() > int64(nPages)*int64(PageSize)/2 {
+		t.Fatalf("Expected file of %v pages to be compressed, got %v bytes", nPages, info.Size())
+	}
+
+	table, err = OpenTable(path, testTableSchema(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	i := 0
+	err = table.Scan(func(row Row) error {
+		if row[0].Int != int64(i) || row[1].StrVal() != fmt.Sprintf("user %v", i) {
+			return fmt.Errorf("unexpected row #%v: %v", i, row)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i != nRows {
+		t.Fatalf("Expected %v rows, got %v", nRows, i)
+	}
+
+	_, err = NewTable(filepath.Join(t.TempDir(), "bad"), testTableSchema(), TableOptions{Compression: "zip"})
+	if err != ErrUnknownCompression {
+		t.Fatalf("Expected ErrUnknownCompression, got %v", err)
+	}
+}
+
+func TestCompressedStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pages.bin")
+	storage, err := OpenCompressedStorage(path, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := func(n int) []byte {
+		return []byte(strings.Repeat(fmt.Sprintf("page %v ", n), int(PageSize))[:PageSize])
+	}
+
+	err = storage.Truncate(4 * int64(PageSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrite the pages a lot, so that the log is compacted
+	for i := 0; i < 1000; i++ {
+		_, err = storage.WriteAt(page(i), int64(i%3)*int64(PageSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// partial write
+	_, err = storage.WriteAt([]byte("hello"), int64(PageSize)+10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() > minCompactionGarbage*2 {
+		t.Fatalf("Expected log to be compacted, got %v bytes", info.Size())
+	}
+
+	// torn frame at the end is discarded
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{1, 2, 3})
+	file.Close()
+
+	storage, err = OpenCompressedStorage(path, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	size, err := storage.Seek(0, 2)
+	if err != nil || size != 4*int64(PageSize) {
+		t.Fatalf("Unexpected size %v (%v)", size, err)
+	}
+
+	expected := bytes.Join([][]byte{page(999), page(997), page(998), make([]byte, PageSize)}, nil)
+	copy(expected[PageSize+10:], "hello")
+
+	buf := make([]byte, len(expected))
+	_, err = storage.ReadAt(buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf, expected) {
+		t.Fatal("Unexpected content of the storage after reopening")
+	}
+}
@@ -0,0 +1,327 @@
+package dumbdb
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+const (
+	// Number of rows inserted at once by copy
+	CopyBatchSize = 1000
+
+	// Max number of rejected rows included into copy report
+	maxReportedErrors = 100
+)
+
+// Parse string representation of the value according to the field type
+func ParseValue(field *Field, s string) (Value, error) {
+	val := Value{
+		TypeID: field.TypeID,
+	}
+
+	switch field.TypeID {
+	case TypeInt:
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return val, fmt.Errorf("invalid int value for %v: %q", field.Name, s)
+		}
+		val.Int = n
+	case TypeBigint:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return val, fmt.Errorf("invalid bigint value for %v: %q", field.Name, s)
+		}
+		val.Int = n
+	case TypeFloat:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return val, fmt.Errorf("invalid float value for %v: %q", field.Name, s)
+		}
+		val.Float = f
+	case TypeBool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return val, fmt.Errorf("invalid bool value for %v: %q", field.Name, s)
+		}
+		val.Int = BoolVal(b).ToInt()
+	case TypeVarchar:
+		val.Str = s
+	default:
+		panic("unhandled type id")
+	}
+
+	return val, field.Typecheck(&val)
+}
+
+// Convert CSV record into a row, columns[i] is the index of schema field stored in record[i]
+func parseRecord(schema *Schema, columns []int, record []string) (Row, error) {
+	if len(record) != len(columns) {
+		return nil, fmt.Errorf("expected %v values, got %v", len(columns), len(record))
+	}
+
+	row := make(Row, len(schema.Fields))
+	for i, s := range record {
+		idx := columns[i]
+		val, err := ParseValue(&schema.Fields[idx], s)
+		if err != nil {
+			return nil, err
+		}
+		row[idx] = val
+	}
+
+	return row, nil
+}
+
+// Map CSV header to schema fields
+func headerColumns(schema *Schema, header []string) ([]int, error) {
+	if len(header) != len(schema.Fields) {
+		return nil, fmt.Errorf("header has %v columns, table has %v", len(header), len(schema.Fields))
+	}
+
+	seen := make(map[int]bool)
+	columns := make([]int, 0, len(header))
+	for _, name := range header {
+		idx, _ := schema.GetField(name)
+		if idx == -1 {
+			return nil, fmt.Errorf("no column named %v in the table", name)
+		}
+
+		if seen[idx] {
+			return nil, fmt.Errorf("duplicate column %v in header", name)
+		}
+
+		seen[idx] = true
+		columns = append(columns, idx)
+	}
+
+	return columns, nil
+}
+
+type CopyError struct {
+	Record int // 1-based index of the record, not counting header
+	Err    error
+}
+
+type CopyReport struct {
+	Inserted int
+	Rejected []CopyError // at most maxReportedErrors
+	Total    int         // total number of rejected rows
+}
+
+// Read CSV from r and insert valid rows into the table, invalid rows are reported
+func (table *Table) CopyFrom(ctx context.Context, r io.Reader, header bool) (*CopyReport, error) {
+	return table.copyFrom(ctx, r, header, table.Insert)
+}
+
+// Same as CopyFrom(), but batches of valid rows are inserted by insert
+func (table *Table) copyFrom(ctx context.Context, r io.Reader, header bool, insert func([]Row) error) (*CopyReport, error) {
+	reader := csv.NewReader(r)
+	// number of fields is checked by parseRecord, to report it as a row error
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	columns := make([]int, len(table.schema.Fields))
+	for i := range columns {
+		columns[i] = i
+	}
+
+	if header {
+		record, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv header: %w", err)
+		}
+
+		columns, err = headerColumns(&table.schema, record)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &CopyReport{}
+	reject := func(record int, err error) {
+		report.Total++
+		if len(report.Rejected) < maxReportedErrors {
+			report.Rejected = append(report.Rejected, CopyError{record, err})
+		}
+	}
+
+	batch := make([]Row, 0, CopyBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		err := insert(batch)
+		if err != nil {
+			return err
+		}
+
+		report.Inserted += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	n := 0
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		n++
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				reject(n, parseErr)
+				continue
+			}
+			return report, err
+		}
+
+		row, err := parseRecord(&table.schema, columns, record)
+		if err != nil {
+			reject(n, err)
+			continue
+		}
+
+		batch = append(batch, row)
+		if len(batch) == CopyBatchSize {
+			err = flush()
+			if err != nil {
+				return report, err
+			}
+
+			err = CancellationError(ctx)
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+
+	return report, flush()
+}
+
+func (report *CopyReport) Result() *Result {
+	var schema Schema
+	schema.addField(Field{Name: "record", TypeID: TypeInt, Len: 4})
+	schema.addField(Field{Name: "message", TypeID: TypeVarchar, Len: 255})
+
+	row := func(record int, message string) Row {
+		if len(message) > 255 {
+			message = message[:255]
+		}
+
+		return Row{
+			{TypeID: TypeInt, Int: int64(record)},
+			{TypeID: TypeVarchar, Str: message},
+		}
+	}
+
+	rows := make([]Row, 0, len(report.Rejected)+1)
+	for _, rejected := range report.Rejected {
+		rows = append(rows, row(rejected.Record, rejected.Err.Error()))
+	}
+
+	summary := fmt.Sprintf("inserted %v rows, rejected %v", report.Inserted, report.Total)
+	if report.Total > len(report.Rejected) {
+		summary += fmt.Sprintf(" (only first %v are listed)", len(report.Rejected))
+	}
+	rows = append(rows, row(0, summary))
+
+	return &Result{
+		Schema:       schema,
+		Rows:         StaticRows(rows),
+		RowsAffected: int64(report.Inserted),
+	}
+}
+
+func (catalog *Catalog) doCopyFrom(ctx context.Context, copy *CopyFrom) (*Result, error) {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	table, ok := catalog.tables[copy.Table]
+	if !ok {
+		return nil, catalog.tableError(copy.Table, ErrNoSuchTable)
+	}
+
+	if copy.Stdin {
+		if copy.Header {
+			return nil, errors.New("header is supported only for csv files")
+		}
+		return &Result{Schema: table.schema, CopyIn: &CopyIn{catalog: catalog, table: table, name: copy.Table}}, nil
+	}
+
+	file, err := os.Open(copy.Filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// rows go through the triggers of the table, as the inserted ones
+	report, err := table.copyFrom(ctx, file, copy.Header, func(rows []Row) error {
+		_, err := catalog.insertInto(copy.Table, table, rows, false)
+		return err
+	})
+	if err != nil {
+		if report != nil {
+			return nil, fmt.Errorf("copy failed after inserting %v rows: %w", report.Inserted, err)
+		}
+		return nil, err
+	}
+
+	return report.Result(), nil
+}
+
+// Destination of copy from stdin, the client streams batches of rows in the
+// order of table columns (Result.Schema) and they are inserted as they arrive.
+// Unlike copy from a file, the catalog isn't locked in between the batches
+type CopyIn struct {
+	catalog *Catalog
+	table   *Table
+	name    string
+
+	// number of rows inserted so far
+	Inserted int64
+}
+
+// Insert the batch of rows, the copy fails if the table was dropped or
+// altered since it started
+func (c *CopyIn) Insert(rows []Row) error {
+	c.catalog.m.RLock()
+	defer c.catalog.m.RUnlock()
+
+	if c.catalog.tables[c.name] != c.table {
+		return fmt.Errorf("%w: %v was dropped or altered during copy", ErrTableDropped, c.name)
+	}
+
+	_, err := c.catalog.insertInto(c.name, c.table, rows, false)
+	if err != nil {
+		return fmt.Errorf("copy failed after inserting %v rows: %w", c.Inserted, err)
+	}
+
+	c.Inserted += int64(len(rows))
+	return nil
+}
+
+// Same as Insert(), but rows which fail don't stop the others and the copy goes
+// on, see Table.InsertBatch(). Returns status of each row
+func (c *CopyIn) InsertBatch(rows []Row) ([]RowStatus, error) {
+	c.catalog.m.RLock()
+	defer c.catalog.m.RUnlock()
+
+	if c.catalog.tables[c.name] != c.table {
+		return nil, fmt.Errorf("%w: %v was dropped or altered during copy", ErrTableDropped, c.name)
+	}
+
+	statuses, err := c.catalog.insertBatch(c.name, c.table, rows)
+	c.Inserted += int64(CountInserted(statuses))
+	if err != nil {
+		return nil, fmt.Errorf("copy failed after inserting %v rows: %w", c.Inserted, err)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,123 @@
+package dumbdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrNoSuchCursor = errors.New("no such cursor")
+
+// Max number of cursors open in a session at once
+const MaxCursors = 64
+
+// Result of the select declared as a cursor, its rows are produced as they
+// are fetched, so the client controls the pace
+type cursor struct {
+	// serializes fetches of pipelined requests
+	m      sync.Mutex
+	schema Schema
+	rows   *Rows
+	cancel context.CancelFunc
+}
+
+// Read up to n next rows, fewer once the rows are exhausted
+func (c *cursor) fetch(n int64) ([]Row, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	rows := []Row{}
+	for int64(len(rows)) < n && c.rows.Next() {
+		rows = append(rows, c.rows.Row())
+	}
+	return rows, c.rows.Err()
+}
+
+func (c *cursor) close() error {
+	c.cancel()
+	err := c.rows.Close()
+	if errors.Is(err, ErrQueryCancelled) {
+		// the rows weren't read till the end
+		return nil
+	}
+	return err
+}
+
+// Open the cursor, the select is executed in the background of the session, so
+// it outlives the statement and isn't limited by its timeout. Declare and close
+// can't run concurrently with other statements of the session, see Session
+func (db *Database) doDeclare(sess *Session, declare *Declare) (*Result, error) {
+	if sess == nil {
+		return nil, errors.New("declare requires a session")
+	}
+
+	if _, ok := sess.cursors[declare.Name]; ok {
+		return nil, fmt.Errorf("cursor %v is already open", declare.Name)
+	}
+
+	if len(sess.cursors) >= MaxCursors {
+		return nil, fmt.Errorf("too many open cursors, at most %v are allowed", MaxCursors)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result, err := db.execute(ctx, sess, &Query{Select: declare.Select})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if sess.cursors == nil {
+		sess.cursors = make(map[string]*cursor)
+	}
+	sess.cursors[declare.Name] = &cursor{schema: result.Schema, rows: result.Rows, cancel: cancel}
+	return nil, nil
+}
+
+func (db *Database) doFetch(sess *Session, fetch *Fetch) (*Result, error) {
+	c, ok := sess.cursor(fetch.Name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNoSuchCursor, fetch.Name)
+	}
+
+	n := int64(1)
+	if fetch.Count != nil {
+		n = *fetch.Count
+	}
+	if n <= 0 {
+		return nil, errors.New("number of fetched rows should be positive")
+	}
+
+	rows, err := c.fetch(n)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Schema: c.schema, Rows: StaticRows(rows)}, nil
+}
+
+func (db *Database) doCloseCursor(sess *Session, close *CloseCursor) (*Result, error) {
+	c, ok := sess.cursor(close.Name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNoSuchCursor, close.Name)
+	}
+
+	delete(sess.cursors, close.Name)
+	return nil, c.close()
+}
+
+func (sess *Session) cursor(name string) (*cursor, bool) {
+	if sess == nil {
+		return nil, false
+	}
+
+	c, ok := sess.cursors[name]
+	return c, ok
+}
+
+// Close all the cursors of the session, it has to be called once the session is over
+func (sess *Session) CloseCursors() {
+	for name, c := range sess.cursors {
+		c.close()
+		delete(sess.cursors, name)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -16,51 +18,1601 @@ var (
 	ErrTableDoesNotExist = errors.New("table does not exist")
 	ErrNoSuchTable       = errors.New("no table with such name")
 	ErrUnhandledQuery    = errors.New("unhandled query")
+	ErrQueryCancelled    = errors.New("query cancelled")
+	ErrStatementTimeout  = fmt.Errorf("%w: statement timeout exceeded", ErrQueryCancelled)
+	ErrTableDropped      = errors.New("table was dropped during the query")
+	ErrScanInterrupted   = errors.New("scan was interrupted by rewrite of the table")
+	ErrReadOnly          = errors.New("database is read-only")
+	ErrInvalidName       = errors.New("invalid name")
+	ErrDivisionByZero    = errors.New("division by zero")
+
+	ErrDatabaseAlreadyExist = errors.New("database with such name already exist")
+	ErrNoSuchDatabase       = errors.New("no database with such name")
 )
 
-type Result struct {
-	Schema Schema
-	Rows   <-chan Row
+type Result struct {
+	Schema Schema
+	Rows   *Rows // nil for statements which don't return rows
+
+	// pages of the table scanned by the query so far, nil if it doesn't scan one
+	Progress *ScanProgress
+
+	// last value generated for auto-increment column by insert
+	LastInsertID int64
+
+	// number of rows inserted or deleted by the statement
+	RowsAffected int64
+
+	// destination of the rows streamed by the client for copy from stdin
+	CopyIn *CopyIn
+}
+
+const MetadataFilename string = "metadata.json"
+
+// Set of tables of a single logical database
+type Catalog struct {
+	// read-only
+	dataDir  string
+	storage  string
+	readOnly bool // nothing is written to dataDir
+	// key of encrypted tables, nil if there is none
+	encryptionKey []byte
+
+	// protects tables, stats, views, grants, triggers and procedures maps
+	m      sync.RWMutex
+	tables map[string]*Table
+	stats  map[string]*TableStats
+	views  map[string]*View
+	grants map[string]map[string]Privileges // by user and table
+	// triggers by name
+	triggers map[string]*Trigger
+	// procedures by name
+	procedures map[string]*Procedure
+
+	// serializes writes of the metadata file, which can happen under read lock
+	metadataM sync.Mutex
+}
+
+// Metadata of a single table, schema is inlined for compatibility
+// with metadata files containing only schemas
+type tableMetadata struct {
+	Schema
+	TableOptions
+	AutoIncrement int64 `json:"auto_increment,omitempty"`
+
+	// written only on clean shutdown, otherwise rows are counted when the table is opened
+	RowCount *int64 `json:"row_count,omitempty"`
+
+	Indexes []IndexMetadata `json:"indexes,omitempty"`
+}
+
+// Returns position of the index in meta.Indexes, -1 if there is no such index
+func (meta *tableMetadata) indexPosition(name string) int {
+	for i := range meta.Indexes {
+		if meta.Indexes[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Open catalog stored in dataDir, tables use the given file storage.
+// Read-only catalog doesn't modify its files, even to recover from a crash
+func OpenCatalog(dataDir string, storage string, readOnly bool) (*Catalog, error) {
+	return OpenEncryptedCatalog(dataDir, storage, readOnly, nil)
+}
+
+// Open catalog, which can have tables encrypted with the key, see
+// LoadEncryptionKey(). Tables can't be encrypted if the key is nil
+func OpenEncryptedCatalog(dataDir string, storage string, readOnly bool, key []byte) (*Catalog, error) {
+	catalog := &Catalog{
+		dataDir:       dataDir,
+		storage:       storage,
+		readOnly:      readOnly,
+		encryptionKey: key,
+		tables:        make(map[string]*Table),
+		stats:         make(map[string]*TableStats),
+		views:         make(map[string]*View),
+		grants:        make(map[string]map[string]Privileges),
+		triggers:      make(map[string]*Trigger),
+		procedures:    make(map[string]*Procedure),
+	}
+
+	err := checkFormatVersion(dataDir, readOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	err = catalog.loadStatistics()
+	if err != nil {
+		return nil, err
+	}
+
+	err = catalog.loadViews()
+	if err != nil {
+		return nil, err
+	}
+
+	err = catalog.loadGrants()
+	if err != nil {
+		return nil, err
+	}
+
+	err = catalog.loadTriggers()
+	if err != nil {
+		return nil, err
+	}
+
+	err = catalog.loadProcedures()
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := readMetadata(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := catalog.recoverDDL(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if !readOnly {
+		for name, meta := range metadata {
+			err = catalog.removeShadowFiles(name, &meta)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for name, meta := range metadata {
+		meta.TableOptions.Storage = storage
+		meta.TableOptions.ReadOnly = readOnly
+		if meta.Encryption != EncryptionNone {
+			meta.TableOptions.EncryptionKey = key
+		}
+		table, err := OpenTable(filepath.Join(dataDir, name), meta.Schema, meta.TableOptions)
+		if err != nil {
+			return nil, fmt.Errorf("table %v: %w", name, err)
+		}
+		catalog.tables[name] = table
+
+		// index files are complete only after clean shutdown, indexes of memory tables are built empty
+		for _, index := range meta.Indexes {
+			rebuild := meta.RowCount == nil || meta.Engine == EngineMemory
+			_, err = table.OpenIndex(catalog.indexPath(name, index.Name), index, rebuild)
+			if err != nil {
+				return nil, fmt.Errorf("index %v: %w", index.Name, err)
+			}
+		}
+
+		// memory tables start empty
+		if meta.Engine == EngineMemory {
+			delete(catalog.stats, name)
+			continue
+		}
+		table.autoIncrement = meta.AutoIncrement
+
+		if meta.RowCount != nil {
+			table.rowCount = *meta.RowCount
+			// counters are stale once the tables are modified, until the next clean shutdown
+			changed = true
+		} else {
+			table.rowCount, err = table.countRows()
+			if err != nil {
+				return nil, fmt.Errorf("table %v: %w", name, err)
+			}
+		}
+	}
+
+	if readOnly {
+		return catalog, nil
+	}
+
+	if changed {
+		err = catalog.saveMetadata()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = os.Remove(filepath.Join(dataDir, JournalFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return catalog, nil
+}
+
+func (catalog *Catalog) Close() error {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	for _, table := range catalog.tables {
+		err := table.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if catalog.readOnly {
+		return nil
+	}
+
+	// row counters are valid only once all the rows are synced
+	return catalog.writeMetadata(true)
+}
+
+// Encoded metadata of all the tables, including row counters if rowCounts is true
+func (catalog *Catalog) metadata(rowCounts bool) ([]byte, error) {
+	metadata := make(map[string]tableMetadata)
+	for name, table := range catalog.tables {
+		meta := tableMetadata{
+			Schema:        table.schema,
+			TableOptions:  table.options,
+			AutoIncrement: table.lastAutoIncrement(),
+			Indexes:       table.indexMetadata(),
+		}
+		if rowCounts {
+			count := table.RowCount()
+			meta.RowCount = &count
+		}
+		metadata[name] = meta
+	}
+
+	return json.Marshal(metadata)
+}
+
+// Read metadata of the tables of the catalog in dataDir, there are none if it's new
+func readMetadata(dataDir string) (map[string]tableMetadata, error) {
+	metadata := make(map[string]tableMetadata)
+	data, err := ioutil.ReadFile(filepath.Join(dataDir, MetadataFilename))
+	if err == nil {
+		err = json.Unmarshal(data, &metadata)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// catalog.m should be at least read-locked
+func (catalog *Catalog) saveMetadata() error {
+	return catalog.writeMetadata(false)
+}
+
+func (catalog *Catalog) writeMetadata(rowCounts bool) error {
+	catalog.metadataM.Lock()
+	defer catalog.metadataM.Unlock()
+
+	data, err := catalog.metadata(rowCounts)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(catalog.dataDir, MetadataFilename), data)
+}
+
+func (catalog *Catalog) loadStatistics() error {
+	data, err := ioutil.ReadFile(filepath.Join(catalog.dataDir, StatisticsFilename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &catalog.stats)
+}
+
+func (catalog *Catalog) saveStatistics() error {
+	data, err := json.Marshal(catalog.stats)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(catalog.dataDir, StatisticsFilename), data)
+}
+
+// Names of tables, indexes and databases are parts of the paths of their files,
+// so names of any created objects can't have dots, path separators or NUL
+func validateName(name string) error {
+	if name == "" || strings.ContainsAny(name, "./\\\x00") {
+		return fmt.Errorf("%w %q: names can't contain dots, slashes or NUL", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// Create a new table with the schema
+func (catalog *Catalog) CreateTable(name string, schema Schema, opts TableOptions) (*Table, error) {
+	if catalog.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	err := validateName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	_, ok := catalog.tables[name]
+	if ok {
+		return nil, ErrTableAlreadyExist
+	}
+
+	_, ok = catalog.views[name]
+	if ok {
+		return nil, ErrViewAlreadyExist
+	}
+
+	err = schema.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = catalog.beginDDL(ddlOp{Op: ddlCreateTable, Table: name})
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Storage = catalog.storage
+	if opts.Encryption != EncryptionNone {
+		opts.EncryptionKey = catalog.encryptionKey
+	}
+	table, err := NewTable(filepath.Join(catalog.dataDir, name), schema, opts)
+	if err != nil {
+		catalog.commitDDL()
+		return nil, err
+	}
+
+	catalog.tables[name] = table
+	err = catalog.saveMetadata()
+	if err != nil {
+		delete(catalog.tables, name)
+		table.Close()
+		if opts.Engine != EngineMemory {
+			os.Remove(table.storage.Name())
+		}
+		if table.dict != nil && table.dict.path != "" {
+			os.Remove(table.dict.path)
+		}
+		catalog.commitDDL()
+		return nil, err
+	}
+
+	return table, catalog.commitDDL()
+}
+
+// Get table by name
+// NOTE: returned table becomes invalid once it is dropped
+func (catalog *Catalog) Table(name string) (*Table, error) {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	table, ok := catalog.tables[name]
+	if !ok {
+		return nil, ErrNoSuchTable
+	}
+	return table, nil
+}
+
+func (catalog *Catalog) doCreate(create *Create) (*Result, error) {
+	opts := TableOptions{
+		Compression: create.Compression,
+		Engine:      create.Engine,
+		BloomFilter: create.BloomFilter,
+		Dictionary:  create.Dictionary,
+		Encryption:  create.Encryption,
+	}
+	if create.TTL != nil {
+		opts.TTL = create.TTL.Duration
+		opts.TTLColumn = create.TTL.Column
+	}
+	if opts.Compression == "none" {
+		opts.Compression = CompressionNone
+	}
+	if opts.Engine == "disk" {
+		opts.Engine = EngineDisk
+	}
+	if opts.Encryption == "none" {
+		opts.Encryption = EncryptionNone
+	}
+
+	_, err := catalog.CreateTable(create.Table, NewSchema(create.Fields), opts)
+	return nil, err
+}
+
+func (catalog *Catalog) doDrop(drop *Drop) (*Result, error) {
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	table, ok := catalog.tables[drop.Table]
+	if !ok {
+		return nil, catalog.tableError(drop.Table, ErrTableDoesNotExist)
+	}
+	if table.capture != nil {
+		return nil, ErrTableBeingAltered
+	}
+
+	ops := []ddlOp{{Op: ddlDropTable, Table: drop.Table}}
+	for _, index := range table.indexes {
+		ops = append(ops, ddlOp{Op: ddlDropIndex, Table: drop.Table, Index: index.name})
+	}
+
+	err := catalog.beginDDL(ops...)
+	if err != nil {
+		return nil, err
+	}
+
+	// once metadata is saved the drop is committed, recovery removes the file
+	// if it's interrupted later
+	delete(catalog.tables, drop.Table)
+	err = catalog.saveMetadata()
+	if err != nil {
+		catalog.tables[drop.Table] = table
+		catalog.commitDDL()
+		return nil, err
+	}
+
+	// selects release the catalog lock before their rows are read
+	table.stopScans(ErrTableDropped)
+
+	filename := table.storage.Name()
+	// FIXME: this flushes all caches to disk, which is unnecessary
+	//        because we are going to delete the file anyway
+	err = table.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if table.options.Engine != EngineMemory {
+		err = os.Remove(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, index := range table.indexes {
+			err = os.Remove(index.path)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if table.dict != nil {
+			err = os.Remove(table.dict.path)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	_, hasStats := catalog.stats[drop.Table]
+	if hasStats {
+		delete(catalog.stats, drop.Table)
+		err = catalog.saveStatistics()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = catalog.dropGrants(drop.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	err = catalog.dropTriggers(drop.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, catalog.commitDDL()
+}
+
+func (catalog *Catalog) doTruncate(truncate *Truncate) (*Result, error) {
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	table, ok := catalog.tables[truncate.Table]
+	if !ok {
+		return nil, catalog.tableError(truncate.Table, ErrNoSuchTable)
+	}
+	if table.capture != nil {
+		return nil, ErrTableBeingAltered
+	}
+
+	if table.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	// pages of the table are released under the selects still reading them
+	table.stopScans(ErrScanInterrupted)
+
+	deleted := table.RowCount()
+	err := table.Truncate()
+	if err != nil {
+		return nil, err
+	}
+
+	// statistics describe rows which are gone
+	_, hasStats := catalog.stats[truncate.Table]
+	if hasStats {
+		delete(catalog.stats, truncate.Table)
+		err = catalog.saveStatistics()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Result{RowsAffected: deleted}, nil
+}
+
+// Compact the table: its rows are copied into a new table, which replaces it
+// the same way as the new table of an alter. So a vacuum interrupted by a crash
+// is either undone or finished when the catalog is opened, and scans of the
+// table go on reading its old files
+func (catalog *Catalog) doVacuum(vacuum *Vacuum) (*Result, error) {
+	// nothing is inserted while the rows are copied
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	table, ok := catalog.tables[vacuum.Table]
+	if !ok {
+		return nil, catalog.tableError(vacuum.Table, ErrNoSuchTable)
+	}
+	if table.capture != nil {
+		return nil, ErrTableBeingAltered
+	}
+	if table.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	alter, err := catalog.newShadow(vacuum.Table, table, table.schema, table.options, func(row Row) Row { return row })
+	if err != nil {
+		return nil, err
+	}
+
+	err = alter.backfill(context.Background())
+	if err != nil {
+		alter.discard()
+		return nil, err
+	}
+
+	// statistics are dropped with the old table
+	_, hasStats := catalog.stats[vacuum.Table]
+	err = catalog.swapTables(alter)
+	if err != nil || !hasStats {
+		return nil, err
+	}
+
+	stats, err := catalog.tables[vacuum.Table].Analyze()
+	if err != nil {
+		return nil, err
+	}
+
+	catalog.stats[vacuum.Table] = stats
+	return nil, catalog.saveStatistics()
+}
+
+func (catalog *Catalog) doInsert(insert *Insert) (*Result, error) {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	table, ok := catalog.tables[insert.Table]
+	if !ok {
+		return nil, catalog.tableError(insert.Table, ErrNoSuchTable)
+	}
+
+	rows, generate, err := insertRows(insert, &table.schema)
+	if err != nil {
+		return nil, err
+	}
+
+	lastID, err := catalog.insertInto(insert.Table, table, rows, generate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{LastInsertID: lastID, RowsAffected: int64(len(rows))}, nil
+}
+
+// Typecheck rows in the order of table columns and insert them, firing triggers
+// of the table, returns the last generated value of auto-increment column if
+// generate is set
+// catalog.m should be locked
+func (catalog *Catalog) insertInto(name string, table *Table, rows []Row, generate bool) (int64, error) {
+	return catalog.insertNested(name, table, rows, generate, 0)
+}
+
+// Same as insertInto(), depth is the number of triggers the insert is made by
+// catalog.m should be locked
+func (catalog *Catalog) insertNested(name string, table *Table, rows []Row, generate bool, depth int) (int64, error) {
+	err := table.schema.TypecheckRows(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	before, after, err := catalog.planTriggers(name, &table.schema)
+	if err != nil {
+		return 0, err
+	}
+
+	lastID, err := table.fillAutoIncrement(rows, generate)
+	if err != nil {
+		return 0, err
+	}
+
+	if table.schema.AutoIncrementField() != -1 {
+		// persist the counter before rows are visible, so that values are never reused
+		err = catalog.saveMetadata()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(before) != 0 {
+		err = fireBefore(before, rows)
+		if err != nil {
+			return 0, err
+		}
+
+		// values set by the triggers are checked the same way
+		err = table.schema.TypecheckRows(rows)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	return lastID, catalog.fireAfter(after, rows, depth)
+}
+
+// Same as insertInto(), but the rows are inserted by Table.InsertBatch(), so
+// rows which fail don't stop the others. Returns status of each row, or the error
+// failing the whole batch, the rows are inserted already if after triggers fail
+// catalog.m should be locked
+func (catalog *Catalog) insertBatch(name string, table *Table, rows []Row) ([]RowStatus, error) {
+	before, after, err := catalog.planTriggers(name, &table.schema)
+	if err != nil {
+		return nil, err
+	}
+
+	// invalid rows are skipped before values of auto-increment column are taken from them
+	statuses := make([]RowStatus, len(rows))
+	positions := make([]int, 0, len(rows))
+	valid := make([]Row, 0, len(rows))
+	for i, row := range rows {
+		err := table.schema.Typecheck(row)
+		if err != nil {
+			statuses[i].Err = err
+			continue
+		}
+		positions = append(positions, i)
+		valid = append(valid, row)
+	}
+
+	_, err = table.fillAutoIncrement(valid, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if table.schema.AutoIncrementField() != -1 {
+		err = catalog.saveMetadata()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(before) != 0 {
+		err = fireBefore(before, valid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// values set by the triggers are checked by the table
+	inserted := make([]Row, 0, len(valid))
+	for i, status := range table.InsertBatch(valid) {
+		statuses[positions[i]] = status
+		if status.Inserted {
+			inserted = append(inserted, valid[i])
+		}
+	}
+
+	return statuses, catalog.fireAfter(after, inserted, 0)
+}
+
+// Arrange values of the insert in the order of table columns, returns true
+// if values of auto-increment column are omitted and have to be generated
+func insertRows(insert *Insert, schema *Schema) ([]Row, bool, error) {
+	rows := ConvertRows(insert.Rows)
+	if len(insert.Columns) == 0 {
+		return rows, false, nil
+	}
+
+	indexes, generate, err := insertColumns(insert.Columns, schema)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for i, row := range rows {
+		if len(row) != len(indexes) {
+			return nil, false, fmt.Errorf("%w: row #%d has %v values, expected %v", ErrTypeMismatch, i, len(row), len(indexes))
+		}
+
+		full := make(Row, len(schema.Fields))
+		for j, idx := range indexes {
+			full[idx] = row[j]
+		}
+		rows[i] = full
+	}
+
+	return rows, generate, nil
+}
+
+// Indexes of the columns given values by the insert in the table, returns true
+// if values of auto-increment column are omitted and have to be generated
+func insertColumns(columns []string, schema *Schema) ([]int, bool, error) {
+	indexes := make([]int, 0, len(columns))
+	given := make([]bool, len(schema.Fields))
+	for _, name := range columns {
+		idx, _ := schema.GetField(name)
+		if idx == -1 {
+			return nil, false, fmt.Errorf("no column named %v in table", name)
+		}
+
+		if given[idx] {
+			return nil, false, fmt.Errorf("column %v is specified more than once", name)
+		}
+
+		given[idx] = true
+		indexes = append(indexes, idx)
+	}
+
+	generate := false
+	for idx, field := range schema.Fields {
+		if given[idx] {
+			continue
+		}
+
+		if !field.AutoIncrement {
+			return nil, false, fmt.Errorf("%w: no value for column %v", ErrConstraintViolation, field.Name)
+		}
+		generate = true
+	}
+
+	return indexes, generate, nil
+}
+
+func exprType(expr *BinOpTree, schema *Schema) (TypeID, error) {
+	switch {
+	case expr.call != nil:
+		fn, err := LookupFunction(expr.call.Name)
+		if err != nil {
+			return TypeInt, err
+		}
+
+		args := make([]TypeID, 0, len(expr.call.Args))
+		for _, arg := range expr.call.Args {
+			t, err := exprType(arg, schema)
+			if err != nil {
+				return t, err
+			}
+			args = append(args, t)
+		}
+
+		t, err := fn.Typecheck(args)
+		if err != nil {
+			return t, fmt.Errorf("%v(): %w", expr.call.Name, err)
+		}
+		return t, nil
+	case expr.val != nil:
+		switch {
+		case expr.val.Const != nil:
+			switch {
+			case expr.val.Const.Float != nil:
+				return TypeFloat, nil
+			case expr.val.Const.Int != nil:
+				return IntegerValue(*expr.val.Const.Int).TypeID, nil
+			case expr.val.Const.Bool != nil:
+				return TypeBool, nil
+			case expr.val.Const.Str != nil:
+				return TypeVarchar, nil
+			}
+		case expr.val.Field != nil:
+			idx, field := schema.GetField(expr.val.Field.Name)
+			if idx == -1 {
+				return TypeInt, fmt.Errorf("no field named %v in table", expr.val.Field)
+			}
+
+			return field.TypeID, nil
+		case expr.val.Subexpr != nil:
+			panic("subexpr should always be nil")
+		default:
+			panic("empty value node")
+		}
+	case expr.subtree != nil && expr.subtree.Op.IsUnary():
+		operand, err := exprType(expr.subtree.Left, schema)
+		if err != nil {
+			return operand, err
+		}
+
+		op := expr.subtree.Op
+		if op == OpNot && operand != TypeBool {
+			return TypeInt, fmt.Errorf("%w: not requires bool operand, got %v", ErrTypeMismatch, operand)
+		}
+
+		if op == OpNeg && !operand.IsNumeric() {
+			return TypeInt, fmt.Errorf("%w: unary minus requires numeric operand, got %v", ErrTypeMismatch, operand)
+		}
+
+		return operand, nil
+	case expr.subtree != nil && expr.subtree.Op == OpIn:
+		left, err := exprType(expr.subtree.Left, schema)
+		if err != nil {
+			return left, err
+		}
+
+		for _, item := range expr.subtree.List {
+			t, err := exprType(item, schema)
+			if err != nil {
+				return t, err
+			}
+
+			if !Comparable(t, left) {
+				return TypeInt, fmt.Errorf("%w: in list, left is %v, list item is %v", ErrTypeMismatch, left, t)
+			}
+		}
+
+		return TypeBool, nil
+	case expr.subtree != nil:
+		left, err := exprType(expr.subtree.Left, schema)
+		if err != nil {
+			return left, err
+		}
+
+		right, err := exprType(expr.subtree.Right, schema)
+		if err != nil {
+			return right, err
+		}
+
+		op := expr.subtree.Op
+		if !Comparable(left, right) {
+			return TypeInt, fmt.Errorf("%w: %v op, left is %v, right is %v", ErrTypeMismatch, op, left, right)
+		}
+
+		if op == OpLike && left != TypeVarchar {
+			return TypeInt, fmt.Errorf("%w: like requires varchar operands, got %v", ErrTypeMismatch, left)
+		}
+
+		isArithmetic := op.IsArithmetic()
+		isStrConcat := op == OpAdd && left == TypeVarchar
+		if isArithmetic && !isStrConcat && !left.IsNumeric() {
+			return TypeInt, fmt.Errorf("%w: attempt to perform arithmetic op %v on type %v", ErrTypeMismatch, op, left)
+		}
+
+		if isStrConcat {
+			return TypeVarchar, nil
+		} else if isArithmetic {
+			if left == TypeFloat || right == TypeFloat {
+				return TypeFloat, nil
+			}
+			if left == TypeBigint || right == TypeBigint {
+				return TypeBigint, nil
+			}
+			return TypeInt, nil
+		} else {
+			// logic op otherwise
+			return TypeBool, nil
+		}
+	}
+
+	return TypeInt, fmt.Errorf("unhandled expr: %v", expr)
+}
+
+// |expr| should be typechecked before calling this function, errors are only
+// the ones of evaluation, e.g. division by zero
+func evalExpr(expr *BinOpTree, fieldToIdx map[string]int, row Row) (Value, error) {
+	switch {
+	case expr.call != nil:
+		fn, err := LookupFunction(expr.call.Name)
+		if err != nil {
+			panic("unknown function")
+		}
+
+		args := make([]Value, 0, len(expr.call.Args))
+		for _, arg := range expr.call.Args {
+			value, err := evalExpr(arg, fieldToIdx, row)
+			if err != nil {
+				return Value{}, err
+			}
+			args = append(args, value)
+		}
+		return fn.Eval(args), nil
+	case expr.val != nil:
+		switch {
+		case expr.val.Const != nil:
+			switch {
+			case expr.val.Const.Float != nil:
+				return FloatValue(*expr.val.Const.Float), nil
+			case expr.val.Const.Int != nil:
+				return IntegerValue(*expr.val.Const.Int), nil
+			case expr.val.Const.Bool != nil:
+				return Value{
+					TypeID: TypeBool,
+					Int:    expr.val.Const.Bool.ToInt(),
+				}, nil
+			case expr.val.Const.Str != nil:
+				return Value{
+					TypeID: TypeVarchar,
+					Str:    *expr.val.Const.Str,
+				}, nil
+			}
+		case expr.val.Field != nil:
+			idx, ok := fieldToIdx[expr.val.Field.Name]
+			if !ok {
+				panic("unknown field")
+			}
+			return row[idx], nil
+		case expr.val.Subexpr != nil:
+			panic("subexpr should always be nil")
+		default:
+			panic("empty value node")
+		}
+	case expr.subtree != nil && expr.subtree.Op.IsUnary():
+		operand, err := evalExpr(expr.subtree.Left, fieldToIdx, row)
+		if err != nil {
+			return Value{}, err
+		}
+		return expr.subtree.Op.Apply(operand, Value{})
+	case expr.subtree != nil && expr.subtree.Op == OpIn:
+		left, err := evalExpr(expr.subtree.Left, fieldToIdx, row)
+		if err != nil {
+			return Value{}, err
+		}
+
+		for _, item := range expr.subtree.List {
+			right, err := evalExpr(item, fieldToIdx, row)
+			if err != nil {
+				return Value{}, err
+			}
+
+			eq, err := OpEq.Apply(left, right)
+			if err != nil {
+				return Value{}, err
+			}
+			if eq.Int != 0 {
+				return Value{TypeID: TypeBool, Int: 1}, nil
+			}
+		}
+		return Value{TypeID: TypeBool, Int: 0}, nil
+	case expr.subtree != nil:
+		left, err := evalExpr(expr.subtree.Left, fieldToIdx, row)
+		if err != nil {
+			return Value{}, err
+		}
+
+		op := expr.subtree.Op
+		if result, ok := op.shortCircuit(left); ok {
+			return result, nil
+		}
+
+		right, err := evalExpr(expr.subtree.Right, fieldToIdx, row)
+		if err != nil {
+			return Value{}, err
+		}
+		return op.Apply(left, right)
+	}
+
+	panic("unhandled binop node")
+}
+
+// Check that qualified column references use the qualifier of the table,
+// empty qualifier means that columns can't be qualified
+func checkQualifier(ref *ColumnRef, qualifier string) error {
+	if ref.Table != "" && ref.Table != qualifier {
+		return fmt.Errorf("unknown table %v in column reference %v", ref.Table, ref)
+	}
+	return nil
+}
+
+func checkQualifiers(expr *BinOpTree, qualifier string) error {
+	switch {
+	case expr.val != nil && expr.val.Field != nil:
+		return checkQualifier(expr.val.Field, qualifier)
+	case expr.call != nil:
+		for _, arg := range expr.call.Args {
+			err := checkQualifiers(arg, qualifier)
+			if err != nil {
+				return err
+			}
+		}
+	case expr.subtree != nil:
+		children := append([]*BinOpTree{expr.subtree.Left, expr.subtree.Right}, expr.subtree.List...)
+		for _, child := range children {
+			if child == nil {
+				continue
+			}
+
+			err := checkQualifiers(child, qualifier)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Mark the columns of the schema used by the expression
+func exprColumns(expr *BinOpTree, schema *Schema, columns []bool) {
+	switch {
+	case expr.val != nil && expr.val.Field != nil:
+		idx, _ := schema.GetField(expr.val.Field.Name)
+		if idx != -1 {
+			columns[idx] = true
+		}
+	case expr.call != nil:
+		for _, arg := range expr.call.Args {
+			exprColumns(arg, schema, columns)
+		}
+	case expr.subtree != nil:
+		children := append([]*BinOpTree{expr.subtree.Left, expr.subtree.Right}, expr.subtree.List...)
+		for _, child := range children {
+			if child != nil {
+				exprColumns(child, schema, columns)
+			}
+		}
+	}
+}
+
+// Build row filter for where clause, and predicates which can be checked before decoding rows
+// Columns can be qualified with |qualifier|, see checkQualifier()
+func planFilter(where *Expression, schema *Schema, qualifier string) (func(Row) (bool, error), []ColumnPredicate, error) {
+	filterTree := where.ToBinOp()
+	err := checkQualifiers(filterTree, qualifier)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t, err := exprType(filterTree, schema)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if t != TypeBool {
+		return nil, nil, errors.New("where clause expression should eval to bool")
+	}
+
+	fieldToIdx := make(map[string]int)
+	fields := schema.ColumnNames()
+	for i, name := range fields {
+		fieldToIdx[name] = i
+	}
+
+	filter := func(row Row) (bool, error) {
+		value, err := evalExpr(filterTree, fieldToIdx, row)
+		return value.Int != 0, err
+	}
+
+	return filter, ExtractPredicates(filterTree, schema), nil
+}
+
+type selectPlan struct {
+	query *Select
+
+	// rows are scanned either from the table, or from the plan of the view
+	table  *Table
+	source *selectPlan
+
+	schema     Schema
+	predicates []ColumnPredicate
+	index      *indexScan // nil for the full scan of the table
+	columns    []bool     // columns of the table used by the query, nil for all of them
+	filter     func(Row) (bool, error)
+	project    func(Row) Row
+	distinct   bool
+
+	// sort of the rows, nil if they aren't sorted or the index returns them in order.
+	// Rows are sorted after the projection if sortProjected is set, before it otherwise
+	less          func(a, b Row) bool
+	sortProjected bool
+	// max number of rows in the result, -1 if there is no limit
+	limit int64
+
+	// estimated number of rows in result, -1 if there are no statistics
+	estimate float64
+}
+
+// catalog.m should be at least read-locked
+func (catalog *Catalog) planSelect(q *Select) (*selectPlan, error) {
+	return catalog.planSelectFrom(q, nil)
+}
+
+// Plan the select, views is the chain of views being expanded, see planView()
+func (catalog *Catalog) planSelectFrom(q *Select, views []string) (*selectPlan, error) {
+	plan := &selectPlan{
+		query: q,
+		filter: func(row Row) (bool, error) {
+			return true, nil
+		},
+		project: func(row Row) Row {
+			return row
+		},
+		distinct: q.Distinct,
+		estimate: -1,
+	}
+
+	table, ok := catalog.tables[q.Table]
+	if ok {
+		plan.table = table
+		plan.schema = table.schema
+	} else {
+		source, err := catalog.planView(q.Table, views)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.source = source
+		plan.schema = source.schema
+	}
+	input := plan.schema
+
+	if q.Where != nil {
+		var err error
+		plan.filter, plan.predicates, err = planFilter(q.Where, &input, q.Qualifier())
+		if err != nil {
+			return nil, err
+		}
+
+		// page filters apply to stored rows only
+		if plan.source != nil {
+			plan.predicates = nil
+		}
+	}
+
+	stats, ok := catalog.stats[q.Table]
+	if ok && plan.table != nil {
+		plan.estimate = stats.OrderPredicates(plan.predicates, table.RowCount())
+	}
+
+	if plan.table != nil {
+		plan.index = chooseIndex(table, plan.predicates, catalog.stats[q.Table])
+	}
+
+	if !q.Projection.All {
+		names := make([]string, 0, len(q.Projection.Items))
+		for _, item := range q.Projection.Items {
+			err := checkQualifier(item.Column, q.Qualifier())
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, item.Column.Name)
+		}
+
+		newSchema, indexes, err := input.Project(names)
+		if err != nil {
+			return nil, err
+		}
+
+		// result columns are named by their aliases
+		for i, item := range q.Projection.Items {
+			newSchema.Fields[i].Name = item.Name()
+		}
+
+		plan.project = func(row Row) Row {
+			return row.Project(indexes)
+		}
+
+		if plan.table != nil {
+			plan.columns = make([]bool, len(input.Fields))
+			for _, idx := range indexes {
+				plan.columns[idx] = true
+			}
+
+			if q.Where != nil {
+				exprColumns(q.Where.ToBinOp(), &input, plan.columns)
+			}
+		}
+
+		plan.schema = newSchema
+	}
+
+	err := plan.planOrder(q, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// Start execution of the plan, progress of the table scan is counted in progress
+func (plan *selectPlan) rows(ctx context.Context, progress *ScanProgress) *Rows {
+	// rows sorted before the projection are projected once they are limited
+	project := plan.project
+	sortInput := plan.less != nil && !plan.sortProjected
+	if sortInput {
+		project = func(row Row) Row {
+			return row
+		}
+	}
+
+	var rows *Rows
+	if plan.source != nil {
+		rows = FilterRows(ctx, plan.source.rows(ctx, progress), plan.filter, project)
+	} else if plan.index != nil {
+		rows = plan.index.rows(ctx, plan.table, plan.filter, project, progress)
+	} else {
+		rows = FullScan(ctx, plan.table, plan.predicates, plan.columns, plan.filter, project, progress)
+	}
+
+	memLimit, tempDir := queryWorkMemory(ctx)
+	if plan.distinct {
+		rows = DistinctRows(ctx, rows, memLimit, tempDir)
+	}
+	if plan.less != nil {
+		rows = SortRows(ctx, rows, plan.less, memLimit, tempDir)
+	}
+	if plan.limit >= 0 {
+		rows = LimitRows(ctx, rows, plan.limit)
+	}
+	if sortInput {
+		rows = FilterRows(ctx, rows, func(row Row) (bool, error) {
+			return true, nil
+		}, plan.project)
+	}
+	return rows
+}
+
+func (catalog *Catalog) doSelect(ctx context.Context, q *Select) (*Result, error) {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	plan, err := catalog.planSelect(q)
+	if err != nil {
+		return nil, err
+	}
+
+	result := Result{
+		Schema:   plan.schema,
+		Progress: &ScanProgress{},
+	}
+	result.Rows = plan.rows(ctx, result.Progress)
+	result.Rows.schema = &result.Schema
+
+	return &result, nil
+}
+
+func (catalog *Catalog) doExplain(explain *Explain) (*Result, error) {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	plan, err := catalog.planSelect(explain.Select)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := catalog.explainPlan(plan, "")
+
+	var schema Schema
+	schema.addField(Field{
+		Name:   "plan",
+		TypeID: TypeVarchar,
+		Len:    255,
+	})
+
+	rows := make([]Row, 0, len(lines))
+	for _, line := range lines {
+		rows = append(rows, Row{Value{
+			TypeID: TypeVarchar,
+			Str:    line,
+		}})
+	}
+
+	return &Result{
+		Schema: schema,
+		Rows:   StaticRows(rows),
+	}, nil
 }
 
-const MetadataFilename string = "metadata.json"
+// Describe the plan, lines of the views' plans are indented under the view
+// catalog.m should be at least read-locked
+func (catalog *Catalog) explainPlan(plan *selectPlan, indent string) []string {
+	q := plan.query
+	var lines []string
+	if plan.source != nil {
+		lines = append(lines, fmt.Sprintf("%vscan of view %v", indent, q.Table))
+	} else if plan.index != nil {
+		scan := "index scan"
+		if plan.index.ordered {
+			scan = "ordered index scan"
+		}
+		lines = append(lines, fmt.Sprintf("%v%v of %v using %v", indent, scan, q.Table, plan.index.String()))
+	} else {
+		lines = append(lines, fmt.Sprintf("%vfull scan of %v", indent, q.Table))
+	}
+
+	// rows fetched by the index are checked by the row filter only
+	if plan.index == nil {
+		for _, p := range plan.predicates {
+			lines = append(lines, fmt.Sprintf("%v  page filter: %v", indent, p.String()))
+		}
+
+		if plan.table != nil {
+			// dictionary columns are compared by ids of their values
+			stored := plan.table.storedPredicates(plan.predicates)
+			for _, zp := range plan.table.zones.predicates(stored) {
+				lines = append(lines, fmt.Sprintf("%v  zone map: %v", indent, zp.predicate.String()))
+			}
+
+			for _, probe := range plan.table.bloom.probes(stored) {
+				lines = append(lines, fmt.Sprintf("%v  bloom filter: %v", indent, probe.predicate.String()))
+			}
+		}
+	}
+
+	if q.Where != nil {
+		lines = append(lines, indent+"  row filter: where clause")
+	}
+
+	if !q.Projection.All {
+		lines = append(lines, fmt.Sprintf("%v  project: %v", indent, plan.schema.ColumnNames()))
+	}
+
+	// other columns of columnar tables aren't read
+	if plan.index == nil && plan.columns != nil && plan.table.options.Engine == EngineColumnar {
+		var names []string
+		for i, field := range plan.table.schema.Fields {
+			if plan.columns[i] {
+				names = append(names, field.Name)
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%v  columns: %v", indent, names))
+	}
+
+	if plan.distinct {
+		lines = append(lines, indent+"  distinct")
+	}
+	lines = append(lines, plan.explainOrder(indent)...)
+
+	if plan.source != nil {
+		return append(lines, catalog.explainPlan(plan.source, indent+"  ")...)
+	}
+
+	stats, ok := catalog.stats[q.Table]
+	if ok {
+		lines = append(lines, fmt.Sprintf("%vtable stats: %v rows, %v pages (%v rows now)", indent, stats.Rows, stats.Pages, plan.table.RowCount()))
+		lines = append(lines, fmt.Sprintf("%vestimated rows: %.0f", indent, plan.estimate))
+	} else {
+		lines = append(lines, indent+"no statistics, run analyze to collect them")
+	}
+
+	return lines
+}
+
+func (catalog *Catalog) doAnalyze(analyze *Analyze) (*Result, error) {
+	catalog.m.RLock()
+	table, ok := catalog.tables[analyze.Table]
+	if !ok {
+		err := catalog.tableError(analyze.Table, ErrNoSuchTable)
+		catalog.m.RUnlock()
+		return nil, err
+	}
+
+	stats, err := table.Analyze()
+	catalog.m.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	if catalog.tables[analyze.Table] != table {
+		// table was dropped (and maybe re-created) while we were scanning it
+		return nil, ErrTableDoesNotExist
+	}
+
+	catalog.stats[analyze.Table] = stats
+	return nil, catalog.saveStatistics()
+}
+
+func (catalog *Catalog) doShowTables() (*Result, error) {
+	catalog.m.RLock()
+	names := make([]string, 0, len(catalog.tables))
+	for name := range catalog.tables {
+		names = append(names, name)
+	}
+	catalog.m.RUnlock()
+	sort.Strings(names)
+
+	var schema Schema
+	schema.addField(Field{Name: "table", TypeID: TypeVarchar, Len: 255})
+
+	rows := make([]Row, 0, len(names))
+	for _, name := range names {
+		rows = append(rows, Row{{TypeID: TypeVarchar, Str: name}})
+	}
+
+	return &Result{
+		Schema: schema,
+		Rows:   StaticRows(rows),
+	}, nil
+}
+
+func (catalog *Catalog) doShowTableStatus() (*Result, error) {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	names := make([]string, 0, len(catalog.tables))
+	for name := range catalog.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var schema Schema
+	schema.addField(Field{Name: "table", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "engine", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "rows", TypeID: TypeBigint, Len: 8})
+	schema.addField(Field{Name: "pages", TypeID: TypeBigint, Len: 8})
+
+	rows := make([]Row, 0, len(names))
+	for _, name := range names {
+		table := catalog.tables[name]
+		engine := table.options.Engine
+		if engine == EngineDisk {
+			engine = "disk"
+		}
+
+		rows = append(rows, Row{
+			{TypeID: TypeVarchar, Str: name},
+			{TypeID: TypeVarchar, Str: engine},
+			BigintValue(table.RowCount()),
+			BigintValue(table.pageCount()),
+		})
+	}
 
+	return &Result{
+		Schema: schema,
+		Rows:   StaticRows(rows),
+	}, nil
+}
+
+func (catalog *Catalog) doDescribe(describe *Describe) (*Result, error) {
+	catalog.m.RLock()
+	var fields []Field
+	table, ok := catalog.tables[describe.Table]
+	if ok {
+		fields = table.schema.Fields
+	} else {
+		// columns of the view are the ones of its select
+		plan, err := catalog.planView(describe.Table, nil)
+		if err != nil {
+			catalog.m.RUnlock()
+			return nil, err
+		}
+		fields = plan.schema.Fields
+	}
+	catalog.m.RUnlock()
+
+	var schema Schema
+	schema.addField(Field{Name: "column", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "type", TypeID: TypeVarchar, Len: 255})
+
+	rows := make([]Row, 0, len(fields))
+	for _, field := range fields {
+		typeName := field.TypeName()
+		if field.AutoIncrement {
+			typeName += " auto_increment"
+		}
+
+		rows = append(rows, Row{
+			{TypeID: TypeVarchar, Str: field.Name},
+			{TypeID: TypeVarchar, Str: typeName},
+		})
+	}
+
+	return &Result{
+		Schema: schema,
+		Rows:   StaticRows(rows),
+	}, nil
+}
+
+// Name of the database which is stored directly in the data directory
+const DefaultDatabase string = "main"
+
+// Set of logical databases stored in the same data directory
+// Default database lives in the data directory itself, others in its subdirectories
 type Database struct {
 	// read-only
-	dataDir string
+	dataDir  string
+	storage  string
+	readOnly bool
+	// key of encrypted tables, nil if there is none
+	encryptionKey []byte
 
-	// protects tables map
-	m      sync.RWMutex
-	tables map[string]*Table
+	// holds the lock of the data directory, nil for read-only databases
+	lock *os.File
+
+	// protects catalogs map
+	m        sync.RWMutex
+	catalogs map[string]*Catalog
+
+	// memory shared by the running queries, nil if it's not limited
+	memory *MemoryPool
+
+	// queries registered by TrackQuery()
+	processes *processList
+
+	// statements modifying the database are written to it, nil if auditing is disabled
+	audit *AuditLog
 }
 
 func NewDatabase(dataDir string) (*Database, error) {
+	return newDatabase(dataDir, StorageFile, false, nil)
+}
+
+func newDatabase(dataDir string, storage string, readOnly bool, key []byte) (*Database, error) {
+	err := validateStorage(storage)
+	if err != nil {
+		return nil, err
+	}
+
 	db := &Database{
-		dataDir: dataDir,
-		tables:  make(map[string]*Table),
+		dataDir:       dataDir,
+		storage:       storage,
+		readOnly:      readOnly,
+		encryptionKey: key,
+		catalogs:      make(map[string]*Catalog),
+		processes:     newProcessList(),
 	}
 
-	data, err := ioutil.ReadFile(filepath.Join(dataDir, MetadataFilename))
-	if os.IsNotExist(err) {
-		return db, nil
+	// read-only database doesn't write anything, so it can be opened while it's in use
+	if !readOnly {
+		db.lock, err = lockDataDir(dataDir)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	catalog, err := OpenEncryptedCatalog(dataDir, storage, readOnly, key)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
+	db.catalogs[DefaultDatabase] = catalog
 
-	var metadata map[string]Schema
-	err = json.Unmarshal(data, &metadata)
+	entries, err := ioutil.ReadDir(dataDir)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	for name, schema := range metadata {
-		table, err := OpenTable(filepath.Join(dataDir, name), schema)
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == DefaultDatabase {
+			continue
+		}
+
+		dir := filepath.Join(dataDir, entry.Name())
+		_, err := os.Stat(filepath.Join(dir, MetadataFilename))
+		if os.IsNotExist(err) {
+			// not a database directory
+			continue
+		}
+
+		catalog, err := OpenEncryptedCatalog(dir, storage, readOnly, key)
 		if err != nil {
+			db.Close()
 			return nil, err
 		}
-		db.tables[name] = table
+		db.catalogs[entry.Name()] = catalog
 	}
 
 	return db, nil
@@ -70,13 +1622,13 @@ func (db *Database) Close() error {
 	db.m.RLock()
 	defer db.m.RUnlock()
 
-	err := db.saveMetadata()
-	if err != nil {
-		return err
+	if db.lock != nil {
+		// released after all the files are synced
+		defer db.lock.Close()
 	}
 
-	for _, table := range db.tables {
-		err = table.Close()
+	for _, catalog := range db.catalogs {
+		err := catalog.Close()
 		if err != nil {
 			return err
 		}
@@ -85,268 +1637,258 @@ func (db *Database) Close() error {
 	return nil
 }
 
-func (db *Database) saveMetadata() error {
-	metadata := make(map[string]Schema)
-	for name, table := range db.tables {
-		metadata[name] = table.schema
+func (db *Database) catalog(name string) (*Catalog, error) {
+	db.m.RLock()
+	defer db.m.RUnlock()
+
+	catalog, ok := db.catalogs[name]
+	if !ok {
+		return nil, ErrNoSuchDatabase
 	}
+	return catalog, nil
+}
 
-	data, err := json.Marshal(metadata)
+func (db *Database) doCreateDatabase(create *CreateDatabase) (*Result, error) {
+	err := validateName(create.Name)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return ioutil.WriteFile(filepath.Join(db.dataDir, MetadataFilename), data, 0600)
-}
-
-func (db *Database) doCreate(create *Create) (*Result, error) {
 	db.m.Lock()
 	defer db.m.Unlock()
 
-	_, ok := db.tables[create.Table]
+	_, ok := db.catalogs[create.Name]
 	if ok {
-		return nil, ErrTableAlreadyExist
+		return nil, ErrDatabaseAlreadyExist
+	}
+
+	dir := filepath.Join(db.dataDir, create.Name)
+	err = os.Mkdir(dir, 0700)
+	if err != nil {
+		return nil, err
 	}
 
-	schema := NewSchema(create.Fields)
-	table, err := NewTable(create.Table, schema)
+	catalog, err := OpenEncryptedCatalog(dir, db.storage, db.readOnly, db.encryptionKey)
 	if err != nil {
+		os.RemoveAll(dir)
 		return nil, err
 	}
 
-	db.tables[create.Table] = table
-	err = db.saveMetadata()
+	// metadata file marks directory as a database
+	err = catalog.saveMetadata()
 	if err != nil {
-		delete(db.tables, create.Table)
+		os.RemoveAll(dir)
 		return nil, err
 	}
 
+	db.catalogs[create.Name] = catalog
 	return nil, nil
 }
 
-func (db *Database) doDrop(drop *Drop) (*Result, error) {
+func (db *Database) doDropDatabase(drop *DropDatabase) (*Result, error) {
+	if drop.Name == DefaultDatabase {
+		return nil, errors.New("default database can't be dropped")
+	}
+
 	db.m.Lock()
 	defer db.m.Unlock()
 
-	table, ok := db.tables[drop.Table]
+	catalog, ok := db.catalogs[drop.Name]
 	if !ok {
-		return nil, ErrTableDoesNotExist
+		return nil, ErrNoSuchDatabase
 	}
 
-	delete(db.tables, drop.Table)
-	filename := table.file.Name()
-	// FIXME: this flushes all caches to disk, which is unnecessary
-	//        because we are going to delete the file anyway
-	err := table.Close()
-	if err != nil {
-		return nil, err
+	delete(db.catalogs, drop.Name)
+
+	// selects release the catalog lock before their rows are read
+	catalog.m.Lock()
+	for _, table := range catalog.tables {
+		table.stopScans(ErrTableDropped)
 	}
+	catalog.m.Unlock()
 
-	err = os.Remove(filename)
+	err := catalog.Close()
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.saveMetadata()
-	return nil, err
+	return nil, os.RemoveAll(catalog.dataDir)
 }
 
-func (db *Database) doInsert(insert *Insert) (*Result, error) {
-	db.m.RLock()
-	defer db.m.RUnlock()
-
-	table, ok := db.tables[insert.Table]
-	if !ok {
-		return nil, ErrNoSuchTable
+func (db *Database) doUse(sess *Session, use *Use) (*Result, error) {
+	if sess == nil {
+		return nil, errors.New("use requires a session")
 	}
 
-	rows := ConvertRows(insert.Rows)
-	for i, row := range rows {
-		err := table.schema.Typecheck(row)
-		if err != nil {
-			return nil, fmt.Errorf("row #%d %v", i, err)
-		}
+	_, err := db.catalog(use.Database)
+	if err != nil {
+		return nil, err
 	}
 
-	err := table.Insert(rows)
-	return nil, err
+	sess.Database = use.Database
+	return nil, nil
 }
 
-func exprType(expr *BinOpTree, schema *Schema) (TypeID, error) {
-	switch {
-	case expr.val != nil:
-		switch {
-		case expr.val.Const != nil:
-			switch {
-			case expr.val.Const.Int != nil:
-				return TypeInt, nil
-			case expr.val.Const.Bool != nil:
-				return TypeBool, nil
-			case expr.val.Const.Str != nil:
-				return TypeVarchar, nil
-			}
-		case expr.val.Field != "":
-			idx, field := schema.GetField(expr.val.Field)
-			if idx == -1 {
-				return TypeInt, fmt.Errorf("no field named %v in table", expr.val.Field)
-			}
-
-			return field.TypeID, nil
-		case expr.val.Subexpr != nil:
-			panic("subexpr should always be nil")
-		default:
-			panic("empty value node")
-		}
-	case expr.subtree != nil:
-		left, err := exprType(expr.subtree.Left, schema)
-		if err != nil {
-			return left, err
-		}
-
-		right, err := exprType(expr.subtree.Right, schema)
-		if err != nil {
-			return right, err
-		}
-
-		op := expr.subtree.Op
-		if left != right {
-			return TypeInt, fmt.Errorf("%v op types mismatch: left is %v, right is %v", op, left, right)
-		}
-
-		isArithmetic := op.IsArithmetic()
-		isStrConcat := op == OpAdd && left == TypeVarchar
-		if isArithmetic && !isStrConcat && left != TypeInt {
-			return TypeInt, fmt.Errorf("attempt to perform arithmetic op %v on type %v", op, left)
-		}
+// Execute query in context of the session, sess can be nil,
+// in which case the default database is used
+func (db *Database) Execute(ctx context.Context, sess *Session, query *Query) (*Result, error) {
+	DefaultMetrics.QueryExecuted(QueryKind(query))
 
-		if isStrConcat {
-			return TypeVarchar, nil
-		} else if isArithmetic {
-			return TypeInt, nil
-		} else {
-			// logic op otherwise
-			return TypeBool, nil
-		}
+	database := sess.currentDatabase()
+	result, err := db.execute(ctx, sess, query)
+	if db.audit != nil && !IsReadOnlyQuery(query) {
+		db.auditQuery(sess, database, query, result, err)
 	}
-
-	return TypeInt, fmt.Errorf("unhandled expr: %v", expr)
+	return result, err
 }
 
-// |expr| should be typechecked before calling this function
-func evalExpr(expr *BinOpTree, fieldToIdx map[string]int, row Row) Value {
-	switch {
-	case expr.val != nil:
-		switch {
-		case expr.val.Const != nil:
-			switch {
-			case expr.val.Const.Int != nil:
-				return Value{
-					TypeID: TypeInt,
-					Int:    *expr.val.Const.Int,
-				}
-			case expr.val.Const.Bool != nil:
-				return Value{
-					TypeID: TypeBool,
-					Int:    expr.val.Const.Bool.ToInt(),
-				}
-			case expr.val.Const.Str != nil:
-				return Value{
-					TypeID: TypeVarchar,
-					Str:    *expr.val.Const.Str,
-				}
-			}
-		case expr.val.Field != "":
-			idx, ok := fieldToIdx[expr.val.Field]
-			if !ok {
-				panic("unknown field")
-			}
-			return row[idx]
-		case expr.val.Subexpr != nil:
-			panic("subexpr should always be nil")
-		default:
-			panic("empty value node")
-		}
-	case expr.subtree != nil:
-		left := evalExpr(expr.subtree.Left, fieldToIdx, row)
-		right := evalExpr(expr.subtree.Right, fieldToIdx, row)
-		op := expr.subtree.Op
-		return op.Apply(left, right)
+func (db *Database) execute(ctx context.Context, sess *Session, query *Query) (*Result, error) {
+	if db.readOnly && !IsReadOnlyQuery(query) {
+		return nil, ErrReadOnly
 	}
 
-	panic("unhandled binop node")
-}
-
-func (db *Database) doSelect(ctx context.Context, q *Select) (*Result, error) {
-	db.m.RLock()
-	defer db.m.RUnlock()
+	if sess.user() != "" && isAdminQuery(query) {
+		return nil, fmt.Errorf("%w: %v can't manage databases, queries and server files", ErrAccessDenied, sess.user())
+	}
 
-	table, ok := db.tables[q.Table]
-	if !ok {
-		return nil, ErrNoSuchTable
+	switch {
+	case query.CreateDatabase != nil:
+		return db.doCreateDatabase(query.CreateDatabase)
+	case query.DropDatabase != nil:
+		return db.doDropDatabase(query.DropDatabase)
+	case query.Use != nil:
+		return db.doUse(sess, query.Use)
+	case query.Backup != nil:
+		return db.doBackup(query.Backup)
+	case query.Set != nil:
+		return sess.doSet(query.Set)
+	case query.ShowVariables != nil:
+		return sess.doShowVariables()
+	case query.ShowProcessList != nil:
+		return db.doShowProcessList()
+	case query.Kill != nil:
+		return db.doKill(query.Kill)
+	case query.Declare != nil:
+		return db.doDeclare(sess, query.Declare)
+	case query.Fetch != nil:
+		return db.doFetch(sess, query.Fetch)
+	case query.Close != nil:
+		return db.doCloseCursor(sess, query.Close)
+	case query.Call != nil:
+		return db.doCall(ctx, sess, query.Call)
 	}
 
-	filter := func(row Row) bool {
-		return true
+	catalog, err := db.catalog(sess.currentDatabase())
+	if err != nil {
+		return nil, err
 	}
 
-	if q.Where != nil {
-		filterTree := q.Where.ToBinOp()
-		t, err := exprType(filterTree, &table.schema)
+	if sess.user() != "" {
+		err = catalog.checkAccess(sess.user(), query)
 		if err != nil {
 			return nil, err
 		}
-
-		if t != TypeBool {
-			return nil, errors.New("where clause expression should eval to bool")
-		}
-
-		fieldToIdx := make(map[string]int)
-		fields := table.schema.ColumnNames()
-		for i, name := range fields {
-			fieldToIdx[name] = i
-		}
-
-		filter = func(row Row) bool {
-			return evalExpr(filterTree, fieldToIdx, row).Int != 0
-		}
 	}
 
-	project := func(row Row) Row {
-		return row
+	result, err := catalog.Execute(sess.memoryContext(ctx, db.memory), query)
+	if err != nil || result == nil || result.Rows == nil {
+		return result, err
 	}
 
-	schema := table.schema
-	if !q.Projection.All {
-		newSchema, indexes, err := table.schema.Project(q.Projection.Fields)
-		if err != nil {
-			return nil, err
-		}
+	if sess != nil && sess.RowLimit != 0 {
+		result.Rows = LimitRows(ctx, result.Rows, sess.RowLimit)
+	}
+	return result, nil
+}
 
-		project = func(row Row) Row {
-			return row.Project(indexes)
-		}
+// Whether only sessions without a user can execute the query, see Session.User
+func isAdminQuery(query *Query) bool {
+	// copy reads and writes any file the server can access, so privileges on
+	// the table aren't enough, only copy from stdin is allowed to users
+	return query.CreateDatabase != nil || query.DropDatabase != nil || query.Backup != nil ||
+		query.ShowProcessList != nil || query.Kill != nil || query.CopyTo != nil ||
+		(query.CopyFrom != nil && !query.CopyFrom.Stdin)
+}
 
-		schema = newSchema
+// Whether the query doesn't modify the database, only such queries
+// can be executed in read-only databases or retried by clients
+func IsReadOnlyQuery(query *Query) bool {
+	switch {
+	case query.Select != nil, query.Explain != nil, query.CopyTo != nil,
+		query.ShowTables != nil, query.ShowTableStatus != nil, query.ShowVariables != nil,
+		query.ShowViews != nil, query.ShowIndexes != nil, query.Describe != nil, query.Use != nil, query.Set != nil, query.Backup != nil,
+		query.ShowProcessList != nil, query.Kill != nil, query.ShowGrants != nil,
+		query.Declare != nil, query.Fetch != nil, query.Close != nil, query.ShowTriggers != nil, query.ShowProcedures != nil:
+		return true
+	default:
+		return false
 	}
+}
 
-	result := Result{
-		Rows:   FullScan(ctx, table, filter, project),
-		Schema: schema,
+func (catalog *Catalog) Execute(ctx context.Context, query *Query) (*Result, error) {
+	if catalog.readOnly && !IsReadOnlyQuery(query) {
+		return nil, ErrReadOnly
 	}
 
-	return &result, nil
-}
-
-func (db *Database) Execute(ctx context.Context, query *Query) (*Result, error) {
 	switch {
 	case query.Create != nil:
-		return db.doCreate(query.Create)
+		return catalog.doCreate(query.Create)
 	case query.Drop != nil:
-		return db.doDrop(query.Drop)
+		return catalog.doDrop(query.Drop)
+	case query.Truncate != nil:
+		return catalog.doTruncate(query.Truncate)
+	case query.Vacuum != nil:
+		return catalog.doVacuum(query.Vacuum)
+	case query.Alter != nil:
+		return catalog.doAlter(ctx, query.Alter)
 	case query.Insert != nil:
-		return db.doInsert(query.Insert)
+		return catalog.doInsert(query.Insert)
 	case query.Select != nil:
-		return db.doSelect(ctx, query.Select)
+		return catalog.doSelect(ctx, query.Select)
+	case query.Analyze != nil:
+		return catalog.doAnalyze(query.Analyze)
+	case query.Explain != nil:
+		return catalog.doExplain(query.Explain)
+	case query.CopyFrom != nil:
+		return catalog.doCopyFrom(ctx, query.CopyFrom)
+	case query.CopyTo != nil:
+		return catalog.doCopyTo(ctx, query.CopyTo)
+	case query.CreateView != nil:
+		return catalog.doCreateView(query.CreateView)
+	case query.DropView != nil:
+		return catalog.doDropView(query.DropView)
+	case query.CreateIndex != nil:
+		return catalog.doCreateIndex(query.CreateIndex)
+	case query.DropIndex != nil:
+		return catalog.doDropIndex(query.DropIndex)
+	case query.ShowTables != nil:
+		return catalog.doShowTables()
+	case query.ShowTableStatus != nil:
+		return catalog.doShowTableStatus()
+	case query.ShowViews != nil:
+		return catalog.doShowViews()
+	case query.CreateTrigger != nil:
+		return catalog.doCreateTrigger(query.CreateTrigger)
+	case query.DropTrigger != nil:
+		return catalog.doDropTrigger(query.DropTrigger)
+	case query.ShowTriggers != nil:
+		return catalog.doShowTriggers()
+	case query.CreateProcedure != nil:
+		return catalog.doCreateProcedure(query.CreateProcedure)
+	case query.DropProcedure != nil:
+		return catalog.doDropProcedure(query.DropProcedure)
+	case query.ShowProcedures != nil:
+		return catalog.doShowProcedures()
+	case query.ShowIndexes != nil:
+		return catalog.doShowIndexes()
+	case query.Describe != nil:
+		return catalog.doDescribe(query.Describe)
+	case query.Grant != nil:
+		return catalog.doGrant(query.Grant)
+	case query.Revoke != nil:
+		return catalog.doRevoke(query.Revoke)
+	case query.ShowGrants != nil:
+		return catalog.doShowGrants()
 	default:
 		return nil, ErrUnhandledQuery
 	}
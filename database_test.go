@@ -0,0 +1,1006 @@
+package dumbdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAutoIncrement(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mustExec(t, db, nil, "create table users (id serial, name varchar(16))")
+
+	result := mustExec(t, db, nil, `insert into users (name) values ("foo"), ("bar")`)
+	if result == nil || result.LastInsertID != 2 {
+		t.Fatalf("Expected last insert id 2, got %+v", result)
+	}
+
+	// explicit values advance the counter
+	mustExec(t, db, nil, `insert into users values (10, "baz")`)
+	db.Close()
+
+	db, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	result = mustExec(t, db, nil, `insert into users (name) values ("qux")`)
+	if result == nil || result.LastInsertID != 11 {
+		t.Fatalf("Expected last insert id 11 after reopening, got %+v", result)
+	}
+
+	_, err = execQuery(t, db, nil, `insert into users (id) values (12)`)
+	if err == nil {
+		t.Fatal("Expected insert without value for non auto-increment column to fail")
+	}
+}
+
+func TestMemoryEngine(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	countRows := func() int {
+		table, err := db.Table("staging")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := 0
+		err = table.Scan(func(row Row) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	mustExec(t, db, nil, "create table staging (id int, name varchar(16)) engine = memory")
+	mustExec(t, db, nil, `insert into staging values (1, "foo"), (2, "bar")`)
+
+	if n := countRows(); n != 2 {
+		t.Fatalf("Expected 2 rows, got %v", n)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "staging.bin"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected no file for memory table, got %v", err)
+	}
+
+	db.Close()
+	db, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// table survives restart, rows don't
+	if n := countRows(); n != 0 {
+		t.Fatalf("Expected empty table after reopening, got %v rows", n)
+	}
+
+	mustExec(t, db, nil, "drop table staging")
+
+	_, err = execQuery(t, db, nil, "create table bad (id int) compression = flate engine = memory")
+	if err != ErrMemoryEngineCompression {
+		t.Fatalf("Expected ErrMemoryEngineCompression, got %v", err)
+	}
+}
+
+func TestDDLRecovery(t *testing.T) {
+	dir := t.TempDir()
+	catalog, err := OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = catalog.CreateTable("users", testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// crash after the file of a new table is created, but before metadata is saved
+	err = catalog.beginDDL(ddlOp{Op: ddlCreateTable, Table: "orphan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := NewTable(filepath.Join(dir, "orphan"), testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.Close()
+	catalog.Close()
+
+	catalog, err = OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "orphan.bin"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected file of interrupted create to be removed, got %v", err)
+	}
+
+	_, err = catalog.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// crash before metadata of a dropped table is saved
+	err = catalog.beginDDL(ddlOp{Op: ddlDropTable, Table: "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog.Close()
+
+	catalog, err = OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+
+	_, err = catalog.Table("users")
+	if err != ErrNoSuchTable {
+		t.Fatalf("Expected interrupted drop to be finished, got %v", err)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "users.bin"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected file of dropped table to be removed, got %v", err)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, JournalFilename))
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected journal to be removed, got %v", err)
+	}
+}
+
+func TestDropDuringScan(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	table, err := db.CreateTable("users", testTableSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := make([]Row, 0, 1000)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("user %v", i))})
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the scan is blocked on sending rows nobody reads
+	result := mustExec(t, db, nil, "select * from users")
+	if !result.Rows.Next() {
+		t.Fatal("Expected rows")
+	}
+
+	mustExec(t, db, nil, "drop table users")
+
+	for result.Rows.Next() {
+	}
+	if result.Rows.Err() != ErrTableDropped {
+		t.Fatalf("Expected ErrTableDropped, got %v", result.Rows.Err())
+	}
+
+	dropped, err := table.Query("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dropped.All()
+	if err != ErrTableDropped {
+		t.Fatalf("Expected ErrTableDropped for scan of dropped table, got %v", err)
+	}
+
+	// tables of the dropped database are closed only once their scans stop
+	sess := &Session{}
+	for _, q := range []string{"create database shop", "use shop", "create table users (id int, name varchar(16))"} {
+		mustExec(t, db, sess, q)
+	}
+
+	catalog, err := db.catalog("shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err = catalog.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result = mustExec(t, db, sess, "select * from users")
+	if !result.Rows.Next() {
+		t.Fatal("Expected rows")
+	}
+
+	mustExec(t, db, nil, "drop database shop")
+
+	for result.Rows.Next() {
+	}
+	if result.Rows.Err() != ErrTableDropped {
+		t.Fatalf("Expected ErrTableDropped, got %v", result.Rows.Err())
+	}
+}
+
+func TestTruncateDuringScan(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	table, err := db.CreateTable("users", testTableSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := make([]Row, 0, 1000)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("user %v", i))})
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the scan is blocked on sending rows nobody reads
+	result := mustExec(t, db, nil, "select * from users")
+	if !result.Rows.Next() {
+		t.Fatal("Expected rows")
+	}
+
+	mustExec(t, db, nil, "truncate table users")
+
+	for result.Rows.Next() {
+	}
+	if !errors.Is(result.Rows.Err(), ErrScanInterrupted) {
+		t.Fatalf("Expected ErrScanInterrupted, got %v", result.Rows.Err())
+	}
+
+	if rows := mustQuery(t, db, nil, "select * from users"); len(rows) != 0 {
+		t.Fatalf("Expected no rows after truncate, got %v", len(rows))
+	}
+}
+
+func TestRowCount(t *testing.T) {
+	dir := t.TempDir()
+	catalog, err := OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := catalog.CreateTable("users", testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := make([]Row, 0, 1000)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("user %v", i))})
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if table.RowCount() != 1000 {
+		t.Fatalf("Expected 1000 rows, got %v", table.RowCount())
+	}
+
+	err = catalog.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catalog, err = OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err = catalog.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if table.RowCount() != 1000 {
+		t.Fatalf("Expected 1000 rows after reopening, got %v", table.RowCount())
+	}
+
+	err = table.Truncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = table.Insert(rows[:10])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := catalog.doShowTableStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := result.Rows.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(status) != 1 || status[0][2].Int != 10 {
+		t.Fatalf("Unexpected table status %v", status)
+	}
+
+	// crash, counter in the metadata is discarded once the catalog is opened
+	table.Close()
+	catalog, err = OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+
+	table, err = catalog.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if table.RowCount() != 10 {
+		t.Fatalf("Expected 10 rows counted after crash, got %v", table.RowCount())
+	}
+}
+
+func TestSessionVariables(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sess := &Session{}
+	for _, q := range []string{
+		"create table users (id int)",
+		"insert into users values (1), (2), (3)",
+		"set row_limit = 2",
+		"set statement_timeout = 1500",
+		"set work_memory = 4096",
+	} {
+		mustExec(t, db, sess, q)
+	}
+
+	if sess.RowLimit != 2 || sess.StatementTimeout != 1500*time.Millisecond {
+		t.Fatalf("Unexpected session %+v", sess)
+	}
+
+	rows := mustQuery(t, db, sess, "select * from users")
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %v", rows)
+	}
+
+	rows = mustQuery(t, db, sess, "show variables")
+
+	values := make(map[string]string)
+	for _, row := range rows {
+		values[row[0].Str] = row[1].Str
+	}
+	if values["row_limit"] != "2" || values["statement_timeout"] != "1500" || values["work_memory"] != "4096" {
+		t.Fatalf("Unexpected variables %v", values)
+	}
+
+	_, err = execQuery(t, db, sess, "set foo = 1")
+	if !errors.Is(err, ErrUnknownVariable) {
+		t.Fatalf("Expected ErrUnknownVariable, got %v", err)
+	}
+
+	_, err = execQuery(t, db, sess, "set row_limit = \"all\"")
+	if err == nil {
+		t.Fatal("Expected non-integer row_limit to be rejected")
+	}
+
+	// users can only lower the limits set by the server, admins can set any
+	limits := SessionLimits{StatementTimeout: time.Second, MemoryLimit: 1 << 20}
+	alice := &Session{User: "alice", Limits: limits}
+	for _, test := range []struct {
+		query string
+		err   error
+	}{
+		{"set statement_timeout = 500", nil},
+		{"set statement_timeout = 1000", nil},
+		{"set statement_timeout = 1001", ErrAccessDenied},
+		{"set statement_timeout = 0", ErrAccessDenied},
+		{"set query_memory_limit = 1024", nil},
+		{"set query_memory_limit = 2097152", ErrAccessDenied},
+		{"set query_memory_limit = 0", ErrAccessDenied},
+		{"set work_memory = 4096", nil},
+		{"set work_memory = 0", nil},
+		{"set work_memory = 33554432", ErrAccessDenied},
+	} {
+		_, err := execQuery(t, db, alice, test.query)
+		if !errors.Is(err, test.err) {
+			t.Fatalf("Expected %v from %v, got %v", test.err, test.query, err)
+		}
+	}
+	if alice.StatementTimeout != time.Second || alice.MemoryLimit != 1024 || alice.WorkMemory != 0 {
+		t.Fatalf("Unexpected session %+v", alice)
+	}
+
+	admin := &Session{Limits: limits}
+	mustExec(t, db, admin, "set statement_timeout = 0")
+	mustExec(t, db, admin, "set query_memory_limit = 2097152")
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenDatabase(Options{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := db.CreateTable("users", MakeSchema(IntField("id"), VarcharField("name", 16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = users.Insert([]Row{{IntValue(1), VarcharValue("foo")}, {IntValue(2), VarcharValue("bar")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.CreateTableWithOptions("events", MakeSchema(IntField("id")), TableOptions{Compression: CompressionFlate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// contents of all the files in the data directory
+	snapshot := func() map[string]string {
+		files := make(map[string]string)
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+
+			data, err := ioutil.ReadFile(path)
+			files[path] = string(data)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+	before := snapshot()
+
+	for _, storage := range []string{StorageFile, StorageMmap} {
+		db, err = OpenDatabase(Options{DataDir: dir, Storage: storage, ReadOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rows := mustQuery(t, db, nil, "select * from users where id = 2")
+		if len(rows) != 1 {
+			t.Fatalf("Expected 1 row, got %v", rows)
+		}
+
+		for _, q := range []string{
+			"insert into users values (3, \"baz\")",
+			"create table foo (id int)",
+			"drop table users",
+			"truncate table events",
+			"analyze users",
+			"create database foo",
+		} {
+			_, err = execQuery(t, db, nil, q)
+			if !errors.Is(err, ErrReadOnly) {
+				t.Fatalf("Expected ErrReadOnly from %v, got %v", q, err)
+			}
+		}
+
+		users, err := db.Table("users")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = users.Insert([]Row{{IntValue(3), VarcharValue("baz")}})
+		if !errors.Is(err, ErrReadOnly) {
+			t.Fatalf("Expected ErrReadOnly, got %v", err)
+		}
+
+		err = db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !reflect.DeepEqual(before, snapshot()) {
+		t.Fatal("Read-only database modified the data directory")
+	}
+}
+
+func TestDataDirLock(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewDatabase(dir)
+	if !errors.Is(err, ErrDatabaseInUse) {
+		t.Fatalf("Expected ErrDatabaseInUse, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), fmt.Sprint(os.Getpid())) {
+		t.Fatalf("Expected pid of the owner in %q", err)
+	}
+
+	// read-only database can be opened while the directory is locked
+	readOnly, err := OpenDatabase(Options{DataDir: dir, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly.Close()
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
+
+func TestFloat(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, q := range []string{
+		"create table prices (id int, price float)",
+		// integers are converted to float
+		"insert into prices values (1, 1.5), (2, 0.25), (3, 10), (4, 1.5)",
+	} {
+		mustExec(t, db, nil, q)
+	}
+
+	_, err = execQuery(t, db, nil, "insert into prices values (2.5, 1)")
+	if err == nil {
+		t.Fatal("Expected float to be rejected by int column")
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"select id from prices where price > 1", []string{"1", "3", "4"}},
+		{"select id from prices where price = 10", []string{"3"}},
+		{"select id from prices where price < 0.5 and price > -0.5", []string{"2"}},
+		{"select id from prices where id < 1.5", []string{"1"}},
+		{"select id from prices where price * 2 + id = 4", []string{"1"}},
+		{"select id from prices where -price < -2", []string{"3"}},
+		{"select distinct price from prices where id != 2", []string{"1.5", "10"}},
+	}
+
+	for _, test := range tests {
+		rows := mustQuery(t, db, nil, test.query)
+		values := make([]string, 0, len(rows))
+		for _, row := range rows {
+			values = append(values, row[0].String())
+		}
+
+		if !reflect.DeepEqual(values, test.expected) {
+			t.Fatalf("%v: expected %v, got %v", test.query, test.expected, values)
+		}
+	}
+
+	rows := mustQuery(t, db, nil, "select price from prices where id = 1")
+	if rows[0][0].TypeID != TypeFloat {
+		t.Fatalf("Expected float, got %v", rows[0][0].TypeID)
+	}
+
+	var price float64
+	err = ScanRow(rows[0], &price)
+	if err != nil || price != 1.5 {
+		t.Fatalf("Expected 1.5, got %v (%v)", price, err)
+	}
+}
+
+func TestViews(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+	}()
+
+	query := func(q string) []string {
+		return columnStrings(mustQuery(t, db, nil, q), 0)
+	}
+
+	for _, q := range []string{
+		"create table users (id int, name varchar(20), age int)",
+		"insert into users values (1, \"foo\", 20), (2, \"bar\", 30), (3, \"baz\", 40)",
+		"create view adults as select id, name as login, age from users where age > 25",
+		"create view old as select login from adults a where a.age > 35",
+	} {
+		mustExec(t, db, nil, q)
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"select login from adults", []string{"bar", "baz"}},
+		{"select id from adults where login != \"bar\"", []string{"3"}},
+		{"select * from old", []string{"baz"}},
+		{"describe adults", []string{"id", "login", "age"}},
+		{"show views", []string{"adults", "old"}},
+		{"show tables", []string{"users"}},
+	}
+
+	check := func() {
+		for _, test := range tests {
+			values := query(test.query)
+			if !reflect.DeepEqual(values, test.expected) {
+				t.Fatalf("%v: expected %v, got %v", test.query, test.expected, values)
+			}
+		}
+	}
+	check()
+
+	// views see rows inserted after they are created
+	mustExec(t, db, nil, "insert into users values (4, \"qux\", 50)")
+	tests[0].expected = []string{"bar", "baz", "qux"}
+	tests[1].expected = []string{"3", "4"}
+	tests[2].expected = []string{"baz", "qux"}
+	check()
+
+	plan := query("explain select login from old")
+	if plan[0] != "scan of view old" || plan[2] != "  scan of view adults" || plan[5] != "    full scan of users" {
+		t.Fatalf("Unexpected plan %q", plan)
+	}
+
+	for _, q := range []string{
+		"insert into adults values (5, \"x\", 60)",
+		"truncate table adults",
+		"drop table adults",
+		"select name from adults",
+		"select login from adults where name = \"foo\"",
+		"create table old (id int)",
+		"create view users as select * from adults",
+		"create view adults as select * from users",
+		"create view broken as select * from nothing",
+		"drop view users",
+	} {
+		_, err := execQuery(t, db, nil, q)
+		if err == nil {
+			t.Fatalf("Expected %v to fail", q)
+		}
+	}
+
+	// definitions are persisted
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	// view of the dropped table fails until the table is re-created
+	mustExec(t, db, nil, "drop table users")
+
+	_, err = execQuery(t, db, nil, "select * from old")
+	if !errors.Is(err, ErrNoSuchTable) {
+		t.Fatalf("Expected select from view of dropped table to fail, got %v", err)
+	}
+
+	mustExec(t, db, nil, "create table users (id int, name varchar(20), age int)")
+
+	values := query("select * from old")
+	if len(values) != 0 {
+		t.Fatalf("Expected no rows, got %v", values)
+	}
+
+	// views referencing themselves are rejected
+	mustExec(t, db, nil, "drop table users")
+
+	_, err = execQuery(t, db, nil, "create view users as select * from old")
+	if err == nil || !strings.Contains(err.Error(), "references itself") {
+		t.Fatalf("Expected view referencing itself to be rejected, got %v", err)
+	}
+
+	mustExec(t, db, nil, "drop view old")
+
+	values = query("show views")
+	if !reflect.DeepEqual(values, []string{"adults"}) {
+		t.Fatalf("Expected only adults view to be left, got %v", values)
+	}
+}
+
+func TestScanProgress(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	table, err := db.CreateTable("events", MakeSchema(IntField("id"), VarcharField("payload", 200)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := make([]Row, 0, 100)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(strings.Repeat("x", 200))})
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := mustExec(t, db, nil, "select id from events where id < 0")
+	_, err = result.Rows.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress := result.Progress.Get()
+	if progress.PagesTotal < 2 || progress.PagesScanned != progress.PagesTotal {
+		t.Fatalf("Expected all the pages to be scanned, got %+v", progress)
+	}
+}
+
+func TestCopyIn(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mustExec(t, db, nil, "create table users (id serial, name varchar(8))")
+
+	copyIn := mustExec(t, db, nil, "copy users from stdin").CopyIn
+	if copyIn == nil {
+		t.Fatal("Expected copy from stdin to return the destination of the rows")
+	}
+
+	for i := 0; i < 3; i++ {
+		batch := make([]Row, 0, CopyBatchSize)
+		for j := 0; j < CopyBatchSize; j++ {
+			id := i*CopyBatchSize + j + 1
+			batch = append(batch, Row{IntValue(int32(id)), {TypeID: TypeVarchar, Str: fmt.Sprintf("user%v", id)}})
+		}
+
+		err = copyIn.Insert(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = copyIn.Insert([]Row{{IntValue(0), {TypeID: TypeVarchar, Str: "too long name"}}})
+	if !errors.Is(err, ErrConstraintViolation) || copyIn.Inserted != 3*CopyBatchSize {
+		t.Fatalf("Expected ErrConstraintViolation after %v rows, got %v (%v)", 3*CopyBatchSize, copyIn.Inserted, err)
+	}
+
+	// rows of the batch which fail don't stop the others
+	statuses, err := copyIn.InsertBatch([]Row{
+		{IntValue(3*CopyBatchSize + 1), {TypeID: TypeVarchar, Str: "foo"}},
+		{IntValue(0), {TypeID: TypeVarchar, Str: "too long name"}},
+		{IntValue(3*CopyBatchSize + 2), {TypeID: TypeVarchar, Str: "bar"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !statuses[0].Inserted || !errors.Is(statuses[1].Err, ErrConstraintViolation) || !statuses[2].Inserted ||
+		copyIn.Inserted != 3*CopyBatchSize+2 {
+		t.Fatalf("Expected the second row to fail, got %v with %v rows", statuses, copyIn.Inserted)
+	}
+
+	// copied values advance the counter
+	result := mustExec(t, db, nil, `insert into users (name) values ("foo")`)
+	if result.LastInsertID != 3*CopyBatchSize+3 {
+		t.Fatalf("Expected last insert id %v, got %v", 3*CopyBatchSize+3, result.LastInsertID)
+	}
+
+	mustExec(t, db, nil, "drop table users")
+	mustExec(t, db, nil, "create table users (id serial, name varchar(8))")
+
+	err = copyIn.Insert([]Row{{IntValue(1), {TypeID: TypeVarchar, Str: "foo"}}})
+	if !errors.Is(err, ErrTableDropped) {
+		t.Fatalf("Expected ErrTableDropped, got %v", err)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sess := &Session{}
+	values := ""
+	for i := 0; i < 2500; i++ {
+		if i != 0 {
+			values += ", "
+		}
+		values += fmt.Sprint("(", i, ")")
+	}
+
+	for _, q := range []string{"create table users (id int)", "insert into users values " + values} {
+		mustExec(t, db, sess, q)
+	}
+
+	// the cursor outlives the statement declaring it
+	query, err := ParseQuery("declare c cursor for select id from users where id >= 100")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = db.Execute(ctx, sess, query)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetched := 0
+	for _, expected := range []int{1, 1000, 1000, 399, 0} {
+		q := fmt.Sprintf("fetch %v from c", expected)
+		if expected == 1 {
+			q = "fetch from c"
+		}
+
+		rows, err := queryRows(t, db, sess, q)
+		if expected == 0 {
+			rows, err = queryRows(t, db, sess, "fetch 10 from c")
+		}
+		if err != nil || len(rows) != expected {
+			t.Fatalf("Expected %v rows, got %v (%v)", expected, len(rows), err)
+		}
+		if expected != 0 && rows[0][0].Int != int64(100+fetched) {
+			t.Fatalf("Expected rows from %v, got %v", 100+fetched, rows[0])
+		}
+		fetched += len(rows)
+	}
+
+	for _, q := range []string{"close c", "declare c cursor for select id from users", "declare d cursor for select id from users"} {
+		mustExec(t, db, sess, q)
+	}
+
+	_, err = execQuery(t, db, sess, "declare c cursor for select id from users")
+	if err == nil {
+		t.Fatal("Expected declare of the open cursor to fail")
+	}
+
+	// partially read cursors are closed with the session
+	mustQuery(t, db, sess, "fetch 10 from d")
+	sess.CloseCursors()
+
+	for _, q := range []string{"fetch from c", "close d"} {
+		_, err = queryRows(t, db, sess, q)
+		if !errors.Is(err, ErrNoSuchCursor) {
+			t.Fatalf("Expected ErrNoSuchCursor for %v, got %v", q, err)
+		}
+	}
+
+	// pages aren't locked while the cursor waits to be fetched
+	mustExec(t, db, sess, "declare c cursor for select id from users")
+	defer sess.CloseCursors()
+	mustQuery(t, db, sess, "fetch from c")
+
+	table, err := db.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan error, 1)
+	go func() {
+		_, release, err := table.lockRows(table.firstPage(), true, nil)
+		if err == nil {
+			release()
+		}
+		locked <- err
+	}()
+
+	select {
+	case err = <-locked:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the first page not to be locked by the cursor")
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "data")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mustExec(t, db, nil, "create table users (id int)")
+	for _, q := range []string{
+		"create table `../escaped` (id int)",
+		"create table `sub/users` (id int)",
+		"create table `..` (id int)",
+		"create table `.` (id int)",
+		"create table `users.alter` (id int)",
+		"create table `a\\b` (id int)",
+		"create table `a\x00b` (id int)",
+		"create database `../escdb`",
+		"create database `..`",
+		"create index `../users_id` on users (id)",
+		"create view `../v` as select * from users",
+		"create procedure `../p`() as begin select * from users end",
+		"create trigger `../t` before insert on users for each row set id = 1",
+	} {
+		_, err = execQuery(t, db, nil, q)
+		if !errors.Is(err, ErrInvalidName) {
+			t.Fatalf("Expected ErrInvalidName for %q, got %v", q, err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(root)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected only the data directory, got %v (%v)", files, err)
+	}
+
+	_, err = db.CreateTable("../escaped", testTableSchema())
+	if !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("Expected ErrInvalidName, got %v", err)
+	}
+}
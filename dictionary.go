@@ -0,0 +1,333 @@
+package dumbdb
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+const DictionaryFileExtension = ".dict"
+
+// Dictionary of the values of varchar columns of a table, which are stored on
+// pages as 4-byte ids and decoded when rows are read. Ids are assigned in the
+// order the values are added and never reused.
+//
+// Values are appended to the dictionary file, as a length (1) followed by the
+// value, and synced before the rows which reference them are written, so a crash
+// can leave only values which aren't referenced yet.
+// Memory tables keep the dictionary in memory only
+type Dictionary struct {
+	columns []bool // dictionary columns by index in the schema
+	path    string // empty for memory tables
+
+	m      sync.RWMutex
+	file   *os.File
+	values []string
+	ids    map[string]uint32
+}
+
+// Open the dictionary of the columns, which have to be varchar
+func openDictionary(path string, schema *Schema, columns []string, opts TableOptions, isNew bool) (*Dictionary, error) {
+	if len(columns) == 0 {
+		return nil, nil
+	}
+
+	dict := &Dictionary{
+		columns: make([]bool, len(schema.Fields)),
+		ids:     make(map[string]uint32),
+	}
+	for _, name := range columns {
+		idx, field := schema.GetField(name)
+		if idx == -1 {
+			return nil, fmt.Errorf("no column named %v for the dictionary", name)
+		}
+
+		if field.TypeID != TypeVarchar {
+			return nil, fmt.Errorf("dictionary column %v has to be varchar", name)
+		}
+		dict.columns[idx] = true
+	}
+
+	if opts.Engine == EngineMemory {
+		return dict, nil
+	}
+
+	flags := os.O_RDWR | os.O_CREATE
+	if isNew {
+		flags |= os.O_TRUNC
+	}
+	if opts.ReadOnly {
+		flags = os.O_RDONLY
+	}
+
+	var err error
+	dict.path = path + DictionaryFileExtension
+	dict.file, err = os.OpenFile(dict.path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dict.load(opts.ReadOnly)
+	if err != nil {
+		dict.file.Close()
+		return nil, err
+	}
+	return dict, nil
+}
+
+// Read the values, a value torn by a crash is dropped
+func (dict *Dictionary) load(readOnly bool) error {
+	data, err := ioutil.ReadAll(dict.file)
+	if err != nil {
+		return err
+	}
+
+	size := 0
+	for size < len(data) {
+		n := int(data[size])
+		if size+1+n > len(data) {
+			break
+		}
+
+		dict.add(string(data[size+1 : size+1+n]))
+		size += 1 + n
+	}
+
+	if size == len(data) || readOnly {
+		return nil
+	}
+
+	err = dict.file.Truncate(int64(size))
+	if err != nil {
+		return err
+	}
+
+	_, err = dict.file.Seek(int64(size), io.SeekStart)
+	return err
+}
+
+func (dict *Dictionary) add(value string) uint32 {
+	id := uint32(len(dict.values))
+	dict.values = append(dict.values, value)
+	dict.ids[value] = id
+	return id
+}
+
+// Replace values of the dictionary columns with their ids, values which aren't in
+// the dictionary yet are added. Rows have to be typechecked
+func (dict *Dictionary) encode(rows []Row) ([]Row, error) {
+	if dict == nil {
+		return rows, nil
+	}
+
+	dict.m.Lock()
+	defer dict.m.Unlock()
+
+	var added []byte
+	encoded := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		row = append(Row(nil), row...)
+		for i := range row {
+			if !dict.columns[i] {
+				continue
+			}
+
+			value := row[i].StrVal()
+			id, ok := dict.ids[value]
+			if !ok {
+				id = dict.add(value)
+				added = append(added, byte(len(value)))
+				added = append(added, value...)
+			}
+			row[i] = IntValue(int32(id))
+		}
+		encoded = append(encoded, row)
+	}
+
+	if len(added) == 0 || dict.file == nil {
+		return encoded, nil
+	}
+
+	_, err := dict.file.Write(added)
+	if err == nil {
+		err = dict.file.Sync()
+	}
+
+	if err != nil {
+		// values may be written partially, they are dropped on the next load
+		dict.reload()
+		return nil, err
+	}
+	return encoded, nil
+}
+
+// Forget values which aren't in the file, after a failed write
+func (dict *Dictionary) reload() {
+	dict.values = nil
+	dict.ids = make(map[string]uint32)
+
+	_, err := dict.file.Seek(0, io.SeekStart)
+	if err == nil {
+		dict.load(false)
+	}
+}
+
+// Replace ids of the dictionary columns read from the page with their values,
+// columns which weren't read get empty values, see Table.scanPage()
+func (dict *Dictionary) decode(row Row, columns []bool) (Row, error) {
+	if dict == nil {
+		return row, nil
+	}
+
+	dict.m.RLock()
+	defer dict.m.RUnlock()
+
+	for i := range row {
+		if !dict.columns[i] {
+			continue
+		}
+
+		if columns != nil && !columns[i] {
+			row[i] = VarcharValue("")
+			continue
+		}
+
+		id := uint32(row[i].Int)
+		if int(id) >= len(dict.values) {
+			return nil, fmt.Errorf("%w: column %v references unknown dictionary id %v", ErrCorruptedPage, i, id)
+		}
+		row[i] = VarcharValue(dict.values[id])
+	}
+	return row, nil
+}
+
+// Id of the value, false if it's not in the dictionary
+func (dict *Dictionary) lookup(val *Value) (Value, bool) {
+	dict.m.RLock()
+	defer dict.m.RUnlock()
+
+	id, ok := dict.ids[val.StrVal()]
+	return IntValue(int32(id)), ok
+}
+
+// Predicates checked against the stored rows: predicates of the dictionary columns
+// compare ids, the ones which can't be checked this way (e.g. ranges) are dropped,
+// as the rows are checked by the row filter anyway. Offsets are the ones in layout
+func (dict *Dictionary) storedPredicates(predicates []ColumnPredicate, schema *Schema, layout *Schema) []ColumnPredicate {
+	if dict == nil {
+		return predicates
+	}
+
+	stored := make([]ColumnPredicate, 0, len(predicates))
+	for _, p := range predicates {
+		idx, _ := schema.GetField(p.Field.Name)
+		if idx == -1 {
+			continue
+		}
+
+		p.Offset = layout.Offset(idx)
+		if !dict.columns[idx] {
+			stored = append(stored, p)
+			continue
+		}
+
+		p.Field = layout.Fields[idx]
+		switch p.Op {
+		case OpEq, OpIn:
+			values := p.Values
+			if p.Op == OpEq {
+				values = []Value{p.Value}
+			}
+
+			// values which aren't in the dictionary match no rows
+			ids := []Value{}
+			for i := range values {
+				id, ok := dict.lookup(&values[i])
+				if ok {
+					ids = append(ids, id)
+				}
+			}
+			if !varcharValues(values) {
+				continue
+			}
+			p.Op, p.Value, p.Values = OpIn, Value{}, ids
+		case OpNotEq:
+			id, ok := dict.lookup(&p.Value)
+			if !ok || !varcharValues([]Value{p.Value}) {
+				continue
+			}
+			p.Value = id
+		default:
+			continue
+		}
+		stored = append(stored, p)
+	}
+	return stored
+}
+
+func varcharValues(values []Value) bool {
+	for i := range values {
+		if values[i].TypeID != TypeVarchar {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove all the values, rows referencing them have to be removed first
+func (dict *Dictionary) Truncate() error {
+	if dict == nil {
+		return nil
+	}
+
+	dict.m.Lock()
+	defer dict.m.Unlock()
+
+	dict.values = nil
+	dict.ids = make(map[string]uint32)
+	if dict.file == nil {
+		return nil
+	}
+
+	err := dict.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = dict.file.Seek(0, io.SeekStart)
+	return err
+}
+
+// Contents of the dictionary file, for backups
+func (dict *Dictionary) snapshot() []byte {
+	dict.m.RLock()
+	defer dict.m.RUnlock()
+
+	var data []byte
+	for _, value := range dict.values {
+		data = append(data, byte(len(value)))
+		data = append(data, value...)
+	}
+	return data
+}
+
+func (dict *Dictionary) Close() error {
+	if dict == nil || dict.file == nil {
+		return nil
+	}
+	return dict.file.Close()
+}
+
+// Schema of the rows stored on pages, where the dictionary columns are 4-byte ids
+func (dict *Dictionary) layout(schema *Schema) Schema {
+	var layout Schema
+	for i, field := range schema.Fields {
+		if dict != nil && dict.columns[i] {
+			field = Field{Name: field.Name, TypeID: TypeInt, Len: 4}
+		}
+		layout.addField(field)
+	}
+	return layout
+}
@@ -0,0 +1,196 @@
+package dumbdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDictionaryTable(t *testing.T) {
+	for _, engine := range []string{"", EngineColumnar} {
+		dir := t.TempDir()
+		catalog, err := OpenCatalog(dir, StorageFile, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		query := func(q string) []string {
+			return rowStrings(mustQueryCatalog(t, catalog, q), formatConst, ", ")
+		}
+
+		create := "create table t (id int, country varchar(100), city varchar(100))"
+		if engine != "" {
+			create += " engine = " + engine
+		}
+		query(create + " dictionary = (country, city)")
+
+		values := make([]string, 0, 1000)
+		for i := 0; i < cap(values); i++ {
+			values = append(values, fmt.Sprintf("(%v, \"country %v\", \"city %v\")", i, i%5, i%50))
+		}
+		query("insert into t values " + strings.Join(values, ", "))
+
+		table, err := catalog.Table("t")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// ids take 4 bytes instead of 100
+		if table.layout.RowSize() != 12 {
+			t.Fatalf("%v: expected rows of 12 bytes, got %v", engine, table.layout.RowSize())
+		}
+
+		check := func() {
+			tests := []struct {
+				where string
+				n     int
+			}{
+				{"country = \"country 1\"", 200},
+				{"country in (\"country 1\", \"country 7\", \"city 2\")", 200},
+				{"country != \"country 1\"", 800},
+				{"country != \"unknown\"", 1000},
+				{"country = \"unknown\"", 0},
+				{"country like \"country 1%\"", 200},
+				{"country > \"country 2\"", 400},
+				{"country = \"country 1\" and city = \"city 11\"", 20},
+				{"city = \"city 11\" and id < 500", 10},
+			}
+
+			for _, test := range tests {
+				if rows := query("select id from t where " + test.where); len(rows) != test.n {
+					t.Fatalf("%v: %v: expected %v rows, got %v", engine, test.where, test.n, len(rows))
+				}
+			}
+
+			rows := query("select id, city from t where country = \"country 3\" and id < 20")
+			if !reflect.DeepEqual(rows, []string{"3, \"city 3\"", "8, \"city 8\"", "13, \"city 13\"", "18, \"city 18\""}) {
+				t.Fatalf("%v: unexpected rows %v", engine, rows)
+			}
+
+			row, err := table.FetchRow(NewRowID(0, 7))
+			if err != nil || row[1].StrVal() != "country 2" || row[2].StrVal() != "city 7" {
+				t.Fatalf("%v: unexpected row %v (%v)", engine, row, err)
+			}
+		}
+		check()
+
+		if len(table.dict.values) != 55 {
+			t.Fatalf("%v: expected 55 values in the dictionary, got %v", engine, len(table.dict.values))
+		}
+
+		for _, reopen := range []string{"clean", "crash"} {
+			if reopen == "crash" {
+				for _, table := range catalog.tables {
+					table.Close()
+				}
+			} else {
+				catalog.Close()
+			}
+
+			catalog, err = OpenCatalog(dir, StorageFile, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			table, err = catalog.Table("t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			check()
+		}
+
+		// indexes store the values, not the ids
+		query("create index t_city on t (city)")
+		if rows := query("select id from t where city = \"city 49\""); len(rows) != 20 {
+			t.Fatalf("%v: unexpected rows %v", engine, rows)
+		}
+
+		query("truncate table t")
+		if len(table.dict.values) != 0 {
+			t.Fatalf("%v: expected empty dictionary, got %v values", engine, len(table.dict.values))
+		}
+
+		query("insert into t values (1, \"a\", \"b\")")
+		if rows := query("select * from t"); !reflect.DeepEqual(rows, []string{"1, \"a\", \"b\""}) {
+			t.Fatalf("%v: unexpected rows %v", engine, rows)
+		}
+
+		query("drop table t")
+		_, err = os.Stat(filepath.Join(dir, "t"+DictionaryFileExtension))
+		if !os.IsNotExist(err) {
+			t.Fatalf("%v: expected the dictionary file to be removed, got %v", engine, err)
+		}
+		catalog.Close()
+	}
+}
+
+func TestDictionaryTornValue(t *testing.T) {
+	schema := NewSchema([]FieldDescription{
+		{Name: "id", Type: &Type{Integer: true}},
+		{Name: "name", Type: &Type{Varchar: 20}},
+	})
+
+	path := filepath.Join(t.TempDir(), "t")
+	table, err := NewTable(path, schema, TableOptions{Dictionary: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = table.Insert([]Row{{IntValue(1), VarcharValue("foo")}, {IntValue(2), VarcharValue("bar")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.Close()
+
+	// value written partially by an interrupted insert
+	file, err := os.OpenFile(path+DictionaryFileExtension, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{10, 'b', 'a'})
+	file.Close()
+
+	table, err = OpenTable(path, schema, TableOptions{Dictionary: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	err = table.Insert([]Row{{IntValue(3), VarcharValue("baz")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	err = table.Scan(func(row Row) error {
+		names = append(names, row[1].StrVal())
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(names, []string{"foo", "bar", "baz"}) {
+		t.Fatalf("Unexpected names %v (%v)", names, err)
+	}
+
+	// rows referencing values missing from the dictionary are reported
+	table.dict.values = table.dict.values[:2]
+	_, err = table.FetchRow(NewRowID(0, 2))
+	if err == nil || !strings.Contains(err.Error(), "unknown dictionary id 2") {
+		t.Fatalf("Expected unknown id error, got %v", err)
+	}
+}
+
+func TestDictionaryColumns(t *testing.T) {
+	schema := NewSchema([]FieldDescription{
+		{Name: "id", Type: &Type{Integer: true}},
+		{Name: "name", Type: &Type{Varchar: 20}},
+	})
+
+	for _, columns := range [][]string{{"missing"}, {"id"}} {
+		_, err := NewTable("", schema, TableOptions{Engine: EngineMemory, Dictionary: columns})
+		if err == nil {
+			t.Fatalf("%v: expected an error", columns)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package dumbdb
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Alignment of offsets, sizes and memory buffers required by O_DIRECT, which is
+// the logical block size of the device. Page size covers all the common ones
+const directIOAlignment = int64(PageSize)
+
+// Storage with the file opened with O_DIRECT, so that pages cached by the Pager
+// aren't cached by the OS once again.
+//
+// Aligned IO, e.g. of the pages allocated by the Pager, goes directly to the file,
+// anything else is done through an aligned bounce buffer. Unaligned writes read the
+// surrounding blocks first and can extend the file up to the block boundary
+type DirectStorage struct {
+	// serializes read-modify-write cycles of unaligned writes
+	m    sync.Mutex
+	file *os.File
+}
+
+// Open or create the storage in file at path, flags are the same as for os.OpenFile()
+func OpenDirectStorage(path string, flags int) (*DirectStorage, error) {
+	file, err := os.OpenFile(path, flags|syscall.O_DIRECT, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &DirectStorage{file: file}, nil
+}
+
+func isAligned(buf []byte, off int64) bool {
+	return len(buf) != 0 &&
+		off%directIOAlignment == 0 &&
+		int64(len(buf))%directIOAlignment == 0 &&
+		int64(uintptr(unsafe.Pointer(&buf[0])))%directIOAlignment == 0
+}
+
+// Aligned block range covering [off, off+size)
+func alignRange(off int64, size int) (int64, int) {
+	start := off - off%directIOAlignment
+	end := off + int64(size)
+	if rem := end % directIOAlignment; rem != 0 {
+		end += directIOAlignment - rem
+	}
+	return start, int(end - start)
+}
+
+func (storage *DirectStorage) ReadAt(buf []byte, off int64) (int, error) {
+	if len(buf) == 0 || isAligned(buf, off) {
+		return storage.file.ReadAt(buf, off)
+	}
+
+	start, size := alignRange(off, len(buf))
+	block := alignedBuffer(size)
+	n, err := storage.file.ReadAt(block, start)
+
+	// part of the block before off doesn't count
+	n -= int(off - start)
+	if n < 0 {
+		n = 0
+	}
+	if n > len(buf) {
+		n = len(buf)
+		err = nil
+	}
+
+	copy(buf, block[off-start:int(off-start)+n])
+	if err == nil && n < len(buf) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (storage *DirectStorage) WriteAt(buf []byte, off int64) (int, error) {
+	if len(buf) == 0 || isAligned(buf, off) {
+		return storage.file.WriteAt(buf, off)
+	}
+
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	start, size := alignRange(off, len(buf))
+	block := alignedBuffer(size)
+	if off != start || len(buf) != size {
+		// blocks past the end of the file are left zeroed
+		_, err := storage.file.ReadAt(block, start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+
+	copy(block[off-start:], buf)
+	_, err := storage.file.WriteAt(block, start)
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (storage *DirectStorage) Seek(diff int64, whence int) (int64, error) {
+	return storage.file.Seek(diff, whence)
+}
+
+func (storage *DirectStorage) Truncate(size int64) error {
+	return storage.file.Truncate(size)
+}
+
+func (storage *DirectStorage) Name() string {
+	return storage.file.Name()
+}
+
+func (storage *DirectStorage) Close() error {
+	return storage.file.Close()
+}
@@ -0,0 +1,100 @@
+package dumbdb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pages.bin")
+	storage, err := OpenDirectStorage(path, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	err = storage.Truncate(3 * int64(PageSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// aligned write of a page
+	page := newPage()
+	copy(page.data, bytes.Repeat([]byte("page"), int(PageSize)/4))
+	_, err = storage.WriteAt(page.data, int64(PageSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unaligned write crossing the page boundary
+	_, err = storage.WriteAt([]byte("hello"), 2*int64(PageSize)-2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := make([]byte, 3*PageSize)
+	copy(expected[PageSize:], page.data[:PageSize-2])
+	copy(expected[2*PageSize-2:], "hello")
+
+	// unaligned buffer
+	buf := make([]byte, len(expected)+1)[1:]
+	n, err := storage.ReadAt(buf, 0)
+	if err != nil || n != len(buf) {
+		t.Fatalf("Unexpected read of %v bytes (%v)", n, err)
+	}
+
+	if !bytes.Equal(buf, expected) {
+		t.Fatal("Unexpected content of the storage")
+	}
+
+	// unaligned read past the end of the storage
+	n, err = storage.ReadAt(buf[:10], 3*int64(PageSize)-5)
+	if n != 5 || err == nil {
+		t.Fatalf("Expected short read of 5 bytes, got %v (%v)", n, err)
+	}
+}
+
+func TestDirectDatabase(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenDatabase(Options{DataDir: dir, Storage: StorageDirect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	table, err := db.CreateTable("users", testTableSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := table.storage.(*DirectStorage); !ok {
+		t.Fatalf("Expected direct storage, got %T", table.storage)
+	}
+
+	const nRows = 1000
+	rows := make([]Row, 0, nRows)
+	for i := 0; i < nRows; i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("user %v", i))})
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	err = table.Scan(func(row Row) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != nRows {
+		t.Fatalf("Expected %v rows, got %v", nRows, n)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package dumbdb
+
+import "os"
+
+// Storage with the file opened with O_DIRECT, not available on this platform
+type DirectStorage struct {
+	*os.File
+}
+
+func OpenDirectStorage(path string, flags int) (*DirectStorage, error) {
+	return nil, ErrDirectIONotSupported
+}
@@ -0,0 +1,206 @@
+package dumbdb
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"math"
+)
+
+const (
+	// Number of partitions rows are spilled into by distinct once the memory
+	// is exhausted
+	spillPartitions = 16
+
+	// Partitions are processed recursively, past this depth memory limit is ignored
+	spillMaxLevel = 4
+)
+
+// Partition of the row with the key at the given depth of recursion
+func spillPartition(key string, level int) int {
+	h := fnv.New32a()
+	// different levels have to split rows differently
+	h.Write([]byte{byte(level)})
+	h.Write([]byte(key))
+	return int(h.Sum32() % spillPartitions)
+}
+
+// Encode row into a string, which is the same for equal rows
+func rowKey(row Row) string {
+	var buf []byte
+	var tmp [binary.MaxVarintLen64]byte
+	for i := range row {
+		val := &row[i]
+		buf = append(buf, byte(val.TypeID))
+		switch val.TypeID {
+		case TypeVarchar:
+			s := val.StrVal()
+			n := binary.PutUvarint(tmp[:], uint64(len(s)))
+			buf = append(buf, tmp[:n]...)
+			buf = append(buf, s...)
+		case TypeFloat:
+			f := val.Float
+			if f == 0 {
+				// -0 is equal to 0
+				f = 0
+			}
+			n := binary.PutUvarint(tmp[:], math.Float64bits(f))
+			buf = append(buf, tmp[:n]...)
+		default:
+			n := binary.PutVarint(tmp[:], val.Int)
+			buf = append(buf, tmp[:n]...)
+		}
+	}
+	return string(buf)
+}
+
+// Hash set of rows seen so far. Once its memory limit is reached, rows which
+// were not seen yet are spilled into partitions by hash of the row, so that
+// duplicates end up in the same partition, which is then deduplicated on its own
+type distinctSet struct {
+	memLimit int
+	tempDir  string
+	level    int
+	memory   *MemoryAccount
+
+	seen    map[string]struct{}
+	memSize int
+
+	// nil until memory is exhausted
+	partitions []*Spool
+}
+
+func newDistinctSet(memLimit int, tempDir string, level int, memory *MemoryAccount) *distinctSet {
+	return &distinctSet{
+		memLimit: memLimit,
+		tempDir:  tempDir,
+		level:    level,
+		memory:   memory,
+		seen:     make(map[string]struct{}),
+	}
+}
+
+// Emit the row if it wasn't seen before, or spill it to be checked later
+func (set *distinctSet) Add(row Row, emit func(Row) error) error {
+	key := rowKey(row)
+	if _, ok := set.seen[key]; ok {
+		return nil
+	}
+
+	if set.partitions == nil {
+		// key is stored twice: in the map and in the emitted row
+		size := 2 * len(key)
+		canSpill := len(set.seen) != 0 && set.level < spillMaxLevel
+		if set.memSize+size <= set.memLimit || !canSpill {
+			// rows are spilled once the query runs out of memory too, unless they can't be
+			err := set.memory.Reserve(size)
+			if err == nil {
+				set.seen[key] = struct{}{}
+				set.memSize += size
+				return emit(row)
+			}
+
+			if !canSpill {
+				return err
+			}
+		}
+
+		set.partitions = make([]*Spool, spillPartitions)
+		for i := range set.partitions {
+			set.partitions[i] = NewSpool(0, set.tempDir)
+		}
+	}
+
+	return set.partitions[spillPartition(key, set.level)].Push(row)
+}
+
+// Deduplicate and emit spilled rows, has to be called after all the rows are added
+func (set *distinctSet) Finish(ctx context.Context, emit func(Row) error) error {
+	// memory of the set is not needed anymore
+	set.seen = nil
+	set.memory.Release(set.memSize)
+	set.memSize = 0
+
+	for _, spool := range set.partitions {
+		spool.CloseWrite(nil)
+		sub := newDistinctSet(set.memLimit, set.tempDir, set.level+1, set.memory)
+		err := sub.addSpooled(ctx, spool, emit)
+		if err == nil {
+			err = sub.Finish(ctx, emit)
+		}
+		sub.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (set *distinctSet) addSpooled(ctx context.Context, spool *Spool, emit func(Row) error) error {
+	for {
+		rows, err := spool.Next(DefaultChunkRows)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			err = set.Add(row, emit)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Remove spill files
+func (set *distinctSet) Close() error {
+	set.memory.Release(set.memSize)
+	set.memSize = 0
+
+	var err error
+	for _, spool := range set.partitions {
+		if closeErr := spool.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	set.partitions = nil
+	return err
+}
+
+// Filter out duplicate rows, order of the rows is not preserved if memLimit is exceeded
+// tempDir can be empty, in which case the default directory for temporary files is used
+func DistinctRows(ctx context.Context, rows *Rows, memLimit int, tempDir string) *Rows {
+	distinct := NewRows(ctx, func(ctx context.Context, emit func(Row) error) error {
+		defer rows.Close()
+
+		set := newDistinctSet(memLimit, tempDir, 0, queryMemoryAccount(ctx))
+		defer set.Close()
+
+		for rows.Next() {
+			err := set.Add(rows.Row(), emit)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := rows.Err()
+		if err != nil {
+			return err
+		}
+
+		return set.Finish(ctx, emit)
+	})
+	distinct.schema = rows.schema
+	return distinct
+}
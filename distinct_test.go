@@ -0,0 +1,36 @@
+package dumbdb
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDistinctRows(t *testing.T) {
+	const nRows = 1000
+	const nDistinct = 300
+
+	rows := make([]Row, 0, nRows)
+	for i := 0; i < nRows; i++ {
+		rows = append(rows, Row{{TypeID: TypeInt, Int: int64(i % nDistinct)}, {TypeID: TypeVarchar, Str: "value\x00\x00"}})
+	}
+
+	// second limit is tiny, so that almost everything is deduplicated on disk
+	for _, memLimit := range []int{DefaultWorkMemory, 64} {
+		distinct, err := DistinctRows(context.Background(), StaticRows(rows), memLimit, t.TempDir()).All()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(distinct) != nDistinct {
+			t.Fatalf("memLimit %v: expected %v rows, got %v", memLimit, nDistinct, len(distinct))
+		}
+
+		seen := make(map[int64]bool)
+		for _, row := range distinct {
+			if seen[row[0].Int] || row[1].StrVal() != "value" {
+				t.Fatalf("memLimit %v: unexpected row %v", memLimit, row)
+			}
+			seen[row[0].Int] = true
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package dumbdbtest
+
+import (
+	"bytes"
+	"dumbdb"
+	"fmt"
+)
+
+// Check that the allocation index of the pager agrees with its storage
+func CheckAllocationIndex(pager *dumbdb.Pager) error {
+	return pager.Validate()
+}
+
+// Check the structure of the tree with BTree.Validate(), and its entries through
+// the public interface: keys of the leaves are ordered (strictly for unique trees),
+// and every key is found by Search(). Returns the number of entries
+func CheckBTree(tree *dumbdb.BTree) (int, error) {
+	n, err := tree.Validate()
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []dumbdb.BTreeKey
+
+	cursor := tree.Search(nil)
+	for ok := cursor.Valid(); ok; ok = cursor.Forward() {
+		key, _ := cursor.Get()
+		if len(keys) != 0 {
+			cmp := bytes.Compare(keys[len(keys)-1], key)
+			if cmp > 0 || cmp == 0 && tree.Unique() {
+				cursor.Close()
+				return 0, fmt.Errorf("entry %v: key %v follows %v", len(keys), key, keys[len(keys)-1])
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	err = cursor.Err()
+	cursor.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(keys) != n {
+		return 0, fmt.Errorf("cursor visited %v entries, %v were validated", len(keys), n)
+	}
+
+	for i, key := range keys {
+		if i != 0 && bytes.Equal(keys[i-1], key) {
+			continue
+		}
+
+		cursor := tree.Search(key)
+		var found dumbdb.BTreeKey
+		if cursor.Valid() {
+			found, _ = cursor.Get()
+		}
+		err := cursor.Err()
+		cursor.Close()
+		if err != nil {
+			return 0, err
+		}
+
+		if !bytes.Equal(found, key) {
+			return 0, fmt.Errorf("entry %v: search of key %v found %v", i, key, found)
+		}
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,103 @@
+package dumbdbtest
+
+import (
+	"bytes"
+	"dumbdb"
+	"errors"
+	"testing"
+)
+
+func TestCheckBTree(t *testing.T) {
+	for _, unique := range []bool{true, false} {
+		pager, err := dumbdb.NewPager(16, NewMemoryStorage())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tree, err := dumbdb.NewBTree(pager, 4, unique)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := CheckBTree(tree)
+		if err != nil || n != 0 {
+			t.Fatalf("empty tree: %v entries, %v", n, err)
+		}
+
+		// enough entries to split the root a few times
+		const nEntries = 5000
+		for i := 0; i < nEntries; i++ {
+			key := int32(i * 7919 % nEntries)
+			if !unique {
+				key /= 2
+			}
+
+			err = tree.Insert(dumbdb.IntKey(key), dumbdb.BTreeValue(i))
+			if err != nil && !errors.Is(err, dumbdb.ErrDuplicateKey) {
+				t.Fatal(err)
+			}
+		}
+
+		n, err = CheckBTree(tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != nEntries {
+			t.Fatalf("unique=%v: %v entries, expected %v", unique, n, nEntries)
+		}
+
+		err = CheckAllocationIndex(pager)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFaultStorage(t *testing.T) {
+	disk := NewMemoryStorage()
+	err := disk.Truncate(4 * int64(dumbdb.PageSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := NewFaultStorage(disk, 1)
+	storage.CrashAt = 1
+	storage.TornWrites = true
+
+	page := bytes.Repeat([]byte{1}, int(dumbdb.PageSize))
+	_, err = storage.WriteAt(page, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.WriteAt(bytes.Repeat([]byte{2}, int(dumbdb.PageSize)), int64(dumbdb.PageSize))
+	if !errors.Is(err, ErrCrashed) || !storage.Crashed() || storage.TornOffset() != int64(dumbdb.PageSize) {
+		t.Fatalf("expected torn write of the second page, got %v", err)
+	}
+
+	// every operation after the crash fails
+	_, err = storage.ReadAt(page, 0)
+	if !errors.Is(err, ErrCrashed) {
+		t.Fatalf("expected ErrCrashed, got %v", err)
+	}
+
+	err = storage.Truncate(0)
+	if !errors.Is(err, ErrCrashed) || storage.Ops() != 2 {
+		t.Fatalf("expected ErrCrashed after 2 operations, got %v after %v", err, storage.Ops())
+	}
+
+	// torn page consists of whole sectors of the old and the new contents
+	torn := make([]byte, dumbdb.PageSize)
+	_, err = disk.ReadAt(torn, int64(dumbdb.PageSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for start := 0; start < len(torn); start += SectorSize {
+		sector := torn[start : start+SectorSize]
+		if !bytes.Equal(sector, make([]byte, SectorSize)) && !bytes.Equal(sector, bytes.Repeat([]byte{2}, SectorSize)) {
+			t.Fatalf("sector at %v is torn", start)
+		}
+	}
+}
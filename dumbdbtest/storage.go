@@ -0,0 +1,169 @@
+// Package dumbdbtest provides helpers for tests of code embedding dumbdb:
+// in-memory and fault-injecting storages, and checkers of storage invariants
+package dumbdbtest
+
+import (
+	"dumbdb"
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+var (
+	ErrInjected = errors.New("injected fault")
+	ErrCrashed  = errors.New("storage crashed")
+)
+
+// Writes of a sector are assumed to be atomic, so torn writes persist whole sectors
+const SectorSize = 512
+
+// Storage which keeps pages in memory, it can be reopened by a new pager
+// to simulate a restart
+func NewMemoryStorage() *dumbdb.MemoryStorage {
+	return dumbdb.NewMemoryStorage()
+}
+
+// Storage which injects faults into another one: short reads, and a crash at
+// the given mutating operation (write or truncate). The operation at the crash
+// point either fails without changing anything or, for torn writes, persists
+// only some of the sectors. Every operation after the crash fails with ErrCrashed,
+// the wrapped storage is left as a disk would be after a power loss.
+//
+// Faults are chosen by a random generator with the given seed, so runs with the
+// same seed and the same sequence of operations are reproducible
+type FaultStorage struct {
+	dumbdb.Storage
+
+	// index of the mutating operation which crashes the storage, -1 to never crash
+	CrashAt int
+	// whether the write at the crash point persists a part of the sectors
+	TornWrites bool
+	// probability of a read to return a part of the data with ErrInjected
+	ShortReadRate float64
+
+	m          sync.Mutex
+	rnd        *rand.Rand
+	ops        int // mutating operations so far
+	crashed    bool
+	tornOffset int64 // offset of the write torn by the crash, -1 if none
+}
+
+func NewFaultStorage(storage dumbdb.Storage, seed int64) *FaultStorage {
+	return &FaultStorage{
+		Storage:    storage,
+		CrashAt:    -1,
+		rnd:        rand.New(rand.NewSource(seed)),
+		tornOffset: -1,
+	}
+}
+
+// Returns true once the storage has crashed
+func (s *FaultStorage) Crashed() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.crashed
+}
+
+// Returns offset of the write torn by the crash, or -1 if no write was torn
+func (s *FaultStorage) TornOffset() int64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.tornOffset
+}
+
+// Number of mutating operations so far, including the failed ones
+func (s *FaultStorage) Ops() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.ops
+}
+
+// Returns ErrCrashed if the storage has crashed, or crashes it if the operation
+// is the crash point
+func (s *FaultStorage) mutate() (crash bool, err error) {
+	if s.crashed {
+		return false, ErrCrashed
+	}
+
+	s.ops++
+	if s.ops-1 == s.CrashAt {
+		s.crashed = true
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *FaultStorage) ReadAt(buf []byte, off int64) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.crashed {
+		return 0, ErrCrashed
+	}
+
+	if len(buf) != 0 && s.rnd.Float64() < s.ShortReadRate {
+		n, err := s.Storage.ReadAt(buf[:s.rnd.Intn(len(buf))], off)
+		if err == nil {
+			err = ErrInjected
+		}
+		return n, err
+	}
+	return s.Storage.ReadAt(buf, off)
+}
+
+func (s *FaultStorage) WriteAt(buf []byte, off int64) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	crash, err := s.mutate()
+	if err != nil {
+		return 0, err
+	}
+
+	if !crash {
+		return s.Storage.WriteAt(buf, off)
+	}
+
+	if !s.TornWrites || len(buf) <= SectorSize {
+		return 0, ErrCrashed
+	}
+
+	// random subset of the sectors reaches the disk
+	s.tornOffset = off
+	for start := 0; start < len(buf); start += SectorSize {
+		end := start + SectorSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		if s.rnd.Intn(2) == 0 {
+			s.Storage.WriteAt(buf[start:end], off+int64(start))
+		}
+	}
+	return 0, ErrCrashed
+}
+
+func (s *FaultStorage) Truncate(size int64) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	crash, err := s.mutate()
+	if err != nil {
+		return err
+	}
+
+	if crash {
+		return ErrCrashed
+	}
+	return s.Storage.Truncate(size)
+}
+
+func (s *FaultStorage) Seek(diff int64, whence int) (int64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.crashed {
+		return 0, ErrCrashed
+	}
+	return s.Storage.Seek(diff, whence)
+}
@@ -0,0 +1,157 @@
+package dumbdb
+
+import (
+	"context"
+	"os"
+)
+
+// Options of the embedded database
+type Options struct {
+	// directory with the data files, created if it doesn't exist
+	DataDir string
+
+	// storage of table files, StorageFile (default), StorageMmap or StorageDirect
+	Storage string
+
+	// reject statements modifying the database with ErrReadOnly and don't write
+	// to DataDir at all, it has to exist
+	ReadOnly bool
+
+	// max memory in bytes used by all the running queries together, queries
+	// fail with ErrDatabaseMemoryLimit once it's exceeded. 0 means no limit
+	MemoryLimit int64
+
+	// key of the tables created with EncryptionAESGCM, see LoadEncryptionKey().
+	// Encrypted tables can't be created or opened without it
+	EncryptionKey []byte
+
+	// statements modifying the database are written to the audit log, nil disables it.
+	// It's not closed by Database.Close()
+	AuditLog *AuditLog
+}
+
+// Open database for use from Go code, without running the server
+func OpenDatabase(opts Options) (*Database, error) {
+	if !opts.ReadOnly {
+		err := os.MkdirAll(opts.DataDir, 0700)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	storage := opts.Storage
+	if storage == "" {
+		storage = StorageFile
+	}
+	db, err := newDatabase(opts.DataDir, storage, opts.ReadOnly, opts.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MemoryLimit != 0 {
+		db.memory = NewMemoryPool(opts.MemoryLimit)
+	}
+	db.audit = opts.AuditLog
+	return db, nil
+}
+
+// Create a new table in the default database
+func (db *Database) CreateTable(name string, schema Schema) (*Table, error) {
+	return db.CreateTableWithOptions(name, schema, TableOptions{})
+}
+
+// Create a new table in the default database with non-default storage options
+func (db *Database) CreateTableWithOptions(name string, schema Schema, opts TableOptions) (*Table, error) {
+	catalog, err := db.catalog(DefaultDatabase)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.CreateTable(name, schema, opts)
+}
+
+// Get table from the default database by name
+func (db *Database) Table(name string) (*Table, error) {
+	catalog, err := db.catalog(DefaultDatabase)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.Table(name)
+}
+
+func IntField(name string) Field {
+	return Field{Name: name, TypeID: TypeInt, Len: 4}
+}
+
+func BigintField(name string) Field {
+	return Field{Name: name, TypeID: TypeBigint, Len: 8}
+}
+
+func FloatField(name string) Field {
+	return Field{Name: name, TypeID: TypeFloat, Len: 8}
+}
+
+func BoolField(name string) Field {
+	return Field{Name: name, TypeID: TypeBool, Len: 1}
+}
+
+func VarcharField(name string, maxLen uint8) Field {
+	return Field{Name: name, TypeID: TypeVarchar, Len: maxLen}
+}
+
+// Build schema from fields, e.g. MakeSchema(IntField("id"), VarcharField("name", 32))
+func MakeSchema(fields ...Field) Schema {
+	var schema Schema
+	for _, field := range fields {
+		schema.addField(field)
+	}
+	return schema
+}
+
+func IntValue(n int32) Value {
+	return Value{TypeID: TypeInt, Int: int64(n)}
+}
+
+func BigintValue(n int64) Value {
+	return Value{TypeID: TypeBigint, Int: n}
+}
+
+func BoolValue(b bool) Value {
+	return Value{TypeID: TypeBool, Int: BoolVal(b).ToInt()}
+}
+
+func VarcharValue(s string) Value {
+	return Value{TypeID: TypeVarchar, Str: s}
+}
+
+func (table *Table) Schema() Schema {
+	return table.schema
+}
+
+// Return rows matching the filter, which is an expression in where clause syntax,
+// e.g. `id > 10 and name != "foo"`. Empty filter matches all rows
+func (table *Table) Query(filter string) (*Rows, error) {
+	match := func(row Row) (bool, error) {
+		return true, nil
+	}
+
+	var predicates []ColumnPredicate
+	if filter != "" {
+		expr, err := ParseExpression(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		match, predicates, err = planFilter(expr, &table.schema, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	identity := func(row Row) Row {
+		return row
+	}
+
+	rows := FullScan(context.Background(), table, predicates, nil, match, identity, nil)
+	rows.schema = &table.schema
+	return rows, nil
+}
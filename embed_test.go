@@ -0,0 +1,176 @@
+package dumbdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmbeddedAPI(t *testing.T) {
+	db, err := OpenDatabase(Options{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	users, err := db.CreateTable("users", MakeSchema(IntField("id"), VarcharField("name", 16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = users.Insert([]Row{
+		{IntValue(1), VarcharValue("foo")},
+		{IntValue(2), VarcharValue("bar")},
+		{IntValue(3), VarcharValue("baz")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = users.Insert([]Row{{VarcharValue("bad"), IntValue(4)}})
+	if err == nil {
+		t.Fatal("Expected insert of mistyped row to fail")
+	}
+
+	rows, err := users.Query(`id > 1 and name != "baz"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		names = append(names, rows.Row()[1].StrVal())
+	}
+
+	if len(names) != 1 || names[0] != "bar" {
+		t.Fatalf("Unexpected query result: %v", names)
+	}
+
+	_, err = users.Query("id + 1")
+	if err == nil {
+		t.Fatal("Expected non-bool filter to fail")
+	}
+}
+
+func TestScanStruct(t *testing.T) {
+	db, err := OpenDatabase(Options{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	users, err := db.CreateTable("users", MakeSchema(IntField("id"), VarcharField("user_name", 16), BoolField("active")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = users.Insert([]Row{{IntValue(7), VarcharValue("foo"), BoolValue(true)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type user struct {
+		ID     int64
+		Name   string `dumbdb:"user_name"`
+		Active bool
+		Note   string `dumbdb:"-"`
+	}
+
+	rows, err := users.Query("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var u user
+	if !rows.Next() {
+		t.Fatal("Expected a row")
+	}
+
+	err = rows.ScanStruct(&u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u != (user{ID: 7, Name: "foo", Active: true}) {
+		t.Fatalf("Unexpected result: %+v", u)
+	}
+
+	var wrong struct {
+		ID string
+	}
+	err = rows.ScanStruct(&wrong)
+	if err == nil {
+		t.Fatal("Expected scanning int into string to fail")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenDatabase(Options{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := db.CreateTable("users", MakeSchema(IntField("id"), VarcharField("name", 200)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// enough rows to fill several pages
+	rows := make([]Row, 0, 100)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue("foo")})
+	}
+
+	err = users.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = users.Truncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = users.Insert([]Row{{IntValue(42), VarcharValue("bar")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = OpenDatabase(Options{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	users, err = db.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := users.Query("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := result.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(all) != 1 || all[0][0].Int != 42 {
+		t.Fatalf("Unexpected rows after truncate: %v", all)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "users.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() != 2*int64(PageSize) {
+		t.Fatalf("Expected table file to shrink to 2 pages, got %v bytes", info.Size())
+	}
+}
@@ -0,0 +1,165 @@
+package dumbdb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Encryption of table pages, see TableOptions
+const (
+	EncryptionNone   = ""
+	EncryptionAESGCM = "aes_gcm"
+)
+
+var (
+	ErrUnknownEncryption      = errors.New("unknown encryption, expected aes_gcm")
+	ErrNoEncryptionKey        = errors.New("encryption key is not set")
+	ErrInvalidEncryptionKey   = errors.New("encryption key should be 64 hex digits (32 bytes)")
+	ErrEncryptedCompression   = errors.New("encrypted tables can't be compressed")
+	ErrMemoryEngineEncryption = errors.New("memory tables can't be encrypted")
+	ErrEncryptedDictionary    = errors.New("encrypted tables can't have dictionary columns, dictionaries are stored unencrypted")
+)
+
+// Size of AES-256 keys
+const EncryptionKeySize = 32
+
+// Environment variable with the hex-encoded key, used if the key file isn't set
+const EncryptionKeyEnv = "DUMBDB_ENCRYPTION_KEY"
+
+const (
+	pageNonceSize = 12
+	pageTagSize   = 16
+
+	// encrypted page is stored as nonce + encrypted data + authentication tag
+	encryptedPageSize = int64(PageSize) + pageNonceSize + pageTagSize
+)
+
+// Decode hex-encoded key, surrounding whitespace is ignored
+func ParseEncryptionKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != EncryptionKeySize {
+		return nil, ErrInvalidEncryptionKey
+	}
+	return key, nil
+}
+
+// Read the key from the file with the hex-encoded key or, if the filename is
+// empty, from EncryptionKeyEnv. Returns nil if neither is set
+func LoadEncryptionKey(filename string) ([]byte, error) {
+	if filename != "" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		return ParseEncryptionKey(string(data))
+	}
+
+	s, ok := os.LookupEnv(EncryptionKeyEnv)
+	if !ok || s == "" {
+		return nil, nil
+	}
+	return ParseEncryptionKey(s)
+}
+
+// Encrypts and authenticates pages with AES-GCM. Each write gets a random nonce,
+// which is stored in the header of the page along with the data, and the ids of
+// the page and its file are authenticated too, so that pages can't be swapped
+// or copied from other files encrypted with the same key
+type pageCipher struct {
+	aead cipher.AEAD
+	// random id of the file kept in its allocation index, 0 for the files
+	// written before the files had ids, only the page id is authenticated then
+	fileID uint32
+}
+
+func newPageCipher(key []byte) (*pageCipher, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, ErrInvalidEncryptionKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &pageCipher{aead: aead}, nil
+}
+
+// Encrypt the data of the page into buf of encryptedPageSize bytes
+func (c *pageCipher) seal(buf []byte, id PageID, data []byte) error {
+	nonce := buf[:pageNonceSize]
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	c.aead.Seal(buf[pageNonceSize:pageNonceSize], nonce, data, c.additionalData(id))
+	return nil
+}
+
+// Decrypt buf of encryptedPageSize bytes into the data of the page. Pages of files
+// with ids are written once they are allocated, see Pager.AllocatePage(), so all of
+// them are authenticated. Older files may have pages which were allocated but never
+// written, they are zeroed and read as zeroed pages
+func (c *pageCipher) open(data []byte, id PageID, buf []byte) error {
+	if c.fileID == 0 && isZeroed(buf) {
+		for i := range data {
+			data[i] = 0
+		}
+		return nil
+	}
+
+	_, err := c.aead.Open(data[:0], buf[:pageNonceSize], buf[pageNonceSize:], c.additionalData(id))
+	if err != nil {
+		return fmt.Errorf("%w: %v can't be decrypted, the encryption key is wrong or the page was modified", ErrCorruptedPage, id)
+	}
+	return nil
+}
+
+// Id of the page followed by the id of the file, unless it's 0
+func (c *pageCipher) additionalData(id PageID) []byte {
+	if c.fileID == 0 {
+		ad := make([]byte, 4)
+		binary.LittleEndian.PutUint32(ad, uint32(id))
+		return ad
+	}
+
+	ad := make([]byte, 8)
+	binary.LittleEndian.PutUint32(ad, uint32(id))
+	binary.LittleEndian.PutUint32(ad[4:], c.fileID)
+	return ad
+}
+
+// Random non-zero id of a new file
+func newFileID() (uint32, error) {
+	var buf [4]byte
+	for {
+		_, err := rand.Read(buf[:])
+		if err != nil {
+			return 0, err
+		}
+
+		if id := binary.LittleEndian.Uint32(buf[:]); id != 0 {
+			return id, nil
+		}
+	}
+}
+
+var zeroedPage [encryptedPageSize]byte
+
+func isZeroed(buf []byte) bool {
+	return bytes.Equal(buf, zeroedPage[:len(buf)])
+}
@@ -0,0 +1,249 @@
+package dumbdb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedTable(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{7}, EncryptionKeySize)
+	db, err := OpenDatabase(Options{DataDir: dir, EncryptionKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+	}()
+
+	sess := &Session{}
+	for _, q := range []string{
+		"create table users (id int, name varchar(20)) encryption = aes_gcm",
+		"create index users_name on users (name)",
+	} {
+		mustExec(t, db, sess, q)
+	}
+
+	const nRows = 1000
+	values := make([]string, 0, nRows)
+	for i := 0; i < nRows; i++ {
+		values = append(values, fmt.Sprintf("(%v, \"secret %v\")", i, i))
+	}
+	mustExec(t, db, sess, "insert into users values "+strings.Join(values, ", "))
+
+	check := func() {
+		rows := mustQuery(t, db, sess, "select id, name from users where name = \"secret 123\"")
+		if len(rows) != 1 || rows[0][0].Int != 123 {
+			t.Fatalf("Unexpected rows %v", rows)
+		}
+	}
+	check()
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// neither the table nor the index have the values in plain text
+	for _, name := range []string{"users.bin", "users.users_name.idx"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("secret")) {
+			t.Fatalf("Expected %v to be encrypted", name)
+		}
+	}
+
+	// the key is required to open the table
+	_, err = OpenDatabase(Options{DataDir: dir})
+	if !errors.Is(err, ErrNoEncryptionKey) {
+		t.Fatalf("Expected ErrNoEncryptionKey, got %v", err)
+	}
+
+	// the wrong key fails authentication of the pages
+	_, err = OpenDatabase(Options{DataDir: dir, EncryptionKey: bytes.Repeat([]byte{8}, EncryptionKeySize)})
+	if !errors.Is(err, ErrCorruptedPage) {
+		t.Fatalf("Expected ErrCorruptedPage, got %v", err)
+	}
+
+	db, err = OpenDatabase(Options{DataDir: dir, EncryptionKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	for _, test := range []struct {
+		query    string
+		expected error
+	}{
+		{"create table t (id int) encryption = des", ErrUnknownEncryption},
+		{"create table t (id int) compression = flate encryption = aes_gcm", ErrEncryptedCompression},
+		{"create table t (id int) engine = memory encryption = aes_gcm", ErrMemoryEngineEncryption},
+		{"create table t (s varchar(10)) encryption = aes_gcm dictionary = (s)", ErrEncryptedDictionary},
+	} {
+		_, err := execQuery(t, db, sess, test.query)
+		if !errors.Is(err, test.expected) {
+			t.Fatalf("%v: expected %v, got %v", test.query, test.expected, err)
+		}
+	}
+
+	catalog, err := OpenCatalog(t.TempDir(), StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+
+	_, err = catalog.CreateTable("t", testTableSchema(), TableOptions{Encryption: EncryptionAESGCM})
+	if !errors.Is(err, ErrNoEncryptionKey) {
+		t.Fatalf("Expected ErrNoEncryptionKey, got %v", err)
+	}
+}
+
+func TestEncryptedPager(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, EncryptionKeySize)
+
+	// file with 5 pages written with the key, the last one is only allocated
+	create := func(name string) *os.File {
+		file, err := os.OpenFile(filepath.Join(t.TempDir(), name), os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pager, err := NewEncryptedPager(16, file, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 5; i++ {
+			id, err := pager.AllocatePage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i == 4 {
+				break
+			}
+
+			page, err := pager.FetchPage(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			copy(page.Data(), fmt.Sprintf("%v %v", name, i))
+			page.MarkDirty()
+			page.Unpin()
+		}
+
+		err = pager.SyncAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	file := create("page")
+	defer file.Close()
+	other := create("other")
+	defer other.Close()
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil || size != int64(PageSize)+5*encryptedPageSize {
+		t.Fatalf("Unexpected size of the storage %v: %v", size, err)
+	}
+
+	// allocated pages are written, so that they're authenticated as well
+	last := make([]byte, encryptedPageSize)
+	_, err = file.ReadAt(last, int64(PageSize)+4*encryptedPageSize)
+	if err != nil || isZeroed(last) {
+		t.Fatalf("Expected allocated page to be written (%v)", err)
+	}
+
+	slot := func(id int) int64 {
+		return int64(PageSize) + int64(id)*encryptedPageSize
+	}
+	copySlot := func(from *os.File, fromID int, toID int) {
+		buf := make([]byte, encryptedPageSize)
+		if fromID == -1 {
+			buf = zeroedPage[:]
+		} else {
+			_, err := from.ReadAt(buf, slot(fromID))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err := file.WriteAt(buf, slot(toID))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// pages swapped in the file, zeroed or copied from the same position of
+	// another file encrypted with the same key fail authentication
+	copySlot(file, 0, 1)
+	copySlot(nil, -1, 3)
+	copySlot(other, 4, 4)
+
+	pager, err := NewEncryptedPager(16, file, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []string{"page 0", "", "page 2", "", ""} {
+		page, err := pager.FetchPage(PageID(i))
+		if expected == "" {
+			if !errors.Is(err, ErrCorruptedPage) {
+				t.Fatalf("Expected ErrCorruptedPage, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(page.Data(), []byte(expected)) {
+			t.Fatalf("Expected %q, got %q", expected, page.Data()[:10])
+		}
+		page.Unpin()
+	}
+
+	// pages kept in memory aren't encrypted
+	opts := TableOptions{Encryption: EncryptionAESGCM, EncryptionKey: key}
+	pager, err = opts.newPager(16, NewMemoryStorage())
+	if err != nil || pager.cipher != nil {
+		t.Fatalf("Expected unencrypted pager, got %v", err)
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, EncryptionKeySize)
+	filename := filepath.Join(t.TempDir(), "key")
+	err := ioutil.WriteFile(filename, []byte(hex.EncodeToString(key)+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadEncryptionKey(filename)
+	if err != nil || !bytes.Equal(loaded, key) {
+		t.Fatalf("Unexpected key %x: %v", loaded, err)
+	}
+
+	os.Setenv(EncryptionKeyEnv, hex.EncodeToString(key[1:]))
+	defer os.Unsetenv(EncryptionKeyEnv)
+	_, err = LoadEncryptionKey("")
+	if !errors.Is(err, ErrInvalidEncryptionKey) {
+		t.Fatalf("Expected ErrInvalidEncryptionKey, got %v", err)
+	}
+
+	os.Unsetenv(EncryptionKeyEnv)
+	loaded, err = LoadEncryptionKey("")
+	if err != nil || loaded != nil {
+		t.Fatalf("Expected no key, got %x: %v", loaded, err)
+	}
+}
@@ -1,32 +1,341 @@
 package dumbdb
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
 
-func FullScan(ctx context.Context, table *Table, filter func(Row) bool, project func(Row) Row) <-chan Row {
-	c := make(chan Row, 16)
-	done := ctx.Done()
-	go func() {
-		// TODO: handle error returned by Scan()
-		table.Scan(func(r Row) error {
-			select {
-			case <-done:
-				return ctx.Err()
-			default:
+// Comparison of a column with a constant, which can be checked
+// directly against the encoded row without materializing it
+type ColumnPredicate struct {
+	Offset int
+	Field  Field
+	Op     Op // comparison or OpIn
+	Value  Value
+
+	// values of OpIn
+	Values []Value
+}
+
+func formatConst(val *Value) string {
+	if val.TypeID == TypeVarchar {
+		return strconv.Quote(val.StrVal())
+	}
+	return val.String()
+}
+
+func (p *ColumnPredicate) String() string {
+	if p.Op == OpIn {
+		values := make([]string, 0, len(p.Values))
+		for i := range p.Values {
+			values = append(values, formatConst(&p.Values[i]))
+		}
+		return fmt.Sprintf("%v in (%v)", p.Field.Name, strings.Join(values, ", "))
+	}
+	return fmt.Sprintf("%v %v %v", p.Field.Name, p.Op, formatConst(&p.Value))
+}
+
+// |data| is the encoded row
+func (p *ColumnPredicate) Match(data []byte) bool {
+	if p.Op == OpIn {
+		for i := range p.Values {
+			if p.Field.Compare(data[p.Offset:], &p.Values[i]) == 0 {
+				return true
 			}
+		}
+		return false
+	}
+	return p.Op.Holds(p.Field.Compare(data[p.Offset:], &p.Value))
+}
+
+func MatchAll(predicates []ColumnPredicate, data []byte) bool {
+	for i := range predicates {
+		if !predicates[i].Match(data) {
+			return false
+		}
+	}
+	return true
+}
+
+func constValue(tree *BinOpTree) (Value, bool) {
+	if tree.val == nil || tree.val.Const == nil {
+		return Value{}, false
+	}
+
+	c := tree.val.Const
+	switch {
+	case c.Float != nil:
+		return FloatValue(*c.Float), true
+	case c.Int != nil:
+		return IntegerValue(*c.Int), true
+	case c.Bool != nil:
+		return Value{TypeID: TypeBool, Int: c.Bool.ToInt()}, true
+	case c.Str != nil:
+		return Value{TypeID: TypeVarchar, Str: *c.Str}, true
+	}
+
+	return Value{}, false
+}
+
+func fieldName(tree *BinOpTree) (string, bool) {
+	if tree.val == nil || tree.val.Field == nil {
+		return "", false
+	}
+	return tree.val.Field.Name, true
+}
 
-			if !filter(r) {
-				return nil
+// Collect simple comparisons (column op const) from the top-level conjunction
+// of |expr|. Matching rows by these predicates is necessary, but not sufficient
+// condition for the whole expression to be true, so the full filter still has to be
+// applied to rows passing the predicates.
+// |expr| should be typechecked before calling this function
+func ExtractPredicates(expr *BinOpTree, schema *Schema) []ColumnPredicate {
+	if expr == nil || expr.subtree == nil {
+		return nil
+	}
+
+	node := expr.subtree
+	if node.Op == OpAnd {
+		left := ExtractPredicates(node.Left, schema)
+		right := ExtractPredicates(node.Right, schema)
+		return append(left, right...)
+	}
+
+	switch node.Op {
+	case OpIn:
+		return inPredicates(node, schema)
+	case OpLike:
+		return likePredicates(node, schema)
+	case OpNot:
+		// not (a op b) is the same as (a op.Negate() b)
+		operand := node.Left.subtree
+		if operand == nil || !operand.Op.IsComparison() {
+			return nil
+		}
+		return ExtractPredicates(binOp(operand.Op.Negate(), operand.Left, operand.Right), schema)
+	}
+
+	if !node.Op.IsComparison() {
+		return nil
+	}
+
+	op := node.Op
+	name, isField := fieldName(node.Left)
+	val, isConst := constValue(node.Right)
+	if !isField || !isConst {
+		// try (const op column)
+		name, isField = fieldName(node.Right)
+		val, isConst = constValue(node.Left)
+		op = op.Mirror()
+	}
+
+	if !isField || !isConst {
+		return nil
+	}
+
+	idx, field := schema.GetField(name)
+	if idx == -1 || !Comparable(field.TypeID, val.TypeID) {
+		return nil
+	}
+
+	return []ColumnPredicate{{
+		Offset: schema.Offset(idx),
+		Field:  field,
+		Op:     op,
+		Value:  val,
+	}}
+}
+
+// Convert context error into the error reported to the user, returns nil if ctx is not done
+func CancellationError(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return ErrStatementTimeout
+	default:
+		return ErrQueryCancelled
+	}
+}
+
+// Scan rows of the table matching predicates and filter, pages scanned so far
+// are counted in progress, which can be nil. columns are the ones used by the
+// predicates, filter and projection, nil for all of them, see Table.scanPage()
+func FullScan(ctx context.Context, table *Table, predicates []ColumnPredicate, columns []bool, filter func(Row) (bool, error), project func(Row) Row, progress *ScanProgress) *Rows {
+	// scan is registered synchronously, while the caller still holds the catalog lock
+	scanCtx, end, err := table.beginScan(ctx)
+	if err != nil {
+		return NewRows(ctx, func(ctx context.Context, emit func(Row) error) error {
+			return err
+		})
+	}
+
+	return NewRows(scanCtx, func(ctx context.Context, emit func(Row) error) error {
+		onRow := func(r Row) error {
+			err := ctx.Err()
+			if err != nil {
+				return err
 			}
 
-			select {
-			case c <- project(r):
-				return nil
-			case <-done:
-				return ctx.Err()
+			ok, err := filter(r)
+			if err != nil || !ok {
+				return err
 			}
+
+			return emit(project(r))
+		}
+
+		predicates := table.storedPredicates(predicates)
+		probes := table.bloom.probes(predicates)
+		zones := table.zones.predicates(predicates)
+		progress.begin(table.pageCount())
+		var err error
+		for id := table.firstPage(); id != InvalidPageID; id = table.nextPage(id) {
+			if table.zones.Excludes(id, zones) || table.bloom.Excludes(id, probes) {
+				progress.pageScanned()
+				continue
+			}
+
+			err = table.scanPage(id, predicates, columns, onRow)
+			if err != nil {
+				break
+			}
+			progress.pageScanned()
+		}
+		return end(err)
+	})
+}
+
+// Returns projected rows of rows which pass the filter
+func FilterRows(ctx context.Context, rows *Rows, filter func(Row) (bool, error), project func(Row) Row) *Rows {
+	return NewRows(ctx, func(ctx context.Context, emit func(Row) error) error {
+		defer rows.Close()
+
+		for rows.Next() {
+			row := rows.Row()
+			ok, err := filter(row)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+
+			err = emit(project(row))
+			if err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// Returns rows from the slice
+func StaticRows(rows []Row) *Rows {
+	return NewRows(context.Background(), func(ctx context.Context, emit func(Row) error) error {
+		for _, row := range rows {
+			err := emit(row)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Returns at most n rows of rows, producing of the rest is stopped
+func LimitRows(ctx context.Context, rows *Rows, n int64) *Rows {
+	limited := NewRows(ctx, func(ctx context.Context, emit func(Row) error) error {
+		defer rows.Close()
+
+		for i := int64(0); i < n && rows.Next(); i++ {
+			err := emit(rows.Row())
+			if err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+	limited.schema = rows.schema
+	return limited
+}
+
+// column in (const, ...)
+func inPredicates(node *BinOpNode, schema *Schema) []ColumnPredicate {
+	name, ok := fieldName(node.Left)
+	if !ok {
+		return nil
+	}
+
+	idx, field := schema.GetField(name)
+	if idx == -1 {
+		return nil
+	}
+
+	values := make([]Value, 0, len(node.List))
+	for _, item := range node.List {
+		val, ok := constValue(item)
+		if !ok || !Comparable(val.TypeID, field.TypeID) {
+			return nil
+		}
+		values = append(values, val)
+	}
+
+	return []ColumnPredicate{{
+		Offset: schema.Offset(idx),
+		Field:  field,
+		Op:     OpIn,
+		Values: values,
+	}}
+}
+
+// column like "prefix%..." is a range scan over strings starting with the prefix
+func likePredicates(node *BinOpNode, schema *Schema) []ColumnPredicate {
+	name, isField := fieldName(node.Left)
+	pattern, isConst := constValue(node.Right)
+	if !isField || !isConst {
+		return nil
+	}
+
+	idx, field := schema.GetField(name)
+	if idx == -1 || field.TypeID != TypeVarchar {
+		return nil
+	}
+
+	prefix := pattern.StrVal()
+	end := strings.IndexAny(prefix, "%_")
+	if end != -1 {
+		prefix = prefix[:end]
+	}
+
+	if prefix == "" {
+		return nil
+	}
+
+	predicates := []ColumnPredicate{{
+		Offset: schema.Offset(idx),
+		Field:  field,
+		Op:     OpGreaterOrEq,
+		Value:  Value{TypeID: TypeVarchar, Str: prefix},
+	}}
+
+	// smallest string greater than all the strings with the prefix
+	upper := []byte(prefix)
+	for len(upper) != 0 && upper[len(upper)-1] == 0xff {
+		upper = upper[:len(upper)-1]
+	}
+
+	if len(upper) != 0 {
+		upper[len(upper)-1]++
+		predicates = append(predicates, ColumnPredicate{
+			Offset: schema.Offset(idx),
+			Field:  field,
+			Op:     OpLess,
+			Value:  Value{TypeID: TypeVarchar, Str: string(upper)},
 		})
-		close(c)
-	}()
+	}
 
-	return c
+	return predicates
 }
@@ -0,0 +1,197 @@
+package dumbdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPredicatePushdown(t *testing.T) {
+	q, err := ParseQuery("create table users (id int, name varchar(20), age int)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := NewSchema(q.Create.Fields)
+
+	rows := []Row{
+		{{TypeID: TypeInt, Int: 1}, {TypeID: TypeVarchar, Str: "Hello"}, {TypeID: TypeInt, Int: 1337}},
+		{{TypeID: TypeInt, Int: 2}, {TypeID: TypeVarchar, Str: "World"}, {TypeID: TypeInt, Int: 42}},
+		{{TypeID: TypeInt, Int: 3}, {TypeID: TypeVarchar, Str: "a"}, {TypeID: TypeInt, Int: 20}},
+	}
+
+	fieldToIdx := make(map[string]int)
+	for i, name := range schema.ColumnNames() {
+		fieldToIdx[name] = i
+	}
+
+	wheres := []string{
+		"id = 1",
+		"1 < id",
+		"id <= 2 and age > 30",
+		"name = \"a\"",
+		"name != \"World\" and 42 >= age",
+		"(id - 2) * 2 <= 42 or name != \"kekus\"",
+		"id between 2 and 3",
+		"age in (20, 1337) and id in (1, 2)",
+		"name like \"Hel%\"",
+		"name like \"%or_d\"",
+		"not (id = 1) and not age < 30",
+		"id > -5 and -age < -30",
+		"not not name = \"a\"",
+		"length(name) = 5 and id < 3",
+		"upper(substr(name, 1, 1)) = \"A\" or concat(trim(name), \"!\") = \"World!\"",
+	}
+
+	data := make([]byte, schema.RowSize())
+	for _, where := range wheres {
+		q, err := ParseQuery("select * from users where " + where)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tree := q.Select.Where.ToBinOp()
+		if _, err := exprType(tree, &schema); err != nil {
+			t.Fatal(err)
+		}
+
+		predicates := ExtractPredicates(tree, &schema)
+		for i, row := range rows {
+			err = schema.WriteRow(data, row)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			value, err := evalExpr(tree, fieldToIdx, row)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := value.Int != 0
+			if expected && !MatchAll(predicates, data) {
+				t.Fatalf("%v: row #%d was filtered out by predicates", where, i)
+			}
+		}
+	}
+}
+
+func TestMatchLike(t *testing.T) {
+	cases := []struct {
+		s, pattern string
+		match      bool
+	}{
+		{"hello", "hello", true},
+		{"hello", "hel%", true},
+		{"hello", "%llo", true},
+		{"hello", "%ll%", true},
+		{"hello", "h_llo", true},
+		{"hello", "%", true},
+		{"", "%", true},
+		{"hello", "h%l%o", true},
+		{"hello", "world%", false},
+		{"hello", "%x%", false},
+		{"hello", "hell", false},
+		{"hello", "h_lo", false},
+	}
+
+	for _, c := range cases {
+		if MatchLike(c.s, c.pattern) != c.match {
+			t.Errorf("%q like %q: expected %v", c.s, c.pattern, c.match)
+		}
+	}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	cases := []struct {
+		expr     string
+		expected Value
+	}{
+		{"1 + 2", Value{TypeID: TypeInt, Int: 3}},
+		{"2147483647 + 1", Value{TypeID: TypeInt, Int: -2147483648}},
+		{"-2147483648 - 1", Value{TypeID: TypeInt, Int: 2147483647}},
+		{"2147483648 + 1", Value{TypeID: TypeBigint, Int: 2147483649}},
+		{"65536 * 65536", Value{TypeID: TypeInt, Int: 0}},
+		{"65536 * 65536 + 4294967296", Value{TypeID: TypeBigint, Int: 4294967296}},
+		{"9223372036854775807 + 1", Value{TypeID: TypeBigint, Int: -9223372036854775808}},
+		{"-(-2147483648)", Value{TypeID: TypeBigint, Int: 2147483648}},
+	}
+
+	var schema Schema
+	for _, c := range cases {
+		e, err := ParseExpression(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tree := e.ToBinOp()
+		typ, err := exprType(tree, &schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		val, err := evalExpr(tree, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val != c.expected || typ != c.expected.TypeID {
+			t.Errorf("%v: expected %v %v, got %v %v", c.expr, c.expected.TypeID, c.expected.Int, typ, val.Int)
+		}
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	cases := []struct {
+		expr     string
+		expected int64
+	}{
+		// division by zero would fail
+		{"false and 1 / 0 = 1", 0},
+		{"true or 1 / 0 = 1", 1},
+		{"1 = 1 and 2 > 1", 1},
+		{"1 = 2 or 2 < 1", 0},
+	}
+
+	var schema Schema
+	for _, c := range cases {
+		e, err := ParseExpression(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tree := e.ToBinOp()
+		_, err = exprType(tree, &schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		val, err := evalExpr(tree, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val.TypeID != TypeBool || val.Int != c.expected {
+			t.Errorf("%v: expected %v, got %v", c.expr, c.expected, val)
+		}
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mustExec(t, db, nil, "create table users (id int, score float)")
+	mustExec(t, db, nil, "insert into users values (1, 1.5), (2, 0.0)")
+
+	for _, where := range []string{"id / 0 = 1", "1 / (id - 1) = 1", "score / score > 0", "id in (1, 2 / 0)"} {
+		_, err := queryRows(t, db, nil, "select * from users where "+where)
+		if !errors.Is(err, ErrDivisionByZero) {
+			t.Fatalf("%v: expected ErrDivisionByZero, got %v", where, err)
+		}
+	}
+
+	// rows which aren't divided by zero pass
+	rows := mustQuery(t, db, nil, "select * from users where id = 2 or 1 / (id - 2) = 1")
+	if len(rows) != 1 || rows[0][0].Int != 2 {
+		t.Fatalf("Expected the row with id 2, got %v", rows)
+	}
+}
@@ -0,0 +1,164 @@
+package dumbdb
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Writes rows in some machine-readable format
+type RowWriter interface {
+	WriteRow(row Row) error
+
+	// Flush buffered data, has to be called after the last row
+	Flush() error
+}
+
+type csvRowWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+// Write rows as CSV, first line is the header with column names
+func NewCSVWriter(w io.Writer, schema *Schema) (RowWriter, error) {
+	writer := &csvRowWriter{
+		w:      csv.NewWriter(w),
+		record: make([]string, 0, len(schema.Fields)),
+	}
+
+	err := writer.w.Write(schema.ColumnNames())
+	if err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+func (w *csvRowWriter) WriteRow(row Row) error {
+	w.record = w.record[:0]
+	for i := range row {
+		w.record = append(w.record, row[i].String())
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *csvRowWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+type jsonRowWriter struct {
+	enc    *json.Encoder
+	names  []string
+	object map[string]interface{}
+}
+
+// Write rows as JSON objects, one per line
+func NewJSONWriter(w io.Writer, schema *Schema) (RowWriter, error) {
+	return &jsonRowWriter{
+		enc:    json.NewEncoder(w),
+		names:  schema.ColumnNames(),
+		object: make(map[string]interface{}, len(schema.Fields)),
+	}, nil
+}
+
+// Convert value into a type which is natural for JSON
+func (val *Value) Interface() interface{} {
+	switch val.TypeID {
+	case TypeInt, TypeBigint:
+		return val.Int
+	case TypeFloat:
+		return val.Float
+	case TypeBool:
+		return val.Int != 0
+	case TypeVarchar:
+		return val.StrVal()
+	}
+	return nil
+}
+
+func (w *jsonRowWriter) WriteRow(row Row) error {
+	for i := range row {
+		w.object[w.names[i]] = row[i].Interface()
+	}
+	return w.enc.Encode(w.object)
+}
+
+func (w *jsonRowWriter) Flush() error {
+	return nil
+}
+
+// Create writer for the format, which is either "csv" or "json"
+func NewRowWriter(format string, w io.Writer, schema *Schema) (RowWriter, error) {
+	switch format {
+	case "csv":
+		return NewCSVWriter(w, schema)
+	case "json":
+		return NewJSONWriter(w, schema)
+	default:
+		return nil, fmt.Errorf("unknown format %v", format)
+	}
+}
+
+func (catalog *Catalog) doCopyTo(ctx context.Context, copy *CopyTo) (*Result, error) {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	plan, err := catalog.planSelect(copy.Select)
+	if err != nil {
+		return nil, err
+	}
+
+	format := copy.Format
+	if format == "" {
+		format = "csv"
+	}
+
+	file, err := os.OpenFile(copy.Filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	writer, err := NewRowWriter(format, file, &plan.schema)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := plan.rows(ctx, nil)
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		err = writer.WriteRow(rows.Row())
+		if err != nil {
+			return nil, err
+		}
+		n++
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var schema Schema
+	schema.addField(Field{Name: "rows", TypeID: TypeInt, Len: 4})
+	return &Result{
+		Schema: schema,
+		Rows:   StaticRows([]Row{{{TypeID: TypeInt, Int: int64(n)}}}),
+	}, nil
+}
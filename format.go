@@ -0,0 +1,434 @@
+package dumbdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version of the files of a catalog, bumped on incompatible changes of the layout
+// of the pages. Older versions are upgraded by Migrate():
+//
+//	1: pages without headers (no version file)
+//	2: pages with PageHeaderSize headers
+const FormatVersion = 2
+
+// File with the format version of the catalog
+const VersionFilename = "version"
+
+var (
+	ErrOldFormat         = errors.New("data directory is written by an older version, it has to be migrated")
+	ErrUnsupportedFormat = errors.New("data directory is written by a newer version")
+)
+
+// Returns format version of the catalog in dataDir, 0 if there is no catalog yet
+func readFormatVersion(dataDir string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dataDir, VersionFilename))
+	if os.IsNotExist(err) {
+		// the first version didn't have the file
+		_, err = os.Stat(filepath.Join(dataDir, MetadataFilename))
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid format version %q", data)
+	}
+	return version, nil
+}
+
+// Contents of the version file of the current format
+func formatVersionData() []byte {
+	return []byte(strconv.Itoa(FormatVersion) + "\n")
+}
+
+func writeFormatVersion(dataDir string) error {
+	return writeFileAtomic(filepath.Join(dataDir, VersionFilename), formatVersionData())
+}
+
+// Returns an error unless the catalog in dataDir has the current format,
+// the version of a new catalog is written unless it's read-only
+func checkFormatVersion(dataDir string, readOnly bool) error {
+	version, err := readFormatVersion(dataDir)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case version == 0 && !readOnly:
+		return writeFormatVersion(dataDir)
+	case version == 0 || version == FormatVersion:
+		return nil
+	case version < FormatVersion:
+		return fmt.Errorf("%w: %v has format version %v, expected %v", ErrOldFormat, dataDir, version, FormatVersion)
+	default:
+		return fmt.Errorf("%w: %v has format version %v, %v is supported", ErrUnsupportedFormat, dataDir, version, FormatVersion)
+	}
+}
+
+// Upgrade files of all the databases in dataDir to FormatVersion, key is the one
+// of the encrypted tables. The database shouldn't be open. Migration can be rerun
+// if it's interrupted
+func Migrate(dataDir string, key []byte) error {
+	lock, err := lockDataDir(dataDir)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	err = migrateCatalog(dataDir, key)
+	if err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(dataDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(dataDir, entry.Name())
+		_, err := os.Stat(filepath.Join(dir, MetadataFilename))
+		if os.IsNotExist(err) {
+			// not a database directory
+			continue
+		}
+
+		err = migrateCatalog(dir, key)
+		if err != nil {
+			return fmt.Errorf("database %v: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// The version file is written once all the tables are copied, the old table files
+// are kept until then, so the catalog is migrated again if it's interrupted
+func migrateCatalog(dataDir string, key []byte) error {
+	version, err := readFormatVersion(dataDir)
+	if err != nil {
+		return err
+	}
+
+	if version == 0 || version == FormatVersion {
+		return nil
+	}
+	if version > FormatVersion {
+		return fmt.Errorf("%w: %v has format version %v, %v is supported", ErrUnsupportedFormat, dataDir, version, FormatVersion)
+	}
+
+	_, err = os.Stat(filepath.Join(dataDir, JournalFilename))
+	if err == nil {
+		return fmt.Errorf("%v has unfinished DDL, it has to be recovered by the version which wrote it", dataDir)
+	}
+
+	metadata, err := readMetadata(dataDir)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(metadata))
+	for name := range metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		meta := metadata[name]
+		path := filepath.Join(dataDir, name)
+		if meta.Engine != EngineMemory {
+			err = migrateTable(path, &meta, key)
+			if err != nil {
+				return fmt.Errorf("table %v: %w", name, err)
+			}
+		}
+
+		// indexes are rebuilt once the catalog is opened without row counts
+		for i, index := range meta.Indexes {
+			err = os.Remove(indexPath(path, index.Name))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			meta.Indexes[i].HeaderID = 0
+		}
+		meta.RowCount = nil
+		metadata[name] = meta
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(filepath.Join(dataDir, MetadataFilename), data)
+	if err == nil {
+		err = writeFormatVersion(dataDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = os.Remove(filepath.Join(dataDir, name) + ".bin.v1")
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy rows of the table file of version 1 into a new one, the old file is renamed
+// to .bin.v1. A copy left by an interrupted migration is discarded
+func migrateTable(path string, meta *tableMetadata, key []byte) error {
+	opts := meta.TableOptions
+	if opts.Encryption != EncryptionNone {
+		if key == nil {
+			return ErrNoEncryptionKey
+		}
+		opts.EncryptionKey = key
+	}
+
+	filename := path + ".bin"
+	oldFilename := filename + ".v1"
+	_, err := os.Stat(oldFilename)
+	if os.IsNotExist(err) {
+		err = os.Rename(filename, oldFilename)
+	} else if err == nil {
+		err = os.Remove(filename)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	old, err := openLegacyTable(oldFilename, &opts)
+	if err != nil {
+		return err
+	}
+
+	table, err := OpenTable(path, meta.Schema, opts)
+	if err != nil {
+		old.storage.Close()
+		return err
+	}
+
+	err = old.scan(&table.layout, opts.Engine, func(rows []Row) error {
+		for i := range rows {
+			rows[i], err = table.dict.decode(rows[i], nil)
+			if err != nil {
+				return err
+			}
+		}
+		return table.Insert(rows)
+	})
+	if err == nil {
+		err = table.Close()
+	} else {
+		table.Close()
+	}
+	old.storage.Close()
+	return err
+}
+
+// Reader of table files of version 1: the allocation index is the page at the start
+// of the file, which has number of the pages (4) and their bitmap. Pages of
+// PageSize bytes follow it, unless they are encrypted
+type legacyTable struct {
+	storage  TableStorage
+	cipher   *pageCipher
+	slotSize int64
+
+	nPages int
+	bitmap []byte
+}
+
+func openLegacyTable(filename string, opts *TableOptions) (*legacyTable, error) {
+	storage, err := openTableStorage(filename, &TableOptions{Compression: opts.Compression}, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	table := &legacyTable{
+		storage:  storage,
+		slotSize: int64(PageSize),
+	}
+	if opts.Encryption != EncryptionNone {
+		table.cipher, err = newPageCipher(opts.EncryptionKey)
+		if err != nil {
+			storage.Close()
+			return nil, err
+		}
+		table.slotSize = encryptedPageSize
+	}
+
+	root := make([]byte, int(PageSize))
+	_, err = storage.ReadAt(root, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		storage.Close()
+		return nil, err
+	}
+
+	table.nPages = int(binary.LittleEndian.Uint32(root))
+	table.bitmap = root[IndexHeaderSize:]
+	if table.nPages > len(table.bitmap)*8 {
+		storage.Close()
+		return nil, fmt.Errorf("%w: allocation index has %v pages", ErrCorruptedPage, table.nPages)
+	}
+	return table, nil
+}
+
+func (table *legacyTable) isAllocated(id int) bool {
+	return id < table.nPages && table.bitmap[id/8]&(1<<(id%8)) != 0
+}
+
+// Pages which were allocated but never written are zeroed
+func (table *legacyTable) readPage(id int) ([]byte, error) {
+	data := make([]byte, int(PageSize))
+	buf := data
+	if table.cipher != nil {
+		buf = make([]byte, table.slotSize)
+	}
+
+	_, err := table.storage.ReadAt(buf, int64(PageSize)+int64(id)*table.slotSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if table.cipher != nil {
+		err = table.cipher.open(data, PageID(id), buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Call onRows with the rows of each page (or row group), the rows are stored
+// ones, encoded with the layout of the table
+func (table *legacyTable) scan(layout *Schema, engine string, onRows func([]Row) error) error {
+	stride := 1
+	if engine == EngineColumnar {
+		stride = len(layout.Fields)
+	}
+
+	// row groups are complete once their last page is allocated
+	for id := 0; table.isAllocated(id + stride - 1); id += stride {
+		var data [][]byte
+		var err error
+		if engine == EngineColumnar {
+			data, err = table.readGroup(id, layout)
+		} else {
+			data, err = table.readRows(id, layout)
+		}
+		if err != nil {
+			return fmt.Errorf("page %v: %w", id, err)
+		}
+
+		rows := make([]Row, len(data))
+		for i := range data {
+			err = layout.ReadRow(data[i], &rows[i])
+			if err != nil {
+				return fmt.Errorf("page %v: %w", id, err)
+			}
+		}
+
+		if len(rows) != 0 {
+			err = onRows(rows)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Rows of the page: their number (2), then the rows one after another
+func (table *legacyTable) readRows(id int, layout *Schema) ([][]byte, error) {
+	page, err := table.readPage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	n := int(binary.LittleEndian.Uint16(page))
+	size := layout.RowSize()
+	if 2+n*size > len(page) {
+		return nil, fmt.Errorf("%w: %v rows of %v bytes don't fit into the page", ErrCorruptedPage, n, size)
+	}
+
+	rows := make([][]byte, n)
+	for i := range rows {
+		rows[i] = page[2+i*size : 2+(i+1)*size]
+	}
+	return rows, nil
+}
+
+// Rows of the row group, see ColumnGroup. Segments of the version 1 had up to
+// groupRows() rows of the pages without headers
+func (table *legacyTable) readGroup(id int, layout *Schema) ([][]byte, error) {
+	width := 1
+	for _, field := range layout.Fields {
+		if int(field.Len) > width {
+			width = int(field.Len)
+		}
+	}
+	capacity := (int(PageSize) - segmentHeaderSize) / width
+	if capacity > MaxRowsPerPage {
+		capacity = MaxRowsPerPage
+	}
+
+	var rows [][]byte
+	offset := 0
+	for i := range layout.Fields {
+		field := &layout.Fields[i]
+		page, err := table.readPage(id + i)
+		if err != nil {
+			return nil, err
+		}
+
+		n, values, err := decodeSegment(page, field, capacity)
+		if err != nil {
+			return nil, err
+		}
+
+		// rows of the other columns beyond the first one weren't committed
+		if i == 0 {
+			rows = make([][]byte, n)
+			for j := range rows {
+				rows[j] = make([]byte, layout.RowSize())
+			}
+		} else if n < len(rows) {
+			return nil, fmt.Errorf("%w: segment of %v has %v rows, expected at least %v",
+				ErrCorruptedPage, field.Name, n, len(rows))
+		}
+
+		size := int(field.Len)
+		for j := range rows {
+			copy(rows[j][offset:offset+size], values[j*size:])
+		}
+		offset += size
+	}
+	return rows, nil
+}
@@ -0,0 +1,179 @@
+package dumbdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Write table file of format version 1 with the given pages
+func writeLegacyTable(t *testing.T, filename string, pages [][]byte, key []byte) {
+	data := make([]byte, PageSize)
+	binary.LittleEndian.PutUint32(data, uint32(len(pages)))
+	for i := range pages {
+		data[IndexHeaderSize+i/8] |= 1 << (i % 8)
+	}
+
+	for i, page := range pages {
+		if key != nil {
+			cipher, err := newPageCipher(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, encryptedPageSize)
+			err = cipher.seal(buf, PageID(i), page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page = buf
+		}
+		data = append(data, page...)
+	}
+
+	err := ioutil.WriteFile(filename, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{3}, EncryptionKeySize)
+
+	users := NewSchema([]FieldDescription{
+		{Name: "id", Type: &Type{Integer: true}},
+		{Name: "name", Type: &Type{Varchar: 20}},
+	})
+	events := NewSchema([]FieldDescription{
+		{Name: "id", Type: &Type{Integer: true}},
+		{Name: "kind", Type: &Type{Integer: true}},
+	})
+
+	// rows of version 1 pages start right at the beginning of the page
+	rowPage := func(schema *Schema, rows []Row) []byte {
+		page := make([]byte, PageSize)
+		binary.LittleEndian.PutUint16(page, uint16(len(rows)))
+		for i, row := range rows {
+			err := schema.WriteRow(page[2+i*schema.RowSize():], row)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return page
+	}
+
+	var userPages [][]byte
+	for i := 0; i < 3; i++ {
+		var rows []Row
+		for j := 0; j < 100; j++ {
+			rows = append(rows, Row{IntValue(int32(i*100 + j)), VarcharValue("user")})
+		}
+		userPages = append(userPages, rowPage(&users, rows))
+	}
+	writeLegacyTable(t, filepath.Join(dir, "users.bin"), userPages, nil)
+	writeLegacyTable(t, filepath.Join(dir, "secrets.bin"), [][]byte{rowPage(&users, []Row{{IntValue(1), VarcharValue("secret")}})}, key)
+
+	// single row group of a columnar table, segment of each column on its own page
+	var segments [][]byte
+	for i := range events.Fields {
+		values := make([]byte, 0)
+		for j := 0; j < 10; j++ {
+			value := make([]byte, events.Fields[i].Len)
+			events.Fields[i].Write(value, IntValue(int32(j*(i+1))))
+			values = append(values, value...)
+		}
+		page := make([]byte, PageSize)
+		encodeSegment(page, &events.Fields[i], values, 10)
+		segments = append(segments, page)
+	}
+	writeLegacyTable(t, filepath.Join(dir, "events.bin"), segments, nil)
+
+	count := int64(300)
+	metadata, err := json.Marshal(map[string]tableMetadata{
+		"users": {
+			Schema:   users,
+			RowCount: &count,
+			Indexes:  []IndexMetadata{{Name: "users_id", Columns: []string{"id"}, HeaderID: 5}},
+		},
+		"secrets": {Schema: users, TableOptions: TableOptions{Encryption: EncryptionAESGCM}},
+		"events":  {Schema: events, TableOptions: TableOptions{Engine: EngineColumnar}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, MetadataFilename), metadata, 0600)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "users.users_id"+IndexFileExtension), make([]byte, PageSize), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenDatabase(Options{DataDir: dir, EncryptionKey: key})
+	if !errors.Is(err, ErrOldFormat) {
+		t.Fatalf("Expected ErrOldFormat, got %v", err)
+	}
+
+	err = Migrate(dir, nil)
+	if !errors.Is(err, ErrNoEncryptionKey) {
+		t.Fatalf("Expected ErrNoEncryptionKey, got %v", err)
+	}
+
+	// the failed migration is started over
+	err = Migrate(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDatabase(Options{DataDir: dir, EncryptionKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, test := range []struct {
+		query    string
+		nRows    int
+		expected int64
+	}{
+		{"select id from users", 300, 0},
+		{"select id from users where id = 250", 1, 250},
+		{"select id from secrets where name = \"secret\"", 1, 1},
+		{"select kind from events where id = 9", 1, 18},
+	} {
+		rows := mustQuery(t, db, &Session{}, test.query)
+		if len(rows) != test.nRows || rows[0][0].Int != test.expected {
+			t.Fatalf("%v: expected %v rows starting with %v, got %v", test.query, test.nRows, test.expected, rows)
+		}
+	}
+
+	for _, name := range []string{"users.bin.v1", "secrets.bin.v1", "events.bin.v1"} {
+		_, err = os.Stat(filepath.Join(dir, name))
+		if !os.IsNotExist(err) {
+			t.Fatalf("Expected %v to be removed, got %v", name, err)
+		}
+	}
+
+	// migration of the current format does nothing
+	err = db.Close()
+	if err == nil {
+		err = Migrate(dir, key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, VersionFilename), []byte("100\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenDatabase(Options{DataDir: dir, EncryptionKey: key})
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package dumbdb
+
+import "sync"
+
+// Free-space map of a table, i.e. number of free row slots of each page, so that
+// inserts don't have to walk all the pages looking for space.
+//
+// It's built from row counts of the pages on the first use, and updated by inserts
+type FreeSpaceMap struct {
+	m      sync.Mutex
+	loaded bool
+
+	// indexed by PageID
+	free []uint16
+	// all the pages before this one are full
+	firstFree int
+}
+
+// Record the number of free slots on the page
+func (fsm *FreeSpaceMap) Update(id PageID, free int) {
+	fsm.m.Lock()
+	defer fsm.m.Unlock()
+	fsm.update(id, free)
+}
+
+func (fsm *FreeSpaceMap) update(id PageID, free int) {
+	// pages written before rows per page were limited can hold more rows
+	if free < 0 {
+		free = 0
+	}
+
+	idx := int(id)
+	for len(fsm.free) <= idx {
+		fsm.free = append(fsm.free, 0)
+	}
+
+	fsm.free[idx] = uint16(free)
+	if free != 0 && idx < fsm.firstFree {
+		fsm.firstFree = idx
+	}
+}
+
+// Returns the first page with free slots, InvalidPageID if all the pages are full
+func (fsm *FreeSpaceMap) FindFree() PageID {
+	fsm.m.Lock()
+	defer fsm.m.Unlock()
+
+	for fsm.firstFree < len(fsm.free) {
+		if fsm.free[fsm.firstFree] != 0 {
+			return PageID(fsm.firstFree)
+		}
+		fsm.firstFree++
+	}
+	return InvalidPageID
+}
+
+// Number of free slots on the page
+func (fsm *FreeSpaceMap) Free(id PageID) int {
+	fsm.m.Lock()
+	defer fsm.m.Unlock()
+
+	if int(id) >= len(fsm.free) {
+		return 0
+	}
+	return int(fsm.free[id])
+}
+
+// Forget pages with ids >= n, e.g. after the table is truncated
+func (fsm *FreeSpaceMap) Truncate(n int) {
+	fsm.m.Lock()
+	defer fsm.m.Unlock()
+
+	if n < len(fsm.free) {
+		fsm.free = fsm.free[:n]
+	}
+	if fsm.firstFree > n {
+		fsm.firstFree = n
+	}
+}
+
+// Read row counts of all the pages of the table, unless it's already done
+func (fsm *FreeSpaceMap) load(table *Table) error {
+	fsm.m.Lock()
+	defer fsm.m.Unlock()
+
+	if fsm.loaded {
+		return nil
+	}
+
+	capacity := table.rowsPerPage()
+	for id := table.firstPage(); id != InvalidPageID; id = table.nextPage(id) {
+		// the first column of a row group has its number of rows
+		lockedPage, release, err := table.lockRows(id, false, make([]bool, len(table.schema.Fields)))
+		if err != nil {
+			return err
+		}
+
+		fsm.update(id, capacity-lockedPage.NumRows())
+		release()
+	}
+
+	fsm.loaded = true
+	return nil
+}
@@ -0,0 +1,138 @@
+package dumbdb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Function which can be called in expressions
+type ScalarFunction struct {
+	// Check types of the arguments and return type of the result
+	Typecheck func(args []TypeID) (TypeID, error)
+
+	// Compute the result, arguments are already typechecked
+	Eval func(args []Value) Value
+}
+
+var (
+	functionsMu sync.RWMutex
+	functions   = make(map[string]*ScalarFunction)
+)
+
+// Make function available in expressions, name is case-insensitive
+// Registering a function with the same name replaces the previous one
+func RegisterFunction(name string, fn *ScalarFunction) {
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	functions[strings.ToLower(name)] = fn
+}
+
+func LookupFunction(name string) (*ScalarFunction, error) {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+
+	fn, ok := functions[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("no function named %v", name)
+	}
+	return fn, nil
+}
+
+// Typecheck for functions with fixed argument types
+func signature(result TypeID, params ...TypeID) func([]TypeID) (TypeID, error) {
+	return func(args []TypeID) (TypeID, error) {
+		if len(args) != len(params) {
+			return result, fmt.Errorf("expected %v arguments, got %v", len(params), len(args))
+		}
+
+		for i := range args {
+			// int parameters accept bigint arguments as well
+			if args[i] != params[i] && !(params[i] == TypeInt && args[i] == TypeBigint) {
+				return result, fmt.Errorf("%w: argument #%d should be %v, got %v", ErrTypeMismatch, i+1, params[i], args[i])
+			}
+		}
+		return result, nil
+	}
+}
+
+func stringFunction(f func(string) string) *ScalarFunction {
+	return &ScalarFunction{
+		Typecheck: signature(TypeVarchar, TypeVarchar),
+		Eval: func(args []Value) Value {
+			return Value{TypeID: TypeVarchar, Str: f(args[0].StrVal())}
+		},
+	}
+}
+
+// substr(s, start) or substr(s, start, length), start is 1-based
+func substr(args []Value) Value {
+	s := args[0].StrVal()
+	start := int(args[1].Int) - 1
+	if start < 0 {
+		start = 0
+	}
+
+	if start > len(s) {
+		start = len(s)
+	}
+
+	end := len(s)
+	if len(args) == 3 {
+		n := int(args[2].Int)
+		if n < 0 {
+			n = 0
+		}
+
+		if start+n < end {
+			end = start + n
+		}
+	}
+
+	return Value{TypeID: TypeVarchar, Str: s[start:end]}
+}
+
+func init() {
+	RegisterFunction("length", &ScalarFunction{
+		Typecheck: signature(TypeInt, TypeVarchar),
+		Eval: func(args []Value) Value {
+			return Value{TypeID: TypeInt, Int: int64(len(args[0].StrVal()))}
+		},
+	})
+
+	RegisterFunction("upper", stringFunction(strings.ToUpper))
+	RegisterFunction("lower", stringFunction(strings.ToLower))
+	RegisterFunction("trim", stringFunction(strings.TrimSpace))
+
+	RegisterFunction("substr", &ScalarFunction{
+		Typecheck: func(args []TypeID) (TypeID, error) {
+			switch len(args) {
+			case 2:
+				return signature(TypeVarchar, TypeVarchar, TypeInt)(args)
+			case 3:
+				return signature(TypeVarchar, TypeVarchar, TypeInt, TypeInt)(args)
+			default:
+				return TypeVarchar, fmt.Errorf("expected 2 or 3 arguments, got %v", len(args))
+			}
+		},
+		Eval: substr,
+	})
+
+	RegisterFunction("concat", &ScalarFunction{
+		Typecheck: func(args []TypeID) (TypeID, error) {
+			for i, t := range args {
+				if t != TypeVarchar {
+					return TypeVarchar, fmt.Errorf("%w: argument #%d should be varchar, got %v", ErrTypeMismatch, i+1, t)
+				}
+			}
+			return TypeVarchar, nil
+		},
+		Eval: func(args []Value) Value {
+			var b strings.Builder
+			for i := range args {
+				b.WriteString(args[i].StrVal())
+			}
+			return Value{TypeID: TypeVarchar, Str: b.String()}
+		},
+	})
+}
@@ -0,0 +1,213 @@
+package dumbdb
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func FuzzParseQuery(f *testing.F) {
+	seeds := []string{
+		"create table users (id int auto_increment, name varchar(20), age bigint, score float, active bool)",
+		"insert into users (name, age) values (\"Hello\", 1337), (\"World\", 42)",
+		"select distinct u.id as user_id, name from users u where u.age > 20 and name like \"a%\"",
+		"select * from users where id between 1 and 10 or id in (1, 2) and name != \"\"",
+		"select `select` from `Order` where length(trim(name)) > 3 and -price * 2.5 != 1e3",
+		"copy (select id from users) to \"users.json\" format json",
+		"create view adults as select id, name from users where age >= 18",
+		"explain select id from adults",
+		"set statement_timeout = 1000; show tables; drop view adults",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, query string) {
+		// any input is either parsed or rejected with an error
+		for _, statement := range SplitStatements(query) {
+			ParseQuery(statement)
+		}
+		ParseQuery(query)
+		ParseExpression(query)
+	})
+}
+
+func fuzzSchema(varcharLen uint8) Schema {
+	if varcharLen == 0 {
+		varcharLen = 1
+	}
+
+	return NewSchema([]FieldDescription{
+		{Name: "i", Type: &Type{Integer: true}},
+		{Name: "b", Type: &Type{Bigint: true}},
+		{Name: "f", Type: &Type{Float: true}},
+		{Name: "ok", Type: &Type{Bool: true}},
+		{Name: "s", Type: &Type{Varchar: int(varcharLen)}},
+	})
+}
+
+func FuzzWriteRow(f *testing.F) {
+	f.Add(int32(1), int64(-9000000000), 3.14, true, "hello", uint8(20))
+	f.Add(int32(math.MinInt32), int64(math.MaxInt64), math.Inf(-1), false, "", uint8(1))
+	f.Add(int32(0), int64(0), math.NaN(), false, "a\x00b", uint8(255))
+
+	f.Fuzz(func(t *testing.T, i int32, b int64, fl float64, ok bool, s string, varcharLen uint8) {
+		schema := fuzzSchema(varcharLen)
+		if len(s) > int(schema.Fields[4].Len) {
+			s = s[:schema.Fields[4].Len]
+		}
+
+		row := Row{IntValue(i), BigintValue(b), {TypeID: TypeFloat, Float: fl}, BoolValue(ok), VarcharValue(s)}
+		data := make([]byte, schema.RowSize())
+		err := schema.WriteRow(data, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded Row
+		err = schema.ReadRow(data, &decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(decoded) != len(row) {
+			t.Fatalf("decoded %v values, expected %v", len(decoded), len(row))
+		}
+
+		for idx := 0; idx < 4; idx++ {
+			if decoded[idx].TypeID != row[idx].TypeID || decoded[idx].Int != row[idx].Int ||
+				math.Float64bits(decoded[idx].Float) != math.Float64bits(row[idx].Float) {
+				t.Fatalf("column %v: decoded %v, expected %v", idx, decoded[idx], row[idx])
+			}
+		}
+
+		// varchars are padded with zeroes
+		if strings.TrimRight(decoded[4].Str, "\x00") != strings.TrimRight(s, "\x00") {
+			t.Fatalf("decoded %q, expected %q", decoded[4].Str, s)
+		}
+	})
+}
+
+func FuzzReadRow(f *testing.F) {
+	f.Add([]byte{}, uint8(10))
+	f.Add(bytes.Repeat([]byte{0xff}, 30), uint8(8))
+
+	f.Fuzz(func(t *testing.T, data []byte, varcharLen uint8) {
+		schema := fuzzSchema(varcharLen)
+
+		var row Row
+		err := schema.ReadRow(data, &row)
+		if err != nil {
+			if len(data) >= schema.RowSize() {
+				t.Fatalf("failed to read %v bytes: %v", len(data), err)
+			}
+			return
+		}
+
+		// encoding of any decoded row is exactly the bytes it was decoded from
+		encoded := make([]byte, schema.RowSize())
+		err = schema.WriteRow(encoded, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(encoded, data[:schema.RowSize()]) {
+			t.Fatalf("row %v is encoded as %v, decoded from %v", row, encoded, data[:schema.RowSize()])
+		}
+	})
+}
+
+// Page images of a fixed-size leaf, a variable-size branch and a list of rows
+func fuzzPageSeeds() [][]byte {
+	fixed := newPage()
+	leaf := BTreeNode{isLeaf: true, keySize: 4, prev: InvalidPageID, next: 7, heapStart: uint16(PageDataSize), page: fixed}
+	for i := 0; i < 10; i++ {
+		leaf.insertLeaf(IntKey(int32(i)), BTreeValue(i))
+	}
+	leaf.writeHeader()
+
+	variable := newPage()
+	branch := BTreeNode{keySize: VariableKeySize, next: 3, heapStart: uint16(PageDataSize), page: variable}
+	for i, key := range []string{"a", "bb", "ccc"} {
+		branch.insertBranch(BTreeKey(key), PageID(i))
+	}
+	branch.removeAt(1)
+	branch.writeHeader()
+
+	rows := newPage()
+	schema := fuzzSchema(10)
+	rowList := NewRowListPage(rows)
+	for i := 0; i < 5; i++ {
+		rowList.TryInsert(Row{IntValue(int32(i)), BigintValue(int64(i)), {TypeID: TypeFloat}, BoolValue(true), VarcharValue("x")}, &schema)
+	}
+	rowList.Commit()
+
+	return [][]byte{fixed.Data(), variable.Data(), rows.Data()}
+}
+
+func FuzzPage(f *testing.F) {
+	for _, seed := range fuzzPageSeeds() {
+		f.Add(seed, uint8(4))
+		f.Add(seed, uint8(VariableKeySize))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, keySize uint8) {
+		page := newPage()
+		copy(page.Data(), data)
+
+		node := readNode(page)
+		err := node.check(int(keySize))
+		if err != nil && !errors.Is(err, ErrCorruptedPage) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err == nil {
+			for idx := 0; idx < node.len(); idx++ {
+				node.key(idx)
+				node.value(idx)
+			}
+
+			key := bytes.Repeat([]byte{0x42}, int(keySize))
+			if node.isVariable() {
+				key = BTreeKey("key")
+			}
+
+			if node.isLeaf {
+				node.searchLeaf(key)
+				if node.hasRoom(len(key)) {
+					node.insertLeaf(key, 1)
+				}
+			} else {
+				node.searchBranch(key)
+				if !node.isFull() {
+					node.insertBranch(key, 1)
+				}
+			}
+
+			if node.len() != 0 {
+				node.removeAt(0)
+			}
+		}
+
+		schema := fuzzSchema(10)
+		page = newPage()
+		copy(page.Data(), data)
+
+		rows := NewRowListPage(page)
+		err = rows.Check(&schema)
+		if err != nil {
+			if !errors.Is(err, ErrCorruptedPage) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return
+		}
+
+		for idx := 0; idx < rows.NumRows(); idx++ {
+			if rows.ReadRow(idx, &schema) == nil {
+				t.Fatalf("failed to read row %v of %v", idx, rows.NumRows())
+			}
+		}
+	})
+}
@@ -1,12 +1,16 @@
 module dumbdb
 
-go 1.16
+go 1.18
 
 require (
 	github.com/alecthomas/participle/v2 v2.0.0-alpha7
-	github.com/chzyer/logex v1.2.0 // indirect
 	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
-	github.com/chzyer/test v0.0.0-20210722231415-061457976a23 // indirect
 	github.com/olekukonko/tablewriter v0.0.5
+)
+
+require (
+	github.com/chzyer/logex v1.2.0 // indirect
+	github.com/chzyer/test v0.0.0-20210722231415-061457976a23 // indirect
+	github.com/mattn/go-runewidth v0.0.9 // indirect
 	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
 )
@@ -0,0 +1,329 @@
+package dumbdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ErrAccessDenied = errors.New("access denied")
+
+// Privileges granted to users by table, see Grant
+const GrantsFilename string = "grants.json"
+
+// Privileges granted on a table (or view), a bit per privilege
+type Privileges uint8
+
+const (
+	PrivSelect Privileges = 1 << iota
+	PrivInsert
+	PrivUpdate
+	PrivDelete
+	// create, drop, truncate, vacuum, analyze the table, manage its indexes
+	PrivDDL
+
+	PrivAll = PrivSelect | PrivInsert | PrivUpdate | PrivDelete | PrivDDL
+)
+
+// Names of the privileges in grant and revoke, in the order of their bits
+var privilegeNames = []string{"select", "insert", "update", "delete", "ddl"}
+
+// Table name granting privileges on all the tables of the database
+const AllTables = "*"
+
+func parsePrivileges(names []string) (Privileges, error) {
+	var privs Privileges
+	for _, name := range names {
+		if name == "all" {
+			privs |= PrivAll
+			continue
+		}
+
+		found := false
+		for i, privName := range privilegeNames {
+			if privName == name {
+				privs |= 1 << i
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown privilege %v", name)
+		}
+	}
+	return privs, nil
+}
+
+func (privs Privileges) String() string {
+	var names []string
+	for i, name := range privilegeNames {
+		if privs&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func (catalog *Catalog) loadGrants() error {
+	data, err := ioutil.ReadFile(filepath.Join(catalog.dataDir, GrantsFilename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// user -> table -> names of privileges
+	grants := make(map[string]map[string][]string)
+	err = json.Unmarshal(data, &grants)
+	if err != nil {
+		return err
+	}
+
+	for user, tables := range grants {
+		catalog.grants[user] = make(map[string]Privileges)
+		for table, names := range tables {
+			privs, err := parsePrivileges(names)
+			if err != nil {
+				return fmt.Errorf("grants of %v on %v: %w", user, table, err)
+			}
+			catalog.grants[user][table] = privs
+		}
+	}
+	return nil
+}
+
+// Encoded privileges of all the users
+func (catalog *Catalog) grantDefinitions() ([]byte, error) {
+	grants := make(map[string]map[string][]string)
+	for user, tables := range catalog.grants {
+		grants[user] = make(map[string][]string)
+		for table, privs := range tables {
+			grants[user][table] = strings.Split(privs.String(), ", ")
+		}
+	}
+	return json.Marshal(grants)
+}
+
+// catalog.m should be locked
+func (catalog *Catalog) saveGrants() error {
+	data, err := catalog.grantDefinitions()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(catalog.dataDir, GrantsFilename), data)
+}
+
+// Privileges of the user on the table, including the ones granted on all tables
+// catalog.m should be at least read-locked
+func (catalog *Catalog) privileges(user string, table string) Privileges {
+	return catalog.grants[user][table] | catalog.grants[user][AllTables]
+}
+
+// Set privileges of the user on the table, zero removes them
+// catalog.m should be locked
+func (catalog *Catalog) putGrant(user string, table string, privs Privileges) {
+	if privs == 0 {
+		delete(catalog.grants[user], table)
+		if len(catalog.grants[user]) == 0 {
+			delete(catalog.grants, user)
+		}
+		return
+	}
+
+	if catalog.grants[user] == nil {
+		catalog.grants[user] = make(map[string]Privileges)
+	}
+	catalog.grants[user][table] = privs
+}
+
+// Add privs of the user on the table to the granted ones, or remove them if revoke is set
+// catalog.m should be locked
+func (catalog *Catalog) changeGrants(user string, table string, privs Privileges, revoke bool) error {
+	old := catalog.grants[user][table]
+	if revoke {
+		catalog.putGrant(user, table, old&^privs)
+	} else {
+		catalog.putGrant(user, table, old|privs)
+	}
+
+	err := catalog.saveGrants()
+	if err != nil {
+		catalog.putGrant(user, table, old)
+	}
+	return err
+}
+
+// Remove privileges on the dropped table or view, so that they aren't
+// inherited by a new one with the same name
+// catalog.m should be locked
+func (catalog *Catalog) dropGrants(table string) error {
+	changed := false
+	for user, tables := range catalog.grants {
+		if _, ok := tables[table]; ok {
+			catalog.putGrant(user, table, 0)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	return catalog.saveGrants()
+}
+
+func (catalog *Catalog) doGrant(grant *Grant) (*Result, error) {
+	privs, err := parsePrivileges(grant.Privileges)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	if grant.Table != AllTables {
+		_, isTable := catalog.tables[grant.Table]
+		_, isView := catalog.views[grant.Table]
+		if !isTable && !isView {
+			return nil, ErrTableDoesNotExist
+		}
+	}
+
+	return nil, catalog.changeGrants(grant.User, grant.Table, privs, false)
+}
+
+func (catalog *Catalog) doRevoke(revoke *Revoke) (*Result, error) {
+	privs, err := parsePrivileges(revoke.Privileges)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	return nil, catalog.changeGrants(revoke.User, revoke.Table, privs, true)
+}
+
+func (catalog *Catalog) doShowGrants() (*Result, error) {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	var schema Schema
+	schema.addField(Field{Name: "user", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "table", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "privileges", TypeID: TypeVarchar, Len: 255})
+
+	var rows []Row
+	for user, tables := range catalog.grants {
+		for table, privs := range tables {
+			rows = append(rows, Row{
+				{TypeID: TypeVarchar, Str: user},
+				{TypeID: TypeVarchar, Str: table},
+				{TypeID: TypeVarchar, Str: privs.String()},
+			})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i][0].Str != rows[j][0].Str {
+			return rows[i][0].Str < rows[j][0].Str
+		}
+		return rows[i][1].Str < rows[j][1].Str
+	})
+
+	return &Result{
+		Schema: schema,
+		Rows:   StaticRows(rows),
+	}, nil
+}
+
+// Privilege the query requires on the table, zero if it doesn't require any
+func requiredPrivilege(query *Query) (Privileges, string) {
+	switch {
+	case query.Select != nil:
+		return PrivSelect, query.Select.Table
+	case query.Explain != nil:
+		return PrivSelect, query.Explain.Select.Table
+	case query.CopyTo != nil:
+		return PrivSelect, query.CopyTo.Select.Table
+	case query.Insert != nil:
+		return PrivInsert, query.Insert.Table
+	case query.CopyFrom != nil:
+		return PrivInsert, query.CopyFrom.Table
+	case query.Truncate != nil:
+		return PrivDelete, query.Truncate.Table
+	case query.Create != nil:
+		return PrivDDL, query.Create.Table
+	case query.Drop != nil:
+		return PrivDDL, query.Drop.Table
+	case query.Vacuum != nil:
+		return PrivDDL, query.Vacuum.Table
+	case query.Alter != nil:
+		return PrivDDL, query.Alter.Table
+	case query.Analyze != nil:
+		return PrivDDL, query.Analyze.Table
+	case query.CreateIndex != nil:
+		return PrivDDL, query.CreateIndex.Table
+	case query.CreateView != nil:
+		return PrivDDL, query.CreateView.Name
+	case query.DropView != nil:
+		return PrivDDL, query.DropView.Name
+	case query.CreateTrigger != nil:
+		return PrivDDL, query.CreateTrigger.Table
+	default:
+		return 0, ""
+	}
+}
+
+// Check that the user was granted the privileges required by the query.
+// Selects from a view require privileges only on the view, so views can
+// expose a part of a table
+func (catalog *Catalog) checkAccess(user string, query *Query) error {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	if query.Grant != nil || query.Revoke != nil || query.ShowGrants != nil {
+		return fmt.Errorf("%w: %v can't manage privileges", ErrAccessDenied, user)
+	}
+
+	// procedures are shared by all the users of the database
+	if query.CreateProcedure != nil || query.DropProcedure != nil {
+		return fmt.Errorf("%w: %v can't manage procedures", ErrAccessDenied, user)
+	}
+
+	priv, table := requiredPrivilege(query)
+	if query.DropIndex != nil {
+		priv, table = PrivDDL, catalog.indexTableName(query.DropIndex.Name)
+	}
+	if query.DropTrigger != nil {
+		priv, table = PrivDDL, catalog.triggerTableName(query.DropTrigger.Name)
+	}
+
+	if priv != 0 && catalog.privileges(user, table)&priv == 0 {
+		return fmt.Errorf("%w: %v has no %v privilege on %v", ErrAccessDenied, user, priv, table)
+	}
+
+	// view definition is executed with the privileges of the view,
+	// so its creator has to be able to select from its source
+	if query.CreateView != nil {
+		source := query.CreateView.Select.Table
+		if catalog.privileges(user, source)&PrivSelect == 0 {
+			return fmt.Errorf("%w: %v has no select privilege on %v", ErrAccessDenied, user, source)
+		}
+	}
+
+	// the same goes for rows inserted by the trigger
+	if query.CreateTrigger != nil && query.CreateTrigger.Action.Insert != nil {
+		target := query.CreateTrigger.Action.Insert.Table
+		if catalog.privileges(user, target)&PrivInsert == 0 {
+			return fmt.Errorf("%w: %v has no insert privilege on %v", ErrAccessDenied, user, target)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package dumbdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGrants(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin, alice := &Session{}, &Session{User: "alice"}
+	for _, q := range []string{
+		"create table users (id int, name varchar(20))",
+		"insert into users values (1, \"alice\"), (2, \"bob\")",
+		"create view first as select name from users where id = 1",
+	} {
+		mustExec(t, db, admin, q)
+	}
+
+	tests := []struct {
+		sess  *Session
+		query string
+		err   error
+	}{
+		{alice, "select * from users", ErrAccessDenied},
+		{alice, "grant select on users to alice", ErrAccessDenied},
+		{admin, "grant select on missing to alice", ErrTableDoesNotExist},
+		{admin, "grant select, ddl on users to alice", nil},
+		{admin, "grant select on first to alice", nil},
+		{alice, "select * from users", nil},
+		{alice, "select * from first", nil},
+		{alice, "explain select * from users", nil},
+		{alice, "insert into users values (3, \"carol\")", ErrAccessDenied},
+		{alice, "truncate table users", ErrAccessDenied},
+		{alice, "create index users_id on users (id)", nil},
+		{alice, "drop index users_id", nil},
+		{alice, "create table t (id int)", ErrAccessDenied},
+		{alice, "backup to \"backup.tar\"", ErrAccessDenied},
+		{alice, "show processlist", ErrAccessDenied},
+		{alice, "show tables", nil},
+		{admin, "revoke select on users from alice", nil},
+		{alice, "select * from users", ErrAccessDenied},
+		// the view is selected with its own privileges
+		{alice, "select * from first", nil},
+		{alice, "create view second as select * from users", ErrAccessDenied},
+		{admin, "grant all on * to alice", nil},
+		{alice, "create table t (id int)", nil},
+		{alice, "insert into t values (1)", nil},
+		// files on the server are accessible only to admins
+		{alice, "copy (select * from t) to \"t.csv\"", ErrAccessDenied},
+		{alice, "copy t from \"t.csv\"", ErrAccessDenied},
+		{alice, "drop database default", ErrAccessDenied},
+	}
+
+	for _, test := range tests {
+		_, err := queryRows(t, db, test.sess, test.query)
+		if !errors.Is(err, test.err) {
+			t.Fatalf("Expected %v from %v, got %v", test.err, test.query, err)
+		}
+	}
+
+	grants := mustQuery(t, db, admin, "show grants")
+
+	expected := [][]string{{"alice", "*", "select, insert, update, delete, ddl"}, {"alice", "first", "select"}, {"alice", "users", "ddl"}}
+	if len(grants) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, grants)
+	}
+	for i, row := range grants {
+		if row[0].Str != expected[i][0] || row[1].Str != expected[i][1] || row[2].Str != expected[i][2] {
+			t.Fatalf("Expected %v, got %v", expected[i], row)
+		}
+	}
+
+	// privileges are dropped with the table and survive restart
+	mustExec(t, db, admin, "drop view first")
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	grants, err = queryRows(t, db, admin, "show grants")
+	if err != nil || len(grants) != 2 || grants[1][1].Str != "users" {
+		t.Fatalf("Expected grants on * and users, got %v (%v)", grants, err)
+	}
+}
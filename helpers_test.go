@@ -0,0 +1,115 @@
+package dumbdb
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// Parse and execute the query in the session, which may be nil. Only a query
+// which fails to parse fails the test
+func execQuery(t *testing.T, db *Database, sess *Session, q string) (*Result, error) {
+	t.Helper()
+
+	query, err := ParseQuery(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db.Execute(context.Background(), sess, query)
+}
+
+// Same as execQuery(), fails the test if the query fails
+func mustExec(t *testing.T, db *Database, sess *Session, q string) *Result {
+	t.Helper()
+
+	result, err := execQuery(t, db, sess, q)
+	if err != nil {
+		t.Fatalf("Failed to execute %v: %v", q, err)
+	}
+	return result
+}
+
+// Same as execQuery(), returns all the rows of the result, nil for queries
+// without rows
+func queryRows(t *testing.T, db *Database, sess *Session, q string) ([]Row, error) {
+	t.Helper()
+
+	result, err := execQuery(t, db, sess, q)
+	if err != nil || result == nil || result.Rows == nil {
+		return nil, err
+	}
+	return result.Rows.All()
+}
+
+// Same as queryRows(), fails the test if the query fails
+func mustQuery(t *testing.T, db *Database, sess *Session, q string) []Row {
+	t.Helper()
+
+	rows, err := queryRows(t, db, sess, q)
+	if err != nil {
+		t.Fatalf("Failed to execute %v: %v", q, err)
+	}
+	return rows
+}
+
+// Parse and execute the query in the catalog. Only a query which fails to
+// parse fails the test
+func execCatalog(t *testing.T, catalog *Catalog, q string) (*Result, error) {
+	t.Helper()
+
+	query, err := ParseQuery(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return catalog.Execute(context.Background(), query)
+}
+
+// Same as execCatalog(), fails the test if the query fails
+func mustExecCatalog(t *testing.T, catalog *Catalog, q string) *Result {
+	t.Helper()
+
+	result, err := execCatalog(t, catalog, q)
+	if err != nil {
+		t.Fatalf("Failed to execute %v: %v", q, err)
+	}
+	return result
+}
+
+// Same as mustExecCatalog(), returns all the rows of the result, nil for
+// queries without rows
+func mustQueryCatalog(t *testing.T, catalog *Catalog, q string) []Row {
+	t.Helper()
+
+	result := mustExecCatalog(t, catalog, q)
+	if result == nil || result.Rows == nil {
+		return nil
+	}
+
+	rows, err := result.Rows.All()
+	if err != nil {
+		t.Fatalf("%v: %v", q, err)
+	}
+	return rows
+}
+
+// Values of the column of the rows
+func columnStrings(rows []Row, idx int) []string {
+	values := make([]string, 0, len(rows))
+	for _, row := range rows {
+		values = append(values, row[idx].String())
+	}
+	return values
+}
+
+// Rows with their values formatted by format and joined by sep
+func rowStrings(rows []Row, format func(*Value) string, sep string) []string {
+	values := make([]string, 0, len(rows))
+	for _, row := range rows {
+		fields := make([]string, 0, len(row))
+		for i := range row {
+			fields = append(fields, format(&row[i]))
+		}
+		values = append(values, strings.Join(fields, sep))
+	}
+	return values
+}
@@ -0,0 +1,997 @@
+package dumbdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrIndexAlreadyExist = errors.New("index with such name already exist")
+	ErrNoSuchIndex       = errors.New("no index with such name")
+	ErrIndexDropped      = errors.New("index was dropped during the query")
+)
+
+// Extension of index files, index idx of table t is stored in t.idx.idx
+const IndexFileExtension = ".idx"
+
+// Number of pages of an index cached in memory
+const IndexCacheSize = 1024
+
+// Index scan is chosen over the full scan only if statistics (when collected)
+// estimate that it finds at most this fraction of the rows, as fetching rows
+// one by one is slower than reading whole pages
+const IndexScanThreshold = 0.2
+
+// Metadata of an index, persisted in catalog metadata of its table
+type IndexMetadata struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+
+	// id of the tree header in the index file, see BTree.HeaderID(). Index pages
+	// are written lazily, so like row counts it's valid only on clean shutdown
+	HeaderID PageID `json:"header_id"`
+}
+
+// Secondary index of a table: non-unique B+ tree mapping keys encoded from
+// the values of the columns (see EncodeKey()) to ids of the rows. Keys of
+// a composite index compare column by column, so the index finds rows by
+// values of any prefix of its columns, optionally followed by a range of
+// values of the next column.
+//
+// Index is redundant, so it's rebuilt from the rows of the table whenever its
+// file may be behind them, i.e. after a crash or Truncate()
+type Index struct {
+	name    string
+	columns []string
+	path    string
+
+	fields    []int   // indexes of the columns in the table schema
+	keyFields []Field // the columns
+
+	storage TableStorage
+	pager   *Pager
+
+	// protects tree, which is replaced when the index is rebuilt, and is nil
+	// once the index is closed
+	m    sync.RWMutex
+	tree *BTree
+}
+
+// Returns name of the table having index with the given name, empty if there is no such index
+// catalog.m should be at least read-locked
+func (catalog *Catalog) indexTableName(name string) string {
+	for tableName, table := range catalog.tables {
+		if table.Index(name) != nil {
+			return tableName
+		}
+	}
+	return ""
+}
+
+// Path of the file of the index on the table stored at tablePath, see OpenTable()
+func indexPath(tablePath string, name string) string {
+	return tablePath + "." + name + IndexFileExtension
+}
+
+// Check the columns of the index on the table with the schema
+func newIndex(schema *Schema, name string, columns []string, path string) (*Index, error) {
+	if len(columns) == 0 {
+		return nil, errors.New("index has no columns")
+	}
+
+	index := &Index{
+		name:    name,
+		columns: columns,
+		path:    path,
+	}
+
+	// longest key, not counting escaped zero bytes of strings
+	keyLen := 0
+	for i, name := range columns {
+		idx, field := schema.GetField(name)
+		if idx == -1 {
+			return nil, fmt.Errorf("no column named %v in the schema", name)
+		}
+
+		for _, prev := range columns[:i] {
+			if prev == name {
+				return nil, fmt.Errorf("column %v is repeated in the index", name)
+			}
+		}
+
+		keyLen += int(field.Len)
+		if field.TypeID == TypeVarchar {
+			keyLen += 2
+		}
+
+		index.fields = append(index.fields, idx)
+		index.keyFields = append(index.keyFields, field)
+	}
+
+	if keyLen > MaxKeySize {
+		return nil, fmt.Errorf("%w: keys of the index take up to %v bytes, %v is max", ErrKeyTooLarge, keyLen, MaxKeySize)
+	}
+	return index, nil
+}
+
+// Open the index file, create is true for a new or rebuilt index, the file is
+// truncated then. Indexes of memory tables are kept in memory, and so are rebuilt
+// indexes of read-only tables, which can't write their files
+func (index *Index) openStorage(opts TableOptions, create bool) error {
+	var err error
+	switch {
+	case opts.Engine == EngineMemory || opts.ReadOnly && create:
+		index.storage = NewMemoryStorage()
+	case opts.ReadOnly:
+		index.storage, err = os.OpenFile(index.path, os.O_RDONLY, 0)
+	case create:
+		index.storage, err = os.OpenFile(index.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	default:
+		index.storage, err = os.OpenFile(index.path, os.O_RDWR, 0600)
+	}
+	if err != nil {
+		return err
+	}
+
+	index.pager, err = opts.newPager(IndexCacheSize, index.storage)
+	if err != nil {
+		index.storage.Close()
+		return err
+	}
+	return nil
+}
+
+func (index *Index) Name() string {
+	return index.name
+}
+
+func (index *Index) Columns() []string {
+	return index.columns
+}
+
+func (index *Index) metadata() IndexMetadata {
+	index.m.RLock()
+	defer index.m.RUnlock()
+
+	meta := IndexMetadata{
+		Name:     index.name,
+		Columns:  index.columns,
+		HeaderID: InvalidPageID,
+	}
+	if index.tree != nil {
+		meta.HeaderID = index.tree.HeaderID()
+	}
+	return meta
+}
+
+// Key of the row, strings are encoded as they are stored, i.e. without trailing zero bytes
+func (index *Index) key(row Row) (BTreeKey, error) {
+	var key BTreeKey
+	for i, idx := range index.fields {
+		val := row[idx]
+		if val.TypeID == TypeVarchar {
+			val.Str = strings.TrimRight(val.StrVal(), "\x00")
+		}
+		key = AppendKey(key, &index.keyFields[i], &val)
+	}
+
+	if len(key) > MaxKeySize {
+		return nil, fmt.Errorf("%w: key of index %v takes %v bytes, %v is max", ErrKeyTooLarge, index.name, len(key), MaxKeySize)
+	}
+	return key, nil
+}
+
+func (index *Index) insert(key BTreeKey, id RowID) error {
+	index.m.RLock()
+	defer index.m.RUnlock()
+
+	if index.tree == nil {
+		return ErrIndexDropped
+	}
+	return index.tree.Insert(key, BTreeValue(id))
+}
+
+// Entry of the index being built
+type indexEntry struct {
+	key BTreeKey
+	id  RowID
+}
+
+// Replace the tree with the one built from the rows of the table
+// NOTE: caller has to make sure that the table is not modified concurrently
+func (index *Index) rebuild(table *Table) error {
+	runs, err := index.sortedRuns(table)
+	if err != nil {
+		return err
+	}
+
+	index.m.Lock()
+	defer index.m.Unlock()
+
+	if index.tree != nil {
+		// pages of the old tree are dropped without syncing
+		index.tree.rootPage.Unpin()
+		index.tree = nil
+	}
+
+	err = index.pager.Truncate()
+	if err != nil {
+		return err
+	}
+
+	tree, err := BuildBTree(index.pager, KeySize(index.keyFields), false, func(emit func(BTreeKey, BTreeValue) error) error {
+		return mergeIndexRuns(runs, emit)
+	})
+	if err != nil {
+		return err
+	}
+
+	index.tree = tree
+	return nil
+}
+
+// Flush the tree and close the file, the index can't be used afterwards
+func (index *Index) close() error {
+	index.m.Lock()
+	defer index.m.Unlock()
+
+	if index.tree != nil {
+		err := index.tree.Close()
+		if err != nil {
+			return err
+		}
+		index.tree = nil
+	}
+	return index.storage.Close()
+}
+
+// Create index on the columns of the table, and build it from the rows
+// NOTE: caller has to make sure that the table is not modified concurrently
+func (table *Table) CreateIndex(path string, name string, columns []string) (*Index, error) {
+	if table.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	if table.Index(name) != nil {
+		return nil, ErrIndexAlreadyExist
+	}
+
+	index, err := newIndex(&table.schema, name, columns, path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = index.openStorage(table.options, true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = index.rebuild(table)
+	if err == nil {
+		// metadata refers to the index once it's created, so it has to be durable
+		err = index.pager.SyncAll()
+	}
+
+	if err != nil {
+		index.close()
+		if table.options.Engine != EngineMemory {
+			os.Remove(path)
+		}
+		return nil, err
+	}
+
+	table.indexes = append(table.indexes, index)
+	return index, nil
+}
+
+// Open existing index of the table. If rebuild is true, i.e. the file may be
+// behind the rows, or if the tree can't be read, the index is rebuilt
+// NOTE: caller has to make sure that the table is not modified concurrently
+func (table *Table) OpenIndex(path string, meta IndexMetadata, rebuild bool) (*Index, error) {
+	index, err := newIndex(&table.schema, meta.Name, meta.Columns, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !rebuild {
+		err = index.openStorage(table.options, false)
+		if err == nil {
+			index.tree, err = ReadBTree(meta.HeaderID, index.pager)
+			if err != nil {
+				index.storage.Close()
+			}
+		}
+		rebuild = err != nil
+	}
+
+	if rebuild {
+		err = index.openStorage(table.options, true)
+		if err != nil {
+			return nil, err
+		}
+
+		err = index.rebuild(table)
+		if err != nil {
+			index.close()
+			return nil, err
+		}
+	}
+
+	table.indexes = append(table.indexes, index)
+	return index, nil
+}
+
+// Returns index of the table with the given name, nil if there is no such index
+func (table *Table) Index(name string) *Index {
+	for _, index := range table.indexes {
+		if index.name == name {
+			return index
+		}
+	}
+	return nil
+}
+
+// Remove the index from the table and close it, its file is left in place
+// NOTE: caller has to make sure that the table is not modified concurrently
+func (table *Table) DropIndex(name string) (*Index, error) {
+	for i, index := range table.indexes {
+		if index.name != name {
+			continue
+		}
+
+		table.indexes = append(table.indexes[:i:i], table.indexes[i+1:]...)
+		return index, index.close()
+	}
+	return nil, ErrNoSuchIndex
+}
+
+func (table *Table) indexMetadata() []IndexMetadata {
+	var metadata []IndexMetadata
+	for _, index := range table.indexes {
+		metadata = append(metadata, index.metadata())
+	}
+	return metadata
+}
+
+// Keys of the rows for each index, so that rows with invalid keys are rejected
+// before any of them is inserted. Indexed by row, then by index
+func (table *Table) indexKeys(rows []Row) ([][]BTreeKey, error) {
+	if len(table.indexes) == 0 {
+		return nil, nil
+	}
+
+	keys := make([][]BTreeKey, 0, len(rows))
+	for i, row := range rows {
+		rowKeys, err := table.rowKeys(row)
+		if err != nil {
+			return nil, fmt.Errorf("row #%d %w", i, err)
+		}
+		keys = append(keys, rowKeys)
+	}
+	return keys, nil
+}
+
+// Keys of the row in each index of the table, nil if the table has no indexes
+func (table *Table) rowKeys(row Row) ([]BTreeKey, error) {
+	if len(table.indexes) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]BTreeKey, 0, len(table.indexes))
+	for _, index := range table.indexes {
+		key, err := index.key(row)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Add entries of the rows inserted into the page starting at index first
+func (table *Table) indexRows(id PageID, first int, keys [][]BTreeKey) error {
+	for i, rowKeys := range keys {
+		rowID := NewRowID(id, uint8(first+i))
+		for j, index := range table.indexes {
+			err := index.insert(rowKeys[j], rowID)
+			if err != nil {
+				return fmt.Errorf("index %v: %w", index.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Rebuild all the indexes from the rows, e.g. once the rows are moved
+// NOTE: caller has to make sure that the table is not modified concurrently
+func (table *Table) rebuildIndexes() error {
+	for _, index := range table.indexes {
+		err := index.rebuild(table)
+		if err != nil {
+			return fmt.Errorf("index %v: %w", index.name, err)
+		}
+	}
+	return nil
+}
+
+// Range of the index scanned by a select: entries with the prefix encoded
+// from values of the leading columns, and optionally bounds of the next column
+type indexScan struct {
+	index *Index
+	// predicates defining the range, matching rows are a subset of the range
+	predicates []ColumnPredicate
+
+	prefix BTreeKey
+	// the first key to visit, at least the prefix
+	low BTreeKey
+	// entries with keys above it and not starting with it are past the range, nil if unbounded
+	high BTreeKey
+
+	// rows are fetched in the order of the keys, the first chunk of ids has at
+	// least chunk of them, see fetchOrdered(). Otherwise rows go in the order of a full scan
+	ordered bool
+	chunk   int
+}
+
+// Returns the value converted to the type of the field for encoding into a key,
+// false if the field can't be compared with the value by comparing their keys
+func keyValue(field *Field, val Value) (Value, bool) {
+	switch field.TypeID {
+	case TypeInt, TypeBigint:
+		if val.TypeID != TypeInt && val.TypeID != TypeBigint {
+			return Value{}, false
+		}
+		if field.TypeID == TypeInt && (val.Int < math.MinInt32 || val.Int > math.MaxInt32) {
+			return Value{}, false
+		}
+		return Value{TypeID: field.TypeID, Int: val.Int}, true
+	case TypeFloat:
+		if !val.TypeID.IsNumeric() {
+			return Value{}, false
+		}
+		return FloatValue(val.ToFloat()), true
+	default:
+		return val, val.TypeID == field.TypeID
+	}
+}
+
+// Plan the scan of the index range containing rows matching the predicates:
+// equalities of the leading columns and bounds of the column after them.
+// Returns nil if none of the predicates constrain the first column
+func planIndexScan(index *Index, predicates []ColumnPredicate) *indexScan {
+	scan := &indexScan{index: index}
+	for i := range index.keyFields {
+		field := &index.keyFields[i]
+
+		var low, high BTreeKey
+		var bounds []ColumnPredicate
+		for _, p := range predicates {
+			if p.Field.Name != field.Name {
+				continue
+			}
+
+			val, ok := keyValue(field, p.Value)
+			if !ok {
+				continue
+			}
+
+			// values of the column which are equal to the bound have keys starting with
+			// the bound, they are visited even for strict inequalities and are filtered out
+			key := AppendKey(append(BTreeKey(nil), scan.prefix...), field, &val)
+			switch p.Op {
+			case OpEq:
+				low, high = key, key
+			case OpGreater, OpGreaterOrEq:
+				if low != nil && bytes.Compare(key, low) <= 0 {
+					continue
+				}
+				low = key
+			case OpLess, OpLessOrEq:
+				if high != nil && bytes.Compare(key, high) >= 0 {
+					continue
+				}
+				high = key
+			default:
+				continue
+			}
+
+			if p.Op == OpEq {
+				bounds = []ColumnPredicate{p}
+				break
+			}
+			bounds = append(bounds, p)
+		}
+
+		if len(bounds) == 0 {
+			break
+		}
+		scan.predicates = append(scan.predicates, bounds...)
+
+		if len(bounds) == 1 && bounds[0].Op == OpEq {
+			scan.prefix = low
+			continue
+		}
+
+		scan.low = low
+		scan.high = high
+		break
+	}
+
+	if len(scan.predicates) == 0 {
+		return nil
+	}
+
+	if scan.low == nil {
+		scan.low = scan.prefix
+	}
+	return scan
+}
+
+// Number of leading columns of the index fixed by equalities, bounded next column counts as a half
+func (scan *indexScan) score() float64 {
+	score := 0.0
+	for _, p := range scan.predicates {
+		if p.Op == OpEq {
+			score++
+		} else {
+			score = math.Floor(score) + 0.5
+		}
+	}
+	return score
+}
+
+// Returns true if the key is in the range, keys are visited from scan.low
+func (scan *indexScan) contains(key BTreeKey) bool {
+	if !bytes.HasPrefix(key, scan.prefix) {
+		return false
+	}
+	return scan.high == nil || bytes.Compare(key, scan.high) <= 0 || bytes.HasPrefix(key, scan.high)
+}
+
+func (scan *indexScan) String() string {
+	conditions := make([]string, 0, len(scan.predicates))
+	for i := range scan.predicates {
+		conditions = append(conditions, scan.predicates[i].String())
+	}
+	if len(conditions) == 0 {
+		// the whole index is scanned
+		return scan.index.name
+	}
+	return fmt.Sprintf("%v (%v)", scan.index.name, strings.Join(conditions, ", "))
+}
+
+// Ids of the rows in the range, ordered
+func (scan *indexScan) rowIDs() ([]RowID, error) {
+	index := scan.index
+	index.m.RLock()
+	defer index.m.RUnlock()
+
+	if index.tree == nil {
+		return nil, ErrIndexDropped
+	}
+
+	// ids are collected before the rows are fetched, so that the leaves aren't
+	// latched while the rows are consumed
+	var ids []RowID
+	cursor := index.tree.Search(scan.low)
+	for ok := cursor.Valid(); ok; ok = cursor.Forward() {
+		key, value := cursor.Get()
+		if !scan.contains(key) {
+			break
+		}
+		ids = append(ids, RowID(value))
+	}
+
+	err := cursor.Err()
+	cursor.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids, nil
+}
+
+// Fetch rows found by the index scan which pass the filter, rows go in the order
+// of a full scan, unless the scan is ordered. Pages of the fetched rows are counted in progress, which can be nil
+func (scan *indexScan) rows(ctx context.Context, table *Table, filter func(Row) (bool, error), project func(Row) Row, progress *ScanProgress) *Rows {
+	// scan is registered synchronously, while the caller still holds the catalog lock
+	scanCtx, end, err := table.beginScan(ctx)
+	if err != nil {
+		return NewRows(ctx, func(ctx context.Context, emit func(Row) error) error {
+			return err
+		})
+	}
+
+	return NewRows(scanCtx, func(ctx context.Context, emit func(Row) error) error {
+		return end(scan.fetch(ctx, table, filter, project, progress, emit))
+	})
+}
+
+func (scan *indexScan) fetch(ctx context.Context, table *Table, filter func(Row) (bool, error), project func(Row) Row, progress *ScanProgress, emit func(Row) error) error {
+	if scan.ordered {
+		return scan.fetchOrdered(ctx, table, filter, project, progress, emit)
+	}
+
+	ids, err := scan.rowIDs()
+	if err != nil {
+		return err
+	}
+
+	pages := int64(0)
+	for i := range ids {
+		if i == 0 || ids[i].PageID() != ids[i-1].PageID() {
+			pages++
+		}
+	}
+	progress.begin(pages)
+
+	for i, id := range ids {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		row, err := table.FetchRow(id)
+		if err != nil {
+			return err
+		}
+
+		ok, err := filter(row)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			err = emit(project(row))
+			if err != nil {
+				return err
+			}
+		}
+
+		if i+1 == len(ids) || ids[i+1].PageID() != id.PageID() {
+			progress.pageScanned()
+		}
+	}
+	return nil
+}
+
+// Ids of the next entries in the range in the order of their keys, at least n of
+// them unless the range is over. Entries with equal keys go together, so the next
+// call continues after the returned key, which is nil once the range is over
+func (scan *indexScan) nextIDs(after BTreeKey, n int) ([]RowID, BTreeKey, error) {
+	index := scan.index
+	index.m.RLock()
+	defer index.m.RUnlock()
+
+	if index.tree == nil {
+		return nil, nil, ErrIndexDropped
+	}
+
+	from := scan.low
+	if after != nil {
+		from = after
+	}
+
+	var ids []RowID
+	var last BTreeKey
+	more := false
+	cursor := index.tree.Search(from)
+	for ok := cursor.Valid(); ok; ok = cursor.Forward() {
+		key, value := cursor.Get()
+		if after != nil && bytes.Equal(key, after) {
+			continue
+		}
+		if !scan.contains(key) {
+			break
+		}
+		if len(ids) >= n && !bytes.Equal(key, last) {
+			more = true
+			break
+		}
+
+		ids = append(ids, RowID(value))
+		last = append(last[:0], key...)
+	}
+
+	err := cursor.Err()
+	cursor.Close()
+	if err != nil || !more {
+		return ids, nil, err
+	}
+	return ids, last, nil
+}
+
+// Max number of ids read from the index at once by the ordered scan
+const maxOrderedChunk = 4096
+
+// Fetch rows found by the index scan in the order of the keys. Ids are read in
+// chunks growing from scan.chunk, so that a scan limited to a few rows
+// stops early instead of reading the whole range
+func (scan *indexScan) fetchOrdered(ctx context.Context, table *Table, filter func(Row) (bool, error), project func(Row) Row, progress *ScanProgress, emit func(Row) error) error {
+	chunk := scan.chunk
+	if chunk <= 0 || chunk > maxOrderedChunk {
+		chunk = maxOrderedChunk
+	}
+
+	pages := int64(0)
+	var after BTreeKey
+	for {
+		ids, next, err := scan.nextIDs(after, chunk)
+		if err != nil {
+			return err
+		}
+
+		// rows aren't grouped by pages, so pages are counted each time the scan moves to another one
+		for i := range ids {
+			if i == 0 || ids[i].PageID() != ids[i-1].PageID() {
+				pages++
+			}
+		}
+		progress.begin(pages)
+
+		for i, id := range ids {
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+
+			row, err := table.FetchRow(id)
+			if err != nil {
+				return err
+			}
+
+			ok, err := filter(row)
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				err = emit(project(row))
+				if err != nil {
+					return err
+				}
+			}
+
+			if i+1 == len(ids) || ids[i+1].PageID() != id.PageID() {
+				progress.pageScanned()
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+		after = next
+		if chunk < maxOrderedChunk {
+			chunk *= 2
+		}
+	}
+}
+
+// Returns true if the scan returns rows sorted by the columns, when it's ordered.
+// Leading columns of the index fixed by equalities can be skipped by the columns
+func (scan *indexScan) sortedBy(columns []string) bool {
+	fixed := 0
+	for _, p := range scan.predicates {
+		if p.Op == OpEq {
+			fixed++
+		}
+	}
+
+	fields := scan.index.keyFields
+	for skip := 0; skip <= fixed; skip++ {
+		if skip+len(columns) > len(fields) {
+			break
+		}
+
+		sorted := true
+		for i, name := range columns {
+			if fields[skip+i].Name != name {
+				sorted = false
+				break
+			}
+		}
+		if sorted {
+			return true
+		}
+	}
+	return false
+}
+
+// Choose the index returning rows of the range in the order of the columns, so
+// that the rows don't need sorting. Unless there is a limit on the number of
+// rows, it's used only if it is chosen for the predicates anyway. Returns nil if
+// the rows have to be sorted
+func chooseOrderedIndex(table *Table, predicates []ColumnPredicate, chosen *indexScan, columns []string, limit int64) *indexScan {
+	if chosen != nil && chosen.sortedBy(columns) {
+		return chosen
+	}
+
+	if limit < 0 {
+		return nil
+	}
+
+	var best *indexScan
+	for _, index := range table.indexes {
+		scan := planIndexScan(index, predicates)
+		if scan == nil {
+			// the whole index is scanned, stopping early once there are enough rows
+			scan = &indexScan{index: index}
+		}
+
+		if scan.sortedBy(columns) && (best == nil || scan.score() > best.score()) {
+			best = scan
+		}
+	}
+	return best
+}
+
+// Choose the index scan for the predicates, the one fixing most of the leading
+// columns of its index. Returns nil if the full scan is better, stats can be nil
+func chooseIndex(table *Table, predicates []ColumnPredicate, stats *TableStats) *indexScan {
+	var best *indexScan
+	for _, index := range table.indexes {
+		scan := planIndexScan(index, predicates)
+		if scan != nil && (best == nil || scan.score() > best.score()) {
+			best = scan
+		}
+	}
+
+	if best == nil || stats == nil {
+		return best
+	}
+
+	selectivity := 1.0
+	for i := range best.predicates {
+		selectivity *= stats.Selectivity(&best.predicates[i])
+	}
+
+	if selectivity > IndexScanThreshold {
+		return nil
+	}
+	return best
+}
+
+// Returns the table having index with the given name, nil if there is no such index
+// catalog.m should be at least read-locked
+func (catalog *Catalog) indexTable(name string) *Table {
+	for _, table := range catalog.tables {
+		if table.Index(name) != nil {
+			return table
+		}
+	}
+	return nil
+}
+
+// Path of the file of the index on the table
+func (catalog *Catalog) indexPath(table string, index string) string {
+	return indexPath(filepath.Join(catalog.dataDir, table), index)
+}
+
+func (catalog *Catalog) doCreateIndex(create *CreateIndex) (*Result, error) {
+	err := validateName(create.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// rows of the table can't be inserted while the index is built
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	table, ok := catalog.tables[create.Table]
+	if !ok {
+		return nil, catalog.tableError(create.Table, ErrNoSuchTable)
+	}
+	if table.capture != nil {
+		return nil, ErrTableBeingAltered
+	}
+
+	if catalog.indexTable(create.Name) != nil {
+		return nil, ErrIndexAlreadyExist
+	}
+
+	err = catalog.beginDDL(ddlOp{Op: ddlCreateIndex, Table: create.Table, Index: create.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := table.CreateIndex(catalog.indexPath(create.Table, create.Name), create.Name, create.Columns)
+	if err != nil {
+		catalog.commitDDL()
+		return nil, err
+	}
+
+	err = catalog.saveMetadata()
+	if err != nil {
+		table.DropIndex(create.Name)
+		if table.options.Engine != EngineMemory {
+			os.Remove(index.path)
+		}
+		catalog.commitDDL()
+		return nil, err
+	}
+
+	return nil, catalog.commitDDL()
+}
+
+func (catalog *Catalog) doDropIndex(drop *DropIndex) (*Result, error) {
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	table := catalog.indexTable(drop.Name)
+	if table == nil {
+		return nil, ErrNoSuchIndex
+	}
+	if table.capture != nil {
+		return nil, ErrTableBeingAltered
+	}
+	tableName := catalog.indexTableName(drop.Name)
+
+	err := catalog.beginDDL(ddlOp{Op: ddlDropIndex, Table: tableName, Index: drop.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	// once metadata is saved the drop is committed, recovery removes the file
+	// if it's interrupted later
+	index, err := table.DropIndex(drop.Name)
+	if err != nil {
+		catalog.commitDDL()
+		return nil, err
+	}
+
+	err = catalog.saveMetadata()
+	if err != nil {
+		catalog.commitDDL()
+		return nil, err
+	}
+
+	if table.options.Engine != EngineMemory {
+		err = os.Remove(index.path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, catalog.commitDDL()
+}
+
+func (catalog *Catalog) doShowIndexes() (*Result, error) {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	var schema Schema
+	schema.addField(Field{Name: "index", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "table", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "columns", TypeID: TypeVarchar, Len: 255})
+
+	var rows []Row
+	for name, table := range catalog.tables {
+		for _, index := range table.indexes {
+			rows = append(rows, Row{
+				{TypeID: TypeVarchar, Str: index.name},
+				{TypeID: TypeVarchar, Str: name},
+				{TypeID: TypeVarchar, Str: strings.Join(index.columns, ", ")},
+			})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i][0].Str < rows[j][0].Str
+	})
+
+	return &Result{
+		Schema: schema,
+		Rows:   StaticRows(rows),
+	}, nil
+}
@@ -0,0 +1,372 @@
+package dumbdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestIndexes(t *testing.T) {
+	dir := t.TempDir()
+	catalog, err := OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		catalog.Close()
+	}()
+
+	query := func(q string) []string {
+		return columnStrings(mustQueryCatalog(t, catalog, q), 0)
+	}
+
+	insert := func(from int, to int) {
+		values := make([]string, 0, to-from)
+		for i := from; i < to; i++ {
+			values = append(values, fmt.Sprintf("(%v, %v, \"b%02d\")", i, i%10, i%100))
+		}
+
+		mustExecCatalog(t, catalog, "insert into t values "+strings.Join(values, ", "))
+	}
+
+	expected := func(n int, match func(a int, b string) bool) []string {
+		var ids []string
+		for i := 0; i < n; i++ {
+			if match(i%10, fmt.Sprintf("b%02d", i%100)) {
+				ids = append(ids, fmt.Sprint(i))
+			}
+		}
+		return ids
+	}
+
+	tests := []struct {
+		where string
+		index string // expected index scan, empty for the full scan
+		match func(a int, b string) bool
+	}{
+		{"a = 3", "t_ab (a = 3)", func(a int, b string) bool { return a == 3 }},
+		{"a = 3 and b > \"b50\"", "t_ab (a = 3, b > \"b50\")", func(a int, b string) bool { return a == 3 && b > "b50" }},
+		{"b <= \"b33\" and a = 3 and b >= \"b13\"", "t_ab (a = 3, b <= \"b33\", b >= \"b13\")", func(a int, b string) bool {
+			return a == 3 && b >= "b13" && b <= "b33"
+		}},
+		{"a = 7 and b = \"b17\"", "t_ab (a = 7, b = \"b17\")", func(a int, b string) bool { return a == 7 && b == "b17" }},
+		{"a = 7 and b = \"b18\"", "t_ab (a = 7, b = \"b18\")", func(a int, b string) bool { return false }},
+		{"a >= 8 and a < 9", "t_ab (a >= 8, a < 9)", func(a int, b string) bool { return a == 8 }},
+		{"a between 2 and 3 and b like \"b1%\"", "t_ab (a >= 2, a <= 3)", func(a int, b string) bool {
+			return a >= 2 && a <= 3 && strings.HasPrefix(b, "b1")
+		}},
+		{"a = 1 or a = 2", "", func(a int, b string) bool { return a == 1 || a == 2 }},
+		{"b = \"b42\"", "", func(a int, b string) bool { return b == "b42" }},
+	}
+
+	check := func(n int, index bool) {
+		for _, test := range tests {
+			q := "select id from t where " + test.where
+			ids := query(q)
+			want := expected(n, test.match)
+			if !reflect.DeepEqual(ids, want) && !(len(ids) == 0 && len(want) == 0) {
+				t.Fatalf("%v: expected %v rows, got %v", q, len(want), len(ids))
+			}
+
+			plan := query("explain " + q)
+			scan := "full scan of t"
+			if index && test.index != "" {
+				scan = "index scan of t using " + test.index
+			}
+			if plan[0] != scan {
+				t.Fatalf("%v: expected %v, got %v", q, scan, plan[0])
+			}
+		}
+	}
+
+	mustExecCatalog(t, catalog, "create table t (id int, a int, b varchar(3))")
+
+	insert(0, 1000)
+	mustExecCatalog(t, catalog, "create index t_ab on t (a, b)")
+	check(1000, true)
+
+	for _, q := range []string{
+		"create index t_ab on t (id)",
+		"create index t_x on t (id, x)",
+		"create index t_aa on t (a, a)",
+		"create index t_x on nope (id)",
+	} {
+		_, err = execCatalog(t, catalog, q)
+		if err == nil {
+			t.Fatalf("Expected %v to fail", q)
+		}
+	}
+
+	// rows inserted later are indexed
+	insert(1000, 2500)
+	check(2500, true)
+
+	mustExecCatalog(t, catalog, "vacuum t")
+	check(2500, true)
+
+	table, err := catalog.Table("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := table.Index("t_ab").tree.Validate()
+	if err != nil || n != 2500 {
+		t.Fatalf("Expected 2500 index entries, got %v (%v)", n, err)
+	}
+
+	// index file is read after clean shutdown, and rebuilt after a crash or if it's missing
+	for _, reopen := range []string{"clean", "crash", "missing"} {
+		switch reopen {
+		case "crash":
+			for _, table := range catalog.tables {
+				table.Close()
+			}
+		case "missing":
+			catalog.Close()
+			err = os.Remove(filepath.Join(dir, "t.t_ab.idx"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		default:
+			catalog.Close()
+		}
+
+		catalog, err = OpenCatalog(dir, StorageFile, false)
+		if err != nil {
+			t.Fatalf("%v: %v", reopen, err)
+		}
+
+		insert(2500, 2600)
+		check(2600, true)
+
+		mustExecCatalog(t, catalog, "truncate table t")
+
+		insert(0, 2500)
+		check(2500, true)
+	}
+
+	if indexes := query("show indexes"); !reflect.DeepEqual(indexes, []string{"t_ab"}) {
+		t.Fatalf("Unexpected indexes %v", indexes)
+	}
+
+	mustExecCatalog(t, catalog, "drop index t_ab")
+	check(2500, false)
+
+	_, err = os.Stat(filepath.Join(dir, "t.t_ab.idx"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected index file to be removed, got %v", err)
+	}
+
+	_, err = execCatalog(t, catalog, "drop index t_ab")
+	if !errors.Is(err, ErrNoSuchIndex) {
+		t.Fatalf("Expected ErrNoSuchIndex, got %v", err)
+	}
+
+	// files of the indexes are removed with the table
+	mustExecCatalog(t, catalog, "create index t_b on t (b)")
+
+	mustExecCatalog(t, catalog, "drop table t")
+
+	_, err = os.Stat(filepath.Join(dir, "t.t_b.idx"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected index file to be removed, got %v", err)
+	}
+
+	// crash before metadata of a dropped index is saved
+	for _, q := range []string{"create table u (id int)", "create index u_id on u (id)"} {
+		mustExecCatalog(t, catalog, q)
+	}
+
+	err = catalog.beginDDL(ddlOp{Op: ddlDropIndex, Table: "u", Index: "u_id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog.Close()
+
+	catalog, err = OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if indexes := query("show indexes"); len(indexes) != 0 {
+		t.Fatalf("Expected interrupted drop to be finished, got indexes %v", indexes)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "u.u_id.idx"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected file of dropped index to be removed, got %v", err)
+	}
+}
+
+func TestIndexStatistics(t *testing.T) {
+	catalog, err := OpenCatalog(t.TempDir(), StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+
+	plan := func(q string) string {
+		return mustQueryCatalog(t, catalog, q)[0][0].StrVal()
+	}
+
+	table, err := catalog.CreateTable("users", testTableSchema(), TableOptions{Engine: EngineMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := make([]Row, 0, 1000)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("user %v", i))})
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = table.CreateIndex("", "users_id", []string{"id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without statistics any usable index is used
+	if p := plan("explain select * from users where id > 10"); !strings.HasPrefix(p, "index scan") {
+		t.Fatalf("Expected index scan, got %v", p)
+	}
+
+	stats, err := table.Analyze()
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog.stats["users"] = stats
+
+	if p := plan("explain select * from users where id > 10"); p != "full scan of users" {
+		t.Fatalf("Expected full scan of most of the rows, got %v", p)
+	}
+
+	if p := plan("explain select * from users where id = 10"); !strings.HasPrefix(p, "index scan") {
+		t.Fatalf("Expected index scan, got %v", p)
+	}
+}
+
+func TestIndexKey(t *testing.T) {
+	schema := NewSchema([]FieldDescription{
+		{Name: "a", Type: &Type{Integer: true}},
+		{Name: "b", Type: &Type{Varchar: 10}},
+		{Name: "c", Type: &Type{Float: true}},
+	})
+
+	index, err := newIndex(&schema, "i", []string{"b", "c", "a"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keys compare column by column, prefix of a string goes before it
+	rows := []Row{
+		{IntValue(2), VarcharValue(""), FloatValue(1)},
+		{IntValue(1), VarcharValue("a"), FloatValue(-2)},
+		{IntValue(1), VarcharValue("a"), FloatValue(0)},
+		{IntValue(2), VarcharValue("a"), FloatValue(0)},
+		{IntValue(-1), VarcharValue("a\x00b"), FloatValue(-5)},
+		{IntValue(-1), VarcharValue("ab"), FloatValue(-5)},
+	}
+
+	var prev BTreeKey
+	for i, row := range rows {
+		key, err := index.key(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if prev != nil && string(prev) >= string(key) {
+			t.Fatalf("key of row %v %v doesn't follow %v", i, key, prev)
+		}
+		prev = key
+	}
+
+	// negative zero is equal to zero
+	zero, _ := index.key(Row{IntValue(1), VarcharValue("a"), FloatValue(0)})
+	negative, _ := index.key(Row{IntValue(1), VarcharValue("a"), FloatValue(math.Copysign(0, -1))})
+	if string(zero) != string(negative) {
+		t.Fatalf("keys of zero and negative zero differ: %v and %v", zero, negative)
+	}
+
+	schema = NewSchema([]FieldDescription{
+		{Name: "a", Type: &Type{Varchar: 255}},
+		{Name: "b", Type: &Type{Varchar: 255}},
+	})
+
+	_, err = newIndex(&schema, "i", []string{"a", "b"}, "")
+	if !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("Expected ErrKeyTooLarge, got %v", err)
+	}
+}
+
+func TestParallelIndexBuild(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	dir := t.TempDir()
+	table, err := NewTable(filepath.Join(dir, "t"), MakeSchema(IntField("id"), IntField("grp")), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	const n = 100000
+	rows := make([]Row, 0, n)
+	for i := 0; i < n; i++ {
+		rows = append(rows, Row{IntValue(int32(i)), IntValue(int32(i % 97))})
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if workers := indexBuildWorkers(int(table.pageCount())); workers < 2 {
+		t.Fatalf("Expected several workers, got %v for %v pages", workers, table.pageCount())
+	}
+
+	index, err := table.CreateIndex(filepath.Join(dir, "t_grp.idx"), "t_grp", []string{"grp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// entries are ordered by key, then by row id
+	cursor := index.tree.Search(BTreeKey{})
+	defer cursor.Close()
+
+	count := 0
+	var prev indexEntry
+	for ; cursor.Valid(); cursor.Forward() {
+		key, value := cursor.Get()
+		entry := indexEntry{key, RowID(value)}
+		if count != 0 && !indexEntryLess(&prev, &entry) {
+			t.Fatalf("Entry %v is out of order: %v after %v", count, entry, prev)
+		}
+
+		row, err := table.FetchRow(entry.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rowKey, err := index.key(row)
+		if err != nil || !bytes.Equal(rowKey, key) {
+			t.Fatalf("Entry %v points to row %v with another key", entry, row)
+		}
+
+		prev = entry
+		count++
+	}
+
+	if cursor.Err() != nil || count != n {
+		t.Fatalf("Expected %v entries, got %v (%v)", n, count, cursor.Err())
+	}
+}
@@ -0,0 +1,152 @@
+package dumbdb
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Tables smaller than this are scanned by a single worker when an index is built
+const minIndexBuildPages = 64
+
+// Entries with equal keys go in the order of the rows
+func indexEntryLess(a, b *indexEntry) bool {
+	cmp := bytes.Compare(a.key, b.key)
+	return cmp < 0 || cmp == 0 && a.id < b.id
+}
+
+// Number of workers scanning the pages of the table to build an index
+func indexBuildWorkers(pages int) int {
+	workers := runtime.GOMAXPROCS(0)
+	if max := pages / minIndexBuildPages; workers > max {
+		workers = max
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// Entries of the index for the rows of the pages
+func (index *Index) scanEntries(table *Table, pages []PageID) ([]indexEntry, error) {
+	var entries []indexEntry
+	for _, id := range pages {
+		// without predicates all the rows of the page are visited in order
+		idx := 0
+		err := table.ScanPage(id, nil, func(row Row) error {
+			if idx >= MaxRowsPerPage {
+				return fmt.Errorf("%v: row %v can't be indexed, only the first %v rows of a page can be referenced", id, idx, MaxRowsPerPage)
+			}
+
+			key, err := index.key(row)
+			if err != nil {
+				return err
+			}
+
+			entries = append(entries, indexEntry{key, NewRowID(id, uint8(idx))})
+			idx++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Scan the table with several workers, each of them scans a contiguous range
+// of pages and sorts entries of its rows. Returns the sorted runs
+func (index *Index) sortedRuns(table *Table) ([][]indexEntry, error) {
+	var pages []PageID
+	for id := table.firstPage(); id != InvalidPageID; id = table.nextPage(id) {
+		pages = append(pages, id)
+	}
+
+	workers := indexBuildWorkers(len(pages))
+	runs := make([][]indexEntry, workers)
+	errs := make([]error, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			first, last := len(pages)*i/workers, len(pages)*(i+1)/workers
+			run, err := index.scanEntries(table, pages[first:last])
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			sort.Slice(run, func(a, b int) bool {
+				return indexEntryLess(&run[a], &run[b])
+			})
+			runs[i] = run
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
+
+// Heap of the sorted runs by their first entries
+type indexRunHeap [][]indexEntry
+
+func (h indexRunHeap) Len() int {
+	return len(h)
+}
+
+func (h indexRunHeap) Less(i, j int) bool {
+	return indexEntryLess(&h[i][0], &h[j][0])
+}
+
+func (h indexRunHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *indexRunHeap) Push(x interface{}) {
+	*h = append(*h, x.([]indexEntry))
+}
+
+func (h *indexRunHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// Emit entries of the sorted runs in order
+func mergeIndexRuns(runs [][]indexEntry, emit func(BTreeKey, BTreeValue) error) error {
+	h := make(indexRunHeap, 0, len(runs))
+	for _, run := range runs {
+		if len(run) != 0 {
+			h = append(h, run)
+		}
+	}
+	heap.Init(&h)
+
+	for h.Len() != 0 {
+		entry := h[0][0]
+		err := emit(entry.key, BTreeValue(entry.id))
+		if err != nil {
+			return err
+		}
+
+		h[0] = h[0][1:]
+		if len(h[0]) == 0 {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package dumbdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNoIndexHeader = errors.New("index file has no tree header")
+
+// Dumps of the files of a table for offline debugging, see cmd/dumbdb-inspect.
+// Files are opened read-only, pages which can't be read are reported and skipped.
+// The database shouldn't be running, as pages it hasn't synced yet aren't seen
+type Inspector struct {
+	w    io.Writer
+	path string
+	meta tableMetadata
+}
+
+// Inspect table name of the catalog in dataDir, key is the one of encrypted tables
+func NewInspector(dataDir string, name string, key []byte, w io.Writer) (*Inspector, error) {
+	metadata, err := readMetadata(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, ok := metadata[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNoSuchTable, name)
+	}
+	if meta.Engine == EngineMemory {
+		return nil, fmt.Errorf("%v is a memory table, it has no files", name)
+	}
+
+	meta.TableOptions.ReadOnly = true
+	if meta.Encryption != EncryptionNone {
+		meta.TableOptions.EncryptionKey = key
+	}
+
+	return &Inspector{
+		w:    w,
+		path: filepath.Join(dataDir, name),
+		meta: meta,
+	}, nil
+}
+
+// Open the table file, or the file of the index if it isn't empty
+func (in *Inspector) openPager(index string) (*Pager, func(), error) {
+	var storage TableStorage
+	var err error
+	if index == "" {
+		opts := in.meta.TableOptions
+		opts.Storage = StorageFile
+		storage, err = openTableStorage(in.path+".bin", &opts, os.O_RDONLY)
+	} else {
+		if in.meta.indexPosition(index) == -1 {
+			return nil, nil, fmt.Errorf("%w: %v", ErrNoSuchIndex, index)
+		}
+		storage, err = os.Open(indexPath(in.path, index))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pager, err := in.meta.TableOptions.newPager(64, storage)
+	if err != nil {
+		storage.Close()
+		return nil, nil, err
+	}
+	return pager, func() { storage.Close() }, nil
+}
+
+// Print number of the pages of the table (or index) file and ranges of the allocated ones
+func (in *Inspector) DumpAllocationIndex(index string) error {
+	pager, release, err := in.openPager(index)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	n := int(pager.index.NumEntries())
+	var ranges []string
+	allocated := 0
+	for id := 0; id < n; id++ {
+		if !pager.index.IsAllocated(PageID(id)) {
+			continue
+		}
+
+		start := id
+		for id+1 < n && pager.index.IsAllocated(PageID(id+1)) {
+			id++
+		}
+		allocated += id - start + 1
+
+		if start == id {
+			ranges = append(ranges, fmt.Sprint(start))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%v-%v", start, id))
+		}
+	}
+
+	fmt.Fprintf(in.w, "pages: %v, allocated: %v, LSN: %v\n", n, allocated, pager.index.lsn)
+	if len(ranges) != 0 {
+		fmt.Fprintf(in.w, "allocated pages: %v\n", strings.Join(ranges, ", "))
+	}
+	return nil
+}
+
+// Print type and LSN of each allocated page, or the reason it can't be read
+func (in *Inspector) DumpPageHeaders(index string) error {
+	pager, release, err := in.openPager(index)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for id := PageID(0); uint32(id) < pager.index.NumEntries(); id++ {
+		if !pager.IsAllocated(id) {
+			continue
+		}
+
+		page, err := pager.FetchPage(id)
+		if err != nil {
+			fmt.Fprintf(in.w, "%v: %v\n", id, err)
+			continue
+		}
+		fmt.Fprintf(in.w, "%v: %v, LSN %v\n", id, page.Type(), page.LSN())
+		page.Unpin()
+	}
+	return nil
+}
+
+// Print rows of each page (or row group of a columnar table) decoded with the
+// schema of the table
+func (in *Inspector) DumpRows() error {
+	pager, release, err := in.openPager("")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	opts := in.meta.TableOptions
+	dict, err := openDictionary(in.path, &in.meta.Schema, opts.Dictionary, opts, false)
+	if err != nil {
+		return err
+	}
+	defer dict.Close()
+	layout := dict.layout(&in.meta.Schema)
+
+	names := make([]string, 0, len(in.meta.Fields))
+	for _, field := range in.meta.Fields {
+		names = append(names, field.Name)
+	}
+	fmt.Fprintf(in.w, "columns: %v\n", strings.Join(names, ", "))
+
+	stride := 1
+	if opts.Engine == EngineColumnar {
+		stride = len(layout.Fields)
+	}
+
+	// row groups are complete once their last page is allocated
+	for id := PageID(0); pager.IsAllocated(id + PageID(stride) - 1); id += PageID(stride) {
+		err := in.dumpPageRows(pager, id, &layout, dict)
+		if err != nil {
+			fmt.Fprintf(in.w, "%v: %v\n", id, err)
+		}
+	}
+	return nil
+}
+
+// Pin the page, or the pages of the row group of a columnar table
+func readRowSet(pager *Pager, id PageID, layout *Schema, engine string) (rowSet, func(), error) {
+	if engine == EngineColumnar {
+		return openColumnGroup(pager, id, layout, nil, false)
+	}
+
+	page, err := pager.FetchPage(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	list := NewRowListPage(page)
+	return &list, page.Unpin, nil
+}
+
+func (in *Inspector) dumpPageRows(pager *Pager, id PageID, layout *Schema, dict *Dictionary) error {
+	rows, release, err := readRowSet(pager, id, layout, in.meta.Engine)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = rows.Check(layout)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(in.w, "%v: %v rows\n", id, rows.NumRows())
+	for i := 0; i < rows.NumRows(); i++ {
+		row, err := dict.decode(rows.ReadRow(i, layout), nil)
+		if err != nil {
+			return err
+		}
+
+		values := make([]string, 0, len(row))
+		for j := range row {
+			values = append(values, literalText(&row[j]))
+		}
+		fmt.Fprintf(in.w, "\t%v: (%v)\n", i, strings.Join(values, ", "))
+	}
+	return nil
+}
+
+// Print nodes of the B+ tree of the index. Keys are printed encoded, see EncodeKey()
+func (in *Inspector) DumpTree(index string) error {
+	pos := in.meta.indexPosition(index)
+	if pos == -1 {
+		return fmt.Errorf("%w: %q", ErrNoSuchIndex, index)
+	}
+
+	pager, release, err := in.openPager(index)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	headerID, err := findTreeHeader(pager, in.meta.Indexes[pos].HeaderID)
+	if err != nil {
+		return err
+	}
+
+	tree, err := ReadBTree(headerID, pager)
+	if err != nil {
+		return fmt.Errorf("header %v: %w", headerID, err)
+	}
+	// the tree isn't closed, as it would sync the pager
+	defer tree.rootPage.Unpin()
+
+	keySize := "variable"
+	if tree.keySize != VariableKeySize {
+		keySize = fmt.Sprint(tree.keySize)
+	}
+	fmt.Fprintf(in.w, "header %v: root %v, key size %v, unique %v\n", headerID, tree.rootID, keySize, tree.unique)
+
+	visited := make(map[PageID]bool)
+	in.dumpNode(pager, tree.rootID, tree.keySize, 0, visited)
+	return nil
+}
+
+// Returns id of the header of the tree, the one saved in the metadata if it's
+// valid, otherwise the first page of the header type
+func findTreeHeader(pager *Pager, saved PageID) (PageID, error) {
+	if pager.IsAllocated(saved) {
+		page, err := pager.FetchPage(saved)
+		if err == nil {
+			kind := page.Type()
+			page.Unpin()
+			if kind == PageTypeBTreeHeader {
+				return saved, nil
+			}
+		}
+	}
+
+	for id := PageID(0); uint32(id) < pager.index.NumEntries(); id++ {
+		if !pager.IsAllocated(id) {
+			continue
+		}
+
+		page, err := pager.FetchPage(id)
+		if err != nil {
+			continue
+		}
+		kind := page.Type()
+		page.Unpin()
+		if kind == PageTypeBTreeHeader {
+			return id, nil
+		}
+	}
+	return InvalidPageID, ErrNoIndexHeader
+}
+
+func (in *Inspector) dumpNode(pager *Pager, id PageID, keySize int, depth int, visited map[PageID]bool) {
+	indent := strings.Repeat("\t", depth)
+	if visited[id] {
+		fmt.Fprintf(in.w, "%v%v: visited already\n", indent, id)
+		return
+	}
+	visited[id] = true
+
+	page, err := pager.FetchPage(id)
+	if err != nil {
+		fmt.Fprintf(in.w, "%v%v: %v\n", indent, id, err)
+		return
+	}
+
+	// the node is copied, so that the page isn't pinned while the children are read
+	node := readNode(page)
+	err = node.check(keySize)
+	var c nodeCopy
+	if err == nil {
+		c = copyNode(&node)
+	}
+	page.Unpin()
+	if err != nil {
+		fmt.Fprintf(in.w, "%v%v: %v\n", indent, id, err)
+		return
+	}
+
+	if c.isLeaf {
+		fmt.Fprintf(in.w, "%vleaf %v (prev %v, next %v): %v keys\n", indent, id, c.prev, c.next, len(c.keys))
+		for i := range c.keys {
+			row := RowID(c.values[i])
+			fmt.Fprintf(in.w, "%v\t%x -> %v:%v\n", indent, []byte(c.keys[i]), row.PageID(), row.RowIndex())
+		}
+		return
+	}
+
+	fmt.Fprintf(in.w, "%vbranch %v: %v keys\n", indent, id, len(c.keys))
+	for i := range c.keys {
+		fmt.Fprintf(in.w, "%v%v) <= %x:\n", indent, i, []byte(c.keys[i]))
+		in.dumpNode(pager, PageID(c.values[i]), keySize, depth+1, visited)
+	}
+	if c.next != InvalidPageID {
+		fmt.Fprintf(in.w, "%v%v) above:\n", indent, len(c.keys))
+		in.dumpNode(pager, c.next, keySize, depth+1, visited)
+	}
+}
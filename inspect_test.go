@@ -0,0 +1,89 @@
+package dumbdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestInspector(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenDatabase(Options{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make([]string, 0, 500)
+	for i := 0; i < 500; i++ {
+		values = append(values, fmt.Sprintf("(%v, \"name %v\")", i, i))
+	}
+	for _, q := range []string{
+		"create table users (id int, name varchar(20)) dictionary = (name)",
+		"create index users_id on users (id)",
+		"insert into users values " + strings.Join(values, ", "),
+		"create table events (id int, kind int) engine = columnar",
+		"insert into events values (1, 2), (3, 4)",
+	} {
+		mustExec(t, db, &Session{}, q)
+	}
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dump := func(table string, f func(in *Inspector) error) string {
+		var buf bytes.Buffer
+		in, err := NewInspector(dir, table, nil, &buf)
+		if err == nil {
+			err = f(in)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	out := dump("users", func(in *Inspector) error { return in.DumpAllocationIndex("") })
+	if !strings.HasPrefix(out, "pages: 2, allocated: 2,") || !strings.Contains(out, "allocated pages: 0-1\n") {
+		t.Fatalf("Unexpected allocation index:\n%v", out)
+	}
+
+	out = dump("users", func(in *Inspector) error { return in.DumpPageHeaders("users_id") })
+	if !strings.Contains(out, "PageID(0): btree_node, LSN") || !strings.Contains(out, "btree_header") {
+		t.Fatalf("Unexpected page headers:\n%v", out)
+	}
+
+	// values of dictionary columns are decoded
+	out = dump("users", func(in *Inspector) error { return in.DumpRows() })
+	if !strings.HasPrefix(out, "columns: id, name\nPageID(0): ") || !strings.Contains(out, ": (499, \"name 499\")\n") {
+		t.Fatalf("Unexpected rows:\n%v", out)
+	}
+
+	out = dump("events", func(in *Inspector) error { return in.DumpRows() })
+	if out != "columns: id, kind\nPageID(0): 2 rows\n\t0: (1, 2)\n\t1: (3, 4)\n" {
+		t.Fatalf("Unexpected rows:\n%v", out)
+	}
+
+	out = dump("users", func(in *Inspector) error { return in.DumpTree("users_id") })
+	if !strings.HasPrefix(out, "header ") || !strings.Contains(out, "branch ") || strings.Count(out, "leaf ") < 2 {
+		t.Fatalf("Unexpected tree:\n%v", out)
+	}
+
+	var buf bytes.Buffer
+	in, err := NewInspector(dir, "users", nil, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = in.DumpTree("")
+	if !errors.Is(err, ErrNoSuchIndex) {
+		t.Fatalf("Expected ErrNoSuchIndex, got %v", err)
+	}
+
+	_, err = NewInspector(dir, "t", nil, &buf)
+	if !errors.Is(err, ErrNoSuchTable) {
+		t.Fatalf("Expected ErrNoSuchTable, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package dumbdb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Journal of the DDL operation in progress, it exists only while the operation runs.
+// If it's found when the catalog is opened, the operation was interrupted by a crash
+// and is finished or rolled back, so that table files and metadata agree
+const JournalFilename string = "ddl.journal"
+
+const (
+	// rolled back: table file is removed unless metadata has the table
+	ddlCreateTable = "create_table"
+	// rolled forward: table is removed from metadata, and its file is removed
+	ddlDropTable = "drop_table"
+	// rolled back: index file is removed unless metadata has the index
+	ddlCreateIndex = "create_index"
+	// rolled forward: index is removed from metadata, and its file is removed
+	ddlDropIndex = "drop_index"
+	// rolled forward: files of the new table are renamed over the table files,
+	// and metadata of the table is replaced
+	ddlAlterTable = "alter_table"
+)
+
+// Single step of a DDL operation
+type ddlOp struct {
+	Op    string `json:"op"`
+	Table string `json:"table"`
+	Index string `json:"index,omitempty"`
+
+	// metadata of the altered table
+	Meta *tableMetadata `json:"meta,omitempty"`
+}
+
+// Write data to a temporary file and rename it over the file at path,
+// so that the file has either old or new content after a crash
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// Make renames and removals of files in the directory durable
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// Record DDL operation before touching any files, catalog.m should be locked
+func (catalog *Catalog) beginDDL(ops ...ddlOp) error {
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(catalog.dataDir, JournalFilename), data)
+}
+
+// Mark DDL operation as finished, catalog.m should be locked
+func (catalog *Catalog) commitDDL() error {
+	err := os.Remove(filepath.Join(catalog.dataDir, JournalFilename))
+	if err != nil {
+		return err
+	}
+	return syncDir(catalog.dataDir)
+}
+
+// Finish or roll back DDL operation interrupted by a crash, if any, before
+// the tables are opened. Metadata is fixed in place, returns true if it was changed
+func (catalog *Catalog) recoverDDL(metadata map[string]tableMetadata) (bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(catalog.dataDir, JournalFilename))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var ops []ddlOp
+	err = json.Unmarshal(data, &ops)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	for _, op := range ops {
+		filename := filepath.Join(catalog.dataDir, op.Table) + ".bin"
+		switch op.Op {
+		case ddlCreateTable:
+			if _, ok := metadata[op.Table]; ok {
+				continue
+			}
+		case ddlDropTable:
+			if _, ok := metadata[op.Table]; ok {
+				delete(metadata, op.Table)
+				changed = true
+			}
+
+			if _, ok := catalog.stats[op.Table]; ok && !catalog.readOnly {
+				delete(catalog.stats, op.Table)
+				err = catalog.saveStatistics()
+				if err != nil {
+					return false, err
+				}
+			}
+		case ddlCreateIndex:
+			meta, ok := metadata[op.Table]
+			if ok && meta.indexPosition(op.Index) != -1 {
+				continue
+			}
+			filename = catalog.indexPath(op.Table, op.Index)
+		case ddlDropIndex:
+			meta, ok := metadata[op.Table]
+			if idx := meta.indexPosition(op.Index); ok && idx != -1 {
+				meta.Indexes = append(meta.Indexes[:idx:idx], meta.Indexes[idx+1:]...)
+				metadata[op.Table] = meta
+				changed = true
+			}
+			filename = catalog.indexPath(op.Table, op.Index)
+		case ddlAlterTable:
+			altered, err := catalog.recoverAlter(op, metadata)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || altered
+			continue
+		default:
+			continue
+		}
+
+		if catalog.readOnly {
+			continue
+		}
+
+		err = os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+
+		if op.Op == ddlCreateTable || op.Op == ddlDropTable {
+			err = os.Remove(filepath.Join(catalog.dataDir, op.Table) + DictionaryFileExtension)
+			if err != nil && !os.IsNotExist(err) {
+				return false, err
+			}
+		}
+	}
+
+	return changed, nil
+}
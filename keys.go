@@ -0,0 +1,99 @@
+package dumbdb
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Encoding of column values into B+ tree keys. Encoded keys compare (as byte strings)
+// in the same order as the values, column by column.
+
+// Size of the int key, see IntKey()
+const IntKeySize = 4
+
+// Size of keys encoded from values of the fields, VariableKeySize if some of the fields have variable size
+func KeySize(fields []Field) int {
+	size := 0
+	for _, field := range fields {
+		switch field.TypeID {
+		case TypeInt:
+			size += 4
+		case TypeBigint, TypeFloat:
+			size += 8
+		case TypeBool:
+			size += 1
+		default:
+			return VariableKeySize
+		}
+	}
+
+	if size > MaxFixedKeySize {
+		return VariableKeySize
+	}
+	return size
+}
+
+// Append encoded value of the field to the key
+func AppendKey(key BTreeKey, field *Field, val *Value) BTreeKey {
+	switch field.TypeID {
+	case TypeInt:
+		// flip the sign bit, so that negative numbers go first
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], uint32(val.Int)^(1<<31))
+		return append(key, buf[:]...)
+	case TypeBigint:
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(val.Int)^(1<<63))
+		return append(key, buf[:]...)
+	case TypeFloat:
+		// flip all the bits of negative numbers, so that they go in reverse order
+		// before positive ones, and only the sign bit of positive ones
+		f := val.ToFloat()
+		if f == 0 {
+			// negative zero is equal to zero
+			f = 0
+		}
+
+		bits := math.Float64bits(f)
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits ^= 1 << 63
+		}
+
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], bits)
+		return append(key, buf[:]...)
+	case TypeBool:
+		return append(key, byte(val.Int))
+	case TypeVarchar:
+		// zero bytes are escaped, and the string is terminated with two zero bytes,
+		// so that prefix of a string goes before it regardless of the next columns
+		s := val.StrVal()
+		for i := 0; i < len(s); i++ {
+			if s[i] == 0 {
+				key = append(key, 0, 0xff)
+			} else {
+				key = append(key, s[i])
+			}
+		}
+		return append(key, 0, 0)
+	default:
+		panic("unhandled type id")
+	}
+}
+
+// Encode values of the fields into a key
+func EncodeKey(fields []Field, values []Value) BTreeKey {
+	var key BTreeKey
+	for i := range fields {
+		key = AppendKey(key, &fields[i], &values[i])
+	}
+	return key
+}
+
+// Key of a tree with a single int column
+func IntKey(n int32) BTreeKey {
+	field := Field{TypeID: TypeInt, Len: 4}
+	return AppendKey(nil, &field, &Value{TypeID: TypeInt, Int: int64(n)})
+}
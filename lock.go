@@ -0,0 +1,47 @@
+package dumbdb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// File in the data directory locked by the process using the database
+const LockFilename = "dumbdb.lock"
+
+var ErrDatabaseInUse = errors.New("database is in use")
+
+// Take exclusive advisory lock of the data directory, so that two processes don't
+// corrupt each other's files. Lock is released once the returned file is closed
+func lockDataDir(dataDir string) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(dataDir, LockFilename), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = flock(file)
+	if errors.Is(err, errLocked) {
+		owner, _ := ioutil.ReadAll(file)
+		file.Close()
+		return nil, fmt.Errorf("%w: %v is locked by process %v", ErrDatabaseInUse, dataDir, strings.TrimSpace(string(owner)))
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// pid of the owner for the error above, it's stale once the lock is released
+	err = file.Truncate(0)
+	if err == nil {
+		_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
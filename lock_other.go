@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package dumbdb
+
+import (
+	"errors"
+	"os"
+)
+
+var errLocked = errors.New("file is locked")
+
+// Advisory locks are not implemented on this platform, the data directory is not protected
+func flock(file *os.File) error {
+	return nil
+}
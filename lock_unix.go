@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package dumbdb
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var errLocked = syscall.EWOULDBLOCK
+
+// Lock the file without waiting, fails with errLocked if it's locked by another process
+func flock(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EINTR) {
+		return flock(file)
+	}
+	return err
+}
@@ -140,6 +140,7 @@ func (cache *LRUCache) Remove(id PageID) *Page {
 	}
 
 	cache.detachNode(node)
+	delete(cache.values, id)
 	return node.page
 }
 
@@ -0,0 +1,129 @@
+package dumbdb
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Table engines, see TableOptions
+const (
+	// pages are stored in the table file
+	EngineDisk = ""
+	// pages are kept in memory only, so the contents are lost on restart
+	EngineMemory = "memory"
+	// pages of the table file hold values of a single column, see ColumnGroup
+	EngineColumnar = "columnar"
+)
+
+var (
+	ErrUnknownEngine           = errors.New("unknown engine, expected memory or columnar")
+	ErrMemoryEngineCompression = errors.New("memory tables can't be compressed")
+)
+
+// Storage which keeps pages in memory, used by tables of the memory engine
+type MemoryStorage struct {
+	// protects pages, the slice itself is replaced when the storage grows
+	m     sync.RWMutex
+	pages [][PageSize]byte
+	off   int64 // for Seek()
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		pages: make([][PageSize]byte, 0),
+	}
+}
+
+func (s *MemoryStorage) TotalLen() int64 {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.totalLen()
+}
+
+func (s *MemoryStorage) totalLen() int64 {
+	return int64(len(s.pages)) * int64(PageSize)
+}
+
+// Change size of the storage, size has to be multiple of the page size
+func (s *MemoryStorage) Truncate(size int64) error {
+	if size%int64(PageSize) != 0 {
+		return ErrInvalidStorageSize
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	n := int(size / int64(PageSize))
+	if n <= len(s.pages) {
+		s.pages = s.pages[:n]
+		return nil
+	}
+
+	for len(s.pages) < n {
+		s.pages = append(s.pages, [PageSize]byte{})
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ReadAt(buf []byte, off int64) (int, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	n := 0
+	for n < len(buf) {
+		pos := off + int64(n)
+		if pos >= s.totalLen() {
+			return n, io.EOF
+		}
+
+		page := &s.pages[pos/int64(PageSize)]
+		n += copy(buf[n:], page[pos%int64(PageSize):])
+	}
+	return n, nil
+}
+
+// Writes have to be within the current size of the storage
+func (s *MemoryStorage) WriteAt(buf []byte, off int64) (int, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	n := 0
+	for n < len(buf) {
+		pos := off + int64(n)
+		if pos >= s.totalLen() {
+			return n, io.ErrShortWrite
+		}
+
+		page := &s.pages[pos/int64(PageSize)]
+		n += copy(page[pos%int64(PageSize):], buf[n:])
+	}
+	return n, nil
+}
+
+func (s *MemoryStorage) Seek(diff int64, whence int) (int64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		s.off += diff
+	case io.SeekStart:
+		s.off = diff
+	case io.SeekEnd:
+		s.off = s.totalLen() + diff
+	}
+	return s.off, nil
+}
+
+func (s *MemoryStorage) Name() string {
+	return ":memory:"
+}
+
+// Frees the pages
+func (s *MemoryStorage) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.pages = nil
+	return nil
+}
@@ -0,0 +1,297 @@
+package dumbdb
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Counter struct {
+	value uint64
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+type Gauge struct {
+	value int64
+}
+
+func (g *Gauge) Add(n int64) {
+	atomic.AddInt64(&g.value, n)
+}
+
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+// Cumulative histogram with fixed bucket upper bounds
+type Histogram struct {
+	bounds []float64
+
+	m      sync.Mutex
+	counts []uint64 // counts[i] is number of observations <= bounds[i]
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Latency buckets in seconds
+var latencyBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// Process-wide counters, safe for concurrent use
+type Metrics struct {
+	m       sync.Mutex
+	queries map[string]*Counter // by query kind
+
+	QueryLatency      *Histogram // seconds
+	ActiveConnections Gauge
+	QueryMemory       Gauge // bytes reserved by the running queries, see MemoryAccount
+
+	// admission control, see QueryQueue
+	RunningQueries  Gauge
+	QueuedQueries   Gauge
+	QueueWait       *Histogram // seconds
+	QueueTimeouts   Counter
+	QueueRejections Counter
+
+	// entries which failed to be written to the audit log, see AuditLog
+	AuditErrors Counter
+	// rows deleted once their TTL passed, see Catalog.ExpireRows
+	ExpiredRows Counter
+
+	CacheHits    Counter
+	CacheMisses  Counter
+	PagesRead    Counter
+	PagesWritten Counter
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		queries:      make(map[string]*Counter),
+		QueryLatency: NewHistogram(latencyBuckets),
+		QueueWait:    NewHistogram(latencyBuckets),
+	}
+}
+
+// Metrics updated by all the databases in the process
+var DefaultMetrics = NewMetrics()
+
+// Returns short name of the statement kind, e.g. "select"
+func QueryKind(query *Query) string {
+	switch {
+	case query.Create != nil:
+		return "create_table"
+	case query.Drop != nil:
+		return "drop_table"
+	case query.Truncate != nil:
+		return "truncate"
+	case query.Vacuum != nil:
+		return "vacuum"
+	case query.Alter != nil:
+		return "alter_table"
+	case query.Insert != nil:
+		return "insert"
+	case query.Select != nil:
+		return "select"
+	case query.Analyze != nil:
+		return "analyze"
+	case query.Explain != nil:
+		return "explain"
+	case query.Set != nil:
+		return "set"
+	case query.CopyFrom != nil:
+		return "copy_from"
+	case query.CopyTo != nil:
+		return "copy_to"
+	case query.CreateView != nil:
+		return "create_view"
+	case query.DropView != nil:
+		return "drop_view"
+	case query.CreateIndex != nil:
+		return "create_index"
+	case query.DropIndex != nil:
+		return "drop_index"
+	case query.ShowTables != nil:
+		return "show_tables"
+	case query.ShowTableStatus != nil:
+		return "show_table_status"
+	case query.ShowVariables != nil:
+		return "show_variables"
+	case query.ShowViews != nil:
+		return "show_views"
+	case query.ShowIndexes != nil:
+		return "show_indexes"
+	case query.Describe != nil:
+		return "describe"
+	case query.CreateDatabase != nil:
+		return "create_database"
+	case query.DropDatabase != nil:
+		return "drop_database"
+	case query.Use != nil:
+		return "use"
+	case query.Backup != nil:
+		return "backup"
+	case query.ShowProcessList != nil:
+		return "show_processlist"
+	case query.Kill != nil:
+		return "kill"
+	case query.Declare != nil:
+		return "declare"
+	case query.Fetch != nil:
+		return "fetch"
+	case query.Close != nil:
+		return "close"
+	case query.Grant != nil:
+		return "grant"
+	case query.Revoke != nil:
+		return "revoke"
+	case query.ShowGrants != nil:
+		return "show_grants"
+	case query.CreateTrigger != nil:
+		return "create_trigger"
+	case query.DropTrigger != nil:
+		return "drop_trigger"
+	case query.ShowTriggers != nil:
+		return "show_triggers"
+	case query.CreateProcedure != nil:
+		return "create_procedure"
+	case query.DropProcedure != nil:
+		return "drop_procedure"
+	case query.ShowProcedures != nil:
+		return "show_procedures"
+	case query.Call != nil:
+		return "call"
+	default:
+		return "unknown"
+	}
+}
+
+func (m *Metrics) QueryExecuted(kind string) {
+	m.m.Lock()
+	counter, ok := m.queries[kind]
+	if !ok {
+		counter = &Counter{}
+		m.queries[kind] = counter
+	}
+	m.m.Unlock()
+
+	counter.Add(1)
+}
+
+func (m *Metrics) ObserveLatency(d time.Duration) {
+	m.QueryLatency.Observe(d.Seconds())
+}
+
+func (m *Metrics) ObserveQueueWait(d time.Duration) {
+	m.QueueWait.Observe(d.Seconds())
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Write metrics in prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	m.m.Lock()
+	kinds := make([]string, 0, len(m.queries))
+	for kind := range m.queries {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	printf("# TYPE dumbdb_queries_total counter\n")
+	for _, kind := range kinds {
+		printf("dumbdb_queries_total{kind=%q} %d\n", kind, m.queries[kind].Value())
+	}
+	m.m.Unlock()
+
+	printHistogram := func(name string, h *Histogram) {
+		h.m.Lock()
+		defer h.m.Unlock()
+
+		printf("# TYPE %v histogram\n", name)
+		for i, bound := range h.bounds {
+			printf("%v_bucket{le=%q} %d\n", name, formatFloat(bound), h.counts[i])
+		}
+		printf("%v_bucket{le=\"+Inf\"} %d\n", name, h.count)
+		printf("%v_sum %v\n", name, formatFloat(h.sum))
+		printf("%v_count %d\n", name, h.count)
+	}
+	printHistogram("dumbdb_query_duration_seconds", m.QueryLatency)
+
+	printf("# TYPE dumbdb_active_connections gauge\n")
+	printf("dumbdb_active_connections %d\n", m.ActiveConnections.Value())
+	printf("# TYPE dumbdb_query_memory_bytes gauge\n")
+	printf("dumbdb_query_memory_bytes %d\n", m.QueryMemory.Value())
+
+	printf("# TYPE dumbdb_running_queries gauge\n")
+	printf("dumbdb_running_queries %d\n", m.RunningQueries.Value())
+	printf("# TYPE dumbdb_queued_queries gauge\n")
+	printf("dumbdb_queued_queries %d\n", m.QueuedQueries.Value())
+	printHistogram("dumbdb_queue_wait_seconds", m.QueueWait)
+	printf("# TYPE dumbdb_queue_timeouts_total counter\n")
+	printf("dumbdb_queue_timeouts_total %d\n", m.QueueTimeouts.Value())
+	printf("# TYPE dumbdb_queue_rejections_total counter\n")
+	printf("dumbdb_queue_rejections_total %d\n", m.QueueRejections.Value())
+
+	printf("# TYPE dumbdb_audit_errors_total counter\n")
+	printf("dumbdb_audit_errors_total %d\n", m.AuditErrors.Value())
+	printf("# TYPE dumbdb_expired_rows_total counter\n")
+	printf("dumbdb_expired_rows_total %d\n", m.ExpiredRows.Value())
+
+	hits := m.CacheHits.Value()
+	misses := m.CacheMisses.Value()
+	printf("# TYPE dumbdb_buffer_pool_hits_total counter\n")
+	printf("dumbdb_buffer_pool_hits_total %d\n", hits)
+	printf("# TYPE dumbdb_buffer_pool_misses_total counter\n")
+	printf("dumbdb_buffer_pool_misses_total %d\n", misses)
+
+	ratio := 0.0
+	if hits+misses != 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+	printf("# TYPE dumbdb_buffer_pool_hit_ratio gauge\n")
+	printf("dumbdb_buffer_pool_hit_ratio %v\n", formatFloat(ratio))
+
+	printf("# TYPE dumbdb_pages_read_total counter\n")
+	printf("dumbdb_pages_read_total %d\n", m.PagesRead.Value())
+	printf("# TYPE dumbdb_pages_written_total counter\n")
+	printf("dumbdb_pages_written_total %d\n", m.PagesWritten.Value())
+
+	return err
+}
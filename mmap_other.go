@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package dumbdb
+
+import "os"
+
+// Storage backed by a memory-mapped file, not available on this platform
+type MmapStorage struct {
+	*os.File
+}
+
+func OpenMmapStorage(path string, flags int) (*MmapStorage, error) {
+	return nil, ErrMmapNotSupported
+}
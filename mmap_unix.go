@@ -0,0 +1,164 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package dumbdb
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Storage backed by a memory-mapped file. Reads copy straight from the mapping,
+// writes are flushed with msync(), so they are durable once WriteAt() returns
+type MmapStorage struct {
+	// protects data, which is remapped when the file is resized
+	m    sync.RWMutex
+	file *os.File
+	data []byte
+	off  int64 // for Seek()
+
+	// protection of the mapping, PROT_READ only for files opened read-only
+	prot int
+}
+
+// Open or create the storage in file at path, flags are the same as for os.OpenFile()
+func OpenMmapStorage(path string, flags int) (*MmapStorage, error) {
+	file, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	storage := &MmapStorage{file: file, prot: syscall.PROT_READ | syscall.PROT_WRITE}
+	if isReadOnly(flags) {
+		storage.prot = syscall.PROT_READ
+	}
+
+	err = storage.remap(info.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return storage, nil
+}
+
+// Replace the mapping with the one of the given size, storage has to be locked
+func (storage *MmapStorage) remap(size int64) error {
+	err := storage.unmap()
+	if err != nil {
+		return err
+	}
+
+	// empty files can't be mapped
+	if size == 0 {
+		return nil
+	}
+
+	data, err := syscall.Mmap(int(storage.file.Fd()), 0, int(size), storage.prot, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+
+	storage.data = data
+	return nil
+}
+
+func (storage *MmapStorage) unmap() error {
+	if storage.data == nil {
+		return nil
+	}
+
+	err := syscall.Munmap(storage.data)
+	storage.data = nil
+	return err
+}
+
+func (storage *MmapStorage) ReadAt(buf []byte, off int64) (int, error) {
+	storage.m.RLock()
+	defer storage.m.RUnlock()
+
+	if off >= int64(len(storage.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(buf, storage.data[off:])
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Writes have to be within the current size of the storage
+func (storage *MmapStorage) WriteAt(buf []byte, off int64) (int, error) {
+	storage.m.RLock()
+	defer storage.m.RUnlock()
+
+	if storage.prot&syscall.PROT_WRITE == 0 {
+		return 0, os.ErrPermission
+	}
+
+	if off+int64(len(buf)) > int64(len(storage.data)) {
+		return 0, io.ErrShortWrite
+	}
+
+	n := copy(storage.data[off:], buf)
+
+	// msync() requires address aligned to the OS page size
+	start := off - off%int64(os.Getpagesize())
+	region := storage.data[start : off+int64(n)]
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&region[0])), uintptr(len(region)), syscall.MS_SYNC)
+	if errno != 0 {
+		return 0, errno
+	}
+	return n, nil
+}
+
+func (storage *MmapStorage) Seek(diff int64, whence int) (int64, error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		storage.off += diff
+	case io.SeekStart:
+		storage.off = diff
+	case io.SeekEnd:
+		storage.off = int64(len(storage.data)) + diff
+	}
+	return storage.off, nil
+}
+
+func (storage *MmapStorage) Truncate(size int64) error {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	err := storage.file.Truncate(size)
+	if err != nil {
+		return err
+	}
+	return storage.remap(size)
+}
+
+func (storage *MmapStorage) Name() string {
+	return storage.file.Name()
+}
+
+func (storage *MmapStorage) Close() error {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	err := storage.unmap()
+	if err != nil {
+		storage.file.Close()
+		return err
+	}
+	return storage.file.Close()
+}
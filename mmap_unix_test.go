@@ -0,0 +1,138 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package dumbdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMmapDatabase(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenDatabase(Options{DataDir: dir, Storage: StorageMmap})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := db.CreateTable("users", testTableSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := table.storage.(*MmapStorage); !ok {
+		t.Fatalf("Expected mmap storage, got %T", table.storage)
+	}
+
+	const nRows = 1000
+	rows := make([]Row, 0, nRows)
+	for i := 0; i < nRows; i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("user %v", i))})
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// files are compatible with the regular storage
+	db, err = OpenDatabase(Options{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	table, err = db.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := 0
+	err = table.Scan(func(row Row) error {
+		if row[0].Int != int64(i) || row[1].StrVal() != fmt.Sprintf("user %v", i) {
+			return fmt.Errorf("unexpected row #%v: %v", i, row)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i != nRows {
+		t.Fatalf("Expected %v rows, got %v", nRows, i)
+	}
+
+	_, err = OpenDatabase(Options{DataDir: dir, Storage: "tape"})
+	if err != ErrUnknownStorage {
+		t.Fatalf("Expected ErrUnknownStorage, got %v", err)
+	}
+}
+
+// Scan of a file much larger than the page cache, so that every page is read from the storage
+func BenchmarkScan(b *testing.B) {
+	const nPages = 4096
+
+	storages := []struct {
+		name string
+		open func(path string) (TableStorage, error)
+	}{
+		{StorageFile, func(path string) (TableStorage, error) {
+			return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_SYNC, 0600)
+		}},
+		{StorageMmap, func(path string) (TableStorage, error) {
+			return OpenMmapStorage(path, os.O_RDWR|os.O_CREATE|os.O_SYNC)
+		}},
+	}
+
+	for _, s := range storages {
+		b.Run(s.name, func(b *testing.B) {
+			storage, err := s.open(filepath.Join(b.TempDir(), "pages.bin"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer storage.Close()
+
+			err = storage.Truncate(nPages * int64(PageSize))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			pager, err := NewPager(64, storage)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			for i := 0; i < nPages-1; i++ {
+				_, err := pager.AllocatePage()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			err = pager.SyncAll()
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(nPages * int64(PageSize))
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				for id := pager.FirstPage(); id != InvalidPageID; id = pager.NextPage(id) {
+					page, err := pager.FetchPage(id)
+					if err != nil {
+						b.Fatal(err)
+					}
+					page.Unpin()
+				}
+			}
+		})
+	}
+}
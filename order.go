@@ -0,0 +1,127 @@
+package dumbdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func (item *OrderItem) String() string {
+	if item.Desc {
+		return item.Column.String() + " desc"
+	}
+	return item.Column.String()
+}
+
+// Compare rows by values of the columns with the indexes, columns with desc set are compared in reverse
+func orderLess(indexes []int, desc []bool) func(a, b Row) bool {
+	return func(a, b Row) bool {
+		for i, idx := range indexes {
+			c := compareValues(&a[idx], &b[idx])
+			if c != 0 {
+				return (c < 0) != desc[i]
+			}
+		}
+		return false
+	}
+}
+
+// Plan order by and limit of the select. Rows are sorted by columns of the table
+// before they are projected, so that they can be sorted by columns which aren't
+// selected, except for distinct selects, which are sorted by the selected columns.
+// If an index returns the rows in order, they aren't sorted at all
+// input is the schema of the rows before the projection
+func (plan *selectPlan) planOrder(q *Select, input *Schema) error {
+	plan.limit = -1
+	if q.Limit != nil {
+		if *q.Limit < 0 {
+			return errors.New("limit should not be negative")
+		}
+		plan.limit = *q.Limit
+	}
+
+	if len(q.OrderBy) == 0 {
+		return nil
+	}
+
+	schema := input
+	if plan.distinct {
+		schema = &plan.schema
+	}
+
+	indexes := make([]int, 0, len(q.OrderBy))
+	desc := make([]bool, 0, len(q.OrderBy))
+	names := make([]string, 0, len(q.OrderBy))
+	descending := false
+	for _, item := range q.OrderBy {
+		err := checkQualifier(item.Column, q.Qualifier())
+		if err != nil {
+			return err
+		}
+
+		name := item.Column.Name
+		if !plan.distinct && item.Column.Table == "" && !q.Projection.All {
+			// selected columns can be referenced by their aliases
+			for _, selected := range q.Projection.Items {
+				if selected.Alias == name {
+					name = selected.Column.Name
+					break
+				}
+			}
+		}
+
+		idx, _ := schema.GetField(name)
+		if idx == -1 {
+			if plan.distinct {
+				return fmt.Errorf("order by column %v of select distinct is not selected", item.Column)
+			}
+			return fmt.Errorf("unknown column %v in order by", item.Column)
+		}
+
+		indexes = append(indexes, idx)
+		desc = append(desc, item.Desc)
+		names = append(names, name)
+		descending = descending || item.Desc
+	}
+
+	if plan.table != nil && !plan.distinct && !descending {
+		scan := chooseOrderedIndex(plan.table, plan.predicates, plan.index, names, plan.limit)
+		if scan != nil {
+			scan.ordered = true
+			scan.chunk = maxOrderedChunk
+			if plan.limit < maxOrderedChunk {
+				scan.chunk = int(plan.limit)
+			}
+			plan.index = scan
+			return nil
+		}
+	}
+
+	plan.less = orderLess(indexes, desc)
+	plan.sortProjected = plan.distinct
+
+	// columnar tables have to read the columns rows are sorted by
+	if !plan.sortProjected && plan.columns != nil {
+		for _, idx := range indexes {
+			plan.columns[idx] = true
+		}
+	}
+	return nil
+}
+
+// Describe sort and limit of the plan
+func (plan *selectPlan) explainOrder(indent string) []string {
+	var lines []string
+	if plan.less != nil {
+		items := make([]string, 0, len(plan.query.OrderBy))
+		for _, item := range plan.query.OrderBy {
+			items = append(items, item.String())
+		}
+		lines = append(lines, fmt.Sprintf("%v  sort: %v", indent, strings.Join(items, ", ")))
+	}
+
+	if plan.limit >= 0 {
+		lines = append(lines, fmt.Sprintf("%v  limit: %v", indent, plan.limit))
+	}
+	return lines
+}
@@ -0,0 +1,142 @@
+package dumbdb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOrderBy(t *testing.T) {
+	catalog, err := OpenCatalog(t.TempDir(), StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+
+	query := func(q string) []string {
+		return rowStrings(mustQueryCatalog(t, catalog, q), (*Value).String, " ")
+	}
+
+	mustExecCatalog(t, catalog, "create table t (id int, a int, b varchar(10))")
+
+	// ids are inserted out of order, a has duplicates
+	const n = 10000
+	values := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		id := (i * 7919) % n
+		values = append(values, fmt.Sprintf("(%v, %v, \"b%v\")", id, id%10, id%3))
+	}
+	mustExecCatalog(t, catalog, "insert into t values "+strings.Join(values, ", "))
+
+	ids := func(from int, to int, step int) []string {
+		var ids []string
+		for i := from; i != to; i += step {
+			ids = append(ids, fmt.Sprint(i))
+		}
+		return ids
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"select id from t where id < 5 order by id", ids(0, 5, 1)},
+		{"select id from t where id < 5 order by id desc", ids(4, -1, -1)},
+		{"select id from t order by id limit 3", ids(0, 3, 1)},
+		{"select id from t order by id desc limit 3", ids(n-1, n-4, -1)},
+		{"select id from t where id > 9000 order by id limit 2", ids(9001, 9003, 1)},
+		{"select id as key from t where id >= 20 order by key limit 2", ids(20, 22, 1)},
+		{"select b from t where id < 4 order by t.id desc", []string{"b0", "b2", "b1", "b0"}},
+		{"select a, id from t where id < 30 and a = 7 order by a, id desc", []string{"7 27", "7 17", "7 7"}},
+		{"select distinct b from t order by b desc", []string{"b2", "b1", "b0"}},
+		{"select id from t where id > 5 limit 0", []string{}},
+	}
+
+	check := func() {
+		for _, test := range tests {
+			values := query(test.query)
+			if !reflect.DeepEqual(values, test.expected) {
+				t.Fatalf("%v: expected %v, got %v", test.query, test.expected, values)
+			}
+		}
+	}
+	check()
+
+	// the same results come from the indexes
+	mustExecCatalog(t, catalog, "create index t_id on t (id)")
+	mustExecCatalog(t, catalog, "create index t_ab on t (a, b)")
+	check()
+
+	// next page starts after the last row of the previous one
+	var pages [][]string
+	last := -1
+	for {
+		page := query(fmt.Sprintf("select id from t where id > %v order by id limit 1000", last))
+		if len(page) == 0 {
+			break
+		}
+		pages = append(pages, page)
+		fmt.Sscan(page[len(page)-1], &last)
+	}
+	if len(pages) != n/1000 || pages[3][0] != "3000" || last != n-1 {
+		t.Fatalf("Unexpected pages, %v of them, last id %v", len(pages), last)
+	}
+
+	// rows failing the row filter make the scan read more chunks of ids
+	rows := query("select id from t where id >= 100 and b = \"b2\" order by id limit 3000")
+	if len(rows) != 3000 || rows[0] != "101" || rows[2999] != "9098" {
+		t.Fatalf("Unexpected rows %v ... %v", rows[:3], rows[len(rows)-3:])
+	}
+
+	// rows with equal keys aren't split between chunks of ids
+	rows = query("select a from t where a >= 1 order by a")
+	if len(rows) != n-n/10 || rows[0] != "1" || rows[len(rows)-1] != "9" {
+		t.Fatalf("Unexpected %v rows", len(rows))
+	}
+
+	for _, test := range []struct {
+		query string
+		plan  []string
+	}{
+		{"explain select id from t where id > 100 order by id limit 10", []string{
+			"ordered index scan of t using t_id (id > 100)",
+			"  row filter: where clause",
+			"  project: [id]",
+			"  limit: 10",
+		}},
+		{"explain select * from t order by id limit 10", []string{
+			"ordered index scan of t using t_id",
+			"  limit: 10",
+		}},
+		{"explain select * from t where a = 3 order by b", []string{
+			"ordered index scan of t using t_ab (a = 3)",
+			"  row filter: where clause",
+		}},
+		{"explain select * from t order by id", []string{
+			"full scan of t",
+			"  sort: id",
+		}},
+		{"explain select * from t order by id desc limit 10", []string{
+			"full scan of t",
+			"  sort: id desc",
+			"  limit: 10",
+		}},
+	} {
+		plan := query(test.query)
+		if !reflect.DeepEqual(plan[:len(test.plan)], test.plan) {
+			t.Fatalf("%v: expected plan %q, got %q", test.query, test.plan, plan)
+		}
+	}
+
+	for _, q := range []string{
+		"select * from t order by c",
+		"select * from t order by u.id",
+		"select distinct b from t order by id",
+	} {
+		_, err := execCatalog(t, catalog, q)
+		if err == nil {
+			t.Fatalf("Expected %v to fail", q)
+		}
+	}
+}
@@ -1,15 +1,81 @@
 package dumbdb
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"sync"
 	"sync/atomic"
+	"unsafe"
 )
 
 type PageID uint32
 
 const PageSize uint32 = 4096
 
+// Each page starts with the header, the rest of it is the data of the page
+//
+//	magic (4) + type (1) + format version (1) + reserved (2) + LSN (8) +
+//	checksum (4) + file id (4)
+//
+// Checksum is crc32 of the whole page except for the checksum itself. File id
+// is set only in the root page of encrypted files, see AllocationIndex
+const (
+	PageHeaderSize        = 24
+	PageDataSize   uint32 = PageSize - PageHeaderSize
+
+	// version of the layout of the pages, pages of newer versions aren't read
+	PageFormatVersion = 1
+
+	pageMagic = 0x67617064 // "dpag"
+)
+
+var (
+	ErrUnsupportedPageVersion = errors.New("page is written by a newer version")
+	ErrNoPageHeader           = fmt.Errorf("%w: no page header", ErrCorruptedPage)
+	ErrPageChecksum           = fmt.Errorf("%w: checksum mismatch", ErrCorruptedPage)
+	// pages of files written before the pages had headers
+	ErrOldPageFormat = errors.New("file is written by an older version without page headers, it has to be migrated")
+)
+
+// Kind of the data of the page
+type PageType uint8
+
+const (
+	// allocated page, which was never written
+	PageTypeFree PageType = iota
+	// root page of AllocationIndex
+	PageTypeAllocation
+	// rows of a table, see RowListPage
+	PageTypeRows
+	// header of a B+tree with the id of its root, see BTree
+	PageTypeBTreeHeader
+	// leaf or branch of a B+tree, see BTreeNode
+	PageTypeBTreeNode
+	// values of a column of a row group, see ColumnGroup
+	PageTypeColumnSegment
+)
+
+func (t PageType) String() string {
+	switch t {
+	case PageTypeFree:
+		return "free"
+	case PageTypeAllocation:
+		return "allocation"
+	case PageTypeRows:
+		return "rows"
+	case PageTypeBTreeHeader:
+		return "btree_header"
+	case PageTypeBTreeNode:
+		return "btree_node"
+	case PageTypeColumnSegment:
+		return "column_segment"
+	default:
+		return fmt.Sprintf("PageType(%d)", uint8(t))
+	}
+}
+
 // any page > InvalidPageID is also considered invalid
 // so max file size is (InvalidPageID - 1) * PageSize = ~64GB
 const InvalidPageID PageID = PageID(0x00ffffff)
@@ -48,7 +114,31 @@ type Page struct {
 	m sync.RWMutex
 	// true if data was modified and doesn't match what's in persistent storage
 	dirty bool
-	data  [PageSize]byte
+	// kind of the data, stored in the header
+	kind PageType
+	// LSN of the last write of the page, stored in the header
+	lsn uint64
+	// whole page including the header, aligned to PageSize, see newPage()
+	data []byte
+}
+
+// Allocate a new zeroed page. Its data is aligned to PageSize, so that the pages can
+// be read and written by DirectStorage without copying
+func newPage() *Page {
+	return &Page{data: alignedBuffer(int(PageSize))}
+}
+
+// Allocate a zeroed buffer of size bytes aligned to PageSize
+func alignedBuffer(size int) []byte {
+	// allocations of the page size are aligned in practice, but it's not guaranteed
+	buf := make([]byte, size)
+	if size == 0 || uintptr(unsafe.Pointer(&buf[0]))%uintptr(PageSize) == 0 {
+		return buf
+	}
+
+	buf = make([]byte, size+int(PageSize))
+	shift := int(PageSize) - int(uintptr(unsafe.Pointer(&buf[0]))%uintptr(PageSize))
+	return buf[shift : shift+size]
 }
 
 func (page *Page) IsPinned() bool {
@@ -105,8 +195,78 @@ func (page *Page) Unlock() {
 	page.m.Unlock()
 }
 
+// Data of the page, without the header
 func (page *Page) Data() []byte {
-	return page.data[:]
+	return page.data[PageHeaderSize:]
+}
+
+func (page *Page) Type() PageType {
+	return page.kind
+}
+
+// Set the kind of the data, it's written to the header along with the data
+func (page *Page) SetType(kind PageType) {
+	page.kind = kind
+}
+
+// LSN of the last write of the page, 0 if it was never written
+func (page *Page) LSN() uint64 {
+	return page.lsn
+}
+
+// Fill the header of the page before it's written
+func (page *Page) writeHeader(lsn uint64) {
+	page.lsn = lsn
+	header := page.data[:PageHeaderSize]
+	binary.LittleEndian.PutUint32(header, pageMagic)
+	header[4] = byte(page.kind)
+	header[5] = PageFormatVersion
+	binary.LittleEndian.PutUint16(header[6:], 0)
+	binary.LittleEndian.PutUint64(header[8:], lsn)
+	binary.LittleEndian.PutUint32(header[16:], pageChecksum(page.data))
+}
+
+// Check the header of the page just read and parse it. Pages which were
+// allocated but never written are zeroed, they are read as free pages
+func (page *Page) readHeader() error {
+	header := page.data[:PageHeaderSize]
+	magic := binary.LittleEndian.Uint32(header)
+	if magic != pageMagic {
+		for _, b := range page.data {
+			if b != 0 {
+				return ErrNoPageHeader
+			}
+		}
+		page.kind, page.lsn = PageTypeFree, 0
+		return nil
+	}
+
+	if header[5] > PageFormatVersion {
+		return fmt.Errorf("%w: format version %v, %v is supported", ErrUnsupportedPageVersion, header[5], PageFormatVersion)
+	}
+
+	page.kind = PageType(header[4])
+	page.lsn = binary.LittleEndian.Uint64(header[8:])
+
+	checksum := binary.LittleEndian.Uint32(header[16:])
+	if checksum != pageChecksum(page.data) {
+		return ErrPageChecksum
+	}
+	return nil
+}
+
+// Checksum of the whole page except for the checksum field of the header
+func pageChecksum(data []byte) uint32 {
+	checksum := crc32.ChecksumIEEE(data[:16])
+	return crc32.Update(checksum, crc32.IEEETable, data[20:])
+}
+
+// Returns ErrCorruptedPage unless the page holds data of the given kind or is free
+func (page *Page) checkType(kind PageType) error {
+	if page.kind != kind && page.kind != PageTypeFree {
+		return fmt.Errorf("%w: %v page, expected %v", ErrCorruptedPage, page.kind, kind)
+	}
+	return nil
 }
 
 func (page *Page) IsDirty() bool {
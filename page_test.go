@@ -0,0 +1,166 @@
+package dumbdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPageHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pages")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	pager, err := NewPager(16, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := NewBTree(pager, 4, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tree.Insert(IntKey(1), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rows are written to a page allocated after the tree
+	id, err := pager.AllocatePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := pager.FetchPage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := testTableSchema()
+	rows := NewRowListPage(page)
+	rows.TryInsert(Row{IntValue(1), VarcharValue("a")}, &schema)
+	rows.Commit()
+	page.Unpin()
+
+	err = pager.SyncAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager, err = NewPager(16, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lsn uint64
+	for _, expected := range []struct {
+		id   PageID
+		kind PageType
+	}{
+		{tree.HeaderID(), PageTypeBTreeHeader},
+		// the root is allocated right before the header
+		{tree.HeaderID() - 1, PageTypeBTreeNode},
+		{id, PageTypeRows},
+	} {
+		page, err := pager.FetchPage(expected.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Type() != expected.kind || page.LSN() == 0 || page.LSN() == lsn {
+			t.Fatalf("Page %v: unexpected %v page with LSN %v", expected.id, page.Type(), page.LSN())
+		}
+		lsn = page.LSN()
+		page.Unpin()
+	}
+
+	// new writes continue the LSNs of the previous ones
+	page, err = pager.FetchPage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.MarkDirty()
+	err = pager.SyncPage(id, page)
+	page.Unpin()
+	if err != nil || page.LSN() <= lsn {
+		t.Fatalf("Expected LSN after %v, got %v (%v)", lsn, page.LSN(), err)
+	}
+
+	corrupt := func(offset int64, data []byte) error {
+		_, err := file.WriteAt(data, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pager, err := NewPager(16, file)
+		if err != nil {
+			return err
+		}
+		_, err = pager.FetchPage(id)
+		return err
+	}
+
+	// a flipped byte of the data fails the checksum
+	rowsOffset := int64(PageSize) * int64(1+id)
+	err = corrupt(rowsOffset+100, []byte{0xff})
+	if !errors.Is(err, ErrPageChecksum) || !errors.Is(err, ErrCorruptedPage) {
+		t.Fatalf("Expected ErrPageChecksum, got %v", err)
+	}
+
+	err = corrupt(rowsOffset+5, []byte{PageFormatVersion + 1})
+	if !errors.Is(err, ErrUnsupportedPageVersion) {
+		t.Fatalf("Expected ErrUnsupportedPageVersion, got %v", err)
+	}
+
+	// rows can't be read from a page of another kind
+	tablePath := filepath.Join(t.TempDir(), "t")
+	table, err := NewTable(tablePath, testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = table.Insert([]Row{{IntValue(1), VarcharValue("a")}})
+	if err == nil {
+		err = table.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tableFile, err := os.OpenFile(tablePath+".bin", os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page = newPage()
+	page.SetType(PageTypeBTreeNode)
+	page.writeHeader(1)
+	_, err = tableFile.WriteAt(page.data, int64(PageSize))
+	tableFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err = OpenTable(tablePath, testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+	err = table.Scan(func(row Row) error { return nil })
+	if !errors.Is(err, ErrCorruptedPage) {
+		t.Fatalf("Expected ErrCorruptedPage, got %v", err)
+	}
+
+	// files written before the headers have the allocation index right at the start
+	var root [PageSize]byte
+	binary.LittleEndian.PutUint32(root[:], 1)
+	root[IndexHeaderSize] = 1
+	_, err = file.WriteAt(root[:], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPager(16, file)
+	if !errors.Is(err, ErrOldPageFormat) {
+		t.Fatalf("Expected ErrOldPageFormat, got %v", err)
+	}
+}
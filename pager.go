@@ -3,31 +3,59 @@ package dumbdb
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 )
 
 const (
 	IndexHeaderSize        = 4
-	IndexMaxEntriesPerPage = (PageSize - IndexHeaderSize) * 8
+	IndexMaxEntriesPerPage = (PageDataSize - IndexHeaderSize) * 8
 )
 
 type AllocationIndex struct {
+	// last LSN assigned to a written page, accessed atomically (first field for
+	// 64-bit alignment), persisted in the header of the root page
+	lsn uint64
+
 	nEntires uint32
 	root     *Page
+	// size of a page in the storage, pages follow the root one
+	slotSize int64
+	// random id of an encrypted file, 0 for the other files, see pageCipher.
+	// It's persisted in the header of the root page
+	fileID uint32
 }
 
 func ReadAllocationIndex(storage Storage) (*AllocationIndex, error) {
-	root := &Page{}
-	_, err := storage.ReadAt(root.Data(), 0)
+	root := newPage()
+	_, err := storage.ReadAt(root.data, 0)
 	if err != nil {
 		return nil, err
 	}
 
+	// the root page is overwritten in place, so a crash can tear it. Pages are
+	// allocated one after another, so the torn page is still usable
+	err = root.readHeader()
+	if err == nil || errors.Is(err, ErrPageChecksum) {
+		err = root.checkType(PageTypeAllocation)
+	}
+	if errors.Is(err, ErrNoPageHeader) {
+		err = ErrOldPageFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("allocation index: %w", err)
+	}
+	root.SetType(PageTypeAllocation)
+
 	nEntries := binary.LittleEndian.Uint32(root.Data())
 	return &AllocationIndex{
+		lsn:      root.LSN(),
 		nEntires: nEntries,
 		root:     root,
+		slotSize: int64(PageSize),
+		fileID:   binary.LittleEndian.Uint32(root.data[20:PageHeaderSize]),
 	}, nil
 }
 
@@ -48,12 +76,14 @@ func (index *AllocationIndex) Unlock() {
 }
 
 func (index *AllocationIndex) SyncPages(storage Storage) error {
-	if !index.root.IsDirty() {
+	if !index.root.IsDirty() && index.root.LSN() == atomic.LoadUint64(&index.lsn) {
 		return nil
 	}
 
 	binary.LittleEndian.PutUint32(index.root.Data(), index.nEntires)
-	_, err := storage.WriteAt(index.root.Data(), 0)
+	binary.LittleEndian.PutUint32(index.root.data[20:PageHeaderSize], index.fileID)
+	index.root.writeHeader(atomic.LoadUint64(&index.lsn))
+	_, err := storage.WriteAt(index.root.data, 0)
 	if err != nil {
 		index.root.MarkClean()
 	}
@@ -70,7 +100,7 @@ func (index *AllocationIndex) GetOffset(id PageID) int64 {
 		return -1
 	}
 
-	return (1 + int64(id)) * int64(PageSize)
+	return int64(PageSize) + int64(id)*index.slotSize
 }
 
 func (index *AllocationIndex) IsAllocated(id PageID) bool {
@@ -106,6 +136,14 @@ type Storage interface {
 	Truncate(size int64) error
 }
 
+// Storage, which keeps pages in its own format (e.g. compressed)
+type RawStorage interface {
+	Storage
+
+	// Returns reader of the underlying file
+	Raw() (*io.SectionReader, error)
+}
+
 // NOTE: all cache methods have to be thread-safe
 type PageCache interface {
 	// get page from cache by id
@@ -131,12 +169,17 @@ var (
 	ErrInvalidStorageSize = errors.New("storage size should be multiple of page size")
 	ErrNoFreePages        = errors.New("no free pages")
 	ErrPageNotAllocated   = errors.New("page not allocated")
+	ErrCorruptedPage      = errors.New("page is corrupted")
 )
 
 // Manages pool of pages in memory abstracting away details of file storage
 type Pager struct {
 	storage     Storage
 	storageSize int64
+	// nil if the pages aren't encrypted, the root page of the allocation index never is
+	cipher *pageCipher
+	// size of a page in the storage, larger than PageSize for encrypted pages
+	slotSize int64
 
 	pageLocks [128]sync.Mutex
 	cache     PageCache
@@ -145,12 +188,32 @@ type Pager struct {
 
 // Create a new pager backed by storage
 func NewPager(maxPages int, storage Storage) (*Pager, error) {
+	return newPager(maxPages, storage, nil)
+}
+
+// Create a new pager backed by storage, which encrypts the pages with AES-GCM
+// using the key of EncryptionKeySize bytes. Pages are stored with their nonces
+// and authentication tags, so they take more than PageSize bytes of the storage
+func NewEncryptedPager(maxPages int, storage Storage, key []byte) (*Pager, error) {
+	cipher, err := newPageCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return newPager(maxPages, storage, cipher)
+}
+
+func newPager(maxPages int, storage Storage, cipher *pageCipher) (*Pager, error) {
 	storageSize, err := storage.Seek(0, io.SeekEnd)
 	if err != nil {
 		return nil, err
 	}
 
-	if storageSize%int64(PageSize) != 0 {
+	slotSize := int64(PageSize)
+	if cipher != nil {
+		slotSize = encryptedPageSize
+	}
+
+	if storageSize != 0 && (storageSize < int64(PageSize) || (storageSize-int64(PageSize))%slotSize != 0) {
 		return nil, ErrInvalidStorageSize
 	}
 
@@ -158,6 +221,8 @@ func NewPager(maxPages int, storage Storage) (*Pager, error) {
 	pager := &Pager{
 		storage:     storage,
 		storageSize: storageSize,
+		cipher:      cipher,
+		slotSize:    slotSize,
 		cache:       &cache,
 		index:       nil,
 	}
@@ -171,6 +236,19 @@ func NewPager(maxPages int, storage Storage) (*Pager, error) {
 	if err != nil {
 		return nil, err
 	}
+	pager.index.slotSize = slotSize
+
+	if cipher != nil {
+		// existing files without ids were written before the files had them
+		if storageSize == 0 {
+			pager.index.fileID, err = newFileID()
+			if err != nil {
+				return nil, err
+			}
+			pager.index.root.MarkDirty()
+		}
+		cipher.fileID = pager.index.fileID
+	}
 
 	return pager, nil
 }
@@ -184,8 +262,10 @@ func (pager *Pager) FetchPage(id PageID) (*Page, error) {
 	page := pager.cache.Get(id)
 	if page != nil {
 		pager.unlockPageID(id)
+		DefaultMetrics.CacheHits.Add(1)
 		return page, nil
 	}
+	DefaultMetrics.CacheMisses.Add(1)
 
 	// read from the disk
 	page, err := pager.readPage(id)
@@ -225,10 +305,73 @@ func (pager *Pager) AllocatePage() (PageID, error) {
 	}
 
 	offset := index.GetOffset(id)
-	err := pager.ensureSize(offset + int64(PageSize))
+	reused := offset+pager.slotSize <= pager.storageSize
+	err := pager.ensureSize(offset + pager.slotSize)
+	if err != nil {
+		return id, err
+	}
+
+	// storage was left larger than the index by a crash, so the page may have
+	// contents of the page allocated before. Pages of encrypted files with ids
+	// are written right away, so that none of them is left zeroed and they are
+	// all authenticated, see pageCipher.open()
+	if reused || (pager.cipher != nil && pager.cipher.fileID != 0) {
+		err = pager.writePageAt(id, offset, newPage())
+	}
 	return id, err
 }
 
+// Deallocate all the pages and shrink the storage, cached pages are dropped without syncing
+// NOTE: caller has to make sure that no pages are in use
+func (pager *Pager) Truncate() error {
+	return pager.TruncatePages(0)
+}
+
+// Deallocate pages with ids >= n and shrink the storage, cached pages are dropped without syncing
+// NOTE: caller has to make sure that these pages are not in use
+func (pager *Pager) TruncatePages(n uint32) error {
+	index := pager.index
+	index.Lock()
+	defer index.Unlock()
+
+	if n >= index.NumEntries() {
+		return nil
+	}
+
+	var cached []PageID
+	pager.cache.ForEach(func(id PageID, page *Page) bool {
+		if uint32(id) >= n {
+			cached = append(cached, id)
+		}
+		return true
+	})
+
+	for _, id := range cached {
+		pager.cache.Remove(id)
+	}
+
+	bitmap := index.root.Data()[IndexHeaderSize:]
+	for idx := n; idx < index.NumEntries(); idx++ {
+		bitmap[idx/8] &^= 1 << (idx % 8)
+	}
+	index.nEntires = n
+	index.root.MarkDirty()
+
+	err := index.SyncPages(pager.storage)
+	if err != nil {
+		return err
+	}
+
+	size := int64(PageSize) + int64(n)*pager.slotSize
+	err = pager.storage.Truncate(size)
+	if err != nil {
+		return err
+	}
+
+	pager.storageSize = size
+	return nil
+}
+
 // Flush page to disk, page have to be locked
 func (pager *Pager) SyncPage(id PageID, page *Page) error {
 	if !page.IsDirty() {
@@ -269,8 +412,61 @@ func (pager *Pager) SyncAll() error {
 		page.RUnlock()
 		return err == nil
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	// LSN of the pages just written is persisted as well
+	return pager.SyncMetadata()
+}
+
+// Flush all the pages and return reader of the whole storage
+// NOTE: caller has to make sure no pages are modified until reading is done
+func (pager *Pager) Snapshot() (*io.SectionReader, error) {
+	err := pager.SyncAll()
+	if err != nil {
+		return nil, err
+	}
+
+	// storages with their own format are copied as is
+	raw, ok := pager.storage.(RawStorage)
+	if ok {
+		return raw.Raw()
+	}
+
+	index := pager.index
+	index.RLock()
+	size := pager.storageSize
+	index.RUnlock()
+
+	return io.NewSectionReader(pager.storage, 0, size), nil
+}
+
+// Check that the allocated pages are exactly the first NumEntries() ones of
+// the allocation index, and that all of them are backed by the storage
+func (pager *Pager) Validate() error {
+	index := pager.index
+	index.RLock()
+	defer index.RUnlock()
+
+	n := index.NumEntries()
+	bitmap := index.root.Data()[IndexHeaderSize:]
+	for idx := uint32(0); idx < IndexMaxEntriesPerPage; idx++ {
+		allocated := bitmap[idx/8]&(1<<(idx%8)) != 0
+		if allocated != (idx < n) {
+			return fmt.Errorf("allocation index: page %v is allocated=%v with %v pages allocated", idx, allocated, n)
+		}
+	}
+
+	size, err := pager.storage.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if required := int64(PageSize) + int64(n)*pager.slotSize; size < required {
+		return fmt.Errorf("allocation index: storage has %v bytes, %v pages need %v", size, n, required)
+	}
+	return nil
 }
 
 // Get ID of the first page. Returns InvalidPageID if db is empty
@@ -279,6 +475,14 @@ func (pager *Pager) FirstPage() PageID {
 	return pager.NextPage(id)
 }
 
+// Returns true if the page is allocated
+func (pager *Pager) IsAllocated(id PageID) bool {
+	index := pager.index
+	index.RLock()
+	defer index.RUnlock()
+	return index.IsAllocated(id)
+}
+
 func (pager *Pager) NextPage(id PageID) PageID {
 	index := pager.index
 	index.RLock()
@@ -315,12 +519,31 @@ func (pager *Pager) ensureSize(requiredSize int64) error {
 	return nil
 }
 
-// Read page at offset
-func (pager *Pager) readPageAt(offset int64) (*Page, error) {
-	page := &Page{}
-	_, err := pager.storage.ReadAt(page.Data(), offset)
+// Read page at offset, decrypting it if needed, and check its header
+func (pager *Pager) readPageAt(id PageID, offset int64) (*Page, error) {
+	page := newPage()
+	if pager.cipher == nil {
+		_, err := pager.storage.ReadAt(page.data, offset)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		buf := make([]byte, pager.slotSize)
+		_, err := pager.storage.ReadAt(buf, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		err = pager.cipher.open(page.data, id, buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	DefaultMetrics.PagesRead.Add(1)
+
+	err := page.readHeader()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%v: %w", id, err)
 	}
 	return page, nil
 }
@@ -338,7 +561,7 @@ func (pager *Pager) readPage(id PageID) (*Page, error) {
 
 	// FIXME: it is possible for id -> offset mapping to change while we are doing IO
 	//        we'll have to LockPageID(id) in DeallocPage() to fix that
-	page, err := pager.readPageAt(offset)
+	page, err := pager.readPageAt(id, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -347,10 +570,25 @@ func (pager *Pager) readPage(id PageID) (*Page, error) {
 	return page, nil
 }
 
-// Write page at offset
-func (pager *Pager) writePageAt(offset int64, page *Page) error {
-	_, err := pager.storage.WriteAt(page.Data(), offset)
-	return err
+// Write page at offset with the next LSN, encrypting it if needed
+func (pager *Pager) writePageAt(id PageID, offset int64, page *Page) error {
+	page.writeHeader(atomic.AddUint64(&pager.index.lsn, 1))
+
+	data := page.data
+	if pager.cipher != nil {
+		data = make([]byte, pager.slotSize)
+		err := pager.cipher.seal(data, id, page.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := pager.storage.WriteAt(data, offset)
+	if err != nil {
+		return err
+	}
+	DefaultMetrics.PagesWritten.Add(1)
+	return nil
 }
 
 // Write page to the disk
@@ -364,5 +602,5 @@ func (pager *Pager) writePage(id PageID, page *Page) error {
 	}
 	index.RUnlock()
 
-	return pager.writePageAt(offset, page)
+	return pager.writePageAt(id, offset, page)
 }
@@ -0,0 +1,128 @@
+package dumbdb
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials file")
+
+// Environment variable with the password clients connect with
+const PasswordEnv = "DUMBDB_PASSWORD"
+
+// Iterations of PBKDF2 deriving the hashes of new passwords
+const PasswordIterations = 100000
+
+const (
+	passwordScheme   = "pbkdf2-sha256"
+	passwordSaltSize = 16
+)
+
+// Hash of a password as stored in the credentials file
+type passwordHash struct {
+	iterations int
+	salt       []byte
+	hash       []byte
+}
+
+// Users allowed to connect to the server and hashes of their passwords, see
+// LoadCredentials(). Each line of the file is
+//
+//	user:pbkdf2-sha256:iterations:salt:hash
+//
+// with the hex-encoded salt and hash, as returned by HashPassword()
+type Credentials struct {
+	users map[string]passwordHash
+}
+
+// Line of the credentials file for the user with the password, the salt is random
+func HashPassword(user string, password string) (string, error) {
+	if user == "" || strings.ContainsAny(user, ":\n") {
+		return "", fmt.Errorf("invalid user name %q", user)
+	}
+
+	salt := make([]byte, passwordSaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	hash := pbkdf2SHA256([]byte(password), salt, PasswordIterations)
+	return fmt.Sprintf("%v:%v:%v:%x:%x", user, passwordScheme, PasswordIterations, salt, hash), nil
+}
+
+// Parse the credentials file, empty lines and lines starting with # are skipped
+func ParseCredentials(data []byte) (*Credentials, error) {
+	creds := &Credentials{users: make(map[string]passwordHash)}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 5 || fields[0] == "" || fields[1] != passwordScheme {
+			return nil, fmt.Errorf("%w: line %v should be user:%v:iterations:salt:hash", ErrInvalidCredentials, i+1, passwordScheme)
+		}
+
+		iterations, err := strconv.Atoi(fields[2])
+		if err != nil || iterations <= 0 {
+			return nil, fmt.Errorf("%w: line %v: invalid number of iterations %q", ErrInvalidCredentials, i+1, fields[2])
+		}
+
+		salt, saltErr := hex.DecodeString(fields[3])
+		hash, hashErr := hex.DecodeString(fields[4])
+		if saltErr != nil || hashErr != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%w: line %v: salt and hash should be hex-encoded, the hash %v bytes long", ErrInvalidCredentials, i+1, sha256.Size)
+		}
+		creds.users[fields[0]] = passwordHash{iterations: iterations, salt: salt, hash: hash}
+	}
+	return creds, nil
+}
+
+func LoadCredentials(filename string) (*Credentials, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCredentials(data)
+}
+
+// Returns true if the user is in the file and the password matches its hash
+func (creds *Credentials) Verify(user string, password string) bool {
+	stored, ok := creds.users[user]
+	if !ok {
+		// the hash is computed anyway, so that unknown users aren't told apart by timing
+		stored = passwordHash{iterations: PasswordIterations, salt: make([]byte, passwordSaltSize)}
+	}
+
+	hash := pbkdf2SHA256([]byte(password), stored.salt, stored.iterations)
+	return subtle.ConstantTimeCompare(hash, stored.hash) == 1 && ok
+}
+
+// PBKDF2 (RFC 8018) with HMAC-SHA256, the key is a single block of sha256.Size bytes
+func pbkdf2SHA256(password []byte, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
@@ -0,0 +1,75 @@
+package dumbdb
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// test vectors of RFC 7914, first block of the key
+	for _, test := range []struct {
+		password, salt string
+		iterations     int
+		key            string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	} {
+		key := hex.EncodeToString(pbkdf2SHA256([]byte(test.password), []byte(test.salt), test.iterations))
+		if key != test.key {
+			t.Fatalf("Expected key %v for %q, got %v", test.key, test.password, key)
+		}
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	alice, err := HashPassword("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := HashPassword("bob", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := ParseCredentials([]byte("# users of the server\n" + alice + "\n\n" + bob + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		user, password string
+		ok             bool
+	}{
+		{"alice", "secret", true},
+		{"bob", "hunter2", true},
+		{"alice", "hunter2", false},
+		{"alice", "", false},
+		{"carol", "secret", false},
+		{"", "", false},
+	} {
+		if creds.Verify(test.user, test.password) != test.ok {
+			t.Fatalf("Expected verification of %v with %q to be %v", test.user, test.password, test.ok)
+		}
+	}
+
+	_, err = HashPassword("a:b", "secret")
+	if err == nil {
+		t.Fatal("Expected user name with a colon to be rejected")
+	}
+
+	for _, data := range []string{
+		"alice",
+		"alice:sha1:1:00:00",
+		"alice:pbkdf2-sha256:0:00:" + strings.Repeat("00", 32),
+		"alice:pbkdf2-sha256:1:xx:" + strings.Repeat("00", 32),
+		"alice:pbkdf2-sha256:1:00:00",
+	} {
+		_, err = ParseCredentials([]byte(data))
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Expected ErrInvalidCredentials for %q, got %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package dumbdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrProcedureAlreadyExist = errors.New("procedure with such name already exist")
+	ErrNoSuchProcedure       = errors.New("no procedure with such name")
+)
+
+// Procedures of the catalog by name, with their definitions (source of create procedure)
+const ProceduresFilename string = "procedures.json"
+
+// Max number of calls nested in each other, e.g. by a procedure calling itself
+const MaxCallDepth = 16
+
+// Procedure stored in the catalog under a name
+type Procedure struct {
+	Definition string
+	query      *CreateProcedure
+}
+
+func parseProcedure(definition string) (*Procedure, error) {
+	q, err := ParseQuery(definition)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.CreateProcedure == nil {
+		return nil, fmt.Errorf("procedure definition is not create procedure: %v", definition)
+	}
+
+	return &Procedure{Definition: definition, query: q.CreateProcedure}, nil
+}
+
+func (catalog *Catalog) loadProcedures() error {
+	data, err := ioutil.ReadFile(filepath.Join(catalog.dataDir, ProceduresFilename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	definitions := make(map[string]string)
+	err = json.Unmarshal(data, &definitions)
+	if err != nil {
+		return err
+	}
+
+	for name, definition := range definitions {
+		procedure, err := parseProcedure(definition)
+		if err != nil {
+			return fmt.Errorf("procedure %v: %w", name, err)
+		}
+		catalog.procedures[name] = procedure
+	}
+	return nil
+}
+
+// Encoded definitions of all the procedures
+func (catalog *Catalog) procedureDefinitions() ([]byte, error) {
+	definitions := make(map[string]string)
+	for name, procedure := range catalog.procedures {
+		definitions[name] = procedure.Definition
+	}
+	return json.Marshal(definitions)
+}
+
+// catalog.m should be locked
+func (catalog *Catalog) saveProcedures() error {
+	data, err := catalog.procedureDefinitions()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(catalog.dataDir, ProceduresFilename), data)
+}
+
+// Format the value as a literal of a statement
+func literalText(val *Value) string {
+	switch val.TypeID {
+	case TypeVarchar:
+		return strconv.Quote(val.StrVal())
+	case TypeFloat:
+		// floats are recognized by the decimal point, exponents aren't supported
+		s := strconv.FormatFloat(val.Float, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	default:
+		return val.String()
+	}
+}
+
+// Replace parameters of the statement (:name) outside string literals and quoted
+// identifiers with the values
+func substituteParams(statement string, values map[string]string) (string, error) {
+	var b strings.Builder
+	start := 0
+	var err error
+	scanWords(statement, func(word string, wordStart int, wordEnd int) {
+		if err != nil || wordStart == 0 || statement[wordStart-1] != ':' {
+			return
+		}
+
+		value, ok := values[word]
+		if !ok {
+			err = fmt.Errorf("unknown parameter :%v", word)
+			return
+		}
+
+		b.WriteString(statement[start : wordStart-1])
+		b.WriteString(value)
+		start = wordEnd
+	})
+	if err != nil {
+		return "", err
+	}
+
+	b.WriteString(statement[start:])
+	return b.String(), nil
+}
+
+// Statements of the procedure with parameters replaced with the values
+func (create *CreateProcedure) statements(args []Value) ([]string, error) {
+	if len(args) != len(create.Params) {
+		return nil, fmt.Errorf("procedure %v expects %v arguments, got %v", create.Name, len(create.Params), len(args))
+	}
+
+	values := make(map[string]string, len(args))
+	for i, name := range create.Params {
+		values[name] = literalText(&args[i])
+	}
+
+	statements := make([]string, 0, len(create.Body))
+	for i, statement := range create.Body {
+		statement, err := substituteParams(statement, values)
+		if err != nil {
+			return nil, fmt.Errorf("statement #%d of procedure %v: %w", i+1, create.Name, err)
+		}
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
+
+// Check that the statements parse, whatever the arguments are. Parameters are
+// replaced with numbers or, if the statement expects strings, with strings
+func (create *CreateProcedure) check() error {
+	if len(create.Body) == 0 {
+		return errors.New("procedure has no statements")
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range create.Params {
+		if seen[name] {
+			return fmt.Errorf("parameter %v is specified more than once", name)
+		}
+		seen[name] = true
+	}
+
+	placeholders := []Value{IntegerValue(0), {TypeID: TypeVarchar, Str: ""}}
+	for n, placeholder := range placeholders {
+		args := make([]Value, len(create.Params))
+		for i := range args {
+			args[i] = placeholder
+		}
+
+		statements, err := create.statements(args)
+		if err != nil {
+			return err
+		}
+
+		parsed := true
+		for i, statement := range statements {
+			q, err := ParseQuery(statement)
+			if err != nil && n != len(placeholders)-1 {
+				// checked again with the next placeholder
+				parsed = false
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("statement #%d: %w", i+1, err)
+			}
+
+			if q.CreateProcedure != nil {
+				return errors.New("procedures can't create procedures")
+			}
+		}
+		if parsed {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (catalog *Catalog) doCreateProcedure(create *CreateProcedure) (*Result, error) {
+	if create.Definition == "" {
+		return nil, errors.New("procedure definition is missing")
+	}
+
+	err := validateName(create.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// tables the statements refer to are checked once they are executed
+	err = create.check()
+	if err != nil {
+		return nil, err
+	}
+
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	if _, ok := catalog.procedures[create.Name]; ok {
+		return nil, ErrProcedureAlreadyExist
+	}
+
+	catalog.procedures[create.Name] = &Procedure{Definition: create.Definition, query: create}
+	err = catalog.saveProcedures()
+	if err != nil {
+		delete(catalog.procedures, create.Name)
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (catalog *Catalog) doDropProcedure(drop *DropProcedure) (*Result, error) {
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	procedure, ok := catalog.procedures[drop.Name]
+	if !ok {
+		return nil, ErrNoSuchProcedure
+	}
+
+	delete(catalog.procedures, drop.Name)
+	err := catalog.saveProcedures()
+	if err != nil {
+		catalog.procedures[drop.Name] = procedure
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (catalog *Catalog) doShowProcedures() (*Result, error) {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	names := make([]string, 0, len(catalog.procedures))
+	for name := range catalog.procedures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var schema Schema
+	schema.addField(Field{Name: "procedure", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "definition", TypeID: TypeVarchar, Len: 255})
+
+	rows := make([]Row, 0, len(names))
+	for _, name := range names {
+		rows = append(rows, Row{
+			{TypeID: TypeVarchar, Str: name},
+			{TypeID: TypeVarchar, Str: catalog.procedures[name].Definition},
+		})
+	}
+
+	return &Result{
+		Schema: schema,
+		Rows:   StaticRows(rows),
+	}, nil
+}
+
+// Key of the context value with the number of calls the statement is nested in
+type callDepthKey struct{}
+
+// Execute statements of the procedure one by one in the session, with the
+// privileges of its user. Execution stops at the first failed statement, the
+// ones before it stay applied. Returns result of the last statement, rows of
+// the other ones are skipped
+func (db *Database) doCall(ctx context.Context, sess *Session, call *CallProcedure) (*Result, error) {
+	depth, _ := ctx.Value(callDepthKey{}).(int)
+	if depth >= MaxCallDepth {
+		return nil, fmt.Errorf("calls are nested too deep, %v levels at most", MaxCallDepth)
+	}
+
+	catalog, err := db.catalog(sess.currentDatabase())
+	if err != nil {
+		return nil, err
+	}
+
+	catalog.m.RLock()
+	procedure, ok := catalog.procedures[call.Name]
+	catalog.m.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNoSuchProcedure, call.Name)
+	}
+
+	args := make([]Value, 0, len(call.Args))
+	for i := range call.Args {
+		args = append(args, call.Args[i].ToValue())
+	}
+
+	statements, err := procedure.query.statements(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, callDepthKey{}, depth+1)
+	var result *Result
+	for i, statement := range statements {
+		failed := func(err error) error {
+			return fmt.Errorf("statement #%d of procedure %v: %w", i+1, call.Name, err)
+		}
+
+		q, err := ParseQuery(statement)
+		if err != nil {
+			return nil, failed(err)
+		}
+
+		result, err = db.execute(ctx, sess, q)
+		if err != nil {
+			return nil, failed(err)
+		}
+
+		if result == nil {
+			continue
+		}
+		if result.CopyIn != nil {
+			return nil, failed(errors.New("copy from stdin can't be called"))
+		}
+
+		if i != len(statements)-1 && result.Rows != nil {
+			_, err = result.Rows.All()
+			if err != nil {
+				return nil, failed(err)
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,121 @@
+package dumbdb
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProcedures(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+	}()
+
+	sess := &Session{}
+	query := func(q string) []string {
+		return rowStrings(mustQuery(t, db, sess, q), (*Value).String, " ")
+	}
+
+	for _, q := range []string{
+		"create table users (id int, name varchar(20), score float)",
+		"create procedure add_user(id, name, score) as begin\n" +
+			"  insert into users values (:id, :name, :score);\n" +
+			"  select id, name, score from users where id = :id\n" +
+			"end",
+		"create procedure add_twice(id) as begin call add_user(:id, \"x:id;\", 1.5); call add_user(11, \"y\", 2) end",
+		"create procedure recurse() as begin call recurse() end",
+	} {
+		mustExec(t, db, sess, q)
+	}
+
+	// result of the last statement is returned
+	rows := query("call add_user(1, \"alice \\\"a\\\"\", 3)")
+	expected := []string{"1 alice \"a\" 3"}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("Expected %q, got %q", expected, rows)
+	}
+
+	// parameters inside string literals are kept as they are
+	rows = query("call add_twice(10)")
+	expected = []string{"11 y 2"}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("Expected %q, got %q", expected, rows)
+	}
+
+	check := func() {
+		expected := []string{"1 alice \"a\" 3", "10 x:id; 1.5", "11 y 2"}
+		users := query("select * from users")
+		if !reflect.DeepEqual(users, expected) {
+			t.Fatalf("Expected users %q, got %q", expected, users)
+		}
+
+		procedures := query("show procedures")
+		if len(procedures) != 3 || !strings.HasPrefix(procedures[0], "add_twice create procedure add_twice(id)") {
+			t.Fatalf("Unexpected procedures %q", procedures)
+		}
+	}
+	check()
+
+	// procedures are persisted
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	_, err = execQuery(t, db, sess, "call recurse()")
+	if err == nil || !strings.Contains(err.Error(), "nested too deep") {
+		t.Fatalf("Expected too deep calls, got %v", err)
+	}
+
+	// statements before the failed one stay applied
+	_, err = execQuery(t, db, sess, "call add_user(12, \"a very long name of the user\", 1)")
+	if err == nil || !strings.Contains(err.Error(), "statement #1 of procedure add_user") {
+		t.Fatalf("Expected failed statement, got %v", err)
+	}
+
+	for _, q := range []string{
+		"call missing()",
+		"call add_user(1)",
+		"create procedure add_user(id) as begin select * from users end",
+		"create procedure p(a, a) as begin select * from users end",
+		"create procedure p() as begin select * from users where id = :id end",
+		"create procedure p() as begin select * form users end",
+		"create procedure p() as begin create procedure q() as begin select * from users end end",
+		"drop procedure missing",
+	} {
+		_, err := execQuery(t, db, sess, q)
+		if err == nil {
+			t.Fatalf("Expected %v to fail", q)
+		}
+	}
+
+	// statements are executed with the privileges of the caller
+	for _, q := range []string{
+		"create table secrets (s varchar(10))",
+		"create procedure secrets() as begin select * from secrets end",
+		"grant select on users to bob",
+	} {
+		mustExec(t, db, sess, q)
+	}
+
+	sess = &Session{User: "bob"}
+	_, err = execQuery(t, db, sess, "call secrets()")
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("Expected access denied, got %v", err)
+	}
+	_, err = execQuery(t, db, sess, "drop procedure secrets")
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("Expected access denied, got %v", err)
+	}
+}
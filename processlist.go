@@ -0,0 +1,136 @@
+package dumbdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNoSuchQuery = errors.New("no such query")
+
+// Query registered in the process list, see Database.TrackQuery()
+type process struct {
+	id       int64
+	client   string
+	database string
+	query    string
+	start    time.Time
+	cancel   context.CancelFunc
+}
+
+// Queries being executed, which can be listed with `show processlist` and
+// cancelled with `kill <id>`
+type processList struct {
+	m         sync.Mutex
+	nextID    int64
+	processes map[int64]*process
+}
+
+func newProcessList() *processList {
+	return &processList{processes: make(map[int64]*process)}
+}
+
+// Register the query, returned context is cancelled once the query is killed.
+// done() has to be called once the query and its result rows are finished
+func (list *processList) add(ctx context.Context, client string, database string, query string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	list.m.Lock()
+	list.nextID++
+	p := &process{
+		id:       list.nextID,
+		client:   client,
+		database: database,
+		query:    query,
+		start:    time.Now(),
+		cancel:   cancel,
+	}
+	list.processes[p.id] = p
+	list.m.Unlock()
+
+	done := func() {
+		list.m.Lock()
+		delete(list.processes, p.id)
+		list.m.Unlock()
+		cancel()
+	}
+	return ctx, done
+}
+
+// Cancel the query with the given id
+func (list *processList) kill(id int64) error {
+	list.m.Lock()
+	defer list.m.Unlock()
+
+	p, ok := list.processes[id]
+	if !ok {
+		return fmt.Errorf("%w with id %v", ErrNoSuchQuery, id)
+	}
+
+	p.cancel()
+	return nil
+}
+
+// Running queries ordered by id
+func (list *processList) list() []process {
+	list.m.Lock()
+	defer list.m.Unlock()
+
+	processes := make([]process, 0, len(list.processes))
+	for _, p := range list.processes {
+		processes = append(processes, *p)
+	}
+
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].id < processes[j].id
+	})
+	return processes
+}
+
+// Register the query executed in the session, so that it's listed by `show
+// processlist` until done() is called. Returned context is cancelled by `kill`
+func (db *Database) TrackQuery(ctx context.Context, sess *Session, query string) (context.Context, func()) {
+	client := ""
+	if sess != nil {
+		client = sess.Client
+	}
+	return db.processes.add(ctx, client, sess.currentDatabase(), query)
+}
+
+func (db *Database) doShowProcessList() (*Result, error) {
+	var schema Schema
+	schema.addField(Field{Name: "id", TypeID: TypeBigint, Len: 8})
+	schema.addField(Field{Name: "client", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "database", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "elapsed_ms", TypeID: TypeBigint, Len: 8})
+	schema.addField(Field{Name: "query", TypeID: TypeVarchar, Len: 255})
+
+	processes := db.processes.list()
+	rows := make([]Row, 0, len(processes))
+	for _, p := range processes {
+		query := p.query
+		if len(query) > 255 {
+			query = query[:255]
+		}
+
+		rows = append(rows, Row{
+			BigintValue(p.id),
+			{TypeID: TypeVarchar, Str: p.client},
+			{TypeID: TypeVarchar, Str: p.database},
+			BigintValue(time.Since(p.start).Milliseconds()),
+			{TypeID: TypeVarchar, Str: query},
+		})
+	}
+
+	return &Result{
+		Schema: schema,
+		Rows:   StaticRows(rows),
+	}, nil
+}
+
+func (db *Database) doKill(kill *Kill) (*Result, error) {
+	return nil, db.processes.kill(kill.ID)
+}
@@ -0,0 +1,74 @@
+package dumbdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestKillQuery(t *testing.T) {
+	db, err := OpenDatabase(Options{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	admin := &Session{Client: "admin"}
+	values := ""
+	for i := 0; i < 5000; i++ {
+		if i != 0 {
+			values += ", "
+		}
+		values += fmt.Sprintf("(%v)", i)
+	}
+
+	for _, q := range []string{"create table t (id int)", "insert into t values " + values} {
+		mustExec(t, db, admin, q)
+	}
+
+	// the query is stopped after its first row
+	sess := &Session{Client: "reader"}
+	const q = "select * from t"
+	ctx, done := db.TrackQuery(context.Background(), sess, q)
+	query, err := ParseQuery(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.Execute(ctx, sess, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Rows.Next() {
+		t.Fatalf("Expected a row, got %v", result.Rows.Err())
+	}
+
+	processes, err := queryRows(t, db, admin, "show processlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processes) != 1 || processes[0][1].Str != "reader" || processes[0][2].Str != DefaultDatabase || processes[0][4].Str != q {
+		t.Fatalf("Expected the query in the process list, got %v", processes)
+	}
+
+	mustExec(t, db, admin, fmt.Sprintf("kill %v", processes[0][0].Int))
+
+	for result.Rows.Next() {
+	}
+	if !errors.Is(result.Rows.Err(), ErrQueryCancelled) {
+		t.Fatalf("Expected ErrQueryCancelled, got %v", result.Rows.Err())
+	}
+
+	// finished queries are removed from the list
+	done()
+	processes, err = queryRows(t, db, admin, "show processlist")
+	if err != nil || len(processes) != 0 {
+		t.Fatalf("Expected empty process list, got %v (%v)", processes, err)
+	}
+
+	_, err = execQuery(t, db, admin, "kill 1")
+	if !errors.Is(err, ErrNoSuchQuery) {
+		t.Fatalf("Expected ErrNoSuchQuery, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package dumbdb
+
+import "sync/atomic"
+
+// Progress of the query scanning a table
+type Progress struct {
+	PagesScanned int64
+	// pages of the table when the scan started, rows inserted during
+	// the scan may add more
+	PagesTotal int64
+}
+
+// Counters of the running scan, updated by the scan and read concurrently
+// Methods can be called on nil ScanProgress, updates are dropped then
+type ScanProgress struct {
+	scanned int64
+	total   int64
+}
+
+func (p *ScanProgress) begin(total int64) {
+	if p != nil {
+		atomic.StoreInt64(&p.total, total)
+	}
+}
+
+func (p *ScanProgress) pageScanned() {
+	if p != nil {
+		atomic.AddInt64(&p.scanned, 1)
+	}
+}
+
+// Current progress of the scan
+func (p *ScanProgress) Get() Progress {
+	if p == nil {
+		return Progress{}
+	}
+
+	return Progress{
+		PagesScanned: atomic.LoadInt64(&p.scanned),
+		PagesTotal:   atomic.LoadInt64(&p.total),
+	}
+}
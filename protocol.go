@@ -1,16 +1,230 @@
 package dumbdb
 
 import (
+	"bytes"
+	"compress/flate"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"net"
+	"sync"
+)
+
+// Version of the protocol spoken by this package. Clients which don't send
+// the handshake are assumed to speak version 0, i.e. plain queries and chunked results
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 0
+)
+
+// Optional features of the protocol, agreed on by the handshake
+const (
+	// results are streamed in several responses, see Response.More
+	CapChunkedResults uint32 = 1 << iota
+	// requests with several statements separated by semicolons, see SendResponses()
+	CapBatches
+	// rows encoded in binary instead of JSON, see EncodeRows()
+	CapBinaryRows
+	// messages over a size threshold are compressed, see CompressingConn
+	CapCompression
+	// several requests in flight, messages are tagged with request ids, see RequestConn
+	CapPipelining
+	// progress of long queries is reported while their results are streamed, see Response.Progress
+	CapProgress
+	// rows of copy from stdin are streamed by the client, see Response.CopyIn
+	CapCopyIn
+	// batches of copy from stdin reporting status of each row, see SendCopyBatch()
+	CapInsertBatch
+)
+
+// Capabilities implemented by this package
+const SupportedCapabilities = CapChunkedResults | CapBatches | CapBinaryRows | CapCompression | CapPipelining | CapProgress | CapCopyIn | CapInsertBatch
+
+var ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+// Prefix of the handshake message, it can't be mistaken for a query
+var handshakeMagic = []byte("\x00dumbdb\x00")
+
+// First message sent by the client, and the server's reply in Response.Handshake
+// with the version and capabilities to be used for the connection
+type Handshake struct {
+	Version      int    `json:"version"`
+	Capabilities uint32 `json:"capabilities"`
+
+	// user the client connects as and its password, sent only by the client.
+	// The password is checked by servers enforcing access control, it's sent
+	// in the clear, as the connection isn't encrypted
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// Send the handshake as the first message of the connection
+func SendHandshake(conn net.Conn, handshake *Handshake) error {
+	message, err := json.Marshal(handshake)
+	if err != nil {
+		return err
+	}
+
+	return SendMessage(conn, append(append([]byte{}, handshakeMagic...), message...))
+}
+
+// Returns the client handshake if the message is one, nil otherwise
+func ParseHandshake(message []byte) (*Handshake, error) {
+	if !bytes.HasPrefix(message, handshakeMagic) {
+		return nil, nil
+	}
+
+	var handshake Handshake
+	err := json.Unmarshal(message[len(handshakeMagic):], &handshake)
+	if err != nil {
+		return nil, err
+	}
+	return &handshake, nil
+}
+
+// Agree on the version and capabilities offered by the client,
+// capabilities are the ones enabled on the server
+func NegotiateHandshake(client *Handshake, capabilities uint32) (*Handshake, error) {
+	if client.Version < MinProtocolVersion {
+		return nil, fmt.Errorf("%w %v, min supported version is %v", ErrUnsupportedVersion, client.Version, MinProtocolVersion)
+	}
+
+	version := client.Version
+	if version > ProtocolVersion {
+		version = ProtocolVersion
+	}
+
+	negotiated := client.Capabilities & capabilities & SupportedCapabilities
+	if negotiated&CapPipelining != 0 {
+		// the stream would be read together with the other requests
+		negotiated &^= CapCopyIn | CapInsertBatch
+	}
+
+	return &Handshake{
+		Version:      version,
+		Capabilities: negotiated,
+	}, nil
+}
+
+// Message checking that the connection is alive, the server replies with an empty
+// response. Servers predating it reply with a syntax error, which serves the same purpose
+var pingMessage = []byte("\x00ping\x00")
+
+func SendPing(conn net.Conn) error {
+	return SendMessage(conn, pingMessage)
+}
+
+func IsPing(message []byte) bool {
+	return bytes.Equal(message, pingMessage)
+}
+
+const (
+	// set in the length prefix of compressed messages
+	compressedMessageFlag = 1 << 31
+
+	// messages smaller than this aren't worth compressing
+	DefaultCompressionThreshold = 16 * 1024
+
+	// length of the messages, compressed or not, has to fit beside the flag
+	MaxMessageSize = compressedMessageFlag - 1
+)
+
+// Connection which compresses messages larger than Threshold bytes sent by SendMessage(),
+// used once CapCompression is negotiated. Compressed messages are flagged in the length
+// prefix, RecvMessage() decompresses them only from such connections
+type CompressingConn struct {
+	net.Conn
+	Threshold int
+}
+
+func compressMessage(message []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(message)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Connection of a single request once CapPipelining is negotiated. Messages sent
+// through it are prefixed with the request id, and sending them is serialized with
+// the other requests sharing the connection
+type RequestConn struct {
+	net.Conn
+	ID uint32
+
+	// shared by all the requests of the connection
+	m *sync.Mutex
+}
+
+// Returns connection for the request with the id, m serializes writes to conn
+func NewRequestConn(conn net.Conn, id uint32, m *sync.Mutex) *RequestConn {
+	return &RequestConn{Conn: conn, ID: id, m: m}
+}
+
+// Prefix message with the request id
+func TagMessage(id uint32, message []byte) []byte {
+	tagged := make([]byte, 4+len(message))
+	binary.LittleEndian.PutUint32(tagged, id)
+	copy(tagged[4:], message)
+	return tagged
+}
+
+// Split message received over pipelined connection into the request id and the payload
+func UntagMessage(message []byte) (uint32, []byte, error) {
+	if len(message) < 4 {
+		return 0, nil, errors.New("message without request id")
+	}
+	return binary.LittleEndian.Uint32(message), message[4:], nil
+}
+
+var (
+	ErrMessageTooLarge = errors.New("message is too large")
+	// compressed message received before compression was negotiated
+	ErrUnexpectedCompression = errors.New("message is compressed, but compression isn't negotiated")
 )
 
 func SendMessage(conn net.Conn, message []byte) error {
+	if rc, ok := conn.(*RequestConn); ok {
+		rc.m.Lock()
+		defer rc.m.Unlock()
+		message = TagMessage(rc.ID, message)
+		conn = rc.Conn
+	}
+
+	if len(message) > MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+
+	length := uint32(len(message))
+	if cc, ok := conn.(*CompressingConn); ok && cc.Threshold > 0 && len(message) >= cc.Threshold {
+		compressed, err := compressMessage(message)
+		if err != nil {
+			return err
+		}
+
+		if len(compressed) < len(message) {
+			message = compressed
+			length = uint32(len(message)) | compressedMessageFlag
+		}
+	}
+
 	var lenbuf [4]byte
-	binary.LittleEndian.PutUint32(lenbuf[:], uint32(len(message)))
+	binary.LittleEndian.PutUint32(lenbuf[:], length)
 	n, err := conn.Write(lenbuf[:])
 	if err != nil {
 		return err
@@ -51,19 +265,475 @@ func RecvMessage(conn net.Conn) ([]byte, error) {
 		return nil, nil
 	}
 
+	compressed := responseLen&compressedMessageFlag != 0
+	responseLen &^= compressedMessageFlag
+	if _, ok := conn.(*CompressingConn); compressed && !ok {
+		return nil, ErrUnexpectedCompression
+	}
+
 	response := make([]byte, responseLen)
 	_, err = io.ReadFull(conn, response)
-	return response, err
+	if err != nil || !compressed {
+		return response, err
+	}
+
+	// don't let a small message inflate over the size of uncompressed ones
+	r := flate.NewReader(bytes.NewReader(response))
+	defer r.Close()
+	message, err := ioutil.ReadAll(io.LimitReader(r, MaxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(message) > MaxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+	return message, nil
 }
 
 type ResponseChunk struct {
+	// internal description of the columns, kept for clients predating Columns
 	Schema Schema
-	Rows   []Row
+	// description of the columns clients should rely on, see ColumnMetadata
+	Columns []ColumnMetadata `json:",omitempty"`
+	Rows    []Row
+
+	// rows in the binary encoding once CapBinaryRows is negotiated, Rows are
+	// empty then. They are decoded into Rows when the response is received
+	Data []byte `json:",omitempty"`
+}
+
+// Replace Rows with their binary encoding
+func (chunk *ResponseChunk) EncodeRows() error {
+	data, err := EncodeRows(chunk.Rows)
+	if err != nil {
+		return err
+	}
+
+	chunk.Data = data
+	chunk.Rows = nil
+	return nil
+}
+
+func (chunk *ResponseChunk) decodeRows() error {
+	if chunk == nil || chunk.Data == nil {
+		return nil
+	}
+
+	rows, err := DecodeRows(chunk.Data)
+	if err != nil {
+		return err
+	}
+
+	chunk.Rows = rows
+	chunk.Data = nil
+	return nil
+}
+
+// Version of the binary encoding of rows, the first byte of the encoded rows.
+// Decoding rejects other versions, so a new encoding needs a new version
+const RowEncodingVersion = 1
+
+// Tags of the values in the binary encoding, each value is its tag followed
+// by int32 (4 bytes), int64 (8 bytes), float64 (8 bytes) or bool (a byte)
+// in little endian, or by uvarint length and bytes of varchar without zero
+// padding. Null has no bytes after the tag
+const (
+	wireNull byte = iota
+	wireInt
+	wireBigint
+	wireFloat
+	wireBool
+	wireVarchar
+)
+
+var ErrInvalidRowEncoding = errors.New("invalid binary encoding of rows")
+
+// Encode rows in binary: the version, uvarint number of rows, and for each
+// row uvarint number of values followed by the values, see wireInt and others
+func EncodeRows(rows []Row) ([]byte, error) {
+	var buf [binary.MaxVarintLen64]byte
+	data := []byte{RowEncodingVersion}
+	appendUvarint := func(n uint64) {
+		data = append(data, buf[:binary.PutUvarint(buf[:], n)]...)
+	}
+
+	appendUvarint(uint64(len(rows)))
+	for _, row := range rows {
+		appendUvarint(uint64(len(row)))
+		for _, val := range row {
+			switch val.TypeID {
+			case TypeInt:
+				binary.LittleEndian.PutUint32(buf[:], uint32(int32(val.Int)))
+				data = append(append(data, wireInt), buf[:4]...)
+			case TypeBigint:
+				binary.LittleEndian.PutUint64(buf[:], uint64(val.Int))
+				data = append(append(data, wireBigint), buf[:8]...)
+			case TypeFloat:
+				binary.LittleEndian.PutUint64(buf[:], math.Float64bits(val.Float))
+				data = append(append(data, wireFloat), buf[:8]...)
+			case TypeBool:
+				data = append(data, wireBool, byte(val.Int))
+			case TypeVarchar:
+				str := val.StrVal()
+				data = append(data, wireVarchar)
+				appendUvarint(uint64(len(str)))
+				data = append(data, str...)
+			default:
+				return nil, fmt.Errorf("can't encode value of type %v", val.TypeID)
+			}
+		}
+	}
+	return data, nil
+}
+
+// Decode rows encoded by EncodeRows()
+func DecodeRows(data []byte) ([]Row, error) {
+	if len(data) == 0 || data[0] != RowEncodingVersion {
+		return nil, fmt.Errorf("%w: unsupported version", ErrInvalidRowEncoding)
+	}
+	data = data[1:]
+
+	uvarint := func() (uint64, error) {
+		n, size := binary.Uvarint(data)
+		if size <= 0 || n > uint64(len(data)) {
+			return 0, fmt.Errorf("%w: invalid length", ErrInvalidRowEncoding)
+		}
+		data = data[size:]
+		return n, nil
+	}
+	fixed := func(size int) ([]byte, error) {
+		if len(data) < size {
+			return nil, fmt.Errorf("%w: truncated value", ErrInvalidRowEncoding)
+		}
+		b := data[:size]
+		data = data[size:]
+		return b, nil
+	}
+
+	nRows, err := uvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]Row, 0, nRows)
+	for i := uint64(0); i < nRows; i++ {
+		nValues, err := uvarint()
+		if err != nil {
+			return nil, err
+		}
+
+		row := make(Row, 0, nValues)
+		for j := uint64(0); j < nValues; j++ {
+			tag, err := fixed(1)
+			if err != nil {
+				return nil, err
+			}
+
+			var val Value
+			var b []byte
+			switch tag[0] {
+			case wireInt:
+				b, err = fixed(4)
+				if err == nil {
+					val = IntValue(int32(binary.LittleEndian.Uint32(b)))
+				}
+			case wireBigint:
+				b, err = fixed(8)
+				if err == nil {
+					val = Value{TypeID: TypeBigint, Int: int64(binary.LittleEndian.Uint64(b))}
+				}
+			case wireFloat:
+				b, err = fixed(8)
+				if err == nil {
+					val = FloatValue(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+				}
+			case wireBool:
+				b, err = fixed(1)
+				if err == nil {
+					val = Value{TypeID: TypeBool, Int: int64(b[0])}
+				}
+			case wireVarchar:
+				var n uint64
+				n, err = uvarint()
+				if err == nil {
+					b, err = fixed(int(n))
+				}
+				if err == nil {
+					val = Value{TypeID: TypeVarchar, Str: string(b)}
+				}
+			case wireNull:
+				// values are never null so far
+				err = fmt.Errorf("%w: null values are not supported", ErrInvalidRowEncoding)
+			default:
+				err = fmt.Errorf("%w: unknown value tag %v", ErrInvalidRowEncoding, tag[0])
+			}
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, val)
+		}
+		rows = append(rows, row)
+	}
+
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%w: trailing bytes", ErrInvalidRowEncoding)
+	}
+	return rows, nil
+}
+
+// Server acknowledges rows streamed by copy from stdin every this many rows by default
+const DefaultCopyAckRows = 10000
+
+// Kinds of messages streamed by the client after copy from stdin, the first byte of the message
+const (
+	// followed by rows encoded by EncodeRows()
+	copyData byte = 'd'
+	// same as copyData, but rows which fail don't stop the others
+	copyBatch byte = 'b'
+	// all the rows are sent
+	copyDone byte = 'c'
+	// client gave up, followed by the reason
+	copyFail byte = 'f'
+)
+
+// Reply to copy from stdin, the client streams the rows with SendCopyData()
+// and ends the stream with SendCopyDone() or SendCopyFail()
+//
+// The server inserts the batches as they arrive and replies with Response.RowsCopied
+// and More set once at least AckRows rows were inserted since the last such reply,
+// so the client knows which batches are acknowledged. Once a batch fails the server
+// replies with the error right away and ignores the rest of the stream, otherwise it
+// replies with the total number of rows once the stream is done. No reply follows
+// the error or the total
+type CopyInStart struct {
+	// columns of the rows, in the order of the table
+	Columns []ColumnMetadata `json:"columns"`
+	AckRows int              `json:"ack_rows"`
+}
+
+// Send batch of rows of copy from stdin
+func SendCopyData(conn net.Conn, rows []Row) error {
+	data, err := EncodeRows(rows)
+	if err != nil {
+		return err
+	}
+	return SendMessage(conn, append([]byte{copyData}, data...))
+}
+
+// Send batch of rows of copy from stdin, which are inserted even if some of
+// them fail, see Table.InsertBatch(). The server replies to each such batch
+// with Response.RowErrors, RowsCopied and More set, unless the copy fails as
+// a whole. Requires CapInsertBatch
+func SendCopyBatch(conn net.Conn, rows []Row) error {
+	data, err := EncodeRows(rows)
+	if err != nil {
+		return err
+	}
+	return SendMessage(conn, append([]byte{copyBatch}, data...))
+}
+
+// End the stream of copy from stdin
+func SendCopyDone(conn net.Conn) error {
+	return SendMessage(conn, []byte{copyDone})
+}
+
+// Abort the stream of copy from stdin, rows inserted so far are kept
+func SendCopyFail(conn net.Conn, reason string) error {
+	return SendMessage(conn, append([]byte{copyFail}, reason...))
+}
+
+var ErrCopyFailed = errors.New("copy aborted by the client")
+
+// Parse message of the stream of copy from stdin, returns the rows of the
+// batch, io.EOF once the stream is done or ErrCopyFailed if it's aborted
+func ParseCopyMessage(message []byte) ([]Row, error) {
+	if len(message) == 0 {
+		return nil, errors.New("empty copy message")
+	}
+
+	switch message[0] {
+	case copyData, copyBatch:
+		return DecodeRows(message[1:])
+	case copyDone:
+		return nil, io.EOF
+	case copyFail:
+		return nil, fmt.Errorf("%w: %s", ErrCopyFailed, message[1:])
+	default:
+		return nil, fmt.Errorf("unknown copy message %q", message[0])
+	}
+}
+
+// Returns true if the message of the stream of copy from stdin was sent by SendCopyBatch()
+func IsCopyBatch(message []byte) bool {
+	return len(message) != 0 && message[0] == copyBatch
+}
+
+// Row of a batch sent by SendCopyBatch() which isn't inserted, or is inserted with an error
+type RowError struct {
+	// position of the row in the batch
+	Row   int       `json:"row"`
+	Error string    `json:"error"`
+	Code  ErrorCode `json:"code,omitempty"`
+	// the row is inserted, but the error followed, see RowStatus
+	Inserted bool `json:"inserted,omitempty"`
+}
+
+// Errors of the rows, which are the rows which aren't inserted, and the inserted
+// ones with an error
+func RowErrors(statuses []RowStatus) []RowError {
+	var errs []RowError
+	for i, status := range statuses {
+		if status.Err != nil {
+			errs = append(errs, RowError{Row: i, Error: status.Err.Error(), Code: ErrorCodeOf(status.Err), Inserted: status.Inserted})
+		}
+	}
+	return errs
+}
+
+// Chunk of the result with the given columns
+func NewResponseChunk(schema Schema, rows []Row) *ResponseChunk {
+	return &ResponseChunk{
+		Schema:  schema,
+		Columns: ColumnsMetadata(&schema),
+		Rows:    rows,
+	}
+}
+
+// Column of the result as described to clients, independent of the internal Schema
+type ColumnMetadata struct {
+	Name string `json:"name"`
+	// bool, int, bigint, float or varchar
+	Type string `json:"type"`
+	// max length in bytes of varchar values, 0 for other types
+	Length int `json:"length,omitempty"`
+	// columns are never nullable so far
+	Nullable bool `json:"nullable"`
+}
+
+func ColumnsMetadata(schema *Schema) []ColumnMetadata {
+	columns := make([]ColumnMetadata, 0, len(schema.Fields))
+	for _, field := range schema.Fields {
+		column := ColumnMetadata{Name: field.Name, Type: field.TypeID.String()}
+		if field.TypeID == TypeVarchar {
+			column.Length = int(field.Len)
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// Schema of the result described by the metadata, to decode the rows with
+func SchemaFromColumns(columns []ColumnMetadata) (Schema, error) {
+	var schema Schema
+	for _, column := range columns {
+		var field Field
+		switch column.Type {
+		case "bool":
+			field = BoolField(column.Name)
+		case "int":
+			field = IntField(column.Name)
+		case "bigint":
+			field = BigintField(column.Name)
+		case "float":
+			field = FloatField(column.Name)
+		case "varchar":
+			if column.Length < 0 || column.Length > math.MaxUint8 {
+				return Schema{}, fmt.Errorf("invalid length %v of column %v", column.Length, column.Name)
+			}
+			field = VarcharField(column.Name, uint8(column.Length))
+		default:
+			return Schema{}, fmt.Errorf("unknown type %q of column %v", column.Type, column.Name)
+		}
+		schema.addField(field)
+	}
+	return schema, nil
+}
+
+// Stable code of the error reported in Response.Code, clients should check it
+// rather than the message, which may change
+type ErrorCode string
+
+const (
+	ErrorCodeSyntax              ErrorCode = "syntax"
+	ErrorCodeNoSuchTable         ErrorCode = "no_such_table"
+	ErrorCodeTypeMismatch        ErrorCode = "type_mismatch"
+	ErrorCodeConstraintViolation ErrorCode = "constraint_violation"
+	ErrorCodeTimeout             ErrorCode = "timeout"
+	ErrorCodeCancelled           ErrorCode = "cancelled"
+	ErrorCodeAccessDenied        ErrorCode = "access_denied"
+	// any other error, e.g. an IO error
+	ErrorCodeInternal ErrorCode = "internal"
+)
+
+// Code of the error reported to the client
+func ErrorCodeOf(err error) ErrorCode {
+	var syntaxErr *SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return ErrorCodeSyntax
+	case errors.Is(err, ErrNoSuchTable), errors.Is(err, ErrTableDoesNotExist), errors.Is(err, ErrNoSuchView):
+		return ErrorCodeNoSuchTable
+	case errors.Is(err, ErrTypeMismatch):
+		return ErrorCodeTypeMismatch
+	case errors.Is(err, ErrConstraintViolation), errors.Is(err, ErrDuplicateKey), errors.Is(err, ErrKeyTooLarge),
+		errors.Is(err, ErrTableAlreadyExist), errors.Is(err, ErrViewAlreadyExist), errors.Is(err, ErrIndexAlreadyExist),
+		errors.Is(err, ErrDatabaseAlreadyExist):
+		return ErrorCodeConstraintViolation
+	case errors.Is(err, ErrStatementTimeout), errors.Is(err, ErrQueueTimeout):
+		return ErrorCodeTimeout
+	case errors.Is(err, ErrQueryCancelled):
+		return ErrorCodeCancelled
+	case errors.Is(err, ErrAccessDenied):
+		return ErrorCodeAccessDenied
+	default:
+		return ErrorCodeInternal
+	}
+}
+
+// Response reporting the error with its code
+func ErrorResponse(err error) *Response {
+	response := &Response{Error: err.Error(), Code: ErrorCodeOf(err)}
+	if errors.As(err, &response.SyntaxError) {
+		response.Detail = fmt.Sprintf("at line %v, column %v", response.SyntaxError.Line, response.SyntaxError.Column)
+		if response.SyntaxError.Token != "" {
+			response.Detail += fmt.Sprintf(" near %q", response.SyntaxError.Token)
+		}
+	}
+	return response
 }
 
 type Response struct {
 	Result *ResponseChunk `json:",omitempty"`
 	Error  string         `json:",omitempty"`
+
+	// code of the error, empty unless Error is set (or the server predates the codes)
+	Code ErrorCode `json:",omitempty"`
+	// optional context of the error, e.g. position of a syntax error
+	Detail string `json:",omitempty"`
+
+	// position of the error in the query, if it failed to parse
+	SyntaxError *SyntaxError `json:",omitempty"`
+
+	// true if more chunks of the same result follow this one
+	More bool `json:",omitempty"`
+
+	// sent periodically while the query runs, the rest of the response is
+	// empty and the result follows it
+	Progress *Progress `json:",omitempty"`
+
+	// value generated for auto-increment column by the last insert
+	LastInsertID int64 `json:",omitempty"`
+
+	// reply to copy from stdin, the client streams the rows once it's received
+	CopyIn *CopyInStart `json:",omitempty"`
+	// number of rows inserted by copy from stdin so far, see CopyInStart
+	RowsCopied int64 `json:",omitempty"`
+	// rows of the batch which aren't inserted, see SendCopyBatch()
+	RowErrors []RowError `json:",omitempty"`
+
+	// reply to the handshake
+	Handshake *Handshake `json:",omitempty"`
 }
 
 func SendResponse(conn net.Conn, response *Response) error {
@@ -80,16 +750,55 @@ func ReceiveResponse(conn net.Conn) (*Response, error) {
 	if err != nil {
 		return nil, err
 	}
+	return DecodeResponse(response)
+}
 
-	if len(response) == 0 {
+// Decode response, nil message means success without data
+func DecodeResponse(message []byte) (*Response, error) {
+	if len(message) == 0 {
 		return nil, nil
 	}
 
 	var result Response
-	err = json.Unmarshal(response, &result)
+	err := json.Unmarshal(message, &result)
 	if err != nil {
 		return nil, err
 	}
 
+	err = result.Result.decodeRows()
+	if err != nil {
+		return nil, err
+	}
 	return &result, nil
 }
+
+// Send responses to all the statements of a batch in a single message
+func SendResponses(conn net.Conn, responses []Response) error {
+	message, err := json.Marshal(responses)
+	if err != nil {
+		return err
+	}
+
+	return SendMessage(conn, message)
+}
+
+func ReceiveResponses(conn net.Conn) ([]Response, error) {
+	message, err := RecvMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []Response
+	err = json.Unmarshal(message, &responses)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range responses {
+		err = responses[i].Result.decodeRows()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return responses, nil
+}
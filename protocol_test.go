@@ -0,0 +1,236 @@
+package dumbdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageCompression(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	small := []byte("select * from users")
+	big := bytes.Repeat([]byte(`{"Str":"user","Int":0}`), DefaultCompressionThreshold)
+
+	go func() {
+		conn := &CompressingConn{Conn: server, Threshold: DefaultCompressionThreshold}
+		SendMessage(conn, small)
+		SendMessage(conn, big)
+		SendMessage(conn, big)
+	}()
+
+	conn := &CompressingConn{Conn: client}
+	message, err := RecvMessage(conn)
+	if err != nil || !bytes.Equal(message, small) {
+		t.Fatalf("Unexpected small message %q (%v)", message, err)
+	}
+
+	// length prefix of the compressed message is flagged
+	var lenbuf [4]byte
+	_, err = io.ReadFull(client, lenbuf[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	length := binary.LittleEndian.Uint32(lenbuf[:])
+	if length&compressedMessageFlag == 0 || int(length&^compressedMessageFlag) >= len(big)/10 {
+		t.Fatalf("Expected message of %v bytes to be compressed, got length prefix %x", len(big), length)
+	}
+
+	_, err = io.CopyN(io.Discard, client, int64(length&^compressedMessageFlag))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err = RecvMessage(conn)
+	if err != nil || !bytes.Equal(message, big) {
+		t.Fatalf("Unexpected big message of %v bytes (%v)", len(message), err)
+	}
+
+	// compressed messages are rejected until compression is negotiated
+	go SendMessage(&CompressingConn{Conn: server, Threshold: DefaultCompressionThreshold}, big)
+
+	_, err = RecvMessage(client)
+	if !errors.Is(err, ErrUnexpectedCompression) {
+		t.Fatalf("Expected ErrUnexpectedCompression, got %v", err)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		query string
+		code  ErrorCode
+	}{
+		{"create table users (id int, name varchar(5))", ""},
+		{"select * from", ErrorCodeSyntax},
+		{"select * from missing", ErrorCodeNoSuchTable},
+		{"insert into users values (\"foo\", \"bar\")", ErrorCodeTypeMismatch},
+		{"select * from users where id = \"foo\"", ErrorCodeTypeMismatch},
+		{"insert into users values (1, \"too long\")", ErrorCodeConstraintViolation},
+		{"insert into users (name) values (\"foo\")", ErrorCodeConstraintViolation},
+		{"create table users (id int)", ErrorCodeConstraintViolation},
+	}
+
+	for _, c := range cases {
+		query, err := ParseQuery(c.query)
+		if err == nil {
+			_, err = db.Execute(context.Background(), nil, query)
+		}
+
+		if c.code == "" {
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		response := ErrorResponse(err)
+		if response.Code != c.code || response.Error != err.Error() {
+			t.Errorf("%v: expected %v, got %+v", c.query, c.code, response)
+		}
+	}
+
+	_, err = execQuery(t, db, &Session{User: "alice"}, "select * from users")
+	if code := ErrorCodeOf(err); code != ErrorCodeAccessDenied {
+		t.Errorf("Expected %v, got %v (%v)", ErrorCodeAccessDenied, code, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	if code := ErrorCodeOf(CancellationError(ctx)); code != ErrorCodeTimeout {
+		t.Errorf("Expected %v, got %v", ErrorCodeTimeout, code)
+	}
+
+	if code := ErrorCodeOf(errors.New("disk is on fire")); code != ErrorCodeInternal {
+		t.Errorf("Expected %v, got %v", ErrorCodeInternal, code)
+	}
+
+	_, err = ParseQuery("select * frm users")
+	response := ErrorResponse(err)
+	if response.SyntaxError == nil || response.Detail != `at line 1, column 10 near "frm"` {
+		t.Errorf("Unexpected detail of syntax error %+v", response)
+	}
+}
+
+func TestColumnsMetadata(t *testing.T) {
+	var schema Schema
+	for _, field := range []Field{IntField("id"), BigintField("big"), FloatField("score"), BoolField("ok"), VarcharField("name", 20)} {
+		schema.addField(field)
+	}
+
+	columns := ColumnsMetadata(&schema)
+	expected := []ColumnMetadata{{"id", "int", 0, false}, {"big", "bigint", 0, false}, {"score", "float", 0, false}, {"ok", "bool", 0, false}, {"name", "varchar", 20, false}}
+	if len(columns) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, columns)
+	}
+	for i := range columns {
+		if columns[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected[i], columns[i])
+		}
+	}
+
+	decoded, err := SchemaFromColumns(columns)
+	if err != nil || len(decoded.Fields) != len(schema.Fields) || decoded.TotalLen != schema.TotalLen {
+		t.Fatalf("Expected %v, got %v (%v)", schema, decoded, err)
+	}
+	for i := range decoded.Fields {
+		if decoded.Fields[i] != schema.Fields[i] {
+			t.Fatalf("Expected %v, got %v", schema.Fields[i], decoded.Fields[i])
+		}
+	}
+
+	_, err = SchemaFromColumns([]ColumnMetadata{{Name: "id", Type: "decimal"}})
+	if err == nil {
+		t.Fatal("Expected unknown type to fail")
+	}
+}
+
+func TestRowEncoding(t *testing.T) {
+	rows := []Row{
+		{IntValue(-1), {TypeID: TypeBigint, Int: 1 << 40}, FloatValue(2.5), {TypeID: TypeBool, Int: 1}, {TypeID: TypeVarchar, Str: "foo\x00\x00"}},
+		{IntValue(0), {TypeID: TypeBigint, Int: -1}, FloatValue(0), {TypeID: TypeBool, Int: 0}, {TypeID: TypeVarchar, Str: ""}},
+	}
+
+	data, err := EncodeRows(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeRows(data)
+	if err != nil || len(decoded) != len(rows) {
+		t.Fatalf("Expected %v, got %v (%v)", rows, decoded, err)
+	}
+
+	rows[0][4].Str = "foo"
+	for i := range rows {
+		for j := range rows[i] {
+			if decoded[i][j] != rows[i][j] {
+				t.Fatalf("Expected %v, got %v", rows[i][j], decoded[i][j])
+			}
+		}
+	}
+
+	for _, invalid := range [][]byte{
+		nil,
+		{RowEncodingVersion + 1, 0},
+		{RowEncodingVersion, 1, 1, wireInt, 0},
+		{RowEncodingVersion, 1, 1, wireNull},
+		{RowEncodingVersion, 1, 1, 42},
+		{RowEncodingVersion, 0, 0},
+	} {
+		_, err = DecodeRows(invalid)
+		if !errors.Is(err, ErrInvalidRowEncoding) {
+			t.Errorf("Expected ErrInvalidRowEncoding for %v, got %v", invalid, err)
+		}
+	}
+}
+
+func TestCopyMessages(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go func() {
+		SendCopyData(client, []Row{{IntValue(1), {TypeID: TypeVarchar, Str: "foo"}}})
+		SendCopyFail(client, "bye")
+		SendCopyDone(client)
+	}()
+
+	var errs []error
+	for i := 0; i < 3; i++ {
+		message, err := RecvMessage(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := ParseCopyMessage(message)
+		if i == 0 && (err != nil || len(rows) != 1 || rows[0][1].Str != "foo") {
+			t.Fatalf("Expected a row, got %v (%v)", rows, err)
+		}
+		errs = append(errs, err)
+	}
+
+	if !errors.Is(errs[1], ErrCopyFailed) || !strings.Contains(errs[1].Error(), "bye") || !errors.Is(errs[2], io.EOF) {
+		t.Fatalf("Expected the copy to fail and finish, got %v", errs)
+	}
+
+	_, err := ParseCopyMessage([]byte("x"))
+	if err == nil {
+		t.Fatal("Expected unknown message to fail")
+	}
+}
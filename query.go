@@ -4,16 +4,22 @@ package dumbdb
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
 )
 
+// Keywords and unquoted identifiers are case-insensitive, they are
+// lowercased by the parser. Identifiers quoted with backticks are taken
+// as is, so they may contain upper case letters or clash with keywords
 var queryLexer = lexer.MustSimple([]lexer.Rule{
 	{Name: `Ident`, Pattern: `[a-zA-Z][a-zA-Z_\d]*`},
+	{Name: `QuotedIdent`, Pattern: "`[^`]+`"},
 	{Name: `String`, Pattern: `"(?:\\.|[^"])*"`},
+	// has to go before Int, otherwise integer part of a float is taken for an Int
+	{Name: `Float`, Pattern: `\d+\.\d+`},
 	{Name: `Int`, Pattern: `\d+`},
-	{Name: `Float`, Pattern: `\d+(?:\.\d+)?`},
 	{Name: `Operators`, Pattern: `<>|!=|<=|>=|[-+*/%,.()=<>]`},
 	{Name: "comment", Pattern: `[#;][^\n]*`},
 	{Name: "whitespace", Pattern: `\s+`},
@@ -21,27 +27,77 @@ var queryLexer = lexer.MustSimple([]lexer.Rule{
 
 type Type struct {
 	Integer bool `@"int"`
+	Bigint  bool `| @"bigint"`
+	Float   bool `| @"float"`
+	Serial  bool `| @"serial"`
 	Bool    bool `| @"bool"`
 	Varchar int  `| "varchar" "(" @Int ")"`
 }
 
 type FieldDescription struct {
-	Name string `@Ident`
-	Type *Type  `@@`
+	Name          string `@(Ident | QuotedIdent)`
+	Type          *Type  `@@`
+	AutoIncrement bool   `@"auto_increment"?`
 }
 
 type Create struct {
-	Table  string             `"create" "table" @Ident`
-	Fields []FieldDescription `"(" @@ ("," @@)*  ")"`
+	Table       string             `"create" "table" @(Ident | QuotedIdent)`
+	Fields      []FieldDescription `"(" @@ ("," @@)*  ")"`
+	Compression string             `("compression" "=" @Ident)?`
+	Engine      string             `("engine" "=" @Ident)?`
+	Encryption  string             `("encryption" "=" @Ident)?`
+	BloomFilter []string           `("bloom_filter" "=" "(" @(Ident | QuotedIdent) ("," @(Ident | QuotedIdent))* ")")?`
+	Dictionary  []string           `("dictionary" "=" "(" @(Ident | QuotedIdent) ("," @(Ident | QuotedIdent))* ")")?`
+	TTL         *TableTTL          `("with" "(" @@ ")")?`
+}
+
+// Rows expire once the column (unix time in seconds) is older than the duration
+type TableTTL struct {
+	Duration string `"ttl" "=" @String`
+	Column   string `"," "ttl_column" "=" @(Ident | QuotedIdent)`
 }
 
 type Drop struct {
-	Table string `"drop" "table" @Ident`
+	Table string `"drop" "table" @(Ident | QuotedIdent)`
+}
+
+// Secondary index on the columns of the table
+type CreateIndex struct {
+	Name    string   `"create" "index" @(Ident | QuotedIdent)`
+	Table   string   `"on" @(Ident | QuotedIdent)`
+	Columns []string `"(" @(Ident | QuotedIdent) ("," @(Ident | QuotedIdent))* ")"`
+}
+
+// Index names are unique within the database, so the table isn't specified
+type DropIndex struct {
+	Name string `"drop" "index" @(Ident | QuotedIdent)`
+}
+
+// List indexes of the current database with their tables and columns
+type ShowIndexes struct {
+	Indexes bool `"show" @"indexes"`
+}
+
+// Remove all rows from the table
+type Truncate struct {
+	Table string `"truncate" "table" @(Ident | QuotedIdent)`
+}
+
+// Compact pages of the table and release the free ones
+type Vacuum struct {
+	Table string `"vacuum" @(Ident | QuotedIdent)`
+}
+
+// Add or drop a column, rows are copied into a new table without blocking the table
+type Alter struct {
+	Table      string            `"alter" "table" @(Ident | QuotedIdent)`
+	AddColumn  *FieldDescription `( "add" "column" @@`
+	DropColumn string            `| "drop" "column" @(Ident | QuotedIdent) )`
 }
 
 type BoolVal bool
 
-func (val BoolVal) ToInt() int32 {
+func (val BoolVal) ToInt() int64 {
 	if val {
 		return 1
 	}
@@ -54,24 +110,31 @@ func (val *BoolVal) Capture(s []string) error {
 		*val = true
 	case "false":
 		*val = false
+	default:
+		return errors.New("bool can only be either true or false")
 	}
 
-	return errors.New("bool can only be either true or false")
+	return nil
 }
 
 // Same as Value, but based on pointers
 type Literal struct {
-	Int  *int32   `@Int`
-	Bool *BoolVal `| @("true" | "false")`
-	Str  *string  `| @String`
+	Float *float64 `@Float`
+	Int   *int64   `| @Int`
+	Bool  *BoolVal `| @("true":Ident | "false":Ident)`
+	Str   *string  `| @String`
 }
 
 func (val *Literal) ToValue() Value {
 	switch {
+	case val.Float != nil:
+		return FloatValue(*val.Float)
 	case val.Int != nil:
+		return IntegerValue(*val.Int)
+	case val.Bool != nil:
 		return Value{
-			TypeID: TypeInt,
-			Int:    *val.Int,
+			TypeID: TypeBool,
+			Int:    val.Bool.ToInt(),
 		}
 	case val.Str != nil:
 		return Value{
@@ -104,13 +167,43 @@ func ConvertRows(ptrs []Tuple) []Row {
 }
 
 type Insert struct {
-	Table string  `"insert" "into" @Ident`
-	Rows  []Tuple `"values" @@ ("," @@)*`
+	Table string `"insert" "into" @(Ident | QuotedIdent)`
+
+	// all the columns of the table in order if empty
+	Columns []string `("(" @(Ident | QuotedIdent) ("," @(Ident | QuotedIdent))* ")")?`
+	Rows    []Tuple  `"values" @@ ("," @@)*`
 }
 
 type Projection struct {
-	All    bool     `@"*"`
-	Fields []string `| @Ident ("," @Ident)*`
+	All   bool              `@"*"`
+	Items []*ProjectionItem `| @@ ("," @@)*`
+}
+
+// Selected column, e.g. u.id as user_id
+type ProjectionItem struct {
+	Column *ColumnRef `@@`
+	Alias  string     `("as" @(Ident | QuotedIdent))?`
+}
+
+// Name of the column in the result
+func (item *ProjectionItem) Name() string {
+	if item.Alias != "" {
+		return item.Alias
+	}
+	return item.Column.Name
+}
+
+// Column name, optionally qualified with table name or alias, e.g. u.id
+type ColumnRef struct {
+	Table string `((?= (Ident | QuotedIdent) ".") @(Ident | QuotedIdent) ".")?`
+	Name  string `@(Ident | QuotedIdent)`
+}
+
+func (ref *ColumnRef) String() string {
+	if ref.Table == "" {
+		return ref.Name
+	}
+	return ref.Table + "." + ref.Name
 }
 
 type Op int
@@ -130,6 +223,13 @@ const (
 
 	OpOr
 	OpAnd
+
+	OpLike
+	OpIn // right operand is a list, see BinOpNode
+
+	// unary, right operand is nil
+	OpNot
+	OpNeg
 )
 
 func (o Op) IsArithmetic() bool {
@@ -141,117 +241,166 @@ func (o Op) IsArithmetic() bool {
 	}
 }
 
-func (o Op) Apply(left Value, right Value) Value {
+func (o Op) IsUnary() bool {
+	return o == OpNot || o == OpNeg
+}
+
+func (o Op) IsComparison() bool {
 	switch o {
-	case OpAdd:
-		if left.TypeID == TypeVarchar {
-			return Value{
-				TypeID: TypeVarchar,
-				Str:    left.StrVal() + right.StrVal(),
-			}
-		}
+	case OpEq, OpNotEq, OpLess, OpLessOrEq, OpGreater, OpGreaterOrEq:
+		return true
+	default:
+		return false
+	}
+}
 
-		return Value{
-			TypeID: TypeInt,
-			Int:    left.Int + right.Int,
-		}
-	case OpSub:
-		return Value{
-			TypeID: TypeInt,
-			Int:    left.Int - right.Int,
-		}
-	case OpMul:
-		return Value{
-			TypeID: TypeInt,
-			Int:    left.Int * right.Int,
-		}
-	case OpDiv:
-		return Value{
-			TypeID: TypeInt,
-			Int:    left.Int / right.Int,
-		}
-	case OpEq:
-		if left.TypeID == TypeVarchar {
-			return Value{
-				TypeID: TypeBool,
-				Int:    BoolVal(left.StrVal() == right.StrVal()).ToInt(),
-			}
-		}
+// Returns op with swapped operands, i.e. (a op b) == (b op.Mirror() a)
+// requires o.IsComparison()
+func (o Op) Mirror() Op {
+	switch o {
+	case OpLess:
+		return OpGreater
+	case OpLessOrEq:
+		return OpGreaterOrEq
+	case OpGreater:
+		return OpLess
+	case OpGreaterOrEq:
+		return OpLessOrEq
+	default:
+		return o
+	}
+}
 
-		return Value{
-			TypeID: TypeBool,
-			Int:    BoolVal(left.Int == right.Int).ToInt(),
-		}
+// Returns op which holds iff o doesn't, i.e. not (a op b) == (a op.Negate() b)
+// requires o.IsComparison()
+func (o Op) Negate() Op {
+	switch o {
+	case OpEq:
+		return OpNotEq
 	case OpNotEq:
-		if left.TypeID == TypeVarchar {
-			return Value{
-				TypeID: TypeBool,
-				Int:    BoolVal(left.StrVal() != right.StrVal()).ToInt(),
-			}
-		}
-
-		return Value{
-			TypeID: TypeBool,
-			Int:    BoolVal(left.Int != right.Int).ToInt(),
-		}
+		return OpEq
 	case OpLess:
-		if left.TypeID == TypeVarchar {
-			return Value{
-				TypeID: TypeBool,
-				Int:    BoolVal(left.StrVal() < right.StrVal()).ToInt(),
-			}
-		}
-
-		return Value{
-			TypeID: TypeBool,
-			Int:    BoolVal(left.Int < right.Int).ToInt(),
-		}
+		return OpGreaterOrEq
 	case OpLessOrEq:
-		if left.TypeID == TypeVarchar {
-			return Value{
-				TypeID: TypeBool,
-				Int:    BoolVal(left.StrVal() <= right.StrVal()).ToInt(),
-			}
-		}
-
-		return Value{
-			TypeID: TypeBool,
-			Int:    BoolVal(left.Int <= right.Int).ToInt(),
-		}
+		return OpGreater
 	case OpGreater:
-		if left.TypeID == TypeVarchar {
-			return Value{
-				TypeID: TypeBool,
-				Int:    BoolVal(left.StrVal() > right.StrVal()).ToInt(),
-			}
-		}
+		return OpLessOrEq
+	case OpGreaterOrEq:
+		return OpLess
+	default:
+		panic("not a comparison op")
+	}
+}
 
-		return Value{
-			TypeID: TypeBool,
-			Int:    BoolVal(left.Int > right.Int).ToInt(),
-		}
+// Check whether comparison holds given the result of compare(left, right)
+// requires o.IsComparison()
+func (o Op) Holds(cmp int) bool {
+	switch o {
+	case OpEq:
+		return cmp == 0
+	case OpNotEq:
+		return cmp != 0
+	case OpLess:
+		return cmp < 0
+	case OpLessOrEq:
+		return cmp <= 0
+	case OpGreater:
+		return cmp > 0
 	case OpGreaterOrEq:
+		return cmp >= 0
+	default:
+		panic("not a comparison op")
+	}
+}
+
+// Result of arithmetic op on numbers, computed as float if one of the operands is float
+func arithmeticResult(left Value, right Value, ints func(a, b int64) int64, floats func(a, b float64) float64) Value {
+	if left.TypeID == TypeFloat || right.TypeID == TypeFloat {
+		return FloatValue(floats(left.ToFloat(), right.ToFloat()))
+	}
+	return integerResult(left, right, ints(left.Int, right.Int))
+}
+
+// Result of arithmetic op on integers, wrapped around to 32 bits unless one of operands is bigint
+func integerResult(left Value, right Value, n int64) Value {
+	if left.TypeID == TypeBigint || right.TypeID == TypeBigint {
+		return Value{TypeID: TypeBigint, Int: n}
+	}
+	return Value{TypeID: TypeInt, Int: int64(int32(n))}
+}
+
+// Result of the logic op when it's decided by the left operand alone,
+// so that the right one isn't evaluated
+func (o Op) shortCircuit(left Value) (Value, bool) {
+	switch {
+	case o == OpAnd && left.Int == 0:
+		return Value{TypeID: TypeBool, Int: 0}, true
+	case o == OpOr && left.Int != 0:
+		return Value{TypeID: TypeBool, Int: 1}, true
+	default:
+		return Value{}, false
+	}
+}
+
+// Result of the op on the operands, right one is ignored by unary ops
+func (o Op) Apply(left Value, right Value) (Value, error) {
+	switch o {
+	case OpAdd:
 		if left.TypeID == TypeVarchar {
 			return Value{
-				TypeID: TypeBool,
-				Int:    BoolVal(left.StrVal() >= right.StrVal()).ToInt(),
-			}
+				TypeID: TypeVarchar,
+				Str:    left.StrVal() + right.StrVal(),
+			}, nil
 		}
 
+		return arithmeticResult(left, right,
+			func(a, b int64) int64 { return a + b },
+			func(a, b float64) float64 { return a + b }), nil
+	case OpSub:
+		return arithmeticResult(left, right,
+			func(a, b int64) int64 { return a - b },
+			func(a, b float64) float64 { return a - b }), nil
+	case OpMul:
+		return arithmeticResult(left, right,
+			func(a, b int64) int64 { return a * b },
+			func(a, b float64) float64 { return a * b }), nil
+	case OpDiv:
+		if right.ToFloat() == 0 {
+			return Value{}, ErrDivisionByZero
+		}
+		return arithmeticResult(left, right,
+			func(a, b int64) int64 { return a / b },
+			func(a, b float64) float64 { return a / b }), nil
+	case OpEq, OpNotEq, OpLess, OpLessOrEq, OpGreater, OpGreaterOrEq:
 		return Value{
 			TypeID: TypeBool,
-			Int:    BoolVal(left.Int >= right.Int).ToInt(),
-		}
+			Int:    BoolVal(o.Holds(compareValues(&left, &right))).ToInt(),
+		}, nil
 	case OpOr:
 		return Value{
 			TypeID: TypeBool,
 			Int:    BoolVal(left.Int != 0 || right.Int != 0).ToInt(),
-		}
+		}, nil
 	case OpAnd:
 		return Value{
 			TypeID: TypeBool,
 			Int:    BoolVal(left.Int != 0 && right.Int != 0).ToInt(),
+		}, nil
+	case OpLike:
+		return Value{
+			TypeID: TypeBool,
+			Int:    BoolVal(MatchLike(left.StrVal(), right.StrVal())).ToInt(),
+		}, nil
+	case OpNot:
+		return Value{
+			TypeID: TypeBool,
+			Int:    BoolVal(left.Int == 0).ToInt(),
+		}, nil
+	case OpNeg:
+		if left.TypeID == TypeFloat {
+			return FloatValue(-left.Float), nil
 		}
+		return integerResult(left, left, -left.Int), nil
 	default:
 		panic("unhandled op")
 	}
@@ -283,6 +432,14 @@ func (o Op) String() string {
 		return "or"
 	case OpAnd:
 		return "and"
+	case OpLike:
+		return "like"
+	case OpIn:
+		return "in"
+	case OpNot:
+		return "not"
+	case OpNeg:
+		return "-"
 	default:
 		return "<unknown op>"
 	}
@@ -324,9 +481,17 @@ func (o *Op) Capture(s []string) error {
 }
 
 type ComplexValue struct {
-	Const   *Literal    `@@`
-	Field   string      `| @Ident`
-	Subexpr *Expression `| "(" @@ ")"`
+	Const   *Literal      `@@`
+	Call    *Call         `| @@`
+	Field   *ColumnRef    `| @@`
+	Subexpr *Expression   `| "(" @@ ")"`
+	Neg     *ComplexValue `| "-" @@`
+}
+
+// Call of the scalar function, e.g. length(name)
+type Call struct {
+	Name string        `@Ident "("`
+	Args []*Expression `(@@ ("," @@)*)? ")"`
 }
 
 type Factor struct {
@@ -350,8 +515,61 @@ type OpTerm struct {
 }
 
 type Comp struct {
-	Left *Term     `@@`
-	Rest []*OpComp `@@*`
+	Left  *Term     `@@`
+	Range *Range    `@@?`
+	Rest  []*OpComp `@@*`
+}
+
+// Comparisons with special syntax
+type Range struct {
+	Between *Between `@@`
+	In      *In      `| @@`
+	Like    *Like    `| @@`
+	Is      *Is      `| @@`
+}
+
+type Between struct {
+	Low  *Term `"between" @@`
+	High *Term `"and" @@`
+}
+
+type In struct {
+	Values []*Term `"in" "(" @@ ("," @@)* ")"`
+}
+
+type Like struct {
+	Pattern *Term `"like" @@`
+}
+
+// Test for NULL, it's parsed only to be rejected with a clear error: columns
+// can't hold NULL and there is no null literal yet
+type Is struct{}
+
+func (*Is) Parse(lex *lexer.PeekingLexer) error {
+	is, err := lex.Peek(0)
+	if err != nil {
+		return err
+	}
+	if is.Value != "is" {
+		return participle.NextMatch
+	}
+
+	// tokens are consumed, so that the error is the deepest one and is reported
+	lex.Next()
+	if token, _ := lex.Peek(0); token.Value == "not" {
+		lex.Next()
+	}
+
+	token, err := lex.Peek(0)
+	if err != nil {
+		return err
+	}
+	if token.Value != "null" {
+		return participle.UnexpectedTokenError{Unexpected: token}
+	}
+
+	lex.Next()
+	return participle.Errorf(is.Pos, "is null and is not null are not supported, values can't be NULL")
 }
 
 type OpComp struct {
@@ -359,8 +577,14 @@ type OpComp struct {
 	Right *Comp `@@`
 }
 
+// Comparison, optionally negated with not
+type Negation struct {
+	Not  *Negation `"not" @@`
+	Comp *Comp     `| @@`
+}
+
 type Conj struct {
-	Left *Comp     `@@`
+	Left *Negation `@@`
 	Rest []*OpConj `@@*`
 }
 
@@ -392,6 +616,10 @@ type OpDisj struct {
 // Arithm ::= Term ('+' Term | '-' Term)*
 // Term ::= Factor ('*' Factor | '/' Factor | '%' Factor)*
 // Factor ::= ['-'] (Var | Number | '(' Expr ')')
+//
+// NOT binds weaker than comparisons, but stronger than AND:
+// Conj ::= Negation ('&&' Negation)*
+// Negation ::= 'not' Negation | Comp
 type Expression struct {
 	Left *Disj     `@@`
 	Rest []*OpDisj `@@*`
@@ -401,11 +629,20 @@ type BinOpNode struct {
 	Op    Op
 	Left  *BinOpTree
 	Right *BinOpTree
+
+	// right operand of OpIn
+	List []*BinOpTree
+}
+
+type FunctionCall struct {
+	Name string // lowercase
+	Args []*BinOpTree
 }
 
 type BinOpTree struct {
 	val     *ComplexValue
 	subtree *BinOpNode
+	call    *FunctionCall
 }
 
 func (e *ComplexValue) ToBinOp() *BinOpTree {
@@ -413,6 +650,40 @@ func (e *ComplexValue) ToBinOp() *BinOpTree {
 		return e.Subexpr.ToBinOp()
 	}
 
+	if e.Call != nil {
+		args := make([]*BinOpTree, 0, len(e.Call.Args))
+		for _, arg := range e.Call.Args {
+			args = append(args, arg.ToBinOp())
+		}
+
+		return &BinOpTree{
+			call: &FunctionCall{
+				Name: strings.ToLower(e.Call.Name),
+				Args: args,
+			},
+		}
+	}
+
+	if e.Neg != nil {
+		operand := e.Neg.ToBinOp()
+		// fold negative constants, so that they can be used by the planner
+		if operand.val != nil && operand.val.Const != nil && operand.val.Const.Int != nil {
+			n := -*operand.val.Const.Int
+			return &BinOpTree{
+				val: &ComplexValue{Const: &Literal{Int: &n}},
+			}
+		}
+
+		if operand.val != nil && operand.val.Const != nil && operand.val.Const.Float != nil {
+			f := -*operand.val.Const.Float
+			return &BinOpTree{
+				val: &ComplexValue{Const: &Literal{Float: &f}},
+			}
+		}
+
+		return binOp(OpNeg, operand, nil)
+	}
+
 	return &BinOpTree{
 		val: e,
 	}
@@ -468,14 +739,53 @@ func (e *Term) ToBinOp() *BinOpTree {
 	return current.subtree.Left
 }
 
+func binOp(op Op, left *BinOpTree, right *BinOpTree) *BinOpTree {
+	return &BinOpTree{
+		subtree: &BinOpNode{
+			Op:    op,
+			Left:  left,
+			Right: right,
+		},
+	}
+}
+
+func (e *Range) ToBinOp(left *BinOpTree) *BinOpTree {
+	switch {
+	case e.Between != nil:
+		// x between a and b is the same as x >= a and x <= b
+		return binOp(OpAnd,
+			binOp(OpGreaterOrEq, left, e.Between.Low.ToBinOp()),
+			binOp(OpLessOrEq, left, e.Between.High.ToBinOp()),
+		)
+	case e.In != nil:
+		list := make([]*BinOpTree, 0, len(e.In.Values))
+		for _, val := range e.In.Values {
+			list = append(list, val.ToBinOp())
+		}
+
+		tree := binOp(OpIn, left, nil)
+		tree.subtree.List = list
+		return tree
+	case e.Like != nil:
+		return binOp(OpLike, left, e.Like.Pattern.ToBinOp())
+	}
+
+	panic("empty range node")
+}
+
 func (e *Comp) ToBinOp() *BinOpTree {
+	left := e.Left.ToBinOp()
+	if e.Range != nil {
+		left = e.Range.ToBinOp(left)
+	}
+
 	if len(e.Rest) == 0 {
-		return e.Left.ToBinOp()
+		return left
 	}
 
 	current := &BinOpTree{
 		subtree: &BinOpNode{
-			Left:  e.Left.ToBinOp(),
+			Left:  left,
 			Right: nil,
 		},
 	}
@@ -493,6 +803,13 @@ func (e *Comp) ToBinOp() *BinOpTree {
 	return current.subtree.Left
 }
 
+func (e *Negation) ToBinOp() *BinOpTree {
+	if e.Not != nil {
+		return binOp(OpNot, e.Not.ToBinOp(), nil)
+	}
+	return e.Comp.ToBinOp()
+}
+
 func (e *Conj) ToBinOp() *BinOpTree {
 	if len(e.Rest) == 0 {
 		return e.Left.ToBinOp()
@@ -569,29 +886,513 @@ func (e *Expression) ToBinOp() *BinOpTree {
 }
 
 type Select struct {
-	Projection Projection  `"select" @@`
-	Table      string      `"from" @Ident`
-	Where      *Expression `["where" @@]`
+	Pos    lexer.Position
+	EndPos lexer.Position
+
+	Distinct   bool         `"select" @"distinct":Ident?`
+	Projection Projection   `@@`
+	Table      string       `"from" @(Ident | QuotedIdent)`
+	Alias      string       `("as" @(Ident | QuotedIdent) | (?! "where":Ident | "order":Ident | "limit":Ident) @(Ident | QuotedIdent))?`
+	Where      *Expression  `["where" @@]`
+	OrderBy    []*OrderItem `["order" "by" @@ ("," @@)*]`
+	Limit      *int64       `["limit" @Int]`
+}
+
+// Column the rows are sorted by, e.g. id desc
+type OrderItem struct {
+	Column *ColumnRef `@@`
+	Desc   bool       `("asc" | @"desc")?`
+}
+
+// Name columns of the table can be qualified with
+func (q *Select) Qualifier() string {
+	if q.Alias != "" {
+		return q.Alias
+	}
+	return q.Table
+}
+
+type Analyze struct {
+	Table string `"analyze" @(Ident | QuotedIdent)`
+}
+
+type Explain struct {
+	Select *Select `"explain" @@`
+}
+
+// Bulk load CSV file (on the server side) into the table, or rows streamed
+// by the client with copy from stdin, see Result.CopyIn
+type CopyFrom struct {
+	Table    string `"copy" @(Ident | QuotedIdent)`
+	Stdin    bool   `"from" ( @"stdin"`
+	Filename string `| @String )`
+	Header   bool   `@"header"?`
+}
+
+// Export result of the query to a file (on the server side)
+type CopyTo struct {
+	Select   *Select `"copy" "(" @@ ")"`
+	Filename string  `"to" @String`
+	Format   string  `["format" @("csv" | "json")]`
+}
+
+// Change value of the session variable
+type Set struct {
+	Name  string  `"set" @(Ident | QuotedIdent)`
+	Value Literal `"=" @@`
+}
+
+// Open cursor over the result of the select, its rows are read by fetch
+type Declare struct {
+	Name   string  `"declare" @(Ident | QuotedIdent) "cursor" "for"`
+	Select *Select `@@`
+}
+
+// Read the next rows of the cursor, a single one by default
+type Fetch struct {
+	Count *int64 `"fetch" @Int?`
+	Name  string `"from" @(Ident | QuotedIdent)`
+}
+
+type CloseCursor struct {
+	Name string `"close" @(Ident | QuotedIdent)`
+}
+
+type CreateDatabase struct {
+	Name string `"create" "database" @(Ident | QuotedIdent)`
+}
+
+type DropDatabase struct {
+	Name string `"drop" "database" @(Ident | QuotedIdent)`
+}
+
+type Use struct {
+	Database string `"use" @(Ident | QuotedIdent)`
+}
+
+// List tables of the current database
+type ShowTables struct {
+	Tables bool `"show" @"tables"`
+}
+
+// List session variables and their values
+type ShowVariables struct {
+	Variables bool `"show" @"variables"`
+}
+
+// Number of rows and pages of each table of the current database
+type ShowTableStatus struct {
+	Status bool `"show" "table" @"status"`
+}
+
+// List columns of the table
+type Describe struct {
+	Table string `("describe" | "desc") @(Ident | QuotedIdent)`
+}
+
+// Named select stored in the catalog, it can be selected from like a table
+type CreateView struct {
+	Name   string  `"create" "view" @(Ident | QuotedIdent)`
+	Select *Select `"as" @@`
+
+	// source text of the select, set by ParseQuery
+	Definition string
+}
+
+type DropView struct {
+	Name string `"drop" "view" @(Ident | QuotedIdent)`
+}
+
+// List views of the current database with their definitions
+type ShowViews struct {
+	Views bool `"show" @"views"`
+}
+
+// Action run for each row inserted into the table. Before triggers change the
+// row or reject the insert, after triggers insert rows into other tables
+type CreateTrigger struct {
+	Name   string         `"create" "trigger" @(Ident | QuotedIdent)`
+	Timing string         `@("before" | "after")`
+	Event  string         `@("insert" | "update" | "delete")`
+	Table  string         `"on" @(Ident | QuotedIdent) "for" "each" "row"`
+	When   *Expression    `("when" "(" @@ ")")?`
+	Action *TriggerAction `@@`
+
+	// source text of the statement, set by ParseQuery
+	Definition string
+}
+
+// Statement of the trigger, columns of the row are referenced as new.column
+type TriggerAction struct {
+	Set    []*Assignment  `"set" @@ ("," @@)*`
+	Raise  *string        `| "raise" "error" @String`
+	Insert *TriggerInsert `| @@`
+}
+
+// Value of the column set by the trigger, e.g. new.name = lower(name)
+type Assignment struct {
+	Column *ColumnRef  `@@ "="`
+	Value  *Expression `@@`
+}
+
+// Insert of a single row into another table, e.g. for auditing
+type TriggerInsert struct {
+	Table string `"insert" "into" @(Ident | QuotedIdent)`
+
+	// all the columns of the table in order if empty
+	Columns []string      `("(" @(Ident | QuotedIdent) ("," @(Ident | QuotedIdent))* ")")?`
+	Values  []*Expression `"values" "(" @@ ("," @@)* ")"`
+}
+
+type DropTrigger struct {
+	Name string `"drop" "trigger" @(Ident | QuotedIdent)`
+}
+
+// List triggers of the current database with their definitions
+type ShowTriggers struct {
+	Triggers bool `"show" @"triggers"`
+}
+
+// Statements stored under a name and executed by call. The body isn't parsed
+// with the rest of the statement, its statements are kept as text, so that
+// they are parsed once parameters (:name) are replaced with the values of the call
+type CreateProcedure struct {
+	Name   string   `"create" "procedure" @(Ident | QuotedIdent)`
+	Params []string `"(" (@(Ident | QuotedIdent) ("," @(Ident | QuotedIdent))*)? ")" "as" "begin" "end"`
+
+	// statements between begin and end, set by ParseQuery
+	Body []string
+	// source text of the statement, set by ParseQuery
+	Definition string
+}
+
+type DropProcedure struct {
+	Name string `"drop" "procedure" @(Ident | QuotedIdent)`
+}
+
+// List procedures of the current database with their definitions
+type ShowProcedures struct {
+	Procedures bool `"show" @"procedures"`
+}
+
+// Execute statements of the procedure with the values of its parameters
+type CallProcedure struct {
+	Name string    `"call" @(Ident | QuotedIdent)`
+	Args []Literal `"(" (@@ ("," @@)*)? ")"`
+}
+
+// Write snapshot of all the databases to a file (on the server side)
+type Backup struct {
+	Filename string `"backup" "to" @String`
+}
+
+// List queries being executed
+type ShowProcessList struct {
+	ProcessList bool `"show" @"processlist"`
+}
+
+// Cancel query with the id from `show processlist`
+type Kill struct {
+	ID int64 `"kill" @Int`
+}
+
+// Give privileges on the table (or all the tables with *) of the current database to the user
+type Grant struct {
+	Privileges []string `"grant" @("select" | "insert" | "update" | "delete" | "ddl" | "all") ("," @("select" | "insert" | "update" | "delete" | "ddl" | "all"))*`
+	Table      string   `"on" @(Ident | QuotedIdent | "*")`
+	User       string   `"to" @(Ident | QuotedIdent)`
+}
+
+type Revoke struct {
+	Privileges []string `"revoke" @("select" | "insert" | "update" | "delete" | "ddl" | "all") ("," @("select" | "insert" | "update" | "delete" | "ddl" | "all"))*`
+	Table      string   `"on" @(Ident | QuotedIdent | "*")`
+	User       string   `"from" @(Ident | QuotedIdent)`
+}
+
+// List privileges granted on the tables of the current database
+type ShowGrants struct {
+	Grants bool `"show" @"grants"`
 }
 
 // see https://sqlite.org/syntaxdiagrams.html
 type Query struct {
-	Create *Create `@@`
-	Drop   *Drop   `| @@`
-	Insert *Insert `| @@`
-	Select *Select `| @@`
+	Create   *Create   `@@`
+	Drop     *Drop     `| @@`
+	Truncate *Truncate `| @@`
+	Vacuum   *Vacuum   `| @@`
+	Alter    *Alter    `| @@`
+	Insert   *Insert   `| @@`
+	Select   *Select   `| @@`
+	Analyze  *Analyze  `| @@`
+	Explain  *Explain  `| @@`
+	Set      *Set      `| @@`
+	CopyFrom *CopyFrom `| @@`
+	CopyTo   *CopyTo   `| @@`
+
+	CreateView  *CreateView  `| @@`
+	DropView    *DropView    `| @@`
+	CreateIndex *CreateIndex `| @@`
+	DropIndex   *DropIndex   `| @@`
+
+	ShowTables      *ShowTables      `| @@`
+	ShowTableStatus *ShowTableStatus `| @@`
+	ShowVariables   *ShowVariables   `| @@`
+	ShowViews       *ShowViews       `| @@`
+	ShowIndexes     *ShowIndexes     `| @@`
+	Describe        *Describe        `| @@`
+
+	CreateDatabase *CreateDatabase `| @@`
+	DropDatabase   *DropDatabase   `| @@`
+	Use            *Use            `| @@`
+	Backup         *Backup         `| @@`
+
+	ShowProcessList *ShowProcessList `| @@`
+	Kill            *Kill            `| @@`
+
+	Declare *Declare     `| @@`
+	Fetch   *Fetch       `| @@`
+	Close   *CloseCursor `| @@`
+
+	Grant      *Grant      `| @@`
+	Revoke     *Revoke     `| @@`
+	ShowGrants *ShowGrants `| @@`
+
+	CreateTrigger *CreateTrigger `| @@`
+	DropTrigger   *DropTrigger   `| @@`
+	ShowTriggers  *ShowTriggers  `| @@`
+
+	CreateProcedure *CreateProcedure `| @@`
+	DropProcedure   *DropProcedure   `| @@`
+	ShowProcedures  *ShowProcedures  `| @@`
+	Call            *CallProcedure   `| @@`
+
+	// source text of the statement, set by ParseQuery
+	Text string
 }
 
-var parser = participle.MustBuild(&Query{},
+var parserOptions = []participle.Option{
 	participle.Lexer(queryLexer),
 	participle.Unquote("String"),
-)
+	participle.Map(func(token lexer.Token) (lexer.Token, error) {
+		token.Value = strings.ToLower(token.Value)
+		return token, nil
+	}, "Ident"),
+	participle.Map(func(token lexer.Token) (lexer.Token, error) {
+		token.Value = token.Value[1 : len(token.Value)-1]
+		return token, nil
+	}, "QuotedIdent"),
+}
+
+var parser = participle.MustBuild(&Query{}, parserOptions...)
+
+// Match string against SQL like pattern, where % matches any sequence
+// of characters and _ matches a single character
+func MatchLike(s string, pattern string) bool {
+	// position in s and pattern right after the last %, for backtracking
+	star, next := -1, 0
+	i, j := 0, 0
+	for i < len(s) {
+		switch {
+		case j < len(pattern) && pattern[j] == '%':
+			star = j
+			next = i
+			j++
+		case j < len(pattern) && (pattern[j] == '_' || pattern[j] == s[i]):
+			i++
+			j++
+		case star != -1:
+			// let the last % match one more character
+			next++
+			i = next
+			j = star + 1
+		default:
+			return false
+		}
+	}
+
+	for j < len(pattern) && pattern[j] == '%' {
+		j++
+	}
+	return j == len(pattern)
+}
+
+// Split script into statements separated by semicolons, ignoring semicolons
+// inside string literals. Empty statements are dropped
+func SplitStatements(script string) []string {
+	var statements []string
+	add := func(statement string) {
+		statement = strings.TrimSpace(statement)
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+
+	// semicolons between begin and end of create procedure separate statements of its body
+	start, depth := 0, 0
+	var words []string
+	scanWords(script, func(word string, wordStart int, wordEnd int) {
+		switch {
+		case word == ";" && depth == 0:
+			add(script[start:wordStart])
+			start = wordEnd
+			words = words[:0]
+		case len(words) < 2:
+			words = append(words, word)
+		case words[0] != "create" || words[1] != "procedure":
+		case word == "begin":
+			depth++
+		case word == "end" && depth > 0:
+			depth--
+		}
+	})
+	add(script[start:])
+
+	return statements
+}
+
+var exprParser = participle.MustBuild(&Expression{}, parserOptions...)
 
+// Error of parsing a query, with position of the offending token
+type SyntaxError struct {
+	Message string
+
+	// 1-based position of the token in the query
+	Line   int
+	Column int
+	// byte offset of the token in the query
+	Offset int
+
+	// offending token, empty if it's the end of the query or the input couldn't be tokenized
+	Token string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// Convert error returned by participle into SyntaxError
+func newSyntaxError(err error) error {
+	var parseErr participle.Error
+	if !errors.As(err, &parseErr) {
+		return err
+	}
+
+	pos := parseErr.Position()
+	syntaxErr := &SyntaxError{
+		Message: parseErr.Message(),
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Offset:  pos.Offset,
+	}
+
+	var unexpected participle.UnexpectedTokenError
+	if errors.As(err, &unexpected) && !unexpected.Unexpected.EOF() {
+		syntaxErr.Token = unexpected.Unexpected.Value
+	}
+	return syntaxErr
+}
+
+// Parse standalone expression, e.g. where clause without "where"
+func ParseExpression(expr string) (*Expression, error) {
+	e := &Expression{}
+	err := exprParser.ParseString("", expr, e)
+	if err != nil {
+		return nil, newSyntaxError(err)
+	}
+	return e, nil
+}
+
+// Parse a single statement, parse errors are returned as *SyntaxError
 func ParseQuery(query string) (*Query, error) {
+	// body of the procedure is blanked out, so that positions of syntax errors are kept
+	text := query
+	bodyStart, bodyEnd := procedureBody(query)
+	if bodyStart != -1 {
+		text = query[:bodyStart] + blank(query[bodyStart:bodyEnd]) + query[bodyEnd:]
+	}
+
 	q := &Query{}
-	err := parser.ParseString("", query, q)
+	err := parser.ParseString("", text, q)
 	if err != nil {
-		return nil, err
+		return nil, newSyntaxError(err)
+	}
+
+	q.Text = strings.TrimSpace(query)
+	if q.CreateView != nil {
+		sel := q.CreateView.Select
+		q.CreateView.Definition = strings.TrimSpace(query[sel.Pos.Offset:sel.EndPos.Offset])
+	}
+	if q.CreateTrigger != nil {
+		q.CreateTrigger.Definition = q.Text
+	}
+	if q.CreateProcedure != nil {
+		q.CreateProcedure.Body = SplitStatements(query[bodyStart:bodyEnd])
+		q.CreateProcedure.Definition = q.Text
 	}
 	return q, nil
 }
+
+// Replace all the characters except for line breaks with spaces
+func blank(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' {
+			return r
+		}
+		return ' '
+	}, s)
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// Call visit for each word of the script outside string literals and quoted
+// identifiers, with its position. Semicolons are visited as words as well
+func scanWords(script string, visit func(word string, start int, end int)) {
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '"':
+			// skip the string, along with escaped characters
+			for i++; i < len(script) && script[i] != '"'; i++ {
+				if script[i] == '\\' {
+					i++
+				}
+			}
+		case c == '`':
+			for i++; i < len(script) && script[i] != '`'; i++ {
+			}
+		case c == ';':
+			visit(";", i, i+1)
+		case isWordChar(c):
+			start := i
+			for i+1 < len(script) && isWordChar(script[i+1]) {
+				i++
+			}
+			visit(strings.ToLower(script[start:i+1]), start, i+1)
+		}
+	}
+}
+
+// Returns the range of the body between begin and end of create procedure,
+// -1 if the statement isn't create procedure
+func procedureBody(statement string) (int, int) {
+	var words []string
+	start, end, last := -1, -1, -1
+	scanWords(statement, func(word string, wordStart int, wordEnd int) {
+		if len(words) < 2 {
+			words = append(words, word)
+		}
+		if word == "begin" && start == -1 {
+			start = wordEnd
+		}
+		if word == "end" {
+			end = wordStart
+		}
+		last = wordStart
+	})
+
+	if len(words) < 2 || words[0] != "create" || words[1] != "procedure" || start == -1 || end != last {
+		return -1, -1
+	}
+	return start, end
+}
@@ -1,6 +1,9 @@
 package dumbdb
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestQuery(t *testing.T) {
 	queries := [...]string{
@@ -11,10 +14,90 @@ func TestQuery(t *testing.T) {
 		"select * from users",
 		"select id, name from users",
 		"select id, name from users where id=1",
+		"select distinct age from users where id < 10",
+		"select u.id as user_id, name from users u where u.age > 20",
+		"select users.id from users as users where users.id = 1",
 		"select id, name from users where id<100 and age>20",
 		"select id, name from users where (id-2)*2 <= 42 or name!=\"kekus\"",
+		"SELECT ID, Name FROM Users WHERE Age > 20 AND id < 10",
+		"create table `Order` (`select` int, `from` varchar(10))",
+		"insert into `Order` (`select`, `from`) values (1, \"x\")",
+		"select `select`, o.`from` from `Order` o where `select` = 1",
+
+		"create table items (id int auto_increment, name varchar(20))",
+		"create table tags (id serial, tag varchar(10))",
+		"create table logs (line varchar(200)) compression = flate",
+		"create table secrets (line varchar(200)) encryption = aes_gcm",
+		"create table staging (id int, name varchar(20)) engine = memory",
+		"create table events (id int, kind varchar(20)) bloom_filter = (id, kind)",
+		"create table facts (id int, kind varchar(20)) engine = columnar",
+		"create table visits (id int, country varchar(40)) dictionary = (country)",
+		"create table logs (id int, created_at bigint) with (ttl = \"24h\", ttl_column = created_at)",
+		"insert into items (name) values (\"foo\"), (\"bar\")",
+		"create table big (id bigint, n int)",
+		"insert into big values (9000000000, 1), (5, 2)",
+		"select * from big where id > 2147483647 and n + 1 = 2",
+
+		"truncate table big",
+		"vacuum big",
+		"alter table users add column age int",
+		"alter table users drop column age",
+		"analyze users",
+		"explain select id from users where id > 10",
+
+		"set statement_timeout = 1000",
+		"set row_limit = 100",
+		"set continue_on_error = true",
+		"set work_memory = 1048576",
+		"set query_memory_limit = 0",
+		"show variables",
+		"show processlist",
+		"kill 42",
+		"grant select, insert on users to alice",
+		"grant all on * to `Bob`",
+		"revoke insert on users from alice",
+		"show grants",
+		"copy users from \"users.csv\" header",
+		"copy users from stdin",
+		"declare c cursor for select id, name from users where id > 1",
+		"fetch 100 from c",
+		"fetch from c",
+		"close c",
+		"create trigger users_name before insert on users for each row when (new.name = \"\") set name = \"anonymous\", id = id + 1",
+		"create trigger users_check before insert on users for each row raise error \"read only\"",
+		"create trigger users_audit after insert on users for each row insert into audit (user_id) values (new.id)",
+		"show triggers",
+		"drop trigger users_audit",
+		"create procedure add_user(id, name) as begin\n  insert into users values (:id, :name);\n  select * from users where id = :id\nend",
+		"create procedure cleanup() as begin truncate table users end",
+		"call add_user(1, \"alice\")",
+		"call cleanup()",
+		"show procedures",
+		"drop procedure cleanup",
+		"copy (select id, name from users where id > 1) to \"users.json\" format json",
 
 		"drop table users",
+
+		"create database test",
+		"use test",
+		"drop database test",
+		"backup to \"backup.tar\"",
+		"show tables",
+		"show table status",
+		"select * from users where id between 1 and 10 and name like \"a%\"",
+		"select * from users where id in (1, 2, 3) or id = 4",
+		"select * from users where not (id = 1) and id > -5 and not name like \"a%\"",
+		"select id, name from users u where id > 10 order by u.id limit 100",
+		"select * from users order by name desc, id asc",
+		"select distinct name from users limit 5",
+		"select id from users where length(trim(name)) > 3 and concat(name, \"x\", lower(name)) != \"\"",
+		"describe users",
+		"create index users_age_name on users (age, name)",
+		"drop index users_age_name",
+		"show indexes",
+		"create table prices (id int, price float)",
+		"insert into prices values (1, 3.14), (2, 0.5), (3, 10)",
+		"select id, price from prices where price * 2 > 1.5 and price != -0.5",
 	}
 
 	for _, query := range queries {
@@ -24,3 +107,102 @@ func TestQuery(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitStatements(t *testing.T) {
+	script := `create table t (s varchar(10));
+insert into t values ("a;b"), ("c\";");;
+create procedure p(s) as begin insert into t values (:s); select * from t end;
+select * from t`
+
+	statements := SplitStatements(script)
+	expected := []string{
+		"create table t (s varchar(10))",
+		`insert into t values ("a;b"), ("c\";")`,
+		"create procedure p(s) as begin insert into t values (:s); select * from t end",
+		"select * from t",
+	}
+
+	if len(statements) != len(expected) {
+		t.Fatalf("Expected %v statements, got %v: %q", len(expected), len(statements), statements)
+	}
+
+	for i := range expected {
+		if statements[i] != expected[i] {
+			t.Fatalf("Statement %v: expected %q, got %q", i, expected[i], statements[i])
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected SyntaxError
+	}{
+		{"select * form users", SyntaxError{Line: 1, Column: 10, Offset: 9, Token: "form"}},
+		{"select id\nfrom users\nwhere id = = 1", SyntaxError{Line: 3, Column: 12, Offset: 32, Token: "="}},
+		// end of the query
+		{"select * from", SyntaxError{Line: 1, Column: 14, Offset: 13}},
+		// there are no NULLs, so the test is rejected at "is"
+		{"select * from users where name is not null", SyntaxError{Line: 1, Column: 32, Offset: 31}},
+	}
+
+	for _, test := range tests {
+		_, err := ParseQuery(test.query)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Fatalf("Expected SyntaxError for %q, got %v", test.query, err)
+		}
+
+		if syntaxErr.Message == "" {
+			t.Fatalf("Expected message for %q", test.query)
+		}
+
+		syntaxErr.Message = ""
+		if *syntaxErr != test.expected {
+			t.Fatalf("Expected %+v for %q, got %+v", test.expected, test.query, *syntaxErr)
+		}
+	}
+}
+
+func TestFloatLiteral(t *testing.T) {
+	q, err := ParseQuery("insert into prices values (3.14, 42)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := q.Insert.Rows[0].Values
+	if values[0].Float == nil || *values[0].Float != 3.14 {
+		t.Fatalf("Expected float literal 3.14, got %+v", values[0])
+	}
+
+	if values[1].Int == nil || *values[1].Int != 42 {
+		t.Fatalf("Expected int literal 42, got %+v", values[1])
+	}
+}
+
+func TestIdentifierCase(t *testing.T) {
+	q, err := ParseQuery("SELECT Distinct u.Name, `Where` FROM Users `where` WHERE `True` = TRUE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sel := q.Select
+	if !sel.Distinct {
+		t.Fatal("Expected distinct")
+	}
+
+	if sel.Table != "users" || sel.Alias != "where" {
+		t.Fatalf("Expected table users aliased as where, got %v %v", sel.Table, sel.Alias)
+	}
+
+	items := sel.Projection.Items
+	if items[0].Column.Table != "u" || items[0].Column.Name != "name" || items[1].Column.Name != "Where" {
+		t.Fatalf("Expected u.name and Where columns, got %v and %v", items[0].Column, items[1].Column)
+	}
+
+	cond := sel.Where.ToBinOp().subtree
+	left, right := cond.Left.val, cond.Right.val
+	if left.Field == nil || left.Field.Name != "True" || right.Const == nil || right.Const.Bool == nil {
+		t.Fatalf("Expected column True compared to constant true, got %+v and %+v", left, right)
+	}
+}
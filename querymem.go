@@ -0,0 +1,113 @@
+package dumbdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+var (
+	ErrQueryMemoryLimit    = errors.New("query memory limit exceeded")
+	ErrDatabaseMemoryLimit = errors.New("memory limit of the database exceeded")
+)
+
+// Memory shared by the running queries of a database, see Options.MemoryLimit
+type MemoryPool struct {
+	limit int64 // 0 means no limit
+	used  int64 // accessed atomically
+}
+
+func NewMemoryPool(limit int64) *MemoryPool {
+	return &MemoryPool{limit: limit}
+}
+
+// Number of bytes reserved by the running queries
+func (pool *MemoryPool) Used() int64 {
+	return atomic.LoadInt64(&pool.used)
+}
+
+func (pool *MemoryPool) reserve(n int64) error {
+	used := atomic.AddInt64(&pool.used, n)
+	if pool.limit != 0 && used > pool.limit {
+		atomic.AddInt64(&pool.used, -n)
+		return fmt.Errorf("%w: running queries use %v bytes, %v more requested, limit is %v",
+			ErrDatabaseMemoryLimit, used-n, n, pool.limit)
+	}
+	return nil
+}
+
+// Memory used by a single query: rows kept by its operators (sorts, hash tables,
+// buffered results), which is also reserved from the pool of the database.
+// Operators which can spill rows to disk do so once the reservation fails,
+// others fail the query. Methods can be called on nil MemoryAccount, which
+// doesn't limit anything
+type MemoryAccount struct {
+	limit int64 // 0 means no limit
+	pool  *MemoryPool
+	used  int64 // accessed atomically, operators of the query run concurrently
+}
+
+// pool can be nil
+func NewMemoryAccount(limit int64, pool *MemoryPool) *MemoryAccount {
+	return &MemoryAccount{limit: limit, pool: pool}
+}
+
+// Reserve n bytes, fails if the limit of the query or of the pool would be exceeded
+func (acc *MemoryAccount) Reserve(n int) error {
+	if acc == nil {
+		return nil
+	}
+
+	used := atomic.AddInt64(&acc.used, int64(n))
+	if acc.limit != 0 && used > acc.limit {
+		atomic.AddInt64(&acc.used, -int64(n))
+		return fmt.Errorf("%w: query uses %v bytes, %v more requested, limit is %v (see query_memory_limit)",
+			ErrQueryMemoryLimit, used-int64(n), n, acc.limit)
+	}
+
+	if acc.pool != nil {
+		err := acc.pool.reserve(int64(n))
+		if err != nil {
+			atomic.AddInt64(&acc.used, -int64(n))
+			return err
+		}
+	}
+
+	DefaultMetrics.QueryMemory.Add(int64(n))
+	return nil
+}
+
+// Release n bytes reserved before
+func (acc *MemoryAccount) Release(n int) {
+	if acc == nil || n == 0 {
+		return
+	}
+
+	atomic.AddInt64(&acc.used, -int64(n))
+	if acc.pool != nil {
+		atomic.AddInt64(&acc.pool.used, -int64(n))
+	}
+	DefaultMetrics.QueryMemory.Add(-int64(n))
+}
+
+// Number of bytes reserved by the query
+func (acc *MemoryAccount) Used() int64 {
+	if acc == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&acc.used)
+}
+
+type memoryAccountKey struct{}
+
+// Returns context of a query which reserves memory used by its operators from acc
+func WithMemoryAccount(ctx context.Context, acc *MemoryAccount) context.Context {
+	return context.WithValue(ctx, memoryAccountKey{}, acc)
+}
+
+// Memory account set by WithMemoryAccount(), nil if there is none
+func queryMemoryAccount(ctx context.Context) *MemoryAccount {
+	acc, _ := ctx.Value(memoryAccountKey{}).(*MemoryAccount)
+	return acc
+}
@@ -0,0 +1,123 @@
+package dumbdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMemoryAccount(t *testing.T) {
+	pool := NewMemoryPool(1000)
+	first, second := NewMemoryAccount(600, pool), NewMemoryAccount(0, pool)
+
+	err := first.Reserve(500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = first.Reserve(200)
+	if !errors.Is(err, ErrQueryMemoryLimit) {
+		t.Fatalf("Expected ErrQueryMemoryLimit, got %v", err)
+	}
+
+	err = second.Reserve(600)
+	if !errors.Is(err, ErrDatabaseMemoryLimit) {
+		t.Fatalf("Expected ErrDatabaseMemoryLimit, got %v", err)
+	}
+
+	first.Release(500)
+	err = second.Reserve(600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second.Release(600)
+	if first.Used() != 0 || second.Used() != 0 || pool.Used() != 0 {
+		t.Fatalf("Expected no memory to be used, got %v, %v and %v", first.Used(), second.Used(), pool.Used())
+	}
+
+	// nil account doesn't limit anything
+	var unlimited *MemoryAccount
+	if err := unlimited.Reserve(1 << 40); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryMemoryLimit(t *testing.T) {
+	acc := NewMemoryAccount(4096, nil)
+	ctx := WithMemoryAccount(context.Background(), acc)
+
+	rows := make([]Row, 0, 1000)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i % 10)), VarcharValue(fmt.Sprintf("row %v", i))})
+	}
+
+	// operators spill once the query runs out of memory
+	sorted, err := SortRows(ctx, StaticRows(rows), func(a, b Row) bool { return a[0].Int < b[0].Int }, DefaultWorkMemory, t.TempDir()).All()
+	if !errors.Is(err, ErrQueryMemoryLimit) || sorted != nil {
+		t.Fatalf("Expected the result buffered by All() to exceed the limit, got %v rows (%v)", len(sorted), err)
+	}
+
+	n := 0
+	distinct := DistinctRows(ctx, StaticRows(rows), DefaultWorkMemory, t.TempDir())
+	for distinct.Next() {
+		n++
+	}
+	if distinct.Err() != nil || n != len(rows) {
+		t.Fatalf("Expected %v rows, got %v (%v)", len(rows), n, distinct.Err())
+	}
+
+	if acc.Used() != 0 {
+		t.Fatalf("Expected memory to be released, %v bytes are used", acc.Used())
+	}
+}
+
+func TestDatabaseMemoryLimit(t *testing.T) {
+	db, err := OpenDatabase(Options{DataDir: t.TempDir(), MemoryLimit: 1 << 19})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sess := &Session{}
+	values := ""
+	for i := 0; i < 5000; i++ {
+		if i != 0 {
+			values += ", "
+		}
+		values += fmt.Sprintf("(%v, \"name %v\")", i, i)
+	}
+
+	for _, q := range []string{"create table t (id int, name varchar(100))", "insert into t values " + values} {
+		mustExec(t, db, sess, q)
+	}
+
+	tests := []struct {
+		limit string
+		err   error
+	}{
+		{"0", ErrDatabaseMemoryLimit},
+		{"100000", ErrQueryMemoryLimit},
+		{"600000", ErrDatabaseMemoryLimit},
+	}
+
+	for _, test := range tests {
+		mustExec(t, db, sess, "set query_memory_limit = "+test.limit)
+
+		rows, err := queryRows(t, db, sess, "select distinct name from t")
+		if !errors.Is(err, test.err) {
+			t.Fatalf("query_memory_limit = %v: expected %v, got %v rows (%v)", test.limit, test.err, len(rows), err)
+		}
+
+		if db.memory.Used() != 0 {
+			t.Fatalf("query_memory_limit = %v: expected memory to be released, %v bytes are used", test.limit, db.memory.Used())
+		}
+	}
+
+	// memory of the filtered rows is released
+	rows, err := queryRows(t, db, sess, "select id from t where id < 10")
+	if err != nil || len(rows) != 10 {
+		t.Fatalf("Expected 10 rows, got %v (%v)", len(rows), err)
+	}
+}
@@ -0,0 +1,218 @@
+package dumbdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Iterator over rows of the query result
+//
+//	rows, err := table.Query("id > 10")
+//	...
+//	defer rows.Close()
+//	for rows.Next() {
+//		var id int
+//		var name string
+//		err = rows.Scan(&id, &name)
+//		...
+//	}
+//	err = rows.Err()
+type Rows struct {
+	c      <-chan Row
+	cancel context.CancelFunc
+	row    Row
+	done   bool
+
+	// schema of the rows, if known
+	schema *Schema
+	// memory account of the query, see All()
+	memory *MemoryAccount
+
+	// set by the producer before c is closed
+	err error
+}
+
+// Run produce in a separate goroutine, rows passed to emit are returned by Next()
+// Error returned by produce is reported by Err(), emit fails once rows are closed
+func NewRows(ctx context.Context, produce func(ctx context.Context, emit func(Row) error) error) *Rows {
+	scanCtx, cancel := context.WithCancel(ctx)
+	c := make(chan Row, 16)
+	rows := &Rows{
+		c:      c,
+		cancel: cancel,
+		memory: queryMemoryAccount(ctx),
+	}
+
+	go func() {
+		defer close(c)
+		err := produce(scanCtx, func(row Row) error {
+			select {
+			case c <- row:
+				return nil
+			case <-scanCtx.Done():
+				return scanCtx.Err()
+			}
+		})
+
+		// errors of the context are returned by emit() and by the checks of
+		// ctx, ctx itself can be cancelled once produce is done, e.g. by the
+		// end of a table scan, so it's not checked for other errors
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			// stopped by Close() (not an error) or because query was cancelled
+			err = CancellationError(ctx)
+		}
+		rows.err = err
+	}()
+
+	return rows
+}
+
+// Columns of the rows, nil if unknown
+func (rows *Rows) Schema() *Schema {
+	return rows.schema
+}
+
+// Advance to the next row, returns false when there are no more rows or on error
+func (rows *Rows) Next() bool {
+	if rows.done {
+		return false
+	}
+
+	row, ok := <-rows.c
+	if !ok {
+		rows.row = nil
+		rows.done = true
+		return false
+	}
+
+	rows.row = row
+	return true
+}
+
+// Current row, valid after Next() returned true
+func (rows *Rows) Row() Row {
+	return rows.row
+}
+
+// Error which stopped the iteration, valid after Next() returned false
+func (rows *Rows) Err() error {
+	if !rows.done {
+		return nil
+	}
+	return rows.err
+}
+
+// Stop producing rows, has to be called if rows are not read till the end
+// Can be called concurrently with Next()
+func (rows *Rows) Close() error {
+	rows.cancel()
+	for range rows.c {
+		// wait for the producer to stop
+	}
+	return rows.err
+}
+
+// Read all the remaining rows. They are accounted in memory of the query while
+// they are read, which fails once the query runs out of memory
+func (rows *Rows) All() ([]Row, error) {
+	var all []Row
+	reserved := 0
+	defer func() {
+		rows.memory.Release(reserved)
+	}()
+
+	for rows.Next() {
+		size := rowMemSize(rows.Row())
+		err := rows.memory.Reserve(size)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		reserved += size
+		all = append(all, rows.Row())
+	}
+	return all, rows.Err()
+}
+
+// Copy columns of the current row into dest, see ScanRow()
+func (rows *Rows) Scan(dest ...interface{}) error {
+	if rows.row == nil {
+		return errors.New("scan called without calling next")
+	}
+	return ScanRow(rows.row, dest...)
+}
+
+// Copy columns of the row into dest, which are pointers to
+// int32, int, int64, bool, string, Value or interface{}
+func ScanRow(row Row, dest ...interface{}) error {
+	if len(dest) != len(row) {
+		return fmt.Errorf("expected %v destinations, got %v", len(row), len(dest))
+	}
+
+	for i := range dest {
+		err := scanValue(&row[i], dest[i])
+		if err != nil {
+			return fmt.Errorf("column %v: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func scanValue(val *Value, dest interface{}) error {
+	switch d := dest.(type) {
+	case *Value:
+		*d = *val
+		return nil
+	case *interface{}:
+		*d = val.Interface()
+		return nil
+	}
+
+	switch val.TypeID {
+	case TypeInt, TypeBigint:
+		switch d := dest.(type) {
+		case *int32:
+			if val.Int < math.MinInt32 || val.Int > math.MaxInt32 {
+				return fmt.Errorf("value %v overflows int32", val.Int)
+			}
+			*d = int32(val.Int)
+			return nil
+		case *int:
+			*d = int(val.Int)
+			return nil
+		case *int64:
+			*d = val.Int
+			return nil
+		case *float64:
+			*d = float64(val.Int)
+			return nil
+		}
+	case TypeFloat:
+		switch d := dest.(type) {
+		case *float64:
+			*d = val.Float
+			return nil
+		case *float32:
+			*d = float32(val.Float)
+			return nil
+		}
+	case TypeBool:
+		d, ok := dest.(*bool)
+		if ok {
+			*d = val.Int != 0
+			return nil
+		}
+	case TypeVarchar:
+		d, ok := dest.(*string)
+		if ok {
+			*d = val.StrVal()
+			return nil
+		}
+	}
+
+	return fmt.Errorf("can't scan %v into %T", val.TypeID, dest)
+}
@@ -0,0 +1,89 @@
+package dumbdb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestRowsErrors(t *testing.T) {
+	errScan := errors.New("scan failed")
+	rows := NewRows(context.Background(), func(ctx context.Context, emit func(Row) error) error {
+		err := emit(Row{IntValue(1)})
+		if err != nil {
+			return err
+		}
+		return errScan
+	})
+
+	all, err := rows.All()
+	if len(all) != 1 || !errors.Is(err, errScan) {
+		t.Fatalf("Expected one row and scan error, got %v, %v", all, err)
+	}
+
+	// infinite producer, stopped by Close()
+	stopped := make(chan struct{})
+	rows = NewRows(context.Background(), func(ctx context.Context, emit func(Row) error) error {
+		defer close(stopped)
+		for {
+			err := emit(Row{IntValue(1)})
+			if err != nil {
+				return err
+			}
+		}
+	})
+
+	var n int
+	if !rows.Next() || rows.Scan(&n) != nil || n != 1 {
+		t.Fatal("Failed to scan the first row")
+	}
+
+	err = rows.Close()
+	if err != nil {
+		t.Fatalf("Expected no error after close, got %v", err)
+	}
+	<-stopped
+
+	// producer stopped by cancelled query
+	ctx, cancel := context.WithCancel(context.Background())
+	rows = NewRows(ctx, func(ctx context.Context, emit func(Row) error) error {
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	_, err = rows.All()
+	if !errors.Is(err, ErrQueryCancelled) {
+		t.Fatalf("Expected cancellation error, got %v", err)
+	}
+
+	// errors of table scans aren't taken for cancellation once the scan ends
+	table, err := NewTable(filepath.Join(t.TempDir(), "users"), testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	err = table.Insert([]Row{{IntValue(1), VarcharValue("foo")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := table.pager.FetchPage(table.firstPage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Lock()
+	binary.LittleEndian.PutUint16(page.Data(), 0xffff)
+	page.Unlock()
+	page.Unpin()
+
+	match := func(Row) (bool, error) { return true, nil }
+	project := func(row Row) Row { return row }
+	_, err = FullScan(context.Background(), table, nil, nil, match, project, nil).All()
+	if !errors.Is(err, ErrCorruptedPage) {
+		t.Fatalf("Expected ErrCorruptedPage, got %v", err)
+	}
+}
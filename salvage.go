@@ -0,0 +1,251 @@
+package dumbdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extension of the files of a table replaced by Salvage()
+const DamagedFileExtension = ".damaged"
+
+// Page (or row group of a columnar table) skipped by Salvage()
+type LostPage struct {
+	ID  PageID
+	Err error
+}
+
+// What Salvage() recovered and what was lost
+type SalvageReport struct {
+	// pages or row groups of columnar tables
+	Pages     int
+	LostPages []LostPage
+
+	Rows int64
+	// rows of the readable pages which can't be decoded
+	LostRows int64
+	// number of rows saved on the last clean shutdown, -1 if it's unknown
+	ExpectedRows int64
+}
+
+func (report *SalvageReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "recovered %v rows from %v of %v pages", report.Rows, report.Pages-len(report.LostPages), report.Pages)
+	if report.ExpectedRows >= 0 {
+		fmt.Fprintf(&b, ", the table had %v rows on the last clean shutdown", report.ExpectedRows)
+	}
+	b.WriteString("\n")
+
+	if report.LostRows != 0 {
+		fmt.Fprintf(&b, "lost %v rows which can't be decoded\n", report.LostRows)
+	}
+	for _, lost := range report.LostPages {
+		fmt.Fprintf(&b, "lost %v: %v\n", lost.ID, lost.Err)
+	}
+	return b.String()
+}
+
+// Copy rows of the table of the catalog in dataDir, which can be read, into table
+// into. Pages failing the checksum or decoding are skipped, as well as the rows
+// which can't be decoded. The new table has no indexes.
+//
+// If into is empty, the table is replaced with the recovered rows, so that the
+// catalog can be opened again. Its damaged files are kept with DamagedFileExtension
+// and its indexes are rebuilt once the catalog is opened.
+// NOTE: the database shouldn't be running
+func Salvage(dataDir string, name string, into string, key []byte) (*SalvageReport, error) {
+	lock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+
+	inspector, err := NewInspector(dataDir, name, key, ioutil.Discard)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := readMetadata(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	target := into
+	if into == "" {
+		target = name + ".salvaged"
+	} else if _, ok := metadata[into]; ok {
+		return nil, fmt.Errorf("%w: %v", ErrTableAlreadyExist, into)
+	}
+
+	meta := metadata[name]
+	opts := meta.TableOptions
+	if opts.Encryption != EncryptionNone {
+		opts.EncryptionKey = key
+	}
+
+	path := filepath.Join(dataDir, target)
+	table, err := NewTable(path, meta.Schema, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := inspector.salvage(table)
+	if err == nil {
+		err = table.Close()
+	} else {
+		table.Close()
+	}
+	if err != nil {
+		removeTableFiles(path)
+		return nil, err
+	}
+
+	report.ExpectedRows = -1
+	if meta.RowCount != nil {
+		report.ExpectedRows = *meta.RowCount
+	}
+
+	if into != "" {
+		metadata[into] = tableMetadata{
+			Schema:        meta.Schema,
+			TableOptions:  meta.TableOptions,
+			AutoIncrement: meta.AutoIncrement,
+		}
+	} else {
+		err = replaceTableFiles(dataDir, name, target, &meta)
+		if err != nil {
+			return nil, err
+		}
+
+		// rows are counted and indexes are rebuilt once the catalog is opened
+		meta.RowCount = nil
+		metadata[name] = meta
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return report, writeFileAtomic(filepath.Join(dataDir, MetadataFilename), data)
+}
+
+// Insert rows of each page which can be read into the table
+func (in *Inspector) salvage(table *Table) (*SalvageReport, error) {
+	pager, release, err := in.openPager("")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	opts := in.meta.TableOptions
+	dict, err := openDictionary(in.path, &in.meta.Schema, opts.Dictionary, opts, false)
+	if err != nil {
+		return nil, err
+	}
+	defer dict.Close()
+	layout := dict.layout(&in.meta.Schema)
+
+	stride := PageID(1)
+	if opts.Engine == EngineColumnar {
+		stride = PageID(len(layout.Fields))
+	}
+
+	report := &SalvageReport{}
+	for id := PageID(0); pager.IsAllocated(id + stride - 1); id += stride {
+		report.Pages++
+
+		rows, lost, err := readPageRows(pager, id, &layout, opts.Engine, dict)
+		if err != nil {
+			report.LostPages = append(report.LostPages, LostPage{ID: id, Err: err})
+			continue
+		}
+		report.LostRows += int64(lost)
+
+		// rows of a page which isn't damaged can still have invalid values
+		valid := rows[:0]
+		for _, row := range rows {
+			if table.schema.TypecheckRows([]Row{row}) != nil {
+				report.LostRows++
+				continue
+			}
+			valid = append(valid, row)
+		}
+
+		if len(valid) != 0 {
+			err = table.Insert(valid)
+			if err != nil {
+				return nil, err
+			}
+			report.Rows += int64(len(valid))
+		}
+	}
+	return report, nil
+}
+
+// Returns decoded rows of the page and number of the rows which can't be decoded
+func readPageRows(pager *Pager, id PageID, layout *Schema, engine string, dict *Dictionary) ([]Row, int, error) {
+	rows, release, err := readRowSet(pager, id, layout, engine)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer release()
+
+	err = rows.Check(layout)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	decoded := make([]Row, 0, rows.NumRows())
+	lost := 0
+	for i := 0; i < rows.NumRows(); i++ {
+		row := rows.ReadRow(i, layout)
+		if row == nil {
+			lost++
+			continue
+		}
+
+		row, err = dict.decode(row, nil)
+		if err != nil {
+			lost++
+			continue
+		}
+		decoded = append(decoded, row)
+	}
+	return decoded, lost, nil
+}
+
+// Replace files of the table with the ones of the salvaged table, the damaged
+// files are renamed and the index files are removed
+func replaceTableFiles(dataDir string, name string, salvaged string, meta *tableMetadata) error {
+	path := filepath.Join(dataDir, name)
+	files := [][2]string{{path + ".bin", salvaged + ".bin"}}
+	if len(meta.Dictionary) != 0 {
+		files = append(files, [2]string{path + DictionaryFileExtension, salvaged + DictionaryFileExtension})
+	}
+
+	for _, file := range files {
+		err := os.Rename(file[0], file[0]+DamagedFileExtension)
+		if err == nil {
+			err = os.Rename(filepath.Join(dataDir, file[1]), file[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, index := range meta.Indexes {
+		err := os.Remove(indexPath(path, index.Name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeTableFiles(path string) {
+	os.Remove(path + ".bin")
+	os.Remove(path + DictionaryFileExtension)
+}
@@ -0,0 +1,92 @@
+package dumbdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSalvage(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenDatabase(Options{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const nRows = 1000
+	values := make([]string, 0, nRows)
+	for i := 0; i < nRows; i++ {
+		values = append(values, fmt.Sprintf("(%v, \"user %v\")", i, i))
+	}
+	mustExec(t, db, &Session{}, "create table users (id int, name varchar(20))")
+	mustExec(t, db, &Session{}, "create index users_id on users (id)")
+	mustExec(t, db, &Session{}, "insert into users values "+strings.Join(values, ", "))
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a flipped byte of the second page fails its checksum
+	file, err := os.OpenFile(filepath.Join(dir, "users.bin"), os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteAt([]byte{0xff}, 2*int64(PageSize)+100)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenDatabase(Options{DataDir: dir})
+	if !errors.Is(err, ErrCorruptedPage) {
+		t.Fatalf("Expected ErrCorruptedPage, got %v", err)
+	}
+
+	lostRows := rowsPerPage(&Schema{TotalLen: 24}, EngineDisk)
+	check := func(report *SalvageReport, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Rows != int64(nRows-lostRows) || report.LostRows != 0 || report.ExpectedRows != nRows ||
+			len(report.LostPages) != 1 || report.LostPages[0].ID != 1 || !errors.Is(report.LostPages[0].Err, ErrPageChecksum) {
+			t.Fatalf("Unexpected report %v", report)
+		}
+	}
+
+	check(Salvage(dir, "users", "recovered", nil))
+	_, err = Salvage(dir, "users", "recovered", nil)
+	if !errors.Is(err, ErrTableAlreadyExist) {
+		t.Fatalf("Expected ErrTableAlreadyExist, got %v", err)
+	}
+
+	// the table is replaced, so that the database can be opened
+	check(Salvage(dir, "users", "", nil))
+	_, err = os.Stat(filepath.Join(dir, "users.bin"+DamagedFileExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenDatabase(Options{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, table := range []string{"users", "recovered"} {
+		rows := mustQuery(t, db, &Session{}, "select id from "+table)
+		if len(rows) != nRows-lostRows {
+			t.Fatalf("Expected %v rows of %v, got %v", nRows-lostRows, table, len(rows))
+		}
+	}
+
+	// rows of the lost page are gone, the index is rebuilt
+	for id, expected := range map[int]int{lostRows - 1: 1, lostRows: 0, 2*lostRows - 1: 0, 2 * lostRows: 1} {
+		rows := mustQuery(t, db, &Session{}, fmt.Sprintf("select name from users where id = %v", id))
+		if len(rows) != expected {
+			t.Fatalf("Expected %v rows with id %v, got %v", expected, id, rows)
+		}
+	}
+}
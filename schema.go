@@ -1,10 +1,18 @@
 package dumbdb
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
+)
+
+var (
+	ErrTypeMismatch        = errors.New("type mismatch")
+	ErrConstraintViolation = errors.New("constraint violation")
 )
 
 type TypeID uint8
@@ -13,14 +21,36 @@ const (
 	TypeInt = iota
 	TypeVarchar
 	TypeBool
+	TypeBigint
+	TypeFloat
 )
 
+// Integer arithmetic wraps around on overflow (as in Go): int at 32 bits, bigint at 64 bits.
+// Expressions mixing int and bigint are computed as bigint
+func (t TypeID) IsInteger() bool {
+	return t == TypeInt || t == TypeBigint
+}
+
+// Expressions mixing integers and floats are computed as float (64-bit)
+func (t TypeID) IsNumeric() bool {
+	return t.IsInteger() || t == TypeFloat
+}
+
+// Returns true if values of the types can be compared with each other
+func Comparable(a TypeID, b TypeID) bool {
+	return a == b || (a.IsNumeric() && b.IsNumeric())
+}
+
 func (t TypeID) String() string {
 	switch t {
 	case TypeBool:
 		return "bool"
 	case TypeInt:
 		return "int"
+	case TypeBigint:
+		return "bigint"
+	case TypeFloat:
+		return "float"
 	case TypeVarchar:
 		return "varchar"
 	}
@@ -32,21 +62,41 @@ type Field struct {
 	Name   string `json:"name"`
 	TypeID TypeID `json:"type_id"`
 	Len    uint8  `json:"len"`
+
+	// value is generated on insert if it's omitted
+	AutoIncrement bool `json:"auto_increment,omitempty"`
+}
+
+// Type as written in create table, e.g. "varchar(10)"
+func (field *Field) TypeName() string {
+	if field.TypeID == TypeVarchar {
+		return fmt.Sprintf("varchar(%d)", field.Len)
+	}
+	return field.TypeID.String()
 }
 
+// Check that value can be stored in the field, integers are converted to the field type
 func (field *Field) Typecheck(v *Value) error {
-	if field.TypeID != v.TypeID {
-		return fmt.Errorf("unexpected type for %v (expected %v, got %v)", field.Name, field.TypeID, v.TypeID)
+	// floats are not truncated to integers implicitly
+	if !Comparable(field.TypeID, v.TypeID) || (field.TypeID.IsInteger() && v.TypeID == TypeFloat) {
+		return fmt.Errorf("%w for %v (expected %v, got %v)", ErrTypeMismatch, field.Name, field.TypeID, v.TypeID)
 	}
 
 	switch field.TypeID {
 	case TypeInt:
-		// nothing
+		if v.Int < math.MinInt32 || v.Int > math.MaxInt32 {
+			return fmt.Errorf("%w: value for %v is out of int range", ErrConstraintViolation, field.Name)
+		}
+		v.TypeID = TypeInt
+	case TypeBigint:
+		v.TypeID = TypeBigint
+	case TypeFloat:
+		*v = FloatValue(v.ToFloat())
 	case TypeBool:
 		// also nothing
 	case TypeVarchar:
 		if len(v.Str) > int(field.Len) {
-			return fmt.Errorf("value for %v is too long (%v is max)", field.Name, field.Len)
+			return fmt.Errorf("%w: value for %v is too long (%v is max)", ErrConstraintViolation, field.Name, field.Len)
 		}
 	default:
 		panic("unhandled type id")
@@ -61,9 +111,13 @@ func (field *Field) Read(data []byte) Value {
 	}
 	switch field.TypeID {
 	case TypeInt:
-		v.Int = int32(binary.LittleEndian.Uint32(data[:4]))
+		v.Int = int64(int32(binary.LittleEndian.Uint32(data[:4])))
+	case TypeBigint:
+		v.Int = int64(binary.LittleEndian.Uint64(data[:8]))
+	case TypeFloat:
+		v.Float = math.Float64frombits(binary.LittleEndian.Uint64(data[:8]))
 	case TypeBool:
-		v.Int = int32(data[0])
+		v.Int = int64(data[0])
 	case TypeVarchar:
 		v.Str = string(data[:field.Len])
 	default:
@@ -72,10 +126,76 @@ func (field *Field) Read(data []byte) Value {
 	return v
 }
 
+// Compare encoded value of the field with val without decoding it
+// returns -1, 0 or 1 (same as bytes.Compare)
+func (field *Field) Compare(data []byte, val *Value) int {
+	switch field.TypeID {
+	case TypeInt:
+		v := int64(int32(binary.LittleEndian.Uint32(data[:4])))
+		if val.TypeID == TypeFloat {
+			return compareFloats(float64(v), val.Float)
+		}
+
+		switch {
+		case v < val.Int:
+			return -1
+		case v > val.Int:
+			return 1
+		default:
+			return 0
+		}
+	case TypeBigint:
+		v := int64(binary.LittleEndian.Uint64(data[:8]))
+		if val.TypeID == TypeFloat {
+			return compareFloats(float64(v), val.Float)
+		}
+
+		switch {
+		case v < val.Int:
+			return -1
+		case v > val.Int:
+			return 1
+		default:
+			return 0
+		}
+	case TypeFloat:
+		v := math.Float64frombits(binary.LittleEndian.Uint64(data[:8]))
+		return compareFloats(v, val.ToFloat())
+	case TypeBool:
+		v := int64(data[0])
+		switch {
+		case v < val.Int:
+			return -1
+		case v > val.Int:
+			return 1
+		default:
+			return 0
+		}
+	case TypeVarchar:
+		// NOTE: string(str) conversions below don't allocate
+		str := bytes.TrimRight(data[:field.Len], "\x00")
+		other := val.StrVal()
+		switch {
+		case string(str) < other:
+			return -1
+		case string(str) > other:
+			return 1
+		default:
+			return 0
+		}
+	default:
+		panic("unhandled type id")
+	}
+}
+
 func (field *Field) Write(data []byte, val Value) {
-	switch val.TypeID {
+	switch field.TypeID {
 	case TypeInt:
 		binary.LittleEndian.PutUint32(data, uint32(val.Int))
+	case TypeBigint:
+		binary.LittleEndian.PutUint64(data, uint64(val.Int))
+	case TypeFloat:
+		binary.LittleEndian.PutUint64(data, math.Float64bits(val.Float))
 	case TypeBool:
 		data[0] = byte(val.Int)
 	case TypeVarchar:
@@ -90,20 +210,73 @@ func (field *Field) Write(data []byte, val Value) {
 
 type Value struct {
 	TypeID TypeID
-	Int    int32
+	Int    int64 // int, bigint and bool
 	Str    string
+	Float  float64 `json:",omitempty"`
 }
 
-func (val *Value) StrVal() string {
-	trailingZeros := len(val.Str) - 1
-	for trailingZeros > 0 && val.Str[trailingZeros] == 0 {
-		trailingZeros--
+// Integer constant, int if it fits into 32 bits, bigint otherwise
+func IntegerValue(n int64) Value {
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		return Value{TypeID: TypeBigint, Int: n}
 	}
+	return Value{TypeID: TypeInt, Int: n}
+}
+
+func FloatValue(f float64) Value {
+	return Value{TypeID: TypeFloat, Float: f}
+}
 
-	if trailingZeros > 0 {
-		trailingZeros++
+// Value of a numeric type as float
+func (val *Value) ToFloat() float64 {
+	if val.TypeID == TypeFloat {
+		return val.Float
 	}
-	return val.Str[:trailingZeros]
+	return float64(val.Int)
+}
+
+func compareFloats(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Compare values of comparable types, returns -1, 0 or 1
+func compareValues(left *Value, right *Value) int {
+	if left.TypeID == TypeVarchar {
+		l, r := left.StrVal(), right.StrVal()
+		switch {
+		case l < r:
+			return -1
+		case l > r:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	if left.TypeID == TypeFloat || right.TypeID == TypeFloat {
+		return compareFloats(left.ToFloat(), right.ToFloat())
+	}
+
+	switch {
+	case left.Int < right.Int:
+		return -1
+	case left.Int > right.Int:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Returns string value without padding zeros
+func (val *Value) StrVal() string {
+	return strings.TrimRight(val.Str, "\x00")
 }
 
 func (val *Value) String() string {
@@ -112,8 +285,10 @@ func (val *Value) String() string {
 	}
 
 	switch val.TypeID {
-	case TypeInt:
-		return strconv.FormatInt(int64(val.Int), 10)
+	case TypeInt, TypeBigint:
+		return strconv.FormatInt(val.Int, 10)
+	case TypeFloat:
+		return strconv.FormatFloat(val.Float, 'g', -1, 64)
 	case TypeBool:
 		return strconv.FormatBool(val.Int != 0)
 	case TypeVarchar:
@@ -146,12 +321,23 @@ func NewSchema(desc []FieldDescription) Schema {
 
 	for _, field := range desc {
 		f := Field{
-			Name: field.Name,
+			Name:          field.Name,
+			AutoIncrement: field.AutoIncrement,
 		}
 		switch {
 		case field.Type.Integer:
 			f.TypeID = TypeInt
 			f.Len = 4
+		case field.Type.Serial:
+			f.TypeID = TypeInt
+			f.Len = 4
+			f.AutoIncrement = true
+		case field.Type.Bigint:
+			f.TypeID = TypeBigint
+			f.Len = 8
+		case field.Type.Float:
+			f.TypeID = TypeFloat
+			f.Len = 8
 		case field.Type.Bool:
 			f.TypeID = TypeBool
 			f.Len = 1
@@ -173,6 +359,36 @@ func (schema *Schema) addField(field Field) {
 	schema.Fields = append(schema.Fields, field)
 }
 
+// Check constraints which are not enforced by the grammar
+func (schema *Schema) Validate() error {
+	autoIncrement := 0
+	for _, field := range schema.Fields {
+		if !field.AutoIncrement {
+			continue
+		}
+
+		if !field.TypeID.IsInteger() {
+			return fmt.Errorf("auto_increment column %v should be int or bigint", field.Name)
+		}
+		autoIncrement++
+	}
+
+	if autoIncrement > 1 {
+		return errors.New("table can have only one auto_increment column")
+	}
+	return nil
+}
+
+// Returns index of the auto-increment field, or -1 if there is none
+func (schema *Schema) AutoIncrementField() int {
+	for idx, field := range schema.Fields {
+		if field.AutoIncrement {
+			return idx
+		}
+	}
+	return -1
+}
+
 func (schema *Schema) GetField(name string) (int, Field) {
 	for idx, field := range schema.Fields {
 		if field.Name == name {
@@ -182,6 +398,15 @@ func (schema *Schema) GetField(name string) (int, Field) {
 	return -1, Field{}
 }
 
+// Returns offset of the field with index idx inside of the encoded row
+func (schema *Schema) Offset(idx int) int {
+	offset := 0
+	for _, field := range schema.Fields[:idx] {
+		offset += int(field.Len)
+	}
+	return offset
+}
+
 func (schema *Schema) RowSize() int {
 	return schema.TotalLen
 }
@@ -197,7 +422,7 @@ func (schema *Schema) ColumnNames() []string {
 // Check whether row matches the schema, returns nil on success
 func (schema *Schema) Typecheck(row Row) error {
 	if len(schema.Fields) != len(row) {
-		return errors.New("number of values doesn't match number of columns")
+		return fmt.Errorf("%w: number of values doesn't match number of columns", ErrTypeMismatch)
 	}
 
 	for i := 0; i < len(schema.Fields); i++ {
@@ -210,6 +435,16 @@ func (schema *Schema) Typecheck(row Row) error {
 	return nil
 }
 
+func (schema *Schema) TypecheckRows(rows []Row) error {
+	for i, row := range rows {
+		err := schema.Typecheck(row)
+		if err != nil {
+			return fmt.Errorf("row #%d %w", i, err)
+		}
+	}
+	return nil
+}
+
 func (schema *Schema) Project(names []string) (Schema, []int, error) {
 	indexes := make([]int, 0, len(names))
 	newSchema := Schema{}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Single line of structured log in logfmt format, e.g.
+//
+//	level=info msg=query client=127.0.0.1:5555 duration=1.5ms
+type logEntry struct {
+	b strings.Builder
+}
+
+func newLogEntry(level string, msg string) *logEntry {
+	entry := &logEntry{}
+	return entry.with("level", level).with("msg", msg)
+}
+
+func formatLogValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case time.Duration:
+		s = v.String()
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func (e *logEntry) with(key string, value interface{}) *logEntry {
+	if e.b.Len() != 0 {
+		e.b.WriteByte(' ')
+	}
+	e.b.WriteString(key)
+	e.b.WriteByte('=')
+	e.b.WriteString(formatLogValue(value))
+	return e
+}
+
+func (e *logEntry) print() {
+	log.Println(e.b.String())
+}
+
+func logInfo(msg string) *logEntry {
+	return newLogEntry("info", msg)
+}
+
+func logWarn(msg string) *logEntry {
+	return newLogEntry("warn", msg)
+}
+
+func logError(msg string) *logEntry {
+	return newLogEntry("error", msg)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"dumbdb"
 	"errors"
@@ -9,10 +10,16 @@ import (
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"time"
 )
 
+var ErrTooManyConnections = errors.New("too many connections")
+
 func readQuery(conn net.Conn) (string, error) {
 	message, err := dumbdb.RecvMessage(conn)
 	if err != nil {
@@ -21,67 +28,768 @@ func readQuery(conn net.Conn) (string, error) {
 	return string(message), err
 }
 
-func handleClient(db *dumbdb.Database, conn net.Conn) {
-	defer conn.Close()
+type options struct {
+	memLimit  int
+	tempDir   string
+	chunkRows int
+
+	// how long to wait for connections to finish on shutdown
+	shutdownTimeout time.Duration
+
+	// default values of statement_timeout, work_memory and query_memory_limit for
+	// new sessions, users other than the admin can only lower them, see SessionLimits.
+	// 0 means no timeout and no memory limit
+	statementTimeout time.Duration
+	workMemory       int
+	queryMemoryLimit int64
+
+	// max number of concurrent connections, 0 means no limit
+	maxConnections int
+	// close connections which didn't send a query for this long, 0 means never
+	idleTimeout time.Duration
+
+	// queries running longer than this are logged as slow, 0 means disabled
+	slowQuery time.Duration
+
+	// compress messages larger than this for clients supporting it, 0 means never
+	compressThreshold int
+
+	// max number of pipelined requests of a single connection executed concurrently,
+	// 0 disables pipelining
+	maxPipelined int
+
+	// how often to report progress of queries to clients supporting it, 0 means never
+	progressInterval time.Duration
+
+	// rows are sent in binary, set for connections which negotiated it
+	binaryRows bool
+
+	// clients stream rows of copy from stdin, set for connections which negotiated it
+	copyIn bool
+	// acknowledge rows streamed by copy from stdin every this many rows
+	copyAckRows int
+
+	// admission control of queries of all the connections, nil if there is no limit
+	queue *dumbdb.QueryQueue
+
+	// limit queries of clients to the privileges of the user they connect as,
+	// except for adminUser. Clients naming a user are authenticated with
+	// credentials, the ones which don't connect as anonymousUser
+	accessControl bool
+	adminUser     string
+	credentials   *dumbdb.Credentials
+}
+
+const anonymousUser = "anonymous"
+
+// User of the session of the client connecting as user, empty means all privileges
+func sessionUser(user string, opts *options) string {
+	if !opts.accessControl {
+		return ""
+	}
+
+	switch user {
+	case "":
+		return anonymousUser
+	case opts.adminUser:
+		return ""
+	default:
+		return user
+	}
+}
+
+// User of the session of the client once its password is checked, see sessionUser()
+func authenticate(handshake *dumbdb.Handshake, opts *options) (string, error) {
+	if opts.accessControl && handshake.User != "" && !opts.credentials.Verify(handshake.User, handshake.Password) {
+		return "", fmt.Errorf("%w: wrong user or password", dumbdb.ErrAccessDenied)
+	}
+	return sessionUser(handshake.User, opts), nil
+}
+
+// Outcome of a single query, used for logging
+type queryStats struct {
+	rows    int
+	err     error // error reported to the client
+	spilled bool
+	queued  time.Duration // time spent waiting in the queue
+}
+
+// Send progress of the scan every interval until the returned function is called,
+// it waits for the sender to exit, so no progress is sent after it returns
+func reportProgress(send func(*dumbdb.Response) error, progress *dumbdb.ScanProgress, interval time.Duration) func() {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+
+	go func() {
+		defer close(exited)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			current := progress.Get()
+			err := send(&dumbdb.Response{Progress: &current})
+			if err != nil {
+				// connection is broken, sending the result fails as well
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+		})
+	}
+}
+
+// Stream result to the client in chunks
+func sendResult(conn net.Conn, result *dumbdb.Result, opts *options, stats *queryStats) error {
+	spool := dumbdb.SpoolRows(result.Rows, opts.memLimit, opts.tempDir)
+
+	// progress is reported concurrently with sending the chunks
+	var sendM sync.Mutex
+	send := func(response *dumbdb.Response) error {
+		sendM.Lock()
+		defer sendM.Unlock()
+		return dumbdb.SendResponse(conn, response)
+	}
+
+	stopProgress := func() {}
+	if opts.progressInterval != 0 && result.Progress != nil {
+		stopProgress = reportProgress(send, result.Progress, opts.progressInterval)
+	}
+
+	defer func() {
+		stopProgress()
+
+		// stop the scan, if it's still running
+		result.Rows.Close()
+		err := spool.Close()
+		if err != nil {
+			logError("spool_close_failed").with("client", conn.RemoteAddr()).with("error", err).print()
+		}
+	}()
+
 	for {
-		query, err := readQuery(conn)
+		rows, err := spool.Next(opts.chunkRows)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			stats.err = err
+			stopProgress()
+			return send(dumbdb.ErrorResponse(err))
+		}
+
+		stats.rows += len(rows)
+		err = send(&dumbdb.Response{
+			Result: resultChunk(result.Schema, rows, opts),
+			More:   true,
+		})
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				log.Printf("[%v] Connection closed\n", conn.RemoteAddr())
+			return err
+		}
+	}
+
+	stats.spilled = spool.Spilled()
+	stopProgress()
+
+	// last (empty) chunk
+	return send(&dumbdb.Response{
+		Result: resultChunk(result.Schema, []dumbdb.Row{}, opts),
+	})
+}
+
+// Chunk of the result, rows are encoded in binary for clients which negotiated it
+func resultChunk(schema dumbdb.Schema, rows []dumbdb.Row, opts *options) *dumbdb.ResponseChunk {
+	chunk := dumbdb.NewResponseChunk(schema, rows)
+	if opts.binaryRows {
+		// values of results have valid types, rows are sent as JSON if they can't be encoded
+		chunk.EncodeRows()
+	}
+	return chunk
+}
+
+// Send the error to the client and record it in stats
+func sendError(conn net.Conn, err error, stats *queryStats) error {
+	stats.err = err
+	return dumbdb.SendResponse(conn, dumbdb.ErrorResponse(err))
+}
+
+// Execute the statement and read all its rows into the response
+func executeBuffered(ctx context.Context, db *dumbdb.Database, sess *dumbdb.Session, statement string, opts *options) (*dumbdb.Response, error) {
+	q, err := dumbdb.ParseQuery(statement)
+	if err != nil {
+		return nil, fmt.Errorf("syntax error: %w", err)
+	}
+
+	queryCtx, cancel := sess.QueryContext(ctx)
+	defer cancel()
+
+	result, err := db.Execute(queryCtx, sess, q)
+	if err != nil || result == nil {
+		return &dumbdb.Response{}, err
+	}
+
+	if result.CopyIn != nil {
+		return nil, errors.New("copy from stdin can't be a part of a batch")
+	}
+
+	response := &dumbdb.Response{LastInsertID: result.LastInsertID}
+	if result.Rows == nil {
+		return response, nil
+	}
+
+	rows, err := result.Rows.All()
+	if err != nil {
+		return nil, err
+	}
+
+	if rows == nil {
+		rows = []dumbdb.Row{}
+	}
+	response.Result = resultChunk(result.Schema, rows, opts)
+	return response, nil
+}
+
+// Execute statements of the batch one by one and send all their results in a single message.
+// Unlike results of single queries, results of the batch are buffered in memory
+func runBatch(ctx context.Context, db *dumbdb.Database, conn net.Conn, sess *dumbdb.Session, statements []string, opts *options, stats *queryStats) error {
+	responses := make([]dumbdb.Response, 0, len(statements))
+	for _, statement := range statements {
+		response, err := executeBuffered(ctx, db, sess, statement, opts)
+		if err != nil {
+			if stats.err == nil {
+				stats.err = err
+			}
+			responses = append(responses, *dumbdb.ErrorResponse(err))
+			if !sess.ContinueOnError {
 				break
 			}
+			continue
+		}
 
-			log.Printf("[%v] Failed to receive query: %v\n", conn.RemoteAddr(), err)
-			break
+		if response.Result != nil {
+			stats.rows += len(response.Result.Rows)
 		}
+		responses = append(responses, *response)
+	}
 
-		q, err := dumbdb.ParseQuery(query)
+	return dumbdb.SendResponses(conn, responses)
+}
+
+// Parse and execute a single query, returns error only if connection should be closed
+func runQuery(ctx context.Context, db *dumbdb.Database, conn net.Conn, sess *dumbdb.Session, query string, opts *options, stats *queryStats) error {
+	// several statements separated by semicolons
+	statements := dumbdb.SplitStatements(query)
+	if len(statements) > 1 {
+		return runBatch(ctx, db, conn, sess, statements, opts, stats)
+	}
+
+	q, err := dumbdb.ParseQuery(query)
+	if err != nil {
+		return sendError(conn, fmt.Errorf("syntax error: %w", err), stats)
+	}
+
+	queryCtx, cancel := sess.QueryContext(ctx)
+	defer cancel()
+
+	result, err := db.Execute(queryCtx, sess, q)
+	if err != nil {
+		return sendError(conn, err, stats)
+	}
+
+	if result == nil {
+		return dumbdb.SendMessage(conn, []byte(""))
+	}
+
+	if result.CopyIn != nil {
+		return receiveCopyIn(queryCtx, conn, result, opts, stats)
+	}
+
+	if result.Rows == nil {
+		// statement without result rows
+		return dumbdb.SendResponse(conn, &dumbdb.Response{
+			LastInsertID: result.LastInsertID,
+		})
+	}
+
+	return sendResult(conn, result, opts, stats)
+}
+
+// Insert rows streamed by the client for copy from stdin, see dumbdb.CopyInStart.
+// Returns error only if connection should be closed
+func receiveCopyIn(ctx context.Context, conn net.Conn, result *dumbdb.Result, opts *options, stats *queryStats) error {
+	if !opts.copyIn {
+		return sendError(conn, errors.New("copy from stdin isn't supported by the client"), stats)
+	}
+
+	err := dumbdb.SendResponse(conn, &dumbdb.Response{
+		CopyIn: &dumbdb.CopyInStart{Columns: dumbdb.ColumnsMetadata(&result.Schema), AckRows: opts.copyAckRows},
+	})
+	if err != nil {
+		return err
+	}
+
+	copyIn := result.CopyIn
+	var acked int64
+	// once the copy fails, the rest of the stream is skipped
+	failed := false
+	for {
+		if opts.idleTimeout != 0 {
+			conn.SetReadDeadline(time.Now().Add(opts.idleTimeout))
+			// don't override the deadline set on shutdown
+			if ctx.Err() != nil {
+				conn.SetReadDeadline(time.Now())
+			}
+		}
+
+		message, err := dumbdb.RecvMessage(conn)
 		if err != nil {
-			log.Printf("[%v] Failed to parse query: %v\n", conn.RemoteAddr(), err)
-			// TODO: handle error?
-			dumbdb.SendResponse(conn, &dumbdb.Response{
-				Error: fmt.Sprintf("syntax error: %v", err.Error()),
-			})
+			return err
+		}
+
+		rows, err := dumbdb.ParseCopyMessage(message)
+		done := errors.Is(err, io.EOF) || errors.Is(err, dumbdb.ErrCopyFailed)
+		if failed {
+			if done {
+				return nil
+			}
 			continue
 		}
 
-		log.Printf("[%v] Running \"%v\"\n", conn.RemoteAddr(), query)
+		if errors.Is(err, io.EOF) {
+			stats.rows = int(copyIn.Inserted)
+			return dumbdb.SendResponse(conn, &dumbdb.Response{RowsCopied: copyIn.Inserted})
+		}
+
+		// batches reporting status of each row are replied to one by one
+		batch := dumbdb.IsCopyBatch(message)
+		var statuses []dumbdb.RowStatus
+		if err == nil && batch {
+			statuses, err = copyIn.InsertBatch(rows)
+		} else if err == nil {
+			err = copyIn.Insert(rows)
+		}
+		if err == nil {
+			err = dumbdb.CancellationError(ctx)
+		}
 
-		result, err := db.Execute(context.Background(), q)
 		if err != nil {
-			log.Printf("[%v] Failed to process query: %v\n", conn.RemoteAddr(), err)
-			// TODO: handle error?
-			dumbdb.SendResponse(conn, &dumbdb.Response{
-				Error: err.Error(),
-			})
+			stats.rows = int(copyIn.Inserted)
+			err = sendError(conn, err, stats)
+			if err != nil || done {
+				return err
+			}
+			failed = true
 			continue
 		}
 
-		if result != nil {
-			// TODO: send rows by chunks
-			rows := make([]dumbdb.Row, 0)
-			for row := range result.Rows {
-				rows = append(rows, row)
+		if batch {
+			acked = copyIn.Inserted
+			err = dumbdb.SendResponse(conn, &dumbdb.Response{RowsCopied: acked, More: true, RowErrors: dumbdb.RowErrors(statuses)})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if copyIn.Inserted-acked >= int64(opts.copyAckRows) {
+			acked = copyIn.Inserted
+			err = dumbdb.SendResponse(conn, &dumbdb.Response{RowsCopied: acked, More: true})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func logQuery(conn net.Conn, query string, duration time.Duration, stats *queryStats, opts *options) {
+	var entry *logEntry
+	if opts.slowQuery != 0 && duration >= opts.slowQuery {
+		entry = logWarn("slow_query")
+	} else {
+		entry = logInfo("query")
+	}
+
+	entry.with("client", conn.RemoteAddr()).
+		with("query", query).
+		with("duration", duration).
+		with("rows", stats.rows)
+
+	if stats.spilled {
+		entry.with("spilled", true)
+	}
+
+	if stats.queued >= time.Millisecond {
+		entry.with("queued", stats.queued)
+	}
+
+	if stats.err != nil {
+		entry.with("error", stats.err)
+	}
+
+	entry.print()
+}
+
+// Run a single query and log it, returns error only if connection should be closed
+func serveQuery(ctx context.Context, db *dumbdb.Database, conn net.Conn, sess *dumbdb.Session, query string, opts *options) error {
+	// health checks of the clients aren't queries
+	if dumbdb.IsPing([]byte(query)) {
+		return dumbdb.SendResponse(conn, &dumbdb.Response{})
+	}
+
+	start := time.Now()
+	var stats queryStats
+	// queued queries are listed by `show processlist` too, so that they can be killed
+	ctx, done := db.TrackQuery(ctx, sess, query)
+	defer done()
+
+	// the query waits for its turn, if the number of running queries is limited
+	release := func() {}
+	var err error
+	if opts.queue != nil && !bypassesQueue(query) {
+		release, err = opts.queue.Admit(ctx)
+		stats.queued = time.Since(start)
+	}
+
+	if err != nil {
+		err = sendError(conn, err, &stats)
+	} else {
+		err = runQuery(ctx, db, conn, sess, query, opts, &stats)
+		release()
+	}
+	duration := time.Since(start)
+
+	dumbdb.DefaultMetrics.ObserveLatency(duration)
+	logQuery(conn, query, duration, &stats, opts)
+	return err
+}
+
+// Delete expired rows of the tables with ttl every interval until ctx is done
+func runExpiration(ctx context.Context, db *dumbdb.Database, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := db.ExpireRows(now)
+			if err != nil {
+				logError("expire_failed").with("error", err).print()
+			}
+			if n != 0 {
+				logInfo("expired").with("rows", n).print()
+			}
+		}
+	}
+}
+
+// Reason of the disconnect after failing to read the next request,
+// empty if the error is unexpected
+func disconnectReason(ctx context.Context, err error) string {
+	if ctx.Err() != nil {
+		return "shutdown"
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "idle"
+	}
+
+	if errors.Is(err, io.EOF) {
+		return "eof"
+	}
+	return ""
+}
+
+// Whether the query changes the session, so it can't run concurrently with other pipelined requests
+func modifiesSession(query string) bool {
+	// batches may contain anything, so they are treated as if they did
+	if len(dumbdb.SplitStatements(query)) > 1 {
+		return true
+	}
+
+	q, err := dumbdb.ParseQuery(query)
+	if err != nil {
+		return false
+	}
+	// procedures may contain anything as well
+	return q.Use != nil || q.Set != nil || q.Declare != nil || q.Close != nil || q.Call != nil
+}
+
+// Whether the query runs without waiting in the queue, so that queries can be
+// listed and killed while all the slots are taken
+func bypassesQueue(query string) bool {
+	q, err := dumbdb.ParseQuery(query)
+	if err != nil {
+		return false
+	}
+	return q.ShowProcessList != nil || q.Kill != nil
+}
+
+func handleClient(ctx context.Context, db *dumbdb.Database, conn net.Conn, opts *options) {
+	defer conn.Close()
+
+	go func(conn net.Conn) {
+		<-ctx.Done()
+		// interrupt blocking read of the next query
+		conn.SetReadDeadline(time.Now())
+	}(conn)
+
+	sess := dumbdb.Session{
+		Client:           conn.RemoteAddr().String(),
+		User:             sessionUser("", opts),
+		StatementTimeout: opts.statementTimeout,
+		WorkMemory:       opts.workMemory,
+		TempDir:          opts.tempDir,
+		MemoryLimit:      opts.queryMemoryLimit,
+		Limits: dumbdb.SessionLimits{
+			StatementTimeout: opts.statementTimeout,
+			WorkMemory:       opts.workMemory,
+			MemoryLimit:      opts.queryMemoryLimit,
+		},
+	}
+	defer sess.CloseCursors()
+
+	// progress is reported only to clients which negotiated it
+	connOpts := *opts
+	connOpts.progressInterval = 0
+
+	closed := func(reason string) {
+		logInfo("disconnected").with("client", conn.RemoteAddr()).with("reason", reason).print()
+	}
+
+	// clients may start with a handshake, older ones send queries right away
+	first := true
+	for {
+		if opts.idleTimeout != 0 {
+			conn.SetReadDeadline(time.Now().Add(opts.idleTimeout))
+		}
+
+		// NOTE: has to be checked after setting the deadline, otherwise
+		//       we could override the deadline set on shutdown
+		if ctx.Err() != nil {
+			closed("shutdown")
+			return
+		}
+
+		query, err := readQuery(conn)
+		if err != nil {
+			if reason := disconnectReason(ctx, err); reason != "" {
+				closed(reason)
+				return
+			}
+
+			logError("receive_failed").with("client", conn.RemoteAddr()).with("error", err).print()
+			return
+		}
+
+		if first {
+			first = false
+			handshake, err := dumbdb.ParseHandshake([]byte(query))
+			if err != nil || handshake != nil {
+				if handshake != nil {
+					sess.User, err = authenticate(handshake, opts)
+				}
+
+				handshake, err = replyHandshake(conn, handshake, err, opts)
+				if err != nil {
+					logError("handshake_failed").with("client", conn.RemoteAddr()).with("error", err).print()
+					return
+				}
+
+				if handshake.Capabilities&dumbdb.CapCompression != 0 {
+					conn = &dumbdb.CompressingConn{Conn: conn, Threshold: opts.compressThreshold}
+				}
+
+				if handshake.Capabilities&dumbdb.CapProgress != 0 {
+					connOpts.progressInterval = opts.progressInterval
+				}
+
+				connOpts.binaryRows = handshake.Capabilities&dumbdb.CapBinaryRows != 0
+				connOpts.copyIn = handshake.Capabilities&dumbdb.CapCopyIn != 0
+
+				if handshake.Capabilities&dumbdb.CapPipelining != 0 {
+					servePipelined(ctx, db, conn, &sess, &connOpts, closed)
+					return
+				}
+				continue
 			}
+		}
+
+		err = serveQuery(ctx, db, conn, &sess, query, &connOpts)
+		if err != nil {
+			logError("send_failed").with("client", conn.RemoteAddr()).with("error", err).print()
+			return
+		}
+	}
+}
 
-			err = dumbdb.SendResponse(conn, &dumbdb.Response{
-				Result: &dumbdb.ResponseChunk{
-					Schema: result.Schema,
-					Rows:   rows,
-				},
-			})
+// Serve requests tagged with ids, up to opts.maxPipelined of them run concurrently
+// and their response chunks are interleaved. Requests changing the session wait for
+// the preceding ones and block the following ones, so they are applied in order
+func servePipelined(ctx context.Context, db *dumbdb.Database, conn net.Conn, sess *dumbdb.Session, opts *options, closed func(reason string)) {
+	var (
+		wg sync.WaitGroup
+		// serializes messages of different requests
+		sendM sync.Mutex
+		// held exclusively by requests modifying the session
+		sessM sync.RWMutex
+		slots = make(chan struct{}, opts.maxPipelined)
+
+		// protects inFlight and the read deadline
+		idleM    sync.Mutex
+		inFlight int
+	)
+	defer wg.Wait()
+
+	// connection is idle only when there are no requests running
+	setDeadline := func() {
+		if opts.idleTimeout == 0 {
+			return
+		}
+
+		idleM.Lock()
+		defer idleM.Unlock()
+
+		if inFlight == 0 {
+			conn.SetReadDeadline(time.Now().Add(opts.idleTimeout))
 		} else {
-			err = dumbdb.SendMessage(conn, []byte(""))
+			conn.SetReadDeadline(time.Time{})
+		}
+
+		// don't override the deadline set on shutdown
+		if ctx.Err() != nil {
+			conn.SetReadDeadline(time.Now())
+		}
+	}
+
+	for {
+		setDeadline()
+		if ctx.Err() != nil {
+			closed("shutdown")
+			return
 		}
 
+		message, err := dumbdb.RecvMessage(conn)
 		if err != nil {
-			log.Printf("[%v] Failed to send response: %v\n", conn.RemoteAddr(), err)
-			break
+			if reason := disconnectReason(ctx, err); reason != "" {
+				closed(reason)
+				return
+			}
+
+			logError("receive_failed").with("client", conn.RemoteAddr()).with("error", err).print()
+			return
+		}
+
+		id, payload, err := dumbdb.UntagMessage(message)
+		if err != nil {
+			logError("receive_failed").with("client", conn.RemoteAddr()).with("error", err).print()
+			return
 		}
+
+		query := string(payload)
+		exclusive := modifiesSession(query)
+		if exclusive {
+			sessM.Lock()
+		} else {
+			sessM.RLock()
+		}
+
+		slots <- struct{}{}
+		idleM.Lock()
+		inFlight++
+		idleM.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := serveQuery(ctx, db, dumbdb.NewRequestConn(conn, id, &sendM), sess, query, opts)
+
+			if exclusive {
+				sessM.Unlock()
+			} else {
+				sessM.RUnlock()
+			}
+			<-slots
+
+			idleM.Lock()
+			inFlight--
+			idleM.Unlock()
+			setDeadline()
+
+			if err != nil {
+				logError("send_failed").with("client", conn.RemoteAddr()).with("error", err).print()
+				// interrupt the read loop
+				conn.Close()
+			}
+		}()
 	}
 }
 
-func runServer(ctx context.Context, db *dumbdb.Database, addr string) error {
+// Reply to the client handshake with the negotiated version and capabilities,
+// returns error if the connection should be closed
+func replyHandshake(conn net.Conn, handshake *dumbdb.Handshake, err error, opts *options) (*dumbdb.Handshake, error) {
+	capabilities := dumbdb.SupportedCapabilities
+	if opts.compressThreshold == 0 {
+		capabilities &^= dumbdb.CapCompression
+	}
+	if opts.maxPipelined == 0 {
+		capabilities &^= dumbdb.CapPipelining
+	}
+	if opts.progressInterval == 0 {
+		capabilities &^= dumbdb.CapProgress
+	}
+
+	if err == nil {
+		handshake, err = dumbdb.NegotiateHandshake(handshake, capabilities)
+	}
+
+	if err != nil {
+		sendErr := dumbdb.SendResponse(conn, dumbdb.ErrorResponse(err))
+		if sendErr != nil {
+			return nil, sendErr
+		}
+		return nil, err
+	}
+
+	return handshake, dumbdb.SendResponse(conn, &dumbdb.Response{Handshake: handshake})
+}
+
+// Send an error to the client over connection limit and close the connection
+func rejectClient(conn net.Conn) {
+	defer conn.Close()
+
+	// don't let a stuck client hold the goroutine
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// protocol is request-response, so wait for the first request to reply to
+	_, err := dumbdb.RecvMessage(conn)
+	if err != nil {
+		return
+	}
+
+	err = dumbdb.SendResponse(conn, dumbdb.ErrorResponse(ErrTooManyConnections))
+	if err != nil {
+		logError("send_failed").with("client", conn.RemoteAddr()).with("error", err).print()
+	}
+}
+
+func runServer(ctx context.Context, db *dumbdb.Database, addr string, opts *options) error {
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
@@ -93,21 +801,147 @@ func runServer(ctx context.Context, db *dumbdb.Database, addr string) error {
 		listener.Close()
 	}()
 
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	conns := make(map[net.Conn]struct{})
+
+	// semaphore limiting the number of active connections
+	var slots chan struct{}
+	if opts.maxConnections > 0 {
+		slots = make(chan struct{}, opts.maxConnections)
+	}
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
-				return nil
+				break
 			}
 
 			return err
 		}
 
-		log.Printf("[%v] Connected\n", conn.RemoteAddr())
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			default:
+				logWarn("rejected").with("client", conn.RemoteAddr()).with("reason", "too many connections").print()
+				go rejectClient(conn)
+				continue
+			}
+		}
+
+		logInfo("connected").with("client", conn.RemoteAddr()).print()
+
+		m.Lock()
+		conns[conn] = struct{}{}
+		m.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// NOTE: connection context is cancelled together with the server context
+			connCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			dumbdb.DefaultMetrics.ActiveConnections.Add(1)
+			handleClient(connCtx, db, conn, opts)
+			dumbdb.DefaultMetrics.ActiveConnections.Add(-1)
+
+			m.Lock()
+			delete(conns, conn)
+			m.Unlock()
+
+			if slots != nil {
+				<-slots
+			}
+		}()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(opts.shutdownTimeout):
+	}
+
+	m.Lock()
+	log.Printf("Connections didn't finish in %v, closing %v of them\n", opts.shutdownTimeout, len(conns))
+	for conn := range conns {
+		conn.Close()
+	}
+	m.Unlock()
+
+	<-drained
+	return nil
+}
+
+// Serve prometheus metrics over http until ctx is done
+func runMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		err := dumbdb.DefaultMetrics.WritePrometheus(w)
+		if err != nil {
+			log.Println("Failed to write metrics:", err)
+		}
+	})
 
-		// TODO: pass ctx to handleClient()
-		go handleClient(db, conn)
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Println("Serving metrics on", addr)
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Println("Metrics server error:", err)
+	}
+}
+
+func restoreBackup(filename string, dataDir string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return dumbdb.Restore(file, dataDir)
+}
+
+// Print the line of the users file for the user, the password is read from
+// dumbdb.PasswordEnv or, if it isn't set, from the first line of stdin
+func printPasswordHash(user string) error {
+	password, ok := os.LookupEnv(dumbdb.PasswordEnv)
+	if !ok {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	if password == "" {
+		return errors.New("password is empty")
+	}
+
+	line, err := dumbdb.HashPassword(user, password)
+	if err != nil {
+		return err
 	}
+	fmt.Println(line)
+	return nil
 }
 
 func main() {
@@ -118,9 +952,127 @@ func main() {
 
 	dataDir := flag.String("data", cwd, "data directory")
 	addr := flag.String("addr", "localhost:1337", "address to bind to")
+	resultMem := flag.Int("result-mem", dumbdb.DefaultSpoolMemory, "max memory (in bytes) buffered per query result before spilling to disk")
+	tempDir := flag.String("temp-dir", "", "directory for spilled results, sorts and distinct (system default if empty)")
+	workMem := flag.Int("work-mem", dumbdb.DefaultWorkMemory, "max memory (in bytes) of a query for sorts and distinct before spilling to disk, users can only lower it")
+	queryMemLimit := flag.Int64("query-mem-limit", 0, "max memory (in bytes) of a single query, exceeding it fails the query, users can only lower it (0 means no limit)")
+	memLimit := flag.Int64("mem-limit", 0, "max memory (in bytes) of all the running queries together, exceeding it fails the query (0 means no limit)")
+	chunkRows := flag.Int("chunk-rows", dumbdb.DefaultChunkRows, "max number of rows sent in a single response chunk")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for connections to finish on shutdown")
+	statementTimeout := flag.Duration("statement-timeout", 0, "max duration of a single query, users can only lower it (0 means no limit)")
+	maxConnections := flag.Int("max-connections", 100, "max number of concurrent connections (0 means no limit)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "close connections idle for this long (0 means never)")
+	metricsAddr := flag.String("metrics-addr", "", "address to serve prometheus metrics on (disabled if empty)")
+	slowQuery := flag.Duration("slow-query", time.Second, "log queries running longer than this as slow (0 disables)")
+	compressThreshold := flag.Int("compress-threshold", dumbdb.DefaultCompressionThreshold, "compress responses larger than this (in bytes) for clients supporting it (0 disables)")
+	maxRunning := flag.Int("max-running-queries", 0, "max number of queries running at once, others wait in the queue (0 means no limit)")
+	maxQueued := flag.Int("max-queued-queries", 0, "max number of queries waiting in the queue, others are rejected (0 means no limit)")
+	queueTimeout := flag.Duration("queue-timeout", 30*time.Second, "how long a query can wait in the queue before it fails (0 means no limit)")
+	maxPipelined := flag.Int("max-pipelined", 8, "max number of pipelined queries of a single connection executed concurrently (0 disables pipelining)")
+	progressInterval := flag.Duration("progress-interval", time.Second, "how often to report progress of running queries to clients supporting it (0 disables)")
+	storage := flag.String("storage", dumbdb.StorageFile, "storage of table files: file (buffered by the OS), mmap or direct (O_DIRECT, bypassing the OS page cache)")
+	readOnly := flag.Bool("read-only", false, "reject statements modifying the database, data directory isn't written to")
+	keyFile := flag.String("encryption-keyfile", "", "file with the hex-encoded 32-byte key of tables created with encryption = aes_gcm (read from $"+dumbdb.EncryptionKeyEnv+" if empty)")
+	restore := flag.String("restore", "", "restore backup file into the (empty) data directory before starting")
+	migrate := flag.Bool("migrate", false, "upgrade files of the data directory written by an older version before starting")
+	accessControl := flag.Bool("access-control", false, "limit clients to the privileges granted to the user they connect as, their passwords are checked against -users-file")
+	adminUser := flag.String("admin-user", "admin", "user having all the privileges, who grants them to others, if -access-control is set")
+	usersFile := flag.String("users-file", "", "file with the users allowed to connect and hashes of their passwords, one line per user printed by -hash-password (required by -access-control)")
+	hashPassword := flag.String("hash-password", "", "print the line of -users-file for the user, whose password is read from $"+dumbdb.PasswordEnv+" or stdin, and exit")
+	auditLog := flag.String("audit-log", "", "file to log statements modifying the database to (disabled if empty)")
+	auditLogSize := flag.Int64("audit-log-size", 100<<20, "rotate the audit log once it's larger than this (in bytes, 0 means never)")
+	auditLogFiles := flag.Int("audit-log-files", 5, "number of rotated audit log files to keep")
+	copyAckRows := flag.Int("copy-ack-rows", dumbdb.DefaultCopyAckRows, "acknowledge rows streamed by copy from stdin every this many rows")
+	ttlInterval := flag.Duration("ttl-interval", time.Minute, "how often to delete expired rows of tables with ttl (0 disables)")
 	flag.Parse()
 
-	db, err := dumbdb.NewDatabase(*dataDir)
+	opts := &options{
+		memLimit:  *resultMem,
+		tempDir:   *tempDir,
+		chunkRows: *chunkRows,
+
+		shutdownTimeout:  *shutdownTimeout,
+		statementTimeout: *statementTimeout,
+		workMemory:       *workMem,
+		queryMemoryLimit: *queryMemLimit,
+
+		maxConnections: *maxConnections,
+		idleTimeout:    *idleTimeout,
+
+		slowQuery: *slowQuery,
+
+		compressThreshold: *compressThreshold,
+		maxPipelined:      *maxPipelined,
+
+		progressInterval: *progressInterval,
+
+		copyAckRows: *copyAckRows,
+
+		accessControl: *accessControl,
+		adminUser:     *adminUser,
+	}
+
+	if *hashPassword != "" {
+		err = printPasswordHash(*hashPassword)
+		if err != nil {
+			fmt.Println("Failed to hash password:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *accessControl {
+		if *usersFile == "" {
+			fmt.Println("-access-control requires -users-file, so that users are authenticated")
+			return
+		}
+
+		opts.credentials, err = dumbdb.LoadCredentials(*usersFile)
+		if err != nil {
+			fmt.Println("Failed to load users file:", err)
+			return
+		}
+	}
+
+	if *maxRunning > 0 {
+		opts.queue = dumbdb.NewQueryQueue(*maxRunning, *maxQueued, *queueTimeout)
+	}
+
+	if *restore != "" {
+		err = restoreBackup(*restore, *dataDir)
+		if err != nil {
+			fmt.Println("Failed to restore backup:", err)
+			return
+		}
+		log.Println("Restored backup", *restore)
+	}
+
+	var audit *dumbdb.AuditLog
+	if *auditLog != "" {
+		audit, err = dumbdb.NewAuditLog(*auditLog, *auditLogSize, *auditLogFiles)
+		if err != nil {
+			fmt.Println("Failed to open audit log:", err)
+			return
+		}
+		defer audit.Close()
+	}
+
+	key, err := dumbdb.LoadEncryptionKey(*keyFile)
+	if err != nil {
+		fmt.Println("Failed to load encryption key:", err)
+		return
+	}
+
+	if *migrate {
+		err = dumbdb.Migrate(*dataDir, key)
+		if err != nil {
+			fmt.Println("Failed to migrate data directory:", err)
+			return
+		}
+		log.Println("Migrated data directory to format version", dumbdb.FormatVersion)
+	}
+
+	db, err := dumbdb.OpenDatabase(dumbdb.Options{DataDir: *dataDir, Storage: *storage, ReadOnly: *readOnly, MemoryLimit: *memLimit, EncryptionKey: key, AuditLog: audit})
 	if err != nil {
 		fmt.Println("Failed to initialize database:", err)
 		return
@@ -137,14 +1089,33 @@ func main() {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	go func() {
 		<-c
+		log.Println("Shutting down, interrupt again to force")
 		cancel()
+
+		<-c
+		log.Println("Forced shutdown")
+		os.Exit(1)
 	}()
 
-	err = runServer(ctx, db, *addr)
+	if *metricsAddr != "" {
+		go runMetrics(ctx, *metricsAddr)
+	}
+
+	if *ttlInterval != 0 && !*readOnly {
+		// the database is closed once expiration stops
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			runExpiration(ctx, db, *ttlInterval)
+		}()
+		defer func() { <-stopped }()
+	}
+
+	err = runServer(ctx, db, *addr, opts)
 	if err != nil {
 		log.Fatal("Server error:", err)
 	}
@@ -0,0 +1,259 @@
+package dumbdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var ErrUnknownVariable = errors.New("unknown session variable")
+
+// Per-connection state
+type Session struct {
+	// name of the current database, empty means DefaultDatabase
+	Database string
+	// address of the client, shown by `show processlist`
+	Client string
+	// user the session is authenticated as, its queries are limited to the
+	// privileges granted to the user, see Grant. Empty means all privileges
+	User string
+
+	// max duration of a single query, 0 means no limit, see QueryContext()
+	StatementTimeout time.Duration
+	// max number of rows returned by a query, 0 means no limit
+	RowLimit int64
+	// execute the rest of a batch of statements after one of them fails
+	ContinueOnError bool
+	// memory of a query for sorts and distinct before they spill
+	// rows to disk, 0 means DefaultWorkMemory
+	WorkMemory int
+	// directory for the spilled rows, empty means the system default
+	TempDir string
+	// max memory of a single query in bytes, 0 means no limit, see MemoryAccount
+	MemoryLimit int64
+	// limits set by the server, users other than admins can only lower
+	// the variables under them and can't turn the limits off
+	Limits SessionLimits
+
+	// cursors opened by declare, see CloseCursors()
+	cursors map[string]*cursor
+}
+
+// Ceilings of the session variables, 0 means there is no ceiling. Work memory
+// can't be unlimited, so its ceiling is DefaultWorkMemory if it isn't set
+type SessionLimits struct {
+	StatementTimeout time.Duration
+	WorkMemory       int
+	MemoryLimit      int64
+}
+
+// Check the value of the limit set by the user of the session, admins can set
+// any value. 0 means no limit, the ceiling is the value set by the server
+func (sess *Session) checkLimit(name string, value int64, ceiling int64) error {
+	switch {
+	case sess.User == "":
+		return nil
+	case value == 0:
+		return fmt.Errorf("%w: only admins can set %v to 0 (no limit)", ErrAccessDenied, name)
+	case ceiling != 0 && value > ceiling:
+		return fmt.Errorf("%w: %v can't be raised over %v set by the server", ErrAccessDenied, name, ceiling)
+	default:
+		return nil
+	}
+}
+
+// Variables settable with `set name = value`, in the order of `show variables`
+var sessionVariables = []struct {
+	name string
+	set  func(sess *Session, value *Literal) error
+	get  func(sess *Session) string
+}{
+	{
+		name: "statement_timeout",
+		set: func(sess *Session, value *Literal) error {
+			if value.Int == nil || *value.Int < 0 {
+				return errors.New("statement_timeout should be a non-negative number of milliseconds")
+			}
+
+			err := sess.checkLimit("statement_timeout", *value.Int, sess.Limits.StatementTimeout.Milliseconds())
+			if err != nil {
+				return err
+			}
+			sess.StatementTimeout = time.Duration(*value.Int) * time.Millisecond
+			return nil
+		},
+		get: func(sess *Session) string {
+			return fmt.Sprint(sess.StatementTimeout.Milliseconds())
+		},
+	},
+	{
+		name: "row_limit",
+		set: func(sess *Session, value *Literal) error {
+			if value.Int == nil || *value.Int < 0 {
+				return errors.New("row_limit should be a non-negative number of rows")
+			}
+			sess.RowLimit = *value.Int
+			return nil
+		},
+		get: func(sess *Session) string {
+			return fmt.Sprint(sess.RowLimit)
+		},
+	},
+	{
+		name: "continue_on_error",
+		set: func(sess *Session, value *Literal) error {
+			if value.Bool == nil {
+				return errors.New("continue_on_error should be true or false")
+			}
+			sess.ContinueOnError = bool(*value.Bool)
+			return nil
+		},
+		get: func(sess *Session) string {
+			return fmt.Sprint(sess.ContinueOnError)
+		},
+	},
+	{
+		name: "work_memory",
+		set: func(sess *Session, value *Literal) error {
+			if value.Int == nil || *value.Int < 0 || *value.Int > math.MaxInt32 {
+				return errors.New("work_memory should be a non-negative number of bytes")
+			}
+
+			// 0 is the default, not unlimited memory
+			n, ceiling := *value.Int, int64(sess.Limits.WorkMemory)
+			if n == 0 {
+				n = DefaultWorkMemory
+			}
+			if ceiling == 0 {
+				ceiling = DefaultWorkMemory
+			}
+			err := sess.checkLimit("work_memory", n, ceiling)
+			if err != nil {
+				return err
+			}
+			sess.WorkMemory = int(*value.Int)
+			return nil
+		},
+		get: func(sess *Session) string {
+			if sess.WorkMemory == 0 {
+				return fmt.Sprint(DefaultWorkMemory)
+			}
+			return fmt.Sprint(sess.WorkMemory)
+		},
+	},
+	{
+		name: "query_memory_limit",
+		set: func(sess *Session, value *Literal) error {
+			if value.Int == nil || *value.Int < 0 {
+				return errors.New("query_memory_limit should be a non-negative number of bytes")
+			}
+
+			err := sess.checkLimit("query_memory_limit", *value.Int, sess.Limits.MemoryLimit)
+			if err != nil {
+				return err
+			}
+			sess.MemoryLimit = *value.Int
+			return nil
+		},
+		get: func(sess *Session) string {
+			return fmt.Sprint(sess.MemoryLimit)
+		},
+	},
+}
+
+// Default memory of a query for sorts and distinct, see Session.WorkMemory
+const DefaultWorkMemory = 16 * 1024 * 1024
+
+type workMemoryKey struct{}
+
+type workMemory struct {
+	memLimit int
+	tempDir  string
+}
+
+// Returns context which limits memory used by sorts and distinct of
+// the queries executed with it, 0 means DefaultWorkMemory. Rows exceeding it are
+// spilled to tempDir, the system default directory for temporary files if empty
+func WithWorkMemory(ctx context.Context, memLimit int, tempDir string) context.Context {
+	return context.WithValue(ctx, workMemoryKey{}, workMemory{memLimit: memLimit, tempDir: tempDir})
+}
+
+// Memory limit and directory for spilled rows set by WithWorkMemory()
+func queryWorkMemory(ctx context.Context) (int, string) {
+	mem, _ := ctx.Value(workMemoryKey{}).(workMemory)
+	if mem.memLimit == 0 {
+		mem.memLimit = DefaultWorkMemory
+	}
+	return mem.memLimit, mem.tempDir
+}
+
+// Context of a query of the session, with its work memory and memory account,
+// which reserves memory from pool (can be nil)
+func (sess *Session) memoryContext(ctx context.Context, pool *MemoryPool) context.Context {
+	if sess == nil {
+		return WithMemoryAccount(ctx, NewMemoryAccount(0, pool))
+	}
+
+	ctx = WithWorkMemory(ctx, sess.WorkMemory, sess.TempDir)
+	return WithMemoryAccount(ctx, NewMemoryAccount(sess.MemoryLimit, pool))
+}
+
+func (sess *Session) user() string {
+	if sess == nil {
+		return ""
+	}
+	return sess.User
+}
+
+func (sess *Session) currentDatabase() string {
+	if sess == nil || sess.Database == "" {
+		return DefaultDatabase
+	}
+	return sess.Database
+}
+
+// Context of a single query, which is done once the statement timeout is exceeded
+func (sess *Session) QueryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if sess == nil || sess.StatementTimeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, sess.StatementTimeout)
+}
+
+func (sess *Session) doSet(set *Set) (*Result, error) {
+	if sess == nil {
+		return nil, errors.New("set requires a session")
+	}
+
+	for _, variable := range sessionVariables {
+		if variable.name == set.Name {
+			return nil, variable.set(sess, &set.Value)
+		}
+	}
+	return nil, fmt.Errorf("%w %v", ErrUnknownVariable, set.Name)
+}
+
+func (sess *Session) doShowVariables() (*Result, error) {
+	if sess == nil {
+		sess = &Session{}
+	}
+
+	var schema Schema
+	schema.addField(Field{Name: "variable", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "value", TypeID: TypeVarchar, Len: 255})
+
+	rows := make([]Row, 0, len(sessionVariables))
+	for _, variable := range sessionVariables {
+		rows = append(rows, Row{
+			{TypeID: TypeVarchar, Str: variable.name},
+			{TypeID: TypeVarchar, Str: variable.get(sess)},
+		})
+	}
+
+	return &Result{
+		Schema: schema,
+		Rows:   StaticRows(rows),
+	}, nil
+}
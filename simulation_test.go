@@ -0,0 +1,315 @@
+package dumbdb_test
+
+import (
+	"bytes"
+	"dumbdb"
+	"dumbdb/dumbdbtest"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// Expected state of the pages
+type pagerModel struct {
+	// contents of the pages as seen through the pager
+	pages [][]byte
+	// contents each page may have in the storage: the last synced one and the
+	// ones after it, as dirty pages are written when evicted. nil when unknown,
+	// i.e. for pages torn by a crash
+	durable [][][]byte
+	// number of pages in the index of the storage, and in the index being synced
+	durableEntries   int
+	attemptedEntries int
+}
+
+func (model *pagerModel) allocate() {
+	zero := make([]byte, dumbdb.PageDataSize)
+	model.pages = append(model.pages, zero)
+	model.durable = append(model.durable, [][]byte{zero})
+}
+
+func (model *pagerModel) update(id dumbdb.PageID, data []byte) {
+	model.pages[id] = append([]byte(nil), data...)
+	if model.durable[id] != nil {
+		model.durable[id] = append(model.durable[id], model.pages[id])
+	}
+}
+
+func (model *pagerModel) synced(id dumbdb.PageID) {
+	model.durable[id] = [][]byte{model.pages[id]}
+}
+
+func (model *pagerModel) truncate(n int) {
+	model.pages = model.pages[:n]
+	model.durable = model.durable[:n]
+}
+
+// Reconcile the model with the storage left by the crash, n is the number of
+// pages in its index
+func (model *pagerModel) crash(n int, tornID dumbdb.PageID) error {
+	if n != model.durableEntries && n != model.attemptedEntries {
+		return fmt.Errorf("%v pages in the index after the crash, expected %v or %v", n, model.durableEntries, model.attemptedEntries)
+	}
+
+	if int(tornID) < len(model.durable) {
+		model.durable[tornID] = nil
+	}
+
+	for len(model.durable) < n {
+		model.durable = append(model.durable, nil)
+	}
+	model.durable = model.durable[:n]
+	model.pages = make([][]byte, n)
+	model.durableEntries = n
+	model.attemptedEntries = n
+	return nil
+}
+
+// Check that the pages of the reopened storage have one of the possible
+// contents, and take them as the current ones. Torn pages fail the checksum,
+// they are dropped along with the pages after them
+func (model *pagerModel) reopen(pager *dumbdb.Pager) error {
+	for id, versions := range model.durable {
+		page, err := pager.FetchPage(dumbdb.PageID(id))
+		for errors.Is(err, dumbdbtest.ErrInjected) {
+			page, err = pager.FetchPage(dumbdb.PageID(id))
+		}
+		if versions == nil && errors.Is(err, dumbdb.ErrCorruptedPage) {
+			model.attemptedEntries = id
+			err = pager.TruncatePages(uint32(id))
+			if err != nil {
+				return err
+			}
+
+			model.truncate(id)
+			model.durableEntries = id
+			model.attemptedEntries = id
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("page %v: %w", id, err)
+		}
+
+		page.RLock()
+		data := append([]byte(nil), page.Data()...)
+		page.RUnlock()
+		page.Unpin()
+
+		found := versions == nil
+		for _, version := range versions {
+			found = found || bytes.Equal(data, version)
+		}
+
+		if !found {
+			return fmt.Errorf("page %v doesn't have any of %v expected contents", id, len(versions))
+		}
+
+		model.pages[id] = data
+		model.durable[id] = [][]byte{data}
+	}
+	return nil
+}
+
+// Check that the pager returns the current contents of the pages
+func (model *pagerModel) check(pager *dumbdb.Pager) error {
+	for id, expected := range model.pages {
+		page, err := pager.FetchPage(dumbdb.PageID(id))
+		if err != nil {
+			return err
+		}
+
+		page.RLock()
+		equal := bytes.Equal(page.Data(), expected)
+		page.RUnlock()
+		page.Unpin()
+
+		if !equal {
+			return fmt.Errorf("page %v doesn't have the expected contents", id)
+		}
+	}
+	return nil
+}
+
+// Run random operations on a pager until the storage crashes, then reopen it
+// and check that acknowledged writes survived. Returns error describing the
+// first violated invariant
+func simulatePager(seed int64, rounds int, steps int) error {
+	rnd := rand.New(rand.NewSource(seed))
+	disk := dumbdbtest.NewMemoryStorage()
+	model := &pagerModel{}
+
+	for round := 0; round < rounds; round++ {
+		storage := dumbdbtest.NewFaultStorage(disk, rnd.Int63())
+		storage.CrashAt = rnd.Intn(2 * steps)
+		storage.TornWrites = rnd.Intn(2) == 0
+		storage.ShortReadRate = 0.05
+
+		err := simulateRound(rnd, storage, model, steps)
+		if err != nil {
+			return fmt.Errorf("round %v: %w", round, err)
+		}
+	}
+	return nil
+}
+
+func simulateRound(rnd *rand.Rand, storage *dumbdbtest.FaultStorage, model *pagerModel, steps int) error {
+	pager, err := dumbdb.NewPager(1+rnd.Intn(4), storage)
+	if errors.Is(err, dumbdbtest.ErrInjected) {
+		// short read of the index, the storage is fine
+		storage.ShortReadRate = 0
+		pager, err = dumbdb.NewPager(1+rnd.Intn(4), storage)
+	}
+
+	if errors.Is(err, dumbdbtest.ErrCrashed) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open the pager: %w", err)
+	}
+
+	err = dumbdbtest.CheckAllocationIndex(pager)
+	if err != nil {
+		return err
+	}
+
+	err = model.reopen(pager)
+	if err != nil && !errors.Is(err, dumbdbtest.ErrCrashed) {
+		return err
+	}
+
+	for step := 0; step < steps && err == nil; step++ {
+		err = simulateStep(rnd, pager, model)
+		if errors.Is(err, dumbdbtest.ErrInjected) {
+			err = nil
+		}
+	}
+
+	if err == nil {
+		// no crash, make the pages durable to check them after the reopen
+		model.attemptedEntries = len(model.pages)
+		err = pager.SyncAll()
+		if err == nil {
+			for id := range model.pages {
+				model.synced(dumbdb.PageID(id))
+			}
+			model.durableEntries = len(model.pages)
+			return nil
+		}
+	}
+
+	if !errors.Is(err, dumbdbtest.ErrCrashed) {
+		return err
+	}
+
+	// reopen the storage as it was left by the crash
+	tornID := dumbdb.InvalidPageID
+	if offset := storage.TornOffset(); offset > 0 {
+		tornID = dumbdb.PageID(offset/int64(dumbdb.PageSize) - 1)
+	}
+
+	index, err := dumbdb.ReadAllocationIndex(storage.Storage)
+	if err != nil {
+		return err
+	}
+	return model.crash(int(index.NumEntries()), tornID)
+}
+
+// Perform a random operation and update the model, errors other than injected
+// faults and crashes are violations of invariants
+func simulateStep(rnd *rand.Rand, pager *dumbdb.Pager, model *pagerModel) error {
+	n := len(model.pages)
+	switch op := rnd.Intn(100); {
+	case op < 15 || n == 0:
+		id, err := pager.AllocatePage()
+		if err != nil {
+			return err
+		}
+
+		if int(id) != n {
+			return fmt.Errorf("allocated page %v, expected %v", id, n)
+		}
+		model.allocate()
+		return nil
+	case op < 55:
+		id := dumbdb.PageID(rnd.Intn(n))
+		page, err := pager.FetchPage(id)
+		if err != nil {
+			return err
+		}
+		defer page.Unpin()
+
+		page.Lock()
+		defer page.Unlock()
+
+		if !bytes.Equal(page.Data(), model.pages[id]) {
+			return fmt.Errorf("page %v doesn't have the expected contents before the update", id)
+		}
+
+		offset := rnd.Intn(int(dumbdb.PageDataSize))
+		rnd.Read(page.Data()[offset:])
+		page.MarkDirty()
+		model.update(id, page.Data())
+		return nil
+	case op < 75:
+		id := dumbdb.PageID(rnd.Intn(n))
+		page, err := pager.FetchPage(id)
+		if err != nil {
+			return err
+		}
+		defer page.Unpin()
+
+		page.RLock()
+		defer page.RUnlock()
+
+		err = pager.SyncPage(id, page)
+		if err != nil {
+			return err
+		}
+
+		model.synced(id)
+		return nil
+	case op < 85:
+		model.attemptedEntries = n
+		err := pager.SyncMetadata()
+		if err != nil {
+			return err
+		}
+
+		model.durableEntries = n
+		return nil
+	case op < 95:
+		return model.check(pager)
+	default:
+		// dropped pages are gone whether they were synced or not
+		keep := rnd.Intn(n + 1)
+		if keep < n {
+			model.attemptedEntries = keep
+		}
+		err := pager.TruncatePages(uint32(keep))
+		if err != nil {
+			return err
+		}
+
+		// index is synced only if some pages are dropped
+		if keep < n {
+			model.truncate(keep)
+			model.durableEntries = keep
+		}
+		return nil
+	}
+}
+
+func TestPagerSimulation(t *testing.T) {
+	seeds := 200
+	if testing.Short() {
+		seeds = 20
+	}
+
+	for seed := 0; seed < seeds; seed++ {
+		err := simulatePager(int64(seed), 8, 60)
+		if err != nil {
+			t.Fatalf("seed %v: %v", seed, err)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package dumbdb
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"sort"
+)
+
+// Number of sorted runs merged at once, more runs are merged in several passes,
+// so that the number of open spill files is bounded
+const sortMergeWidth = 64
+
+// Chunks of rows read by the operators, returns io.EOF after the last one
+type rowChunks func() ([]Row, error)
+
+func chunksOf(rows *Rows) rowChunks {
+	return func() ([]Row, error) {
+		if !rows.Next() {
+			err := rows.Err()
+			if err == nil {
+				err = io.EOF
+			}
+			return nil, err
+		}
+		return []Row{rows.Row()}, nil
+	}
+}
+
+func spoolChunks(spool *Spool) rowChunks {
+	return func() ([]Row, error) {
+		return spool.Next(DefaultChunkRows)
+	}
+}
+
+// Next row of a sorted run being merged
+type sortCursor struct {
+	run   int // index of the run, so that equal rows keep their order
+	rows  []Row
+	chunk rowChunks
+}
+
+type sortHeap struct {
+	cursors []*sortCursor
+	less    func(a, b Row) bool
+}
+
+func (h *sortHeap) Len() int {
+	return len(h.cursors)
+}
+
+func (h *sortHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	if h.less(a.rows[0], b.rows[0]) {
+		return true
+	}
+	if h.less(b.rows[0], a.rows[0]) {
+		return false
+	}
+	return a.run < b.run
+}
+
+func (h *sortHeap) Swap(i, j int) {
+	h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i]
+}
+
+func (h *sortHeap) Push(x interface{}) {
+	h.cursors = append(h.cursors, x.(*sortCursor))
+}
+
+func (h *sortHeap) Pop() interface{} {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
+
+// External merge sort: rows are sorted in memory until memLimit is reached,
+// then the sorted run is spilled to a temporary file, and the runs are merged
+type rowSorter struct {
+	less     func(a, b Row) bool
+	memLimit int
+	tempDir  string
+	memory   *MemoryAccount
+
+	mem     []Row
+	memSize int
+	runs    []*Spool
+}
+
+func (s *rowSorter) Add(row Row) error {
+	size := rowMemSize(row)
+	reserved := false
+	if s.memSize+size <= s.memLimit {
+		// the run is spilled once the query runs out of memory too
+		reserved = s.memory.Reserve(size) == nil
+	}
+
+	if !reserved && len(s.mem) != 0 {
+		err := s.spill()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !reserved {
+		err := s.memory.Reserve(size)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mem = append(s.mem, row)
+	s.memSize += size
+	return nil
+}
+
+// Write rows kept in memory as a sorted run
+func (s *rowSorter) spill() error {
+	sort.SliceStable(s.mem, func(i, j int) bool {
+		return s.less(s.mem[i], s.mem[j])
+	})
+
+	run := NewSpool(0, s.tempDir)
+	s.runs = append(s.runs, run)
+	for _, row := range s.mem {
+		err := run.Push(row)
+		if err != nil {
+			return err
+		}
+	}
+	run.CloseWrite(nil)
+
+	s.mem = nil
+	s.memory.Release(s.memSize)
+	s.memSize = 0
+	return nil
+}
+
+// Emit rows in order, has to be called after all the rows are added
+func (s *rowSorter) Finish(ctx context.Context, emit func(Row) error) error {
+	if s.runs == nil {
+		sort.SliceStable(s.mem, func(i, j int) bool {
+			return s.less(s.mem[i], s.mem[j])
+		})
+
+		for _, row := range s.mem {
+			err := emit(row)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := s.spill()
+	if err != nil {
+		return err
+	}
+
+	// consecutive runs are merged, so that equal rows keep their order
+	for len(s.runs) > sortMergeWidth {
+		var merged []*Spool
+		for i := 0; i < len(s.runs); i += sortMergeWidth {
+			end := i + sortMergeWidth
+			if end > len(s.runs) {
+				end = len(s.runs)
+			}
+
+			run := NewSpool(0, s.tempDir)
+			merged = append(merged, run)
+			err = s.merge(ctx, s.runs[i:end], run.Push)
+			run.CloseWrite(nil)
+			if err != nil {
+				s.runs = append(merged, s.runs[i:]...)
+				return err
+			}
+
+			for _, spool := range s.runs[i:end] {
+				spool.Close()
+			}
+		}
+		s.runs = merged
+	}
+
+	return s.merge(ctx, s.runs, emit)
+}
+
+func (s *rowSorter) merge(ctx context.Context, runs []*Spool, emit func(Row) error) error {
+	h := &sortHeap{less: s.less}
+	for i, run := range runs {
+		cursor := &sortCursor{run: i, chunk: spoolChunks(run)}
+		ok, err := cursor.next()
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			h.cursors = append(h.cursors, cursor)
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() != 0 {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		cursor := h.cursors[0]
+		err = emit(cursor.rows[0])
+		if err != nil {
+			return err
+		}
+
+		cursor.rows = cursor.rows[1:]
+		ok, err := cursor.next()
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// Read the next chunk of the run if the current one is consumed, returns false at the end of the run
+func (c *sortCursor) next() (bool, error) {
+	if len(c.rows) != 0 {
+		return true, nil
+	}
+
+	rows, err := c.chunk()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	c.rows = rows
+	return len(c.rows) != 0, nil
+}
+
+// Remove spill files
+func (s *rowSorter) Close() error {
+	var err error
+	for _, run := range s.runs {
+		if closeErr := run.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	s.runs = nil
+	s.mem = nil
+	s.memory.Release(s.memSize)
+	s.memSize = 0
+	return err
+}
+
+// Sort rows by less, equal rows keep their order. Rows are spilled to temporary
+// files once memLimit is exceeded, tempDir can be empty, in which case the
+// default directory for temporary files is used
+func SortRows(ctx context.Context, rows *Rows, less func(a, b Row) bool, memLimit int, tempDir string) *Rows {
+	sorted := NewRows(ctx, func(ctx context.Context, emit func(Row) error) error {
+		defer rows.Close()
+
+		sorter := &rowSorter{less: less, memLimit: memLimit, tempDir: tempDir, memory: queryMemoryAccount(ctx)}
+		defer sorter.Close()
+
+		for rows.Next() {
+			err := sorter.Add(rows.Row())
+			if err != nil {
+				return err
+			}
+		}
+
+		err := rows.Err()
+		if err != nil {
+			return err
+		}
+
+		return sorter.Finish(ctx, emit)
+	})
+	sorted.schema = rows.schema
+	return sorted
+}
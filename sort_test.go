@@ -0,0 +1,53 @@
+package dumbdb
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSortRows(t *testing.T) {
+	const nRows = 5000
+
+	rng := rand.New(rand.NewSource(1))
+	rows := make([]Row, 0, nRows)
+	for i := 0; i < nRows; i++ {
+		rows = append(rows, Row{IntValue(int32(rng.Intn(100))), IntValue(int32(i))})
+	}
+
+	less := func(a, b Row) bool {
+		return a[0].Int < b[0].Int
+	}
+
+	want := append([]Row(nil), rows...)
+	sort.SliceStable(want, func(i, j int) bool {
+		return less(want[i], want[j])
+	})
+
+	// with the small limits runs are spilled, and merged in several passes
+	for _, memLimit := range []int{DefaultWorkMemory, 4096, 200} {
+		dir := t.TempDir()
+		sorted, err := SortRows(context.Background(), StaticRows(rows), less, memLimit, dir).All()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(sorted) != nRows {
+			t.Fatalf("memLimit %v: expected %v rows, got %v", memLimit, nRows, len(sorted))
+		}
+
+		// equal rows keep their order
+		for i := range sorted {
+			if sorted[i][0].Int != want[i][0].Int || sorted[i][1].Int != want[i][1].Int {
+				t.Fatalf("memLimit %v: row %v is %v, expected %v", memLimit, i, sorted[i], want[i])
+			}
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		if err != nil || len(files) != 0 {
+			t.Fatalf("memLimit %v: expected spill files to be removed, got %v (%v)", memLimit, len(files), err)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package dumbdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+var ErrSpoolClosed = errors.New("spool is closed")
+
+const (
+	// Default memory ceiling for rows buffered by Spool
+	DefaultSpoolMemory = 16 * 1024 * 1024
+
+	// Default number of rows sent in a single response chunk
+	DefaultChunkRows = 1024
+)
+
+// Approximate amount of memory used by the row
+func rowMemSize(row Row) int {
+	size := 24 // slice header
+	for _, val := range row {
+		size += 32 + len(val.Str)
+	}
+	return size
+}
+
+// Buffer between result producer and (possibly slow) consumer
+// Rows are kept in memory until memLimit is reached, after that
+// they are spilled to a temporary file
+type Spool struct {
+	memLimit int
+	tempDir  string
+	// rows kept in memory are reserved from the account, they are spilled
+	// once it's exhausted. nil if memory of the query isn't accounted
+	memory *MemoryAccount
+
+	m    sync.Mutex
+	cond *sync.Cond
+
+	// rows kept in memory, all of them precede rows in spill file
+	mem     []Row
+	memSize int
+
+	// spill file, created lazily
+	file     *os.File
+	readFile *os.File
+	reader   *bufio.Reader
+	written  int // number of rows written to file
+	consumed int // number of rows read back from file
+
+	closed bool // no more rows will be pushed
+	err    error
+}
+
+// tempDir can be empty, in which case the default directory for temporary files is used
+func NewSpool(memLimit int, tempDir string) *Spool {
+	spool := &Spool{
+		memLimit: memLimit,
+		tempDir:  tempDir,
+	}
+	spool.cond = sync.NewCond(&spool.m)
+	return spool
+}
+
+// Start a goroutine which moves rows to the spool
+func SpoolRows(rows *Rows, memLimit int, tempDir string) *Spool {
+	spool := NewSpool(memLimit, tempDir)
+	spool.memory = rows.memory
+	go func() {
+		for rows.Next() {
+			err := spool.Push(rows.Row())
+			if err != nil {
+				// stop the producer, spool is already closed
+				rows.Close()
+				break
+			}
+		}
+		spool.CloseWrite(rows.Err())
+	}()
+	return spool
+}
+
+func (spool *Spool) spill(row Row) error {
+	if spool.file == nil {
+		file, err := ioutil.TempFile(spool.tempDir, "dumbdb-spool-")
+		if err != nil {
+			return err
+		}
+
+		readFile, err := os.Open(file.Name())
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return err
+		}
+
+		spool.file = file
+		spool.readFile = readFile
+		spool.reader = bufio.NewReader(readFile)
+	}
+
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+
+	data = append(data, '\n')
+	_, err = spool.file.Write(data)
+	if err != nil {
+		return err
+	}
+
+	spool.written++
+	return nil
+}
+
+func (spool *Spool) Push(row Row) error {
+	spool.m.Lock()
+	defer spool.m.Unlock()
+
+	if spool.err != nil {
+		return spool.err
+	}
+
+	size := rowMemSize(row)
+	// keep the order: once something is spilled, everything goes to the file
+	// until the consumer catches up
+	isSpilling := spool.consumed != spool.written
+	fits := spool.memSize+size <= spool.memLimit || len(spool.mem) == 0
+	if !isSpilling && fits && spool.memory.Reserve(size) == nil {
+		spool.mem = append(spool.mem, row)
+		spool.memSize += size
+	} else {
+		err := spool.spill(row)
+		if err != nil {
+			spool.err = err
+			spool.cond.Broadcast()
+			return err
+		}
+	}
+
+	spool.cond.Broadcast()
+	return nil
+}
+
+// Mark the end of rows stream, err is reported to consumer (if not nil)
+func (spool *Spool) CloseWrite(err error) {
+	spool.m.Lock()
+	defer spool.m.Unlock()
+
+	spool.closed = true
+	if spool.err == nil {
+		spool.err = err
+	}
+	spool.cond.Broadcast()
+}
+
+// Returns true if at least some rows were spilled to disk
+func (spool *Spool) Spilled() bool {
+	spool.m.Lock()
+	defer spool.m.Unlock()
+	return spool.file != nil
+}
+
+// Get up to max rows, blocks until at least one row is available
+// Returns io.EOF after all rows are consumed
+func (spool *Spool) Next(max int) ([]Row, error) {
+	spool.m.Lock()
+	defer spool.m.Unlock()
+
+	for len(spool.mem) == 0 && spool.consumed == spool.written && !spool.closed && spool.err == nil {
+		spool.cond.Wait()
+	}
+
+	if spool.err != nil {
+		return nil, spool.err
+	}
+
+	rows := make([]Row, 0, max)
+	for len(rows) < max && len(spool.mem) != 0 {
+		rows = append(rows, spool.mem[0])
+		spool.memSize -= rowMemSize(spool.mem[0])
+		spool.memory.Release(rowMemSize(spool.mem[0]))
+		spool.mem[0] = nil
+		spool.mem = spool.mem[1:]
+	}
+
+	for len(rows) < max && spool.consumed < spool.written {
+		line, err := spool.reader.ReadBytes('\n')
+		if err != nil {
+			spool.err = err
+			return nil, err
+		}
+
+		var row Row
+		err = json.Unmarshal(line, &row)
+		if err != nil {
+			spool.err = err
+			return nil, err
+		}
+
+		rows = append(rows, row)
+		spool.consumed++
+	}
+
+	if len(rows) == 0 && spool.closed {
+		return nil, io.EOF
+	}
+
+	return rows, nil
+}
+
+// Release resources used by the spool, rows pushed after Close() are rejected
+func (spool *Spool) Close() error {
+	spool.m.Lock()
+	defer spool.m.Unlock()
+
+	spool.mem = nil
+	spool.memory.Release(spool.memSize)
+	spool.memSize = 0
+	spool.err = ErrSpoolClosed
+	spool.cond.Broadcast()
+	if spool.file == nil {
+		return nil
+	}
+
+	name := spool.file.Name()
+	err := spool.file.Close()
+	if closeErr := spool.readFile.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := os.Remove(name); err == nil {
+		err = removeErr
+	}
+
+	spool.file = nil
+	spool.readFile = nil
+	spool.reader = nil
+	return err
+}
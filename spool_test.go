@@ -0,0 +1,47 @@
+package dumbdb
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestSpoolSpill(t *testing.T) {
+	const nRows = 1000
+
+	rows := make([]Row, 0, nRows)
+	for i := 0; i < nRows; i++ {
+		rows = append(rows, Row{{TypeID: TypeInt, Int: int64(i)}, {TypeID: TypeVarchar, Str: "value"}})
+	}
+
+	// tiny memory limit, so that almost everything goes to disk
+	spool := SpoolRows(StaticRows(rows), 256, t.TempDir())
+	defer spool.Close()
+
+	next := 0
+	for {
+		chunk, err := spool.Next(64)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, row := range chunk {
+			if row[0].Int != int64(next) || row[1].StrVal() != "value" {
+				t.Fatalf("Unexpected row at %v: %v", next, row)
+			}
+			next++
+		}
+	}
+
+	if next != nRows {
+		t.Fatalf("Expected %v rows, got %v", nRows, next)
+	}
+
+	if !spool.Spilled() {
+		t.Fatal("Expected rows to be spilled to disk")
+	}
+}
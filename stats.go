@@ -0,0 +1,209 @@
+package dumbdb
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+const StatisticsFilename string = "statistics.json"
+
+// Number of smallest hashes kept by the distinct values sketch
+const sketchSize = 256
+
+// K-minimum values sketch for estimating number of distinct values
+type distinctSketch struct {
+	hashes []uint64 // sorted, unique
+}
+
+func (s *distinctSketch) Add(val *Value) {
+	h := fnv.New64a()
+	switch val.TypeID {
+	case TypeVarchar:
+		h.Write([]byte(val.StrVal()))
+	case TypeFloat:
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(val.Float))
+		h.Write(buf[:])
+	default:
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], uint64(val.Int))
+		h.Write(buf[:])
+	}
+	hash := h.Sum64()
+
+	idx := sort.Search(len(s.hashes), func(i int) bool {
+		return s.hashes[i] >= hash
+	})
+
+	if idx < len(s.hashes) && s.hashes[idx] == hash {
+		return
+	}
+
+	if len(s.hashes) == sketchSize {
+		if idx == sketchSize {
+			return
+		}
+		s.hashes = s.hashes[:sketchSize-1]
+	}
+
+	s.hashes = append(s.hashes, 0)
+	copy(s.hashes[idx+1:], s.hashes[idx:])
+	s.hashes[idx] = hash
+}
+
+func (s *distinctSketch) Estimate() int {
+	if len(s.hashes) < sketchSize {
+		// exact
+		return len(s.hashes)
+	}
+
+	kth := float64(s.hashes[sketchSize-1]) / float64(math.MaxUint64)
+	return int(float64(sketchSize-1) / kth)
+}
+
+type ColumnStats struct {
+	Name     string `json:"name"`
+	Min      *Value `json:"min,omitempty"`
+	Max      *Value `json:"max,omitempty"`
+	Distinct int    `json:"distinct"`
+}
+
+type TableStats struct {
+	Rows    int           `json:"rows"`
+	Pages   int           `json:"pages"`
+	Columns []ColumnStats `json:"columns"`
+}
+
+// Collect statistics by scanning the whole table
+func (table *Table) Analyze() (*TableStats, error) {
+	stats := &TableStats{
+		Columns: make([]ColumnStats, len(table.schema.Fields)),
+	}
+	sketches := make([]distinctSketch, len(table.schema.Fields))
+	for i, field := range table.schema.Fields {
+		stats.Columns[i].Name = field.Name
+	}
+
+	for id := table.firstPage(); id != InvalidPageID; id = table.nextPage(id) {
+		stats.Pages++
+		err := table.ScanPage(id, nil, func(row Row) error {
+			stats.Rows++
+			for i := range row {
+				val := row[i]
+				if val.TypeID == TypeVarchar {
+					// don't persist padding
+					val.Str = val.StrVal()
+				}
+
+				column := &stats.Columns[i]
+				if column.Min == nil || compareValues(&val, column.Min) < 0 {
+					column.Min = &val
+				}
+				if column.Max == nil || compareValues(&val, column.Max) > 0 {
+					column.Max = &val
+				}
+				sketches[i].Add(&val)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range sketches {
+		stats.Columns[i].Distinct = sketches[i].Estimate()
+	}
+
+	return stats, nil
+}
+
+// Default selectivity for predicates we know nothing about
+const defaultSelectivity = 1.0 / 3.0
+
+// Estimate fraction of rows matching the predicate
+func (stats *TableStats) Selectivity(p *ColumnPredicate) float64 {
+	var column *ColumnStats
+	for i := range stats.Columns {
+		if stats.Columns[i].Name == p.Field.Name {
+			column = &stats.Columns[i]
+			break
+		}
+	}
+
+	if column == nil || column.Min == nil || column.Max == nil {
+		return defaultSelectivity
+	}
+
+	eq := 1.0
+	if column.Distinct > 0 {
+		eq = 1.0 / float64(column.Distinct)
+	}
+
+	switch p.Op {
+	case OpEq:
+		if compareValues(&p.Value, column.Min) < 0 || compareValues(&p.Value, column.Max) > 0 {
+			return 0
+		}
+		return eq
+	case OpNotEq:
+		return 1 - eq
+	case OpIn:
+		return math.Min(1, eq*float64(len(p.Values)))
+	}
+
+	if !p.Field.TypeID.IsNumeric() || column.Max.ToFloat() == column.Min.ToFloat() {
+		return defaultSelectivity
+	}
+
+	// assume uniform distribution between min and max
+	span := column.Max.ToFloat() - column.Min.ToFloat()
+	below := (p.Value.ToFloat() - column.Min.ToFloat()) / span
+	below = math.Max(0, math.Min(1, below))
+	switch p.Op {
+	case OpLess, OpLessOrEq:
+		return below
+	case OpGreater, OpGreaterOrEq:
+		return 1 - below
+	}
+
+	return defaultSelectivity
+}
+
+// Order predicates so that the most selective ones are checked first
+// returns estimated number of rows matching all of them out of nRows
+// (current number of rows, which can differ from stats.Rows collected by analyze)
+func (stats *TableStats) OrderPredicates(predicates []ColumnPredicate, nRows int64) float64 {
+	selectivity := make([]float64, len(predicates))
+	for i := range predicates {
+		selectivity[i] = stats.Selectivity(&predicates[i])
+	}
+
+	sort.Sort(bySelectivity{predicates, selectivity})
+
+	estimate := float64(nRows)
+	for _, s := range selectivity {
+		estimate *= s
+	}
+	return estimate
+}
+
+type bySelectivity struct {
+	predicates  []ColumnPredicate
+	selectivity []float64
+}
+
+func (s bySelectivity) Len() int {
+	return len(s.predicates)
+}
+
+func (s bySelectivity) Less(i, j int) bool {
+	return s.selectivity[i] < s.selectivity[j]
+}
+
+func (s bySelectivity) Swap(i, j int) {
+	s.predicates[i], s.predicates[j] = s.predicates[j], s.predicates[i]
+	s.selectivity[i], s.selectivity[j] = s.selectivity[j], s.selectivity[i]
+}
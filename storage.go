@@ -0,0 +1,27 @@
+package dumbdb
+
+import (
+	"errors"
+	"os"
+)
+
+// File storage of table pages, see TableOptions
+const (
+	// regular file, read and written through the OS page cache
+	StorageFile = "file"
+	// memory-mapped file
+	StorageMmap = "mmap"
+	// file opened with O_DIRECT, bypassing the OS page cache
+	StorageDirect = "direct"
+)
+
+var (
+	ErrUnknownStorage       = errors.New("unknown storage, expected file, mmap or direct")
+	ErrMmapNotSupported     = errors.New("mmap storage is not supported on this platform")
+	ErrDirectIONotSupported = errors.New("direct IO storage is not supported on this platform")
+)
+
+// Whether file opened with flags (as for os.OpenFile()) can't be written
+func isReadOnly(flags int) bool {
+	return flags&(os.O_WRONLY|os.O_RDWR) == 0
+}
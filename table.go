@@ -1,8 +1,16 @@
 package dumbdb
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type RowListPage struct {
@@ -28,14 +36,37 @@ func (p *RowListPage) NumRows() int {
 	return int(p.nRows)
 }
 
-func (p *RowListPage) ReadRow(idx int, schema *Schema) Row {
+// Returns ErrCorruptedPage if the page doesn't hold rows or claims more rows than fit into it
+func (p *RowListPage) Check(schema *Schema) error {
+	err := p.page.checkType(PageTypeRows)
+	if err != nil {
+		return err
+	}
+
+	if 2+schema.RowSize()*p.NumRows() > len(p.page.Data()) {
+		return fmt.Errorf("%w: %v rows of %v bytes don't fit into the page", ErrCorruptedPage, p.nRows, schema.RowSize())
+	}
+	return nil
+}
+
+// Returns encoded row at idx, or nil if idx is out of bounds
+func (p *RowListPage) RowData(idx int, schema *Schema) []byte {
 	offset := 2 + schema.RowSize()*idx
 	if offset+schema.RowSize() > len(p.page.Data()) {
 		return nil
 	}
 
+	return p.page.Data()[offset : offset+schema.RowSize()]
+}
+
+func (p *RowListPage) ReadRow(idx int, schema *Schema) Row {
+	data := p.RowData(idx, schema)
+	if data == nil {
+		return nil
+	}
+
 	row := make(Row, 0, len(schema.Fields))
-	err := schema.ReadRow(p.page.Data()[offset:], &row)
+	err := schema.ReadRow(data, &row)
 	if err != nil {
 		return nil
 	}
@@ -43,9 +74,13 @@ func (p *RowListPage) ReadRow(idx int, schema *Schema) Row {
 	return row
 }
 
-// Returns true on success
+// Returns true on success, fails if the page has no room or already has MaxRowsPerPage rows
 // NOTE: inserts are not applied until Commit() is called
 func (p *RowListPage) TryInsert(row Row, schema *Schema) bool {
+	if p.nRows >= MaxRowsPerPage {
+		return false
+	}
+
 	offset := 2 + schema.RowSize()*int(p.nRows)
 	if offset+schema.RowSize() > len(p.page.Data()) {
 		return false
@@ -60,10 +95,20 @@ func (p *RowListPage) TryInsert(row Row, schema *Schema) bool {
 	return true
 }
 
+// Remove the last n rows
+// NOTE: removals are not applied until Commit() is called
+func (p *RowListPage) RemoveLast(n int) {
+	if n > int(p.nRows) {
+		n = int(p.nRows)
+	}
+	p.nRows -= uint16(n)
+}
+
 // Commit inserts into memory
 func (p *RowListPage) Commit() {
 	if p.nRows != p.initialRows {
 		binary.LittleEndian.PutUint16(p.page.Data(), p.nRows)
+		p.page.SetType(PageTypeRows)
 		p.page.MarkDirty()
 	}
 }
@@ -77,135 +122,855 @@ func (p *RowListPage) Rollback() {
 	}
 }
 
+// Write the page if it was changed
+func (p *RowListPage) Sync(pager *Pager) error {
+	return pager.SyncPage(p.page.id, p.page)
+}
+
+// Rows of a page of a row table (RowListPage), or of a row group of
+// a columnar table (ColumnGroup), see Table.lockRows()
+type rowSet interface {
+	NumRows() int
+	Check(schema *Schema) error
+	RowData(idx int, schema *Schema) []byte
+	ReadRow(idx int, schema *Schema) Row
+	TryInsert(row Row, schema *Schema) bool
+	RemoveLast(n int)
+	Commit()
+	Rollback()
+	Sync(pager *Pager) error
+}
+
+var (
+	ErrRowNotInserted = errors.New("failed to insert the row")
+	ErrNoSuchRow      = errors.New("no row with such id")
+	ErrRowTooLarge    = errors.New("row doesn't fit into a page")
+)
+
+// Error of Table.Insert() which failed after some of the rows were inserted,
+// these rows stay in the table
+type InsertError struct {
+	Inserted int
+	Err      error
+}
+
+func (e *InsertError) Error() string {
+	return fmt.Sprintf("%v (%v rows were inserted)", e.Err, e.Inserted)
+}
+
+func (e *InsertError) Unwrap() error {
+	return e.Err
+}
+
+// Returns InsertError if n rows were inserted before the error
+func insertError(n int, err error) error {
+	if n == 0 {
+		return err
+	}
+	return &InsertError{Inserted: n, Err: err}
+}
+
+// Result of inserting a row by Table.InsertBatch()
+type RowStatus struct {
+	Inserted bool
+	// reason the row isn't inserted. If the row is inserted, the error which
+	// followed, e.g. its index entries failed to be added
+	Err error
+}
+
+// Number of the inserted rows
+func CountInserted(statuses []RowStatus) int {
+	n := 0
+	for _, status := range statuses {
+		if status.Inserted {
+			n++
+		}
+	}
+	return n
+}
+
+// Attempts of Table.Insert() to find room for the rows before it gives up, pages
+// found free can be filled by concurrent inserts
+const maxInsertAttempts = 16
+
+// Location of a row in the table: id of the page (24 bits) and index of the row
+// Ids are stable until the rows are moved by vacuum, which rewrites the table, or removed
+// Ids are stable until the rows are moved by Vacuum() or removed
+type RowID uint32
+
+func NewRowID(page PageID, idx uint8) RowID {
+	return RowID(uint32(page)<<8 | uint32(idx))
+}
+
+// Rows inserted into a page, so that all of them can be referenced by RowID.
+// Pages written before the limit was introduced may hold more rows
+const MaxRowsPerPage = 256
+
+func (id RowID) PageID() PageID {
+	val := uint32(id)
+	return PageID(val >> 8)
+}
+
+func (id RowID) RowIndex() uint8 {
+	val := uint32(id)
+	return uint8(val & 0xff)
+}
+
+// Storage options of a table, persisted in catalog metadata
+type TableOptions struct {
+	// Compression of the pages, CompressionNone or CompressionFlate
+	Compression string `json:"compression,omitempty"`
+
+	// Engine of the table, EngineDisk, EngineMemory or EngineColumnar
+	Engine string `json:"engine,omitempty"`
+
+	// File storage, StorageFile, StorageMmap or StorageDirect. It's an option of the whole
+	// database rather than of the table, so it's not persisted.
+	// Ignored for compressed and memory tables, encrypted ones can't use StorageDirect
+	Storage string `json:"-"`
+
+	// Encryption of the pages of the table and its indexes, EncryptionNone or EncryptionAESGCM
+	Encryption string `json:"encryption,omitempty"`
+	// Key of the encrypted table, it's the key of the whole database, so it's not persisted
+	EncryptionKey []byte `json:"-"`
+
+	// Columns with bloom filters of each page, see BloomFilterMap
+	BloomFilter []string `json:"bloom_filter,omitempty"`
+	// Varchar columns stored as ids of their values, see Dictionary
+	Dictionary []string `json:"dictionary,omitempty"`
+
+	// Rows are deleted once the value of TTLColumn (unix time in seconds) is
+	// older than TTL, a duration such as 24h, see Catalog.ExpireRows
+	TTL       string `json:"ttl,omitempty"`
+	TTLColumn string `json:"ttl_column,omitempty"`
+
+	// Table file is opened read-only and modifications fail with ErrReadOnly,
+	// set for all the tables of a read-only database, so it's not persisted
+	ReadOnly bool `json:"-"`
+}
+
+func (opts *TableOptions) Validate() error {
+	switch opts.Compression {
+	case CompressionNone, CompressionFlate:
+	default:
+		return ErrUnknownCompression
+	}
+
+	switch opts.Engine {
+	case EngineDisk, EngineColumnar:
+	case EngineMemory:
+		if opts.Compression != CompressionNone {
+			return ErrMemoryEngineCompression
+		}
+	default:
+		return ErrUnknownEngine
+	}
+
+	switch {
+	case opts.Encryption == EncryptionNone:
+	case opts.Encryption != EncryptionAESGCM:
+		return ErrUnknownEncryption
+	case opts.Engine == EngineMemory:
+		return ErrMemoryEngineEncryption
+	case opts.Compression != CompressionNone:
+		return ErrEncryptedCompression
+	case len(opts.Dictionary) != 0:
+		return ErrEncryptedDictionary
+	case opts.EncryptionKey == nil:
+		return ErrNoEncryptionKey
+	case len(opts.EncryptionKey) != EncryptionKeySize:
+		return ErrInvalidEncryptionKey
+	}
+
+	return validateStorage(opts.Storage)
+}
+
+// Pager of the table or index file, pages kept in memory aren't encrypted
+func (opts *TableOptions) newPager(maxPages int, storage Storage) (*Pager, error) {
+	if _, ok := storage.(*MemoryStorage); ok || opts.Encryption == EncryptionNone {
+		return NewPager(maxPages, storage)
+	}
+	return NewEncryptedPager(maxPages, storage, opts.EncryptionKey)
+}
+
+func validateStorage(storage string) error {
+	switch storage {
+	case "", StorageFile, StorageMmap, StorageDirect:
+		return nil
+	default:
+		return ErrUnknownStorage
+	}
+}
+
+// Storage of the table file
+type TableStorage interface {
+	Storage
+	io.Closer
+
+	Name() string
+}
+
 type Table struct {
-	schema Schema
-	file   *os.File
-	pager  *Pager
+	// number of rows, accessed atomically (first field for 64-bit alignment),
+	// persisted in catalog metadata on clean shutdown
+	rowCount int64
+
+	schema    Schema
+	layout    Schema // of the rows stored on pages, see Dictionary.layout()
+	options   TableOptions
+	storage   TableStorage
+	pager     *Pager
+	freeSpace FreeSpaceMap
+	bloom     *BloomFilterMap // nil if there are no bloom filters
+	zones     *ZoneMap        // nil if there are no integer columns
+	dict      *Dictionary     // nil if there are no dictionary columns
+
+	// serializes allocations of row groups of a columnar table
+	allocateM sync.Mutex
+
+	// last value of the auto-increment column, persisted in catalog metadata
+	autoIncrementM sync.Mutex
+	autoIncrement  int64
+
+	// scans running in the background, see beginScan()
+	scansM   sync.Mutex
+	scans    map[int]*tableScan
+	nextScan int
+	scansWG  sync.WaitGroup
+	dropped  bool
+
+	// secondary indexes, modified only while the catalog is locked exclusively
+	indexes []*Index
+
+	// rows expire once the column is older than ttl, -1 if they don't
+	ttl       time.Duration
+	ttlColumn int
+
+	// rows inserted while the table is being altered, nil otherwise.
+	// Set only while the catalog is locked exclusively
+	capture *rowCapture
 }
 
 // Create a new table
-func NewTable(path string, schema Schema) (*Table, error) {
-	return initTable(path, schema, true)
+func NewTable(path string, schema Schema, opts TableOptions) (*Table, error) {
+	return initTable(path, schema, opts, true)
 }
 
 // Open existing table
-func OpenTable(path string, schema Schema) (*Table, error) {
-	return initTable(path, schema, false)
+func OpenTable(path string, schema Schema, opts TableOptions) (*Table, error) {
+	return initTable(path, schema, opts, false)
 }
 
-func initTable(path string, schema Schema, isNew bool) (*Table, error) {
-	// TODO: consider O_DIRECT, see https://github.com/ncw/directio
+func initTable(path string, schema Schema, opts TableOptions, isNew bool) (*Table, error) {
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, ttlColumn, err := opts.expiration(&schema)
+	if err != nil {
+		return nil, err
+	}
+
+	dict, err := openDictionary(path, &schema, opts.Dictionary, opts, isNew)
+	if err != nil {
+		return nil, err
+	}
+
+	layout := dict.layout(&schema)
+	// existing tables are opened even if their rows don't fit, they have no rows
+	// and inserts into them fail
+	if isNew {
+		err = checkRowSize(&layout, opts.Engine)
+		if err != nil {
+			dict.Close()
+			return nil, err
+		}
+	}
+
+	bloom, err := NewBloomFilterMap(&layout, opts.BloomFilter, rowsPerPage(&layout, opts.Engine))
+	if err != nil {
+		dict.Close()
+		return nil, err
+	}
+
 	// TODO: check whether WriteAt() is atomic if writes are aligned to page size
 	flags := os.O_RDWR | os.O_CREATE | os.O_SYNC
 	if isNew {
 		flags |= os.O_EXCL
 	}
+	if opts.ReadOnly {
+		flags = os.O_RDONLY
+	}
 
-	file, err := os.OpenFile(path+".bin", flags, 0600)
+	storage, err := openTableStorage(path+".bin", &opts, flags)
 	if err != nil {
+		dict.Close()
 		return nil, err
 	}
 
-	pager, err := NewPager(4096, file)
+	pager, err := opts.newPager(4096, storage)
 	if err != nil {
+		storage.Close()
+		dict.Close()
 		return nil, err
 	}
 
 	return &Table{
-		schema: schema,
-		file:   file,
-		pager:  pager,
+		schema:  schema,
+		layout:  layout,
+		options: opts,
+		storage: storage,
+		pager:   pager,
+		bloom:   bloom,
+		zones:   NewZoneMap(&layout),
+		dict:    dict,
+
+		ttl:       ttl,
+		ttlColumn: ttlColumn,
 	}, nil
 }
 
-// Returns number of pages successfully inserted
-func (table *Table) insertInto(id PageID, rows []Row) (int, error) {
+// Open the table file with the storage of the options, flags are the same as for os.OpenFile()
+func openTableStorage(filename string, opts *TableOptions, flags int) (TableStorage, error) {
+	switch {
+	case opts.Engine == EngineMemory:
+		// there is nothing to open, contents of the table are gone
+		return NewMemoryStorage(), nil
+	case opts.Compression == CompressionFlate:
+		return OpenCompressedStorage(filename, flags)
+	case opts.Storage == StorageMmap:
+		return OpenMmapStorage(filename, flags)
+	case opts.Storage == StorageDirect && opts.Encryption == EncryptionNone:
+		// encrypted pages aren't aligned to the page size
+		return OpenDirectStorage(filename, flags)
+	default:
+		return os.OpenFile(filename, flags, 0600)
+	}
+}
+
+// Number of rows which fit into a page, or into a row group of a columnar table
+func rowsPerPage(schema *Schema, engine string) int {
+	if engine == EngineColumnar {
+		return groupRows(schema)
+	}
+
+	n := (int(PageDataSize) - 2) / schema.RowSize()
+	if n > MaxRowsPerPage {
+		return MaxRowsPerPage
+	}
+	return n
+}
+
+// Max size of a row stored on a page, see rowsPerPage(). Values of dictionary
+// columns are stored as ids. Row groups of columnar tables fit any row
+const MaxRowSize = int(PageDataSize) - 2
+
+// Returns ErrRowTooLarge if rows stored with the layout don't fit into a page
+func checkRowSize(layout *Schema, engine string) error {
+	if rowsPerPage(layout, engine) == 0 {
+		return fmt.Errorf("%w: row takes %v bytes, %v is max", ErrRowTooLarge, layout.RowSize(), MaxRowSize)
+	}
+	return nil
+}
+
+func (table *Table) rowsPerPage() int {
+	return rowsPerPage(&table.layout, table.options.Engine)
+}
+
+// Number of pages taken by a row group, 1 unless the table is columnar
+func (table *Table) pageStride() int {
+	if table.options.Engine == EngineColumnar {
+		return len(table.schema.Fields)
+	}
+	return 1
+}
+
+// Get ID of the first page, or of the first row group of a columnar table.
+// Returns InvalidPageID if the table is empty
+func (table *Table) firstPage() PageID {
+	if !table.pager.IsAllocated(PageID(table.pageStride() - 1)) {
+		return InvalidPageID
+	}
+	return 0
+}
+
+// Row groups are complete once their last page is allocated, see allocatePage()
+func (table *Table) nextPage(id PageID) PageID {
+	stride := PageID(table.pageStride())
+	next := id + stride
+	if !table.pager.IsAllocated(next + stride - 1) {
+		return InvalidPageID
+	}
+	return next
+}
+
+// Allocate a new page, or all the pages of a new row group of a columnar table
+func (table *Table) allocatePage() (PageID, error) {
+	stride := PageID(table.pageStride())
+	if stride == 1 {
+		return table.pager.AllocatePage()
+	}
+
+	table.allocateM.Lock()
+	defer table.allocateM.Unlock()
+
+	// the pages are allocated one after another, pages of the group left
+	// incomplete by a crash are allocated first
+	for {
+		id, err := table.pager.AllocatePage()
+		if err != nil {
+			return InvalidPageID, err
+		}
+
+		if (id+1)%stride == 0 {
+			return id + 1 - stride, nil
+		}
+	}
+}
+
+// Pin and lock the page, or the pages of the row group of a columnar table.
+// columns are the ones read from the row group, nil for all of them (as for
+// modifications). release() unlocks and unpins the pages
+func (table *Table) lockRows(id PageID, write bool, columns []bool) (rows rowSet, release func(), err error) {
+	if table.options.Engine == EngineColumnar {
+		return openColumnGroup(table.pager, id, &table.layout, columns, write)
+	}
+
 	page, err := table.pager.FetchPage(id)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
+	}
+
+	if write {
+		page.Lock()
+	} else {
+		page.RLock()
 	}
-	defer page.Unpin()
 
-	i := 0
-	page.Lock()
 	lockedPage := NewRowListPage(page)
-	defer page.Unlock()
-	for i < len(rows) && lockedPage.TryInsert(rows[i], &table.schema) {
+	release = func() {
+		if write {
+			page.Unlock()
+		} else {
+			page.RUnlock()
+		}
+		page.Unpin()
+	}
+	return &lockedPage, release, nil
+}
+
+// Returns number of rows successfully inserted, and index of the first one in the page
+func (table *Table) insertInto(id PageID, rows []Row) (int, int, error) {
+	lockedPage, release, err := table.lockRows(id, true, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer release()
+
+	err = lockedPage.Check(&table.layout)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%v: %w", id, err)
+	}
+
+	i := 0
+	first := lockedPage.NumRows()
+	for i < len(rows) && lockedPage.TryInsert(rows[i], &table.layout) {
 		i++
 	}
 
 	if i != 0 {
 		lockedPage.Commit()
 		// TODO: remove this sync() after implementing WAL
-		err := table.pager.SyncPage(id, page)
+		err := lockedPage.Sync(table.pager)
 		if err != nil {
 			lockedPage.Rollback()
-			return 0, err
+			return 0, 0, err
 		}
+		table.bloom.inserted(id, lockedPage, first, &table.layout)
+		table.zones.inserted(id, lockedPage, first, &table.layout)
 	}
 
-	return i, nil
+	table.freeSpace.Update(id, table.rowsPerPage()-lockedPage.NumRows())
+	return i, first, nil
 }
 
 // TODO: make it atomic globally, not only inside a single page
 func (table *Table) Insert(rows []Row) error {
+	if table.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	err := checkRowSize(&table.layout, table.options.Engine)
+	if err != nil {
+		return err
+	}
+
+	err = table.schema.TypecheckRows(rows)
+	if err != nil {
+		return err
+	}
+
+	keys, err := table.indexKeys(rows)
+	if err != nil {
+		return err
+	}
+
+	// values of the dictionary columns are added to the dictionary before the rows are written
+	stored, err := table.dict.encode(rows)
+	if err != nil {
+		return err
+	}
+
+	// rows which can't be written would be retried on every page
+	buf := make([]byte, table.layout.RowSize())
+	for i, row := range stored {
+		err = table.layout.WriteRow(buf, row)
+		if err != nil {
+			return fmt.Errorf("%w: row %v: %v", ErrRowNotInserted, i+1, err)
+		}
+	}
+
+	n, _, err := table.insertRows(rows, stored, keys)
+	if err != nil {
+		return insertError(n, err)
+	}
+	return nil
+}
+
+// Write the rows, which are checked already, stored are the rows encoded with
+// the dictionary and keys are their index keys. Returns number of the rows
+// inserted before an error and how many of them are indexed, the rest of the
+// inserted rows may miss their index entries
+func (table *Table) insertRows(rows []Row, stored []Row, keys [][]BTreeKey) (int, int, error) {
+	err := table.freeSpace.load(table)
+	if err != nil {
+		return 0, 0, err
+	}
+
 	i := 0
-	// first try inserting into existing pages
-	for id := table.pager.FirstPage(); id != InvalidPageID; id = table.pager.NextPage(id) {
-		n, err := table.insertInto(id, rows[i:])
+	attempts := 0
+	for i < len(rows) {
+		// first try inserting into existing pages,
+		// if there is no space on existing pages allocate a new one
+		id := table.freeSpace.FindFree()
+		if id == InvalidPageID {
+			id, err = table.allocatePage()
+			if err != nil {
+				return i, i, err
+			}
+		}
+
+		n, first, err := table.insertInto(id, stored[i:])
 		if err != nil {
-			return err
+			return i, i, err
+		}
+
+		if n == 0 {
+			// page has room, but the row wasn't written
+			if table.freeSpace.Free(id) != 0 {
+				return i, i, ErrRowNotInserted
+			}
+
+			attempts++
+			if attempts == maxInsertAttempts {
+				return i, i, fmt.Errorf("%w: no room found in %v attempts", ErrRowNotInserted, attempts)
+			}
+			continue
 		}
+		attempts = 0
 
+		atomic.AddInt64(&table.rowCount, int64(n))
+		if table.capture != nil {
+			table.capture.add(rows[i : i+n])
+		}
+
+		if keys != nil {
+			err = table.indexRows(id, first, keys[i:i+n])
+			if err != nil {
+				// the rows are written already
+				return i + n, i, err
+			}
+		}
 		i += n
-		if i == len(rows) {
-			return nil
+	}
+	return i, i, nil
+}
+
+// Insert the rows one page at a time, unlike Insert() rows which fail don't stop
+// the others. Each row is checked on its own, so invalid rows are skipped, and a
+// page-sized batch which fails to be written doesn't fail the next batches.
+// Returns status of each row, rows which are written, but miss their index
+// entries, are inserted with the error
+func (table *Table) InsertBatch(rows []Row) []RowStatus {
+	statuses := make([]RowStatus, len(rows))
+	failAll := func(err error) []RowStatus {
+		for i := range statuses {
+			statuses[i].Err = err
 		}
+		return statuses
 	}
 
-	// no space on existing pages, allocate new pages
-	for {
-		id, err := table.pager.AllocatePage()
+	if table.options.ReadOnly {
+		return failAll(ErrReadOnly)
+	}
+
+	err := checkRowSize(&table.layout, table.options.Engine)
+	if err != nil {
+		return failAll(err)
+	}
+
+	// positions of the valid rows in rows
+	positions := make([]int, 0, len(rows))
+	valid := make([]Row, 0, len(rows))
+	var keys [][]BTreeKey
+	for i, row := range rows {
+		err := table.schema.Typecheck(row)
+		var rowKeys []BTreeKey
+		if err == nil {
+			rowKeys, err = table.rowKeys(row)
+		}
 		if err != nil {
-			return err
+			statuses[i].Err = err
+			continue
 		}
 
-		n, err := table.insertInto(id, rows[i:])
+		positions = append(positions, i)
+		valid = append(valid, row)
+		if rowKeys != nil {
+			keys = append(keys, rowKeys)
+		}
+	}
+
+	stored, err := table.dict.encode(valid)
+	if err != nil {
+		for _, pos := range positions {
+			statuses[pos].Err = err
+		}
+		return statuses
+	}
+
+	// rows which can't be written would be retried on every page
+	buf := make([]byte, table.layout.RowSize())
+	n := 0
+	for i, row := range stored {
+		err = table.layout.WriteRow(buf, row)
 		if err != nil {
-			return err
+			statuses[positions[i]].Err = fmt.Errorf("%w: %v", ErrRowNotInserted, err)
+			continue
 		}
 
-		i += n
-		if i == len(rows) {
-			return nil
+		positions[n], valid[n], stored[n] = positions[i], valid[i], row
+		if keys != nil {
+			keys[n] = keys[i]
 		}
+		n++
 	}
+
+	batch := table.rowsPerPage()
+	for start := 0; start < n; start += batch {
+		end := start + batch
+		if end > n {
+			end = n
+		}
+
+		var batchKeys [][]BTreeKey
+		if keys != nil {
+			batchKeys = keys[start:end]
+		}
+		inserted, indexed, err := table.insertRows(valid[start:end], stored[start:end], batchKeys)
+		for i, pos := range positions[start:end] {
+			statuses[pos].Inserted = i < inserted
+			if i >= indexed {
+				statuses[pos].Err = err
+			}
+		}
+	}
+	return statuses
 }
 
-func (table *Table) ScanPage(id PageID, onRow func(Row) error) error {
-	page, err := table.pager.FetchPage(id)
+// Call onRow for each row on the page matching all the predicates
+// NOTE: predicates are checked before decoding the row
+func (table *Table) ScanPage(id PageID, predicates []ColumnPredicate, onRow func(Row) error) error {
+	return table.scanPage(id, table.storedPredicates(predicates), nil, onRow)
+}
+
+// Predicates checked against the rows stored on pages, see Dictionary.storedPredicates()
+func (table *Table) storedPredicates(predicates []ColumnPredicate) []ColumnPredicate {
+	return table.dict.storedPredicates(predicates, &table.schema, &table.layout)
+}
+
+// Same as ScanPage(), but the predicates have to be stored ones. columns are the
+// ones read, nil for all of them. Columnar tables read only these columns (which
+// have to include the columns of the predicates), other values of the rows are zero
+func (table *Table) scanPage(id PageID, predicates []ColumnPredicate, columns []bool, onRow func(Row) error) error {
+	// onRow can block, e.g. on rows of a cursor nobody fetches, so it's called
+	// once the page is released
+	rows, err := table.readPage(id, predicates, columns)
 	if err != nil {
 		return err
 	}
-	defer page.Unpin()
 
-	page.RLock()
-	lockedPage := NewRowListPage(page)
-	defer page.RUnlock()
-	for i := 0; i < lockedPage.NumRows(); i++ {
-		row := lockedPage.ReadRow(i, &table.schema)
-		err := onRow(row)
+	for _, row := range rows {
+		err = onRow(row)
 		if err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
+// Rows on the page matching all the predicates, see scanPage()
+func (table *Table) readPage(id PageID, predicates []ColumnPredicate, columns []bool) ([]Row, error) {
+	lockedPage, release, err := table.lockRows(id, false, columns)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	err = lockedPage.Check(&table.layout)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", id, err)
+	}
+
+	// filters are built from whole rows
+	if columns == nil || table.options.Engine != EngineColumnar {
+		table.bloom.scanned(id, lockedPage, &table.layout)
+		table.zones.scanned(id, lockedPage, &table.layout)
+	}
+
+	var rows []Row
+	for i := 0; i < lockedPage.NumRows(); i++ {
+		if len(predicates) != 0 {
+			data := lockedPage.RowData(i, &table.layout)
+			if data == nil || !MatchAll(predicates, data) {
+				continue
+			}
+		}
+
+		row, err := table.dict.decode(lockedPage.ReadRow(i, &table.layout), columns)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", id, err)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// Returns the row with the given id, or ErrNoSuchRow if there is no such row
+func (table *Table) FetchRow(id RowID) (Row, error) {
+	// rows of columnar tables are referenced by the first pages of their groups
+	if int(id.PageID())%table.pageStride() != 0 {
+		return nil, ErrNoSuchRow
+	}
+
+	lockedPage, release, err := table.lockRows(id.PageID(), false, nil)
+	if errors.Is(err, ErrPageNotAllocated) {
+		return nil, ErrNoSuchRow
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	err = lockedPage.Check(&table.layout)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", id.PageID(), err)
+	}
+
+	if int(id.RowIndex()) >= lockedPage.NumRows() {
+		return nil, ErrNoSuchRow
+	}
+	row, err := table.dict.decode(lockedPage.ReadRow(int(id.RowIndex()), &table.layout), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", id.PageID(), err)
+	}
+	return row, nil
+}
+
+// Scan registered by beginScan()
+type tableScan struct {
+	cancel context.CancelFunc
+	// error the scan fails with once it's stopped by stopScans()
+	err error
+}
+
+// Register a scan which outlives the catalog lock, e.g. the one producing rows of
+// a select. Returned context is cancelled once the scan is stopped by stopScans(),
+// end() has to be called with the error of the scan once it's finished, it returns
+// the error the scan should fail with
+func (table *Table) beginScan(ctx context.Context) (scanCtx context.Context, end func(error) error, err error) {
+	table.scansM.Lock()
+	defer table.scansM.Unlock()
+
+	if table.dropped {
+		return nil, nil, ErrTableDropped
+	}
+
+	if table.scans == nil {
+		table.scans = make(map[int]*tableScan)
+	}
+
+	id := table.nextScan
+	table.nextScan++
+
+	scanCtx, cancel := context.WithCancel(ctx)
+	scan := &tableScan{cancel: cancel}
+	table.scans[id] = scan
+	table.scansWG.Add(1)
+
+	end = func(err error) error {
+		table.scansM.Lock()
+		delete(table.scans, id)
+		if err != nil && scan.err != nil {
+			err = scan.err
+		}
+		table.scansM.Unlock()
+
+		cancel()
+		table.scansWG.Done()
+		return err
+	}
+	return scanCtx, end, nil
+}
+
+// Cancel the active scans and wait for them to finish, they fail with err.
+// Called before pages of the table are rewritten, and with ErrTableDropped
+// before the dropped table is closed, in which case new scans fail as well
+// catalog.m should be locked
+func (table *Table) stopScans(err error) {
+	table.scansM.Lock()
+	if err == ErrTableDropped {
+		table.dropped = true
+	}
+	for _, scan := range table.scans {
+		scan.err = err
+		scan.cancel()
+	}
+	table.scansM.Unlock()
+
+	table.scansWG.Wait()
+}
+
 func (table *Table) Scan(onRow func(Row) error) error {
-	for id := table.pager.FirstPage(); id != InvalidPageID; id = table.pager.NextPage(id) {
-		err := table.ScanPage(id, onRow)
+	return table.ScanWhere(nil, onRow)
+}
+
+// Number of pages allocated to the table, or of row groups of a columnar table
+func (table *Table) pageCount() int64 {
+	n := int64(0)
+	for id := table.firstPage(); id != InvalidPageID; id = table.nextPage(id) {
+		n++
+	}
+	return n
+}
+
+// Same as Scan(), but skips rows not matching the predicates
+func (table *Table) ScanWhere(predicates []ColumnPredicate, onRow func(Row) error) error {
+	stored := table.storedPredicates(predicates)
+	for id := table.firstPage(); id != InvalidPageID; id = table.nextPage(id) {
+		err := table.scanPage(id, stored, nil, onRow)
 		if err != nil {
 			return err
 		}
@@ -213,10 +978,114 @@ func (table *Table) Scan(onRow func(Row) error) error {
 	return nil
 }
 
+// Fill in the auto-increment column of the rows (which have to be typechecked),
+// if |generate| is false values are given explicitly, and the counter is only
+// advanced past them. Returns the last generated value, 0 if none were generated
+func (table *Table) fillAutoIncrement(rows []Row, generate bool) (int64, error) {
+	idx := table.schema.AutoIncrementField()
+	if idx == -1 {
+		return 0, nil
+	}
+
+	table.autoIncrementM.Lock()
+	defer table.autoIncrementM.Unlock()
+
+	max := int64(math.MaxInt64)
+	if table.schema.Fields[idx].TypeID == TypeInt {
+		max = math.MaxInt32
+	}
+
+	next := table.autoIncrement
+	for _, row := range rows {
+		if !generate {
+			if row[idx].Int > next {
+				next = row[idx].Int
+			}
+			continue
+		}
+
+		if next == max {
+			return 0, fmt.Errorf("%w: auto_increment column is out of values", ErrConstraintViolation)
+		}
+
+		next++
+		row[idx] = Value{TypeID: table.schema.Fields[idx].TypeID, Int: next}
+	}
+
+	table.autoIncrement = next
+	if !generate {
+		return 0, nil
+	}
+	return next, nil
+}
+
+func (table *Table) lastAutoIncrement() int64 {
+	table.autoIncrementM.Lock()
+	defer table.autoIncrementM.Unlock()
+	return table.autoIncrement
+}
+
+// Remove all the rows, schema and indexes of the table are kept
+func (table *Table) Truncate() error {
+	if table.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	table.freeSpace.Truncate(0)
+	table.bloom.Truncate(0)
+	table.zones.Truncate(0)
+	err := table.pager.Truncate()
+	if err != nil {
+		return err
+	}
+
+	// ids of the values aren't referenced anymore
+	err = table.dict.Truncate()
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt64(&table.rowCount, 0)
+	return table.rebuildIndexes()
+}
+
+// Number of rows in the table, maintained without scanning it
+func (table *Table) RowCount() int64 {
+	return atomic.LoadInt64(&table.rowCount)
+}
+
+// Count rows using the row counts of the pages, when the counter wasn't persisted
+func (table *Table) countRows() (int64, error) {
+	n := int64(0)
+	for id := table.firstPage(); id != InvalidPageID; id = table.nextPage(id) {
+		// the first column of a row group has its number of rows
+		lockedPage, release, err := table.lockRows(id, false, make([]bool, len(table.schema.Fields)))
+		if err != nil {
+			return 0, err
+		}
+
+		n += int64(lockedPage.NumRows())
+		release()
+	}
+	return n, nil
+}
+
 func (table *Table) Close() error {
 	err := table.pager.SyncAll()
 	if err != nil {
 		return err
 	}
-	return table.file.Close()
+
+	for _, index := range table.indexes {
+		err = index.close()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = table.dict.Close()
+	if err != nil {
+		return err
+	}
+	return table.storage.Close()
 }
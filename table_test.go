@@ -0,0 +1,512 @@
+package dumbdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testTableSchema() Schema {
+	return NewSchema([]FieldDescription{
+		{Name: "id", Type: &Type{Integer: true}},
+		{Name: "name", Type: &Type{Varchar: 100}},
+	})
+}
+
+func countPages(table *Table) int {
+	n := 0
+	for id := table.pager.FirstPage(); id != InvalidPageID; id = table.pager.NextPage(id) {
+		n++
+	}
+	return n
+}
+
+func TestFreeSpaceMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	table, err := NewTable(path, testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a page and a half
+	perPage := table.rowsPerPage()
+	rows := make([]Row, 0, perPage*3/2)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("user %v", i))})
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = table.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err = OpenTable(path, testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	// the second page is filled before a new one is allocated
+	for n := len(rows) % perPage; n < perPage; n++ {
+		err = table.Insert(rows[:1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if countPages(table) != 2 {
+			t.Fatalf("Expected 2 pages, got %v", countPages(table))
+		}
+	}
+
+	err = table.Insert(rows[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if countPages(table) != 3 {
+		t.Fatalf("Expected 3 pages, got %v", countPages(table))
+	}
+
+	n := 0
+	err = table.Scan(func(row Row) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2*perPage+1 {
+		t.Fatalf("Expected %v rows, got %v", 2*perPage+1, n)
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	dir := t.TempDir()
+	catalog, err := OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		catalog.Close()
+	}()
+
+	table, err := catalog.CreateTable("users", testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// half-empty pages, like the ones left by deletes
+	const nPages = 4
+	perPage := table.rowsPerPage() / 2
+	for p := 0; p < nPages; p++ {
+		id, err := table.pager.AllocatePage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rows := make([]Row, 0, perPage)
+		for i := 0; i < perPage; i++ {
+			rows = append(rows, Row{IntValue(int32(p*perPage + i)), VarcharValue("user")})
+		}
+
+		_, _, err = table.insertInto(id, rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		table.rowCount += int64(len(rows))
+	}
+
+	_, err = table.CreateIndex(indexPath(filepath.Join(dir, "users"), "users_id"), "users_id", []string{"id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = catalog.saveMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		table, err := catalog.Table("users")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := 0
+		seen := make(map[int64]bool)
+		err = table.Scan(func(row Row) error {
+			seen[row[0].Int] = true
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < nPages*perPage; i++ {
+			if !seen[int64(i)] {
+				t.Fatalf("Row %v is lost", i)
+			}
+		}
+
+		if n != nPages*perPage {
+			t.Fatalf("Expected %v rows, got %v", nPages*perPage, n)
+		}
+
+		// entries of the index point to the rows
+		cursor := table.indexes[0].tree.Search(BTreeKey{})
+		defer cursor.Close()
+
+		entries := 0
+		for ; cursor.Valid(); cursor.Forward() {
+			key, value := cursor.Get()
+			row, err := table.FetchRow(RowID(value))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rowKey, err := table.indexes[0].key(row)
+			if err != nil || !bytes.Equal(rowKey, key) {
+				t.Fatalf("Entry %x points to row %v with another key", key, row)
+			}
+			entries++
+		}
+		if cursor.Err() != nil || entries != n {
+			t.Fatalf("Expected %v index entries, got %v (%v)", n, entries, cursor.Err())
+		}
+	}
+
+	// a vacuum interrupted before the tables are swapped leaves the table as it was
+	alter, err := catalog.newShadow("users", table, table.schema, table.options, func(row Row) Row { return row })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = alter.backfill(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = alter.shadow.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = catalog.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err = OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	files, err := filepath.Glob(filepath.Join(dir, "users*"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("Expected the table and index files, got %v (%v)", files, err)
+	}
+
+	// the select reads the old table, which isn't changed by the vacuum
+	table, err = catalog.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := table.Query("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	_, err = catalog.doVacuum(&Vacuum{Table: "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if rows.Err() != nil || n != nPages*perPage {
+		t.Fatalf("Expected %v rows, got %v (%v)", nPages*perPage, n, rows.Err())
+	}
+
+	check()
+	table, err = catalog.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countPages(table) != nPages/2 {
+		t.Fatalf("Expected %v pages after vacuum, got %v", nPages/2, countPages(table))
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "users.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// allocation index + pages
+	if info.Size() != int64(1+nPages/2)*int64(PageSize) {
+		t.Fatalf("Unexpected file size after vacuum: %v", info.Size())
+	}
+}
+
+func TestFetchRow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	table, err := NewTable(path, testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	rows := make([]Row, 0, 100)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("user%v", i))})
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rows are appended to the pages in the insertion order
+	perPage := table.rowsPerPage()
+	for i, expected := range rows {
+		id := NewRowID(table.pager.FirstPage()+PageID(i/perPage), uint8(i%perPage))
+		row, err := table.FetchRow(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(row) != 2 || row[0].Int != expected[0].Int || row[1].StrVal() != expected[1].Str {
+			t.Fatalf("Row %v: expected %v, got %v", id, expected, row)
+		}
+	}
+
+	lastPage := table.pager.FirstPage() + PageID((len(rows)-1)/perPage)
+	for _, id := range []RowID{NewRowID(lastPage, uint8((len(rows)-1)%perPage+1)), NewRowID(lastPage+1, 0)} {
+		_, err = table.FetchRow(id)
+		if !errors.Is(err, ErrNoSuchRow) {
+			t.Fatalf("Expected ErrNoSuchRow for %v, got %v", id, err)
+		}
+	}
+}
+
+func BenchmarkTableInsert(b *testing.B) {
+	for _, nPages := range []int{10, 1000} {
+		b.Run(fmt.Sprintf("pages=%v", nPages), func(b *testing.B) {
+			table, err := NewTable(filepath.Join(b.TempDir(), "users"), testTableSchema(), TableOptions{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer table.Close()
+
+			row := Row{IntValue(1), VarcharValue("user")}
+			rows := make([]Row, table.rowsPerPage()*nPages)
+			for i := range rows {
+				rows[i] = row
+			}
+
+			err = table.Insert(rows)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err = table.Insert([]Row{row})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRowTooLarge(t *testing.T) {
+	var fields []FieldDescription
+	var names []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("c%v", i)
+		fields = append(fields, FieldDescription{Name: name, Type: &Type{Varchar: 255}})
+		names = append(names, name)
+	}
+	schema := NewSchema(fields)
+
+	path := filepath.Join(t.TempDir(), "t")
+	_, err := NewTable(path, schema, TableOptions{})
+	if !errors.Is(err, ErrRowTooLarge) {
+		t.Fatalf("Expected ErrRowTooLarge, got %v", err)
+	}
+
+	// values of dictionary columns are stored as ids, row groups fit any row
+	for _, opts := range []TableOptions{{Dictionary: names}, {Engine: EngineColumnar}} {
+		table, err := NewTable(path, schema, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		table.Close()
+		os.Remove(path + ".bin")
+	}
+
+	// tables created before the check can be opened, but rows can't be inserted
+	table, err := NewTable(path, schema, TableOptions{Dictionary: names})
+	if err == nil {
+		err = table.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err = OpenTable(path, schema, TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	row := make(Row, len(fields))
+	for i := range row {
+		row[i] = VarcharValue("value")
+	}
+	err = table.Insert([]Row{row})
+	if !errors.Is(err, ErrRowTooLarge) {
+		t.Fatalf("Expected ErrRowTooLarge, got %v", err)
+	}
+}
+
+// Storage failing writes at or after the offset
+type failingStorage struct {
+	TableStorage
+	offset int64
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (s *failingStorage) WriteAt(data []byte, off int64) (int, error) {
+	if off+int64(len(data)) > s.offset {
+		return 0, errWriteFailed
+	}
+	return s.TableStorage.WriteAt(data, off)
+}
+
+func TestInsertError(t *testing.T) {
+	table, err := NewTable(filepath.Join(t.TempDir(), "users"), testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	// rows which can't be written are rejected before any of them is inserted
+	long := Row{IntValue(1), VarcharValue(string(make([]byte, 200)))}
+	err = table.Insert([]Row{{IntValue(0), VarcharValue("a")}, long})
+	if !errors.Is(err, ErrConstraintViolation) || table.RowCount() != 0 {
+		t.Fatalf("Expected ErrConstraintViolation, got %v with %v rows", err, table.RowCount())
+	}
+
+	// the third page can't be written
+	table.pager.storage = &failingStorage{TableStorage: table.storage, offset: 3 * int64(PageSize)}
+
+	perPage := table.rowsPerPage()
+	rows := make([]Row, 0, 3*perPage)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("user%v", i))})
+	}
+
+	err = table.Insert(rows)
+	var insertErr *InsertError
+	if !errors.As(err, &insertErr) || insertErr.Inserted != 2*perPage || !errors.Is(err, errWriteFailed) {
+		t.Fatalf("Expected InsertError after %v rows, got %v", 2*perPage, err)
+	}
+	if table.RowCount() != int64(2*perPage) {
+		t.Fatalf("Expected %v rows, got %v", 2*perPage, table.RowCount())
+	}
+	table.pager.storage = table.storage
+
+	// rows are written before their index entries, so they are counted as inserted
+	index, err := table.CreateIndex(filepath.Join(t.TempDir(), "users_id.idx"), "users_id", []string{"id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := index.tree
+	index.tree = nil
+
+	err = table.Insert(rows[:3])
+	index.tree = tree
+	if !errors.As(err, &insertErr) || insertErr.Inserted != 3 || !errors.Is(err, ErrIndexDropped) {
+		t.Fatalf("Expected InsertError after 3 rows, got %v", err)
+	}
+	if table.RowCount() != int64(2*perPage+3) {
+		t.Fatalf("Expected %v rows, got %v", 2*perPage+3, table.RowCount())
+	}
+}
+
+func TestInsertBatch(t *testing.T) {
+	table, err := NewTable(filepath.Join(t.TempDir(), "users"), testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	// the third page can't be written
+	table.pager.storage = &failingStorage{TableStorage: table.storage, offset: 3 * int64(PageSize)}
+
+	// invalid rows don't fail the others
+	perPage := table.rowsPerPage()
+	rows := []Row{
+		{IntValue(1)},
+		{IntValue(2), VarcharValue(string(make([]byte, 200)))},
+	}
+	for i := 0; i < 3*perPage; i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("user%v", i))})
+	}
+
+	statuses := table.InsertBatch(rows)
+	if len(statuses) != len(rows) {
+		t.Fatalf("Expected %v statuses, got %v", len(rows), len(statuses))
+	}
+	if !errors.Is(statuses[0].Err, ErrTypeMismatch) || !errors.Is(statuses[1].Err, ErrConstraintViolation) {
+		t.Fatalf("Expected invalid rows to fail, got %v, %v", statuses[0].Err, statuses[1].Err)
+	}
+	for i, status := range statuses[2:] {
+		if i < 2*perPage && (!status.Inserted || status.Err != nil) {
+			t.Fatalf("Expected row %v to be inserted, got %v", i, status.Err)
+		}
+		if i >= 2*perPage && (status.Inserted || !errors.Is(status.Err, errWriteFailed)) {
+			t.Fatalf("Expected row %v to fail with errWriteFailed, got %v", i, status.Err)
+		}
+	}
+
+	if CountInserted(statuses) != 2*perPage || table.RowCount() != int64(2*perPage) {
+		t.Fatalf("Expected %v rows, got %v inserted and %v in the table", 2*perPage, CountInserted(statuses), table.RowCount())
+	}
+	table.pager.storage = table.storage
+
+	// rows written before their index entries failed are inserted, but report the error
+	index, err := table.CreateIndex(filepath.Join(t.TempDir(), "users_id.idx"), "users_id", []string{"id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := index.tree
+	index.tree = nil
+
+	statuses = table.InsertBatch([]Row{rows[2], rows[0], rows[3]})
+	index.tree = tree
+	for i, status := range statuses {
+		if (i != 1) != status.Inserted {
+			t.Fatalf("Unexpected status of row %v: %+v", i, status)
+		}
+		if i != 1 && !errors.Is(status.Err, ErrIndexDropped) {
+			t.Fatalf("Expected row %v to report ErrIndexDropped, got %v", i, status.Err)
+		}
+	}
+	if table.RowCount() != int64(2*perPage+2) {
+		t.Fatalf("Expected %v rows, got %v", 2*perPage+2, table.RowCount())
+	}
+}
@@ -0,0 +1,216 @@
+package dumbdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidBTree = errors.New("B+ tree is invalid")
+
+// Contents of a node copied out of its page, so that the page isn't latched
+// while its children are checked
+type nodeCopy struct {
+	isLeaf bool
+	keys   []BTreeKey
+	values []uint32
+	prev   PageID
+	next   PageID
+	used   int // bytes taken by the entries
+}
+
+func copyNode(node *BTreeNode) nodeCopy {
+	c := nodeCopy{
+		isLeaf: node.isLeaf,
+		keys:   make([]BTreeKey, node.len()),
+		values: make([]uint32, node.len()),
+		prev:   node.prev,
+		next:   node.next,
+		used:   node.slotOffset(node.len()) - NodeHeaderSize + int(node.keyBytes),
+	}
+
+	for idx := 0; idx < node.len(); idx++ {
+		c.keys[idx] = append(BTreeKey(nil), node.key(idx)...)
+		c.values[idx] = node.value(idx)
+	}
+	return c
+}
+
+// State of the tree traversal done by Validate()
+type treeCheck struct {
+	tree    *BTree
+	visited map[PageID]bool
+
+	// depth of the leaves, -1 until the first leaf is reached
+	leafDepth int
+	// the last visited leaf, leaves are visited in the key order
+	lastLeaf    PageID
+	lastLeafKey BTreeKey
+	lastNext    PageID
+
+	// least number of bytes taken by the entries of a node, except the root and
+	// the nodes on the edges: the leftmost leaf of a new tree holds only the smallest
+	// possible key, and the rightmost nodes can be partially filled by a bulk load.
+	// Splits leave about half of the entries in each node, nodes with variable-size
+	// keys are split by size, so they can get a few entries less
+	minFill int
+	entries int
+}
+
+// Check the invariants of the tree: all the leaves are at the same depth, keys
+// are ordered within and across the nodes (strictly for unique trees), keys of
+// each subtree are within the bounds set by the separators of its parents, the
+// leaves are linked in the key order, and nodes are filled by at least about a half.
+// Returns the number of entries, or error wrapping ErrInvalidBTree (or ErrCorruptedPage)
+// which describes the first violation.
+// NOTE: the tree must not be modified concurrently
+func (tree *BTree) Validate() (int, error) {
+	maxEntry := VarSlotSize + MaxKeySize
+	if tree.keySize != VariableKeySize {
+		maxEntry = tree.keySize + ValueSize
+	}
+
+	check := treeCheck{
+		tree:      tree,
+		visited:   make(map[PageID]bool),
+		leafDepth: -1,
+		lastLeaf:  InvalidPageID,
+		lastNext:  InvalidPageID,
+		minFill:   (int(PageDataSize)-NodeHeaderSize)/2 - 3*maxEntry,
+	}
+
+	root := tree.rlatchRoot()
+	rootID := root.page.id
+	rootCopy := copyNode(&root)
+	runlatch(&root)
+
+	if rootCopy.isLeaf {
+		return 0, fmt.Errorf("%w: root %v is a leaf", ErrInvalidBTree, rootID)
+	}
+
+	check.visited[rootID] = true
+	err := check.node(rootID, &rootCopy, 0, nil, nil, true)
+	if err != nil {
+		return 0, err
+	}
+
+	if check.lastNext != InvalidPageID {
+		return 0, fmt.Errorf("%w: the last leaf %v is followed by %v", ErrInvalidBTree, check.lastLeaf, check.lastNext)
+	}
+	return check.entries, nil
+}
+
+// Read the node and copy it, the node has to pass BTreeNode.check()
+func (check *treeCheck) fetch(id PageID) (nodeCopy, error) {
+	if check.visited[id] {
+		return nodeCopy{}, fmt.Errorf("%w: page %v is referenced twice", ErrInvalidBTree, id)
+	}
+	check.visited[id] = true
+
+	page, err := check.tree.pager.FetchPage(id)
+	if err != nil {
+		return nodeCopy{}, fmt.Errorf("%v: %w", id, err)
+	}
+	defer page.Unpin()
+
+	page.RLock()
+	defer page.RUnlock()
+
+	node := readNode(page)
+	err = node.check(check.tree.keySize)
+	if err != nil {
+		return nodeCopy{}, fmt.Errorf("%v: %w", id, err)
+	}
+	return copyNode(&node), nil
+}
+
+// Check the subtree of the node, its keys have to be > low (>= for non-unique trees)
+// and <= high, nil bounds are not checked. edge is true for the leftmost and the
+// rightmost nodes of their level
+func (check *treeCheck) node(id PageID, node *nodeCopy, depth int, low BTreeKey, high BTreeKey, edge bool) error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %v: %v", ErrInvalidBTree, id, fmt.Sprintf(format, args...))
+	}
+
+	if !edge && node.used < check.minFill {
+		return invalid("entries take %v bytes, expected at least %v", node.used, check.minFill)
+	}
+
+	for idx, key := range node.keys {
+		if low != nil {
+			cmp := bytes.Compare(key, low)
+			if cmp < 0 || cmp == 0 && check.tree.unique {
+				return invalid("key %v of entry %v is below the lower bound %v", key, idx, low)
+			}
+		}
+
+		if high != nil && bytes.Compare(key, high) > 0 {
+			return invalid("key %v of entry %v is above the upper bound %v", key, idx, high)
+		}
+
+		if idx != 0 {
+			cmp := bytes.Compare(node.keys[idx-1], key)
+			if cmp > 0 || cmp == 0 && node.isLeaf && check.tree.unique {
+				return invalid("key %v of entry %v follows %v", key, idx, node.keys[idx-1])
+			}
+		}
+	}
+
+	if node.isLeaf {
+		return check.leaf(id, node, depth)
+	}
+
+	// child of the entry has keys <= its key and above the key of the previous entry,
+	// the rightmost child has keys above the last key
+	for idx, key := range node.keys {
+		childID := PageID(node.values[idx])
+		child, err := check.fetch(childID)
+		if err != nil {
+			return err
+		}
+
+		err = check.node(childID, &child, depth+1, low, key, edge && idx == 0)
+		if err != nil {
+			return err
+		}
+		low = key
+	}
+
+	child, err := check.fetch(node.next)
+	if err != nil {
+		return err
+	}
+	return check.node(node.next, &child, depth+1, low, high, edge)
+}
+
+func (check *treeCheck) leaf(id PageID, node *nodeCopy, depth int) error {
+	if check.leafDepth == -1 {
+		check.leafDepth = depth
+	} else if depth != check.leafDepth {
+		return fmt.Errorf("%w: leaf %v is at depth %v, expected %v", ErrInvalidBTree, id, depth, check.leafDepth)
+	}
+
+	if check.lastLeaf == InvalidPageID && node.prev != InvalidPageID {
+		return fmt.Errorf("%w: the first leaf %v is preceded by %v", ErrInvalidBTree, id, node.prev)
+	}
+
+	if check.lastLeaf != InvalidPageID && (check.lastNext != id || node.prev != check.lastLeaf) {
+		return fmt.Errorf("%w: leaf %v follows %v, but they are linked to %v and %v",
+			ErrInvalidBTree, id, check.lastLeaf, node.prev, check.lastNext)
+	}
+
+	if len(node.keys) != 0 && check.lastLeafKey != nil {
+		cmp := bytes.Compare(check.lastLeafKey, node.keys[0])
+		if cmp > 0 || cmp == 0 && check.tree.unique {
+			return fmt.Errorf("%w: the first key %v of leaf %v follows %v", ErrInvalidBTree, node.keys[0], id, check.lastLeafKey)
+		}
+	}
+
+	if len(node.keys) != 0 {
+		check.lastLeafKey = node.keys[len(node.keys)-1]
+	}
+	check.lastLeaf = id
+	check.lastNext = node.next
+	check.entries += len(node.keys)
+	return nil
+}
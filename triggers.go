@@ -0,0 +1,470 @@
+package dumbdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	ErrTriggerAlreadyExist = errors.New("trigger with such name already exist")
+	ErrNoSuchTrigger       = errors.New("no trigger with such name")
+)
+
+// Triggers of the catalog by name, with their definitions (source of create trigger)
+const TriggersFilename string = "triggers.json"
+
+// Max number of triggers an insert can be nested in, e.g. when a trigger inserts
+// into a table whose trigger inserts back into the first one
+const MaxTriggerDepth = 16
+
+// Trigger stored in the catalog under a name
+type Trigger struct {
+	Definition string
+	query      *CreateTrigger
+}
+
+func parseTrigger(definition string) (*Trigger, error) {
+	q, err := ParseQuery(definition)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.CreateTrigger == nil {
+		return nil, fmt.Errorf("trigger definition is not create trigger: %v", definition)
+	}
+
+	return &Trigger{Definition: definition, query: q.CreateTrigger}, nil
+}
+
+func (catalog *Catalog) loadTriggers() error {
+	data, err := ioutil.ReadFile(filepath.Join(catalog.dataDir, TriggersFilename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	definitions := make(map[string]string)
+	err = json.Unmarshal(data, &definitions)
+	if err != nil {
+		return err
+	}
+
+	for name, definition := range definitions {
+		trigger, err := parseTrigger(definition)
+		if err != nil {
+			return fmt.Errorf("trigger %v: %w", name, err)
+		}
+		catalog.triggers[name] = trigger
+	}
+	return nil
+}
+
+// Encoded definitions of all the triggers
+func (catalog *Catalog) triggerDefinitions() ([]byte, error) {
+	definitions := make(map[string]string)
+	for name, trigger := range catalog.triggers {
+		definitions[name] = trigger.Definition
+	}
+	return json.Marshal(definitions)
+}
+
+// catalog.m should be locked
+func (catalog *Catalog) saveTriggers() error {
+	data, err := catalog.triggerDefinitions()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(catalog.dataDir, TriggersFilename), data)
+}
+
+// Trigger ready to be fired for the rows of its table
+type triggerPlan struct {
+	name string
+	// nil if the trigger fires for all the rows
+	when func(Row) (bool, error)
+
+	// before triggers either set values of the columns or reject the row
+	columns []int
+	values  []func(Row) (Value, error)
+	raise   *string
+
+	// after triggers insert a row into another table, values are in the order of its columns
+	table    string
+	generate bool
+}
+
+// Compile expression of the trigger evaluated on rows of the table
+func triggerExpr(expr *Expression, schema *Schema) (*BinOpTree, TypeID, error) {
+	tree := expr.ToBinOp()
+	err := checkQualifiers(tree, "new")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	t, err := exprType(tree, schema)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tree, t, nil
+}
+
+// Plan the trigger for rows of the table with the schema
+// catalog.m should be at least read-locked
+func (catalog *Catalog) planTrigger(name string, trigger *CreateTrigger, schema *Schema) (*triggerPlan, error) {
+	if trigger.Event != "insert" {
+		return nil, fmt.Errorf("%v triggers are not supported, only insert ones", trigger.Event)
+	}
+
+	fieldToIdx := make(map[string]int)
+	for i, column := range schema.ColumnNames() {
+		fieldToIdx[column] = i
+	}
+
+	eval := func(tree *BinOpTree) func(Row) (Value, error) {
+		return func(row Row) (Value, error) {
+			return evalExpr(tree, fieldToIdx, row)
+		}
+	}
+
+	plan := &triggerPlan{name: name}
+	if trigger.When != nil {
+		tree, t, err := triggerExpr(trigger.When, schema)
+		if err != nil {
+			return nil, err
+		}
+		if t != TypeBool {
+			return nil, errors.New("when condition of trigger should eval to bool")
+		}
+
+		when := eval(tree)
+		plan.when = func(row Row) (bool, error) {
+			value, err := when(row)
+			return value.Int != 0, err
+		}
+	}
+
+	action := trigger.Action
+	before := trigger.Timing == "before"
+	switch {
+	case action.Set != nil:
+		if !before {
+			return nil, errors.New("set is allowed only in before triggers, rows are already inserted after them")
+		}
+
+		for _, assignment := range action.Set {
+			err := checkQualifier(assignment.Column, "new")
+			if err != nil {
+				return nil, err
+			}
+
+			idx, field := schema.GetField(assignment.Column.Name)
+			if idx == -1 {
+				return nil, fmt.Errorf("no column named %v in table", assignment.Column)
+			}
+
+			tree, t, err := triggerExpr(assignment.Value, schema)
+			if err != nil {
+				return nil, err
+			}
+			if !Comparable(field.TypeID, t) {
+				return nil, fmt.Errorf("%w: column %v is %v, value is %v", ErrTypeMismatch, field.Name, field.TypeID, t)
+			}
+
+			plan.columns = append(plan.columns, idx)
+			plan.values = append(plan.values, eval(tree))
+		}
+	case action.Raise != nil:
+		if !before {
+			return nil, errors.New("raise is allowed only in before triggers, rows are already inserted after them")
+		}
+		plan.raise = action.Raise
+	case action.Insert != nil:
+		if before {
+			return nil, errors.New("insert is allowed only in after triggers")
+		}
+
+		insert := action.Insert
+		table, ok := catalog.tables[insert.Table]
+		if !ok {
+			return nil, catalog.tableError(insert.Table, ErrNoSuchTable)
+		}
+
+		indexes := make([]int, 0, len(table.schema.Fields))
+		for i := range table.schema.Fields {
+			indexes = append(indexes, i)
+		}
+		if len(insert.Columns) != 0 {
+			var err error
+			indexes, plan.generate, err = insertColumns(insert.Columns, &table.schema)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(insert.Values) != len(indexes) {
+			return nil, fmt.Errorf("%w: insert of trigger has %v values, expected %v", ErrTypeMismatch, len(insert.Values), len(indexes))
+		}
+
+		for i, value := range insert.Values {
+			tree, t, err := triggerExpr(value, schema)
+			if err != nil {
+				return nil, err
+			}
+
+			field := &table.schema.Fields[indexes[i]]
+			if !Comparable(field.TypeID, t) {
+				return nil, fmt.Errorf("%w: column %v is %v, value is %v", ErrTypeMismatch, field.Name, field.TypeID, t)
+			}
+
+			plan.columns = append(plan.columns, indexes[i])
+			plan.values = append(plan.values, eval(tree))
+		}
+		plan.table = insert.Table
+	}
+
+	return plan, nil
+}
+
+// Plan triggers of the table fired before and after the insert, in the order of their names
+// catalog.m should be at least read-locked
+func (catalog *Catalog) planTriggers(table string, schema *Schema) ([]*triggerPlan, []*triggerPlan, error) {
+	if len(catalog.triggers) == 0 {
+		return nil, nil, nil
+	}
+
+	var names []string
+	for name, trigger := range catalog.triggers {
+		if trigger.query.Table == table {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var before, after []*triggerPlan
+	for _, name := range names {
+		trigger := catalog.triggers[name].query
+		plan, err := catalog.planTrigger(name, trigger, schema)
+		if err != nil {
+			// the table or the table the trigger inserts into were altered since it was created
+			return nil, nil, fmt.Errorf("trigger %v: %w", name, err)
+		}
+
+		if trigger.Timing == "before" {
+			before = append(before, plan)
+		} else {
+			after = append(after, plan)
+		}
+	}
+	return before, after, nil
+}
+
+// Whether the trigger fires for the row
+func (trigger *triggerPlan) fires(row Row) (bool, error) {
+	if trigger.when == nil {
+		return true, nil
+	}
+	return trigger.when(row)
+}
+
+// Fire before triggers for each row, they change the rows in place
+func fireBefore(triggers []*triggerPlan, rows []Row) error {
+	for i, row := range rows {
+		for _, trigger := range triggers {
+			fires, err := trigger.fires(row)
+			if err != nil {
+				return fmt.Errorf("row #%d: trigger %v failed: %w", i, trigger.name, err)
+			}
+			if !fires {
+				continue
+			}
+
+			if trigger.raise != nil {
+				return fmt.Errorf("%w: row #%d rejected by trigger %v: %v", ErrConstraintViolation, i, trigger.name, *trigger.raise)
+			}
+
+			// all the values are computed from the row as it was before the trigger
+			values := make([]Value, 0, len(trigger.values))
+			for _, eval := range trigger.values {
+				value, err := eval(row)
+				if err != nil {
+					return fmt.Errorf("row #%d: trigger %v failed: %w", i, trigger.name, err)
+				}
+				values = append(values, value)
+			}
+			for j, idx := range trigger.columns {
+				row[idx] = values[j]
+			}
+		}
+	}
+	return nil
+}
+
+// Fire after triggers for the inserted rows, each trigger inserts its rows at once
+// catalog.m should be locked
+func (catalog *Catalog) fireAfter(triggers []*triggerPlan, rows []Row, depth int) error {
+	if len(triggers) != 0 && depth >= MaxTriggerDepth {
+		return fmt.Errorf("triggers are nested too deep, %v levels at most", MaxTriggerDepth)
+	}
+
+	for _, trigger := range triggers {
+		table, ok := catalog.tables[trigger.table]
+		if !ok {
+			return fmt.Errorf("rows are inserted, but trigger %v failed: %w", trigger.name, ErrNoSuchTable)
+		}
+
+		var inserted []Row
+		for _, row := range rows {
+			fires, err := trigger.fires(row)
+			if err != nil {
+				return fmt.Errorf("rows are inserted, but trigger %v failed: %w", trigger.name, err)
+			}
+			if !fires {
+				continue
+			}
+
+			full := make(Row, len(table.schema.Fields))
+			for i, idx := range trigger.columns {
+				full[idx], err = trigger.values[i](row)
+				if err != nil {
+					return fmt.Errorf("rows are inserted, but trigger %v failed: %w", trigger.name, err)
+				}
+			}
+			inserted = append(inserted, full)
+		}
+
+		if len(inserted) == 0 {
+			continue
+		}
+
+		_, err := catalog.insertNested(trigger.table, table, inserted, trigger.generate, depth+1)
+		if err != nil {
+			return fmt.Errorf("rows are inserted, but trigger %v failed: %w", trigger.name, err)
+		}
+	}
+	return nil
+}
+
+func (catalog *Catalog) doCreateTrigger(create *CreateTrigger) (*Result, error) {
+	if create.Definition == "" {
+		return nil, errors.New("trigger definition is missing")
+	}
+
+	err := validateName(create.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	if _, ok := catalog.triggers[create.Name]; ok {
+		return nil, ErrTriggerAlreadyExist
+	}
+
+	table, ok := catalog.tables[create.Table]
+	if !ok {
+		return nil, catalog.tableError(create.Table, ErrNoSuchTable)
+	}
+
+	// the trigger is checked now, and again each time it's fired, as the
+	// tables can be altered or dropped later
+	_, err = catalog.planTrigger(create.Name, create, &table.schema)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog.triggers[create.Name] = &Trigger{Definition: create.Definition, query: create}
+	err = catalog.saveTriggers()
+	if err != nil {
+		delete(catalog.triggers, create.Name)
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (catalog *Catalog) doDropTrigger(drop *DropTrigger) (*Result, error) {
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	trigger, ok := catalog.triggers[drop.Name]
+	if !ok {
+		return nil, ErrNoSuchTrigger
+	}
+
+	delete(catalog.triggers, drop.Name)
+	err := catalog.saveTriggers()
+	if err != nil {
+		catalog.triggers[drop.Name] = trigger
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Returns the table the trigger fires for, empty if there is no such trigger
+// catalog.m should be at least read-locked
+func (catalog *Catalog) triggerTableName(name string) string {
+	trigger, ok := catalog.triggers[name]
+	if !ok {
+		return ""
+	}
+	return trigger.query.Table
+}
+
+// Drop triggers of the dropped table
+// catalog.m should be locked
+func (catalog *Catalog) dropTriggers(table string) error {
+	changed := false
+	for name, trigger := range catalog.triggers {
+		if trigger.query.Table == table {
+			delete(catalog.triggers, name)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	return catalog.saveTriggers()
+}
+
+func (catalog *Catalog) doShowTriggers() (*Result, error) {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	names := make([]string, 0, len(catalog.triggers))
+	for name := range catalog.triggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var schema Schema
+	schema.addField(Field{Name: "trigger", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "table", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "definition", TypeID: TypeVarchar, Len: 255})
+
+	rows := make([]Row, 0, len(names))
+	for _, name := range names {
+		trigger := catalog.triggers[name]
+		rows = append(rows, Row{
+			{TypeID: TypeVarchar, Str: name},
+			{TypeID: TypeVarchar, Str: trigger.query.Table},
+			{TypeID: TypeVarchar, Str: trigger.Definition},
+		})
+	}
+
+	return &Result{
+		Schema: schema,
+		Rows:   StaticRows(rows),
+	}, nil
+}
@@ -0,0 +1,131 @@
+package dumbdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTriggers(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+	}()
+
+	sess := &Session{}
+	query := func(q string) []string {
+		return rowStrings(mustQuery(t, db, sess, q), (*Value).String, " ")
+	}
+
+	for _, q := range []string{
+		"create table users (id int auto_increment, name varchar(20), age int)",
+		"create table audit (id bigint auto_increment, user_id int, name varchar(20))",
+		"create trigger users_lower before insert on users for each row set name = lower(new.name)",
+		"create trigger users_age before insert on users for each row when (age > 150) raise error \"age is too large\"",
+		"create trigger users_audit after insert on users for each row when (new.age >= 18) insert into audit (user_id, name) values (id, name + \"!\")",
+	} {
+		mustExec(t, db, sess, q)
+	}
+
+	mustExec(t, db, sess, "insert into users (name, age) values (\"Alice\", 30), (\"BOB\", 10), (\"Carol\", 40)")
+
+	// the whole insert is rejected
+	_, err = execQuery(t, db, sess, "insert into users (name, age) values (\"Dave\", 20), (\"Eve\", 200)")
+	if !errors.Is(err, ErrConstraintViolation) || !strings.Contains(err.Error(), "age is too large") {
+		t.Fatalf("Expected rejected row, got %v", err)
+	}
+
+	// rows copied from a file fire the triggers as well
+	filename := filepath.Join(dir, "users.csv")
+	err = os.WriteFile(filename, []byte("10,Frank,50\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, db, sess, fmt.Sprintf("copy users from %q", filename))
+
+	check := func() {
+		expected := []string{"1 alice 30", "2 bob 10", "3 carol 40", "10 frank 50"}
+		users := query("select * from users")
+		if !reflect.DeepEqual(users, expected) {
+			t.Fatalf("Expected users %v, got %v", expected, users)
+		}
+
+		expected = []string{"1 1 alice!", "2 3 carol!", "3 10 frank!"}
+		audit := query("select * from audit")
+		if !reflect.DeepEqual(audit, expected) {
+			t.Fatalf("Expected audit %v, got %v", expected, audit)
+		}
+	}
+	check()
+
+	triggers := query("show triggers")
+	if len(triggers) != 3 || !strings.HasPrefix(triggers[0], "users_age users create trigger users_age before insert") {
+		t.Fatalf("Unexpected triggers %q", triggers)
+	}
+
+	// triggers are persisted
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	// triggers inserting into each other's tables stop at some depth
+	for _, q := range []string{
+		"create table a (n int)",
+		"create table b (n int)",
+		"create trigger a_b after insert on a for each row insert into b values (n + 1)",
+		"create trigger b_a after insert on b for each row insert into a values (n + 1)",
+	} {
+		mustExec(t, db, sess, q)
+	}
+
+	_, err = execQuery(t, db, sess, "insert into a values (0)")
+	if err == nil || !strings.Contains(err.Error(), "nested too deep") {
+		t.Fatalf("Expected too deep triggers, got %v", err)
+	}
+
+	for _, q := range []string{
+		"create trigger users_lower before insert on users for each row set name = \"x\"",
+		"create trigger t before insert on missing for each row set name = \"x\"",
+		"create trigger t before update on users for each row set name = \"x\"",
+		"create trigger t after insert on users for each row set name = \"x\"",
+		"create trigger t before insert on users for each row insert into audit values (1, 2, \"x\")",
+		"create trigger t after insert on users for each row raise error \"x\"",
+		"create trigger t before insert on users for each row set missing = 1",
+		"create trigger t before insert on users for each row set age = name",
+		"create trigger t before insert on users for each row set old.age = 1",
+		"create trigger t before insert on users for each row when (age) set age = 1",
+		"create trigger t after insert on users for each row insert into missing values (1)",
+		"create trigger t after insert on users for each row insert into audit values (id)",
+		"create trigger t after insert on users for each row insert into audit (user_id) values (name)",
+		"drop trigger missing",
+	} {
+		_, err := execQuery(t, db, sess, q)
+		if err == nil {
+			t.Fatalf("Expected %v to fail", q)
+		}
+	}
+
+	// triggers go away with their table
+	for _, q := range []string{"drop trigger users_age", "drop table a", "drop table b"} {
+		mustExec(t, db, sess, q)
+	}
+
+	triggers = query("show triggers")
+	if len(triggers) != 2 || !strings.HasPrefix(triggers[0], "users_audit ") || !strings.HasPrefix(triggers[1], "users_lower ") {
+		t.Fatalf("Unexpected triggers %q", triggers)
+	}
+}
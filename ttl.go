@@ -0,0 +1,209 @@
+package dumbdb
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInvalidTTL = errors.New("invalid ttl")
+
+// TTL of the rows and index of the column with their creation time, -1 if the
+// rows don't expire. The column holds unix time in seconds
+func (opts *TableOptions) expiration(schema *Schema) (time.Duration, int, error) {
+	if opts.TTL == "" && opts.TTLColumn == "" {
+		return 0, -1, nil
+	}
+
+	ttl, err := time.ParseDuration(opts.TTL)
+	if err != nil || ttl <= 0 {
+		return 0, -1, fmt.Errorf("%w: %q should be a positive duration, e.g. 24h", ErrInvalidTTL, opts.TTL)
+	}
+
+	idx, field := schema.GetField(opts.TTLColumn)
+	if idx == -1 {
+		return 0, -1, fmt.Errorf("%w: no column named %q for the ttl", ErrInvalidTTL, opts.TTLColumn)
+	}
+
+	if !field.TypeID.IsInteger() {
+		return 0, -1, fmt.Errorf("%w: ttl column %v should be int or bigint (unix time in seconds), not %v", ErrInvalidTTL, opts.TTLColumn, field.TypeID)
+	}
+	return ttl, idx, nil
+}
+
+// Delete rows matching filter by rewriting the pages holding them without the
+// rows, returns number of deleted rows. Indexes are rebuilt, as the remaining
+// rows of the rewritten pages get new ids, so scans of the table are stopped
+// before the first page is rewritten. catalog.m should be locked
+func (table *Table) deleteWhere(filter func(Row) bool) (int, error) {
+	if table.options.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	err := table.freeSpace.load(table)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	stopped := false
+	for id := table.firstPage(); id != InvalidPageID; id = table.nextPage(id) {
+		if !stopped {
+			// scans aren't stopped unless some rows are deleted
+			var matches bool
+			matches, err = table.pageMatches(id, filter)
+			if err != nil {
+				break
+			}
+			if !matches {
+				continue
+			}
+
+			table.stopScans(ErrScanInterrupted)
+			stopped = true
+		}
+
+		var n int
+		n, err = table.deleteFromPage(id, filter)
+		deleted += n
+		atomic.AddInt64(&table.rowCount, -int64(n))
+		if err != nil {
+			break
+		}
+	}
+
+	if deleted == 0 {
+		return 0, err
+	}
+
+	// rows of the pages rewritten before an error are moved as well
+	rebuildErr := table.rebuildIndexes()
+	if err == nil {
+		err = rebuildErr
+	} else if rebuildErr != nil {
+		err = fmt.Errorf("%w, and indexes failed to be rebuilt: %v", err, rebuildErr)
+	}
+	return deleted, err
+}
+
+// Returns true if some rows of the page match filter
+func (table *Table) pageMatches(id PageID, filter func(Row) bool) (bool, error) {
+	rows, err := table.readPage(id, nil, nil)
+	if err != nil {
+		return false, err
+	}
+
+	for _, row := range rows {
+		if filter(row) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (table *Table) deleteFromPage(id PageID, filter func(Row) bool) (int, error) {
+	lockedPage, release, err := table.lockRows(id, true, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	err = lockedPage.Check(&table.layout)
+	if err != nil {
+		return 0, fmt.Errorf("%v: %w", id, err)
+	}
+
+	// rows are kept as stored, the filter gets the decoded ones
+	n := lockedPage.NumRows()
+	kept := make([]Row, 0, n)
+	for i := 0; i < n; i++ {
+		stored := lockedPage.ReadRow(i, &table.layout)
+		// values are decoded in place
+		row, err := table.dict.decode(append(Row(nil), stored...), nil)
+		if err != nil {
+			return 0, err
+		}
+
+		if !filter(row) {
+			kept = append(kept, stored)
+		}
+	}
+
+	if len(kept) == n {
+		return 0, nil
+	}
+
+	lockedPage.RemoveLast(n)
+	for _, row := range kept {
+		if !lockedPage.TryInsert(row, &table.layout) {
+			lockedPage.Rollback()
+			return 0, ErrRowNotInserted
+		}
+	}
+
+	// kept rows overwrite the old ones in place, so the page can't be rolled
+	// back once it's committed, it's written by the next sync if this one fails
+	lockedPage.Commit()
+	err = lockedPage.Sync(table.pager)
+	if err != nil {
+		return n - len(kept), err
+	}
+
+	// bloom filters and zone maps still cover the deleted values, which is safe
+	table.freeSpace.Update(id, table.rowsPerPage()-lockedPage.NumRows())
+	return n - len(kept), nil
+}
+
+// Delete rows of the table older than its TTL, returns number of deleted rows
+// catalog.m should be locked
+func (table *Table) expire(now time.Time) (int, error) {
+	// rows are copied into the new table, so they aren't moved until it's done
+	if table.ttlColumn == -1 || table.capture != nil {
+		return 0, nil
+	}
+
+	deadline := now.Add(-table.ttl).Unix()
+	return table.deleteWhere(func(row Row) bool {
+		return row[table.ttlColumn].Int < deadline
+	})
+}
+
+// Delete expired rows of the tables with TTL, returns number of deleted rows
+func (catalog *Catalog) ExpireRows(now time.Time) (int, error) {
+	if catalog.readOnly {
+		return 0, nil
+	}
+
+	// deletion moves rows, so nothing else should use the table
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	deleted := 0
+	for name, table := range catalog.tables {
+		n, err := table.expire(now)
+		deleted += n
+		DefaultMetrics.ExpiredRows.Add(uint64(n))
+		if err != nil {
+			return deleted, fmt.Errorf("table %v: %w", name, err)
+		}
+	}
+	return deleted, nil
+}
+
+// Delete expired rows of the tables of all the databases, returns number of
+// deleted rows. It's meant to be called periodically
+func (db *Database) ExpireRows(now time.Time) (int, error) {
+	db.m.RLock()
+	defer db.m.RUnlock()
+
+	deleted := 0
+	for name, catalog := range db.catalogs {
+		n, err := catalog.ExpireRows(now)
+		deleted += n
+		if err != nil {
+			return deleted, fmt.Errorf("database %v: %w", name, err)
+		}
+	}
+	return deleted, nil
+}
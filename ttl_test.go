@@ -0,0 +1,184 @@
+package dumbdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpireRows(t *testing.T) {
+	now := time.Now()
+
+	for _, engine := range []string{"disk", EngineColumnar} {
+		t.Run(engine, func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := NewDatabase(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mustExec(t, db, nil, fmt.Sprintf("create table events (id int, kind varchar(10), created_at bigint) engine = %v dictionary = (kind) with (ttl = \"1h\", ttl_column = created_at)", engine))
+
+			// every third row is expired
+			values := ""
+			for i := 0; i < 3000; i++ {
+				created := now.Unix()
+				if i%3 == 0 {
+					created -= 2 * 3600
+				}
+				if i != 0 {
+					values += ", "
+				}
+				values += fmt.Sprintf("(%v, \"kind%v\", %v)", i, i%5, created)
+			}
+
+			for _, q := range []string{"insert into events values " + values, "create index events_id on events (id)"} {
+				mustExec(t, db, nil, q)
+			}
+
+			n, err := db.ExpireRows(now)
+			if err != nil || n != 1000 {
+				t.Fatalf("Expected 1000 rows to expire, got %v (%v)", n, err)
+			}
+
+			rows, err := queryRows(t, db, nil, "select id, kind from events")
+			if err != nil || len(rows) != 2000 {
+				t.Fatalf("Expected 2000 rows, got %v (%v)", len(rows), err)
+			}
+			for _, row := range rows {
+				if row[0].Int%3 == 0 || row[1].Str != fmt.Sprintf("kind%v", row[0].Int%5) {
+					t.Fatalf("Unexpected row %v", row)
+				}
+			}
+
+			// index points to the moved rows
+			rows, err = queryRows(t, db, nil, "select id from events where id = 1999")
+			if err != nil || len(rows) != 1 || rows[0][0].Int != 1999 {
+				t.Fatalf("Expected row 1999, got %v (%v)", rows, err)
+			}
+
+			// selects are stopped only once some rows expire
+			result := mustExec(t, db, nil, "select id from events where id >= 1000")
+			if !result.Rows.Next() {
+				t.Fatalf("Expected rows, got %v", result.Rows.Err())
+			}
+
+			n, err = db.ExpireRows(now)
+			if err != nil || n != 0 {
+				t.Fatalf("Expected no rows to expire, got %v (%v)", n, err)
+			}
+
+			mustExec(t, db, nil, fmt.Sprintf("insert into events values (3000, \"kind0\", %v)", now.Unix()-2*3600))
+			n, err = db.ExpireRows(now)
+			if err != nil || n != 1 {
+				t.Fatalf("Expected the inserted row to expire, got %v (%v)", n, err)
+			}
+
+			for result.Rows.Next() {
+			}
+			if !errors.Is(result.Rows.Err(), ErrScanInterrupted) {
+				t.Fatalf("Expected ErrScanInterrupted, got %v", result.Rows.Err())
+			}
+
+			// ttl is persisted with the table
+			err = db.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db, err = NewDatabase(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			n, err = db.ExpireRows(now.Add(3 * time.Hour))
+			if err != nil || n != 2000 {
+				t.Fatalf("Expected the rest to expire, got %v (%v)", n, err)
+			}
+
+			table, err := db.Table("events")
+			if err != nil || table.RowCount() != 0 {
+				t.Fatalf("Expected empty table, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteWhereError(t *testing.T) {
+	dir := t.TempDir()
+	table, err := NewTable(filepath.Join(dir, "users"), testTableSchema(), TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	perPage := table.rowsPerPage()
+	rows := make([]Row, 0, 3*perPage)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("user%v", i))})
+	}
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := table.CreateIndex(filepath.Join(dir, "users_id.idx"), "users_id", []string{"id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the third page can't be written, the first two are rewritten already
+	table.pager.storage = &failingStorage{TableStorage: table.storage, offset: 3 * int64(PageSize)}
+	deleted, err := table.deleteWhere(func(row Row) bool { return row[0].Int%2 == 0 })
+	table.pager.storage = table.storage
+	if !errors.Is(err, errWriteFailed) || deleted != len(rows)-int(table.RowCount()) {
+		t.Fatalf("Expected errWriteFailed with %v rows deleted, got %v (%v)", len(rows)-int(table.RowCount()), deleted, err)
+	}
+
+	// index is rebuilt, so its entries point to the moved rows
+	cursor := index.tree.Search(BTreeKey{})
+	defer cursor.Close()
+
+	count := 0
+	for ; cursor.Valid(); cursor.Forward() {
+		key, value := cursor.Get()
+		row, err := table.FetchRow(RowID(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rowKey, err := index.key(row)
+		if err != nil || !bytes.Equal(rowKey, key) {
+			t.Fatalf("Entry %x points to row %v with another key", key, row)
+		}
+		count++
+	}
+
+	if cursor.Err() != nil || int64(count) != table.RowCount() {
+		t.Fatalf("Expected %v entries, got %v (%v)", table.RowCount(), count, cursor.Err())
+	}
+}
+
+func TestInvalidTTL(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, q := range []string{
+		"create table t (id int, created_at bigint) with (ttl = \"soon\", ttl_column = created_at)",
+		"create table t (id int, created_at bigint) with (ttl = \"-1h\", ttl_column = created_at)",
+		"create table t (id int, created_at bigint) with (ttl = \"1h\", ttl_column = missing)",
+		"create table t (id int, created_at varchar(20)) with (ttl = \"1h\", ttl_column = created_at)",
+	} {
+		_, err = execQuery(t, db, nil, q)
+		if !errors.Is(err, ErrInvalidTTL) {
+			t.Fatalf("Expected ErrInvalidTTL for %v, got %v", q, err)
+		}
+	}
+}
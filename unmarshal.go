@@ -0,0 +1,137 @@
+package dumbdb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var valueType = reflect.TypeOf(Value{})
+
+// Find struct field for the column, by `dumbdb:"name"` tag or
+// case-insensitively by field name. Fields tagged `dumbdb:"-"` are skipped
+func structField(t reflect.Type, column string) (reflect.StructField, bool) {
+	var match reflect.StructField
+	found := false
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		tag := field.Tag.Get("dumbdb")
+		if tag == "-" {
+			continue
+		}
+
+		if tag != "" {
+			if tag == column {
+				return field, true
+			}
+			continue
+		}
+
+		if !found && strings.EqualFold(field.Name, column) {
+			match = field
+			found = true
+		}
+	}
+	return match, found
+}
+
+func setField(dst reflect.Value, val *Value) error {
+	if dst.Type() == valueType {
+		dst.Set(reflect.ValueOf(*val))
+		return nil
+	}
+
+	if dst.Kind() == reflect.Interface && dst.NumMethod() == 0 {
+		dst.Set(reflect.ValueOf(val.Interface()))
+		return nil
+	}
+
+	switch val.TypeID {
+	case TypeInt, TypeBigint:
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n := val.Int
+			if dst.OverflowInt(n) {
+				return fmt.Errorf("value %v overflows %v", n, dst.Type())
+			}
+			dst.SetInt(n)
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if val.Int < 0 || dst.OverflowUint(uint64(val.Int)) {
+				return fmt.Errorf("value %v overflows %v", val.Int, dst.Type())
+			}
+			dst.SetUint(uint64(val.Int))
+			return nil
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(float64(val.Int))
+			return nil
+		}
+	case TypeFloat:
+		switch dst.Kind() {
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(val.Float)
+			return nil
+		}
+	case TypeBool:
+		if dst.Kind() == reflect.Bool {
+			dst.SetBool(val.Int != 0)
+			return nil
+		}
+	case TypeVarchar:
+		if dst.Kind() == reflect.String {
+			dst.SetString(val.StrVal())
+			return nil
+		}
+	}
+
+	return fmt.Errorf("can't convert %v to %v", val.TypeID, dst.Type())
+}
+
+// Copy values of the row into fields of the struct pointed to by dest
+// Columns are matched to fields by `dumbdb:"column"` tag, or by field name ignoring case.
+// Columns without matching field are ignored
+func Unmarshal(row Row, schema *Schema, dest interface{}) error {
+	ptr := reflect.ValueOf(dest)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Struct {
+		return errors.New("unmarshal destination should be a non-nil pointer to struct")
+	}
+
+	if len(row) != len(schema.Fields) {
+		return errors.New("number of values doesn't match number of columns")
+	}
+
+	v := ptr.Elem()
+	for i := range schema.Fields {
+		name := schema.Fields[i].Name
+		field, ok := structField(v.Type(), name)
+		if !ok {
+			continue
+		}
+
+		err := setField(v.FieldByIndex(field.Index), &row[i])
+		if err != nil {
+			return fmt.Errorf("column %v: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Copy current row into the struct, see Unmarshal()
+func (rows *Rows) ScanStruct(dest interface{}) error {
+	if rows.row == nil {
+		return errors.New("scan called without calling next")
+	}
+
+	if rows.schema == nil {
+		return errors.New("rows have no schema")
+	}
+
+	return Unmarshal(rows.row, rows.schema, dest)
+}
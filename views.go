@@ -0,0 +1,203 @@
+package dumbdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	ErrViewAlreadyExist = errors.New("view with such name already exist")
+	ErrNoSuchView       = errors.New("no view with such name")
+)
+
+// Views of the catalog by name, with their definitions (source of the select)
+const ViewsFilename string = "views.json"
+
+// Select stored in the catalog under a name. It isn't materialized, selects
+// from the view expand its definition, so they always see the current rows
+type View struct {
+	Definition string
+	query      *Select
+}
+
+func parseView(definition string) (*View, error) {
+	q, err := ParseQuery(definition)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Select == nil {
+		return nil, fmt.Errorf("view definition is not a select: %v", definition)
+	}
+
+	return &View{Definition: definition, query: q.Select}, nil
+}
+
+func (catalog *Catalog) loadViews() error {
+	data, err := ioutil.ReadFile(filepath.Join(catalog.dataDir, ViewsFilename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	definitions := make(map[string]string)
+	err = json.Unmarshal(data, &definitions)
+	if err != nil {
+		return err
+	}
+
+	for name, definition := range definitions {
+		view, err := parseView(definition)
+		if err != nil {
+			return fmt.Errorf("view %v: %w", name, err)
+		}
+		catalog.views[name] = view
+	}
+	return nil
+}
+
+// Encoded definitions of all the views
+func (catalog *Catalog) viewDefinitions() ([]byte, error) {
+	definitions := make(map[string]string)
+	for name, view := range catalog.views {
+		definitions[name] = view.Definition
+	}
+	return json.Marshal(definitions)
+}
+
+// catalog.m should be locked
+func (catalog *Catalog) saveViews() error {
+	data, err := catalog.viewDefinitions()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(catalog.dataDir, ViewsFilename), data)
+}
+
+// Error for statements working only with tables, if name refers to a view
+// catalog.m should be at least read-locked
+func (catalog *Catalog) tableError(name string, err error) error {
+	if _, ok := catalog.views[name]; ok {
+		return fmt.Errorf("%w: %v is a view", err, name)
+	}
+	return err
+}
+
+// Plan select of the view definition. views is the chain of views being
+// expanded, so that views referencing themselves are rejected
+// catalog.m should be at least read-locked
+func (catalog *Catalog) planView(name string, views []string) (*selectPlan, error) {
+	for _, expanded := range views {
+		if expanded == name {
+			return nil, fmt.Errorf("view %v references itself", name)
+		}
+	}
+
+	view, ok := catalog.views[name]
+	if !ok {
+		return nil, ErrNoSuchTable
+	}
+
+	plan, err := catalog.planSelectFrom(view.query, append(views, name))
+	if err != nil {
+		return nil, fmt.Errorf("view %v: %w", name, err)
+	}
+	return plan, nil
+}
+
+func (catalog *Catalog) doCreateView(create *CreateView) (*Result, error) {
+	if create.Definition == "" {
+		return nil, errors.New("view definition is missing")
+	}
+
+	err := validateName(create.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	if _, ok := catalog.tables[create.Name]; ok {
+		return nil, ErrTableAlreadyExist
+	}
+
+	if _, ok := catalog.views[create.Name]; ok {
+		return nil, ErrViewAlreadyExist
+	}
+
+	// definition is checked now, but tables it selects from can be dropped later,
+	// in which case selects from the view fail
+	_, err = catalog.planSelectFrom(create.Select, []string{create.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	catalog.views[create.Name] = &View{Definition: create.Definition, query: create.Select}
+	err = catalog.saveViews()
+	if err != nil {
+		delete(catalog.views, create.Name)
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (catalog *Catalog) doDropView(drop *DropView) (*Result, error) {
+	catalog.m.Lock()
+	defer catalog.m.Unlock()
+
+	view, ok := catalog.views[drop.Name]
+	if !ok {
+		if _, ok := catalog.tables[drop.Name]; ok {
+			return nil, fmt.Errorf("%w: %v is a table", ErrNoSuchView, drop.Name)
+		}
+		return nil, ErrNoSuchView
+	}
+
+	delete(catalog.views, drop.Name)
+	err := catalog.saveViews()
+	if err != nil {
+		catalog.views[drop.Name] = view
+		return nil, err
+	}
+
+	return nil, catalog.dropGrants(drop.Name)
+}
+
+func (catalog *Catalog) doShowViews() (*Result, error) {
+	catalog.m.RLock()
+	defer catalog.m.RUnlock()
+
+	names := make([]string, 0, len(catalog.views))
+	for name := range catalog.views {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var schema Schema
+	schema.addField(Field{Name: "view", TypeID: TypeVarchar, Len: 255})
+	schema.addField(Field{Name: "definition", TypeID: TypeVarchar, Len: 255})
+
+	rows := make([]Row, 0, len(names))
+	for _, name := range names {
+		rows = append(rows, Row{
+			{TypeID: TypeVarchar, Str: name},
+			{TypeID: TypeVarchar, Str: catalog.views[name].Definition},
+		})
+	}
+
+	return &Result{
+		Schema: schema,
+		Rows:   StaticRows(rows),
+	}, nil
+}
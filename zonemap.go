@@ -0,0 +1,220 @@
+package dumbdb
+
+import (
+	"math"
+	"sync"
+)
+
+// Least and greatest values of a column on a page, min > max if the page is empty
+type zone struct {
+	min, max int64
+}
+
+func emptyZone() zone {
+	return zone{min: math.MaxInt64, max: math.MinInt64}
+}
+
+func (z *zone) add(v int64) {
+	if v < z.min {
+		z.min = v
+	}
+	if v > z.max {
+		z.max = v
+	}
+}
+
+// Zone map of a table, i.e. min and max values of the integer columns on each
+// page, so that scans skip pages where no row can match the range of the WHERE
+// clause.
+//
+// Same as bloom filters, zones are kept in memory: zones of a page are computed
+// when the page is scanned for the first time, or when rows are inserted into the
+// new page, and extended by inserts afterwards. Zones aren't narrowed when rows
+// are removed from the page. Methods can be called on nil ZoneMap, which is used
+// for tables without integer columns
+type ZoneMap struct {
+	columns []int   // indexes of the integer columns in the schema
+	fields  []Field // the columns
+	offsets []int   // offsets of the columns in the encoded row
+
+	m sync.Mutex
+	// indexed by PageID, then by column. nil for pages which weren't scanned yet
+	zones [][]zone
+}
+
+// Zone map of the integer columns of the schema, nil if there are none
+func NewZoneMap(schema *Schema) *ZoneMap {
+	var zm ZoneMap
+	for idx, field := range schema.Fields {
+		if field.TypeID.IsInteger() {
+			zm.columns = append(zm.columns, idx)
+			zm.fields = append(zm.fields, field)
+			zm.offsets = append(zm.offsets, schema.Offset(idx))
+		}
+	}
+
+	if len(zm.columns) == 0 {
+		return nil
+	}
+	return &zm
+}
+
+func (zm *ZoneMap) addRow(zones []zone, data []byte) {
+	for i := range zones {
+		zones[i].add(zm.fields[i].Read(data[zm.offsets[i]:]).Int)
+	}
+}
+
+// Compute zones of the page unless they are computed already, the page should be at least read-locked
+func (zm *ZoneMap) scanned(id PageID, page rowSet, schema *Schema) {
+	if zm == nil {
+		return
+	}
+
+	zm.m.Lock()
+	defer zm.m.Unlock()
+
+	if int(id) < len(zm.zones) && zm.zones[id] != nil {
+		return
+	}
+
+	zones := make([]zone, len(zm.columns))
+	for i := range zones {
+		zones[i] = emptyZone()
+	}
+
+	for i := 0; i < page.NumRows(); i++ {
+		zm.addRow(zones, page.RowData(i, schema))
+	}
+
+	for len(zm.zones) <= int(id) {
+		zm.zones = append(zm.zones, nil)
+	}
+	zm.zones[id] = zones
+}
+
+// Add rows inserted into the page starting at index first, zones of pages which weren't
+// scanned are computed only if the page was empty. The page should be locked
+func (zm *ZoneMap) inserted(id PageID, page rowSet, first int, schema *Schema) {
+	if zm == nil {
+		return
+	}
+
+	if first == 0 {
+		zm.scanned(id, page, schema)
+		return
+	}
+
+	zm.m.Lock()
+	defer zm.m.Unlock()
+
+	if int(id) >= len(zm.zones) || zm.zones[id] == nil {
+		return
+	}
+
+	for i := first; i < page.NumRows(); i++ {
+		zm.addRow(zm.zones[id], page.RowData(i, schema))
+	}
+}
+
+// Forget pages with ids >= n, e.g. after the table is truncated
+func (zm *ZoneMap) Truncate(n int) {
+	if zm == nil {
+		return
+	}
+
+	zm.m.Lock()
+	defer zm.m.Unlock()
+
+	if n < len(zm.zones) {
+		zm.zones = zm.zones[:n]
+	}
+}
+
+// Range predicate checked against the zones of a column
+type zonePredicate struct {
+	predicate ColumnPredicate
+	column    int // index in zm.columns
+}
+
+// Predicates which can be checked against the zones, i.e. comparisons
+// and lists of the integer columns
+func (zm *ZoneMap) predicates(predicates []ColumnPredicate) []zonePredicate {
+	if zm == nil {
+		return nil
+	}
+
+	var zps []zonePredicate
+	for _, p := range predicates {
+		for i := range zm.fields {
+			if zm.fields[i].Name == p.Field.Name {
+				zps = append(zps, zonePredicate{predicate: p, column: i})
+			}
+		}
+	}
+	return zps
+}
+
+// Returns true if no value within the zone can match the predicate. Values are
+// compared as ColumnPredicate.Match() does, the comparison is monotonic, so
+// values within the zone compare between its min and max
+func (zp *zonePredicate) excludes(field *Field, z zone) bool {
+	if z.min > z.max {
+		return true
+	}
+
+	var low, high [8]byte
+	field.Write(low[:], IntegerValue(z.min))
+	field.Write(high[:], IntegerValue(z.max))
+
+	outside := func(val *Value) bool {
+		return field.Compare(low[:], val) > 0 || field.Compare(high[:], val) < 0
+	}
+
+	p := &zp.predicate
+	switch p.Op {
+	case OpIn:
+		for i := range p.Values {
+			if !outside(&p.Values[i]) {
+				return false
+			}
+		}
+		return true
+	case OpEq:
+		return outside(&p.Value)
+	case OpNotEq:
+		return field.Compare(low[:], &p.Value) == 0 && field.Compare(high[:], &p.Value) == 0
+	case OpLess:
+		return field.Compare(low[:], &p.Value) >= 0
+	case OpLessOrEq:
+		return field.Compare(low[:], &p.Value) > 0
+	case OpGreater:
+		return field.Compare(high[:], &p.Value) <= 0
+	case OpGreaterOrEq:
+		return field.Compare(high[:], &p.Value) < 0
+	}
+	return false
+}
+
+// Returns true if the page definitely has no rows matching all the predicates
+func (zm *ZoneMap) Excludes(id PageID, predicates []zonePredicate) bool {
+	if zm == nil || len(predicates) == 0 {
+		return false
+	}
+
+	zm.m.Lock()
+	defer zm.m.Unlock()
+
+	if int(id) >= len(zm.zones) || zm.zones[id] == nil {
+		return false
+	}
+
+	zones := zm.zones[id]
+	for i := range predicates {
+		zp := &predicates[i]
+		if zp.excludes(&zm.fields[zp.column], zones[zp.column]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package dumbdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestZoneMap(t *testing.T) {
+	schema := NewSchema([]FieldDescription{
+		{Name: "id", Type: &Type{Integer: true}},
+		{Name: "name", Type: &Type{Varchar: 20}},
+		{Name: "n", Type: &Type{Bigint: true}},
+	})
+
+	if NewZoneMap(&schema) == nil {
+		t.Fatal("Expected zone map of the integer columns")
+	}
+
+	noInts := NewSchema([]FieldDescription{{Name: "name", Type: &Type{Varchar: 20}}})
+	if NewZoneMap(&noInts) != nil {
+		t.Fatal("Expected no zone map without integer columns")
+	}
+
+	table, err := NewTable("", schema, TableOptions{Engine: EngineMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	rows := make([]Row, 0, 5000)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, Row{IntValue(int32(i)), VarcharValue(fmt.Sprintf("name %v", i)), BigintValue(-int64(i) << 32)})
+	}
+
+	err = table.Insert(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pages []PageID
+	for id := table.pager.FirstPage(); id != InvalidPageID; id = table.pager.NextPage(id) {
+		pages = append(pages, id)
+	}
+
+	perPage := table.rowsPerPage()
+	id, n := schema.Fields[0], schema.Fields[2]
+	tests := []struct {
+		predicates []ColumnPredicate
+		match      func(i int) bool
+	}{
+		{[]ColumnPredicate{{Field: id, Op: OpEq, Value: IntValue(1234)}}, func(i int) bool { return i == 1234 }},
+		{[]ColumnPredicate{{Field: id, Op: OpNotEq, Value: IntValue(1234)}}, func(i int) bool { return i != 1234 }},
+		{[]ColumnPredicate{{Field: id, Op: OpLess, Value: IntValue(int32(perPage))}}, func(i int) bool { return i < perPage }},
+		{[]ColumnPredicate{{Field: id, Op: OpLessOrEq, Value: IntValue(int32(perPage))}}, func(i int) bool { return i <= perPage }},
+		{[]ColumnPredicate{{Field: id, Op: OpGreater, Value: IntValue(4000)}}, func(i int) bool { return i > 4000 }},
+		{[]ColumnPredicate{{Field: id, Op: OpGreaterOrEq, Value: FloatValue(3999.5)}}, func(i int) bool { return i >= 4000 }},
+		{[]ColumnPredicate{{Field: id, Op: OpEq, Value: FloatValue(-0.5)}}, func(i int) bool { return false }},
+		{[]ColumnPredicate{{Field: id, Op: OpEq, Value: FloatValue(math.NaN())}}, func(i int) bool { return true }},
+		{[]ColumnPredicate{{Field: id, Op: OpIn, Values: []Value{IntValue(-1), IntValue(3000), IntValue(10)}}}, func(i int) bool {
+			return i == 3000 || i == 10
+		}},
+		{[]ColumnPredicate{{Field: n, Op: OpLess, Value: BigintValue(-4990 << 32)}}, func(i int) bool { return i > 4990 }},
+		{[]ColumnPredicate{
+			{Field: id, Op: OpGreater, Value: IntValue(1000)},
+			{Field: n, Op: OpGreaterOrEq, Value: BigintValue(-1200 << 32)},
+		}, func(i int) bool { return i > 1000 && i <= 1200 }},
+	}
+
+	for _, test := range tests {
+		zones := table.zones.predicates(test.predicates)
+		if len(zones) != len(test.predicates) {
+			t.Fatalf("%v: expected %v predicates, got %v", test.predicates, len(test.predicates), len(zones))
+		}
+
+		// pages were filled by inserts, so their zones are computed already.
+		// Zones are exact, so pages without matching rows are excluded
+		for page, id := range pages {
+			match := false
+			for i := page * perPage; i < (page+1)*perPage && i < len(rows); i++ {
+				match = match || test.match(i)
+			}
+
+			if table.zones.Excludes(id, zones) == match {
+				t.Fatalf("%v: page %v (matching rows: %v) is excluded wrongly", test.predicates, id, match)
+			}
+		}
+	}
+
+	// predicates of other columns
+	if zones := table.zones.predicates([]ColumnPredicate{{Field: schema.Fields[1], Op: OpEq, Value: VarcharValue("name 1")}}); len(zones) != 0 {
+		t.Fatalf("Expected no predicates, got %v", len(zones))
+	}
+
+	// zones of truncated pages are dropped
+	err = table.Truncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zones := table.zones.predicates(tests[0].predicates)
+	if table.zones.Excludes(pages[0], zones) {
+		t.Fatal("Expected no zones of the truncated page")
+	}
+}
+
+func TestZoneMapScan(t *testing.T) {
+	dir := t.TempDir()
+	catalog, err := OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		catalog.Close()
+	}()
+
+	query := func(q string) []string {
+		return columnStrings(mustQueryCatalog(t, catalog, q), 0)
+	}
+
+	query("create table t (id int, ts bigint)")
+
+	values := make([]string, 0, 3000)
+	for i := 0; i < cap(values); i++ {
+		values = append(values, fmt.Sprintf("(%v, %v)", i, 1600000000000+i*1000))
+	}
+	query("insert into t values " + strings.Join(values, ", "))
+
+	check := func() {
+		ids := query("select id from t where ts >= 1600002990000")
+		if len(ids) != 10 || ids[0] != "2990" {
+			t.Fatalf("Unexpected rows %v", ids)
+		}
+
+		ids = query("select id from t where id between 100 and 102 or id = 5")
+		if len(ids) != 4 {
+			t.Fatalf("Unexpected rows %v", ids)
+		}
+
+		plan := query("explain select id from t where id > 10")
+		if len(plan) < 3 || strings.TrimSpace(plan[2]) != "zone map: id > 10" {
+			t.Fatalf("Expected zone map in the plan, got %v", plan)
+		}
+
+		table, err := catalog.Table("t")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		predicates := []ColumnPredicate{
+			{Offset: table.schema.Offset(0), Field: table.schema.Fields[0], Op: OpLess, Value: IntValue(10)},
+		}
+
+		// skipped pages are reported as scanned
+		progress := &ScanProgress{}
+		rows := FullScan(context.Background(), table, predicates, nil, func(Row) (bool, error) { return true, nil }, func(r Row) Row { return r }, progress)
+		all, err := rows.All()
+		if err != nil || len(all) != 10 {
+			t.Fatalf("Expected 10 rows, got %v (%v)", len(all), err)
+		}
+
+		p := progress.Get()
+		if p.PagesScanned != p.PagesTotal || p.PagesTotal < 2 {
+			t.Fatalf("Unexpected progress %+v", p)
+		}
+
+		zones := table.zones.predicates(predicates)
+		excluded := 0
+		for id := table.pager.FirstPage(); id != InvalidPageID; id = table.pager.NextPage(id) {
+			if table.zones.Excludes(id, zones) {
+				excluded++
+			}
+		}
+
+		if excluded != int(p.PagesTotal)-1 {
+			t.Fatalf("Expected %v pages to be excluded, got %v", p.PagesTotal-1, excluded)
+		}
+	}
+
+	check()
+
+	// zones are computed by the first scan after reopening
+	catalog.Close()
+	catalog, err = OpenCatalog(dir, StorageFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query("select id from t where id < 0")
+	check()
+
+	// zones are extended by inserts into partially filled pages
+	query("insert into t values (5000, 0)")
+	if ids := query("select id from t where id > 4000"); len(ids) != 1 || ids[0] != "5000" {
+		t.Fatalf("Unexpected rows %v", ids)
+	}
+}